@@ -0,0 +1,291 @@
+package onet
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// how long drain and shutdown wait for the protocols if no timeout is given
+const adminDefaultTimeout = 30 * time.Second
+
+// KeyRotator can be implemented by a service that holds keys or secrets of
+// its own that the operators want to replace regularly, see
+// Server.RotateKeys.
+type KeyRotator interface {
+	RotateKeys() error
+}
+
+// adminAuth holds the tokens of the operators of a server.
+type adminAuth struct {
+	// from token to name
+	tokens map[string]string
+	// reloads the configuration of the services, set by the app
+	reload func() ([]string, error)
+	sync.Mutex
+}
+
+// AddAdminToken lets the operators presenting the bearer token use the
+// admin API on /admin/ of the websocket under the given name. The admin
+// tokens are separate from the ones of the clients. Without any token, the
+// admin API refuses all requests.
+func (c *Server) AddAdminToken(name, token string) error {
+	if token == "" {
+		return xerrors.New("empty token")
+	}
+	c.adminAuth.Lock()
+	defer c.adminAuth.Unlock()
+	if c.adminAuth.tokens == nil {
+		c.adminAuth.tokens = make(map[string]string)
+	}
+	c.adminAuth.tokens[token] = name
+	return nil
+}
+
+// RemoveAdminToken revokes the admin token.
+func (c *Server) RemoveAdminToken(token string) {
+	c.adminAuth.Lock()
+	defer c.adminAuth.Unlock()
+	delete(c.adminAuth.tokens, token)
+}
+
+func (c *Server) verifyAdminToken(token string) (string, bool) {
+	c.adminAuth.Lock()
+	defer c.adminAuth.Unlock()
+	for t, name := range c.adminAuth.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// SetConfigReloader sets how the admin API reloads the configuration, like
+// with ReloadConfig and the configuration file the server was started
+// with. f returns the names of the sections that have been applied.
+func (c *Server) SetConfigReloader(f func() ([]string, error)) {
+	c.adminAuth.Lock()
+	defer c.adminAuth.Unlock()
+	c.adminAuth.reload = f
+}
+
+// RotateKeys calls RotateKeys on the services implementing KeyRotator, and
+// returns the errors by name of service.
+func (c *Server) RotateKeys() map[string]error {
+	c.serviceManager.servicesMutex.Lock()
+	rotators := make(map[string]KeyRotator)
+	for id, s := range c.serviceManager.services {
+		if kr, ok := s.(KeyRotator); ok {
+			rotators[ServiceFactory.Name(id)] = kr
+		}
+	}
+	c.serviceManager.servicesMutex.Unlock()
+
+	results := make(map[string]error)
+	for name, kr := range rotators {
+		results[name] = kr.RotateKeys()
+	}
+	return results
+}
+
+// KillProtocol stops the protocol instance with the token, as given in
+// ProtocolStatus, without waiting for it to be done. The Shutdown of the
+// protocol is called, and KillProtocol waits at most adminDefaultTimeout
+// for its Dispatch to return.
+func (c *Server) KillProtocol(token string) error {
+	o := c.overlay
+	o.instancesLock.Lock()
+	var tni *TreeNodeInstance
+	for id, n := range o.instances {
+		if id.String() == token {
+			tni = n
+			break
+		}
+	}
+	if tni == nil {
+		o.instancesLock.Unlock()
+		return xerrors.New("no such protocol instance: " + token)
+	}
+	log.Lvl1("Killing protocol", tni.ProtocolName(), token)
+	// the protocol is already shut down by nodeDelete
+	o.nodeDelete(tni.token)
+	o.instancesLock.Unlock()
+
+	// the overlay doesn't know when the protocols that the services run
+	// themselves are done
+	done := tni.dispatched()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-c.Clock().After(adminDefaultTimeout):
+		return xerrors.Errorf("protocol instance %s didn't stop in %v", token, adminDefaultTimeout)
+	}
+}
+
+// CompactStorage shrinks the database of the server, if its storage
+// implements Compacter.
+func (c *Server) CompactStorage() error {
+	cp, ok := c.serviceManager.storage.(Compacter)
+	if !ok {
+		return xerrors.New("storage can't be compacted")
+	}
+	if err := cp.Compact(); err != nil {
+		return xerrors.Errorf("compacting: %v", err)
+	}
+	return nil
+}
+
+// the request of the admin operations taking arguments
+type adminRequest struct {
+	Level   int    `json:"level"`
+	Token   string `json:"token"`
+	Timeout string `json:"timeout"`
+}
+
+func (req *adminRequest) timeout() (time.Duration, error) {
+	if req.Timeout == "" {
+		return adminDefaultTimeout, nil
+	}
+	return time.ParseDuration(req.Timeout)
+}
+
+// errorMap returns the errors as strings, "ok" for nil.
+func errorMap(errs map[string]error) map[string]string {
+	ret := make(map[string]string)
+	for name, err := range errs {
+		if err != nil {
+			ret[name] = err.Error()
+		} else {
+			ret[name] = "ok"
+		}
+	}
+	return ret
+}
+
+// serveAdmin is the admin API of the operators, on /admin/<operation> with
+// an admin token. The replies are in JSON, and the operations are:
+//   - GET protocols: the running protocol instances
+//   - POST kill-protocol {"token": ...}: stops a protocol instance
+//   - GET log-level, POST log-level {"level": ...}: the debug level
+//   - POST reload-config: reloads the configuration of the services
+//   - POST rotate-keys: calls the services implementing KeyRotator
+//   - POST compact: shrinks the database
+//   - POST drain {"timeout": "30s"}: stops taking new requests
+//   - POST shutdown {"timeout": "30s"}: drains, then closes the server
+//
+// All the operations are added to the audit log.
+func (c *Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	auth := r.Header.Get("Authorization")
+	name, ok := c.verifyAdminToken(strings.TrimPrefix(auth, "Bearer "))
+	if !strings.HasPrefix(auth, "Bearer ") || !ok {
+		http.Error(w, wrapJSONMsg("admin token required"), http.StatusUnauthorized)
+		return
+	}
+	op := strings.TrimPrefix(r.URL.Path, "/admin/")
+	get := r.Method == http.MethodGet
+	if !get && r.Method != http.MethodPost {
+		http.Error(w, wrapJSONMsg("only GET and POST are supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	req := &adminRequest{}
+	if r.Method == http.MethodPost {
+		buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, gatewayMaxRequest))
+		if err == nil && len(buf) > 0 {
+			err = json.Unmarshal(buf, req)
+		}
+		if err != nil {
+			http.Error(w, wrapJSONMsg("decoding error "+err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	if !get {
+		c.audit.add(AuditEvent{
+			Time:    time.Now(),
+			Service: "admin",
+			Handler: op,
+			Client:  name,
+			Event:   "admin",
+		})
+	}
+
+	var reply interface{}
+	status := http.StatusOK
+	var err error
+	switch {
+	case op == "protocols" && get:
+		reply = c.overlay.protocolStatus()
+	case op == "kill-protocol" && !get:
+		err = c.KillProtocol(req.Token)
+		reply = map[string]string{"killed": req.Token}
+	case op == "log-level" && get:
+		reply = map[string]int{"level": log.DebugVisible()}
+	case op == "log-level":
+		log.Lvl1("Debug level set to", req.Level, "by", name)
+		log.SetDebugVisible(req.Level)
+		reply = map[string]int{"level": log.DebugVisible()}
+	case op == "reload-config" && !get:
+		c.adminAuth.Lock()
+		reload := c.adminAuth.reload
+		c.adminAuth.Unlock()
+		if reload == nil {
+			err = xerrors.New("the server can't reload its configuration")
+			break
+		}
+		var updated []string
+		updated, err = reload()
+		reply = map[string][]string{"sections": updated}
+	case op == "rotate-keys" && !get:
+		results := c.RotateKeys()
+		reply = map[string]map[string]string{"services": errorMap(results)}
+		for _, e := range results {
+			if e != nil {
+				status = http.StatusInternalServerError
+			}
+		}
+	case op == "compact" && !get:
+		err = c.CompactStorage()
+		reply = map[string]string{"compacted": "ok"}
+	case (op == "drain" || op == "shutdown") && !get:
+		var timeout time.Duration
+		timeout, err = req.timeout()
+		if err != nil {
+			http.Error(w, wrapJSONMsg("wrong timeout "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		if op == "drain" {
+			go c.drain(timeout)
+		} else {
+			go func() {
+				if err := c.Shutdown(timeout); err != nil {
+					log.Error("Shutdown:", err)
+				}
+			}()
+		}
+		status = http.StatusAccepted
+		reply = map[string]string{op: timeout.String()}
+	default:
+		ops := []string{"compact", "drain", "kill-protocol", "log-level",
+			"protocols", "reload-config", "rotate-keys", "shutdown"}
+		http.Error(w, wrapJSONMsg("unknown operation, use one of "+strings.Join(ops, ", ")),
+			http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,189 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+)
+
+const adminServiceName = "adminService"
+
+type adminService struct {
+	*ServiceProcessor
+	rotated *int32
+}
+
+func (s *adminService) RotateKeys() error {
+	atomic.AddInt32(s.rotated, 1)
+	return nil
+}
+
+func TestServer_Admin(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	var rotations int32
+	RegisterNewService(adminServiceName, func(c *Context) (Service, error) {
+		return &adminService{ServiceProcessor: NewServiceProcessor(c),
+			rotated: &rotations}, nil
+	})
+	defer UnregisterService(adminServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(2, true)
+	h := servers[0]
+	require.NoError(t, h.AddAdminToken("alice", "admin-secret"))
+	require.NoError(t, h.AddClientToken("bob", "client-secret"))
+	h.SetConfigReloader(func() ([]string, error) {
+		return []string{"section"}, nil
+	})
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	addr := "http://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/admin/"
+	call := func(method, op, token, body string, reply interface{}) int {
+		req, err := http.NewRequest(method, addr+op, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if reply != nil && resp.StatusCode < 300 {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(reply))
+		}
+		return resp.StatusCode
+	}
+
+	// the tokens of the clients can't be used
+	require.Equal(t, http.StatusUnauthorized, call("GET", "log-level", "", "", nil))
+	require.Equal(t, http.StatusUnauthorized, call("GET", "log-level", "client-secret", "", nil))
+	require.Equal(t, http.StatusNotFound, call("POST", "unknown", "admin-secret", "", nil))
+
+	lvl := log.DebugVisible()
+	defer log.SetDebugVisible(lvl)
+	level := map[string]int{}
+	require.Equal(t, http.StatusOK, call("POST", "log-level", "admin-secret", `{"level": 4}`, &level))
+	require.Equal(t, 4, level["level"])
+	require.Equal(t, 4, log.DebugVisible())
+	log.SetDebugVisible(lvl)
+
+	sections := map[string][]string{}
+	require.Equal(t, http.StatusOK, call("POST", "reload-config", "admin-secret", "", &sections))
+	require.Equal(t, []string{"section"}, sections["sections"])
+
+	rotated := map[string]map[string]string{}
+	require.Equal(t, http.StatusOK, call("POST", "rotate-keys", "admin-secret", "", &rotated))
+	require.Equal(t, "ok", rotated["services"][adminServiceName])
+	require.Equal(t, int32(1), atomic.LoadInt32(&rotations))
+
+	p, err := local.CreateProtocol(ProtocolChannelsName, tree)
+	require.NoError(t, err)
+	var protocols []ProtocolStatus
+	require.Equal(t, http.StatusOK, call("GET", "protocols", "admin-secret", "", &protocols))
+	require.Equal(t, 1, len(protocols))
+	require.Equal(t, p.(*ProtocolChannels).Token().ID().String(), protocols[0].Token)
+	require.Equal(t, http.StatusInternalServerError,
+		call("POST", "kill-protocol", "admin-secret", `{"token": "unknown"}`, nil))
+	require.Equal(t, http.StatusOK,
+		call("POST", "kill-protocol", "admin-secret", `{"token": "`+protocols[0].Token+`"}`, nil))
+	require.Equal(t, http.StatusOK, call("GET", "protocols", "admin-secret", "", &protocols))
+	require.Equal(t, 0, len(protocols))
+
+	if _, ok := h.serviceManager.storage.(Compacter); ok {
+		require.Equal(t, http.StatusOK, call("POST", "compact", "admin-secret", "", nil))
+		require.NoError(t, h.serviceManager.storage.View(func(tx StorageTx) error {
+			require.NotEmpty(t, tx.Buckets())
+			return nil
+		}))
+	}
+
+	require.Equal(t, http.StatusBadRequest,
+		call("POST", "drain", "admin-secret", `{"timeout": "soon"}`, nil))
+	require.Equal(t, http.StatusAccepted,
+		call("POST", "drain", "admin-secret", `{"timeout": "1s"}`, nil))
+	for i := 0; i < 20 && !h.Draining(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.True(t, h.Draining())
+
+	events := h.AuditLog()
+	require.NotEmpty(t, events)
+	require.Equal(t, "alice", events[len(events)-1].Client)
+	require.Equal(t, "drain", events[len(events)-1].Handler)
+}
+
+const killableProtocolName = "killableProtocol"
+
+// killableProtocol dispatches until it is shut down, unless it is stuck.
+type killableProtocol struct {
+	*TreeNodeInstance
+	stop    chan struct{}
+	stuck   chan struct{}
+	stopped *int32
+}
+
+func (p *killableProtocol) Start() error {
+	return nil
+}
+
+func (p *killableProtocol) Dispatch() error {
+	<-p.stuck
+	<-p.stop
+	atomic.AddInt32(p.stopped, 1)
+	return nil
+}
+
+func (p *killableProtocol) Shutdown() error {
+	close(p.stop)
+	return nil
+}
+
+func TestServer_KillProtocol(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(1, true)
+	h := servers[0]
+	var stopped int32
+	stuck := make(chan struct{})
+	_, err := h.ProtocolRegister(killableProtocolName, func(n *TreeNodeInstance) (ProtocolInstance, error) {
+		return &killableProtocol{TreeNodeInstance: n, stop: make(chan struct{}),
+			stuck: stuck, stopped: &stopped}, nil
+	})
+	require.NoError(t, err)
+	clock := NewVirtualClock(time.Now())
+	h.SetClock(clock)
+
+	// the protocol that doesn't stop isn't waited for forever
+	p, err := local.CreateProtocol(killableProtocolName, tree)
+	require.NoError(t, err)
+	errs := make(chan error)
+	go func() {
+		errs <- h.KillProtocol(p.Token().ID().String())
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	clock.Advance(adminDefaultTimeout)
+	require.Error(t, <-errs)
+	require.Empty(t, h.overlay.instances)
+	close(stuck)
+	for atomic.LoadInt32(&stopped) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// KillProtocol returns when the Dispatch of the protocol has returned
+	p, err = local.CreateProtocol(killableProtocolName, tree)
+	require.NoError(t, err)
+	require.NoError(t, h.KillProtocol(p.Token().ID().String()))
+	require.Equal(t, int32(2), atomic.LoadInt32(&stopped))
+	require.Error(t, h.KillProtocol(p.Token().ID().String()))
+}
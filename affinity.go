@@ -0,0 +1,34 @@
+package onet
+
+import (
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+// SetCPUAffinity pins the goroutines giving the messages to the protocol
+// instances to the CPUs, so that the servers running in the same process,
+// like the hosts of a simulation, don't slow each other down with their
+// crypto. Each of these goroutines then has a thread of its own, which is
+// best kept in check with SetGoroutineBudget. The Dispatch and Start methods
+// of the instances are not pinned. Nil unpins the goroutines started
+// afterwards. It is only supported on Linux.
+func (c *Server) SetCPUAffinity(cpus []int) error {
+	if len(cpus) > 0 {
+		if !affinitySupported {
+			return pinThread(cpus)
+		}
+		// the thread of a goroutine exiting locked is thrown away, so
+		// that no other goroutine runs pinned
+		errs := make(chan error)
+		go func() {
+			runtime.LockOSThread()
+			errs <- pinThread(cpus)
+		}()
+		if err := <-errs; err != nil {
+			return xerrors.Errorf("pinning to %v: %v", cpus, err)
+		}
+	}
+	c.overlay.budget.setCPUs(cpus)
+	return nil
+}
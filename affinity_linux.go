@@ -0,0 +1,15 @@
+package onet
+
+import "golang.org/x/sys/unix"
+
+const affinitySupported = true
+
+// pinThread pins the thread of the goroutine, which must be locked to it, to
+// the CPUs.
+func pinThread(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package onet
+
+import "golang.org/x/xerrors"
+
+const affinitySupported = false
+
+// pinThread is only implemented on Linux.
+func pinThread(cpus []int) error {
+	return xerrors.New("CPU affinity is only supported on Linux")
+}
@@ -0,0 +1,31 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SetCPUAffinity(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	servers, _, tree := local.GenTree(2, true)
+	if !affinitySupported {
+		require.Error(t, servers[0].SetCPUAffinity([]int{0}))
+		return
+	}
+	require.Error(t, servers[0].SetCPUAffinity([]int{-1}))
+	for _, s := range servers {
+		require.NoError(t, s.SetCPUAffinity([]int{0}))
+	}
+	pi, err := local.StartProtocol(pingPongProtoName, tree)
+	require.NoError(t, err)
+	<-pi.(*pingPongProto).done
+	st := servers[0].statusReporterStruct.ReportStatus()["Goroutines"]
+	require.Equal(t, "[0]", st.Field["CPUs"])
+
+	require.NoError(t, servers[0].SetCPUAffinity(nil))
+	st = servers[0].statusReporterStruct.ReportStatus()["Goroutines"]
+	require.Equal(t, "[]", st.Field["CPUs"])
+}
@@ -0,0 +1,215 @@
+package onet
+
+import (
+	"sort"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// how long the sender of an AnycastMsg waits for the acknowledgement before
+// trying the next member
+const defaultAnycastTimeout = 2 * time.Second
+
+// how long a member that failed to acknowledge is tried last
+const anycastFailureMemory = time.Minute
+
+// AnycastMsgID of AnycastMsg message as registered in network
+var AnycastMsgID = network.RegisterMessage(AnycastMsg{})
+
+// AnycastAckMsgID of AnycastAck message as registered in network
+var AnycastAckMsgID = network.RegisterMessage(AnycastAck{})
+
+// AnycastMsg carries a message that must be handled by at least one member
+// of a set. The receiver delivers the payload to its processors and
+// acknowledges it.
+type AnycastMsg struct {
+	ID uuid.UUID
+	// Payload is the marshaled message, including its type.
+	Payload []byte
+}
+
+// AnycastAck is sent back to the sender of an AnycastMsg once the payload
+// has been delivered.
+type AnycastAck struct {
+	ID uuid.UUID
+}
+
+// peerLatency is what we know about the round-trip time to a peer.
+type peerLatency struct {
+	rtt      time.Duration
+	failedAt time.Time
+}
+
+// Anycast delivers msg to one live member of candidates. Members are tried
+// by increasing measured round-trip time, members we haven't measured yet
+// come next and members that recently failed come last. If a member doesn't
+// acknowledge the message in time, the next one is tried. It returns the
+// member that acknowledged the message.
+//
+// A member that is only slow, or whose acknowledgement is lost, still
+// handles the message, so several members can handle it: the processors
+// must accept duplicates.
+//
+// If we are one of the candidates, the message is handled locally.
+func (o *Overlay) Anycast(candidates []*network.ServerIdentity, msg network.Message) (*network.ServerIdentity, error) {
+	if len(candidates) == 0 {
+		return nil, xerrors.New("no candidates given")
+	}
+	own := o.ServerIdentity()
+	for _, si := range candidates {
+		if si.ID.Equal(own.ID) {
+			if _, err := o.server.Send(own, msg); err != nil {
+				return nil, xerrors.Errorf("dispatching locally: %v", err)
+			}
+			return own, nil
+		}
+	}
+
+	payload, err := network.Marshal(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling: %v", err)
+	}
+	for _, si := range o.sortByLatency(candidates) {
+		err := o.anycastTo(si, payload)
+		if err == nil {
+			return si, nil
+		}
+		log.Lvl2(own, "anycast to", si, "failed:", err)
+	}
+	return nil, xerrors.New("no candidate acknowledged the message")
+}
+
+// PeerLatency returns the last measured round-trip time to the peer, if
+// any. It is measured by Anycast.
+func (o *Overlay) PeerLatency(id network.ServerIdentityID) (time.Duration, bool) {
+	o.latenciesLock.Lock()
+	defer o.latenciesLock.Unlock()
+	l, ok := o.latencies[id]
+	if !ok || l.rtt == 0 {
+		return 0, false
+	}
+	return l.rtt, true
+}
+
+// sortByLatency returns a copy of the list in the order Anycast tries them.
+func (o *Overlay) sortByLatency(list []*network.ServerIdentity) []*network.ServerIdentity {
+	o.latenciesLock.Lock()
+	defer o.latenciesLock.Unlock()
+	// rank 0: measured, 1: unknown, 2: recently failed
+	rank := func(si *network.ServerIdentity) (int, time.Duration) {
+		l, ok := o.latencies[si.ID]
+		switch {
+		case !ok:
+			return 1, 0
+		case time.Since(l.failedAt) < anycastFailureMemory:
+			return 2, l.rtt
+		case l.rtt == 0:
+			return 1, 0
+		}
+		return 0, l.rtt
+	}
+	sorted := append([]*network.ServerIdentity{}, list...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, li := rank(sorted[i])
+		rj, lj := rank(sorted[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return li < lj
+	})
+	return sorted
+}
+
+// anycastTo sends the payload to the peer and waits for its acknowledgement,
+// updating the latency measured for this peer.
+func (o *Overlay) anycastTo(si *network.ServerIdentity, payload []byte) error {
+	am := &AnycastMsg{ID: uuid.NewV4(), Payload: payload}
+	acked := make(chan struct{})
+	o.latenciesLock.Lock()
+	o.anycastAcks[am.ID] = acked
+	o.latenciesLock.Unlock()
+	defer func() {
+		o.latenciesLock.Lock()
+		delete(o.anycastAcks, am.ID)
+		o.latenciesLock.Unlock()
+	}()
+
+	start := time.Now()
+	_, err := o.server.Send(si, am)
+	if err == nil {
+		select {
+		case <-acked:
+		case <-o.server.Clock().After(o.anycastTimeout):
+			err = xerrors.New("no acknowledgement before timeout")
+		}
+	}
+
+	o.latenciesLock.Lock()
+	defer o.latenciesLock.Unlock()
+	l := o.latencies[si.ID]
+	if l == nil {
+		l = &peerLatency{}
+		o.latencies[si.ID] = l
+	}
+	if err != nil {
+		l.failedAt = time.Now()
+		return xerrors.Errorf("sending: %v", err)
+	}
+	// moving average so a single slow answer doesn't reorder the peers
+	rtt := time.Since(start)
+	if l.rtt == 0 {
+		l.rtt = rtt
+	} else {
+		l.rtt = (4*l.rtt + rtt) / 5
+	}
+	l.failedAt = time.Time{}
+	return nil
+}
+
+// handleAnycastMsg delivers the payload to our processors and acknowledges
+// it.
+func (o *Overlay) handleAnycastMsg(env *network.Envelope) {
+	am, ok := env.Msg.(*AnycastMsg)
+	if !ok {
+		log.Error("not an anycast message")
+		return
+	}
+	typ, inner, err := network.Unmarshal(am.Payload, o.suite())
+	if err != nil {
+		log.Error("unmarshaling anycast payload:", err)
+		return
+	}
+	err = o.server.Dispatch(&network.Envelope{
+		ServerIdentity: env.ServerIdentity,
+		MsgType:        typ,
+		Msg:            inner,
+		Size:           network.Size(len(am.Payload)),
+	})
+	if err != nil {
+		log.Error("dispatching anycast payload:", err)
+		return
+	}
+	if _, err := o.server.Send(env.ServerIdentity, &AnycastAck{ID: am.ID}); err != nil {
+		log.Lvl2("couldn't acknowledge anycast to", env.ServerIdentity, ":", err)
+	}
+}
+
+// handleAnycastAck wakes up the Anycast call waiting for this
+// acknowledgement.
+func (o *Overlay) handleAnycastAck(env *network.Envelope) {
+	ack, ok := env.Msg.(*AnycastAck)
+	if !ok {
+		log.Error("not an anycast acknowledgement")
+		return
+	}
+	o.latenciesLock.Lock()
+	defer o.latenciesLock.Unlock()
+	if c, ok := o.anycastAcks[ack.ID]; ok {
+		close(c)
+		delete(o.anycastAcks, ack.ID)
+	}
+}
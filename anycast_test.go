@@ -0,0 +1,51 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestOverlay_Anycast(t *testing.T) {
+	local, servers, count := setupSubset(t, 3)
+	defer local.CloseAll()
+	o := servers[0].overlay
+	o.anycastTimeout = 200 * time.Millisecond
+
+	// The dead candidate is unknown like the others, so it is tried first,
+	// fails and must be replaced by a live one.
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	candidates := []*network.ServerIdentity{dead, servers[1].ServerIdentity, servers[2].ServerIdentity}
+	si, err := o.Anycast(candidates, &subsetTestMsg{1})
+	require.NoError(t, err)
+	require.True(t, si.Equal(servers[1].ServerIdentity))
+	require.Equal(t, 1, count(1))
+	require.Equal(t, 0, count(2))
+	_, ok := o.PeerLatency(dead.ID)
+	require.False(t, ok)
+	_, ok = o.PeerLatency(servers[1].ServerIdentity.ID)
+	require.True(t, ok)
+
+	// Now the measured candidate comes first and the failed one last.
+	sorted := o.sortByLatency(candidates)
+	require.True(t, sorted[0].Equal(servers[1].ServerIdentity))
+	require.True(t, sorted[1].Equal(servers[2].ServerIdentity))
+	require.True(t, sorted[2].Equal(dead))
+
+	_, err = o.Anycast([]*network.ServerIdentity{dead}, &subsetTestMsg{2})
+	require.Error(t, err)
+}
+
+func TestOverlay_AnycastLocal(t *testing.T) {
+	local, servers, count := setupSubset(t, 2)
+	defer local.CloseAll()
+
+	candidates := []*network.ServerIdentity{servers[1].ServerIdentity, servers[0].ServerIdentity}
+	si, err := servers[0].overlay.Anycast(candidates, &subsetTestMsg{1})
+	require.NoError(t, err)
+	require.True(t, si.Equal(servers[0].ServerIdentity))
+	require.Equal(t, 1, count(0))
+	require.Equal(t, 0, count(1))
+}
@@ -0,0 +1,180 @@
+package onet
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// Deprecation tells the clients of a handler that it will go away. It is
+// sent in the Deprecation, Sunset and X-Onet-Deprecation headers of the
+// replies of the gateway and of the websocket upgrades, and the Client logs
+// it once per handler.
+type Deprecation struct {
+	// Message tells the clients what to use instead.
+	Message string
+	// Sunset, if not zero, is when the handler will be removed.
+	Sunset time.Time
+}
+
+// setHeaders adds the deprecation to the headers of a reply.
+func (d *Deprecation) setHeaders(h http.Header) {
+	h.Set("Deprecation", "true")
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Message != "" {
+		h.Set("X-Onet-Deprecation", d.Message)
+	}
+}
+
+// String returns the deprecation as logged by the clients.
+func (d Deprecation) String() string {
+	s := "deprecated"
+	if !d.Sunset.IsZero() {
+		s += " until " + d.Sunset.UTC().Format(time.RFC3339)
+	}
+	if d.Message != "" {
+		s += ": " + d.Message
+	}
+	return s
+}
+
+// deprecationFromHeader returns the deprecation in the headers of a reply,
+// or nil if there is none.
+func deprecationFromHeader(h http.Header) *Deprecation {
+	if h.Get("Deprecation") == "" {
+		return nil
+	}
+	d := &Deprecation{Message: h.Get("X-Onet-Deprecation")}
+	if sunset, err := http.ParseTime(h.Get("Sunset")); err == nil {
+		d.Sunset = sunset
+	}
+	return d
+}
+
+// handlerDeprecations is implemented by the services that can deprecate
+// their handlers, which are the ones embedding a ServiceProcessor.
+type handlerDeprecations interface {
+	handlerDeprecation(path string) *Deprecation
+}
+
+// versionedPath returns the path of the version of the handler, as used on
+// the websocket and the gateway.
+func versionedPath(version int, name string) string {
+	return fmt.Sprintf("v%d/%s", version, name)
+}
+
+// RegisterVersionedHandler stores a handler like RegisterHandler, but for
+// the versions minVersion to maxVersion of the API of the service. The
+// websocket forwards the requests to "ws://service_name/v$version/struct_name",
+// and the gateway those to "/v4/service_name/v$version/struct_name". The
+// requests to "struct_name" without version go to the handler given to
+// RegisterHandler if there is one, else to the lowest version, so that the
+// clients written before the versions keep working.
+//
+// A breaking change of the messages of a handler must be registered under a
+// new version, and the old versions can be marked with DeprecateHandler.
+func (p *ServiceProcessor) RegisterVersionedHandler(f interface{}, minVersion, maxVersion int) error {
+	if minVersion < 1 {
+		return xerrors.New("versions start at 1")
+	}
+	if minVersion > maxVersion {
+		return xerrors.New("min version is greater than max version")
+	}
+	if err := handlerInputCheck(f); err != nil {
+		return xerrors.Errorf("input check: %v", err)
+	}
+	pm, sh, err := createServiceHandler(f)
+	if err != nil {
+		return xerrors.Errorf("creating handler: %v", err)
+	}
+	for v := minVersion; v <= maxVersion; v++ {
+		p.handlers[versionedPath(v, pm)] = sh
+	}
+	return nil
+}
+
+// DeprecateHandler marks the handler at path, like "struct_name" or
+// "v1/struct_name", as deprecated. The handler keeps working, but its
+// clients are told it will go away.
+func (p *ServiceProcessor) DeprecateHandler(path string, d Deprecation) error {
+	h, ok := p.handlers[path]
+	if !ok {
+		return xerrors.New("unknown handler: " + path)
+	}
+	h.deprecation = &d
+	p.handlers[path] = h
+	return nil
+}
+
+// lookupHandler returns the handler of the path, which is the handler
+// without version or the lowest version if the path has no version.
+func (p *ServiceProcessor) lookupHandler(path string) (serviceHandler, bool) {
+	if h, ok := p.handlers[path]; ok || strings.Contains(path, "/") {
+		return h, ok
+	}
+	var versions []int
+	for name := range p.handlers {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 || parts[1] != path || !strings.HasPrefix(parts[0], "v") {
+			continue
+		}
+		if v, err := strconv.Atoi(parts[0][1:]); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return serviceHandler{}, false
+	}
+	sort.Ints(versions)
+	h, ok := p.handlers[versionedPath(versions[0], path)]
+	return h, ok
+}
+
+// handlerDeprecation returns the deprecation of the handler of the path.
+func (p *ServiceProcessor) handlerDeprecation(path string) *Deprecation {
+	h, _ := p.lookupHandler(path)
+	return h.deprecation
+}
+
+// SendProtobufVersion is like SendProtobuf, but sends msg to the given
+// version of the handler, as registered with RegisterVersionedHandler.
+func (c *Client) SendProtobufVersion(dst *network.ServerIdentity, version int, msg interface{}, ret interface{}) error {
+	name := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+	return c.sendProtobuf(dst, versionedPath(version, name), msg, ret)
+}
+
+// deprecated records that the handler is deprecated, and warns about it the
+// first time.
+func (c *Client) deprecated(path string, d Deprecation) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.deprecations[path]; ok {
+		return
+	}
+	if c.deprecations == nil {
+		c.deprecations = make(map[string]Deprecation)
+	}
+	c.deprecations[path] = d
+	log.Warnf("%s/%s is %s", c.service, path, d)
+}
+
+// Deprecations returns the deprecated handlers the client used, by path.
+func (c *Client) Deprecations() map[string]Deprecation {
+	c.Lock()
+	defer c.Unlock()
+	ret := make(map[string]Deprecation)
+	for path, d := range c.deprecations {
+		ret[path] = d
+	}
+	return ret
+}
@@ -0,0 +1,92 @@
+package onet
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+const versionServiceName = "versionService"
+
+type versionQuery struct {
+	Val int
+}
+
+func TestServiceProcessor_RegisterVersionedHandler(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	RegisterNewService(versionServiceName, func(c *Context) (Service, error) {
+		s := NewServiceProcessor(c)
+		v1 := func(msg *versionQuery) (*versionQuery, error) {
+			return &versionQuery{Val: msg.Val + 1}, nil
+		}
+		v2 := func(msg *versionQuery) (*versionQuery, error) {
+			return &versionQuery{Val: msg.Val + 2}, nil
+		}
+		if err := s.RegisterVersionedHandler(v1, 0, 1); err == nil {
+			return nil, xerrors.New("version 0 accepted")
+		}
+		if err := s.RegisterVersionedHandler(v1, 2, 1); err == nil {
+			return nil, xerrors.New("wrong range accepted")
+		}
+		if err := s.RegisterVersionedHandler(v1, 1, 1); err != nil {
+			return nil, err
+		}
+		if err := s.RegisterVersionedHandler(v2, 2, 3); err != nil {
+			return nil, err
+		}
+		if err := s.DeprecateHandler("v2/unknown", Deprecation{}); err == nil {
+			return nil, xerrors.New("unknown handler deprecated")
+		}
+		return s, s.DeprecateHandler("v1/versionQuery", Deprecation{
+			Message: "use v2",
+			Sunset:  sunset,
+		})
+	})
+	defer UnregisterService(versionServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	cl := local.NewClient(versionServiceName)
+
+	reply := &versionQuery{}
+	// without version the lowest one is used, which is deprecated
+	require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &versionQuery{Val: 10}, reply))
+	require.Equal(t, 11, reply.Val)
+	_, ok := cl.Deprecations()["versionQuery"]
+	require.True(t, ok)
+	require.NoError(t, cl.SendProtobufVersion(h.ServerIdentity, 2, &versionQuery{Val: 10}, reply))
+	require.Equal(t, 12, reply.Val)
+	require.NoError(t, cl.SendProtobufVersion(h.ServerIdentity, 3, &versionQuery{Val: 10}, reply))
+	require.Equal(t, 12, reply.Val)
+	require.Error(t, cl.SendProtobufVersion(h.ServerIdentity, 4, &versionQuery{Val: 10}, reply))
+	require.Equal(t, 1, len(cl.Deprecations()))
+
+	require.NoError(t, cl.SendProtobufVersion(h.ServerIdentity, 1, &versionQuery{Val: 10}, reply))
+	require.Equal(t, 11, reply.Val)
+	dep, ok := cl.Deprecations()["v1/versionQuery"]
+	require.True(t, ok)
+	require.Equal(t, "use v2", dep.Message)
+	require.True(t, sunset.Equal(dep.Sunset))
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	addr := "http://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/v4/"
+	resp := gatewayPost(t, addr+versionServiceName+"/v1/versionQuery", "", `{"Val": 1}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "true", resp.Header.Get("Deprecation"))
+	require.Equal(t, "use v2", resp.Header.Get("X-Onet-Deprecation"))
+	require.Equal(t, sunset.Format(http.TimeFormat), resp.Header.Get("Sunset"))
+	resp = gatewayPost(t, addr+versionServiceName+"/v2/versionQuery", "", `{"Val": 1}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "", resp.Header.Get("Deprecation"))
+}
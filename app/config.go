@@ -3,12 +3,14 @@ package app
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"go.dedis.ch/kyber/v3"
@@ -23,13 +25,32 @@ import (
 // CothorityConfig is the configuration structure of the cothority daemon.
 // - Suite: The cryptographic suite
 // - Public: The public key
-// - Private: The Private key
+// - Private: The Private key, encrypted with a passphrase if it starts with
+// "scrypt:", as the ones of the services
 // - Address: The external address of the conode, used by others to connect to this one
 // - ListenAddress: The address this conode is listening on
 // - Description: The description
 // - URL: The URL where this server can be contacted externally.
 // - WebSocketTLSCertificate: TLS certificate for the WebSocket
 // - WebSocketTLSCertificateKey: TLS certificate key for the WebSocket
+// - WebSocketListenAddress: The address the WebSocket listens on, if it isn't on
+// all the interfaces one port above Address
+// - WebSocketLocalOnly: Whether the WebSocket only listens on the loopback
+// interface
+// - WebSocketUnixSocket: The Unix socket the WebSocket listens on instead of a
+// port, with the permissions WebSocketUnixSocketMode, 0600 by default
+// - WebSocketAllowedOrigins: The origins of the web pages that may use the
+// WebSocket, all of them if empty
+// - WebSocketTrustedProxies: The reverse proxies whose X-Forwarded-For header
+// is trusted
+// - WebSocketPingInterval, WebSocketReadTimeout, WebSocketWriteTimeout and
+// WebSocketIdleTimeout: How the connections of the clients are kept alive, like
+// "30s", see onet.KeepAlive
+// - Storage: The storage backend of the services, "bbolt", "badger" or
+// "memory", "bbolt" if empty
+// - StorageKey: "conode" to encrypt the storage with a key derived from the
+// private key, or a hex-encoded key, or empty for no encryption
+// - OldStorageKeys: The previous values of StorageKey, for key rotation
 type CothorityConfig struct {
 	Suite                      string
 	Public                     string
@@ -41,6 +62,63 @@ type CothorityConfig struct {
 	URL                        string
 	WebSocketTLSCertificate    CertificateURL
 	WebSocketTLSCertificateKey CertificateURL
+	WebSocketListenAddress     string      `toml:",omitempty"`
+	WebSocketLocalOnly         bool        `toml:",omitempty"`
+	WebSocketUnixSocket        string      `toml:",omitempty"`
+	WebSocketUnixSocketMode    os.FileMode `toml:",omitempty"`
+	WebSocketAllowedOrigins    []string    `toml:",omitempty"`
+	WebSocketTrustedProxies    []string    `toml:",omitempty"`
+	WebSocketPingInterval      string      `toml:",omitempty"`
+	WebSocketReadTimeout       string      `toml:",omitempty"`
+	WebSocketWriteTimeout      string      `toml:",omitempty"`
+	WebSocketIdleTimeout       string      `toml:",omitempty"`
+	Storage                    string      `toml:",omitempty"`
+	StorageKey                 string      `toml:",omitempty"`
+	OldStorageKeys             []string    `toml:",omitempty"`
+	// ClientLimits, if set, limits the requests of the clients
+	ClientLimits *onet.ClientLimits `toml:",omitempty"`
+	// GRPCAddress, if set, is where the gRPC gateway listens
+	GRPCAddress string `toml:",omitempty"`
+	// ShutdownTimeout, like "30s", is how long the running protocols may
+	// take to finish when the conode shuts down
+	ShutdownTimeout string `toml:",omitempty"`
+	// AdminTokens maps the names of the operators to the bearer tokens
+	// they use for the admin API on /admin/ of the websocket
+	AdminTokens map[string]string `toml:",omitempty"`
+	// MaxMessageSize, MaxMessageDepth and MaxMessageElements, if set, limit
+	// the size, the nesting and the number of fields of the messages
+	// received, and DecodeTimeout, like "1m", how long a message may take to
+	// be received
+	MaxMessageSize     int    `toml:",omitempty"`
+	MaxMessageDepth    int    `toml:",omitempty"`
+	MaxMessageElements int    `toml:",omitempty"`
+	DecodeTimeout      string `toml:",omitempty"`
+	// PuzzleDifficulty, if set, has the conodes connecting to this one
+	// solve a puzzle of this many bits first, and MaxPendingPuzzles limits
+	// how many of them may be solving it at the same time. All the conodes
+	// of the network must set the same difficulty.
+	PuzzleDifficulty  int `toml:",omitempty"`
+	MaxPendingPuzzles int `toml:",omitempty"`
+	// TreeCacheTTL, like "10m", is how long the trees are kept after their
+	// last protocol is done, and MaxTrees how many of them are kept, or -1
+	// for no limit
+	TreeCacheTTL string `toml:",omitempty"`
+	MaxTrees     int    `toml:",omitempty"`
+	// DispatchWorkers is how many protocol messages are processed at the
+	// same time, for different protocol instances.
+	DispatchWorkers int `toml:",omitempty"`
+	// GoroutineBudget is how many goroutines at most give the messages to
+	// the protocol instances, without a limit if it is zero.
+	GoroutineBudget int `toml:",omitempty"`
+	// MaxClockSkew, like "5s", is how far the clocks of the watched conodes
+	// may be before a warning is logged
+	MaxClockSkew string `toml:",omitempty"`
+
+	// passphrase encrypts the private keys when the config is saved
+	passphrase string
+	// sections are the [Config.*] sections of the services, as read from
+	// the file, written back when the config is saved
+	sections map[string]interface{}
 }
 
 // ServiceConfig is the configuration of a specific service to override
@@ -59,21 +137,79 @@ func (hc *CothorityConfig) Save(file string) error {
 	if err != nil {
 		return xerrors.Errorf("opening config file: %v", err)
 	}
+	defer fd.Close()
+	c := hc
+	if hc.passphrase != "" {
+		if c, err = hc.encrypted(); err != nil {
+			return xerrors.Errorf("encrypting keys: %v", err)
+		}
+	}
 	fd.WriteString("# This file contains your private key.\n")
 	fd.WriteString("# Do not give it away lightly!\n")
-	err = toml.NewEncoder(fd).Encode(hc)
+	err = toml.NewEncoder(fd).Encode(c)
 	if err != nil {
 		return xerrors.Errorf("toml encoding: %v", err)
 	}
+	if len(c.sections) > 0 {
+		err = toml.NewEncoder(fd).Encode(map[string]interface{}{"Config": c.sections})
+		if err != nil {
+			return xerrors.Errorf("toml encoding of the sections: %v", err)
+		}
+	}
 	return nil
 }
 
-// LoadCothority loads a conode config from the given file.
+// LoadCothority loads a conode config from the given file, with its fields
+// overridden by the CONODE_ environment variables.
 func LoadCothority(file string) (*CothorityConfig, error) {
+	return LoadCothorityWithFlags(file, nil)
+}
+
+// LoadCothorityWithFlags loads a conode config from the given file, with its
+// fields overridden by the CONODE_ environment variables, and then by the
+// flags given in the command-line, if flags is not nil.
+func LoadCothorityWithFlags(file string, flags *ConfigFlags) (*CothorityConfig, error) {
+	return loadCothority(file, true, flags)
+}
+
+// loadCothority loads a conode config from the given file, with its fields
+// overridden if override is true. The commands rewriting the file load it
+// without, so that the overrides aren't saved in it.
+func loadCothority(file string, override bool, flags *ConfigFlags) (*CothorityConfig, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("opening config file: %v", err)
+	}
 	hc := &CothorityConfig{}
-	_, err := toml.DecodeFile(file, hc)
+	// the sections of the services are decoded when they are reloaded
+	format := fileFormat(file)
+	if err := decodeStrict(bytes.NewReader(buf), format, hc, "Config"); err != nil {
+		return nil, xerrors.Errorf("%s: %v", file, err)
+	}
+	if hc.sections, err = configSections(bytes.NewReader(buf), format); err != nil {
+		return nil, xerrors.Errorf("%s: %v", file, err)
+	}
+	if override {
+		if err := Override(hc, EnvPrefix, flags); err != nil {
+			return nil, xerrors.Errorf("overriding: %v", err)
+		}
+	}
+	if hc.Encrypted() {
+		passphrase, err := askPassphrase("Passphrase of the keys of " + file)
+		if err != nil {
+			return nil, err
+		}
+		if err := hc.decryptKeys(passphrase); err != nil {
+			return nil, xerrors.Errorf("decrypting keys: %v", err)
+		}
+	}
+	err = missing(file, map[string]string{
+		"Public":  hc.Public,
+		"Private": hc.Private,
+		"Address": string(hc.Address),
+	})
 	if err != nil {
-		return nil, xerrors.Errorf("toml decoding: %v", err)
+		return nil, err
 	}
 
 	// Backwards compatibility with configs before we included the suite name
@@ -121,6 +257,117 @@ func (hc *CothorityConfig) GetServerIdentity() (*network.ServerIdentity, error)
 	return si, nil
 }
 
+// StorageConfig returns the configuration of the storage of the services.
+func (hc *CothorityConfig) StorageConfig(si *network.ServerIdentity) (onet.StorageConfig, error) {
+	cfg := onet.StorageConfig{Backend: hc.Storage}
+	if hc.StorageKey == "" {
+		return cfg, nil
+	}
+	parse := func(k string) ([]byte, error) {
+		if k == "conode" {
+			return onet.DeriveStorageKey(si.GetPrivate())
+		}
+		return hex.DecodeString(k)
+	}
+	var err error
+	cfg.Key, err = parse(hc.StorageKey)
+	if err != nil {
+		return cfg, xerrors.Errorf("storage key: %v", err)
+	}
+	for _, k := range hc.OldStorageKeys {
+		old, err := parse(k)
+		if err != nil {
+			return cfg, xerrors.Errorf("old storage key: %v", err)
+		}
+		cfg.OldKeys = append(cfg.OldKeys, old)
+	}
+	return cfg, nil
+}
+
+// DecodeLimits returns the limits of the messages received by the conode.
+func (hc *CothorityConfig) DecodeLimits() (network.DecodeLimits, error) {
+	if hc.MaxMessageSize < 0 || hc.MaxMessageDepth < 0 || hc.MaxMessageElements < 0 {
+		return network.DecodeLimits{}, xerrors.New("negative message limit")
+	}
+	limits := network.DecodeLimits{
+		MaxMessageSize: network.Size(hc.MaxMessageSize),
+		MaxDepth:       hc.MaxMessageDepth,
+		MaxElements:    hc.MaxMessageElements,
+	}
+	if hc.DecodeTimeout != "" {
+		timeout, err := time.ParseDuration(hc.DecodeTimeout)
+		if err != nil {
+			return limits, xerrors.Errorf("decode timeout: %v", err)
+		}
+		limits.Timeout = timeout
+	}
+	return limits, nil
+}
+
+// WebSocketKeepAlive returns how the websocket keeps the connections of the
+// clients alive.
+func (hc *CothorityConfig) WebSocketKeepAlive() (onet.KeepAlive, error) {
+	var ka onet.KeepAlive
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"ping interval", hc.WebSocketPingInterval, &ka.PingInterval},
+		{"read timeout", hc.WebSocketReadTimeout, &ka.ReadTimeout},
+		{"write timeout", hc.WebSocketWriteTimeout, &ka.WriteTimeout},
+		{"idle timeout", hc.WebSocketIdleTimeout, &ka.IdleTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return ka, xerrors.Errorf("%s: %v", d.name, err)
+		}
+		if v < 0 {
+			return ka, xerrors.Errorf("negative %s", d.name)
+		}
+		*d.dst = v
+	}
+	return ka, nil
+}
+
+// webSocketTLS returns the TLS configuration of the websocket, or nil if it
+// has no certificate.
+func (hc *CothorityConfig) webSocketTLS() (*tls.Config, error) {
+	if hc.WebSocketTLSCertificate == "" || hc.WebSocketTLSCertificateKey == "" {
+		return nil, nil
+	}
+	if hc.WebSocketTLSCertificate.CertificateURLType() == File &&
+		hc.WebSocketTLSCertificateKey.CertificateURLType() == File {
+		// Use the reloader only when both are files as it doesn't
+		// make sense for string embedded certificates.
+
+		cr, err := onet.NewCertificateReloader(
+			hc.WebSocketTLSCertificate.blobPart(),
+			hc.WebSocketTLSCertificateKey.blobPart(),
+		)
+		if err != nil {
+			return nil, xerrors.Errorf("certificate: %v", err)
+		}
+		return &tls.Config{GetCertificate: cr.GetCertificateFunc()}, nil
+	}
+	tlsCertificate, err := hc.WebSocketTLSCertificate.Content()
+	if err != nil {
+		return nil, xerrors.Errorf("getting WebSocketTLSCertificate content: %v", err)
+	}
+	tlsCertificateKey, err := hc.WebSocketTLSCertificateKey.Content()
+	if err != nil {
+		return nil, xerrors.Errorf("getting WebSocketTLSCertificateKey content: %v", err)
+	}
+	cert, err := tls.X509KeyPair(tlsCertificate, tlsCertificateKey)
+	if err != nil {
+		return nil, xerrors.Errorf("loading X509KeyPair: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
 // ParseCothority parses the config file into a CothorityConfig.
 // It returns the CothorityConfig, the Host so we can already use it, and an error if
 // the file is inaccessible or has wrong values in it.
@@ -129,66 +376,157 @@ func ParseCothority(file string) (*CothorityConfig, *onet.Server, error) {
 	if err != nil {
 		return nil, nil, xerrors.Errorf("reading config: %v", err)
 	}
+	server, err := hc.NewServer()
+	if err != nil {
+		return nil, nil, err
+	}
+	return hc, server, nil
+}
+
+// NewServer returns the server of the configuration, for the programs
+// embedding a conode with its configuration given as a structure instead of
+// a file. The options are given to onet.NewServer after the ones of the
+// configuration, like onet.WithServices to only run some of the services.
+func (hc *CothorityConfig) NewServer(opts ...onet.Option) (*onet.Server, error) {
 	suite, err := suites.Find(hc.Suite)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("kyber suite: %v", err)
+		return nil, xerrors.Errorf("kyber suite: %v", err)
 	}
 
 	si, err := hc.GetServerIdentity()
 	if err != nil {
-		return nil, nil, xerrors.Errorf("parse server identity: %v", err)
+		return nil, xerrors.Errorf("parse server identity: %v", err)
 	}
 
-	// Same as `NewServerTCP` if `hc.ListenAddress` is empty
-	server := onet.NewServerTCPWithListenAddr(si, suite, hc.ListenAddress)
+	storage, err := hc.StorageConfig(si)
+	if err != nil {
+		return nil, xerrors.Errorf("storage: %v", err)
+	}
 
-	// Set Websocket TLS if possible
-	if hc.WebSocketTLSCertificate != "" && hc.WebSocketTLSCertificateKey != "" {
-		if hc.WebSocketTLSCertificate.CertificateURLType() == File &&
-			hc.WebSocketTLSCertificateKey.CertificateURLType() == File {
-			// Use the reloader only when both are files as it doesn't
-			// make sense for string embedded certificates.
+	limits, err := hc.DecodeLimits()
+	if err != nil {
+		return nil, xerrors.Errorf("message limits: %v", err)
+	}
+	network.SetDecodeLimits(limits)
 
-			cr, err := onet.NewCertificateReloader(
-				hc.WebSocketTLSCertificate.blobPart(),
-				hc.WebSocketTLSCertificateKey.blobPart(),
-			)
-			if err != nil {
-				return nil, nil, xerrors.Errorf("certificate: %v", err)
-			}
+	tlsConfig, err := hc.webSocketTLS()
+	if err != nil {
+		return nil, xerrors.Errorf("websocket TLS: %v", err)
+	}
+	keepAlive, err := hc.WebSocketKeepAlive()
+	if err != nil {
+		return nil, xerrors.Errorf("websocket keep-alive: %v", err)
+	}
+	server, err := onet.NewServer(append([]onet.Option{
+		onet.WithSuite(suite),
+		onet.WithServerIdentity(si),
+		onet.WithListenAddress(hc.ListenAddress),
+		onet.WithStorage(storage),
+		onet.WithWebSocketConfig(onet.WebSocketConfig{
+			ListenAddress:  hc.WebSocketListenAddress,
+			LocalOnly:      hc.WebSocketLocalOnly,
+			UnixSocket:     hc.WebSocketUnixSocket,
+			UnixSocketMode: hc.WebSocketUnixSocketMode,
+			AllowedOrigins: hc.WebSocketAllowedOrigins,
+			TrustedProxies: hc.WebSocketTrustedProxies,
+			KeepAlive:      keepAlive,
+		}),
+		onet.WithTLS(tlsConfig),
+	}, opts...)...)
+	if err != nil {
+		return nil, xerrors.Errorf("server: %v", err)
+	}
+	if err := hc.configure(server); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return server, nil
+}
 
-			server.WebSocket.Lock()
-			server.WebSocket.TLSConfig = &tls.Config{
-				GetCertificate: cr.GetCertificateFunc(),
-			}
-			server.WebSocket.Unlock()
-		} else {
-			tlsCertificate, err := hc.WebSocketTLSCertificate.Content()
-			if err != nil {
-				return nil, nil, xerrors.Errorf("getting WebSocketTLSCertificate content: %v", err)
-			}
-			tlsCertificateKey, err := hc.WebSocketTLSCertificateKey.Content()
-			if err != nil {
-				return nil, nil, xerrors.Errorf("getting WebSocketTLSCertificateKey content: %v", err)
-			}
-			cert, err := tls.X509KeyPair(tlsCertificate, tlsCertificateKey)
-			if err != nil {
-				return nil, nil, xerrors.Errorf("loading X509KeyPair: %v", err)
+// configure sets the limits and the tokens of the configuration.
+func (hc *CothorityConfig) configure(server *onet.Server) error {
+	var err error
+	if hc.ClientLimits != nil {
+		server.SetClientLimits(*hc.ClientLimits)
+	}
+	if hc.TreeCacheTTL != "" || hc.MaxTrees != 0 {
+		c := onet.TreeCacheConfig{MaxTrees: hc.MaxTrees}
+		if hc.TreeCacheTTL != "" {
+			if c.TTL, err = time.ParseDuration(hc.TreeCacheTTL); err != nil {
+				return xerrors.Errorf("tree cache TTL: %v", err)
 			}
+		}
+		server.SetTreeCache(c)
+	}
+	if hc.DispatchWorkers != 0 {
+		server.SetDispatchWorkers(hc.DispatchWorkers)
+	}
+	if hc.GoroutineBudget != 0 {
+		server.SetGoroutineBudget(hc.GoroutineBudget)
+	}
+	if hc.MaxClockSkew != "" {
+		d, err := time.ParseDuration(hc.MaxClockSkew)
+		if err != nil {
+			return xerrors.Errorf("max clock skew: %v", err)
+		}
+		server.SetMaxClockSkew(d)
+	}
+	if hc.PuzzleDifficulty != 0 {
+		err = server.SetPuzzle(network.Puzzle{
+			Difficulty: hc.PuzzleDifficulty,
+			MaxPending: hc.MaxPendingPuzzles,
+		})
+		if err != nil {
+			return xerrors.Errorf("puzzle: %v", err)
+		}
+	}
+	for name, token := range hc.AdminTokens {
+		if err := server.AddAdminToken(name, token); err != nil {
+			return xerrors.Errorf("admin token of %s: %v", name, err)
+		}
+	}
+	return nil
+}
 
-			server.WebSocket.Lock()
-			server.WebSocket.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
-			server.WebSocket.Unlock()
+// reloadableConfig is the part of the config file with the sections the
+// services can reload, given as tables [Config.<section>].
+type reloadableConfig struct {
+	Config map[string]toml.Primitive
+}
+
+// ReloadConfigFile reads the [Config.<section>] tables of the config file
+// and gives them to the services of the server, see onet.Server.ReloadConfig.
+// In a YAML or JSON file, they are the tables of Config.
+// It returns the names of the sections that have been applied.
+func ReloadConfigFile(server *onet.Server, file string) ([]string, error) {
+	if format := fileFormat(file); format != formatTOML {
+		return reloadConfigSections(server, file, format)
+	}
+	rc := &reloadableConfig{}
+	md, err := toml.DecodeFile(file, rc)
+	if err != nil {
+		return nil, xerrors.Errorf("toml decoding: %v", err)
+	}
+	updated, err := server.ReloadConfig(func(name string, cfg interface{}) (bool, error) {
+		prim, ok := rc.Config[name]
+		if !ok {
+			return false, nil
 		}
+		if err := md.PrimitiveDecode(prim, cfg); err != nil {
+			return false, xerrors.Errorf("toml decoding: %v", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reloading: %v", err)
 	}
-	return hc, server, nil
+	return updated, nil
 }
 
 // GroupToml holds the data of the group.toml file.
 type GroupToml struct {
-	Servers []*ServerToml `toml:"servers"`
+	Description string        `toml:",omitempty"`
+	Servers     []*ServerToml `toml:"servers"`
 }
 
 // NewGroupToml creates a new GroupToml struct from the given ServerTomls.
@@ -274,19 +612,55 @@ func (g *Group) Save(suite suites.Suite, filename string) error {
 }
 
 // ReadGroupDescToml reads a group.toml file and returns the list of ServerIdentities
-// and descriptions in the file.
+// and descriptions in the file, with its fields overridden by the
+// CONODE_GROUP_ environment variables.
 // If the file couldn't be decoded or doesn't hold valid ServerIdentities,
 // an error is returned.
 func ReadGroupDescToml(f io.Reader) (*Group, error) {
-	group := &GroupToml{}
-	_, err := toml.DecodeReader(f, group)
+	return ReadGroupDescTomlWithFlags(f, nil)
+}
+
+// ReadGroupDescTomlWithFlags is ReadGroupDescToml with the fields of the
+// group.toml file also overridden by the flags given in the command-line, if
+// flags is not nil.
+func ReadGroupDescTomlWithFlags(f io.Reader, flags *ConfigFlags) (*Group, error) {
+	return readGroupDesc(f, formatTOML, flags)
+}
+
+// ReadGroupDescFile reads a group file in TOML, YAML or JSON, after the
+// extension of its name, like ReadGroupDescTomlWithFlags. Flags can be nil.
+func ReadGroupDescFile(file string, flags *ConfigFlags) (*Group, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		return nil, xerrors.Errorf("toml decoding: %v", err)
+		return nil, xerrors.Errorf("opening group file: %v", err)
+	}
+	defer f.Close()
+	group, err := readGroupDesc(f, fileFormat(file), flags)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %v", file, err)
+	}
+	return group, nil
+}
+
+func readGroupDesc(f io.Reader, format string, flags *ConfigFlags) (*Group, error) {
+	group := &GroupToml{}
+	if err := decodeStrict(f, format, group); err != nil {
+		return nil, err
+	}
+	if err := Override(group, GroupEnvPrefix, flags); err != nil {
+		return nil, xerrors.Errorf("overriding: %v", err)
 	}
 	// convert from ServerTomls to entities
 	var entities = make([]*network.ServerIdentity, len(group.Servers))
 	var descs = make(map[*network.ServerIdentity]string)
 	for i, s := range group.Servers {
+		err := missing(fmt.Sprintf("server %d", i+1), map[string]string{
+			"Address": string(s.Address),
+			"Public":  s.Public,
+		})
+		if err != nil {
+			return nil, err
+		}
 		// Backwards compatibility with old group files.
 		if s.Suite == "" {
 			s.Suite = "Ed25519"
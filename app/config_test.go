@@ -8,10 +8,13 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v4"
 	"go.dedis.ch/onet/v4/log"
 	"go.dedis.ch/onet/v4/network"
@@ -151,6 +154,14 @@ func TestParseCothority(t *testing.T) {
         Address = "%s"
         ListenAddress = "%s"
 		    Description = "%s"
+		PuzzleDifficulty = 4
+		TreeCacheTTL = "1m"
+		MaxTrees = 5
+		DispatchWorkers = 4
+		GoroutineBudget = 64
+		MaxClockSkew = "2s"
+		WebSocketPingInterval = "30s"
+		WebSocketIdleTimeout = "10m"
 		[services]
 			[services.%s]
 			suite = "bn256.adapter"
@@ -182,6 +193,19 @@ func TestParseCothority(t *testing.T) {
 	require.Equal(t, "bn256.adapter", cothConfig.Services[testServiceName].Suite)
 	require.Equal(t, scPublic, cothConfig.Services[testServiceName].Public)
 	require.Equal(t, scPrivate, cothConfig.Services[testServiceName].Private)
+	require.Equal(t, 4, cothConfig.PuzzleDifficulty)
+	require.Equal(t, "1m", cothConfig.TreeCacheTTL)
+	require.Equal(t, 5, cothConfig.MaxTrees)
+	require.Equal(t, 4, cothConfig.DispatchWorkers)
+	require.Equal(t, 64, cothConfig.GoroutineBudget)
+	require.Equal(t, "2s", cothConfig.MaxClockSkew)
+	ka, err := cothConfig.WebSocketKeepAlive()
+	require.NoError(t, err)
+	require.Equal(t, onet.KeepAlive{PingInterval: 30 * time.Second, IdleTimeout: 10 * time.Minute}, ka)
+	cothConfig.WebSocketReadTimeout = "-1s"
+	_, err = cothConfig.WebSocketKeepAlive()
+	require.Error(t, err)
+	cothConfig.WebSocketReadTimeout = ""
 
 	srv.Close()
 }
@@ -321,3 +345,136 @@ f9Oeos0UUothgiDktdQHxdNEwLjQf7lJJBzV+5OtwswCWA==
 		require.Nil(t, err)
 	}
 }
+
+type reloadTestConfig struct {
+	Limit int
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	var applied []int
+	onet.RegisterNewService("OnetReloadTestService", func(c *onet.Context) (onet.Service, error) {
+		err := c.RegisterConfigSection(onet.ConfigSection{
+			Name: "tuning",
+			New:  func() interface{} { return &reloadTestConfig{} },
+			Apply: func(cfg interface{}) {
+				applied = append(applied, cfg.(*reloadTestConfig).Limit)
+			},
+		})
+		return nil, err
+	})
+	defer onet.UnregisterService("OnetReloadTestService")
+
+	privateInfo := `Suite = "Ed25519"
+Public = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+Private = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+Address = "tcp://1.2.3.4:1234"
+ListenAddress = "127.0.0.1:0"
+[Config.tuning]
+Limit = %d
+[Config.unknown]
+Other = "ignored"
+`
+	privateToml, err := ioutil.TempFile("", "temp_private.toml")
+	require.NoError(t, err)
+	defer os.Remove(privateToml.Name())
+	privateToml.WriteString(fmt.Sprintf(privateInfo, 5))
+	privateToml.Close()
+
+	_, srv, err := ParseCothority(privateToml.Name())
+	require.NoError(t, err)
+	defer srv.Close()
+
+	updated, err := ReloadConfigFile(srv, privateToml.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"tuning"}, updated)
+	require.Equal(t, []int{5}, applied)
+
+	require.NoError(t, ioutil.WriteFile(privateToml.Name(), []byte(fmt.Sprintf(privateInfo, 7)), 0600))
+	_, err = ReloadConfigFile(srv, privateToml.Name())
+	require.NoError(t, err)
+	require.Equal(t, []int{5, 7}, applied)
+
+	require.NoError(t, ioutil.WriteFile(privateToml.Name(), []byte("[Config.tuning]\nLimit = \"no\"\n"), 0600))
+	_, err = ReloadConfigFile(srv, privateToml.Name())
+	require.Error(t, err)
+	require.Equal(t, []int{5, 7}, applied)
+}
+
+func TestCothorityConfig_StorageConfig(t *testing.T) {
+	kp := key.NewKeyPair(suites.MustFind("Ed25519"))
+	si := network.NewServerIdentity(kp.Public, network.NewAddress(network.TLS, "127.0.0.1:2000"))
+	si.SetPrivate(kp.Private)
+
+	hc := &CothorityConfig{Storage: "memory"}
+	cfg, err := hc.StorageConfig(si)
+	require.NoError(t, err)
+	require.Equal(t, "memory", cfg.Backend)
+	require.Nil(t, cfg.Key)
+
+	hc.StorageKey = "conode"
+	hc.OldStorageKeys = []string{strings.Repeat("01", onet.StorageKeySize)}
+	cfg, err = hc.StorageConfig(si)
+	require.NoError(t, err)
+	derived, err := onet.DeriveStorageKey(kp.Private)
+	require.NoError(t, err)
+	require.Equal(t, derived, cfg.Key)
+	require.Equal(t, [][]byte{bytes.Repeat([]byte{1}, onet.StorageKeySize)}, cfg.OldKeys)
+
+	hc.StorageKey = "not hex"
+	_, err = hc.StorageConfig(si)
+	require.Error(t, err)
+}
+
+func TestCothorityConfig_DecodeLimits(t *testing.T) {
+	hc := &CothorityConfig{}
+	limits, err := hc.DecodeLimits()
+	require.NoError(t, err)
+	require.Equal(t, network.DecodeLimits{}, limits)
+
+	hc = &CothorityConfig{MaxMessageSize: 1024, MaxMessageElements: 10, DecodeTimeout: "30s"}
+	limits, err = hc.DecodeLimits()
+	require.NoError(t, err)
+	require.Equal(t, network.DecodeLimits{MaxMessageSize: 1024, MaxElements: 10,
+		Timeout: 30 * time.Second}, limits)
+
+	hc.DecodeTimeout = "soon"
+	_, err = hc.DecodeLimits()
+	require.Error(t, err)
+	hc = &CothorityConfig{MaxMessageDepth: -1}
+	_, err = hc.DecodeLimits()
+	require.Error(t, err)
+}
+
+func TestCothorityConfig_NewServer(t *testing.T) {
+	for _, name := range []string{"EmbeddedA", "EmbeddedB"} {
+		_, err := onet.RegisterNewService(name, func(c *onet.Context) (onet.Service, error) {
+			return onet.NewServiceProcessor(c), nil
+		})
+		require.NoError(t, err)
+		defer onet.UnregisterService(name)
+	}
+	tmp, err := ioutil.TempDir("", "embedded")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	suite := suites.MustFind("Ed25519")
+	kp := key.NewKeyPair(suite)
+	public, err := encoding.PointToStringHex(suite, kp.Public)
+	require.NoError(t, err)
+	private, err := encoding.ScalarToStringHex(suite, kp.Private)
+	require.NoError(t, err)
+	hc := &CothorityConfig{
+		Suite:                  "Ed25519",
+		Public:                 public,
+		Private:                private,
+		Address:                network.NewTCPAddress("127.0.0.1:0"),
+		WebSocketListenAddress: "127.0.0.1:0",
+		MaxTrees:               5,
+	}
+	srv, err := hc.NewServer(onet.WithStorageDir(tmp), onet.WithServices("EmbeddedA"))
+	require.NoError(t, err)
+	defer srv.Close()
+	require.True(t, srv.ServerIdentity.Public.Equal(kp.Public))
+	require.NotNil(t, srv.Service("EmbeddedA"))
+	require.Nil(t, srv.Service("EmbeddedB"))
+}
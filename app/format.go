@@ -0,0 +1,208 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.dedis.ch/onet/v4"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// The formats of the configuration files, told apart by their extension:
+// .yaml or .yml for YAML, .json for JSON, and TOML for any other one.
+const (
+	formatTOML = "toml"
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+// fileFormat returns the format of the configuration file.
+func fileFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".json":
+		return formatJSON
+	}
+	return formatTOML
+}
+
+// decodeStrict decodes the configuration in the format into v, and fails on
+// the keys that are not fields of v, except the top-level ones in skip,
+// and on the values of the wrong type. The keys of YAML and JSON are the
+// ones of TOML.
+func decodeStrict(r io.Reader, format string, v interface{}, skip ...string) error {
+	if format == formatTOML {
+		md, err := toml.DecodeReader(r, v)
+		if err != nil {
+			return xerrors.Errorf("toml decoding: %v", err)
+		}
+		var unknown []string
+		for _, key := range md.Undecoded() {
+			if !contains(skip, key[0]) {
+				unknown = append(unknown, key.String())
+			}
+		}
+		if len(unknown) > 0 {
+			return xerrors.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+		}
+		return nil
+	}
+
+	top, err := decodeGeneric(r, format)
+	if err != nil {
+		return err
+	}
+	for _, key := range skip {
+		delete(top, key)
+	}
+	buf, err := json.Marshal(top)
+	if err != nil {
+		return xerrors.Errorf("json encoding: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return xerrors.Errorf("%s decoding: %v", format, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
+// decodeGeneric returns the top-level table of a YAML or JSON file.
+func decodeGeneric(r io.Reader, format string) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("reading: %v", err)
+	}
+	var raw interface{}
+	if format == formatYAML {
+		err = yaml.Unmarshal(buf, &raw)
+	} else {
+		err = json.Unmarshal(buf, &raw)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("%s decoding: %v", format, err)
+	}
+	if raw == nil {
+		return map[string]interface{}{}, nil
+	}
+	top, ok := stringKeys(raw).(map[string]interface{})
+	if !ok {
+		return nil, xerrors.Errorf("%s decoding: the file is not a table of keys", format)
+	}
+	return top, nil
+}
+
+// stringKeys returns the value with the tables of YAML, whose keys can be of
+// any type, turned into tables with string keys, as the ones of JSON.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = stringKeys(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = stringKeys(e)
+		}
+		return v
+	}
+	return v
+}
+
+// configSections returns the [Config.*] sections of the services in the
+// configuration, by their name.
+func configSections(r io.Reader, format string) (map[string]interface{}, error) {
+	var top map[string]interface{}
+	var err error
+	if format == formatTOML {
+		_, err = toml.DecodeReader(r, &top)
+		if err != nil {
+			err = xerrors.Errorf("toml decoding: %v", err)
+		}
+	} else {
+		top, err = decodeGeneric(r, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sections, ok := top["Config"].(map[string]interface{})
+	if !ok && top["Config"] != nil {
+		return nil, xerrors.New("Config is not a table of sections")
+	}
+	return sections, nil
+}
+
+// reloadConfigSections is ReloadConfigFile for the YAML and JSON files.
+func reloadConfigSections(server *onet.Server, file, format string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, xerrors.Errorf("opening config file: %v", err)
+	}
+	defer f.Close()
+	sections, err := configSections(f, format)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := server.ReloadConfig(func(name string, cfg interface{}) (bool, error) {
+		section, ok := sections[name]
+		if !ok {
+			return false, nil
+		}
+		buf, err := json.Marshal(section)
+		if err != nil {
+			return false, xerrors.Errorf("json encoding: %v", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return false, xerrors.Errorf("%s decoding: %v", format, strings.TrimPrefix(err.Error(), "json: "))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reloading: %v", err)
+	}
+	return updated, nil
+}
+
+// missing returns an error with the names of the required fields that are
+// empty, or nil if they are all set.
+func missing(where string, fields map[string]string) error {
+	var names []string
+	for name, value := range fields {
+		if value == "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return xerrors.Errorf("%s: missing required fields: %s", where, strings.Join(names, ", "))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const privateYAML = `
+Suite: Ed25519
+Public: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+Private: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+Address: tcp://1.2.3.4:1234
+ListenAddress: 127.0.0.1:0
+WebSocketAllowedOrigins: [https://a.org]
+ClientLimits: {Rate: 10, Burst: 20}
+Config:
+  tuning: {Limit: 5}
+`
+
+const privateJSON = `{
+	"Suite": "Ed25519",
+	"Public": "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4",
+	"Private": "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4",
+	"Address": "tcp://1.2.3.4:1234",
+	"ListenAddress": "127.0.0.1:0",
+	"WebSocketAllowedOrigins": ["https://a.org"],
+	"ClientLimits": {"Rate": 10, "Burst": 20},
+	"Config": {"tuning": {"Limit": 5}}
+}`
+
+// TestLoadCothority_formats checks that the YAML and JSON config files are
+// read as the TOML ones, with their reloadable sections.
+func TestLoadCothority_formats(t *testing.T) {
+	var applied []int
+	onet.RegisterNewService("OnetFormatTestService", func(c *onet.Context) (onet.Service, error) {
+		err := c.RegisterConfigSection(onet.ConfigSection{
+			Name: "tuning",
+			New:  func() interface{} { return &reloadTestConfig{} },
+			Apply: func(cfg interface{}) {
+				applied = append(applied, cfg.(*reloadTestConfig).Limit)
+			},
+		})
+		return nil, err
+	})
+	defer onet.UnregisterService("OnetFormatTestService")
+
+	tmp, err := ioutil.TempDir("", "formats")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	for name, content := range map[string]string{"private.yaml": privateYAML, "private.json": privateJSON} {
+		file := path.Join(tmp, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+		hc, srv, err := ParseCothority(file)
+		require.NoError(t, err, name)
+		require.Equal(t, network.NewTCPAddress("1.2.3.4:1234"), hc.Address)
+		require.Equal(t, []string{"https://a.org"}, hc.WebSocketAllowedOrigins)
+		require.Equal(t, 10.0, hc.ClientLimits.Rate)
+		require.Equal(t, 20, hc.ClientLimits.Burst)
+
+		applied = nil
+		updated, err := ReloadConfigFile(srv, file)
+		require.NoError(t, err, name)
+		require.Equal(t, []string{"tuning"}, updated)
+		require.Equal(t, []int{5}, applied)
+		require.NoError(t, srv.Close())
+	}
+
+	file := path.Join(tmp, "private.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("Config:\n  tuning: {Limit: 5, Limt: 6}\n"), 0600))
+	_, srv, err := ParseCothority(path.Join(tmp, "private.json"))
+	require.NoError(t, err)
+	defer srv.Close()
+	_, err = ReloadConfigFile(srv, file)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown field "Limt"`)
+}
+
+// TestLoadCothority_strict checks that the typos in the config files are
+// errors instead of being ignored.
+func TestLoadCothority_strict(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "strict")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	const keys = `Public = "6a92"
+Private = "6a92"
+Address = "tcp://1.2.3.4:1234"
+`
+	for name, c := range map[string]struct{ content, msg string }{
+		"unknown.toml": {keys + "Adress = \"tcp://1.2.3.4:1234\"\n[Services.abc]\nSuit = \"Ed25519\"\n",
+			"unknown keys: Adress, Services.abc.Suit"},
+		"type.toml":     {keys + "MaxTrees = \"many\"\n", "toml decoding"},
+		"missing.toml":  {"Address = \"tcp://1.2.3.4:1234\"\n", "missing required fields: Private, Public"},
+		"unknown.yaml":  {"Public: 6a92\nPrivate: 6a92\nAddress: tcp://1.2.3.4:1234\nLisenAddress: x\n", `unknown field "LisenAddress"`},
+		"type.yaml":     {"Public: 6a92\nPrivate: 6a92\nAddress: tcp://1.2.3.4:1234\nMaxTrees: many\n", "field CothorityConfig.MaxTrees of type int"},
+		"missing.yaml":  {"Public: 6a92\n", "missing required fields: Address, Private"},
+		"list.yaml":     {"- Public\n", "not a table of keys"},
+		"unknown.json":  {`{"Public": "6a92", "Private": "6a92", "Address": "tcp://1.2.3.4:1234", "Storag": "bbolt"}`, `unknown field "Storag"`},
+		"syntax.json":   {`{"Public": "6a92",}`, "json decoding"},
+		"services.json": {`{"Public": "6a92", "Private": "6a92", "Address": "tcp://1.2.3.4:1234", "Services": {"abc": {"Suit": "x"}}}`, `unknown field "Suit"`},
+	} {
+		file := path.Join(tmp, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(c.content), 0600))
+		_, err := LoadCothority(file)
+		require.Error(t, err, name)
+		require.Contains(t, err.Error(), c.msg, name)
+	}
+}
+
+func TestReadGroupDescFile(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	tmp, err := ioutil.TempDir("", "group")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	file := path.Join(tmp, "public.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+Description: Test group
+servers:
+  - Address: tcp://5.135.161.91:2000
+    Public: 94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65
+    Description: First
+  - Address: tcp://185.26.156.40:61117
+    Suite: Ed25519
+    Public: 6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4
+    URL: https://example.com/conode
+`), 0600))
+	group, err := ReadGroupDescFile(file, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(group.Roster.List))
+	require.Equal(t, network.NewTCPAddress("5.135.161.91:2000"), group.Roster.List[0].Address)
+	require.Equal(t, "First", group.Description[group.Roster.List[0]])
+	require.Equal(t, "https://example.com/conode", group.Roster.List[1].URL)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("servers:\n  - Address: tcp://5.135.161.91:2000\n"), 0600))
+	_, err = ReadGroupDescFile(file, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server 1: missing required fields: Public")
+
+	file = path.Join(tmp, "public.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("[[servers]]\nAdress = \"tcp://5.135.161.91:2000\"\n"), 0600))
+	_, err = ReadGroupDescFile(file, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown keys: servers.Adress")
+}
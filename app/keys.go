@@ -0,0 +1,691 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/cfgpath"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/xerrors"
+)
+
+// PassphraseEnv is the environment variable with the passphrase of the
+// private keys of an encrypted config file. Without it, the passphrase is
+// asked for.
+const PassphraseEnv = "CONODE_PASSPHRASE"
+
+// encryptedPrefix starts the private keys encrypted with a passphrase, which
+// are followed by the hex-encoded salt, nonce and sealed key.
+const encryptedPrefix = "scrypt:"
+
+// pemKeyType is the type of the PEM blocks of the exported private keys.
+const pemKeyType = "CONODE PRIVATE KEY"
+
+// The parameters of scrypt, as recommended for interactive logins.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// SetPassphrase has the private keys of the conode and of its services
+// encrypted with the passphrase when the config is saved, or in clear if it
+// is empty. LoadCothority sets the one the keys were encrypted with.
+func (hc *CothorityConfig) SetPassphrase(passphrase string) {
+	hc.passphrase = passphrase
+}
+
+// Encrypted returns whether the private keys are encrypted, as in a file
+// saved with a passphrase.
+func (hc *CothorityConfig) Encrypted() bool {
+	if strings.HasPrefix(hc.Private, encryptedPrefix) {
+		return true
+	}
+	for _, sc := range hc.Services {
+		if strings.HasPrefix(sc.Private, encryptedPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptKeys decrypts the private keys with the passphrase, and keeps it
+// to encrypt them again when the config is saved.
+func (hc *CothorityConfig) decryptKeys(passphrase string) error {
+	var err error
+	if hc.Private, err = decryptKey(hc.Private, passphrase); err != nil {
+		return err
+	}
+	for name, sc := range hc.Services {
+		if sc.Private, err = decryptKey(sc.Private, passphrase); err != nil {
+			return xerrors.Errorf("service %s: %v", name, err)
+		}
+		hc.Services[name] = sc
+	}
+	hc.passphrase = passphrase
+	return nil
+}
+
+// encrypted returns a copy of the config with the private keys encrypted
+// with its passphrase.
+func (hc *CothorityConfig) encrypted() (*CothorityConfig, error) {
+	c := *hc
+	var err error
+	if c.Private, err = encryptKey(hc.Private, hc.passphrase); err != nil {
+		return nil, err
+	}
+	c.Services = make(map[string]ServiceConfig, len(hc.Services))
+	for name, sc := range hc.Services {
+		if sc.Private, err = encryptKey(sc.Private, hc.passphrase); err != nil {
+			return nil, err
+		}
+		c.Services[name] = sc
+	}
+	return &c, nil
+}
+
+// encryptKey returns the private key sealed with a key derived from the
+// passphrase.
+func encryptKey(private, passphrase string) (string, error) {
+	if private == "" || strings.HasPrefix(private, encryptedPrefix) {
+		return private, nil
+	}
+	sealed, err := seal([]byte(private), passphrase)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + hex.EncodeToString(sealed), nil
+}
+
+// decryptKey returns the private key encrypted by encryptKey, or the key if
+// it isn't encrypted.
+func decryptKey(private, passphrase string) (string, error) {
+	if !strings.HasPrefix(private, encryptedPrefix) {
+		return private, nil
+	}
+	sealed, err := hex.DecodeString(strings.TrimPrefix(private, encryptedPrefix))
+	if err != nil {
+		return "", xerrors.Errorf("encrypted key: %v", err)
+	}
+	plain, err := open(sealed, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// seal returns the salt, the nonce and the data sealed with AES-GCM by a key
+// derived from the passphrase with scrypt.
+func seal(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, xerrors.Errorf("salt: %v", err)
+	}
+	aead, err := passphraseAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, xerrors.Errorf("nonce: %v", err)
+	}
+	return aead.Seal(append(salt, nonce...), nonce, data, nil), nil
+}
+
+// open returns the data sealed by seal.
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	if len(sealed) < scryptSaltLen {
+		return nil, xerrors.New("encrypted key too short")
+	}
+	aead, err := passphraseAEAD(passphrase, sealed[:scryptSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[scryptSaltLen:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, xerrors.New("encrypted key too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, xerrors.New("wrong passphrase")
+	}
+	return plain, nil
+}
+
+func passphraseAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	k, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, xerrors.Errorf("scrypt: %v", err)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, xerrors.Errorf("cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// askPassphrase returns the passphrase of PassphraseEnv, or asks for it
+// without echoing it if the input is a terminal.
+func askPassphrase(prompt string) (string, error) {
+	if p, ok := os.LookupEnv(PassphraseEnv); ok {
+		return p, nil
+	}
+	fmt.Fprint(out, prompt+": ")
+	if in.Buffered() == 0 && terminal.IsTerminal(int(os.Stdin.Fd())) {
+		p, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", xerrors.Errorf("reading passphrase: %v", err)
+		}
+		return string(p), nil
+	}
+	p, err := in.ReadString('\n')
+	if err != nil {
+		return "", xerrors.Errorf("reading passphrase: %v", err)
+	}
+	return strings.TrimRight(p, "\r\n"), nil
+}
+
+// ExportKeys returns the private keys of the conode and of its services as
+// PEM blocks, with the suite and the service of each key in their headers.
+// The keys are sealed with the passphrase if it isn't empty.
+func (hc *CothorityConfig) ExportKeys(passphrase string) ([]byte, error) {
+	var buf bytes.Buffer
+	export := func(service, suiteName, private string) error {
+		suite, err := suites.Find(suiteName)
+		if err != nil {
+			return xerrors.Errorf("kyber suite: %v", err)
+		}
+		scalar, err := encoding.StringHexToScalar(suite, private)
+		if err != nil {
+			return xerrors.Errorf("private key: %v", err)
+		}
+		data, err := scalar.MarshalBinary()
+		if err != nil {
+			return xerrors.Errorf("marshaling: %v", err)
+		}
+		block := &pem.Block{Type: pemKeyType, Headers: map[string]string{"Suite": suiteName}}
+		if service != "" {
+			block.Headers["Service"] = service
+		}
+		if passphrase != "" {
+			if data, err = seal(data, passphrase); err != nil {
+				return err
+			}
+			block.Headers["Encryption"] = "scrypt-aes-gcm"
+		}
+		block.Bytes = data
+		return pem.Encode(&buf, block)
+	}
+	if err := export("", hc.Suite, hc.Private); err != nil {
+		return nil, err
+	}
+	for _, name := range sortedServices(hc.Services) {
+		sc := hc.Services[name]
+		if err := export(name, sc.Suite, sc.Private); err != nil {
+			return nil, xerrors.Errorf("service %s: %v", name, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ImportKeys replaces the key pairs of the conode and of its services with
+// the private keys of the PEM blocks of ExportKeys, opened with the
+// passphrase if they are sealed.
+func (hc *CothorityConfig) ImportKeys(data []byte, passphrase string) error {
+	var found bool
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != pemKeyType {
+			continue
+		}
+		raw := block.Bytes
+		if block.Headers["Encryption"] != "" {
+			var err error
+			if raw, err = open(raw, passphrase); err != nil {
+				return err
+			}
+		}
+		suiteName := block.Headers["Suite"]
+		suite, err := suites.Find(suiteName)
+		if err != nil {
+			return xerrors.Errorf("kyber suite: %v", err)
+		}
+		scalar := suite.Scalar()
+		if err := scalar.UnmarshalBinary(raw); err != nil {
+			return xerrors.Errorf("private key: %v", err)
+		}
+		private, public, err := keyStrings(suite, scalar)
+		if err != nil {
+			return err
+		}
+		if service := block.Headers["Service"]; service != "" {
+			if hc.Services == nil {
+				hc.Services = make(map[string]ServiceConfig)
+			}
+			hc.Services[service] = ServiceConfig{Suite: suiteName, Public: public, Private: private}
+		} else {
+			hc.Suite, hc.Public, hc.Private = suiteName, public, private
+			found = true
+		}
+	}
+	if !found {
+		return xerrors.New("no key of the conode")
+	}
+	return nil
+}
+
+// keyStrings returns the hex-encoded private and public keys.
+func keyStrings(suite suites.Suite, private kyber.Scalar) (string, string, error) {
+	priv, err := encoding.ScalarToStringHex(suite, private)
+	if err != nil {
+		return "", "", xerrors.Errorf("encoding private key: %v", err)
+	}
+	pub, err := encoding.PointToStringHex(suite, suite.Point().Mul(private, nil))
+	if err != nil {
+		return "", "", xerrors.Errorf("encoding public key: %v", err)
+	}
+	return priv, pub, nil
+}
+
+// KeyRotation announces the new key of a conode to the operators of its
+// rosters. It is signed by the old key, to show it comes from the conode,
+// and by the new one, to show the conode holds it.
+type KeyRotation struct {
+	Suite        string
+	Address      network.Address
+	OldPublic    string
+	NewPublic    string
+	Time         time.Time
+	OldSignature string
+	NewSignature string
+}
+
+// message returns what the keys sign.
+func (kr *KeyRotation) message() []byte {
+	return []byte(fmt.Sprintf("onet key rotation\n%s\n%s\n%s\n%s\n%d",
+		kr.Suite, kr.Address, kr.OldPublic, kr.NewPublic, kr.Time.Unix()))
+}
+
+// Verify checks the signatures of the announcement.
+func (kr *KeyRotation) Verify() error {
+	suite, err := suites.Find(kr.Suite)
+	if err != nil {
+		return xerrors.Errorf("kyber suite: %v", err)
+	}
+	for _, k := range []struct{ name, public, sig string }{
+		{"old", kr.OldPublic, kr.OldSignature},
+		{"new", kr.NewPublic, kr.NewSignature},
+	} {
+		pub, err := encoding.StringHexToPoint(suite, k.public)
+		if err != nil {
+			return xerrors.Errorf("%s key: %v", k.name, err)
+		}
+		sig, err := hex.DecodeString(k.sig)
+		if err != nil {
+			return xerrors.Errorf("%s signature: %v", k.name, err)
+		}
+		if err := schnorr.Verify(suite, pub, kr.message(), sig); err != nil {
+			return xerrors.Errorf("%s signature: %v", k.name, err)
+		}
+	}
+	return nil
+}
+
+// Apply verifies the announcement and replaces the old key of the conode in
+// the roster by the new one.
+func (kr *KeyRotation) Apply(gt *GroupToml) error {
+	if err := kr.Verify(); err != nil {
+		return err
+	}
+	for _, s := range gt.Servers {
+		if s.Public == kr.OldPublic {
+			s.Public = kr.NewPublic
+			s.Suite = kr.Suite
+			return nil
+		}
+	}
+	return xerrors.Errorf("no conode with the key %s in the roster", kr.OldPublic)
+}
+
+// Save writes the announcement to the file, in TOML.
+func (kr *KeyRotation) Save(file string) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(kr); err != nil {
+		return xerrors.Errorf("toml encoding: %v", err)
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// MoveDatabase renames the database of the conode in dataDir after its new
+// key, if there is one. It is called once the config with the new key is
+// saved, so that the database is never named after a key the config
+// doesn't have.
+func (kr *KeyRotation) MoveDatabase(dataDir string) error {
+	if dataDir == "" {
+		return nil
+	}
+	suite, err := suites.Find(kr.Suite)
+	if err != nil {
+		return xerrors.Errorf("kyber suite: %v", err)
+	}
+	old, err := encoding.StringHexToPoint(suite, kr.OldPublic)
+	if err != nil {
+		return xerrors.Errorf("old key: %v", err)
+	}
+	public, err := encoding.StringHexToPoint(suite, kr.NewPublic)
+	if err != nil {
+		return xerrors.Errorf("new key: %v", err)
+	}
+	from := onet.DatabaseFile(dataDir, old)
+	if _, err := os.Stat(from); err != nil {
+		return nil
+	}
+	if err := os.Rename(from, onet.DatabaseFile(dataDir, public)); err != nil {
+		return xerrors.Errorf("renaming database: %v", err)
+	}
+	return nil
+}
+
+// ReadKeyRotation reads the announcement of a key rotation, in TOML, YAML
+// or JSON after the extension of the file.
+func ReadKeyRotation(file string) (*KeyRotation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, xerrors.Errorf("opening announcement: %v", err)
+	}
+	defer f.Close()
+	kr := &KeyRotation{}
+	if err := decodeStrict(f, fileFormat(file), kr); err != nil {
+		return nil, xerrors.Errorf("%s: %v", file, err)
+	}
+	return kr, nil
+}
+
+// RotateKey gives the conode a new key pair, and returns the announcement
+// for the operators of its rosters. The services keep their keys. If the
+// storage is encrypted with the key of the conode, the old one is kept in
+// OldStorageKeys. The config and the announcement must then be saved, the
+// database moved with MoveDatabase, and the conode restarted.
+func (hc *CothorityConfig) RotateKey() (*KeyRotation, error) {
+	suite, err := suites.Find(hc.Suite)
+	if err != nil {
+		return nil, xerrors.Errorf("kyber suite: %v", err)
+	}
+	old, err := encoding.StringHexToScalar(suite, hc.Private)
+	if err != nil {
+		return nil, xerrors.Errorf("private key: %v", err)
+	}
+	kp := key.NewKeyPair(suite)
+	private, public, err := keyStrings(suite, kp.Private)
+	if err != nil {
+		return nil, err
+	}
+	_, oldPublic, err := keyStrings(suite, old)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := &KeyRotation{
+		Suite:     hc.Suite,
+		Address:   hc.Address,
+		OldPublic: oldPublic,
+		NewPublic: public,
+		Time:      time.Now().UTC().Truncate(time.Second),
+	}
+	for _, k := range []struct {
+		private kyber.Scalar
+		sig     *string
+	}{{old, &kr.OldSignature}, {kp.Private, &kr.NewSignature}} {
+		sig, err := schnorr.Sign(suite, k.private, kr.message())
+		if err != nil {
+			return nil, xerrors.Errorf("signing: %v", err)
+		}
+		*k.sig = hex.EncodeToString(sig)
+	}
+
+	if hc.StorageKey == "conode" {
+		storageKey, err := onet.DeriveStorageKey(old)
+		if err != nil {
+			return nil, xerrors.Errorf("storage key: %v", err)
+		}
+		hc.OldStorageKeys = append(hc.OldStorageKeys, hex.EncodeToString(storageKey))
+	}
+	hc.Public, hc.Private = public, private
+	return kr, nil
+}
+
+// KeyCommand runs one of the commands managing the keys of a conode, for
+// the binaries offering them to the operators, with the output in out:
+//
+// generate [-suite Ed25519] [-address tls://host:port] [-description text]
+// [-encrypt] dir writes a new private.toml and public.toml in dir.
+// encrypt private.toml and decrypt private.toml protect the private keys
+// with a passphrase, or remove it.
+// export [-o keys.pem] [-encrypt] private.toml writes the private keys as
+// PEM blocks, sealed with a passphrase with -encrypt.
+// import keys.pem private.toml replaces the keys of the config.
+// rotate [-o rotation.toml] [-db dir] private.toml gives the conode a new
+// key and writes its announcement.
+// apply rotation.toml roster.toml updates the roster with the announcement.
+//
+// The passphrases are the one of CONODE_PASSPHRASE, or asked for.
+func KeyCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return xerrors.New("missing command: generate, encrypt, decrypt, export, import, rotate or apply")
+	}
+	fs := flag.NewFlagSet("key "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	suiteName := fs.String("suite", "Ed25519", "suite of the new key pair")
+	address := fs.String("address", "tls://127.0.0.1:7770", "address of the new conode")
+	description := fs.String("description", "New conode", "description of the new conode")
+	encrypt := fs.Bool("encrypt", false, "encrypt the keys with a passphrase")
+	output := fs.String("o", "", "file to write to")
+	dataDir := fs.String("db", "", "directory of the database of the conode")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	files := fs.Args()
+	need := func(n int, what string) error {
+		if len(files) != n {
+			return xerrors.Errorf("%s needs %s", args[0], what)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "generate":
+		if err := need(1, "a directory"); err != nil {
+			return err
+		}
+		return generateConfig(files[0], *suiteName, network.Address(*address), *description, *encrypt)
+	case "encrypt", "decrypt":
+		if err := need(1, "a private.toml"); err != nil {
+			return err
+		}
+		hc, err := loadCothority(files[0], false, nil)
+		if err != nil {
+			return err
+		}
+		passphrase := ""
+		if args[0] == "encrypt" {
+			if passphrase, err = askPassphrase("New passphrase"); err != nil {
+				return err
+			}
+		}
+		hc.SetPassphrase(passphrase)
+		return hc.Save(files[0])
+	case "export":
+		if err := need(1, "a private.toml"); err != nil {
+			return err
+		}
+		hc, err := LoadCothority(files[0])
+		if err != nil {
+			return err
+		}
+		passphrase := ""
+		if *encrypt {
+			if passphrase, err = askPassphrase("Passphrase of the exported keys"); err != nil {
+				return err
+			}
+		}
+		data, err := hc.ExportKeys(passphrase)
+		if err != nil {
+			return err
+		}
+		if *output == "" {
+			_, err = out.Write(data)
+			return err
+		}
+		return ioutil.WriteFile(*output, data, 0600)
+	case "import":
+		if err := need(2, "the keys and a private.toml"); err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(files[0])
+		if err != nil {
+			return xerrors.Errorf("reading keys: %v", err)
+		}
+		hc, err := loadCothority(files[1], false, nil)
+		if err != nil {
+			return err
+		}
+		passphrase := ""
+		if bytes.Contains(data, []byte("Encryption:")) {
+			if passphrase, err = askPassphrase("Passphrase of the imported keys"); err != nil {
+				return err
+			}
+		}
+		if err := hc.ImportKeys(data, passphrase); err != nil {
+			return err
+		}
+		return hc.Save(files[1])
+	case "rotate":
+		if err := need(1, "a private.toml"); err != nil {
+			return err
+		}
+		hc, err := loadCothority(files[0], false, nil)
+		if err != nil {
+			return err
+		}
+		dir := *dataDir
+		if dir == "" {
+			if dir = os.Getenv("CONODE_SERVICE_PATH"); dir == "" {
+				dir = cfgpath.GetDataPath("conode")
+			}
+		}
+		kr, err := hc.RotateKey()
+		if err != nil {
+			return err
+		}
+		announce := *output
+		if announce == "" {
+			announce = path.Join(path.Dir(files[0]), "rotation.toml")
+		}
+		if err := kr.Save(announce); err != nil {
+			return err
+		}
+		if err := hc.Save(files[0]); err != nil {
+			return err
+		}
+		if err := kr.MoveDatabase(dir); err != nil {
+			return xerrors.Errorf("the config has the new key, but %v", err)
+		}
+		fmt.Fprintln(out, "New public key", kr.NewPublic+", announcement in", announce)
+		return nil
+	case "apply":
+		if err := need(2, "the announcement and a roster"); err != nil {
+			return err
+		}
+		kr, err := ReadKeyRotation(files[0])
+		if err != nil {
+			return err
+		}
+		gt, err := ReadGroupToml(files[1])
+		if err != nil {
+			return err
+		}
+		if err := kr.Apply(gt); err != nil {
+			return err
+		}
+		return gt.Save(files[1])
+	}
+	return xerrors.Errorf("unknown command %s", args[0])
+}
+
+// generateConfig writes the private.toml and public.toml of a new conode in
+// the directory.
+func generateConfig(dir, suiteName string, addr network.Address, desc string, encrypt bool) error {
+	suite, err := suites.Find(suiteName)
+	if err != nil {
+		return xerrors.Errorf("kyber suite: %v", err)
+	}
+	if !addr.Valid() {
+		return xerrors.Errorf("invalid address %s", addr)
+	}
+	kp := key.NewKeyPair(suite)
+	private, public, err := keyStrings(suite, kp.Private)
+	if err != nil {
+		return err
+	}
+	services := GenerateServiceKeyPairs()
+	hc := &CothorityConfig{
+		Suite:       suite.String(),
+		Public:      public,
+		Private:     private,
+		Address:     addr,
+		Services:    services,
+		Description: desc,
+	}
+	if encrypt {
+		passphrase, err := askPassphrase("Passphrase of the keys")
+		if err != nil {
+			return err
+		}
+		hc.SetPassphrase(passphrase)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return xerrors.Errorf("creating directory: %v", err)
+	}
+	if err := hc.Save(path.Join(dir, DefaultServerConfig)); err != nil {
+		return err
+	}
+	return NewGroupToml(NewServerToml(suite, kp.Public, addr, desc, services)).
+		Save(path.Join(dir, DefaultGroupFile))
+}
+
+func sortedServices(services map[string]ServiceConfig) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/onet/v4"
+)
+
+// TestKeyCommand_encrypt checks that the private keys of an encrypted
+// config are never written in clear, and that they are decrypted when the
+// config is loaded.
+func TestKeyCommand_encrypt(t *testing.T) {
+	registerService()
+	defer unregisterService()
+	tmp, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	defer setenv(t, PassphraseEnv, "correct horse")()
+
+	var o bytes.Buffer
+	require.NoError(t, KeyCommand([]string{"generate", "-encrypt", "-address", "tcp://127.0.0.1:7770", tmp}, &o))
+	file := path.Join(tmp, DefaultServerConfig)
+	requireEncrypted(t, file, true)
+
+	hc, err := LoadCothority(file)
+	require.NoError(t, err)
+	require.False(t, hc.Encrypted())
+	_, err = hc.GetServerIdentity()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(hc.Services))
+	hc.Description = "changed"
+	require.NoError(t, hc.Save(file))
+	requireEncrypted(t, file, true)
+
+	group, err := ReadGroupDescFile(path.Join(tmp, DefaultGroupFile), nil)
+	require.NoError(t, err)
+	require.Equal(t, hc.Public, group.Roster.List[0].Public.String())
+
+	os.Setenv(PassphraseEnv, "wrong")
+	_, err = LoadCothority(file)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "wrong passphrase")
+
+	os.Setenv(PassphraseEnv, "correct horse")
+	require.NoError(t, KeyCommand([]string{"decrypt", file}, &o))
+	requireEncrypted(t, file, false)
+	again, err := LoadCothority(file)
+	require.NoError(t, err)
+	require.Equal(t, hc.Private, again.Private)
+	require.Equal(t, "changed", again.Description)
+
+	require.NoError(t, KeyCommand([]string{"encrypt", file}, &o))
+	requireEncrypted(t, file, true)
+}
+
+func requireEncrypted(t *testing.T, file string, encrypted bool) {
+	buf, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, encrypted, strings.Count(string(buf), encryptedPrefix) == 2, string(buf))
+}
+
+func TestKeyCommand_exportImport(t *testing.T) {
+	registerService()
+	defer unregisterService()
+	tmp, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	var o bytes.Buffer
+	require.NoError(t, KeyCommand([]string{"generate", tmp}, &o))
+	file := path.Join(tmp, DefaultServerConfig)
+	hc, err := LoadCothority(file)
+	require.NoError(t, err)
+
+	for _, encrypt := range []bool{false, true} {
+		keys := path.Join(tmp, "keys.pem")
+		args := []string{"export", "-o", keys, file}
+		if encrypt {
+			args = []string{"export", "-encrypt", "-o", keys, file}
+			defer setenv(t, PassphraseEnv, "export pass")()
+		}
+		require.NoError(t, KeyCommand(args, &o))
+		data, err := ioutil.ReadFile(keys)
+		require.NoError(t, err)
+		require.Equal(t, 2, strings.Count(string(data), "BEGIN "+pemKeyType))
+		require.Equal(t, encrypt, strings.Contains(string(data), "Encryption: scrypt-aes-gcm"))
+
+		other := &CothorityConfig{Address: hc.Address}
+		passphrase := ""
+		if encrypt {
+			passphrase = "export pass"
+			require.Error(t, other.ImportKeys(data, "wrong"))
+		}
+		require.NoError(t, other.ImportKeys(data, passphrase))
+		require.Equal(t, hc.Suite, other.Suite)
+		require.Equal(t, hc.Public, other.Public)
+		require.Equal(t, hc.Private, other.Private)
+		require.Equal(t, hc.Services, other.Services)
+	}
+	require.Error(t, (&CothorityConfig{}).ImportKeys([]byte("no keys"), ""))
+}
+
+func TestKeyCommand_rotate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	var o bytes.Buffer
+	require.NoError(t, KeyCommand([]string{"generate", "-address", "tcp://127.0.0.1:7770", tmp}, &o))
+	file := path.Join(tmp, DefaultServerConfig)
+	hc, err := LoadCothority(file)
+	require.NoError(t, err)
+	hc.StorageKey = "conode"
+	require.NoError(t, hc.Save(file))
+	// the sections of the services are kept
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString("\n[Config.x]\n  Value = 3\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	oldSI, err := hc.GetServerIdentity()
+	require.NoError(t, err)
+	oldStorageKey, err := onet.DeriveStorageKey(oldSI.GetPrivate())
+	require.NoError(t, err)
+
+	db := path.Join(tmp, "db")
+	require.NoError(t, os.MkdirAll(db, 0750))
+	require.NoError(t, ioutil.WriteFile(onet.DatabaseFile(db, oldSI.Public), []byte("data"), 0600))
+
+	// the overrides of the environment aren't saved
+	defer setenv(t, "CONODE_DESCRIPTION", "from the environment")()
+	announce := path.Join(tmp, "rotation.toml")
+	require.NoError(t, KeyCommand([]string{"rotate", "-db", db, "-o", announce, file}, &o))
+	saved, err := loadCothority(file, false, nil)
+	require.NoError(t, err)
+	require.Equal(t, hc.Description, saved.Description)
+	require.Equal(t, map[string]interface{}{"x": map[string]interface{}{"Value": int64(3)}},
+		saved.sections)
+	rotated, err := LoadCothority(file)
+	require.NoError(t, err)
+	require.NotEqual(t, hc.Public, rotated.Public)
+	require.Equal(t, hc.Services, rotated.Services)
+	newSI, err := rotated.GetServerIdentity()
+	require.NoError(t, err)
+	storage, err := rotated.StorageConfig(newSI)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{oldStorageKey}, storage.OldKeys)
+	data, err := ioutil.ReadFile(onet.DatabaseFile(db, newSI.Public))
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+
+	kr, err := ReadKeyRotation(announce)
+	require.NoError(t, err)
+	require.NoError(t, kr.Verify())
+	require.Equal(t, hc.Public, kr.OldPublic)
+	require.Equal(t, rotated.Public, kr.NewPublic)
+
+	roster := path.Join(tmp, DefaultGroupFile)
+	require.NoError(t, KeyCommand([]string{"apply", announce, roster}, &o))
+	gt, err := ReadGroupToml(roster)
+	require.NoError(t, err)
+	require.Equal(t, rotated.Public, gt.Servers[0].Public)
+	require.Error(t, kr.Apply(gt))
+
+	// an announcement for another key isn't valid
+	suite := suites.MustFind("Ed25519")
+	other, err := encoding.StringHexToPoint(suite, rosterPub1)
+	require.NoError(t, err)
+	kr.NewPublic = other.String()
+	require.Error(t, kr.Verify())
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// fields of private.toml, named after the fields, like
+// CONODE_WEB_SOCKET_LISTEN_ADDRESS for WebSocketListenAddress.
+const EnvPrefix = "CONODE_"
+
+// GroupEnvPrefix is the prefix of the environment variables overriding the
+// fields of public.toml, like CONODE_GROUP_SERVERS.
+const GroupEnvPrefix = "CONODE_GROUP_"
+
+// ConfigFlags are the command-line flags overriding the fields of a
+// configuration file, one for every field, named after it like
+// -web-socket-listen-address for WebSocketListenAddress. A field is first
+// read from the file, then from its environment variable if it is set, and
+// then from its flag if it is given.
+//
+// The values are the ones of the file: a string as it is, a number, a list
+// of strings separated by commas, or any TOML value, like
+// {Rate = 10.0, Burst = 20} for the ClientLimits.
+type ConfigFlags struct {
+	fs *flag.FlagSet
+	// fields are the names of the fields of the flags.
+	fields map[string]string
+}
+
+// RegisterConfigFlags adds the flags of the fields of the configuration
+// structure to the flag set, like &CothorityConfig{}, with the prefix in
+// front of their names.
+func RegisterConfigFlags(fs *flag.FlagSet, prefix string, cfg interface{}) *ConfigFlags {
+	cf := &ConfigFlags{fs: fs, fields: make(map[string]string)}
+	forFields(cfg, func(name string, _ reflect.Value) error {
+		flagName := prefix + strings.ToLower(strings.Replace(snakeCase(name), "_", "-", -1))
+		fs.String(flagName, "", "overrides "+name+" of the configuration")
+		cf.fields[flagName] = name
+		return nil
+	})
+	return cf
+}
+
+// set returns the values of the fields whose flag is given in the
+// command-line.
+func (cf *ConfigFlags) set() map[string]string {
+	set := make(map[string]string)
+	cf.fs.Visit(func(f *flag.Flag) {
+		if name, ok := cf.fields[f.Name]; ok {
+			set[name] = f.Value.String()
+		}
+	})
+	return set
+}
+
+// Override replaces the fields of the configuration structure by their
+// environment variable, with the prefix in front of their names, and then by
+// their flag if flags is not nil.
+func Override(cfg interface{}, envPrefix string, flags *ConfigFlags) error {
+	var set map[string]string
+	if flags != nil {
+		set = flags.set()
+	}
+	return forFields(cfg, func(name string, v reflect.Value) error {
+		if s, ok := os.LookupEnv(envPrefix + snakeCase(name)); ok {
+			if err := setField(v, s); err != nil {
+				return xerrors.Errorf("%s%s: %v", envPrefix, snakeCase(name), err)
+			}
+		}
+		if s, ok := set[name]; ok {
+			if err := setField(v, s); err != nil {
+				return xerrors.Errorf("flag of %s: %v", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// forFields calls f with the name in the file and the value of every field
+// of the structure cfg points to.
+func forFields(cfg interface{}, f func(name string, v reflect.Value) error) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("toml"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if err := f(name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setField sets the field to the value given as a string.
+func setField(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return xerrors.Errorf("parsing: %v", err)
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return xerrors.Errorf("parsing: %v", err)
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return xerrors.Errorf("parsing: %v", err)
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return xerrors.Errorf("parsing: %v", err)
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			l := reflect.MakeSlice(v.Type(), 0, 0)
+			if s != "" {
+				for _, e := range strings.Split(s, ",") {
+					l = reflect.Append(l, reflect.ValueOf(strings.TrimSpace(e)).Convert(v.Type().Elem()))
+				}
+			}
+			v.Set(l)
+			return nil
+		}
+	}
+	var values map[string]toml.Primitive
+	md, err := toml.Decode("value = "+s, &values)
+	if err != nil {
+		return xerrors.Errorf("toml decoding: %v", err)
+	}
+	fresh := reflect.New(v.Type())
+	if err := md.PrimitiveDecode(values["value"], fresh.Interface()); err != nil {
+		return xerrors.Errorf("toml decoding: %v", err)
+	}
+	v.Set(fresh.Elem())
+	return nil
+}
+
+// snakeCase returns the name in upper snake case, like WEB_SOCKET_TLS_CERTIFICATE
+// for WebSocketTLSCertificate.
+func snakeCase(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestSnakeCase(t *testing.T) {
+	require.Equal(t, "WEB_SOCKET_TLS_CERTIFICATE", snakeCase("WebSocketTLSCertificate"))
+	require.Equal(t, "URL", snakeCase("URL"))
+	require.Equal(t, "GRPC_ADDRESS", snakeCase("GRPCAddress"))
+	require.Equal(t, "SERVERS", snakeCase("servers"))
+}
+
+// TestLoadCothorityWithFlags checks that the environment variables override
+// the file, and the flags override both.
+func TestLoadCothorityWithFlags(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "override")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	file := path.Join(tmp, "private.toml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"
+Private = "e1bbd2a1fb4bdb2f0fa4e3a2e6d7e3b2f5a0bd0bda5b2d3ae3b1c4e9d2c0f20a"
+Address = "tls://127.0.0.1:7770"
+Description = "from the file"
+URL = "https://example.com"
+MaxTrees = 10
+`), 0600))
+
+	defer setenv(t, "CONODE_DESCRIPTION", "from the environment")()
+	defer setenv(t, "CONODE_URL", "https://env.example.com")()
+	defer setenv(t, "CONODE_WEB_SOCKET_ALLOWED_ORIGINS", "https://a.org, https://b.org")()
+	defer setenv(t, "CONODE_WEB_SOCKET_UNIX_SOCKET_MODE", "0660")()
+	defer setenv(t, "CONODE_CLIENT_LIMITS", "{Rate = 10.0, Burst = 20}")()
+
+	fs := flag.NewFlagSet("conode", flag.ContinueOnError)
+	flags := RegisterConfigFlags(fs, "", &CothorityConfig{})
+	require.NoError(t, fs.Parse([]string{"-url", "https://flag.example.com",
+		"-max-trees", "20", "-address", "tcp://127.0.0.1:7772"}))
+
+	hc, err := LoadCothorityWithFlags(file, flags)
+	require.NoError(t, err)
+	require.Equal(t, "from the environment", hc.Description)
+	require.Equal(t, "https://flag.example.com", hc.URL)
+	require.Equal(t, 20, hc.MaxTrees)
+	require.Equal(t, network.NewTCPAddress("127.0.0.1:7772"), hc.Address)
+	require.Equal(t, []string{"https://a.org", "https://b.org"}, hc.WebSocketAllowedOrigins)
+	require.Equal(t, os.FileMode(0660), hc.WebSocketUnixSocketMode)
+	require.NotNil(t, hc.ClientLimits)
+	require.Equal(t, 10.0, hc.ClientLimits.Rate)
+	require.Equal(t, 20, hc.ClientLimits.Burst)
+	require.Equal(t, "Ed25519", hc.Suite)
+
+	hc, err = LoadCothority(file)
+	require.NoError(t, err)
+	require.Equal(t, "https://env.example.com", hc.URL)
+	require.Equal(t, 10, hc.MaxTrees)
+
+	defer setenv(t, "CONODE_MAX_TREES", "many")()
+	_, err = LoadCothority(file)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "CONODE_MAX_TREES")
+}
+
+func TestReadGroupDescTomlWithFlags(t *testing.T) {
+	registerService()
+	defer unregisterService()
+
+	defer setenv(t, "CONODE_GROUP_SERVERS", `[{Address = "tcp://127.0.0.1:2000", `+
+		`Public = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65", `+
+		`Description = "from the environment"}]`)()
+	group, err := ReadGroupDescToml(strings.NewReader(serverGroup))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(group.Roster.List))
+	require.Equal(t, network.NewTCPAddress("127.0.0.1:2000"), group.Roster.List[0].Address)
+	require.Equal(t, "from the environment", group.Description[group.Roster.List[0]])
+}
+
+// setenv sets the environment variable and returns the function restoring
+// it.
+func setenv(t *testing.T, key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
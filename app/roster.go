@@ -0,0 +1,479 @@
+package app
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// ReadGroupToml reads a group file, in TOML, YAML or JSON after its
+// extension, like the public.toml of a conode or a roster, without turning
+// it into the identities of a Group.
+func ReadGroupToml(file string) (*GroupToml, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, xerrors.Errorf("opening group file: %v", err)
+	}
+	defer f.Close()
+	gt := &GroupToml{}
+	if err := decodeStrict(f, fileFormat(file), gt); err != nil {
+		return nil, xerrors.Errorf("%s: %v", file, err)
+	}
+	return gt, nil
+}
+
+// CreateGroup returns the roster of the conodes of the public.toml files,
+// or of the rosters, given as files, as MergeGroups.
+func CreateGroup(files ...string) (*GroupToml, error) {
+	var groups []*GroupToml
+	for _, file := range files {
+		gt, err := ReadGroupToml(file)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, gt)
+	}
+	return MergeGroups(groups...)
+}
+
+// MergeGroups returns the roster with the conodes of all the groups, in the
+// order they come first. A conode in more than one group must have the same
+// entry in all of them, and two conodes can't have the same address. The
+// description of the roster is that of the first group having one.
+func MergeGroups(groups ...*GroupToml) (*GroupToml, error) {
+	merged := &GroupToml{}
+	byPublic := make(map[string]*ServerToml)
+	byAddress := make(map[network.Address]string)
+	for _, gt := range groups {
+		if merged.Description == "" {
+			merged.Description = gt.Description
+		}
+		for _, s := range gt.Servers {
+			if old, ok := byPublic[s.Public]; ok {
+				if changes := serverChanges(old, s); len(changes) > 0 {
+					return nil, xerrors.Errorf("conode %s has different %s",
+						s.Public, strings.Join(changes, ", "))
+				}
+				continue
+			}
+			if pub, ok := byAddress[s.Address]; ok {
+				return nil, xerrors.Errorf("conodes %s and %s have the same address %s",
+					pub, s.Public, s.Address)
+			}
+			byPublic[s.Public] = s
+			byAddress[s.Address] = s.Public
+			merged.Servers = append(merged.Servers, s)
+		}
+	}
+	return merged, nil
+}
+
+// Verify returns an error with all the problems of the entries of the
+// roster: missing fields, invalid addresses, unknown suites, keys that are
+// not points of their suite, and conodes that appear twice.
+func (gt *GroupToml) Verify() error {
+	var problems []string
+	publics := make(map[string]int)
+	addresses := make(map[network.Address]int)
+	for i, s := range gt.Servers {
+		where := fmt.Sprintf("server %d", i+1)
+		if err := missing(where, map[string]string{"Address": string(s.Address), "Public": s.Public}); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if !s.Address.Valid() {
+			problems = append(problems, fmt.Sprintf("%s: invalid address %s", where, s.Address))
+		}
+		if j, ok := publics[s.Public]; ok {
+			problems = append(problems, fmt.Sprintf("%s: same public key as server %d", where, j))
+		}
+		if j, ok := addresses[s.Address]; ok {
+			problems = append(problems, fmt.Sprintf("%s: same address as server %d", where, j))
+		}
+		publics[s.Public] = i + 1
+		addresses[s.Address] = i + 1
+		if _, err := serverIdentity(s); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+		}
+	}
+	if len(problems) > 0 {
+		return xerrors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// serverIdentity is ToServerIdentity, which defaults to Ed25519 as the old
+// group files, and returns an error instead of panicking for a service key
+// of the wrong suite.
+func serverIdentity(s *ServerToml) (si *network.ServerIdentity, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("service keys: %v", r)
+		}
+	}()
+	c := *s
+	if c.Suite == "" {
+		c.Suite = "Ed25519"
+	}
+	return c.ToServerIdentity()
+}
+
+// GroupDiff are the conodes added, removed and changed between two rosters,
+// told apart by their public keys.
+type GroupDiff struct {
+	Added   []*ServerToml
+	Removed []*ServerToml
+	Changed []ServerChange
+}
+
+// ServerChange is a conode whose entry changed, with the names of the
+// fields that changed.
+type ServerChange struct {
+	Before, After *ServerToml
+	Fields        []string
+}
+
+// DiffGroups returns the differences from the roster before to the roster
+// after.
+func DiffGroups(before, after *GroupToml) *GroupDiff {
+	d := &GroupDiff{}
+	olds := make(map[string]*ServerToml)
+	for _, s := range before.Servers {
+		olds[s.Public] = s
+	}
+	kept := make(map[string]bool)
+	for _, s := range after.Servers {
+		kept[s.Public] = true
+		o, ok := olds[s.Public]
+		if !ok {
+			d.Added = append(d.Added, s)
+		} else if changes := serverChanges(o, s); len(changes) > 0 {
+			d.Changed = append(d.Changed, ServerChange{o, s, changes})
+		}
+	}
+	for _, s := range before.Servers {
+		if !kept[s.Public] {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
+
+// Empty returns whether the rosters are the same.
+func (d *GroupDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a line for each conode added with +, removed with -, or
+// changed with ~.
+func (d *GroupDiff) String() string {
+	var b strings.Builder
+	for _, s := range d.Added {
+		fmt.Fprintf(&b, "+ %s %s %s\n", s.Public, s.Address, s.Description)
+	}
+	for _, s := range d.Removed {
+		fmt.Fprintf(&b, "- %s %s %s\n", s.Public, s.Address, s.Description)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "~ %s %s\n", c.After.Public, strings.Join(c.Fields, ", "))
+	}
+	return b.String()
+}
+
+// serverChanges returns the names of the fields that differ between the
+// entries of a conode, with their old and new values.
+func serverChanges(before, after *ServerToml) []string {
+	var changes []string
+	field := func(name, o, n string) {
+		if o != n {
+			changes = append(changes, fmt.Sprintf("%s %q -> %q", name, o, n))
+		}
+	}
+	field("Address", string(before.Address), string(after.Address))
+	field("Suite", defaultSuite(before.Suite), defaultSuite(after.Suite))
+	field("Description", before.Description, after.Description)
+	field("URL", before.URL, after.URL)
+	if !reflect.DeepEqual(before.Services, after.Services) && (len(before.Services) > 0 || len(after.Services) > 0) {
+		changes = append(changes, "Services")
+	}
+	return changes
+}
+
+func defaultSuite(s string) string {
+	if s == "" {
+		return "Ed25519"
+	}
+	return s
+}
+
+// MemberCheck is the result of the connection to a conode of a roster.
+type MemberCheck struct {
+	Server *ServerToml
+	// Router is the error connecting to the conode, for a tls:// address
+	// also when its certificate doesn't hold its public key, or nil if it
+	// answered.
+	Router error
+	// WebSocket is the error connecting to the websocket of the conode, at
+	// its URL or else next to its address, or nil if it answered.
+	WebSocket error
+	// CertificateExpiry is when the certificate of the websocket expires,
+	// for a https:// URL.
+	CertificateExpiry time.Time
+}
+
+// OK returns whether the conode answered on both its address and its
+// websocket.
+func (mc *MemberCheck) OK() bool {
+	return mc.Router == nil && mc.WebSocket == nil
+}
+
+func (mc *MemberCheck) String() string {
+	status := func(err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+	s := fmt.Sprintf("%s %s: router %s, websocket %s", mc.Server.Public, mc.Server.Address,
+		status(mc.Router), status(mc.WebSocket))
+	if !mc.CertificateExpiry.IsZero() {
+		s += ", certificate expires " + mc.CertificateExpiry.Format(time.RFC3339)
+	}
+	return s
+}
+
+// CheckGroup connects to all the conodes of the roster at the same time,
+// and returns how each of them answered within the timeout.
+func CheckGroup(gt *GroupToml, timeout time.Duration) []*MemberCheck {
+	checks := make([]*MemberCheck, len(gt.Servers))
+	var wg sync.WaitGroup
+	for i, s := range gt.Servers {
+		checks[i] = &MemberCheck{Server: s}
+		wg.Add(1)
+		go func(mc *MemberCheck) {
+			defer wg.Done()
+			mc.Router = withTimeout(timeout, func() error { return checkRouter(mc.Server) })
+			mc.WebSocket = withTimeout(timeout, func() error {
+				expiry, err := checkWebSocket(mc.Server, timeout)
+				mc.CertificateExpiry = expiry
+				return err
+			})
+		}(checks[i])
+	}
+	wg.Wait()
+	return checks
+}
+
+// withTimeout returns the error of f, or an error if it doesn't return
+// within the timeout.
+func withTimeout(timeout time.Duration, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return xerrors.Errorf("no answer within %s", timeout)
+	}
+}
+
+// checkRouter connects to the conode, and for a tls:// address checks that
+// its certificate holds its public key.
+func checkRouter(s *ServerToml) error {
+	them, err := serverIdentity(s)
+	if err != nil {
+		return err
+	}
+	suite, err := suites.Find(defaultSuite(s.Suite))
+	if err != nil {
+		return xerrors.Errorf("kyber suite: %v", err)
+	}
+	var c *network.TCPConn
+	switch s.Address.ConnType() {
+	case network.TLS:
+		// any key pair will do, to check the certificate of the conode
+		kp := key.NewKeyPair(suite)
+		us := network.NewServerIdentity(kp.Public, network.NewAddress(network.TLS, "127.0.0.1:0"))
+		us.SetPrivate(kp.Private)
+		c, err = network.NewTLSConn(us, them, suite)
+	case network.PlainTCP:
+		c, err = network.NewTCPConn(s.Address, suite)
+	default:
+		return xerrors.Errorf("can't connect to %s", s.Address)
+	}
+	if err != nil {
+		return xerrors.Errorf("connecting: %v", err)
+	}
+	return c.Close()
+}
+
+// checkWebSocket connects to the websocket of the conode, and returns when
+// its certificate expires for a https:// URL.
+func checkWebSocket(s *ServerToml, timeout time.Duration) (time.Time, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if s.URL == "" {
+		host, port, err := net.SplitHostPort(s.Address.NetworkAddress())
+		if err != nil {
+			return time.Time{}, xerrors.Errorf("address: %v", err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return time.Time{}, xerrors.Errorf("port: %v", err)
+		}
+		c, err := dialer.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(p+1)))
+		if err != nil {
+			return time.Time{}, xerrors.Errorf("connecting: %v", err)
+		}
+		return time.Time{}, c.Close()
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("URL: %v", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if u.Scheme != "https" && u.Scheme != "wss" {
+		c, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return time.Time{}, xerrors.Errorf("connecting: %v", err)
+		}
+		return time.Time{}, c.Close()
+	}
+	c, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("certificate: %v", err)
+	}
+	defer c.Close()
+	return c.ConnectionState().PeerCertificates[0].NotAfter, nil
+}
+
+// RosterCommand runs one of the commands managing the public.toml rosters,
+// for the binaries offering them to the operators, with the output in out:
+//
+// create [-o file] public.toml... writes the roster of the conodes, and asks
+// for their files if there is none.
+// merge [-o file] roster.toml... writes the roster of all the rosters.
+// verify roster.toml checks the entries of the roster.
+// diff before.toml after.toml shows the conodes added, removed and changed.
+// check [-timeout 10s] roster.toml connects to all the conodes.
+//
+// The files can be in TOML, YAML or JSON, and the rosters are written in
+// TOML, to out if there is no -o.
+func RosterCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return xerrors.New("missing command: create, merge, verify, diff or check")
+	}
+	fs := flag.NewFlagSet("roster "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	output := fs.String("o", "", "file to write the roster to")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for each conode")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	files := fs.Args()
+
+	write := func(gt *GroupToml) error {
+		if err := gt.Verify(); err != nil {
+			return xerrors.Errorf("invalid roster: %v", err)
+		}
+		if *output == "" {
+			_, err := io.WriteString(out, gt.String())
+			return err
+		}
+		return ioutil.WriteFile(*output, []byte(gt.String()), 0644)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(files) == 0 {
+			for {
+				file := Input("", "Public file of a conode, empty when done")
+				if file == "" {
+					break
+				}
+				files = append(files, TildeToHome(file))
+			}
+		}
+		fallthrough
+	case "merge":
+		if len(files) == 0 {
+			return xerrors.New("no files")
+		}
+		gt, err := CreateGroup(files...)
+		if err != nil {
+			return err
+		}
+		return write(gt)
+	case "verify":
+		if len(files) != 1 {
+			return xerrors.New("verify needs one roster")
+		}
+		gt, err := ReadGroupToml(files[0])
+		if err != nil {
+			return err
+		}
+		if err := gt.Verify(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d conodes, all valid\n", len(gt.Servers))
+		return nil
+	case "diff":
+		if len(files) != 2 {
+			return xerrors.New("diff needs the old and the new roster")
+		}
+		before, err := ReadGroupToml(files[0])
+		if err != nil {
+			return err
+		}
+		after, err := ReadGroupToml(files[1])
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, DiffGroups(before, after).String())
+		return err
+	case "check":
+		if len(files) != 1 {
+			return xerrors.New("check needs one roster")
+		}
+		gt, err := ReadGroupToml(files[0])
+		if err != nil {
+			return err
+		}
+		var failed int
+		for _, mc := range CheckGroup(gt, *timeout) {
+			fmt.Fprintln(out, mc)
+			if !mc.OK() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return xerrors.Errorf("%d of %d conodes failed", failed, len(gt.Servers))
+		}
+		return nil
+	}
+	return xerrors.Errorf("unknown command %s", args[0])
+}
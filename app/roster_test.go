@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const (
+	rosterPub1 = "94b8255379e11df5167b8a7ae3b85f7e7eb5f13894abee85bd31b3270f1e4c65"
+	rosterPub2 = "6a921638a4ade8970ebcd9e371570f08d71a24987f90f12391b9f6c525be5be4"
+)
+
+func rosterEntry(addr, pub, desc string) *ServerToml {
+	return &ServerToml{Address: network.NewTCPAddress(addr), Suite: "Ed25519", Public: pub, Description: desc}
+}
+
+func TestMergeGroups(t *testing.T) {
+	a := NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub1, "one"))
+	b := NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub1, "one"), rosterEntry("1.2.3.5:7770", rosterPub2, "two"))
+	b.Description = "roster"
+
+	gt, err := MergeGroups(a, b)
+	require.NoError(t, err)
+	require.Equal(t, "roster", gt.Description)
+	require.Equal(t, 2, len(gt.Servers))
+	require.Equal(t, rosterPub1, gt.Servers[0].Public)
+	require.Equal(t, rosterPub2, gt.Servers[1].Public)
+
+	c := NewGroupToml(rosterEntry("1.2.3.6:7770", rosterPub1, "one"))
+	_, err = MergeGroups(a, c)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `Address "tcp://1.2.3.4:7770" -> "tcp://1.2.3.6:7770"`)
+
+	d := NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub2, "two"))
+	_, err = MergeGroups(a, d)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "have the same address")
+}
+
+func TestGroupToml_Verify(t *testing.T) {
+	gt := NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub1, "one"), rosterEntry("1.2.3.5:7770", rosterPub2, "two"))
+	require.NoError(t, gt.Verify())
+
+	gt = NewGroupToml(
+		rosterEntry("1.2.3.4:7770", rosterPub1, "one"),
+		rosterEntry("1.2.3.4:7770", rosterPub1, "again"),
+		&ServerToml{Address: "tcp://nowhere", Public: "12", Suite: "Ed25519"},
+		&ServerToml{Address: network.NewTCPAddress("1.2.3.6:7770"), Public: rosterPub2, Suite: "nope"},
+		&ServerToml{Public: rosterPub2},
+	)
+	err := gt.Verify()
+	require.Error(t, err)
+	for _, msg := range []string{
+		"server 2: same public key as server 1",
+		"server 2: same address as server 1",
+		"server 3: invalid address tcp://nowhere",
+		"server 3: encoding key",
+		"server 4: kyber suite",
+		"server 5: missing required fields: Address",
+	} {
+		require.Contains(t, err.Error(), msg)
+	}
+}
+
+func TestDiffGroups(t *testing.T) {
+	before := NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub1, "one"), rosterEntry("1.2.3.5:7770", rosterPub2, "two"))
+	after := NewGroupToml(rosterEntry("1.2.3.4:7772", rosterPub1, "one"), rosterEntry("1.2.3.6:7770", "abcd", "three"))
+
+	d := DiffGroups(before, after)
+	require.False(t, d.Empty())
+	require.Equal(t, 1, len(d.Added))
+	require.Equal(t, "abcd", d.Added[0].Public)
+	require.Equal(t, 1, len(d.Removed))
+	require.Equal(t, rosterPub2, d.Removed[0].Public)
+	require.Equal(t, 1, len(d.Changed))
+	require.Equal(t, []string{`Address "tcp://1.2.3.4:7770" -> "tcp://1.2.3.4:7772"`}, d.Changed[0].Fields)
+	require.Equal(t, "+ abcd tcp://1.2.3.6:7770 three\n"+
+		"- "+rosterPub2+" tcp://1.2.3.5:7770 two\n"+
+		"~ "+rosterPub1+` Address "tcp://1.2.3.4:7770" -> "tcp://1.2.3.4:7772"`+"\n", d.String())
+
+	require.True(t, DiffGroups(before, before).Empty())
+}
+
+// TestCheckGroup checks the connection to a conode listening on both its
+// address and its websocket, and to one that isn't running.
+func TestCheckGroup(t *testing.T) {
+	router, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer router.Close()
+	ws, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ws.Close()
+	go acceptAll(router)
+	go acceptAll(ws)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	up := rosterEntry(router.Addr().String(), rosterPub1, "up")
+	up.URL = "http://" + ws.Addr().String()
+	down := rosterEntry(closed.Addr().String(), rosterPub2, "down")
+	checks := CheckGroup(NewGroupToml(up, down), 5*time.Second)
+	require.Equal(t, 2, len(checks))
+	require.True(t, checks[0].OK(), checks[0].String())
+	require.False(t, checks[1].OK())
+	require.Error(t, checks[1].Router)
+	require.Error(t, checks[1].WebSocket)
+	require.Contains(t, checks[0].String(), "router ok, websocket ok")
+}
+
+func acceptAll(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Close()
+	}
+}
+
+func TestRosterCommand(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "roster")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	pub1 := path.Join(tmp, "public1.toml")
+	require.NoError(t, NewGroupToml(rosterEntry("1.2.3.4:7770", rosterPub1, "one")).Save(pub1))
+	pub2 := path.Join(tmp, "public2.yaml")
+	require.NoError(t, ioutil.WriteFile(pub2, []byte(fmt.Sprintf(
+		"servers:\n  - {Address: \"tcp://1.2.3.5:7770\", Public: %s, Description: two}\n", rosterPub2)), 0644))
+	roster := path.Join(tmp, "roster.toml")
+
+	var out bytes.Buffer
+	require.NoError(t, RosterCommand([]string{"create", "-o", roster, pub1, pub2}, &out))
+	group, err := ReadGroupDescFile(roster, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(group.Roster.List))
+
+	out.Reset()
+	require.NoError(t, RosterCommand([]string{"merge", roster, pub1}, &out))
+	require.Equal(t, 2, strings.Count(out.String(), "[[servers]]"))
+
+	out.Reset()
+	require.NoError(t, RosterCommand([]string{"verify", roster}, &out))
+	require.Equal(t, "2 conodes, all valid\n", out.String())
+
+	out.Reset()
+	require.NoError(t, RosterCommand([]string{"diff", pub1, roster}, &out))
+	require.Equal(t, "+ "+rosterPub2+" tcp://1.2.3.5:7770 two\n", out.String())
+
+	require.Error(t, RosterCommand(nil, &out))
+	require.Error(t, RosterCommand([]string{"rotate"}, &out))
+	require.Error(t, RosterCommand([]string{"verify"}, &out))
+	require.Error(t, RosterCommand([]string{"merge"}, &out))
+}
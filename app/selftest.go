@@ -0,0 +1,621 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// DefaultMaxClockSkew is how far the clock of the conode may be from the ones
+// of the other members of the roster before SelfTest fails, the same as the
+// conodes warn about.
+const DefaultMaxClockSkew = onet.DefaultMaxClockSkew
+
+// CheckStatus is the outcome of one of the checks of SelfTest.
+type CheckStatus int
+
+const (
+	// CheckOK is a check that passed.
+	CheckOK CheckStatus = iota
+	// CheckWarning is a check that passed, but found something the
+	// operator should look at.
+	CheckWarning
+	// CheckFailed is a check that found the conode isn't ready.
+	CheckFailed
+	// CheckSkipped is a check that couldn't run, as there was nothing to
+	// check.
+	CheckSkipped
+)
+
+func (cs CheckStatus) String() string {
+	switch cs {
+	case CheckOK:
+		return "ok"
+	case CheckWarning:
+		return "warning"
+	case CheckFailed:
+		return "FAILED"
+	case CheckSkipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
+// CheckResult is the outcome of one of the checks of SelfTest, with what it
+// found.
+type CheckResult struct {
+	Name    string
+	Status  CheckStatus
+	Details []string
+}
+
+func (cr *CheckResult) add(status CheckStatus, format string, args ...interface{}) {
+	if status != CheckSkipped && (cr.Status == CheckSkipped || status > cr.Status) {
+		cr.Status = status
+	}
+	cr.Details = append(cr.Details, fmt.Sprintf(format, args...))
+}
+
+// SelfTestOptions tells SelfTest how to check the conode.
+type SelfTestOptions struct {
+	// Roster is the public.toml with the other conodes, to check the clock
+	// against theirs, or empty to skip the check.
+	Roster string
+	// Helper is the URL of a port scanner which connects back to the port
+	// given as ?port= on the address of the request, and answers "Open" if it
+	// could, like the one used by InteractiveConfig. The check of the
+	// address is skipped if both Helper and STUN are empty.
+	Helper string
+	// STUN is the host:port of a STUN server, which tells the public IP of
+	// the conode. It must be one of the IPs of the address of the conode.
+	STUN string
+	// Timeout is how long each connection may take, 10 seconds by default.
+	Timeout time.Duration
+	// MaxClockSkew is DefaultMaxClockSkew if zero.
+	MaxClockSkew time.Duration
+}
+
+// SelfTestReport holds the results of the checks of SelfTest.
+type SelfTestReport struct {
+	Results []*CheckResult
+}
+
+// Ready returns whether none of the checks failed.
+func (r *SelfTestReport) Ready() bool {
+	for _, cr := range r.Results {
+		if cr.Status == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *SelfTestReport) String() string {
+	var b strings.Builder
+	var failed, warnings int
+	for _, cr := range r.Results {
+		fmt.Fprintf(&b, "%-8s %s\n", cr.Name, cr.Status)
+		for _, d := range cr.Details {
+			fmt.Fprintf(&b, "         %s\n", d)
+		}
+		switch cr.Status {
+		case CheckFailed:
+			failed++
+		case CheckWarning:
+			warnings++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(&b, "not ready: %d of %d checks failed\n", failed, len(r.Results))
+	} else {
+		fmt.Fprintf(&b, "ready, with %d warnings\n", warnings)
+	}
+	return b.String()
+}
+
+// SelfTest checks that the conode of the config file is ready to join its
+// roster: that the config is valid, that the address of the conode can be
+// reached from the outside, that its certificates are valid, and that its
+// clock is close to the ones of the other conodes. It can run before or
+// while the conode runs.
+func SelfTest(file string, opts SelfTestOptions) *SelfTestReport {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxClockSkew == 0 {
+		opts.MaxClockSkew = DefaultMaxClockSkew
+	}
+	config := &CheckResult{Name: "config"}
+	report := &SelfTestReport{Results: []*CheckResult{config}}
+	hc, err := LoadCothority(file)
+	if err != nil {
+		config.add(CheckFailed, "%v", err)
+		return report
+	}
+	si := checkConfig(hc, config)
+	if si == nil {
+		return report
+	}
+	report.Results = append(report.Results,
+		checkAddress(hc, opts),
+		checkTLS(hc, si, opts.Timeout),
+		checkClock(hc, opts))
+	return report
+}
+
+// checkConfig checks the values of the config which are only parsed when the
+// conode starts, and returns its identity if it could be read.
+func checkConfig(hc *CothorityConfig, cr *CheckResult) (si *network.ServerIdentity) {
+	defer func() {
+		// the keys of the services panic on a wrong suite
+		if r := recover(); r != nil {
+			cr.add(CheckFailed, "%v", r)
+			si = nil
+		}
+	}()
+	si, err := hc.GetServerIdentity()
+	if err != nil {
+		cr.add(CheckFailed, "%v", err)
+		return nil
+	}
+	suite, err := suites.Find(hc.Suite)
+	if err != nil {
+		cr.add(CheckFailed, "kyber suite: %v", err)
+		return nil
+	}
+	if !suite.Point().Mul(si.GetPrivate(), nil).Equal(si.Public) {
+		cr.add(CheckFailed, "the private key doesn't match the public key")
+	}
+	for _, sid := range si.ServiceIdentities {
+		ss, err := suites.Find(sid.Suite)
+		if err != nil || !ss.Point().Mul(sid.GetPrivate(), nil).Equal(sid.Public) {
+			cr.add(CheckFailed, "the private key of %s doesn't match its public key", sid.Name)
+		}
+	}
+	if len(si.ServiceIdentities) != len(hc.Services) {
+		cr.add(CheckWarning, "%d of the %d services' key pairs are not for a service of this binary",
+			len(hc.Services)-len(si.ServiceIdentities), len(hc.Services))
+	}
+	if !hc.Address.Valid() {
+		cr.add(CheckFailed, "invalid address %s", hc.Address)
+	}
+	if _, err := hc.StorageConfig(si); err != nil {
+		cr.add(CheckFailed, "%v", err)
+	}
+	if _, err := hc.DecodeLimits(); err != nil {
+		cr.add(CheckFailed, "message limits: %v", err)
+	}
+	if _, err := hc.WebSocketKeepAlive(); err != nil {
+		cr.add(CheckFailed, "websocket keep-alive: %v", err)
+	}
+	for name, d := range map[string]string{"shutdown timeout": hc.ShutdownTimeout,
+		"tree cache TTL": hc.TreeCacheTTL, "max clock skew": hc.MaxClockSkew} {
+		if _, err := time.ParseDuration(d); d != "" && err != nil {
+			cr.add(CheckFailed, "%s: %v", name, err)
+		}
+	}
+	if hc.Address.ConnType() == network.PlainTCP {
+		cr.add(CheckWarning, "the conode is reached with plain TCP, without TLS")
+	}
+	cr.add(CheckOK, "conode %s at %s", hc.Public, hc.Address)
+	return si
+}
+
+// checkAddress checks that the address of the conode can be reached from the
+// outside. If the conode doesn't run, it listens on its port during the
+// check.
+func checkAddress(hc *CothorityConfig, opts SelfTestOptions) *CheckResult {
+	cr := &CheckResult{Name: "address", Status: CheckSkipped}
+	if opts.Helper == "" && opts.STUN == "" {
+		cr.add(CheckSkipped, "no helper nor STUN server to check it from the outside")
+		return cr
+	}
+	host, port, err := net.SplitHostPort(hc.Address.NetworkAddress())
+	if err != nil {
+		cr.add(CheckFailed, "address: %v", err)
+		return cr
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		cr.add(CheckFailed, "resolving %s: %v", host, err)
+		return cr
+	}
+
+	if opts.STUN != "" {
+		public, err := stunAddress(opts.STUN, opts.Timeout)
+		switch {
+		case err != nil:
+			cr.add(CheckFailed, "STUN %s: %v", opts.STUN, err)
+		case !containsIP(ips, public):
+			cr.add(CheckFailed, "the public IP is %s, but %s is at %v", public, host, ips)
+		default:
+			cr.add(CheckOK, "the public IP %s is the one of %s", public, host)
+		}
+	}
+
+	if opts.Helper != "" {
+		bind, err := bindAddress(hc)
+		if err != nil {
+			cr.add(CheckFailed, "%v", err)
+			return cr
+		}
+		if ln, err := net.Listen("tcp", bind); err == nil {
+			defer ln.Close()
+			go acceptAndClose(ln)
+		} else {
+			cr.add(CheckOK, "%s is in use, supposing the conode runs", bind)
+		}
+		if err := askPortScan(opts.Helper, port, opts.Timeout); err != nil {
+			cr.add(CheckFailed, "%s isn't reachable from the outside: %v", hc.Address, err)
+		} else {
+			cr.add(CheckOK, "port %s is reachable from the outside", port)
+		}
+	}
+	return cr
+}
+
+// bindAddress returns where the conode listens, as the router does.
+func bindAddress(hc *CothorityConfig) (string, error) {
+	if hc.ListenAddress == "" {
+		return network.GlobalBind(hc.Address.NetworkAddress())
+	}
+	if !strings.Contains(hc.ListenAddress, ":") {
+		return hc.ListenAddress + ":" + hc.Address.Port(), nil
+	}
+	return hc.ListenAddress, nil
+}
+
+func acceptAndClose(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		c.Close()
+	}
+}
+
+// askPortScan asks the helper to connect to the port, as tryConnect does.
+func askPortScan(helper, port string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(helper + "?port=" + url.QueryEscape(port))
+	if err != nil {
+		return xerrors.Errorf("asking the helper: %v", err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return xerrors.Errorf("reading body: %v", err)
+	}
+	if res := strings.TrimSpace(string(buf)); res != "Open" {
+		return xerrors.Errorf("the helper answered %q", res)
+	}
+	return nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+	stunMagicCookie      = 0x2112a442
+)
+
+// stunAddress returns the public IP of this host, as seen by the STUN
+// server, following RFC 5389.
+func stunAddress(server string, timeout time.Duration) (net.IP, error) {
+	c, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("connecting: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:]); err != nil {
+		return nil, xerrors.Errorf("transaction ID: %v", err)
+	}
+	if _, err := c.Write(req); err != nil {
+		return nil, xerrors.Errorf("sending request: %v", err)
+	}
+	resp := make([]byte, 1500)
+	n, err := c.Read(resp)
+	if err != nil {
+		return nil, xerrors.Errorf("reading answer: %v", err)
+	}
+	resp = resp[:n]
+	if n < 20 || binary.BigEndian.Uint16(resp[0:]) != stunBindingSuccess ||
+		string(resp[8:20]) != string(req[8:20]) {
+		return nil, xerrors.New("invalid answer")
+	}
+	attrs := resp[20:]
+	if l := int(binary.BigEndian.Uint16(resp[2:])); l < len(attrs) {
+		attrs = attrs[:l]
+	}
+	var mapped net.IP
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+l > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+l]
+		switch typ {
+		case stunXorMappedAddress:
+			if ip := stunIP(value, resp[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			mapped = stunIP(value, nil)
+		}
+		// the attributes are padded to 4 bytes
+		next := 4 + (l+3)/4*4
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == nil {
+		return nil, xerrors.New("no address in the answer")
+	}
+	return mapped, nil
+}
+
+// stunIP returns the IP of an address attribute, XORed with the cookie and
+// the transaction ID in mask if it is not nil.
+func stunIP(value, mask []byte) net.IP {
+	var ip net.IP
+	switch {
+	case len(value) == 8 && value[1] == 1:
+		ip = net.IP(append([]byte{}, value[4:8]...))
+	case len(value) == 20 && value[1] == 2:
+		ip = net.IP(append([]byte{}, value[4:20]...))
+	default:
+		return nil
+	}
+	if mask != nil {
+		for i := range ip {
+			ip[i] ^= mask[i]
+		}
+	}
+	return ip
+}
+
+// checkTLS checks that the conode can be reached with TLS with its key, and
+// the certificate of its websocket.
+func checkTLS(hc *CothorityConfig, si *network.ServerIdentity, timeout time.Duration) *CheckResult {
+	cr := &CheckResult{Name: "tls", Status: CheckSkipped}
+	if hc.Address.ConnType() == network.TLS {
+		if err := withTimeout(timeout, func() error { return checkOwnTLS(hc, si) }); err != nil {
+			cr.add(CheckFailed, "router: %v", err)
+		} else {
+			cr.add(CheckOK, "router: the certificate holds the public key")
+		}
+	} else {
+		cr.add(CheckSkipped, "router: no TLS")
+	}
+
+	tlsConfig, err := hc.webSocketTLS()
+	if err != nil {
+		cr.add(CheckFailed, "websocket: %v", err)
+		return cr
+	}
+	if tlsConfig == nil {
+		cr.add(CheckSkipped, "websocket: no certificate")
+		return cr
+	}
+	cert := tlsConfig.Certificates
+	if tlsConfig.GetCertificate != nil {
+		c, err := tlsConfig.GetCertificate(nil)
+		if err != nil {
+			cr.add(CheckFailed, "websocket: %v", err)
+			return cr
+		}
+		cert = []tls.Certificate{*c}
+	}
+	leaf, err := x509.ParseCertificate(cert[0].Certificate[0])
+	if err != nil {
+		cr.add(CheckFailed, "websocket: parsing certificate: %v", err)
+		return cr
+	}
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		cr.add(CheckFailed, "websocket: the certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	case now.Before(leaf.NotBefore):
+		cr.add(CheckFailed, "websocket: the certificate is only valid from %s", leaf.NotBefore.Format(time.RFC3339))
+	case leaf.NotAfter.Sub(now) < 30*24*time.Hour:
+		cr.add(CheckWarning, "websocket: the certificate expires on %s", leaf.NotAfter.Format(time.RFC3339))
+	default:
+		cr.add(CheckOK, "websocket: the certificate expires on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	if u, err := url.Parse(si.URL); err == nil && u.Hostname() != "" {
+		if err := leaf.VerifyHostname(u.Hostname()); err != nil {
+			cr.add(CheckFailed, "websocket: %v", err)
+		}
+	}
+	return cr
+}
+
+// checkOwnTLS connects to the conode if it runs, or else to a listener with
+// its key, and checks that the certificate holds its public key.
+func checkOwnTLS(hc *CothorityConfig, si *network.ServerIdentity) error {
+	suite, err := suites.Find(hc.Suite)
+	if err != nil {
+		return xerrors.Errorf("kyber suite: %v", err)
+	}
+	bind, err := bindAddress(hc)
+	if err != nil {
+		return err
+	}
+	if ln, err := net.Listen("tcp", bind); err != nil {
+		// the conode runs, so check it on its address
+		return checkRouter(&ServerToml{Address: hc.Address, Suite: hc.Suite, Public: hc.Public})
+	} else if err := ln.Close(); err != nil {
+		return xerrors.Errorf("closing listener: %v", err)
+	}
+	l, err := network.NewTLSListenerWithListenAddr(si, suite, hc.ListenAddress)
+	if err != nil {
+		return xerrors.Errorf("listening: %v", err)
+	}
+	defer l.Stop()
+	go l.Listen(func(c network.Conn) { c.Close() })
+	host := l.Address().Host()
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	local := network.NewTLSAddress(net.JoinHostPort(host, l.Address().Port()))
+	return checkRouter(&ServerToml{Address: local, Suite: hc.Suite, Public: hc.Public})
+}
+
+// clockSkew is what checkClock found for a conode.
+type clockSkew struct {
+	server *ServerToml
+	skew   time.Duration
+	err    error
+}
+
+// checkClock compares the clock of this host with the Date header of the
+// answers of the websockets of the other conodes of the roster. As the
+// header is in seconds, the skew is only known within a second.
+func checkClock(hc *CothorityConfig, opts SelfTestOptions) *CheckResult {
+	cr := &CheckResult{Name: "clock", Status: CheckSkipped}
+	if opts.Roster == "" {
+		cr.add(CheckSkipped, "no roster to check the clock against")
+		return cr
+	}
+	gt, err := ReadGroupToml(opts.Roster)
+	if err != nil {
+		cr.add(CheckFailed, "%v", err)
+		return cr
+	}
+	var skews []*clockSkew
+	for _, s := range gt.Servers {
+		if s.Public != hc.Public {
+			skews = append(skews, &clockSkew{server: s})
+		}
+	}
+	if len(skews) == 0 {
+		cr.add(CheckSkipped, "no other conode in the roster")
+		return cr
+	}
+	var wg sync.WaitGroup
+	for _, cs := range skews {
+		wg.Add(1)
+		go func(cs *clockSkew) {
+			defer wg.Done()
+			cs.skew, cs.err = remoteClockSkew(cs.server, opts.Timeout)
+		}(cs)
+	}
+	wg.Wait()
+	for _, cs := range skews {
+		name := cs.server.Address.String()
+		switch {
+		case cs.err != nil:
+			cr.add(CheckWarning, "%s: %v", name, cs.err)
+		case cs.skew > opts.MaxClockSkew || -cs.skew > opts.MaxClockSkew:
+			cr.add(CheckFailed, "%s: off by %s", name, cs.skew)
+		default:
+			cr.add(CheckOK, "%s: off by %s", name, cs.skew)
+		}
+	}
+	return cr
+}
+
+// webSocketURL returns the URL of the websocket of the conode, which is at
+// the port after the one of its address if it has no URL.
+func webSocketURL(s *ServerToml) (string, error) {
+	if s.URL != "" {
+		return s.URL, nil
+	}
+	p, err := strconv.Atoi(s.Address.Port())
+	if err != nil {
+		return "", xerrors.Errorf("port: %v", err)
+	}
+	return fmt.Sprintf("http://%s:%d", s.Address.Host(), p+1), nil
+}
+
+// remoteClockSkew returns how far ahead the clock of the conode is.
+func remoteClockSkew(s *ServerToml, timeout time.Duration) (time.Duration, error) {
+	u, err := webSocketURL(s)
+	if err != nil {
+		return 0, err
+	}
+	client := http.Client{
+		Timeout: timeout,
+		// only the time of the answer is read
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	start := time.Now()
+	resp, err := client.Get(strings.TrimSuffix(u, "/") + "/status")
+	if err != nil {
+		return 0, xerrors.Errorf("connecting: %v", err)
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, xerrors.Errorf("no time in the answer: %v", err)
+	}
+	// the header is truncated to the second
+	remote := date.Add(500 * time.Millisecond)
+	return remote.Sub(start.Add(rtt / 2)).Round(time.Second), nil
+}
+
+// SelfTestCommand runs the check of the conodes with the config file given in
+// args, and writes its report to out. The flags are -roster with the
+// public.toml to check the clock, -helper with the URL of the port scanner,
+// the one of InteractiveConfig by default, -stun with a STUN server,
+// -timeout and -max-skew. It returns an error if the conode isn't ready.
+func SelfTestCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(out)
+	var opts SelfTestOptions
+	fs.StringVar(&opts.Roster, "roster", "", "public.toml to compare the clock with")
+	fs.StringVar(&opts.Helper, "helper", portscan, "URL of the port scanner checking the address, none if empty")
+	fs.StringVar(&opts.STUN, "stun", "", "host:port of a STUN server telling the public IP")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "how long to wait for each connection")
+	fs.DurationVar(&opts.MaxClockSkew, "max-skew", DefaultMaxClockSkew, "how far the clock may be off")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return xerrors.New("check needs the config file")
+	}
+	report := SelfTest(fs.Arg(0), opts)
+	if _, err := io.WriteString(out, report.String()); err != nil {
+		return err
+	}
+	if !report.Ready() {
+		return xerrors.New("the conode isn't ready")
+	}
+	return nil
+}
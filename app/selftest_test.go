@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/suites"
+)
+
+// TestSelfTest checks a conode which is reachable, but whose clock is an
+// hour behind one of the other conodes.
+func TestSelfTest(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "selftest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+	var o bytes.Buffer
+	require.NoError(t, KeyCommand([]string{"generate", "-address", fmt.Sprintf("tcp://127.0.0.1:%d", port), tmp}, &o))
+	file := path.Join(tmp, DefaultServerConfig)
+
+	helper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		c, err := net.Dial("tcp", net.JoinHostPort(host, r.URL.Query().Get("port")))
+		if err != nil {
+			fmt.Fprint(w, "Closed")
+			return
+		}
+		c.Close()
+		fmt.Fprint(w, "Open")
+	}))
+	defer helper.Close()
+	stun := stunServer(t)
+	defer stun.Close()
+
+	inTime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer inTime.Close()
+	late := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer late.Close()
+	ok := rosterEntry("127.0.0.1:1", rosterPub1, "in time")
+	ok.URL = inTime.URL
+	off := rosterEntry("127.0.0.1:2", rosterPub2, "late")
+	off.URL = late.URL
+	roster := path.Join(tmp, "roster.toml")
+	require.NoError(t, NewGroupToml(ok, off).Save(roster))
+
+	opts := SelfTestOptions{Roster: roster, Helper: helper.URL, STUN: stun.LocalAddr().String(), Timeout: 5 * time.Second}
+	report := SelfTest(file, opts)
+	require.False(t, report.Ready(), report.String())
+	require.Equal(t, 4, len(report.Results))
+	require.Equal(t, CheckWarning, report.Results[0].Status, report.String())
+	require.Equal(t, CheckOK, report.Results[1].Status, report.String())
+	require.Equal(t, CheckSkipped, report.Results[2].Status, report.String())
+	require.Equal(t, CheckFailed, report.Results[3].Status, report.String())
+	require.Contains(t, report.String(), "127.0.0.1:2: off by 1h0m0s")
+	require.Contains(t, report.String(), "not ready: 1 of 4 checks failed")
+
+	require.NoError(t, NewGroupToml(ok).Save(roster))
+	report = SelfTest(file, opts)
+	require.True(t, report.Ready(), report.String())
+	require.Contains(t, report.String(), "ready, with 1 warnings")
+
+	// the conode runs, so the port is in use
+	ln, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	go acceptAll(ln)
+	report = SelfTest(file, opts)
+	require.True(t, report.Ready(), report.String())
+	require.NoError(t, ln.Close())
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Closed")
+	}))
+	defer closed.Close()
+	report = SelfTest(file, SelfTestOptions{Helper: closed.URL})
+	require.False(t, report.Ready())
+	require.Contains(t, report.String(), `the helper answered "Closed"`)
+}
+
+// stunServer answers the binding requests with the address they come from.
+func stunServer(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 20 {
+				continue
+			}
+			resp := make([]byte, 32)
+			binary.BigEndian.PutUint16(resp[0:], stunBindingSuccess)
+			binary.BigEndian.PutUint16(resp[2:], 12)
+			copy(resp[4:20], buf[4:20])
+			binary.BigEndian.PutUint16(resp[20:], stunXorMappedAddress)
+			binary.BigEndian.PutUint16(resp[22:], 8)
+			resp[25] = 1
+			udp := addr.(*net.UDPAddr)
+			binary.BigEndian.PutUint16(resp[26:], uint16(udp.Port)^uint16(stunMagicCookie>>16))
+			for i, b := range udp.IP.To4() {
+				resp[28+i] = b ^ buf[4+i]
+			}
+			pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc
+}
+
+func TestSelfTest_config(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "selftest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	var o bytes.Buffer
+	require.NoError(t, KeyCommand([]string{"generate", "-address", "tls://127.0.0.1:7770", tmp}, &o))
+	file := path.Join(tmp, DefaultServerConfig)
+	hc, err := LoadCothority(file)
+	require.NoError(t, err)
+
+	cert, key := selfSignedCertificate(t, tmp, time.Now().Add(24*time.Hour))
+	hc.WebSocketTLSCertificate = CertificateURL("file://" + cert)
+	hc.WebSocketTLSCertificateKey = CertificateURL("file://" + key)
+	cr := &CheckResult{}
+	si := checkConfig(hc, cr)
+	require.NotNil(t, si)
+	require.Equal(t, CheckOK, cr.Status, cr.Details)
+	tls := checkTLS(hc, si, time.Second)
+	require.Equal(t, 3, len(tls.Details), tls.Details)
+	require.Contains(t, tls.Details[1], "websocket: the certificate expires on")
+	require.Contains(t, tls.Details[2], "cannot validate certificate for 127.0.0.1")
+
+	other, err := LoadCothority(file)
+	require.NoError(t, err)
+	other.Private, _ = createKeyPair(suites.MustFind("Ed25519"))
+	other.StorageKey = "nothex"
+	other.TreeCacheTTL = "soon"
+	cr = &CheckResult{}
+	require.NotNil(t, checkConfig(other, cr))
+	require.Equal(t, CheckFailed, cr.Status)
+	require.Equal(t, 4, len(cr.Details), cr.Details)
+	require.Equal(t, "the private key doesn't match the public key", cr.Details[0])
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("Suite = \"Ed25519\"\n"), 0600))
+	require.Error(t, SelfTestCommand([]string{"-helper", "", file}, &o))
+	require.Contains(t, o.String(), "missing required fields")
+	require.Error(t, SelfTestCommand(nil, &o))
+}
+
+// selfSignedCertificate writes a certificate for localhost valid until
+// notAfter, and returns the files of the certificate and of its key.
+func selfSignedCertificate(t *testing.T, dir string, notAfter time.Time) (string, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	require.NoError(t, err)
+	cert, key := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return cert, key
+}
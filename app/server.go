@@ -6,9 +6,12 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.dedis.ch/kyber/v3/util/encoding"
@@ -33,6 +36,10 @@ const DefaultPort = 7770
 // DefaultAddress where to be contacted by other servers.
 const DefaultAddress = "127.0.0.1"
 
+// How long a conode waits for running protocols to finish when it gets
+// SIGINT or SIGTERM, if the config doesn't set ShutdownTimeout.
+const shutdownTimeout = 10 * time.Second
+
 // Service used to get the public IP-address.
 const portscan = "https://blog.dedis.ch/portscan.php"
 
@@ -344,13 +351,163 @@ func tryConnect(ip, binding network.Address) error {
 // RunServer starts a conode with the given config file name. It can
 // be used by different apps (like CoSi, for example)
 func RunServer(configFilename string) {
-	if _, err := os.Stat(configFilename); os.IsNotExist(err) {
-		log.Fatalf("[-] Configuration file does not exist. %s", configFilename)
+	RunServers(configFilename)
+}
+
+// RunServers starts the conodes of the config files in this process, for
+// small test networks or clusters on one machine, and returns once they are
+// all stopped. The conodes need their own keys and ports, including the one
+// of the websocket, and the same limits of the messages, as they are the
+// same for the whole process. SIGHUP reloads the configuration of all of
+// them.
+func RunServers(configFilenames ...string) {
+	var hcs []*CothorityConfig
+	for _, file := range configFilenames {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			log.Fatalf("[-] Configuration file does not exist. %s", file)
+		}
+		hc, err := LoadCothority(file)
+		if err != nil {
+			log.Fatal("Couldn't parse config:", err)
+		}
+		hcs = append(hcs, hc)
+	}
+	if err := checkCluster(hcs); err != nil {
+		log.Fatal("Couldn't run the conodes together:", err)
 	}
-	// Let's read the config
-	_, server, err := ParseCothority(configFilename)
+
+	servers := make([]*onet.Server, len(hcs))
+	for i, hc := range hcs {
+		servers[i] = setupServer(hc, configFilenames[i])
+	}
+
+	// SIGHUP reloads the configuration of the services
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer func() {
+		signal.Stop(hup)
+		close(hup)
+	}()
+	go func() {
+		for range hup {
+			for i, server := range servers {
+				updated, err := ReloadConfigFile(server, configFilenames[i])
+				if err != nil {
+					log.Error("Couldn't reload config:", err)
+					continue
+				}
+				log.Lvl1("Reloaded configuration sections", updated)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *onet.Server) {
+			defer wg.Done()
+			server.Start()
+		}(server)
+	}
+	wg.Wait()
+}
+
+// setupServer returns the conode of the config file, with its gRPC gateway,
+// its services configured and shutting down on SIGINT and SIGTERM.
+func setupServer(hc *CothorityConfig, configFilename string) *onet.Server {
+	server, err := hc.NewServer()
 	if err != nil {
 		log.Fatal("Couldn't parse config:", err)
 	}
-	server.Start()
+	if hc.GRPCAddress != "" {
+		// the gateway uses the certificate of the websocket, if any
+		server.WebSocket.Lock()
+		tlsConfig := server.WebSocket.TLSConfig
+		server.WebSocket.Unlock()
+		if _, err := server.StartGRPC(hc.GRPCAddress, tlsConfig); err != nil {
+			log.Fatal("Couldn't start the gRPC gateway:", err)
+		}
+	}
+	if _, err := ReloadConfigFile(server, configFilename); err != nil {
+		log.Fatal("Couldn't configure services:", err)
+	}
+	server.SetConfigReloader(func() ([]string, error) {
+		return ReloadConfigFile(server, configFilename)
+	})
+
+	timeout := shutdownTimeout
+	if hc.ShutdownTimeout != "" {
+		timeout, err = time.ParseDuration(hc.ShutdownTimeout)
+		if err != nil {
+			log.Fatal("Couldn't parse the shutdown timeout:", err)
+		}
+	}
+	server.ShutdownOnSignal(timeout)
+	return server
+}
+
+// checkCluster returns an error if the conodes of the configurations can't
+// run in the same process. The ports are compared whatever the addresses,
+// as the conodes often listen on all the interfaces.
+func checkCluster(hcs []*CothorityConfig) error {
+	publics := make(map[string]bool)
+	ports := make(map[string]bool)
+	use := func(port string) error {
+		if ports[port] {
+			return xerrors.New("two conodes use the port " + port)
+		}
+		ports[port] = true
+		return nil
+	}
+	var first network.DecodeLimits
+	for i, hc := range hcs {
+		if publics[hc.Public] {
+			return xerrors.New("two conodes have the key " + hc.Public)
+		}
+		publics[hc.Public] = true
+		limits, err := hc.DecodeLimits()
+		if err != nil {
+			return xerrors.Errorf("message limits: %v", err)
+		}
+		if i == 0 {
+			first = limits
+		} else if limits != first {
+			return xerrors.New("the conodes have different message limits")
+		}
+
+		port := hc.Address.Port()
+		if hc.ListenAddress != "" {
+			_, p, err := net.SplitHostPort(hc.ListenAddress)
+			if err != nil {
+				return xerrors.Errorf("listen address: %v", err)
+			}
+			port = p
+		}
+		if err := use(port); err != nil {
+			return err
+		}
+		switch {
+		case hc.WebSocketUnixSocket != "":
+			if err := use(hc.WebSocketUnixSocket); err != nil {
+				return err
+			}
+		case hc.WebSocketListenAddress != "":
+			_, p, err := net.SplitHostPort(hc.WebSocketListenAddress)
+			if err != nil {
+				return xerrors.Errorf("websocket listen address: %v", err)
+			}
+			if err := use(p); err != nil {
+				return err
+			}
+		default:
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return xerrors.Errorf("port: %v", err)
+			}
+			if err := use(strconv.Itoa(p + 1)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
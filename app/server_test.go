@@ -3,11 +3,13 @@ package app
 import (
 	"io/ioutil"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
 )
 
 func TestInteractiveConfig(t *testing.T) {
@@ -36,3 +38,24 @@ func TestInteractiveConfig(t *testing.T) {
 
 	log.ErrFatal(os.RemoveAll(tmp))
 }
+
+func TestCheckCluster(t *testing.T) {
+	conode := func(public string, port int) *CothorityConfig {
+		return &CothorityConfig{
+			Public:  public,
+			Address: network.NewTLSAddress("127.0.0.1:" + strconv.Itoa(port)),
+		}
+	}
+	require.NoError(t, checkCluster([]*CothorityConfig{conode("a", 7770), conode("b", 7772)}))
+	// the websocket of the first uses the port of the second
+	require.Error(t, checkCluster([]*CothorityConfig{conode("a", 7770), conode("b", 7771)}))
+	require.Error(t, checkCluster([]*CothorityConfig{conode("a", 7770), conode("a", 7772)}))
+
+	b := conode("b", 7772)
+	b.WebSocketListenAddress = "127.0.0.1:7771"
+	require.Error(t, checkCluster([]*CothorityConfig{conode("a", 7770), b}))
+	b.WebSocketListenAddress = "127.0.0.1:7780"
+	require.NoError(t, checkCluster([]*CothorityConfig{conode("a", 7770), b}))
+	b.MaxMessageSize = 1000
+	require.Error(t, checkCluster([]*CothorityConfig{conode("a", 7770), b}))
+}
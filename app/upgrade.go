@@ -0,0 +1,72 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"golang.org/x/xerrors"
+)
+
+// AdminTokenEnv is the environment variable with the admin token of the
+// conodes, if it isn't given with -token.
+const AdminTokenEnv = "CONODE_ADMIN_TOKEN"
+
+// UpgradeMembers returns the members of a rolling upgrade of the conodes of
+// the roster, in its order, all with the same admin token.
+func UpgradeMembers(gt *GroupToml, token string) ([]onet.UpgradeMember, error) {
+	members := make([]onet.UpgradeMember, len(gt.Servers))
+	for i, s := range gt.Servers {
+		u, err := webSocketURL(s)
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", s.Address, err)
+		}
+		members[i] = onet.UpgradeMember{Name: s.Address.String(), URL: u, Token: token}
+	}
+	return members, nil
+}
+
+// UpgradeCommand restarts the conodes of the roster given in args one after
+// the other, with onet.RollingUpgrade, and writes its progress to out. The
+// conodes must be restarted by their supervisor once they shut down. The
+// flags are -token with the admin token, AdminTokenEnv by default,
+// -max-down, -drain, -rejoin and -version, the version the conodes must run
+// after the upgrade.
+func UpgradeCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	fs.SetOutput(out)
+	token := fs.String("token", os.Getenv(AdminTokenEnv), "admin token of the conodes")
+	cfg := onet.UpgradeConfig{}
+	fs.IntVar(&cfg.MaxDown, "max-down", 0, "how many conodes may be down, a third of them if 0")
+	fs.DurationVar(&cfg.DrainTimeout, "drain", 30*time.Second, "how long the protocols have to finish")
+	fs.DurationVar(&cfg.RejoinTimeout, "rejoin", 5*time.Minute, "how long a conode may take to come back")
+	fs.StringVar(&cfg.Version, "version", "", "version the conodes must run after the upgrade")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return xerrors.New("upgrade needs the roster")
+	}
+	if *token == "" {
+		return xerrors.New("no admin token, give it with -token or " + AdminTokenEnv)
+	}
+	gt, err := ReadGroupToml(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg.Members, err = UpgradeMembers(gt, *token)
+	if err != nil {
+		return err
+	}
+	cfg.Progress = func(ue onet.UpgradeEvent) {
+		fmt.Fprintln(out, ue)
+	}
+	if _, err := onet.RollingUpgrade(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d conodes upgraded\n", len(cfg.Members))
+	return nil
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+)
+
+// fakeConode answers like the websocket of a conode, which is restarted
+// with version v2 shortly after it is asked to shut down.
+type fakeConode struct {
+	sync.Mutex
+	started  time.Time
+	version  string
+	restarts int
+}
+
+func (fc *fakeConode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fc.Lock()
+	defer fc.Unlock()
+	if time.Now().Before(fc.started) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/status":
+		st := onet.DetailedStatus{Uptime: time.Since(fc.started)}
+		st.Build.Version = fc.version
+		json.NewEncoder(w).Encode(&st)
+	case "/readyz":
+		json.NewEncoder(w).Encode(&onet.HealthReport{Status: "ok", Phase: "ready"})
+	case "/admin/shutdown":
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		fc.started = time.Now().Add(100 * time.Millisecond)
+		fc.version = "v2"
+		fc.restarts++
+	}
+}
+
+func TestUpgradeCommand(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	var conodes []*fakeConode
+	var servers []*ServerToml
+	for i, pub := range []string{rosterPub1, rosterPub2} {
+		fc := &fakeConode{started: time.Now().Add(-time.Hour), version: "v1"}
+		conodes = append(conodes, fc)
+		ws := httptest.NewServer(fc)
+		defer ws.Close()
+		s := rosterEntry(fmt.Sprintf("127.0.0.1:%d", i+1), pub, "")
+		s.URL = ws.URL
+		servers = append(servers, s)
+	}
+	roster := path.Join(tmp, "roster.toml")
+	require.NoError(t, NewGroupToml(servers...).Save(roster))
+
+	var o bytes.Buffer
+	require.Error(t, UpgradeCommand([]string{roster}, &o))
+	require.Error(t, UpgradeCommand([]string{"-token", "wrong", roster}, &o))
+	require.Equal(t, 0, conodes[0].restarts)
+
+	o.Reset()
+	require.NoError(t, UpgradeCommand([]string{"-token", "secret", "-version", "v2", roster}, &o), o.String())
+	require.Contains(t, o.String(), "127.0.0.1:2: done")
+	require.Contains(t, o.String(), "2 conodes upgraded")
+	for _, fc := range conodes {
+		require.Equal(t, 1, fc.restarts)
+	}
+
+	// the conodes already run v2, so they don't become v3
+	require.Error(t, UpgradeCommand([]string{"-token", "secret", "-version", "v3", "-rejoin", "500ms", roster}, &o))
+	require.Contains(t, o.String(), "runs version v2 instead of v3")
+	require.Equal(t, 2, conodes[0].restarts)
+	require.Equal(t, 1, conodes[1].restarts)
+}
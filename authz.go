@@ -0,0 +1,152 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// maximum number of events kept in the audit log of a server
+const auditLogSize = 1024
+
+// PeerAuthorizer decides whether the server that sent a message may invoke
+// a handler. The identity of the sender is the one of the connection, which
+// is only authenticated for TLS connections.
+type PeerAuthorizer func(si *network.ServerIdentity) bool
+
+// AllowPeers returns a PeerAuthorizer accepting only the given servers.
+func AllowPeers(ids ...network.ServerIdentityID) PeerAuthorizer {
+	allowed := make(map[network.ServerIdentityID]bool)
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	return func(si *network.ServerIdentity) bool {
+		return allowed[si.ID]
+	}
+}
+
+// AllowRoster returns a PeerAuthorizer accepting only the members of the
+// roster.
+func AllowRoster(ro *Roster) PeerAuthorizer {
+	var ids []network.ServerIdentityID
+	for _, si := range ro.List {
+		ids = append(ids, si.ID)
+	}
+	return AllowPeers(ids...)
+}
+
+// AuditEvent is an entry of the audit log of a server.
+type AuditEvent struct {
+	Time    time.Time
+	Service string
+	// Handler is the message type or RPC method that was invoked.
+	Handler string
+	Peer    *network.ServerIdentity
+	// Client is the name of the admin token, for the operations of the
+	// admin API.
+	Client string
+	Event  string
+}
+
+// auditLog keeps the last auditLogSize events.
+type auditLog struct {
+	events []AuditEvent
+	sync.Mutex
+}
+
+func (a *auditLog) add(e AuditEvent) {
+	if e.Peer != nil {
+		log.Warnf("audit: %s: %s of %s invoked by %s", e.Event, e.Handler, e.Service, e.Peer)
+	} else {
+		log.Warnf("audit: %s: %s of %s invoked by %s", e.Event, e.Handler, e.Service, e.Client)
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.events = append(a.events, e)
+	if len(a.events) > auditLogSize {
+		a.events = a.events[len(a.events)-auditLogSize:]
+	}
+}
+
+// AuditLog returns the events of the audit log, oldest first.
+func (c *Server) AuditLog() []AuditEvent {
+	c.audit.Lock()
+	defer c.audit.Unlock()
+	return append([]AuditEvent{}, c.audit.events...)
+}
+
+// authzRule is a PeerAuthorizer with the name of the service that set it.
+type authzRule struct {
+	service string
+	allow   PeerAuthorizer
+}
+
+// peerAuthz holds the rules of the services of a server, keyed by message
+// type for the processors and by service and method for the RPC handlers.
+type peerAuthz struct {
+	messages map[network.MessageTypeID]authzRule
+	methods  map[string]authzRule
+	sync.Mutex
+}
+
+func newPeerAuthz() *peerAuthz {
+	return &peerAuthz{
+		messages: make(map[network.MessageTypeID]authzRule),
+		methods:  make(map[string]authzRule),
+	}
+}
+
+// check returns true if there is no rule for the handler, if the message
+// comes from ourselves or if the rule accepts the sender. Refusals are
+// added to the audit log.
+func (pa *peerAuthz) check(srv *Server, si *network.ServerIdentity, r authzRule, ok bool, handler string) bool {
+	if !ok || si.ID.Equal(srv.ServerIdentity.ID) || r.allow(si) {
+		return true
+	}
+	srv.audit.add(AuditEvent{
+		Time:    time.Now(),
+		Service: r.service,
+		Handler: handler,
+		Peer:    si,
+		Event:   "denied",
+	})
+	return false
+}
+
+func (pa *peerAuthz) allowMessage(srv *Server, si *network.ServerIdentity, msgType network.MessageTypeID) bool {
+	pa.Lock()
+	r, ok := pa.messages[msgType]
+	pa.Unlock()
+	return pa.check(srv, si, r, ok, msgType.String())
+}
+
+func (pa *peerAuthz) allowMethod(srv *Server, si *network.ServerIdentity, service, method string) bool {
+	pa.Lock()
+	r, ok := pa.methods[service+"/"+method]
+	pa.Unlock()
+	return pa.check(srv, si, r, ok, method)
+}
+
+// AuthorizeMessage restricts the servers that may send messages of the
+// given type to the processor registered by this service. Messages from
+// other servers are dropped and logged to the audit log of the server.
+func (c *Context) AuthorizeMessage(msgType network.MessageTypeID, a PeerAuthorizer) {
+	pa := c.server.authz
+	pa.Lock()
+	pa.messages[msgType] = authzRule{ServiceFactory.Name(c.serviceID), a}
+	pa.Unlock()
+}
+
+// AuthorizeRPC restricts the servers that may call the RPC handler of this
+// service for the given method, which is the name of the request structure
+// without its package. Refused calls return an error to the caller and are
+// logged to the audit log of the server.
+func (c *Context) AuthorizeRPC(method string, a PeerAuthorizer) {
+	name := ServiceFactory.Name(c.serviceID)
+	pa := c.server.authz
+	pa.Lock()
+	pa.methods[name+"/"+method] = authzRule{name, a}
+	pa.Unlock()
+}
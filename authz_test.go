@@ -0,0 +1,83 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const authzServiceName = "authzService"
+
+type authzMsg struct {
+	Val int
+}
+
+var authzMsgID = network.RegisterMessage(authzMsg{})
+
+type authzService struct {
+	*rpcService
+	got chan *network.ServerIdentity
+}
+
+func newAuthzService(c *Context) (Service, error) {
+	rs, err := newRPCService(c)
+	if err != nil {
+		return nil, err
+	}
+	s := &authzService{rpcService: rs.(*rpcService), got: make(chan *network.ServerIdentity, 10)}
+	c.RegisterProcessorFunc(authzMsgID, func(env *network.Envelope) error {
+		s.got <- env.ServerIdentity
+		return nil
+	})
+	return s, nil
+}
+
+func TestAuthz(t *testing.T) {
+	sid, err := RegisterNewService(authzServiceName, newAuthzService)
+	require.NoError(t, err)
+	defer UnregisterService(authzServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(3)
+	services := local.GetServices(servers, sid)
+	s0 := services[0].(*authzService)
+	s0.AuthorizeMessage(authzMsgID, AllowPeers(servers[1].ServerIdentity.ID))
+	s0.AuthorizeRPC("rpcPing", AllowRoster(local.GenRosterFromHost(servers[:2]...)))
+
+	// denied message
+	_, err = servers[2].Send(servers[0].ServerIdentity, &authzMsg{})
+	require.NoError(t, err)
+	// allowed message
+	_, err = servers[1].Send(servers[0].ServerIdentity, &authzMsg{})
+	require.NoError(t, err)
+	select {
+	case si := <-s0.got:
+		require.True(t, si.Equal(servers[1].ServerIdentity))
+	case <-time.After(time.Second):
+		t.Fatal("allowed message didn't arrive")
+	}
+	// messages from ourselves are always allowed
+	_, err = servers[0].Send(servers[0].ServerIdentity, &authzMsg{})
+	require.NoError(t, err)
+	require.True(t, (<-s0.got).Equal(servers[0].ServerIdentity))
+	require.Len(t, s0.got, 0)
+
+	resp := &rpcPong{}
+	s1 := services[1].(*authzService)
+	s2 := services[2].(*authzService)
+	require.NoError(t, s1.Call(servers[0].ServerIdentity, &rpcPing{}, resp, time.Second))
+	err = s2.Call(servers[0].ServerIdentity, &rpcPing{}, resp, time.Second)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not authorized")
+
+	events := servers[0].AuditLog()
+	require.Len(t, events, 2)
+	require.Equal(t, authzServiceName, events[0].Service)
+	require.Equal(t, authzMsgID.String(), events[0].Handler)
+	require.True(t, events[0].Peer.Equal(servers[2].ServerIdentity))
+	require.Equal(t, "rpcPing", events[1].Handler)
+	require.True(t, events[1].Peer.Equal(servers[2].ServerIdentity))
+	require.Len(t, servers[1].AuditLog(), 0)
+}
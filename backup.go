@@ -0,0 +1,243 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// Snapshot is a copy of the buckets of a storage at one point in time. The
+// values are not encrypted, even if the storage is.
+type Snapshot struct {
+	// Created is the time of the snapshot in nanoseconds since the epoch.
+	Created int64
+	Buckets []SnapshotBucket
+	// Hash covers all the fields above, see Snapshot.ComputeHash.
+	Hash []byte
+}
+
+// SnapshotBucket holds all the entries of a bucket.
+type SnapshotBucket struct {
+	Name    []byte
+	Entries []SnapshotEntry
+}
+
+// SnapshotEntry is a key/value pair of a bucket.
+type SnapshotEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// ComputeHash returns the hash of the content of the snapshot. Every slice
+// is prefixed with its length, so that no two snapshots have the same
+// input to the hash.
+func (s *Snapshot) ComputeHash() []byte {
+	h := sha256.New()
+	write := func(b []byte) {
+		binary.Write(h, binary.LittleEndian, uint64(len(b)))
+		h.Write(b)
+	}
+	binary.Write(h, binary.LittleEndian, s.Created)
+	binary.Write(h, binary.LittleEndian, uint64(len(s.Buckets)))
+	for _, b := range s.Buckets {
+		write(b.Name)
+		binary.Write(h, binary.LittleEndian, uint64(len(b.Entries)))
+		for _, e := range b.Entries {
+			write(e.Key)
+			write(e.Value)
+		}
+	}
+	return h.Sum(nil)
+}
+
+// ReadSnapshot decodes a snapshot written by Server.Backup or
+// Context.Backup and checks its hash.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, xerrors.Errorf("reading: %v", err)
+	}
+	s := &Snapshot{}
+	if err := protobuf.Decode(buf, s); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	if !bytes.Equal(s.Hash, s.ComputeHash()) {
+		return nil, xerrors.New("wrong hash, the snapshot is corrupted")
+	}
+	return s, nil
+}
+
+// takeSnapshot copies the buckets for which keep returns true in one
+// read-only transaction, so the snapshot is consistent while the services
+// keep writing.
+func takeSnapshot(st Storage, keep func(name []byte) bool) (*Snapshot, error) {
+	s := &Snapshot{Created: time.Now().UnixNano()}
+	err := st.View(func(tx StorageTx) error {
+		for _, name := range tx.Buckets() {
+			if !keep(name) {
+				continue
+			}
+			b := SnapshotBucket{Name: append([]byte{}, name...)}
+			err := tx.ForEach(name, func(k, v []byte) error {
+				if v == nil {
+					// nested bucket of bbolt
+					return nil
+				}
+				b.Entries = append(b.Entries, SnapshotEntry{
+					Key:   append([]byte{}, k...),
+					Value: append([]byte{}, v...),
+				})
+				return nil
+			})
+			if err != nil {
+				return xerrors.Errorf("bucket %s: %v", name, err)
+			}
+			s.Buckets = append(s.Buckets, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	s.Hash = s.ComputeHash()
+	return s, nil
+}
+
+func writeSnapshot(w io.Writer, s *Snapshot) error {
+	buf, err := protobuf.Encode(s)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return xerrors.Errorf("writing: %v", err)
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the content of the storage with the snapshot,
+// in one transaction. The buckets that are not in the snapshot are
+// emptied.
+func restoreSnapshot(st Storage, s *Snapshot) error {
+	return st.Update(func(tx StorageTx) error {
+		for _, name := range tx.Buckets() {
+			var keys [][]byte
+			err := tx.ForEach(name, func(k, v []byte) error {
+				if v != nil {
+					keys = append(keys, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return xerrors.Errorf("bucket %s: %v", name, err)
+			}
+			for _, k := range keys {
+				if err := tx.Delete(name, k); err != nil {
+					return xerrors.Errorf("delete: %v", err)
+				}
+			}
+		}
+		for _, b := range s.Buckets {
+			if err := tx.CreateBucket(b.Name); err != nil {
+				return xerrors.Errorf("creating bucket: %v", err)
+			}
+			for _, e := range b.Entries {
+				if err := tx.Put(b.Name, e.Key, e.Value); err != nil {
+					return xerrors.Errorf("put: %v", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// restoreSnapshotFile restores the snapshot stored in the file.
+func restoreSnapshotFile(st Storage, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return xerrors.Errorf("opening snapshot: %v", err)
+	}
+	defer f.Close()
+	s, err := ReadSnapshot(f)
+	if err != nil {
+		return err
+	}
+	log.Lvlf1("Restoring snapshot of %s with %d buckets",
+		time.Unix(0, s.Created), len(s.Buckets))
+	return restoreSnapshot(st, s)
+}
+
+// Backup writes a snapshot of the buckets of all the services to w. It can
+// be called while the server is running, and the snapshot can be given
+// back to the server with StorageConfig.Restore.
+func (c *Server) Backup(w io.Writer) error {
+	s, err := takeSnapshot(c.serviceManager.storage, func([]byte) bool { return true })
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, s)
+}
+
+// Backup writes a snapshot of the buckets of the service to w: the one of
+// Save and Load, the one of the version and the ones returned by
+// GetAdditionalBucket.
+func (c *Context) Backup(w io.Writer) error {
+	additional := append(append([]byte{}, c.bucketName...), '_')
+	s, err := takeSnapshot(c.manager.storage, func(name []byte) bool {
+		return bytes.Equal(name, c.bucketName) ||
+			bytes.Equal(name, c.bucketVersionName) ||
+			bytes.HasPrefix(name, additional)
+	})
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(w, s)
+}
+
+func allowBackup() bool {
+	return os.Getenv("ONET_ALLOW_BACKUP") != ""
+}
+
+// serveBackup sends a snapshot of the storage to the operators with an
+// admin token, as the snapshot isn't encrypted.
+func (c *Server) serveBackup(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	name, ok := c.verifyAdminToken(strings.TrimPrefix(auth, "Bearer "))
+	if !strings.HasPrefix(auth, "Bearer ") || !ok {
+		log.Warn("Refusing backup request from", r.RemoteAddr)
+		http.Error(w, "admin token required", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	log.Lvl1("Sending a backup to", name, "at", r.RemoteAddr)
+	c.audit.add(AuditEvent{
+		Time:    time.Now(),
+		Service: "admin",
+		Handler: "backup",
+		Client:  name,
+		Event:   "admin",
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	s, err := takeSnapshot(c.serviceManager.storage, func([]byte) bool { return true })
+	if err != nil {
+		log.Error("Backup failed:", err)
+		http.Error(w, "backup failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := writeSnapshot(w, s); err != nil {
+		log.Error("Sending backup:", err)
+	}
+}
@@ -0,0 +1,105 @@
+package onet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Backup(t *testing.T) {
+	os.Setenv("ONET_ALLOW_BACKUP", "1")
+	defer os.Unsetenv("ONET_ALLOW_BACKUP")
+	var c *Context
+	RegisterNewService("backupService", func(ctx *Context) (Service, error) {
+		c = ctx
+		return &DummyService{c: ctx}, nil
+	})
+	defer UnregisterService("backupService")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+
+	require.NoError(t, c.Save([]byte("key"), &authzMsg{1}))
+	_, additional := c.GetAdditionalBucket([]byte("extra"))
+	require.NoError(t, c.manager.storage.Update(func(tx StorageTx) error {
+		return tx.Put(additional, []byte("a"), []byte("b"))
+	}))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, servers[0].Backup(buf))
+	full, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.True(t, len(full.Buckets) > 3)
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt[len(corrupt)/2] ^= 1
+	_, err = ReadSnapshot(bytes.NewReader(corrupt))
+	require.Error(t, err)
+
+	buf.Reset()
+	require.NoError(t, c.Backup(buf))
+	s, err := ReadSnapshot(buf)
+	require.NoError(t, err)
+	var names []string
+	for _, b := range s.Buckets {
+		names = append(names, string(b.Name))
+	}
+	require.Equal(t, []string{"backupService", "backupService_extra", "backupServiceversion"}, names)
+
+	tmp, err := ioutil.TempFile("", "snapshot")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	require.NoError(t, servers[0].Backup(tmp))
+	tmp.Close()
+	restored, err := openStorage(StorageConfig{Backend: "memory", Restore: tmp.Name()}, "")
+	require.NoError(t, err)
+	require.NoError(t, restored.View(func(tx StorageTx) error {
+		require.Equal(t, []byte("b"), getValue(t, tx, additional, []byte("a")))
+		return nil
+	}))
+
+	// restoring replaces what was there
+	st := NewMemoryStorage()
+	require.NoError(t, st.Update(func(tx StorageTx) error {
+		require.NoError(t, tx.CreateBucket([]byte("backupService")))
+		return tx.Put([]byte("backupService"), []byte("other"), []byte("x"))
+	}))
+	require.NoError(t, restoreSnapshot(st, full))
+	require.NoError(t, st.View(func(tx StorageTx) error {
+		require.Nil(t, getValue(t, tx, []byte("backupService"), []byte("other")))
+		require.Equal(t, []byte("b"), getValue(t, tx, additional, []byte("a")))
+		require.NotNil(t, getValue(t, tx, []byte("backupService"), []byte("key")))
+		return nil
+	}))
+
+	hp, err := getWSHostPort(servers[0].ServerIdentity, false)
+	require.NoError(t, err)
+	require.NoError(t, servers[0].AddAdminToken("alice", "admin-secret"))
+	get := func(token string) *http.Response {
+		req, err := http.NewRequest("GET", "http://"+hp+"/backup", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", "http://example.com")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	resp := get("")
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = get("wrong")
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("admin-secret")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	_, err = ReadSnapshot(resp.Body)
+	require.NoError(t, err)
+}
@@ -0,0 +1,423 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// beaconProtocolName is the CollectProtocol running the phases of the rounds
+// of the beacons.
+const beaconProtocolName = "RandomBeacon"
+
+// DefaultBeaconTimeout is how long each phase of a round waits for the
+// members when no timeout is given.
+const DefaultBeaconTimeout = 5 * time.Second
+
+// beaconHistory is how many outputs a Beacon keeps.
+const beaconHistory = 16
+
+// the phases of a round
+const (
+	beaconCommit = iota
+	beaconReveal
+	beaconPublish
+)
+
+// BeaconRequestMsgID of BeaconRequest message as registered in network
+var BeaconRequestMsgID = network.RegisterMessage(BeaconRequest{})
+
+// BeaconSharesMsgID of BeaconShares message as registered in network
+var BeaconSharesMsgID = network.RegisterMessage(BeaconShares{})
+
+func init() {
+	GlobalProtocolRegister(beaconProtocolName, NewCollectProtocol(respondBeacon, reduceBeaconShares))
+}
+
+// BeaconRequest is a phase of a round of a Beacon: the members first commit
+// to a fresh secret, then reveal it once given the commits, and finally check
+// and keep the output computed from the secrets.
+type BeaconRequest struct {
+	Round   uint64
+	Phase   int
+	Commits []BeaconShare
+	Secrets []BeaconShare
+}
+
+// BeaconShares are the commits, secrets or acknowledgements of the members of
+// a subtree for a BeaconRequest.
+type BeaconShares struct {
+	Shares []BeaconShare
+}
+
+// BeaconShare is the commit or the secret of a member, by its index in the
+// roster.
+type BeaconShare struct {
+	Index int
+	Data  []byte
+	// Signature is the Schnorr signature of the member on its commit, so
+	// that the member running the round can't replace the commits of the
+	// others with its own.
+	Signature []byte
+}
+
+// BeaconOutput is the randomness of a round of a Beacon.
+type BeaconOutput struct {
+	Round      uint64
+	Randomness []byte
+	// Contributors are the indexes in the roster of the members whose
+	// secret is in the randomness.
+	Contributors []int
+}
+
+// Beacon produces collective randomness for the roster of a service, by
+// rounds of commit-reveal among its members. A round needs a majority of the
+// members, and any of them can start one with Next, or Start can run them
+// periodically from the leader of the roster. The commits are signed by the
+// members, but a member can still bias the randomness by withholding its
+// secret once it saw the other ones, and the member running the round by
+// leaving out some of the secrets or by not publishing the output. So it is
+// meant for the choices that only need to be unpredictable beforehand, like
+// the random trees and the gossip peers.
+type Beacon struct {
+	service ServiceID
+	roster  *Roster
+	overlay *Overlay
+
+	// rounds serializes the rounds we run
+	rounds sync.Mutex
+	lock   sync.Mutex
+	// round is the latest round recorded
+	round uint64
+	// the secrets of the rounds in progress, by the member running them
+	secrets  map[network.ServerIdentityID]beaconSecret
+	outputs  []*BeaconOutput
+	onOutput []func(*BeaconOutput)
+	stop     chan struct{}
+}
+
+// Beacon returns the random beacon of the roster for the service, the same
+// for all the calls with the roster.
+func (c *Context) Beacon(ro *Roster) *Beacon {
+	return c.overlay.beacon(c.serviceID, ro)
+}
+
+func (o *Overlay) beacon(service ServiceID, ro *Roster) *Beacon {
+	o.beaconsLock.Lock()
+	defer o.beaconsLock.Unlock()
+	rosters, ok := o.beacons[service]
+	if !ok {
+		rosters = make(map[RosterID]*Beacon)
+		o.beacons[service] = rosters
+	}
+	b, ok := rosters[ro.ID]
+	if !ok {
+		b = &Beacon{service: service, roster: ro, overlay: o,
+			secrets: make(map[network.ServerIdentityID]beaconSecret)}
+		rosters[ro.ID] = b
+	}
+	return b
+}
+
+// Latest returns the output of the latest round known, nil if there is none.
+func (b *Beacon) Latest() *BeaconOutput {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.outputs) == 0 {
+		return nil
+	}
+	return b.outputs[len(b.outputs)-1]
+}
+
+// Output returns the output of the round, nil if it is unknown or too old.
+func (b *Beacon) Output(round uint64) *BeaconOutput {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, out := range b.outputs {
+		if out.Round == round {
+			return out
+		}
+	}
+	return nil
+}
+
+// OnOutput registers a function called with the output of every round this
+// member learns.
+func (b *Beacon) OnOutput(f func(*BeaconOutput)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.onOutput = append(b.onOutput, f)
+}
+
+// Start runs a round at every period, as long as we are the leader of the
+// roster for the service, until Stop is called or the server is closed.
+func (b *Beacon) Start(period time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	b.stop = stop
+	leader := b.overlay.leaderElection(b.service, b.roster)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-b.overlay.server.Clock().After(period):
+			}
+			if !leader.Leader().Equal(b.overlay.ServerIdentity()) {
+				continue
+			}
+			if _, err := b.Next(0); err != nil {
+				log.Lvl2(b.overlay.ServerIdentity(), "beacon round failed:", err)
+			}
+		}
+	}()
+}
+
+// Stop stops the rounds run by Start.
+func (b *Beacon) Stop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+}
+
+// Next runs a round and returns its output, once a majority of the members
+// revealed their secret. Each phase waits for the members at most timeout.
+func (b *Beacon) Next(timeout time.Duration) (*BeaconOutput, error) {
+	if timeout <= 0 {
+		timeout = DefaultBeaconTimeout
+	}
+	b.rounds.Lock()
+	defer b.rounds.Unlock()
+	quorum := len(b.roster.List)/2 + 1
+	b.lock.Lock()
+	round := b.round + 1
+	b.lock.Unlock()
+
+	commits, err := b.phase(&BeaconRequest{Round: round, Phase: beaconCommit}, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("collecting commits: %v", err)
+	}
+	if len(commits) < quorum {
+		return nil, xerrors.Errorf("%d commits for round %d, %d needed", len(commits), round, quorum)
+	}
+	secrets, err := b.phase(&BeaconRequest{Round: round, Phase: beaconReveal, Commits: commits}, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("collecting secrets: %v", err)
+	}
+	out, err := beaconOutput(b.overlay.suite(), b.roster, round, commits, secrets)
+	if err != nil {
+		return nil, err
+	}
+	acks, err := b.phase(&BeaconRequest{Round: round, Phase: beaconPublish, Commits: commits, Secrets: secrets}, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("publishing: %v", err)
+	}
+	if len(acks) < len(b.roster.List) {
+		log.Lvl2(b.overlay.ServerIdentity(), "beacon round", round, "published to", len(acks), "members")
+	}
+	return out, nil
+}
+
+// phase runs the phase on a star rooted at us and returns the shares of the
+// members, sorted by their index.
+func (b *Beacon) phase(req *BeaconRequest, timeout time.Duration) ([]BeaconShare, error) {
+	resp, err := b.overlay.collectStar(beaconProtocolName, b.roster, b.service, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, nil
+	}
+	shares := resp.(*BeaconShares).Shares
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Index < shares[j].Index })
+	return shares, nil
+}
+
+// beaconSecret is the secret of a member for a round in progress.
+type beaconSecret struct {
+	round uint64
+	data  []byte
+}
+
+// answer returns the share of the member at index for the request of the
+// round run by root. Every member running a round has its own secrets, so
+// that another one starting a round doesn't stop it.
+func (b *Beacon) answer(root network.ServerIdentityID, index int, req *BeaconRequest) (*BeaconShare, error) {
+	switch req.Phase {
+	case beaconCommit:
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if req.Round <= b.round {
+			return nil, xerrors.Errorf("round %d already done", req.Round)
+		}
+		secret := random.Bits(256, true, random.New())
+		b.secrets[root] = beaconSecret{req.Round, secret}
+		commit := sha256.Sum256(secret)
+		sig, err := schnorr.Sign(b.overlay.suite(), b.overlay.server.private,
+			beaconCommitMessage(b.roster, req.Round, index, commit[:]))
+		if err != nil {
+			return nil, xerrors.Errorf("signing: %v", err)
+		}
+		return &BeaconShare{Index: index, Data: commit[:], Signature: sig}, nil
+	case beaconReveal:
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		secret, ok := b.secrets[root]
+		if !ok || secret.round != req.Round {
+			return nil, xerrors.Errorf("no commit for round %d", req.Round)
+		}
+		commit := sha256.Sum256(secret.data)
+		for _, c := range req.Commits {
+			if c.Index == index && bytes.Equal(c.Data, commit[:]) {
+				return &BeaconShare{Index: index, Data: secret.data}, nil
+			}
+		}
+		return nil, xerrors.Errorf("commit missing from round %d", req.Round)
+	case beaconPublish:
+		out, err := beaconOutput(b.overlay.suite(), b.roster, req.Round, req.Commits, req.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		b.record(out)
+		return &BeaconShare{Index: index}, nil
+	}
+	return nil, xerrors.Errorf("unknown beacon phase %d", req.Phase)
+}
+
+// record keeps the output, if it is newer than the ones known, and calls the
+// listeners.
+func (b *Beacon) record(out *BeaconOutput) {
+	b.lock.Lock()
+	if n := len(b.outputs); n > 0 && b.outputs[n-1].Round >= out.Round {
+		b.lock.Unlock()
+		return
+	}
+	if out.Round > b.round {
+		b.round = out.Round
+	}
+	for root, s := range b.secrets {
+		if s.round <= out.Round {
+			delete(b.secrets, root)
+		}
+	}
+	b.outputs = append(b.outputs, out)
+	if len(b.outputs) > beaconHistory {
+		b.outputs = b.outputs[1:]
+	}
+	listeners := append([]func(*BeaconOutput){}, b.onOutput...)
+	b.lock.Unlock()
+	for _, f := range listeners {
+		f(out)
+	}
+}
+
+// beaconCommitMessage is what a member signs with its commit.
+func beaconCommitMessage(ro *Roster, round uint64, index int, commit []byte) []byte {
+	msg := append([]byte("beacon"), ro.ID[:]...)
+	var buf [12]byte
+	binary.BigEndian.PutUint64(buf[:8], round)
+	binary.BigEndian.PutUint32(buf[8:], uint32(index))
+	msg = append(msg, buf[:]...)
+	return append(msg, commit...)
+}
+
+// beaconOutput returns the output of the round from the secrets matching the
+// commits signed by their members, or an error if they are not from a
+// majority of the roster.
+func beaconOutput(suite network.Suite, ro *Roster, round uint64, commits, secrets []BeaconShare) (*BeaconOutput, error) {
+	committed := make(map[int][]byte)
+	for _, c := range commits {
+		if c.Index < 0 || c.Index >= len(ro.List) {
+			continue
+		}
+		err := schnorr.Verify(suite, ro.List[c.Index].Public,
+			beaconCommitMessage(ro, round, c.Index, c.Data), c.Signature)
+		if err != nil {
+			log.Lvl2("beacon: invalid commit of member", c.Index, ":", err)
+			continue
+		}
+		committed[c.Index] = c.Data
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Index < secrets[j].Index })
+
+	h := sha256.New()
+	h.Write(ro.ID[:])
+	binary.Write(h, binary.BigEndian, round)
+	out := &BeaconOutput{Round: round}
+	for _, s := range secrets {
+		commit := sha256.Sum256(s.Data)
+		if c, ok := committed[s.Index]; !ok || !bytes.Equal(c, commit[:]) {
+			continue
+		}
+		if n := len(out.Contributors); n > 0 && out.Contributors[n-1] == s.Index {
+			continue
+		}
+		binary.Write(h, binary.BigEndian, uint32(s.Index))
+		h.Write(s.Data)
+		out.Contributors = append(out.Contributors, s.Index)
+	}
+	if quorum := len(ro.List)/2 + 1; len(out.Contributors) < quorum {
+		return nil, xerrors.Errorf("%d secrets for round %d, %d needed", len(out.Contributors), round, quorum)
+	}
+	out.Randomness = h.Sum(nil)
+	return out, nil
+}
+
+// Rand returns pseudo-random numbers seeded with the randomness, the same for
+// all the members.
+func (out *BeaconOutput) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(out.Randomness))))
+}
+
+// Tree returns an n-ary tree of the roster shuffled with the randomness, the
+// same for all the members.
+func (out *BeaconOutput) Tree(ro *Roster, n int) *Tree {
+	list := make([]*network.ServerIdentity, len(ro.List))
+	for i, p := range out.Rand().Perm(len(ro.List)) {
+		list[i] = ro.List[p]
+	}
+	return NewRoster(list).GenerateNaryTree(n)
+}
+
+// Subset returns a Roster which starts with root and is followed by n
+// elements of ro other than root, picked with the randomness and root, so
+// that every member can check the peers that root samples.
+func (out *BeaconOutput) Subset(ro *Roster, root *network.ServerIdentity, n int) *Roster {
+	seed := sha256.Sum256(append(append([]byte{}, out.Randomness...), root.ID[:]...))
+	r := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))
+	return ro.randomSubset(root, n, r.Perm(len(ro.List)))
+}
+
+func respondBeacon(n *TreeNodeInstance, req network.Message) (network.Message, error) {
+	b := n.overlay.beacon(n.Token().ServiceID, n.Roster())
+	share, err := b.answer(n.Root().ServerIdentity.ID, n.TreeNode().RosterIndex, req.(*BeaconRequest))
+	if err != nil {
+		return nil, err
+	}
+	return &BeaconShares{Shares: []BeaconShare{*share}}, nil
+}
+
+func reduceBeaconShares(responses []network.Message) (network.Message, error) {
+	shares := &BeaconShares{}
+	for _, r := range responses {
+		shares.Shares = append(shares.Shares, r.(*BeaconShares).Shares...)
+	}
+	return shares, nil
+}
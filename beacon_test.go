@@ -0,0 +1,113 @@
+package onet
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeacon_Next(t *testing.T) {
+	contexts := registerLeaderService(t, "beaconTest")
+	defer UnregisterService("beaconTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(4, true)
+
+	b := contexts[servers[1].ServerIdentity.ID].Beacon(ro)
+	require.Nil(t, b.Latest())
+	out, err := b.Next(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), out.Round)
+	require.Equal(t, []int{0, 1, 2, 3}, out.Contributors)
+	for _, s := range servers {
+		other := contexts[s.ServerIdentity.ID].Beacon(ro).Latest()
+		require.NotNil(t, other)
+		require.Equal(t, out.Randomness, other.Randomness)
+		require.Equal(t, out.Tree(ro, 2).Dump(), other.Tree(ro, 2).Dump())
+	}
+
+	// another member starts the next round
+	next, err := contexts[servers[3].ServerIdentity.ID].Beacon(ro).Next(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), next.Round)
+	require.NotEqual(t, out.Randomness, next.Randomness)
+	require.Equal(t, next, b.Output(2))
+	require.Equal(t, out, b.Output(1))
+
+	root := ro.List[2]
+	sub := next.Subset(ro, root, 2)
+	require.Len(t, sub.List, 3)
+	require.True(t, sub.List[0].Equal(root))
+	require.Equal(t, sub.List, next.Subset(ro, root, 2).List)
+}
+
+// Tests that a round only needs a majority of the members, and that the
+// leader runs the rounds periodically.
+func TestBeacon_Majority(t *testing.T) {
+	contexts := registerLeaderService(t, "beaconTest")
+	defer UnregisterService("beaconTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(5, true)
+
+	outputs := make(chan *BeaconOutput, 10)
+	contexts[servers[1].ServerIdentity.ID].Beacon(ro).OnOutput(func(out *BeaconOutput) {
+		outputs <- out
+	})
+	require.NoError(t, servers[4].Close())
+	for _, s := range servers {
+		contexts[s.ServerIdentity.ID].Beacon(ro).Start(200 * time.Millisecond)
+	}
+	out := <-outputs
+	require.Equal(t, uint64(1), out.Round)
+	require.Equal(t, []int{0, 1, 2, 3}, out.Contributors)
+	out = <-outputs
+	require.Equal(t, uint64(2), out.Round)
+	for _, s := range servers[:4] {
+		contexts[s.ServerIdentity.ID].Beacon(ro).Stop()
+	}
+
+	require.NoError(t, servers[3].Close())
+	_, err := contexts[servers[0].ServerIdentity.ID].Beacon(ro).Next(500 * time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, servers[2].Close())
+	_, err = contexts[servers[0].ServerIdentity.ID].Beacon(ro).Next(500 * time.Millisecond)
+	require.Error(t, err)
+}
+
+// Tests that the member running a round can't replace the commits of the
+// others, and that another member starting a round doesn't stop it.
+func TestBeacon_Commits(t *testing.T) {
+	contexts := registerLeaderService(t, "beaconTest")
+	defer UnregisterService("beaconTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(3, true)
+	b := contexts[servers[0].ServerIdentity.ID].Beacon(ro)
+	root := servers[1].ServerIdentity.ID
+
+	// the commits and the secrets invented by the root are refused
+	var commits, secrets []BeaconShare
+	for i := range ro.List {
+		secret := []byte{byte(i)}
+		commit := sha256.Sum256(secret)
+		commits = append(commits, BeaconShare{Index: i, Data: commit[:]})
+		secrets = append(secrets, BeaconShare{Index: i, Data: secret})
+	}
+	_, err := b.answer(root, 0, &BeaconRequest{Round: 1, Phase: beaconPublish,
+		Commits: commits, Secrets: secrets})
+	require.Error(t, err)
+	require.Nil(t, b.Latest())
+
+	commit, err := b.answer(root, 0, &BeaconRequest{Round: 1, Phase: beaconCommit})
+	require.NoError(t, err)
+	_, err = b.answer(servers[2].ServerIdentity.ID, 0, &BeaconRequest{Round: 2, Phase: beaconCommit})
+	require.NoError(t, err)
+	secret, err := b.answer(root, 0, &BeaconRequest{Round: 1, Phase: beaconReveal,
+		Commits: []BeaconShare{*commit}})
+	require.NoError(t, err)
+	hash := sha256.Sum256(secret.Data)
+	require.Equal(t, commit.Data, hash[:])
+}
@@ -0,0 +1,222 @@
+package onet
+
+import (
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// BroadcastMsgID of BroadcastMsg message as registered in network
+var BroadcastMsgID = network.RegisterMessage(BroadcastMsg{})
+
+// BroadcastReceiptMsgID of BroadcastReceipt message as registered in network
+var BroadcastReceiptMsgID = network.RegisterMessage(BroadcastReceipt{})
+
+// BroadcastMsg carries a message down a tree. Every node forwards it to its
+// children, delivers the payload to its own processors and acknowledges it
+// straight to the origin, the root of the tree.
+type BroadcastMsg struct {
+	ID     uuid.UUID
+	Origin *network.ServerIdentity
+	Roster *Roster
+	Tree   *TreeMarshal
+	// Payload is the marshaled message, including its type.
+	Payload []byte
+	// Signature is the signature of the origin, as the one of a SubsetMsg.
+	Signature []byte
+}
+
+// BroadcastReceipt is sent by every node of a BroadcastMsg to the origin
+// once the payload has been delivered.
+type BroadcastReceipt struct {
+	ID uuid.UUID
+}
+
+// broadcastDelivery keeps track of the receipts of a broadcast.
+type broadcastDelivery struct {
+	pending map[network.ServerIdentityID]bool
+	missing int
+	quorum  int
+	done    chan struct{}
+}
+
+// BroadcastAck sends msg to all the nodes of the tree, which relay it along
+// the tree, and returns once quorum of them, counting us, acknowledged it,
+// or after the timeout. A quorum of zero or less waits for all of them. We
+// must be the root of the tree.
+//
+// The returned map holds one entry per node of the tree: nil if it
+// acknowledged the message, or the reason why it hasn't yet. The error is
+// set if the quorum isn't reached.
+func (o *Overlay) BroadcastAck(tree *Tree, msg network.Message, quorum int, timeout time.Duration) (map[network.ServerIdentityID]error, error) {
+	if tree == nil || tree.Root == nil {
+		return nil, xerrors.New("no tree given")
+	}
+	own := o.ServerIdentity()
+	if !tree.Root.ServerIdentity.ID.Equal(own.ID) {
+		return nil, xerrors.New("not the root of the tree")
+	}
+	nodes := tree.List()
+	if quorum <= 0 {
+		quorum = len(nodes)
+	}
+	if quorum > len(nodes) {
+		return nil, xerrors.Errorf("quorum of %d for %d nodes", quorum, len(nodes))
+	}
+	payload, err := network.Marshal(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling: %v", err)
+	}
+
+	reports := make(map[network.ServerIdentityID]error)
+	bm := &BroadcastMsg{
+		ID:      uuid.NewV4(),
+		Origin:  own,
+		Roster:  tree.Roster,
+		Tree:    tree.MakeTreeMarshal(),
+		Payload: payload,
+	}
+	if bm.Signature, err = o.signOrigin("broadcast", bm.ID, payload); err != nil {
+		return nil, xerrors.Errorf("signing: %v", err)
+	}
+	delivery := &broadcastDelivery{
+		pending: make(map[network.ServerIdentityID]bool),
+		missing: quorum,
+		quorum:  quorum,
+		done:    make(chan struct{}),
+	}
+	for _, tn := range nodes {
+		delivery.pending[tn.ServerIdentity.ID] = true
+	}
+	o.broadcastsLock.Lock()
+	o.broadcasts[bm.ID] = delivery
+	o.broadcastsLock.Unlock()
+
+	// the children first, so that they don't wait for our processors
+	for _, child := range tree.Root.Children {
+		if _, err := o.server.Send(child.ServerIdentity, bm); err != nil {
+			reports[child.ServerIdentity.ID] = xerrors.Errorf("sending: %v", err)
+			log.Lvl2(own, "couldn't send broadcast to", child.ServerIdentity, ":", err)
+		}
+	}
+	if _, err := o.server.Send(own, msg); err != nil {
+		reports[own.ID] = xerrors.Errorf("dispatching locally: %v", err)
+	} else {
+		o.receipt(bm.ID, own.ID)
+	}
+
+	select {
+	case <-delivery.done:
+	case <-o.server.Clock().After(timeout):
+	}
+
+	o.broadcastsLock.Lock()
+	delete(o.broadcasts, bm.ID)
+	reached := delivery.missing <= 0
+	for _, tn := range nodes {
+		id := tn.ServerIdentity.ID
+		if _, ok := reports[id]; ok {
+			continue
+		}
+		if delivery.pending[id] {
+			reports[id] = xerrors.New("no acknowledgement before returning")
+		} else {
+			reports[id] = nil
+		}
+	}
+	o.broadcastsLock.Unlock()
+	if !reached {
+		return reports, xerrors.Errorf("quorum of %d not reached", quorum)
+	}
+	return reports, nil
+}
+
+// receipt marks the node as having acknowledged the broadcast.
+func (o *Overlay) receipt(id uuid.UUID, from network.ServerIdentityID) {
+	o.broadcastsLock.Lock()
+	defer o.broadcastsLock.Unlock()
+	delivery, ok := o.broadcasts[id]
+	if !ok || !delivery.pending[from] {
+		return
+	}
+	delete(delivery.pending, from)
+	delivery.missing--
+	if delivery.missing == 0 {
+		close(delivery.done)
+	}
+}
+
+// handleBroadcastMsg relays the message to our children in the tree,
+// delivers the payload to our processors and acknowledges it to the origin.
+func (o *Overlay) handleBroadcastMsg(env *network.Envelope) {
+	bm, ok := env.Msg.(*BroadcastMsg)
+	if !ok {
+		log.Error("not a broadcast message")
+		return
+	}
+	if bm.Origin == nil || bm.Tree == nil || bm.Roster == nil {
+		log.Error("broadcast message without origin or tree")
+		return
+	}
+	if err := o.verifyOrigin("broadcast", bm.Origin, bm.ID, bm.Payload, bm.Signature); err != nil {
+		log.Errorf("broadcast from %s refused: %v", env.ServerIdentity, err)
+		return
+	}
+	tree, err := bm.Tree.MakeTree(bm.Roster)
+	if err != nil {
+		log.Error("broadcast tree:", err)
+		return
+	}
+	if !tree.Root.ServerIdentity.ID.Equal(bm.Origin.ID) {
+		log.Errorf("broadcast from %s refused: the origin isn't the root of the tree", env.ServerIdentity)
+		return
+	}
+	own := o.ServerIdentity()
+	for _, tn := range tree.List() {
+		if !tn.ServerIdentity.ID.Equal(own.ID) {
+			continue
+		}
+		if tn.Parent == nil || !tn.Parent.ServerIdentity.ID.Equal(env.ServerIdentity.ID) {
+			log.Errorf("broadcast from %s refused: not our parent in the tree", env.ServerIdentity)
+			return
+		}
+		for _, child := range tn.Children {
+			if _, err := o.server.Send(child.ServerIdentity, bm); err != nil {
+				log.Lvl2(own, "couldn't relay broadcast to", child.ServerIdentity, ":", err)
+			}
+		}
+		break
+	}
+
+	typ, inner, err := network.Unmarshal(bm.Payload, o.suite())
+	if err != nil {
+		log.Error("unmarshaling broadcast payload:", err)
+		return
+	}
+	err = o.server.Dispatch(&network.Envelope{
+		ServerIdentity: bm.Origin,
+		MsgType:        typ,
+		Msg:            inner,
+		Size:           network.Size(len(bm.Payload)),
+	})
+	if err != nil {
+		log.Error("dispatching broadcast payload:", err)
+		return
+	}
+	if _, err := o.server.Send(bm.Origin, &BroadcastReceipt{ID: bm.ID}); err != nil {
+		log.Lvl2("couldn't acknowledge broadcast to", bm.Origin, ":", err)
+	}
+}
+
+// handleBroadcastReceipt marks the sender of the receipt as delivered.
+func (o *Overlay) handleBroadcastReceipt(env *network.Envelope) {
+	rec, ok := env.Msg.(*BroadcastReceipt)
+	if !ok {
+		log.Error("not a broadcast receipt")
+		return
+	}
+	o.receipt(rec.ID, env.ServerIdentity.ID)
+}
@@ -0,0 +1,97 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+func TestOverlay_BroadcastAck(t *testing.T) {
+	local, servers, count := setupSubset(t, 7)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	tree := ro.GenerateBinaryTree()
+	reports, err := servers[0].overlay.BroadcastAck(tree, &subsetTestMsg{1}, 0, 5*time.Second)
+	require.NoError(t, err)
+	require.Len(t, reports, 7)
+	for i, s := range servers {
+		require.NoError(t, reports[s.ServerIdentity.ID])
+		require.Equal(t, 1, count(i))
+	}
+
+	_, err = servers[1].overlay.BroadcastAck(tree, &subsetTestMsg{1}, 0, time.Second)
+	require.Error(t, err)
+	_, err = servers[0].overlay.BroadcastAck(tree, &subsetTestMsg{1}, 8, time.Second)
+	require.Error(t, err)
+}
+
+// Tests that the quorum is reached without the nodes below a closed one,
+// which are reported.
+func TestOverlay_BroadcastAckQuorum(t *testing.T) {
+	local, servers, _ := setupSubset(t, 7)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	tree := ro.GenerateBinaryTree()
+	closed := tree.Root.Children[0]
+	var below []network.ServerIdentityID
+	closed.Visit(0, func(_ int, tn *TreeNode) {
+		below = append(below, tn.ServerIdentity.ID)
+	})
+	for _, s := range servers {
+		if s.ServerIdentity.ID.Equal(closed.ServerIdentity.ID) {
+			require.NoError(t, s.Close())
+		}
+	}
+
+	reports, err := servers[0].overlay.BroadcastAck(tree, &subsetTestMsg{1}, 4, 5*time.Second)
+	require.NoError(t, err)
+	for _, id := range below {
+		require.Error(t, reports[id])
+	}
+
+	_, err = servers[0].overlay.BroadcastAck(tree, &subsetTestMsg{1}, 5, 500*time.Millisecond)
+	require.Error(t, err)
+}
+
+// Tests that the broadcasts are only accepted from the parent in the tree,
+// signed by the origin.
+func TestOverlay_BroadcastOrigin(t *testing.T) {
+	local, servers, count := setupSubset(t, 3)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	tree := ro.GenerateNaryTree(2)
+	payload, err := network.Marshal(&subsetTestMsg{1})
+	require.NoError(t, err)
+	bm := &BroadcastMsg{
+		ID:      uuid.NewV4(),
+		Origin:  servers[0].ServerIdentity,
+		Roster:  ro,
+		Tree:    tree.MakeTreeMarshal(),
+		Payload: payload,
+	}
+	// servers[2] can't sign for the origin
+	bm.Signature, err = servers[2].overlay.signOrigin("broadcast", bm.ID, payload)
+	require.NoError(t, err)
+	_, err = servers[2].Send(servers[1].ServerIdentity, bm)
+	require.NoError(t, err)
+	// nor relay a broadcast to a node that isn't its child
+	bm.Signature, err = servers[0].overlay.signOrigin("broadcast", bm.ID, payload)
+	require.NoError(t, err)
+	_, err = servers[2].Send(servers[1].ServerIdentity, bm)
+	require.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 0, count(1))
+
+	_, err = servers[0].Send(servers[1].ServerIdentity, bm)
+	require.NoError(t, err)
+	for i := 0; i < 100 && count(1) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, count(1))
+}
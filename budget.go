@@ -0,0 +1,129 @@
+package onet
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// SetGoroutineBudget changes how many goroutines at most give the messages
+// to the protocol instances, which then take turns, so that hundreds of
+// thousands of instances don't need as many goroutines. The messages of an
+// instance are always given in the order they arrived. Zero or less, the
+// default, gives a goroutine to every instance with messages waiting.
+//
+// The handlers of the protocols must not wait for the messages of another
+// instance with a budget, or they might wait for a goroutine forever. The
+// Dispatch and Start methods of the instances still have their own
+// goroutine.
+func (c *Server) SetGoroutineBudget(n int) {
+	c.overlay.budget.setMax(n)
+}
+
+// goroutineBudget runs the tasks of the protocol instances with at most max
+// goroutines, or with one goroutine for each task if max is zero. The
+// goroutines only exist while there are tasks, so there is nothing to stop.
+type goroutineBudget struct {
+	sync.Mutex
+	max     int
+	running int
+	peak    int
+	// waiting are the tasks waiting for a goroutine
+	waiting []func() bool
+	// saturated counts the tasks that had to wait
+	saturated uint64
+	// cpus are the CPUs the goroutines are pinned to, if any
+	cpus []int
+}
+
+func (gb *goroutineBudget) setMax(n int) {
+	if n < 0 {
+		n = 0
+	}
+	gb.Lock()
+	defer gb.Unlock()
+	gb.max = n
+	for len(gb.waiting) > 0 && gb.free() {
+		task := gb.waiting[0]
+		gb.waiting = gb.waiting[1:]
+		gb.start(task)
+	}
+}
+
+// free returns whether another goroutine may start. It must be called with
+// the lock.
+func (gb *goroutineBudget) free() bool {
+	return gb.max == 0 || gb.running < gb.max
+}
+
+func (gb *goroutineBudget) setCPUs(cpus []int) {
+	gb.Lock()
+	defer gb.Unlock()
+	gb.cpus = append([]int(nil), cpus...)
+}
+
+// start starts a goroutine for the task. It must be called with the lock.
+func (gb *goroutineBudget) start(task func() bool) {
+	gb.running++
+	if gb.running > gb.peak {
+		gb.peak = gb.running
+	}
+	go gb.work(task, gb.cpus)
+}
+
+// run runs the task as soon as there is a goroutine for it. The task
+// returns whether it has more to do, and is then run again after the tasks
+// waiting.
+func (gb *goroutineBudget) run(task func() bool) {
+	gb.Lock()
+	defer gb.Unlock()
+	if gb.free() {
+		gb.start(task)
+		return
+	}
+	gb.saturated++
+	gb.waiting = append(gb.waiting, task)
+}
+
+// work runs the task and the ones waiting after it. A goroutine pinned to
+// CPUs exits with its thread locked, so that the thread is thrown away.
+func (gb *goroutineBudget) work(task func() bool, cpus []int) {
+	if len(cpus) > 0 {
+		runtime.LockOSThread()
+		if err := pinThread(cpus); err != nil {
+			log.Error("Pinning to CPUs:", err)
+		}
+	}
+	for {
+		more := task()
+		gb.Lock()
+		if more {
+			gb.waiting = append(gb.waiting, task)
+		}
+		if len(gb.waiting) == 0 || gb.running > gb.max && gb.max > 0 {
+			gb.running--
+			gb.Unlock()
+			return
+		}
+		task = gb.waiting[0]
+		gb.waiting = gb.waiting[1:]
+		gb.Unlock()
+	}
+}
+
+// GetStatus implements the StatusReporter interface.
+func (gb *goroutineBudget) GetStatus() *Status {
+	gb.Lock()
+	defer gb.Unlock()
+	return &Status{Field: map[string]string{
+		"Budget":    strconv.Itoa(gb.max),
+		"Running":   strconv.Itoa(gb.running),
+		"Peak":      strconv.Itoa(gb.peak),
+		"Waiting":   strconv.Itoa(len(gb.waiting)),
+		"Saturated": strconv.FormatUint(gb.saturated, 10),
+		"CPUs":      fmt.Sprint(gb.cpus),
+	}}
+}
@@ -0,0 +1,96 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoroutineBudget(t *testing.T) {
+	var gb goroutineBudget
+	gb.setMax(2)
+
+	release := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(5)
+	started := make(chan bool, 5)
+	for i := 0; i < 5; i++ {
+		gb.run(func() bool {
+			started <- true
+			<-release
+			wg.Done()
+			return false
+		})
+	}
+	<-started
+	<-started
+	st := gb.GetStatus()
+	require.Equal(t, "2", st.Field["Running"])
+	require.Equal(t, "3", st.Field["Waiting"])
+	require.Equal(t, "3", st.Field["Saturated"])
+
+	close(release)
+	wg.Wait()
+	st = gb.GetStatus()
+	require.Equal(t, "2", st.Field["Peak"])
+	require.Equal(t, "0", st.Field["Waiting"])
+
+	// a task with more to do lets the waiting ones run in between
+	gb.setMax(1)
+	var order []int
+	var mut sync.Mutex
+	done := make(chan bool)
+	block := make(chan bool)
+	gb.run(func() bool {
+		<-block
+		return false
+	})
+	add := func(i int) {
+		mut.Lock()
+		defer mut.Unlock()
+		order = append(order, i)
+		if len(order) == 3 {
+			close(done)
+		}
+	}
+	turns := 0
+	gb.run(func() bool {
+		add(1)
+		turns++
+		return turns < 2
+	})
+	gb.run(func() bool {
+		add(2)
+		return false
+	})
+	close(block)
+	<-done
+	mut.Lock()
+	require.Equal(t, []int{1, 2, 1}, order)
+	mut.Unlock()
+}
+
+func TestServer_SetGoroutineBudget(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	servers, _, tree := local.GenTree(2, true)
+	for _, s := range servers {
+		s.SetGoroutineBudget(1)
+	}
+	var pps []*pingPongProto
+	for i := 0; i < 10; i++ {
+		pi, err := local.StartProtocol(pingPongProtoName, tree)
+		require.NoError(t, err)
+		pps = append(pps, pi.(*pingPongProto))
+	}
+	for _, pp := range pps {
+		<-pp.done
+	}
+	for _, s := range servers {
+		st := s.statusReporterStruct.ReportStatus()["Goroutines"]
+		require.Equal(t, "1", st.Field["Budget"])
+		require.Equal(t, "1", st.Field["Peak"])
+	}
+}
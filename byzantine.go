@@ -0,0 +1,107 @@
+package onet
+
+import (
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// Adversary is the behaviour of a byzantine server in the protocols, to
+// evaluate them against attacks without changing their code. It is set with
+// Server.SetAdversary.
+type Adversary struct {
+	// NewProtocol, if not nil, creates the protocol instances of the
+	// server instead of the registered protocol, which honest creates.
+	// It can return its own ProtocolInstance, or wrap the honest one.
+	NewProtocol func(n *TreeNodeInstance, honest NewProtocol) (ProtocolInstance, error)
+	// Mutate, if not nil, returns the message sent to a node instead of
+	// msg, or nil to not send anything.
+	Mutate func(n *TreeNodeInstance, to *TreeNode, msg interface{}) interface{}
+}
+
+// SilentAdversary doesn't send any message.
+var SilentAdversary = &Adversary{
+	Mutate: func(*TreeNodeInstance, *TreeNode, interface{}) interface{} {
+		return nil
+	},
+}
+
+// EquivocatingAdversary sends alter(msg) instead of msg to half of the nodes,
+// the ones with an odd index in the roster, so that the nodes don't agree on
+// what it sent.
+func EquivocatingAdversary(alter func(msg interface{}) interface{}) *Adversary {
+	return &Adversary{
+		Mutate: func(n *TreeNodeInstance, to *TreeNode, msg interface{}) interface{} {
+			if to.RosterIndex%2 == 1 {
+				return alter(msg)
+			}
+			return msg
+		},
+	}
+}
+
+// adversaries holds the adversaries of the protocols of a server.
+type adversaries struct {
+	// from protocol name to adversary, "" for all
+	byName map[string]*Adversary
+	sync.Mutex
+}
+
+// SetAdversary makes the server byzantine in the protocol with the name, or
+// in all protocols if name is "". A nil adversary makes it honest again, and
+// an empty Adversary keeps it honest in that protocol even if it is byzantine
+// in all the others. It applies to the protocol instances created afterwards.
+func (c *Server) SetAdversary(name string, a *Adversary) {
+	c.adversaries.Lock()
+	defer c.adversaries.Unlock()
+	if c.adversaries.byName == nil {
+		c.adversaries.byName = make(map[string]*Adversary)
+	}
+	if a == nil {
+		delete(c.adversaries.byName, name)
+		return
+	}
+	c.adversaries.byName[name] = a
+}
+
+// adversary returns the adversary of the server in the protocol, or nil.
+func (c *Server) adversary(protoID ProtocolID) *Adversary {
+	c.adversaries.Lock()
+	empty := len(c.adversaries.byName) == 0
+	c.adversaries.Unlock()
+	if empty {
+		return nil
+	}
+	name := c.protocols.ProtocolIDToName(protoID)
+	c.adversaries.Lock()
+	defer c.adversaries.Unlock()
+	if a, ok := c.adversaries.byName[name]; ok {
+		return a
+	}
+	return c.adversaries.byName[""]
+}
+
+var registeredAdversaries = struct {
+	byName map[string]*Adversary
+	sync.Mutex
+}{byName: map[string]*Adversary{"silent": SilentAdversary}}
+
+// AdversaryRegister registers an adversary under a name, so that the
+// simulations can use it. The "silent" adversary is always available.
+func AdversaryRegister(name string, a *Adversary) error {
+	registeredAdversaries.Lock()
+	defer registeredAdversaries.Unlock()
+	if _, exists := registeredAdversaries.byName[name]; exists {
+		return xerrors.Errorf("adversary %s already exists", name)
+	}
+	registeredAdversaries.byName[name] = a
+	return nil
+}
+
+// RegisteredAdversary returns the adversary registered under the name, or
+// nil.
+func RegisteredAdversary(name string) *Adversary {
+	registeredAdversaries.Lock()
+	defer registeredAdversaries.Unlock()
+	return registeredAdversaries.byName[name]
+}
@@ -0,0 +1,110 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const byzantineTestName = "ByzantineTest"
+
+type ByzantineTestMsg struct {
+	Val int
+}
+
+type byzantineTestReceived struct {
+	index, val int
+}
+
+var byzantineTestCh = make(chan byzantineTestReceived, 10)
+
+func init() {
+	network.RegisterMessage(ByzantineTestMsg{})
+	GlobalProtocolRegister(byzantineTestName, newByzantineTest)
+}
+
+// byzantineTest sends a value down the tree.
+type byzantineTest struct {
+	*TreeNodeInstance
+}
+
+func newByzantineTest(n *TreeNodeInstance) (ProtocolInstance, error) {
+	p := &byzantineTest{n}
+	return p, p.RegisterHandler(p.handle)
+}
+
+func (p *byzantineTest) Start() error {
+	return p.SendToChildren(&ByzantineTestMsg{1})
+}
+
+func (p *byzantineTest) handle(msg struct {
+	*TreeNode
+	ByzantineTestMsg
+}) error {
+	byzantineTestCh <- byzantineTestReceived{p.Index(), msg.Val}
+	defer p.Done()
+	return p.SendToChildren(&msg.ByzantineTestMsg)
+}
+
+// byzantineTestRun runs the protocol and returns the values received by the
+// servers.
+func byzantineTestRun(t *testing.T, local *LocalTest, tree *Tree, n int) map[int]int {
+	pi, err := local.StartProtocol(byzantineTestName, tree)
+	require.NoError(t, err)
+	defer pi.(*byzantineTest).Done()
+	received := make(map[int]int)
+	for len(received) < n {
+		select {
+		case r := <-byzantineTestCh:
+			received[r.index] = r.val
+		case <-time.After(time.Second):
+			return received
+		}
+	}
+	return received
+}
+
+func TestServer_SetAdversary(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(4, true)
+
+	// the root sends 2 to the servers with an odd index
+	servers[0].SetAdversary(byzantineTestName, EquivocatingAdversary(func(msg interface{}) interface{} {
+		return &ByzantineTestMsg{2}
+	}))
+	require.Equal(t, map[int]int{1: 2, 2: 1, 3: 2}, byzantineTestRun(t, local, tree, 3))
+
+	// the first child is silent
+	servers[0].SetAdversary(byzantineTestName, nil)
+	servers[1].SetAdversary("", SilentAdversary)
+	require.Equal(t, map[int]int{1: 1, 2: 1}, byzantineTestRun(t, local, tree, 3))
+
+	// the second child runs its own protocol, which doesn't forward
+	servers[1].SetAdversary("", nil)
+	servers[2].SetAdversary(byzantineTestName, &Adversary{
+		NewProtocol: func(n *TreeNodeInstance, honest NewProtocol) (ProtocolInstance, error) {
+			p := &byzantineTest{n}
+			return p, p.RegisterHandler(func(msg struct {
+				*TreeNode
+				ByzantineTestMsg
+			}) error {
+				byzantineTestCh <- byzantineTestReceived{p.Index(), -1}
+				p.Done()
+				return nil
+			})
+		},
+	})
+	require.Equal(t, map[int]int{1: 1, 2: -1, 3: 1}, byzantineTestRun(t, local, tree, 3))
+}
+
+func TestAdversaryRegister(t *testing.T) {
+	require.Equal(t, SilentAdversary, RegisteredAdversary("silent"))
+	require.Error(t, AdversaryRegister("silent", &Adversary{}))
+	a := &Adversary{}
+	require.NoError(t, AdversaryRegister("byzantineTest", a))
+	require.Equal(t, a, RegisteredAdversary("byzantineTest"))
+	require.Nil(t, RegisteredAdversary("unknown"))
+}
@@ -0,0 +1,35 @@
+package onet
+
+import (
+	"go.dedis.ch/onet/v4/network"
+)
+
+// Advertise tells the peers of the server that the service supports the
+// capability in the version, like "compression" or the newest version of one
+// of its messages, so that they can adapt to it. A new version replaces the
+// former one, and an empty version withdraws the capability. The capabilities
+// are announced after the handshake of every connection, and to the peers
+// already connected when they change. They are named after the service, so
+// that the ones of the services don't clash.
+func (c *Context) Advertise(capability, version string) {
+	c.server.SetCapability(c.capabilityName(capability), version)
+}
+
+// PeerCapability returns the version of the capability the service of the
+// peer advertised, and false if it didn't, because it doesn't support it or
+// runs an older version of onet. The capabilities of a peer are known once a
+// connection was opened with it, and the ones of a peer the server just
+// connected to come with its first answer.
+func (c *Context) PeerCapability(si *network.ServerIdentity, capability string) (string, bool) {
+	return c.server.PeerCapability(si.ID, c.capabilityName(capability))
+}
+
+// PeerCapabilities returns all the capabilities the peer advertised, named
+// after their services, like "Service/capability".
+func (c *Context) PeerCapabilities(si *network.ServerIdentity) []network.Capability {
+	return c.server.PeerCapabilities(si.ID)
+}
+
+func (c *Context) capabilityName(capability string) string {
+	return ServiceFactory.Name(c.serviceID) + "/" + capability
+}
@@ -0,0 +1,46 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const capabilityServiceName = "capabilityService"
+
+var capabilityServiceID ServiceID
+
+func init() {
+	capabilityServiceID, _ = RegisterNewService(capabilityServiceName, func(c *Context) (Service, error) {
+		return NewServiceProcessor(c), nil
+	})
+}
+
+func TestContext_Advertise(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	services := local.GetServices(servers, capabilityServiceID)
+	s0, s1 := services[0].(*ServiceProcessor), services[1].(*ServiceProcessor)
+
+	s0.Advertise("zip", "1")
+	_, ok := s1.PeerCapability(servers[0].ServerIdentity, "zip")
+	require.False(t, ok)
+	require.NoError(t, s0.SendRaw(servers[1].ServerIdentity, &subsetTestMsg{1}))
+	for i := 0; i < 100 && !ok; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, ok = s1.PeerCapability(servers[0].ServerIdentity, "zip")
+	}
+	v, ok := s1.PeerCapability(servers[0].ServerIdentity, "zip")
+	require.True(t, ok)
+	require.Equal(t, "1", v)
+	require.Equal(t, []network.Capability{{Name: capabilityServiceName + "/zip", Version: "1"}},
+		s1.PeerCapabilities(servers[0].ServerIdentity))
+
+	st, err := servers[1].DetailedStatus()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(st.Connections))
+	require.Equal(t, 1, len(st.Connections[0].Capabilities))
+}
@@ -0,0 +1,197 @@
+package onet
+
+import (
+	"reflect"
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// CausalMode tells what a TreeNodeInstance does with the vector clocks of the
+// messages of its protocol.
+type CausalMode int
+
+const (
+	// CausalNone doesn't attach clocks to the messages. It is the default.
+	CausalNone CausalMode = iota
+	// CausalClocks attaches the clocks to the messages and gives them to the
+	// handlers and channels asking for them, but delivers the messages as
+	// they arrive.
+	CausalClocks
+	// CausalDelivery also holds the messages back until all the broadcasts
+	// they depend on were delivered.
+	CausalDelivery
+)
+
+// VectorClock is the causal past of a message of a protocol instance: the
+// entry i is the number of Broadcasts of the node at index i of the roster
+// that happened before. The other ways to send carry the clock without
+// counting, so that a causal order holds for the broadcasts, and the other
+// messages are only delivered after the broadcasts seen by their sender.
+//
+// A handler or a channel gets the clock of a message with a third field of
+// type VectorClock, after the *TreeNode and the message.
+type VectorClock []uint64
+
+var vectorClockType = reflect.TypeOf(VectorClock{})
+
+// handlerFields returns whether the structure given to handlers and channels
+// has the *TreeNode and the message, and optionally the VectorClock.
+func handlerFields(t reflect.Type) bool {
+	switch t.NumField() {
+	case 2:
+		return true
+	case 3:
+		return t.Field(2).Type == vectorClockType
+	}
+	return false
+}
+
+// Copy returns a copy of the clock.
+func (vc VectorClock) Copy() VectorClock {
+	if vc == nil {
+		return nil
+	}
+	return append(VectorClock{}, vc...)
+}
+
+// get returns the entry i, zero if the clock is shorter.
+func (vc VectorClock) get(i int) uint64 {
+	if i < len(vc) {
+		return vc[i]
+	}
+	return 0
+}
+
+// LessOrEqual returns whether every entry of vc is at most the one of o, so
+// that the events of vc are all in the past of o.
+func (vc VectorClock) LessOrEqual(o VectorClock) bool {
+	for i, v := range vc {
+		if v > o.get(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Concurrent returns whether none of the clocks is in the past of the other.
+func (vc VectorClock) Concurrent(o VectorClock) bool {
+	return !vc.LessOrEqual(o) && !o.LessOrEqual(vc)
+}
+
+// merge sets every entry of vc to the maximum of the two clocks.
+func (vc *VectorClock) merge(o VectorClock) {
+	for len(*vc) < len(o) {
+		*vc = append(*vc, 0)
+	}
+	for i, v := range o {
+		if v > (*vc)[i] {
+			(*vc)[i] = v
+		}
+	}
+}
+
+// causalState are the clock of a TreeNodeInstance and the messages it holds
+// back.
+type causalState struct {
+	sync.Mutex
+	mode  CausalMode
+	clock VectorClock
+	held  []*ProtocolMsg
+}
+
+// SetCausalMode sets what this node does with the vector clocks. All the
+// nodes of the protocol should set the same mode in its constructor, before
+// sending or receiving a message.
+func (n *TreeNodeInstance) SetCausalMode(mode CausalMode) {
+	n.causal.Lock()
+	defer n.causal.Unlock()
+	n.causal.mode = mode
+	if mode != CausalNone && n.causal.clock == nil {
+		n.causal.clock = make(VectorClock, len(n.Roster().List))
+	}
+}
+
+// VectorClock returns the clock of this node: its broadcasts and the ones of
+// the messages delivered so far. It is nil with CausalNone.
+func (n *TreeNodeInstance) VectorClock() VectorClock {
+	n.causal.Lock()
+	defer n.causal.Unlock()
+	return n.causal.clock.Copy()
+}
+
+// stamp returns the clock to attach to a message, after counting it if it is
+// a broadcast.
+func (n *TreeNodeInstance) stamp(broadcast bool) VectorClock {
+	n.causal.Lock()
+	defer n.causal.Unlock()
+	if n.causal.mode == CausalNone {
+		return nil
+	}
+	if broadcast {
+		n.causal.clock[n.treeNode.RosterIndex]++
+	}
+	return n.causal.clock.Copy()
+}
+
+// deliverable returns the messages to dispatch now that msg arrived: none if
+// it has to wait for the broadcasts it depends on, or it and the held
+// messages that only waited for it.
+func (n *TreeNodeInstance) deliverable(msg *ProtocolMsg) []*ProtocolMsg {
+	n.causal.Lock()
+	defer n.causal.Unlock()
+	if n.causal.mode == CausalNone || msg.Clock == nil {
+		return []*ProtocolMsg{msg}
+	}
+	if n.causal.mode == CausalClocks {
+		n.causal.clock.merge(msg.Clock)
+		return []*ProtocolMsg{msg}
+	}
+
+	if from := n.causalSender(msg); msg.ClockTick && msg.Clock.get(from) <= n.causal.clock.get(from) {
+		log.Lvl3(n.Info(), "dropping a broadcast already delivered")
+		return nil
+	}
+	n.causal.held = append(n.causal.held, msg)
+	var out []*ProtocolMsg
+	for progress := true; progress; {
+		progress = false
+		for i, m := range n.causal.held {
+			if n.causalReady(m) {
+				n.causal.clock.merge(m.Clock)
+				out = append(out, m)
+				n.causal.held = append(n.causal.held[:i:i], n.causal.held[i+1:]...)
+				progress = true
+				break
+			}
+		}
+	}
+	return out
+}
+
+// causalReady returns whether all the broadcasts in the past of the message
+// were delivered, and if it is a broadcast, whether it is the next one of its
+// sender. The state must be locked.
+func (n *TreeNodeInstance) causalReady(msg *ProtocolMsg) bool {
+	from := n.causalSender(msg)
+	for i, v := range msg.Clock {
+		have := n.causal.clock.get(i)
+		if i == from && msg.ClockTick {
+			if v != have+1 {
+				return false
+			}
+		} else if v > have {
+			return false
+		}
+	}
+	return true
+}
+
+// causalSender returns the index in the roster of the sender of the message,
+// -1 if it isn't in the tree.
+func (n *TreeNodeInstance) causalSender(msg *ProtocolMsg) int {
+	if tn := n.Tree().Search(msg.From.TreeNodeID); tn != nil {
+		return tn.RosterIndex
+	}
+	return -1
+}
@@ -0,0 +1,125 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const causalTestName = "CausalTest"
+
+type CausalTestMsg struct {
+	Val int
+}
+
+type causalTestReceived struct {
+	index, val int
+	clock      VectorClock
+}
+
+var causalTestCh = make(chan causalTestReceived, 10)
+
+func init() {
+	network.RegisterMessage(CausalTestMsg{})
+	GlobalProtocolRegister(causalTestName, newCausalTest)
+}
+
+// causalTest broadcasts a value from the root, and the second node
+// broadcasts another one once it got it.
+type causalTest struct {
+	*TreeNodeInstance
+}
+
+func newCausalTest(n *TreeNodeInstance) (ProtocolInstance, error) {
+	n.SetCausalMode(CausalDelivery)
+	p := &causalTest{n}
+	return p, p.RegisterHandler(p.handle)
+}
+
+func (p *causalTest) Start() error {
+	p.Broadcast(&CausalTestMsg{0})
+	return nil
+}
+
+func (p *causalTest) handle(msg struct {
+	*TreeNode
+	CausalTestMsg
+	VectorClock
+}) error {
+	causalTestCh <- causalTestReceived{p.Index(), msg.Val, msg.VectorClock}
+	if p.Index() == 1 {
+		defer p.Done()
+		p.Broadcast(&CausalTestMsg{1})
+	} else if msg.Val == 1 {
+		p.Done()
+	}
+	return nil
+}
+
+func TestVectorClock(t *testing.T) {
+	a := VectorClock{1, 0, 2}
+	b := VectorClock{1, 1, 2}
+	require.True(t, a.LessOrEqual(b))
+	require.False(t, b.LessOrEqual(a))
+	require.False(t, a.Concurrent(b))
+	require.True(t, VectorClock{2}.Concurrent(VectorClock{0, 1}))
+	require.True(t, VectorClock{}.LessOrEqual(a))
+
+	c := a.Copy()
+	c.merge(VectorClock{0, 3, 1, 4})
+	require.Equal(t, VectorClock{1, 3, 2, 4}, c)
+	require.Equal(t, VectorClock{1, 0, 2}, a)
+}
+
+func TestTreeNodeInstance_CausalDelivery(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenTree(3, true)
+	list := tree.List()
+	n, err := local.NewTreeNodeInstance(list[2], causalTestName)
+	require.NoError(t, err)
+	n.overlay.RegisterTree(tree)
+	n.SetCausalMode(CausalDelivery)
+	msg := func(from *TreeNode, clock VectorClock, tick bool) *ProtocolMsg {
+		return &ProtocolMsg{From: &Token{TreeNodeID: from.ID}, Clock: clock, ClockTick: tick}
+	}
+
+	// the broadcast of node 1 and a message of node 1 both wait for the
+	// broadcast of the root
+	second := msg(list[1], VectorClock{1, 1, 0}, true)
+	reply := msg(list[1], VectorClock{1, 1, 0}, false)
+	first := msg(list[0], VectorClock{1, 0, 0}, true)
+	require.Empty(t, n.deliverable(second))
+	require.Empty(t, n.deliverable(reply))
+	require.Equal(t, []*ProtocolMsg{first, second, reply}, n.deliverable(first))
+	require.Equal(t, VectorClock{1, 1, 0}, n.VectorClock())
+
+	// a message without clock isn't held
+	plain := msg(list[1], nil, false)
+	require.Equal(t, []*ProtocolMsg{plain}, n.deliverable(plain))
+	// a broadcast already delivered is dropped
+	require.Empty(t, n.deliverable(msg(list[0], VectorClock{1, 0, 0}, true)))
+}
+
+func TestTreeNodeInstance_CausalClocks(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenTree(3, true)
+	_, err := local.StartProtocol(causalTestName, tree)
+	require.NoError(t, err)
+
+	clocks := make(map[int][]VectorClock)
+	for i := 0; i < 4; i++ {
+		select {
+		case r := <-causalTestCh:
+			clocks[r.index] = append(clocks[r.index], r.clock)
+		case <-time.After(time.Second):
+			require.Fail(t, "missing message")
+		}
+	}
+	require.Equal(t, []VectorClock{{1, 0, 0}}, clocks[1])
+	require.Equal(t, []VectorClock{{1, 0, 0}, {1, 1, 0}}, clocks[2])
+	require.Equal(t, []VectorClock{{1, 1, 0}}, clocks[0])
+}
@@ -0,0 +1,116 @@
+package onet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// simulationCheckpoint asks a server to save its services after the round.
+type simulationCheckpoint struct {
+	Round int
+}
+
+// simulationCheckpointDone is the reply to simulationCheckpoint, with the
+// error if the services couldn't be saved.
+type simulationCheckpointDone struct {
+	Round int
+	Error string
+}
+
+func (d *simulationCheckpointDone) reply() (int, error) {
+	if d.Error != "" {
+		return d.Round, xerrors.New("checkpoint failed: " + d.Error)
+	}
+	return d.Round, nil
+}
+
+var simulationCheckpointID = network.RegisterMessage(simulationCheckpoint{})
+var simulationCheckpointDoneID = network.RegisterMessage(simulationCheckpointDone{})
+
+// how long Checkpoint waits for the servers
+const checkpointTimeout = time.Minute
+
+// Checkpoint saves the storage of the services of all the servers once the
+// round is done, so that a simulation resuming after this round starts with
+// them. It is called by Run, usually together with monitor.Checkpoint, which
+// saves the measures. When simul resumes a simulation, it starts again at
+// SimulationBFTree.ResumeRound.
+func (sc *SimulationConfig) Checkpoint(round int) error {
+	if sc.checkpointDone == nil {
+		return xerrors.New("the simulation has no server")
+	}
+	err := sc.sendRound(round, &simulationCheckpoint{round}, sc.checkpointDone, checkpointTimeout)
+	if err != nil {
+		return xerrors.Errorf("checkpoint: %v", err)
+	}
+	return nil
+}
+
+// checkpointFile returns the file of the saved services of the server with
+// the index in the roster.
+func checkpointFile(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("checkpoint-%d.snapshot", index))
+}
+
+// checkpointStorage returns the storage of the server with the index in the
+// roster, which restores its services if the simulation resumes.
+func checkpointStorage(dir string, index int, config string) (StorageConfig, error) {
+	var resume struct{ ResumeRound int }
+	if _, err := toml.Decode(config, &resume); err != nil {
+		return StorageConfig{}, xerrors.Errorf("decoding config: %v", err)
+	}
+	file := checkpointFile(dir, index)
+	if resume.ResumeRound == 0 {
+		// don't keep the services of a previous simulation
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return StorageConfig{}, xerrors.Errorf("removing checkpoint: %v", err)
+		}
+		return StorageConfig{}, nil
+	}
+	if _, err := os.Stat(file); err != nil {
+		log.Lvl2("No services to restore for server", index)
+		return StorageConfig{}, nil
+	}
+	return StorageConfig{Restore: file}, nil
+}
+
+// registerCheckpoint makes the server of the configuration save its services
+// to the file when the root asks for it.
+func (sc *SimulationConfig) registerCheckpoint(file string) {
+	sc.checkpointDone = make(chan roundReply, len(sc.Roster.List))
+	server := sc.Server
+	sc.registerRound(simulationCheckpointID, simulationCheckpointDoneID, func(msg network.Message) network.Message {
+		reply := &simulationCheckpointDone{Round: msg.(*simulationCheckpoint).Round}
+		if err := saveCheckpoint(server, file); err != nil {
+			reply.Error = fmt.Sprintf("%s: %v", server.ServerIdentity, err)
+		}
+		return reply
+	}, sc.checkpointDone)
+}
+
+// saveCheckpoint writes a backup of the server to the file, which is
+// replaced only once the backup is complete.
+func saveCheckpoint(server *Server, file string) error {
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		return xerrors.Errorf("creating file: %v", err)
+	}
+	if err := server.Backup(f); err != nil {
+		f.Close()
+		return xerrors.Errorf("backup: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("closing file: %v", err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return xerrors.Errorf("renaming file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package onet
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulationConfig_Checkpoint(t *testing.T) {
+	require.Error(t, (&SimulationConfig{}).Checkpoint(0))
+
+	sc, _, err := createBFTree(3, 2, false, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, sc.Save(dir))
+	scs, err := LoadSimulationConfig("Ed25519", dir, "127.0.0.1")
+	require.NoError(t, err)
+	defer closeAll(scs)
+	for _, s := range scs {
+		s.Server.StartInBackground()
+	}
+
+	require.NoError(t, scs[0].Checkpoint(0))
+	for i := range scs {
+		_, err := os.Stat(checkpointFile(dir, i))
+		require.NoError(t, err)
+	}
+
+	// the services are restored only when resuming
+	storage, err := checkpointStorage(dir, 1, "ResumeRound = 1")
+	require.NoError(t, err)
+	require.Equal(t, checkpointFile(dir, 1), storage.Restore)
+	storage, err = checkpointStorage(dir, 5, "ResumeRound = 1")
+	require.NoError(t, err)
+	require.Equal(t, "", storage.Restore)
+	storage, err = checkpointStorage(dir, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, "", storage.Restore)
+	_, err = os.Stat(checkpointFile(dir, 1))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,194 @@
+package onet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"golang.org/x/xerrors"
+)
+
+// the header with the public key of a client that wants to authenticate
+// with a signed challenge
+const clientPublicHeader = "X-Onet-Public"
+
+// how long a client has to answer the challenge
+const clientChallengeTimeout = 10 * time.Second
+
+const clientChallengeSize = 32
+
+// ClientIdentity is a client that authenticated on the websocket, either
+// with a bearer token or by signing a challenge with its key pair.
+type ClientIdentity struct {
+	// Name given to the token with Server.AddClientToken, empty for keys.
+	Name string
+	// Public key of the client, nil for tokens.
+	Public kyber.Point
+}
+
+type clientIdentityKey struct{}
+
+// ClientIdentityFromRequest returns the client that authenticated for the
+// request given to Service.ProcessClientRequest, or nil.
+func ClientIdentityFromRequest(r *http.Request) *ClientIdentity {
+	if r == nil {
+		return nil
+	}
+	id, _ := r.Context().Value(clientIdentityKey{}).(*ClientIdentity)
+	return id
+}
+
+// clientAuth holds the tokens accepted by a server.
+type clientAuth struct {
+	// from token to name
+	tokens map[string]string
+	sync.Mutex
+}
+
+// AddClientToken lets the clients presenting the bearer token authenticate
+// under the given name.
+func (c *Server) AddClientToken(name, token string) error {
+	if token == "" {
+		return xerrors.New("empty token")
+	}
+	c.clientAuth.Lock()
+	defer c.clientAuth.Unlock()
+	if c.clientAuth.tokens == nil {
+		c.clientAuth.tokens = make(map[string]string)
+	}
+	c.clientAuth.tokens[token] = name
+	return nil
+}
+
+// RemoveClientToken revokes the token.
+func (c *Server) RemoveClientToken(token string) {
+	c.clientAuth.Lock()
+	defer c.clientAuth.Unlock()
+	delete(c.clientAuth.tokens, token)
+}
+
+func (c *Server) verifyToken(token string) (string, bool) {
+	c.clientAuth.Lock()
+	defer c.clientAuth.Unlock()
+	for t, name := range c.clientAuth.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// challengeMessage is what the client signs: the challenge and the path of
+// the connection, so a signature can't be used on another service.
+func challengeMessage(challenge []byte, path string) []byte {
+	return append(append([]byte("onet client auth:"), challenge...), []byte(path)...)
+}
+
+// authenticateClient checks the credentials a client sent when opening the
+// websocket. It returns nil if the client didn't send any.
+func (c *Server) authenticateClient(r *http.Request, ws *websocket.Conn) (*ClientIdentity, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, xerrors.New("only bearer tokens are supported")
+		}
+		name, ok := c.verifyToken(strings.TrimPrefix(auth, "Bearer "))
+		if !ok {
+			return nil, xerrors.New("invalid token")
+		}
+		return &ClientIdentity{Name: name}, nil
+	}
+
+	pubHex := r.Header.Get(clientPublicHeader)
+	if pubHex == "" {
+		return nil, nil
+	}
+	buf, err := hex.DecodeString(pubHex)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding public key: %v", err)
+	}
+	public := c.suite.Point()
+	if err := public.UnmarshalBinary(buf); err != nil {
+		return nil, xerrors.Errorf("decoding public key: %v", err)
+	}
+	challenge := make([]byte, clientChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, xerrors.Errorf("challenge: %v", err)
+	}
+	ws.SetWriteDeadline(time.Now().Add(clientChallengeTimeout))
+	if err := ws.WriteMessage(websocket.BinaryMessage, challenge); err != nil {
+		return nil, xerrors.Errorf("sending challenge: %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(clientChallengeTimeout))
+	_, sig, err := ws.ReadMessage()
+	if err != nil {
+		return nil, xerrors.Errorf("reading signature: %v", err)
+	}
+	ws.SetReadDeadline(time.Time{})
+	if err := schnorr.Verify(c.suite, public, challengeMessage(challenge, r.URL.Path), sig); err != nil {
+		return nil, xerrors.Errorf("invalid signature: %v", err)
+	}
+	return &ClientIdentity{Public: public}, nil
+}
+
+// withClientIdentity returns the request carrying the identity.
+func withClientIdentity(r *http.Request, id *ClientIdentity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+}
+
+// answerChallenge is run by the client right after the connection opened,
+// if it has a key pair.
+func (c *Client) answerChallenge(conn *websocket.Conn, path string) error {
+	conn.SetReadDeadline(time.Now().Add(clientChallengeTimeout))
+	_, challenge, err := conn.ReadMessage()
+	if err != nil {
+		return xerrors.Errorf("reading challenge: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	sig, err := schnorr.Sign(c.suite, c.private, challengeMessage(challenge, path))
+	if err != nil {
+		return xerrors.Errorf("signing: %v", err)
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, sig)
+}
+
+// authHeader adds the credentials of the client to the header used to open
+// a websocket.
+func (c *Client) authHeader(header http.Header) error {
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+		return nil
+	}
+	if c.private != nil {
+		buf, err := c.suite.Point().Mul(c.private, nil).MarshalBinary()
+		if err != nil {
+			return xerrors.Errorf("marshaling public key: %v", err)
+		}
+		header.Set(clientPublicHeader, hex.EncodeToString(buf))
+	}
+	return nil
+}
+
+// SetToken makes the client authenticate with the bearer token on the
+// connections it opens from now on.
+func (c *Client) SetToken(token string) {
+	c.Lock()
+	defer c.Unlock()
+	c.token = token
+}
+
+// SetPrivate makes the client authenticate by signing a challenge with the
+// private key on the connections it opens from now on. The private key must
+// be of the suite of the client.
+func (c *Client) SetPrivate(private kyber.Scalar) {
+	c.Lock()
+	defer c.Unlock()
+	c.private = private
+}
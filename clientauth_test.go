@@ -0,0 +1,72 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+const clientAuthServiceName = "clientAuthService"
+
+type clientAuthService struct {
+	*ServiceProcessor
+	client *ClientIdentity
+	sync.Mutex
+}
+
+func (s *clientAuthService) Secret(client *ClientIdentity, msg *authzMsg) (*authzMsg, error) {
+	s.Lock()
+	s.client = client
+	s.Unlock()
+	return &authzMsg{Val: msg.Val + 1}, nil
+}
+
+func TestClientAuth(t *testing.T) {
+	var srv *clientAuthService
+	RegisterNewService(clientAuthServiceName, func(c *Context) (Service, error) {
+		srv = &clientAuthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterAuthenticatedHandler(srv.Secret)
+	})
+	defer UnregisterService(clientAuthServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	si := servers[0].ServerIdentity
+	require.NoError(t, servers[0].AddClientToken("alice", "secret"))
+
+	reply := &authzMsg{}
+	cl := local.NewClient(clientAuthServiceName)
+	err := cl.SendProtobuf(si, &authzMsg{Val: 1}, reply)
+	require.Error(t, err)
+
+	cl = local.NewClient(clientAuthServiceName)
+	cl.SetToken("wrong")
+	err = cl.SendProtobuf(si, &authzMsg{Val: 1}, reply)
+	require.Error(t, err)
+
+	cl = local.NewClient(clientAuthServiceName)
+	cl.SetToken("secret")
+	require.NoError(t, cl.SendProtobuf(si, &authzMsg{Val: 1}, reply))
+	require.Equal(t, 2, reply.Val)
+	srv.Lock()
+	require.Equal(t, "alice", srv.client.Name)
+	srv.Unlock()
+
+	servers[0].RemoveClientToken("secret")
+	err = cl.SendProtobuf(si, &authzMsg{Val: 1}, reply)
+	require.Error(t, err)
+
+	kp := key.NewKeyPair(tSuite)
+	cl = local.NewClient(clientAuthServiceName)
+	cl.SetPrivate(kp.Private)
+	require.NoError(t, cl.SendProtobuf(si, &authzMsg{Val: 2}, reply))
+	require.Equal(t, 3, reply.Val)
+	srv.Lock()
+	require.True(t, srv.client.Public.Equal(kp.Public))
+	srv.Unlock()
+
+	require.Error(t, servers[0].AddClientToken("bob", ""))
+}
@@ -0,0 +1,329 @@
+package onet
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+)
+
+// CacheInvalidationPath is the path on the websocket of a service where the
+// clients caching its replies learn which ones are stale.
+const CacheInvalidationPath = "cache-invalidations"
+
+// above this number of replies, the client drops the oldest ones
+const clientCacheMaxEntries = 1024
+
+// how many invalidations are queued for a client before it is disconnected,
+// which makes it drop all its replies
+const cacheInvalidationQueue = 16
+
+// CacheInvalidation is sent to the clients caching the replies of the
+// handlers of a service when they are stale. If Handlers is empty, all the
+// replies of the service are stale.
+type CacheInvalidation struct {
+	Handlers []string
+}
+
+type cacheKey struct {
+	dst  network.ServerIdentityID
+	path string
+	hash [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	reply   []byte
+	expires time.Time
+}
+
+// clientCache holds the replies a Client got for the handlers given to
+// CacheReplies.
+type clientCache struct {
+	ttl     map[string]time.Duration
+	entries map[cacheKey]cacheEntry
+	// the connections to the CacheInvalidationPath of the nodes, nil while
+	// dialing or if the node doesn't send invalidations
+	watchers map[network.ServerIdentityID]*websocket.Conn
+	sync.Mutex
+}
+
+// CacheReplies makes the client keep the replies of the handler of the
+// service for ttl, so that the same request to the same node isn't sent
+// again. The node tells the client when the replies are stale, see
+// Context.InvalidateClientCaches. It is meant for the handlers without side
+// effects, like the status or the configuration of a node. A ttl of zero
+// stops the caching of the handler.
+func (c *Client) CacheReplies(handler string, ttl time.Duration) {
+	c.Lock()
+	if c.cache == nil {
+		c.cache = &clientCache{
+			ttl:      make(map[string]time.Duration),
+			entries:  make(map[cacheKey]cacheEntry),
+			watchers: make(map[network.ServerIdentityID]*websocket.Conn),
+		}
+	}
+	cc := c.cache
+	c.Unlock()
+
+	cc.Lock()
+	defer cc.Unlock()
+	if ttl <= 0 {
+		delete(cc.ttl, handler)
+		cc.dropLocked(nil, []string{handler})
+		return
+	}
+	cc.ttl[handler] = ttl
+}
+
+// InvalidateCache drops the cached replies of the handlers, or all of them
+// if none is given.
+func (c *Client) InvalidateCache(handlers ...string) {
+	c.Lock()
+	cc := c.cache
+	c.Unlock()
+	if cc != nil {
+		cc.Lock()
+		cc.dropLocked(nil, handlers)
+		cc.Unlock()
+	}
+}
+
+// lookup returns the cached reply to the request.
+func (cc *clientCache) lookup(dst *network.ServerIdentity, path string, buf []byte) ([]byte, bool) {
+	if cc == nil {
+		return nil, false
+	}
+	cc.Lock()
+	defer cc.Unlock()
+	key := cacheKey{dst.ID, path, sha256.Sum256(buf)}
+	e, ok := cc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(cc.entries, key)
+		return nil, false
+	}
+	return e.reply, true
+}
+
+// store keeps the reply if the handler is cached, and makes sure the client
+// listens for the invalidations of the node.
+func (cc *clientCache) store(c *Client, dst *network.ServerIdentity, path string, buf, reply []byte) {
+	if cc == nil {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+	ttl, ok := cc.ttl[path]
+	if !ok {
+		return
+	}
+	if len(cc.entries) >= clientCacheMaxEntries {
+		cc.pruneLocked()
+	}
+	cc.entries[cacheKey{dst.ID, path, sha256.Sum256(buf)}] = cacheEntry{
+		reply:   reply,
+		expires: time.Now().Add(ttl),
+	}
+	if _, ok := cc.watchers[dst.ID]; !ok {
+		cc.watchers[dst.ID] = nil
+		go cc.watch(c, dst)
+	}
+}
+
+// pruneLocked drops the expired replies, and the ones expiring first if
+// there are still too many.
+func (cc *clientCache) pruneLocked() {
+	now := time.Now()
+	var oldest cacheKey
+	var oldestExpires time.Time
+	for key, e := range cc.entries {
+		if now.After(e.expires) {
+			delete(cc.entries, key)
+		} else if oldestExpires.IsZero() || e.expires.Before(oldestExpires) {
+			oldest, oldestExpires = key, e.expires
+		}
+	}
+	if len(cc.entries) >= clientCacheMaxEntries {
+		delete(cc.entries, oldest)
+	}
+}
+
+// dropLocked drops the replies of the node, or of all nodes if dst is nil,
+// for the handlers, or all of them if handlers is empty.
+func (cc *clientCache) dropLocked(dst *network.ServerIdentityID, handlers []string) {
+	for key := range cc.entries {
+		if dst != nil && !key.dst.Equal(*dst) {
+			continue
+		}
+		drop := len(handlers) == 0
+		for _, h := range handlers {
+			if key.path == h {
+				drop = true
+				break
+			}
+		}
+		if drop {
+			delete(cc.entries, key)
+		}
+	}
+}
+
+// watch reads the invalidations sent by the node. Once the connection is
+// lost, the replies of the node are dropped as some invalidations may be
+// missed.
+func (cc *clientCache) watch(c *Client, dst *network.ServerIdentity) {
+	conn, err := c.dial(dst, CacheInvalidationPath)
+	if err != nil {
+		// the replies of the node are only kept for their ttl
+		log.Lvl2("No cache invalidations from", dst, ":", err)
+		return
+	}
+	cc.Lock()
+	if w, ok := cc.watchers[dst.ID]; !ok || w != nil {
+		// the client was closed while dialing
+		cc.Unlock()
+		conn.Close()
+		return
+	}
+	cc.watchers[dst.ID] = conn
+	cc.Unlock()
+
+	for {
+		_, buf, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		inv := &CacheInvalidation{}
+		if err := protobuf.Decode(buf, inv); err != nil {
+			log.Error("decoding cache invalidation:", err)
+			break
+		}
+		log.Lvl3("Invalidating", inv.Handlers, "of", dst)
+		cc.Lock()
+		cc.dropLocked(&dst.ID, inv.Handlers)
+		cc.Unlock()
+	}
+	conn.Close()
+	cc.Lock()
+	if cc.watchers[dst.ID] == conn {
+		delete(cc.watchers, dst.ID)
+	}
+	cc.dropLocked(&dst.ID, nil)
+	cc.Unlock()
+}
+
+// close stops listening for invalidations.
+func (cc *clientCache) close() {
+	if cc == nil {
+		return
+	}
+	cc.Lock()
+	defer cc.Unlock()
+	for id, conn := range cc.watchers {
+		if conn != nil {
+			conn.Close()
+		}
+		delete(cc.watchers, id)
+	}
+}
+
+// cacheInvalidator sends the invalidations of the services to the clients
+// caching their replies.
+type cacheInvalidator struct {
+	watchers map[string]map[chan []string]bool
+	sync.Mutex
+}
+
+func newCacheInvalidator() *cacheInvalidator {
+	return &cacheInvalidator{watchers: make(map[string]map[chan []string]bool)}
+}
+
+// InvalidateClientCaches tells the clients caching the replies of the
+// handlers of the service that they are stale. If no handler is given, all
+// the replies of the service are stale.
+func (c *Context) InvalidateClientCaches(handlers ...string) {
+	ci := c.server.cacheInvalidator
+	ci.Lock()
+	defer ci.Unlock()
+	for w := range ci.watchers[ServiceFactory.Name(c.serviceID)] {
+		select {
+		case w <- handlers:
+		default:
+			// the client is too slow, it will drop everything
+			delete(ci.watchers[ServiceFactory.Name(c.serviceID)], w)
+			close(w)
+		}
+	}
+}
+
+// serve sends the invalidations of the service on the connection until the
+// client or the server closes it.
+func (ci *cacheInvalidator) serve(service string, ws *websocket.Conn) {
+	w := make(chan []string, cacheInvalidationQueue)
+	ci.Lock()
+	if ci.watchers[service] == nil {
+		ci.watchers[service] = make(map[chan []string]bool)
+	}
+	ci.watchers[service][w] = true
+	ci.Unlock()
+	defer func() {
+		ci.Lock()
+		if ci.watchers[service][w] {
+			delete(ci.watchers[service], w)
+			close(w)
+		}
+		ci.Unlock()
+	}()
+
+	closing := make(chan struct{})
+	go func() {
+		// the client only closes the connection
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				close(closing)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closing:
+			return
+		case handlers, ok := <-w:
+			if !ok {
+				ws.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "stopped"),
+					time.Now().Add(time.Millisecond*500))
+				return
+			}
+			buf, err := protobuf.Encode(&CacheInvalidation{Handlers: handlers})
+			if err != nil {
+				log.Error("encoding cache invalidation:", err)
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(time.Minute))
+			if err := ws.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close disconnects the clients, which drop the replies of the server.
+func (ci *cacheInvalidator) close() {
+	ci.Lock()
+	defer ci.Unlock()
+	for service, ws := range ci.watchers {
+		for w := range ws {
+			close(w)
+		}
+		delete(ci.watchers, service)
+	}
+}
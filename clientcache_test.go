@@ -0,0 +1,79 @@
+package onet
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const cacheServiceName = "cacheService"
+
+type cacheQuery struct {
+	Calls int64
+}
+
+type cacheService struct {
+	*ServiceProcessor
+	calls int64
+}
+
+func (s *cacheService) Query(msg *cacheQuery) (*cacheQuery, error) {
+	return &cacheQuery{Calls: atomic.AddInt64(&s.calls, 1)}, nil
+}
+
+func TestClient_CacheReplies(t *testing.T) {
+	var srv *cacheService
+	RegisterNewService(cacheServiceName, func(c *Context) (Service, error) {
+		srv = &cacheService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterHandler(srv.Query)
+	})
+	defer UnregisterService(cacheServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+
+	cl := local.NewClient(cacheServiceName)
+	query := func() int64 {
+		reply := &cacheQuery{}
+		require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &cacheQuery{}, reply))
+		return reply.Calls
+	}
+	require.Equal(t, int64(1), query())
+	require.Equal(t, int64(2), query())
+
+	cl.CacheReplies("cacheQuery", time.Hour)
+	require.Equal(t, int64(3), query())
+	require.Equal(t, int64(3), query())
+	cl.InvalidateCache("cacheQuery")
+	require.Equal(t, int64(4), query())
+	require.Equal(t, int64(4), query())
+
+	// wait for the client to listen for the invalidations
+	for i := 0; i < 100; i++ {
+		cc := cl.cache
+		cc.Lock()
+		watching := cc.watchers[h.ServerIdentity.ID] != nil
+		cc.Unlock()
+		if watching {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.InvalidateClientCaches("cacheQuery")
+	var calls int64
+	for i := 0; i < 100; i++ {
+		if calls = query(); calls == 5 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, int64(5), calls)
+	require.Equal(t, int64(5), query())
+
+	cl.CacheReplies("cacheQuery", 0)
+	require.Equal(t, int64(6), query())
+	require.NoError(t, cl.Close())
+}
@@ -0,0 +1,200 @@
+package onet
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock gives the time to the services and protocols. A server uses the real
+// time, unless it is given a VirtualClock with Server.SetClock, for example
+// in the deterministic simulations.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel receiving the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// Sleep waits for d to pass.
+	Sleep(d time.Duration)
+}
+
+// RealClock is the Clock of the system.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// VirtualClock is a Clock whose time only passes when it is advanced, so
+// that a run doesn't depend on how fast the machine is.
+type VirtualClock struct {
+	now    time.Time
+	timers []*virtualTimer
+	sync.Mutex
+}
+
+type virtualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewVirtualClock returns a clock starting at the given time.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now implements Clock.
+func (vc *VirtualClock) Now() time.Time {
+	vc.Lock()
+	defer vc.Unlock()
+	return vc.now
+}
+
+// After implements Clock. The channel receives the time when the clock is
+// advanced past d.
+func (vc *VirtualClock) After(d time.Duration) <-chan time.Time {
+	vc.Lock()
+	defer vc.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- vc.now
+		return ch
+	}
+	vc.timers = append(vc.timers, &virtualTimer{vc.now.Add(d), ch})
+	return ch
+}
+
+// Sleep implements Clock. It returns when the clock is advanced past d.
+func (vc *VirtualClock) Sleep(d time.Duration) {
+	<-vc.After(d)
+}
+
+// Advance moves the time forward by d and fires the timers that are due.
+func (vc *VirtualClock) Advance(d time.Duration) {
+	vc.Lock()
+	defer vc.Unlock()
+	vc.now = vc.now.Add(d)
+	vc.fire()
+}
+
+// AdvanceToNext moves the time to the next timer and fires it. It returns
+// false if there is no timer waiting.
+func (vc *VirtualClock) AdvanceToNext() bool {
+	vc.Lock()
+	defer vc.Unlock()
+	if len(vc.timers) == 0 {
+		return false
+	}
+	next := vc.timers[0].at
+	for _, t := range vc.timers[1:] {
+		if t.at.Before(next) {
+			next = t.at
+		}
+	}
+	if next.After(vc.now) {
+		vc.now = next
+	}
+	vc.fire()
+	return true
+}
+
+// Timers returns the number of timers waiting.
+func (vc *VirtualClock) Timers() int {
+	vc.Lock()
+	defer vc.Unlock()
+	return len(vc.timers)
+}
+
+// fire fires the timers that are due, the earliest first.
+func (vc *VirtualClock) fire() {
+	sort.SliceStable(vc.timers, func(i, j int) bool {
+		return vc.timers[i].at.Before(vc.timers[j].at)
+	})
+	var i int
+	for ; i < len(vc.timers) && !vc.timers[i].at.After(vc.now); i++ {
+		vc.timers[i].ch <- vc.now
+	}
+	vc.timers = vc.timers[i:]
+}
+
+// DriftClock is a Clock disagreeing with its base one: it is ahead by an
+// offset and runs faster by the rate drift, or behind and slower if they are
+// negative, so that the servers sharing a clock in a simulation still each
+// have their own time, as on different machines.
+type DriftClock struct {
+	base   Clock
+	start  time.Time
+	offset time.Duration
+	drift  float64
+}
+
+// NewDriftClock returns a clock drifting from base from now on. The drift
+// must be above -1, like 1e-4 for a clock gaining 0.1ms every second.
+func NewDriftClock(base Clock, offset time.Duration, drift float64) *DriftClock {
+	return &DriftClock{base: base, start: base.Now(), offset: offset, drift: drift}
+}
+
+// Now implements Clock.
+func (dc *DriftClock) Now() time.Time {
+	elapsed := dc.base.Now().Sub(dc.start)
+	return dc.start.Add(dc.offset + time.Duration(float64(elapsed)*(1+dc.drift)))
+}
+
+// After implements Clock. The channel receives the time once d has passed
+// on this clock.
+func (dc *DriftClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	base := dc.base.After(time.Duration(float64(d) / (1 + dc.drift)))
+	go func() {
+		<-base
+		ch <- dc.Now()
+	}()
+	return ch
+}
+
+// Sleep implements Clock.
+func (dc *DriftClock) Sleep(d time.Duration) {
+	<-dc.After(d)
+}
+
+// serverClock holds the clock of a server.
+type serverClock struct {
+	clock Clock
+	sync.Mutex
+}
+
+// SetClock sets the clock given to the services and protocols of the server.
+// A nil clock is the real one, stretched by SetTimeScale.
+func (c *Server) SetClock(clock Clock) {
+	c.clock.Lock()
+	defer c.clock.Unlock()
+	c.clock.clock = clock
+}
+
+// Clock returns the clock of the server.
+func (c *Server) Clock() Clock {
+	c.clock.Lock()
+	defer c.clock.Unlock()
+	if c.clock.clock == nil {
+		timeScale.RLock()
+		defer timeScale.RUnlock()
+		return timeScale.clock
+	}
+	return c.clock.clock
+}
+
+// SetClock sets the clock of the servers of the test, including the ones
+// created afterwards. With a VirtualClock, the timeouts of the services and
+// protocols using the clock, and the tasks of Context.Schedule, only expire
+// when the test advances it, so that they can be tested without waiting. It
+// should be called before the servers start their timers. A nil clock is the
+// real one.
+func (l *LocalTest) SetClock(clock Clock) {
+	l.clock = clock
+	for _, srv := range l.Servers {
+		srv.SetClock(clock)
+	}
+	l.setSchedulerIdle()
+}
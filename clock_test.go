@@ -0,0 +1,117 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	require.Equal(t, start, vc.Now())
+	require.False(t, vc.AdvanceToNext())
+
+	now := vc.After(0)
+	require.Equal(t, start, <-now)
+	later := vc.After(2 * time.Second)
+	sooner := vc.After(time.Second)
+	require.Equal(t, 2, vc.Timers())
+
+	vc.Advance(500 * time.Millisecond)
+	select {
+	case <-sooner:
+		require.Fail(t, "timer fired too early")
+	default:
+	}
+	require.True(t, vc.AdvanceToNext())
+	require.Equal(t, start.Add(time.Second), <-sooner)
+	require.True(t, vc.AdvanceToNext())
+	require.Equal(t, start.Add(2*time.Second), <-later)
+	require.Equal(t, 0, vc.Timers())
+
+	slept := make(chan bool)
+	go func() {
+		vc.Sleep(time.Minute)
+		slept <- true
+	}()
+	for vc.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	vc.Advance(time.Minute)
+	<-slept
+	require.Equal(t, start.Add(time.Minute+2*time.Second), vc.Now())
+}
+
+func TestDriftClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	dc := NewDriftClock(vc, time.Second, 0.5)
+	require.Equal(t, start.Add(time.Second), dc.Now())
+	vc.Advance(2 * time.Second)
+	require.Equal(t, start.Add(4*time.Second), dc.Now())
+
+	// 3s on the drifting clock are 2s on the base one
+	later := dc.After(3 * time.Second)
+	vc.Advance(time.Second)
+	select {
+	case <-later:
+		require.Fail(t, "timer fired too early")
+	case <-time.After(10 * time.Millisecond):
+	}
+	vc.Advance(time.Second)
+	require.Equal(t, start.Add(7*time.Second), <-later)
+}
+
+func TestServer_SetClock(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	server := local.GenServers(1)[0]
+	require.Equal(t, RealClock, server.Clock())
+
+	vc := NewVirtualClock(time.Unix(0, 0))
+	server.SetClock(vc)
+	require.Equal(t, vc, server.Clock())
+	server.SetClock(nil)
+	require.Equal(t, RealClock, server.Clock())
+}
+
+func TestLocalTest_SetClock(t *testing.T) {
+	var c *Context
+	RegisterNewService("clockService", func(ctx *Context) (Service, error) {
+		c = ctx
+		return &DummyService{c: ctx}, nil
+	})
+	defer UnregisterService("clockService")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	vc := NewVirtualClock(time.Unix(0, 0))
+	local.SetClock(vc)
+	servers := local.GenServers(2)
+	for _, s := range servers {
+		require.Equal(t, vc, s.Clock())
+	}
+
+	runs := make(chan bool, 10)
+	require.NoError(t, c.Schedule("hourly", Every(time.Hour), func() {
+		runs <- true
+	}))
+	for vc.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	vc.Advance(59 * time.Minute)
+	select {
+	case <-runs:
+		require.Fail(t, "task ran too early")
+	case <-time.After(20 * time.Millisecond):
+	}
+	vc.Advance(time.Minute)
+	<-runs
+	require.True(t, c.Unschedule("hourly"))
+
+	local.SetClock(nil)
+	for _, s := range servers {
+		require.Equal(t, RealClock, s.Clock())
+	}
+}
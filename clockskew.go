@@ -0,0 +1,256 @@
+package onet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// DefaultMaxClockSkew is how far the clock of a peer may be from the one of
+// the server before the overlay warns about it.
+const DefaultMaxClockSkew = 5 * time.Second
+
+// how many samples of the clock of each peer are kept
+const clockSkewWindow = 8
+
+// clockSample is what one heartbeat told about the clock of a peer.
+type clockSample struct {
+	offset time.Duration
+	delay  time.Duration
+}
+
+// peerClock holds the last samples of the clock of a peer.
+type peerClock struct {
+	si       *network.ServerIdentity
+	samples  []clockSample
+	exceeded bool
+}
+
+// estimate returns the sample with the shortest round-trip, whose offset is
+// the least disturbed by the network, as NTP does.
+func (pc *peerClock) estimate() clockSample {
+	best := pc.samples[0]
+	for _, s := range pc.samples[1:] {
+		if s.delay < best.delay {
+			best = s
+		}
+	}
+	return best
+}
+
+// clockSkews are the clocks of the peers, as sampled by the heartbeats of the
+// failure monitor.
+type clockSkews struct {
+	max   time.Duration
+	peers map[network.ServerIdentityID]*peerClock
+	sync.Mutex
+}
+
+func newClockSkews() *clockSkews {
+	return &clockSkews{
+		max:   DefaultMaxClockSkew,
+		peers: make(map[network.ServerIdentityID]*peerClock),
+	}
+}
+
+// sample records the answer hb to a heartbeat, received at the time, and
+// warns if the clock of the peer went beyond the maximum skew.
+func (cs *clockSkews) sample(si *network.ServerIdentity, hb *Heartbeat, received time.Time) {
+	if hb.Sent == 0 || hb.Received == 0 || hb.Replied == 0 {
+		// the peer doesn't stamp its heartbeats
+		return
+	}
+	t1, t2, t3, t4 := hb.Sent, hb.Received, hb.Replied, received.UnixNano()
+	s := clockSample{
+		offset: time.Duration(((t2 - t1) + (t3 - t4)) / 2),
+		delay:  time.Duration((t4 - t1) - (t3 - t2)),
+	}
+	if s.delay < 0 {
+		return
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+	pc, ok := cs.peers[si.ID]
+	if !ok {
+		pc = &peerClock{si: si}
+		cs.peers[si.ID] = pc
+	}
+	pc.samples = append(pc.samples, s)
+	if len(pc.samples) > clockSkewWindow {
+		pc.samples = pc.samples[1:]
+	}
+	offset := pc.estimate().offset
+	exceeded := offset > cs.max || -offset > cs.max
+	if exceeded && !pc.exceeded {
+		log.Warnf("the clock of %s is off by %s, more than %s", si, offset, cs.max)
+	} else if !exceeded && pc.exceeded {
+		log.Lvlf2("the clock of %s is back within %s", si, cs.max)
+	}
+	pc.exceeded = exceeded
+}
+
+func (cs *clockSkews) forget(id network.ServerIdentityID) {
+	cs.Lock()
+	defer cs.Unlock()
+	delete(cs.peers, id)
+}
+
+// ClockSkewStatus is how far ahead of the server the clock of a peer is.
+type ClockSkewStatus struct {
+	ID      string
+	Address network.Address
+	Offset  time.Duration
+	// Delay is the round-trip time of the heartbeat the offset comes from,
+	// so that the offset is known within half of it.
+	Delay   time.Duration
+	Samples int
+	// Exceeded tells whether the offset is beyond the maximum skew of the
+	// overlay.
+	Exceeded bool
+}
+
+func (cs *clockSkews) status() []ClockSkewStatus {
+	cs.Lock()
+	defer cs.Unlock()
+	ret := make([]ClockSkewStatus, 0, len(cs.peers))
+	for _, pc := range cs.peers {
+		e := pc.estimate()
+		ret = append(ret, ClockSkewStatus{
+			ID:       pc.si.ID.String(),
+			Address:  pc.si.Address,
+			Offset:   e.offset,
+			Delay:    e.delay,
+			Samples:  len(pc.samples),
+			Exceeded: pc.exceeded,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret
+}
+
+// SetMaxClockSkew sets how far the clock of a peer may be before a warning
+// is logged, DefaultMaxClockSkew by default.
+func (c *Server) SetMaxClockSkew(d time.Duration) {
+	c.overlay.SetMaxClockSkew(d)
+}
+
+// SetMaxClockSkew sets how far the clock of a peer may be before a warning
+// is logged, DefaultMaxClockSkew by default.
+func (o *Overlay) SetMaxClockSkew(d time.Duration) {
+	o.clockSkews.Lock()
+	defer o.clockSkews.Unlock()
+	o.clockSkews.max = d
+}
+
+// ClockSkew returns how far ahead of the clock of the server the one of the
+// peer is, if it was sampled. The clocks of the servers given to Watch are
+// sampled with every heartbeat.
+func (o *Overlay) ClockSkew(si *network.ServerIdentity) (time.Duration, bool) {
+	o.clockSkews.Lock()
+	defer o.clockSkews.Unlock()
+	pc, ok := o.clockSkews.peers[si.ID]
+	if !ok {
+		return 0, false
+	}
+	return pc.estimate().offset, true
+}
+
+// NetworkTime returns the time of the server corrected by the median of the
+// skews of the sampled peers, so that the servers agree on the time even if
+// one of their clocks is off. It is the time of the server if no peer was
+// sampled.
+func (o *Overlay) NetworkTime() time.Time {
+	now := o.server.Clock().Now()
+	o.clockSkews.Lock()
+	offsets := make([]time.Duration, 0, len(o.clockSkews.peers)+1)
+	for _, pc := range o.clockSkews.peers {
+		offsets = append(offsets, pc.estimate().offset)
+	}
+	o.clockSkews.Unlock()
+	if len(offsets) == 0 {
+		return now
+	}
+	// the server counts as a peer without skew
+	offsets = append(offsets, 0)
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return now.Add(offsets[len(offsets)/2])
+}
+
+// TimeMode tells which time TreeNodeInstance.Timestamp gives to a protocol.
+type TimeMode int
+
+const (
+	// WallTime is the time of the clock of the server. It is the default.
+	WallTime TimeMode = iota
+	// CompensatedTime is the time of the server corrected by the skew of
+	// its peers, see Overlay.NetworkTime.
+	CompensatedTime
+	// LogicalTime is a Lamport clock, attached to the messages of the
+	// protocol, so that a message is always received after it was sent,
+	// whatever the clocks of the servers say.
+	LogicalTime
+)
+
+// logicalState is the time mode of a TreeNodeInstance and its Lamport clock.
+type logicalState struct {
+	sync.Mutex
+	mode TimeMode
+	time uint64
+}
+
+// SetTimeMode sets which time Timestamp returns. All the nodes of the
+// protocol should set the same mode in its constructor, before sending or
+// receiving a message.
+func (n *TreeNodeInstance) SetTimeMode(mode TimeMode) {
+	n.logical.Lock()
+	defer n.logical.Unlock()
+	n.logical.mode = mode
+}
+
+// Timestamp returns the time of an event of the protocol, following its
+// TimeMode: the nanoseconds since the epoch of the clock of the server or of
+// the network, or with LogicalTime the Lamport clock, which counts as an
+// event.
+func (n *TreeNodeInstance) Timestamp() int64 {
+	n.logical.Lock()
+	mode := n.logical.mode
+	if mode == LogicalTime {
+		defer n.logical.Unlock()
+		n.logical.time++
+		return int64(n.logical.time)
+	}
+	n.logical.Unlock()
+	if mode == CompensatedTime {
+		return n.overlay.NetworkTime().UnixNano()
+	}
+	return n.overlay.server.Clock().Now().UnixNano()
+}
+
+// logicalSend returns the Lamport time to attach to a message, zero if the
+// protocol doesn't use LogicalTime.
+func (n *TreeNodeInstance) logicalSend() uint64 {
+	n.logical.Lock()
+	defer n.logical.Unlock()
+	if n.logical.mode != LogicalTime {
+		return 0
+	}
+	n.logical.time++
+	return n.logical.time
+}
+
+// logicalReceive moves the Lamport clock past the time of a message received.
+func (n *TreeNodeInstance) logicalReceive(t uint64) {
+	n.logical.Lock()
+	defer n.logical.Unlock()
+	if n.logical.mode != LogicalTime {
+		return
+	}
+	if t > n.logical.time {
+		n.logical.time = t
+	}
+	n.logical.time++
+}
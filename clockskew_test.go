@@ -0,0 +1,156 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+const logicalTimeTestName = "LogicalTimeTest"
+
+type LogicalTimeTestMsg struct {
+	Sent int64
+}
+
+type logicalTimeTestReceived struct {
+	sent, received int64
+}
+
+var logicalTimeTestCh = make(chan logicalTimeTestReceived, 10)
+
+func init() {
+	network.RegisterMessage(LogicalTimeTestMsg{})
+	GlobalProtocolRegister(logicalTimeTestName, newLogicalTimeTest)
+}
+
+// logicalTimeTest sends the timestamp of the root to its children, which
+// report it and their own timestamp once they got it.
+type logicalTimeTest struct {
+	*TreeNodeInstance
+}
+
+func newLogicalTimeTest(n *TreeNodeInstance) (ProtocolInstance, error) {
+	n.SetTimeMode(LogicalTime)
+	p := &logicalTimeTest{n}
+	return p, p.RegisterHandler(p.handle)
+}
+
+func (p *logicalTimeTest) Start() error {
+	defer p.Done()
+	for _, err := range p.SendToChildrenInParallel(&LogicalTimeTestMsg{p.Timestamp()}) {
+		return err
+	}
+	return nil
+}
+
+func (p *logicalTimeTest) handle(msg struct {
+	*TreeNode
+	LogicalTimeTestMsg
+}) error {
+	defer p.Done()
+	logicalTimeTestCh <- logicalTimeTestReceived{msg.Sent, p.Timestamp()}
+	return nil
+}
+
+func TestClockSkews_sample(t *testing.T) {
+	cs := newClockSkews()
+	cs.max = time.Second
+	si := network.NewServerIdentity(tSuite.Point(), network.NewLocalAddress("peer"))
+	start := time.Now()
+	// the peer is 10s ahead, and the heartbeat took delay to go and come back
+	heartbeat := func(delay time.Duration) {
+		sent := start
+		received := sent.Add(delay/2 + 10*time.Second)
+		cs.sample(si, &Heartbeat{
+			Reply:    true,
+			Sent:     sent.UnixNano(),
+			Received: received.UnixNano(),
+			Replied:  received.UnixNano(),
+		}, sent.Add(delay))
+	}
+	heartbeat(100 * time.Millisecond)
+	heartbeat(2 * time.Millisecond)
+	heartbeat(50 * time.Millisecond)
+	st := cs.status()
+	require.Equal(t, 1, len(st))
+	require.Equal(t, 10*time.Second, st[0].Offset)
+	require.Equal(t, 2*time.Millisecond, st[0].Delay)
+	require.Equal(t, 3, st[0].Samples)
+	require.True(t, st[0].Exceeded)
+
+	// an old peer doesn't stamp its heartbeats
+	cs.sample(si, &Heartbeat{Reply: true}, start)
+	require.Equal(t, 3, cs.status()[0].Samples)
+	for i := 0; i < clockSkewWindow; i++ {
+		heartbeat(time.Second)
+	}
+	require.Equal(t, clockSkewWindow, cs.status()[0].Samples)
+	require.Equal(t, time.Second, cs.status()[0].Delay)
+
+	cs.forget(si.ID)
+	require.Equal(t, 0, len(cs.status()))
+}
+
+// TestOverlay_ClockSkew checks that the heartbeats find the skew of a server
+// whose clock is ahead.
+func TestOverlay_ClockSkew(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(3, false)
+	servers[1].SetClock(NewDriftClock(RealClock, 10*time.Second, 0))
+	o := servers[0].overlay
+	o.SetHeartbeatInterval(20 * time.Millisecond)
+	o.Watch(servers[1].ServerIdentity, servers[2].ServerIdentity)
+	_, ok := o.ClockSkew(servers[1].ServerIdentity)
+	require.False(t, ok)
+
+	for i := 0; i < 100; i++ {
+		if st, _ := servers[0].DetailedStatus(); len(st.ClockSkews) == 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	skew, ok := o.ClockSkew(servers[1].ServerIdentity)
+	require.True(t, ok)
+	require.InDelta(t, float64(10*time.Second), float64(skew), float64(100*time.Millisecond))
+	skew, ok = o.ClockSkew(servers[2].ServerIdentity)
+	require.True(t, ok)
+	require.InDelta(t, 0, float64(skew), float64(100*time.Millisecond))
+
+	st, err := servers[0].DetailedStatus()
+	require.NoError(t, err)
+	for _, cs := range st.ClockSkews {
+		require.Equal(t, cs.ID == servers[1].ServerIdentity.ID.String(), cs.Exceeded)
+	}
+	// the median skew is the one of the two servers in time
+	require.InDelta(t, float64(time.Now().UnixNano()), float64(o.NetworkTime().UnixNano()),
+		float64(100*time.Millisecond))
+
+	o.Unwatch(servers[1].ServerIdentity)
+	_, ok = o.ClockSkew(servers[1].ServerIdentity)
+	require.False(t, ok)
+}
+
+// TestTreeNodeInstance_LogicalTime checks that the messages are received
+// after they were sent, even by a server whose clock is behind.
+func TestTreeNodeInstance_LogicalTime(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(3, true)
+	servers[1].SetClock(NewDriftClock(RealClock, -time.Hour, 0))
+
+	_, err := local.StartProtocol(logicalTimeTestName, tree)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-logicalTimeTestCh:
+			require.Equal(t, int64(1), r.sent)
+			// sending and receiving are events too
+			require.True(t, r.received > r.sent+2, r)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no message received")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package onet
+
+import (
+	"net"
+	"strconv"
+
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// defaultClusterPort is the port of the first conode of a cluster.
+const defaultClusterPort = 7770
+
+// ClusterConfig is the configuration of the conodes of NewCluster.
+type ClusterConfig struct {
+	// Size is how many conodes the cluster has.
+	Size int
+	// Host is the IP address the conodes listen on, 127.0.0.1 by default.
+	Host string
+	// Port is the one of the first conode, 7770 by default. The conode i
+	// listens for the other conodes on Port+2i, and for the clients on
+	// Port+2i+1.
+	Port int
+	// Dir holds the databases of the conodes, which are named after their
+	// public keys. By default it is the one of CONODE_SERVICE_PATH.
+	Dir string
+	// ConnType is how the conodes connect to each other, network.TLS by
+	// default.
+	ConnType network.ConnType
+}
+
+// Cluster are conodes running in the same process, with the roster they
+// form.
+type Cluster struct {
+	Servers []*Server
+	Roster  *Roster
+}
+
+// NewCluster returns conodes with their own identities and ports, to run in
+// the same process, for small test networks or clusters on one machine. The
+// options are given to NewServer for every conode, after the ones of the
+// cluster, so they must not give an identity.
+func NewCluster(cfg ClusterConfig, opts ...Option) (*Cluster, error) {
+	if cfg.Size <= 0 {
+		return nil, xerrors.New("a cluster needs conodes")
+	}
+	host := cfg.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultClusterPort
+	}
+	connType := cfg.ConnType
+	if connType == "" {
+		connType = network.TLS
+	}
+	o := &serverOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, xerrors.Errorf("option: %v", err)
+		}
+	}
+	if o.suite == nil {
+		o.suite = suites.MustFind("Ed25519")
+	}
+	c := &Cluster{}
+	var sis []*network.ServerIdentity
+	for i := 0; i < cfg.Size; i++ {
+		addr := net.JoinHostPort(host, strconv.Itoa(port+2*i))
+		si := newServerIdentity(o.suite, network.NewAddress(connType, addr))
+		own := []Option{WithSuite(o.suite), WithServerIdentity(si)}
+		if cfg.Dir != "" {
+			own = append(own, WithStorageDir(cfg.Dir))
+		}
+		srv, err := NewServer(append(own, opts...)...)
+		if err != nil {
+			c.Close()
+			return nil, xerrors.Errorf("conode %d: %v", i, err)
+		}
+		c.Servers = append(c.Servers, srv)
+		sis = append(sis, srv.ServerIdentity)
+	}
+	c.Roster = NewRoster(sis)
+	return c, nil
+}
+
+// Start starts the conodes and returns once they are all up and running.
+func (c *Cluster) Start() {
+	for _, srv := range c.Servers {
+		srv.StartInBackground()
+	}
+}
+
+// Close closes all the conodes, and returns the first error.
+func (c *Cluster) Close() error {
+	var first error
+	for _, srv := range c.Servers {
+		if err := srv.Close(); err != nil && first == nil {
+			first = xerrors.Errorf("closing %s: %v", srv.ServerIdentity, err)
+		}
+	}
+	return first
+}
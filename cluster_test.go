@@ -0,0 +1,37 @@
+package onet
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestNewCluster(t *testing.T) {
+	_, err := NewCluster(ClusterConfig{})
+	require.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "cluster")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := NewCluster(ClusterConfig{Size: 3, Port: 2600, Dir: dir, ConnType: network.PlainTCP}, WithSuite(tSuite))
+	require.NoError(t, err)
+	defer c.Close()
+	require.Len(t, c.Roster.List, 3)
+	require.Equal(t, "2602", c.Servers[1].ServerIdentity.Address.Port())
+	require.False(t, c.Servers[0].ServerIdentity.Public.Equal(c.Servers[1].ServerIdentity.Public))
+
+	c.Start()
+	tree := c.Roster.GenerateBinaryTree()
+	pi, err := c.Servers[0].overlay.StartProtocol(pingPongProtoName, tree, NilServiceID)
+	require.NoError(t, err)
+	<-pi.(*pingPongProto).done
+
+	// the databases are next to each other
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	require.NoError(t, c.Close())
+}
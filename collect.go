@@ -0,0 +1,311 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// DefaultCollectTimeout is how long the root of a CollectProtocol waits for
+// the responses when no timeout is given.
+const DefaultCollectTimeout = 10 * time.Second
+
+// CollectRequestMsgID of CollectRequest message as registered in network
+var CollectRequestMsgID = network.RegisterMessage(CollectRequest{})
+
+// CollectResponseMsgID of CollectResponse message as registered in network
+var CollectResponseMsgID = network.RegisterMessage(CollectResponse{})
+
+// CollectResponder returns the response of a node to the request of a
+// CollectProtocol.
+type CollectResponder func(n *TreeNodeInstance, req network.Message) (network.Message, error)
+
+// CollectReducer aggregates the responses of a node and of its subtrees into
+// one. It is given at least one response.
+type CollectReducer func(responses []network.Message) (network.Message, error)
+
+// CollectResult is the outcome of a CollectProtocol at the root.
+type CollectResult struct {
+	// Response is the aggregate of the responses received, nil if there
+	// is none.
+	Response network.Message
+	// Missing are the nodes whose response is not in Response, because
+	// they didn't answer in time, failed or are below one that did.
+	Missing []*network.ServerIdentity
+	// Err is set when the responses couldn't be aggregated at the root.
+	Err error
+}
+
+// CollectProtocol sends a request from the root down the tree and aggregates
+// the responses of the nodes up the tree: every node gives its response and
+// the ones of its children to the reducer, and sends the result to its
+// parent. The nodes that don't answer in time are reported as missing, with
+// their subtree, instead of blocking their parent.
+//
+// It is registered by the services with NewCollectProtocol, and the root
+// sets Request and Timeout before starting it, and then reads Result.
+type CollectProtocol struct {
+	*TreeNodeInstance
+	// Request is given to the responder of every node.
+	Request network.Message
+	// Timeout is how long the root waits for the responses. The nodes
+	// below wait less, so that they answer before their parent gives up.
+	Timeout time.Duration
+	// Result gets the outcome at the root.
+	Result chan CollectResult
+
+	respond CollectResponder
+	reduce  CollectReducer
+
+	lock      sync.Mutex
+	finished  bool
+	done      chan struct{}
+	responses []network.Message
+	missing   []network.ServerIdentityID
+	answered  map[TreeNodeID]bool
+}
+
+// CollectRequest is the request of a CollectProtocol sent to the children.
+type CollectRequest struct {
+	// Request is the marshalled request.
+	Request []byte
+	// Timeout is how long the node has to answer.
+	Timeout time.Duration
+}
+
+// CollectResponse is the aggregated response of a subtree.
+type CollectResponse struct {
+	// Response is the marshalled aggregate, empty if no node answered.
+	Response []byte
+	// Missing are the nodes of the subtree that are not in Response.
+	Missing []network.ServerIdentityID
+}
+
+// NewCollectProtocol returns the constructor of a CollectProtocol with the
+// responder and the reducer, to be registered by the service.
+func NewCollectProtocol(respond CollectResponder, reduce CollectReducer) NewProtocol {
+	return func(n *TreeNodeInstance) (ProtocolInstance, error) {
+		p := &CollectProtocol{
+			TreeNodeInstance: n,
+			Timeout:          DefaultCollectTimeout,
+			Result:           make(chan CollectResult, 1),
+			respond:          respond,
+			reduce:           reduce,
+			answered:         make(map[TreeNodeID]bool),
+			done:             make(chan struct{}),
+		}
+		if err := p.RegisterHandlers(p.handleRequest, p.handleResponse); err != nil {
+			return nil, xerrors.Errorf("registering handlers: %v", err)
+		}
+		return p, nil
+	}
+}
+
+// Start sends the request down the tree.
+func (p *CollectProtocol) Start() error {
+	if p.Request == nil {
+		return xerrors.New("no request")
+	}
+	p.collect(p.Request, p.Timeout)
+	return nil
+}
+
+func (p *CollectProtocol) handleRequest(msg struct {
+	*TreeNode
+	CollectRequest
+}) error {
+	_, req, err := network.Unmarshal(msg.Request, p.Suite())
+	if err != nil {
+		// the children never get the request
+		p.lock.Lock()
+		p.missing = append(p.missing, p.ServerIdentity().ID)
+		p.lock.Unlock()
+		p.finish()
+		return xerrors.Errorf("decoding request: %v", err)
+	}
+	p.collect(req, msg.Timeout)
+	return nil
+}
+
+// collect gives the response of the node, and waits for the ones of the
+// children for most of the timeout.
+func (p *CollectProtocol) collect(req network.Message, timeout time.Duration) {
+	resp, err := p.respond(p.TreeNodeInstance, req)
+	p.lock.Lock()
+	if err != nil {
+		log.Lvl2(p.ServerIdentity(), "collect responder failed:", err)
+		p.missing = append(p.missing, p.ServerIdentity().ID)
+	} else {
+		p.responses = append(p.responses, resp)
+	}
+	p.lock.Unlock()
+	if p.IsLeaf() {
+		p.finish()
+		return
+	}
+
+	buf, err := network.Marshal(req)
+	if err != nil {
+		log.Error("marshalling collect request:", err)
+		p.finish()
+		return
+	}
+	// the children get less time, so that their answer arrives before the
+	// node gives up
+	wait := timeout * 9 / 10
+	child := &CollectRequest{Request: buf, Timeout: timeout * 8 / 10}
+	// in parallel, so that the children that can't be reached don't delay
+	// the others
+	for _, c := range p.Children() {
+		go func(c *TreeNode) {
+			if err := p.SendTo(c, child); err != nil {
+				log.Lvl2(p.ServerIdentity(), "couldn't send collect request:", err)
+				p.answer(c, nil)
+			}
+		}(c)
+	}
+	go func() {
+		select {
+		case <-p.Clock().After(wait):
+			p.finish()
+		case <-p.done:
+		}
+	}()
+}
+
+func (p *CollectProtocol) handleResponse(msg struct {
+	*TreeNode
+	CollectResponse
+}) error {
+	p.answer(msg.TreeNode, &msg.CollectResponse)
+	return nil
+}
+
+// answer records the response of the child, nil if it won't answer, and
+// finishes when all the children answered.
+func (p *CollectProtocol) answer(c *TreeNode, resp *CollectResponse) {
+	p.lock.Lock()
+	if p.finished || p.answered[c.ID] {
+		p.lock.Unlock()
+		return
+	}
+	p.answered[c.ID] = true
+	switch {
+	case resp == nil:
+		p.missing = append(p.missing, p.subtree(c)...)
+	case len(resp.Response) == 0:
+		p.missing = append(p.missing, resp.Missing...)
+	default:
+		_, r, err := network.Unmarshal(resp.Response, p.Suite())
+		if err != nil {
+			log.Lvl2(p.ServerIdentity(), "invalid collect response:", err)
+			p.missing = append(p.missing, p.subtree(c)...)
+		} else {
+			p.responses = append(p.responses, r)
+			p.missing = append(p.missing, resp.Missing...)
+		}
+	}
+	all := len(p.answered) == len(p.Children())
+	p.lock.Unlock()
+	if all {
+		p.finish()
+	}
+}
+
+// finish aggregates the responses received and sends them to the parent, or
+// gives them as the result at the root. The children that didn't answer yet
+// are missing.
+func (p *CollectProtocol) finish() {
+	p.lock.Lock()
+	if p.finished {
+		p.lock.Unlock()
+		return
+	}
+	p.finished = true
+	close(p.done)
+	for _, c := range p.Children() {
+		if !p.answered[c.ID] {
+			p.missing = append(p.missing, p.subtree(c)...)
+		}
+	}
+	responses, missing := p.responses, p.missing
+	p.lock.Unlock()
+	defer p.Done()
+
+	var agg network.Message
+	var err error
+	if len(responses) > 0 {
+		agg, err = p.reduce(responses)
+	}
+	if p.IsRoot() {
+		p.Result <- CollectResult{
+			Response: agg,
+			Missing:  p.identities(missing),
+			Err:      err,
+		}
+		return
+	}
+
+	resp := &CollectResponse{Missing: missing}
+	if err == nil && agg != nil {
+		resp.Response, err = network.Marshal(agg)
+	}
+	if err != nil {
+		log.Lvl2(p.ServerIdentity(), "couldn't aggregate collect responses:", err)
+		resp = &CollectResponse{Missing: p.subtree(p.TreeNode())}
+	}
+	if err := p.SendToParent(resp); err != nil {
+		log.Lvl2(p.ServerIdentity(), "couldn't send collect response:", err)
+	}
+}
+
+// subtree returns the IDs of the nodes of the subtree of c.
+func (p *CollectProtocol) subtree(c *TreeNode) []network.ServerIdentityID {
+	var ids []network.ServerIdentityID
+	c.Visit(0, func(_ int, n *TreeNode) {
+		ids = append(ids, n.ServerIdentity.ID)
+	})
+	return ids
+}
+
+// identities returns the identities of the roster with the IDs.
+func (p *CollectProtocol) identities(ids []network.ServerIdentityID) []*network.ServerIdentity {
+	var sis []*network.ServerIdentity
+	for _, id := range ids {
+		if _, si := p.Roster().Search(id); si != nil {
+			sis = append(sis, si)
+		}
+	}
+	return sis
+}
+
+// collectStar runs the CollectProtocol registered under name on a star of the
+// roster rooted at us, so that a failed node only misses its own response,
+// and returns the aggregate of the responses.
+func (o *Overlay) collectStar(name string, ro *Roster, sid ServiceID, req network.Message, timeout time.Duration) (network.Message, error) {
+	tree := ro.GenerateNaryTreeWithRoot(len(ro.List)-1, o.ServerIdentity())
+	if tree == nil {
+		return nil, xerrors.New("not a member of the roster")
+	}
+	pi, err := o.CreateProtocol(name, tree, sid)
+	if err != nil {
+		return nil, xerrors.Errorf("creating protocol: %v", err)
+	}
+	p, ok := pi.(*CollectProtocol)
+	if !ok {
+		return nil, xerrors.Errorf("%s is not a collect protocol", name)
+	}
+	p.Request = req
+	p.Timeout = timeout
+	if err := p.Start(); err != nil {
+		return nil, xerrors.Errorf("starting: %v", err)
+	}
+	res := <-p.Result
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return res.Response, nil
+}
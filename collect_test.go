@@ -0,0 +1,105 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+const collectTestName = "collectTest"
+
+type collectCount struct {
+	N int
+}
+
+// the responders of these servers are too slow or fail
+var collectFaulty = struct {
+	sync.Mutex
+	slow, failing network.ServerIdentityID
+}{}
+
+func init() {
+	network.RegisterMessage(&collectCount{})
+	GlobalProtocolRegister(collectTestName, NewCollectProtocol(
+		func(n *TreeNodeInstance, req network.Message) (network.Message, error) {
+			collectFaulty.Lock()
+			slow := n.ServerIdentity().ID.Equal(collectFaulty.slow)
+			failing := n.ServerIdentity().ID.Equal(collectFaulty.failing)
+			collectFaulty.Unlock()
+			if slow {
+				time.Sleep(time.Second)
+			}
+			if failing {
+				return nil, xerrors.New("failing")
+			}
+			return &collectCount{N: req.(*collectCount).N}, nil
+		},
+		func(responses []network.Message) (network.Message, error) {
+			sum := 0
+			for _, r := range responses {
+				sum += r.(*collectCount).N
+			}
+			return &collectCount{N: sum}, nil
+		}))
+}
+
+func TestCollectProtocol(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+
+	pi, err := local.CreateProtocol(collectTestName, tree)
+	require.NoError(t, err)
+	p := pi.(*CollectProtocol)
+	p.Request = &collectCount{N: 2}
+	require.NoError(t, p.Start())
+
+	res := <-p.Result
+	require.NoError(t, res.Err)
+	require.Equal(t, 14, res.Response.(*collectCount).N)
+	require.Empty(t, res.Missing)
+}
+
+// Tests that the subtree of a node that doesn't answer in time and a node
+// failing are reported missing, and the others aggregated.
+func TestCollectProtocol_Missing(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(7, 7, 2, true)
+
+	slow := tree.Root.Children[0]
+	failing := tree.Root.Children[1].Children[0]
+	collectFaulty.Lock()
+	collectFaulty.slow = slow.ServerIdentity.ID
+	collectFaulty.failing = failing.ServerIdentity.ID
+	collectFaulty.Unlock()
+	defer func() {
+		collectFaulty.Lock()
+		collectFaulty.slow = network.ServerIdentityID{}
+		collectFaulty.failing = network.ServerIdentityID{}
+		collectFaulty.Unlock()
+	}()
+
+	pi, err := local.CreateProtocol(collectTestName, tree)
+	require.NoError(t, err)
+	p := pi.(*CollectProtocol)
+	p.Request = &collectCount{N: 1}
+	p.Timeout = 500 * time.Millisecond
+	require.NoError(t, p.Start())
+
+	res := <-p.Result
+	require.NoError(t, res.Err)
+	require.Equal(t, 3, res.Response.(*collectCount).N)
+	missing := make(map[network.ServerIdentityID]bool)
+	for _, si := range res.Missing {
+		missing[si.ID] = true
+	}
+	require.Equal(t, 4, len(missing))
+	require.True(t, missing[slow.ServerIdentity.ID])
+	require.True(t, missing[slow.Children[0].ServerIdentity.ID])
+	require.True(t, missing[failing.ServerIdentity.ID])
+}
@@ -0,0 +1,100 @@
+package onet
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// committee returns the indexes in the roster of the hosts given by
+// Committee, in order.
+func (s *SimulationBFTree) committee(hosts int) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, v := range splitList(s.Committee) {
+		bounds := strings.Split(v, "-")
+		if len(bounds) > 2 {
+			return nil, xerrors.Errorf("wrong range %q", v)
+		}
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, xerrors.Errorf("wrong index %q", v)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, xerrors.Errorf("wrong index %q", v)
+			}
+		}
+		if first < 0 || last < first || last >= hosts {
+			return nil, xerrors.Errorf("%q is not in the %d hosts", v, hosts)
+		}
+		for i := first; i <= last; i++ {
+			seen[i] = true
+		}
+	}
+	var indexes []int
+	for i := range seen {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// CommitteeTree returns the tree of the hosts running the protocol in the
+// round, so that a protocol can run on a part of the roster without
+// deploying again. They are the hosts given by Committee, else CommitteeSize
+// hosts drawn at random in every round, else all of them. The root of
+// sc.Tree, which runs the simulation, is always the root of the committee.
+func (s *SimulationBFTree) CommitteeTree(sc *SimulationConfig, round int) (*Tree, error) {
+	if s.Committee == "" && s.CommitteeSize == 0 {
+		return sc.Tree, nil
+	}
+	if sc.Tree == nil {
+		return nil, xerrors.New("no tree")
+	}
+	list := sc.Tree.Roster.List
+	root := sc.Tree.Root.ServerIdentity
+	var others []int
+	for i, si := range list {
+		if !si.Equal(root) {
+			others = append(others, i)
+		}
+	}
+
+	var members []int
+	switch {
+	case s.Committee != "" && s.CommitteeSize != 0:
+		return nil, xerrors.New("only one of Committee and CommitteeSize can be set")
+	case s.Committee != "":
+		indexes, err := s.committee(len(list))
+		if err != nil {
+			return nil, xerrors.Errorf("committee: %v", err)
+		}
+		for _, i := range indexes {
+			if !list[i].Equal(root) {
+				members = append(members, i)
+			}
+		}
+	default:
+		if s.CommitteeSize < 1 || s.CommitteeSize > len(list) {
+			return nil, xerrors.Errorf("CommitteeSize must be between 1 and %d", len(list))
+		}
+		// the same seed and round give the same committee, also when the
+		// simulation resumes
+		r := rand.New(rand.NewSource(s.CommitteeSeed + int64(round)))
+		for _, i := range r.Perm(len(others))[:s.CommitteeSize-1] {
+			members = append(members, others[i])
+		}
+		sort.Ints(members)
+	}
+
+	entities := append(make([]*network.ServerIdentity, 0, len(members)+1), root)
+	for _, i := range members {
+		entities = append(entities, list[i])
+	}
+	return NewRoster(entities).GenerateBigNaryTree(s.BF, len(entities)), nil
+}
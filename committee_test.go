@@ -0,0 +1,74 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// committeeConfig returns the configuration of a simulation of ten hosts.
+func committeeConfig(t *testing.T) (*SimulationBFTree, *SimulationConfig) {
+	sc := &SimulationConfig{}
+	sb := &SimulationBFTree{Hosts: 10, BF: 2, Suite: "Ed25519"}
+	sb.CreateRoster(sc, []string{"10.0.0.1", "10.0.0.2"}, 2000)
+	require.NoError(t, sb.CreateTree(sc))
+	return sb, sc
+}
+
+func TestSimulationBFTree_CommitteeTree(t *testing.T) {
+	sb, sc := committeeConfig(t)
+	tree, err := sb.CommitteeTree(sc, 0)
+	require.NoError(t, err)
+	require.Equal(t, sc.Tree, tree)
+
+	sb.Committee = "3, 5-7 0"
+	tree, err = sb.CommitteeTree(sc, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, tree.Size())
+	list := sc.Roster.List
+	require.Equal(t, list[0], tree.Root.ServerIdentity)
+	for i, si := range tree.Roster.List {
+		require.Equal(t, list[[]int{0, 3, 5, 6, 7}[i]], si)
+	}
+
+	sb.Committee = ""
+	sb.CommitteeSize = 4
+	tree, err = sb.CommitteeTree(sc, 1)
+	require.NoError(t, err)
+	require.Equal(t, 4, tree.Size())
+	require.Equal(t, list[0], tree.Root.ServerIdentity)
+	// the same round gives the same committee
+	again, err := sb.CommitteeTree(sc, 1)
+	require.NoError(t, err)
+	require.Equal(t, tree.Roster.ID, again.Roster.ID)
+	// but not always in other rounds
+	differ := false
+	for round := 2; round < 10 && !differ; round++ {
+		other, err := sb.CommitteeTree(sc, round)
+		require.NoError(t, err)
+		differ = !other.Roster.ID.Equal(tree.Roster.ID)
+	}
+	require.True(t, differ)
+
+	sb.CommitteeSize = 10
+	tree, err = sb.CommitteeTree(sc, 0)
+	require.NoError(t, err)
+	require.Equal(t, 10, tree.Size())
+}
+
+func TestSimulationBFTree_CommitteeTree_error(t *testing.T) {
+	sb, sc := committeeConfig(t)
+	for _, s := range []SimulationBFTree{
+		{Committee: "1", CommitteeSize: 2},
+		{Committee: "10"},
+		{Committee: "3-1"},
+		{Committee: "1-2-3"},
+		{Committee: "x"},
+		{CommitteeSize: 11},
+		{CommitteeSize: -1},
+	} {
+		sb.Committee, sb.CommitteeSize = s.Committee, s.CommitteeSize
+		_, err := sb.CommitteeTree(sc, 0)
+		require.Error(t, err, s)
+	}
+}
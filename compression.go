@@ -0,0 +1,59 @@
+package onet
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// DefaultCompressionMinSize is a sensible size to give to
+// SetClientCompression, as smaller replies gain little from compression.
+const DefaultCompressionMinSize = 1024
+
+// SetClientCompression lets the clients of the service negotiate
+// permessage-deflate on the websocket, which is off by default as some
+// clients don't support it well. Only the replies of at least minSize bytes
+// are compressed, zero compresses all of them, and a negative minSize turns
+// the compression off again. It is meant for the services sending large
+// replies, like blocks or states, and only applies to the connections opened
+// afterwards.
+func (c *Context) SetClientCompression(minSize int) {
+	w := c.server.WebSocket
+	name := ServiceFactory.Name(c.serviceID)
+	w.Lock()
+	defer w.Unlock()
+	if minSize < 0 {
+		delete(w.compression, name)
+		return
+	}
+	if w.compression == nil {
+		w.compression = make(map[string]int)
+	}
+	w.compression[name] = minSize
+}
+
+// compressionFor returns whether the connections of the service may be
+// compressed, and the size from which the replies are compressed.
+func (w *WebSocket) compressionFor(service string) (bool, int) {
+	if w == nil {
+		return false, 0
+	}
+	w.Lock()
+	defer w.Unlock()
+	minSize, ok := w.compression[service]
+	return ok, minSize
+}
+
+// writeCompressed sends the reply, compressed if the compression has been
+// negotiated and the reply is big enough.
+func writeCompressed(ws *websocket.Conn, mt int, reply []byte, minSize int) error {
+	ws.EnableWriteCompression(len(reply) >= minSize)
+	return ws.WriteMessage(mt, reply)
+}
+
+// SetCompression makes the client ask for permessage-deflate when it opens
+// its connections, which the nodes accept for the services that enabled it
+// with Context.SetClientCompression.
+func (c *Client) SetCompression(enabled bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.compression = enabled
+}
@@ -0,0 +1,58 @@
+package onet
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const compressionServiceName = "compressionService"
+
+type compressionQuery struct {
+	Size int
+}
+
+type compressionReply struct {
+	Data []byte
+}
+
+func TestClient_SetCompression(t *testing.T) {
+	RegisterNewService(compressionServiceName, func(c *Context) (Service, error) {
+		s := NewServiceProcessor(c)
+		c.SetClientCompression(DefaultCompressionMinSize)
+		return s, s.RegisterHandler(func(msg *compressionQuery) (*compressionReply, error) {
+			return &compressionReply{Data: bytes.Repeat([]byte{1}, msg.Size)}, nil
+		})
+	})
+	defer UnregisterService(compressionServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	url := "ws://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/"
+	negotiated := func(service string, enabled bool) bool {
+		d := &websocket.Dialer{EnableCompression: enabled}
+		conn, resp, err := d.Dial(url+service+"/compressionQuery", http.Header{})
+		require.NoError(t, err)
+		defer conn.Close()
+		return resp.Header.Get("Sec-Websocket-Extensions") != ""
+	}
+	require.True(t, negotiated(compressionServiceName, true))
+	require.False(t, negotiated(compressionServiceName, false))
+	require.False(t, negotiated(testServiceName, true))
+
+	cl := local.NewClient(compressionServiceName)
+	cl.SetCompression(true)
+	for _, size := range []int{10, 100000} {
+		reply := &compressionReply{}
+		require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &compressionQuery{Size: size}, reply))
+		require.Equal(t, size, len(reply.Data))
+	}
+}
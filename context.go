@@ -3,7 +3,9 @@ package onet
 import (
 	"bytes"
 	"encoding/binary"
+	"math/rand"
 	"sync"
+	"time"
 
 	"go.dedis.ch/onet/v4/log"
 	"go.dedis.ch/onet/v4/network"
@@ -32,12 +34,12 @@ func newContext(c *Server, o *Overlay, servID ServiceID, manager *serviceManager
 		bucketName:        []byte(ServiceFactory.Name(servID)),
 		bucketVersionName: []byte(ServiceFactory.Name(servID) + "version"),
 	}
-	err := manager.db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(ctx.bucketName)
+	err := manager.storage.Update(func(tx StorageTx) error {
+		err := tx.CreateBucket(ctx.bucketName)
 		if err != nil {
 			return xerrors.Errorf("creating bucket: %v", err)
 		}
-		_, err = tx.CreateBucketIfNotExists(ctx.bucketVersionName)
+		err = tx.CreateBucket(ctx.bucketVersionName)
 		if err != nil {
 			return xerrors.Errorf("creating bucket: %v", err)
 		}
@@ -65,6 +67,39 @@ func (c *Context) SendRaw(si *network.ServerIdentity, msg interface{}) error {
 	return nil
 }
 
+// SendToRosterSubset sends the message to the members of the roster with the
+// given ids and returns a delivery report per id. See
+// Overlay.SendToRosterSubset for how the message is routed.
+func (c *Context) SendToRosterSubset(ro *Roster, ids []network.ServerIdentityID, msg interface{}) (map[network.ServerIdentityID]error, error) {
+	reports, err := c.overlay.SendToRosterSubset(ro, ids, msg)
+	if err != nil {
+		return nil, xerrors.Errorf("sending to subset: %v", err)
+	}
+	return reports, nil
+}
+
+// Anycast delivers the message to one live member of candidates, preferring
+// the members with the lowest measured latency, and returns the member that
+// handled it. See Overlay.Anycast.
+func (c *Context) Anycast(candidates []*network.ServerIdentity, msg interface{}) (*network.ServerIdentity, error) {
+	si, err := c.overlay.Anycast(candidates, msg)
+	if err != nil {
+		return nil, xerrors.Errorf("anycast: %v", err)
+	}
+	return si, nil
+}
+
+// BroadcastAck sends the message to all the nodes of the tree, of which we
+// must be the root, and returns once quorum of them acknowledged it, with the
+// nodes that didn't.
+func (c *Context) BroadcastAck(tree *Tree, msg interface{}, quorum int, timeout time.Duration) (map[network.ServerIdentityID]error, error) {
+	reports, err := c.overlay.BroadcastAck(tree, msg, quorum, timeout)
+	if err != nil {
+		return reports, xerrors.Errorf("broadcasting: %v", err)
+	}
+	return reports, nil
+}
+
 // ServerIdentity returns this server's identity.
 func (c *Context) ServerIdentity() *network.ServerIdentity {
 	return c.server.ServerIdentity
@@ -80,8 +115,12 @@ func (c *Context) ServiceID() ServiceID {
 	return c.serviceID
 }
 
-// CreateProtocol returns a ProtocolInstance bound to the service.
+// CreateProtocol returns a ProtocolInstance bound to the service. It returns
+// ErrDraining once the server shuts down.
 func (c *Context) CreateProtocol(name string, t *Tree) (ProtocolInstance, error) {
+	if c.server.Draining() {
+		return nil, ErrDraining
+	}
 	pi, err := c.overlay.CreateProtocol(name, t, c.serviceID)
 	if err != nil {
 		return nil, xerrors.Errorf("creating protocol: %v", err)
@@ -140,6 +179,103 @@ func (c *Context) RegisterMessageProxy(m MessageProxy) {
 	c.overlay.RegisterMessageProxy(m)
 }
 
+// Subscribe registers a handler that is called for every message published
+// on the given topic by any member of a roster we are part of. A service can
+// only have one handler per topic: subscribing again replaces it.
+func (c *Context) Subscribe(topic string, h TopicHandler) {
+	c.server.pubSub.subscribe(topic, c.serviceID, h)
+}
+
+// Unsubscribe removes the handler of this service for the given topic.
+func (c *Context) Unsubscribe(topic string) {
+	c.server.pubSub.unsubscribe(topic, c.serviceID)
+}
+
+// Publish disseminates data on the topic to all members of the roster using
+// a tree with DefaultTopicFanout children per node. Every member acknowledges
+// the message to its parent and duplicates are filtered, so subscribers
+// receive each message at least once and usually exactly once.
+func (c *Context) Publish(topic string, ro *Roster, data []byte) (TopicMsgID, error) {
+	return c.PublishWithFanout(topic, ro, DefaultTopicFanout, data)
+}
+
+// PublishWithFanout is like Publish but uses the given number of children
+// per node in the dissemination tree.
+func (c *Context) PublishWithFanout(topic string, ro *Roster, fanout int, data []byte) (TopicMsgID, error) {
+	id, err := c.server.pubSub.publish(topic, ro, fanout, data)
+	if err != nil {
+		return id, xerrors.Errorf("publishing: %v", err)
+	}
+	return id, nil
+}
+
+// OpenStream opens a Stream to the same service on the given server. The
+// service on the other side must accept streams with AcceptStreams.
+func (c *Context) OpenStream(si *network.ServerIdentity) (*Stream, error) {
+	s, err := c.server.streams.openStream(si, ServiceFactory.Name(c.serviceID))
+	if err != nil {
+		return nil, xerrors.Errorf("opening stream: %v", err)
+	}
+	return s, nil
+}
+
+// AcceptStreams registers the handler that is called in a new go-routine
+// for every stream opened to this service by another server.
+func (c *Context) AcceptStreams(h StreamHandler) {
+	m := c.server.streams
+	m.Lock()
+	m.acceptors[ServiceFactory.Name(c.serviceID)] = h
+	m.Unlock()
+}
+
+// DHT returns the key-based routing layer of the server. It is shared by all
+// services and only looks for contacts once one of them called DHT.Join.
+func (c *Context) DHT() *DHT {
+	return c.server.dht
+}
+
+// PeerSampling returns the random sample of the servers kept by gossip. It is
+// shared by all services and only shuffles once one of them called
+// PeerSampling.Join.
+func (c *Context) PeerSampling() *PeerSampling {
+	return c.server.rps
+}
+
+// GetRandomPeers returns up to n servers picked at random from the sample kept
+// by PeerSampling.
+func (c *Context) GetRandomPeers(n int) []*network.ServerIdentity {
+	return c.server.rps.GetRandomPeers(n)
+}
+
+// Watch starts to send heartbeats to the servers, so that Suspected tells
+// whether they failed. The watched servers are shared by all services.
+func (c *Context) Watch(servers ...*network.ServerIdentity) {
+	c.overlay.Watch(servers...)
+}
+
+// Suspected returns whether the failure detector of the overlay suspects that
+// the server failed.
+func (c *Context) Suspected(si *network.ServerIdentity) bool {
+	return c.overlay.Suspected(si)
+}
+
+// OnSuspect registers a function called when a watched server becomes
+// suspected, and when it isn't anymore.
+func (c *Context) OnSuspect(f func(si *network.ServerIdentity, suspected bool)) {
+	c.overlay.OnSuspect(f)
+}
+
+// Clock returns the clock of the server. The services should use it instead
+// of the time package, so that they can run in virtual time.
+func (c *Context) Clock() Clock {
+	return c.server.Clock()
+}
+
+// Rand returns the seeded source of randomness of the server.
+func (c *Context) Rand() *rand.Rand {
+	return c.server.Rand()
+}
+
 // Service returns the corresponding service.
 func (c *Context) Service(name string) Service {
 	return c.manager.service(name)
@@ -172,9 +308,8 @@ func (c *Context) Save(key []byte, data interface{}) error {
 	if err != nil {
 		return xerrors.Errorf("marshaling: %v", err)
 	}
-	err = c.manager.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(c.bucketName)
-		return b.Put(key, buf)
+	err = c.manager.storage.Update(func(tx StorageTx) error {
+		return tx.Put(c.bucketName, key, buf)
 	})
 	if err != nil {
 		return xerrors.Errorf("tx error: %v", err)
@@ -186,10 +321,10 @@ func (c *Context) Save(key []byte, data interface{}) error {
 // Returns a nil value if the key does not exist.
 func (c *Context) Load(key []byte) (interface{}, error) {
 	var buf []byte
-	err := c.manager.db.View(func(tx *bbolt.Tx) error {
-		v := tx.Bucket(c.bucketName).Get(key)
-		if v == nil {
-			return nil
+	err := c.manager.storage.View(func(tx StorageTx) error {
+		v, err := tx.Get(c.bucketName, key)
+		if err != nil || v == nil {
+			return err
 		}
 
 		buf = make([]byte, len(v))
@@ -216,10 +351,10 @@ func (c *Context) Load(key []byte) (interface{}, error) {
 // Returns a nil value if the key does not exist.
 func (c *Context) LoadRaw(key []byte) ([]byte, error) {
 	var buf []byte
-	err := c.manager.db.View(func(tx *bbolt.Tx) error {
-		v := tx.Bucket(c.bucketName).Get(key)
-		if v == nil {
-			return nil
+	err := c.manager.storage.View(func(tx StorageTx) error {
+		v, err := tx.Get(c.bucketName, key)
+		if err != nil || v == nil {
+			return err
 		}
 
 		buf = make([]byte, len(v))
@@ -238,10 +373,10 @@ var dbVersion = []byte("dbVersion")
 // no version has been found.
 func (c *Context) LoadVersion() (int, error) {
 	var buf []byte
-	err := c.manager.db.View(func(tx *bbolt.Tx) error {
-		v := tx.Bucket(c.bucketVersionName).Get(dbVersion)
-		if v == nil {
-			return nil
+	err := c.manager.storage.View(func(tx StorageTx) error {
+		v, err := tx.Get(c.bucketVersionName, dbVersion)
+		if err != nil || v == nil {
+			return err
 		}
 
 		buf = make([]byte, len(v))
@@ -271,9 +406,8 @@ func (c *Context) SaveVersion(version int) error {
 	if err != nil {
 		return xerrors.Errorf("int to bytes: %v", err)
 	}
-	err = c.manager.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(c.bucketVersionName)
-		return b.Put(dbVersion, buf.Bytes())
+	err = c.manager.storage.Update(func(tx StorageTx) error {
+		return tx.Put(c.bucketVersionName, dbVersion, buf.Bytes())
 	})
 	if err != nil {
 		return xerrors.Errorf("tx error: %v")
@@ -281,6 +415,134 @@ func (c *Context) SaveVersion(version int) error {
 	return nil
 }
 
+// View runs f in a read-only transaction on the storage. The transaction
+// only gives access to the buckets of the service: the one of Save and
+// Load, which is BucketName(), and the ones created with CreateBucket.
+func (c *Context) View(f func(tx StorageTx) error) error {
+	return c.manager.storage.View(func(tx StorageTx) error {
+		return f(&serviceTx{tx, c})
+	})
+}
+
+// Update runs f in a read-write transaction on the storage, with the same
+// restrictions as View. Either all the changes made by f are kept, or none
+// of them if f returns an error, even if they span several buckets. Use
+// SaveTx and LoadTx to store messages in the same transaction.
+func (c *Context) Update(f func(tx StorageTx) error) error {
+	return c.manager.storage.Update(func(tx StorageTx) error {
+		return f(&serviceTx{tx, c})
+	})
+}
+
+// BucketName returns the name of the bucket used by Save and Load.
+func (c *Context) BucketName() []byte {
+	return append([]byte{}, c.bucketName...)
+}
+
+// CreateBucket makes sure the bucket with the given name exists and
+// returns its full name, which is the one to use in the transactions of
+// View and Update. Contrary to GetAdditionalBucket, it works with all
+// storages.
+func (c *Context) CreateBucket(name []byte) ([]byte, error) {
+	fullName := append(c.BucketName(), '_')
+	fullName = append(fullName, name...)
+	err := c.manager.storage.Update(func(tx StorageTx) error {
+		return tx.CreateBucket(fullName)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	return fullName, nil
+}
+
+// SaveTx is Save in the transaction tx of Update.
+func (c *Context) SaveTx(tx StorageTx, key []byte, data interface{}) error {
+	buf, err := network.Marshal(data)
+	if err != nil {
+		return xerrors.Errorf("marshaling: %v", err)
+	}
+	return tx.Put(c.bucketName, key, buf)
+}
+
+// LoadTx is Load in the transaction tx of View or Update.
+func (c *Context) LoadTx(tx StorageTx, key []byte) (interface{}, error) {
+	v, err := tx.Get(c.bucketName, key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	// the value is only valid during the transaction
+	buf := append([]byte{}, v...)
+	_, ret, err := network.Unmarshal(buf, c.server.suite)
+	if err != nil {
+		return nil, xerrors.Errorf("unmarshaling: %v", err)
+	}
+	return ret, nil
+}
+
+// serviceTx restricts a transaction to the buckets of a service.
+type serviceTx struct {
+	StorageTx
+	c *Context
+}
+
+func (t *serviceTx) owns(bucket []byte) bool {
+	return bytes.Equal(bucket, t.c.bucketName) ||
+		bytes.Equal(bucket, t.c.bucketVersionName) ||
+		bytes.HasPrefix(bucket, append(t.c.BucketName(), '_'))
+}
+
+func (t *serviceTx) check(bucket []byte) error {
+	if !t.owns(bucket) {
+		return xerrors.New("bucket of another service: " + string(bucket))
+	}
+	return nil
+}
+
+func (t *serviceTx) CreateBucket(name []byte) error {
+	if err := t.check(name); err != nil {
+		return err
+	}
+	return t.StorageTx.CreateBucket(name)
+}
+
+func (t *serviceTx) Get(bucket, key []byte) ([]byte, error) {
+	if !t.owns(bucket) {
+		return nil, nil
+	}
+	return t.StorageTx.Get(bucket, key)
+}
+
+func (t *serviceTx) Put(bucket, key, value []byte) error {
+	if err := t.check(bucket); err != nil {
+		return err
+	}
+	return t.StorageTx.Put(bucket, key, value)
+}
+
+func (t *serviceTx) Delete(bucket, key []byte) error {
+	if err := t.check(bucket); err != nil {
+		return err
+	}
+	return t.StorageTx.Delete(bucket, key)
+}
+
+func (t *serviceTx) ForEach(bucket []byte, f func(k, v []byte) error) error {
+	if err := t.check(bucket); err != nil {
+		return err
+	}
+	return t.StorageTx.ForEach(bucket, f)
+}
+
+func (t *serviceTx) Buckets() [][]byte {
+	var names [][]byte
+	for _, b := range t.StorageTx.Buckets() {
+		if t.owns(b) {
+			names = append(names, b)
+		}
+	}
+	return names
+}
+
 // GetAdditionalBucket makes sure that a bucket with the given name
 // exists, by eventually creating it, and returns the created bucket name,
 // which is the servicename + "_" + the given name.
@@ -288,13 +550,33 @@ func (c *Context) SaveVersion(version int) error {
 // This function should only be used if the Load and Save functions are not sufficient.
 // Additionally, the user should not create buckets directly on the DB but always
 // call this function to create new buckets to avoid bucket name conflicts.
+//
+// It panics if the server doesn't use the bbolt storage, or encrypts it, see
+// AdditionalBucket.
 func (c *Context) GetAdditionalBucket(name []byte) (*bbolt.DB, []byte) {
+	db, fullName, err := c.AdditionalBucket(name)
+	if err != nil {
+		panic(err)
+	}
+	return db, fullName
+}
+
+// AdditionalBucket is GetAdditionalBucket returning an error if the server
+// doesn't use the bbolt storage, or encrypts it, as the bbolt database
+// would bypass the encryption. CreateBucket, with View and Update, works
+// with all the storages.
+func (c *Context) AdditionalBucket(name []byte) (*bbolt.DB, []byte, error) {
 	// make a copy to insure c.bucketName is not written
 	bucketName := make([]byte, len(c.bucketName))
 	copy(bucketName, c.bucketName)
 
 	fullName := append(append(bucketName, byte('_')), name...)
-	err := c.manager.db.Update(func(tx *bbolt.Tx) error {
+	bs, ok := c.manager.storage.(*bboltStorage)
+	if !ok {
+		return nil, nil, xerrors.New("the additional buckets need the unencrypted bbolt storage")
+	}
+	db := bs.sharedDB()
+	err := db.Update(func(tx *bbolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(fullName)
 		if err != nil {
 			return xerrors.Errorf("create bucket: %v", err)
@@ -302,7 +584,7 @@ func (c *Context) GetAdditionalBucket(name []byte) (*bbolt.DB, []byte) {
 		return nil
 	})
 	if err != nil {
-		panic(xerrors.Errorf("tx error: %v", err))
+		return nil, nil, xerrors.Errorf("tx error: %v", err)
 	}
-	return c.manager.db, fullName
+	return db, fullName, nil
 }
@@ -1,6 +1,7 @@
 package onet
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
@@ -115,6 +116,25 @@ func TestContext_GetAdditionalBucket(t *testing.T) {
 	require.Equal(t, "testService_new", string(name))
 }
 
+func TestContext_AdditionalBucket(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	log.ErrFatal(err)
+	defer os.RemoveAll(tmp)
+
+	c := createContext(t, tmp)
+	db, name, err := c.AdditionalBucket([]byte("new"))
+	require.NoError(t, err)
+	require.NotNil(t, db)
+	require.Equal(t, "testService_new", string(name))
+
+	// the encrypted storage has no bbolt database to give
+	c.manager.storage, err = NewEncryptedStorage(c.manager.storage, bytes.Repeat([]byte{1}, StorageKeySize))
+	require.NoError(t, err)
+	_, _, err = c.AdditionalBucket([]byte("new"))
+	require.Error(t, err)
+	require.Panics(t, func() { c.GetAdditionalBucket([]byte("new")) })
+}
+
 func TestContext_Path(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "conode")
 	log.ErrFatal(err)
@@ -178,7 +198,51 @@ func createContext(t *testing.T, dbPath string) *Context {
 		return nil
 	})
 	require.Nil(t, err)
-	sm.db = db
+	sm.storage = &bboltStorage{db: db}
 
 	return newContext(cn, nil, ServiceFactory.ServiceID(name), sm)
 }
+
+func TestContext_Update(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	log.ErrFatal(err)
+	defer os.RemoveAll(tmp)
+	c := createContext(t, tmp)
+	network.RegisterMessage(&ContextData{})
+
+	orders, err := c.CreateBucket([]byte("orders"))
+	require.NoError(t, err)
+	require.Equal(t, "testService_orders", string(orders))
+
+	// a failing transaction keeps nothing
+	err = c.Update(func(tx StorageTx) error {
+		require.NoError(t, tx.Put(orders, []byte("1"), []byte("pending")))
+		require.NoError(t, c.SaveTx(tx, []byte("total"), &ContextData{I: 1}))
+		return xerrors.New("abort")
+	})
+	require.Error(t, err)
+	msg, err := c.Load([]byte("total"))
+	require.NoError(t, err)
+	require.Nil(t, msg)
+
+	require.NoError(t, c.Update(func(tx StorageTx) error {
+		require.NoError(t, tx.Put(orders, []byte("1"), []byte("pending")))
+		return c.SaveTx(tx, []byte("total"), &ContextData{I: 1})
+	}))
+	require.NoError(t, c.View(func(tx StorageTx) error {
+		require.Equal(t, []byte("pending"), getValue(t, tx, orders, []byte("1")))
+		msg, err := c.LoadTx(tx, []byte("total"))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), msg.(*ContextData).I)
+		require.Equal(t, [][]byte{c.BucketName(), orders, []byte("testServiceversion")}, tx.Buckets())
+		return nil
+	}))
+
+	// other buckets are out of reach
+	require.NoError(t, c.Update(func(tx StorageTx) error {
+		require.Error(t, tx.CreateBucket([]byte("otherService")))
+		require.Error(t, tx.Put([]byte("otherService"), []byte("a"), []byte("b")))
+		require.Nil(t, getValue(t, tx, []byte("otherService"), []byte("a")))
+		return nil
+	}))
+}
@@ -0,0 +1,110 @@
+package onet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.dedis.ch/onet/v4/network"
+)
+
+// defaultDedupSize is how many messages a dedup filter remembers when
+// DispatchPolicy.DedupSize is not set.
+const defaultDedupSize = 10000
+
+// dedupBits and dedupHashes give the filters a false positive rate of about
+// 0.01%, counting both generations.
+const (
+	dedupBits   = 20
+	dedupHashes = 14
+)
+
+// dedupFilter is a rotating Bloom filter of the hashes of the messages of a
+// protocol. The messages are added to the current generation and looked for
+// in both, and the previous one is dropped when the current one is full, so
+// that the last size messages at least are remembered with a fixed memory.
+type dedupFilter struct {
+	size     int
+	added    int
+	current  []uint64
+	previous []uint64
+}
+
+func newDedupFilter(size int) *dedupFilter {
+	words := (size*dedupBits + 63) / 64
+	return &dedupFilter{
+		size:     size,
+		current:  make([]uint64, words),
+		previous: make([]uint64, words),
+	}
+}
+
+// seen adds the hash of the message to the filter and returns whether it was
+// probably already there.
+func (f *dedupFilter) seen(sum [sha256.Size]byte) bool {
+	h1 := binary.LittleEndian.Uint64(sum[0:])
+	h2 := binary.LittleEndian.Uint64(sum[8:]) | 1
+	bits := uint64(len(f.current) * 64)
+	inCurrent, inPrevious := true, true
+	for i := uint64(0); i < dedupHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		inCurrent = inCurrent && f.current[word]&mask != 0
+		inPrevious = inPrevious && f.previous[word]&mask != 0
+	}
+	if inCurrent || inPrevious {
+		return true
+	}
+	if f.added >= f.size {
+		f.previous, f.current = f.current, f.previous
+		for i := range f.current {
+			f.current[i] = 0
+		}
+		f.added = 0
+	}
+	for i := uint64(0); i < dedupHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		f.current[bit/64] |= uint64(1) << (bit % 64)
+	}
+	f.added++
+	return false
+}
+
+// dedupSum is the hash of a message for the filters, from its type and its
+// encoding.
+func dedupSum(typ network.MessageTypeID, buf []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(typ[:])
+	h.Write(buf)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// duplicate returns whether the message was already given to the instances
+// of the protocol of tok, for the policies with Dedup. The encoding of the
+// message is only asked for them.
+func (dq *dispatchQueues) duplicate(tok *Token, typ network.MessageTypeID, encoding func() ([]byte, error)) bool {
+	p := dq.policy(tok)
+	if !p.Dedup {
+		return false
+	}
+	buf, err := encoding()
+	if err != nil {
+		return false
+	}
+	sum := dedupSum(typ, buf)
+
+	dq.Lock()
+	defer dq.Unlock()
+	key := dispatchKey{tok.ServiceID, tok.ProtoID}
+	f, ok := dq.filters[key]
+	if !ok || f.size != p.dedupSize() {
+		f = newDedupFilter(p.dedupSize())
+		dq.filters[key] = f
+	}
+	if f.seen(sum) {
+		dq.duplicates++
+		return true
+	}
+	return false
+}
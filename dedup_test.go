@@ -0,0 +1,80 @@
+package onet
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func dedupTestSum(i int) [32]byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(i))
+	return dedupSum(network.MessageTypeID{}, buf[:])
+}
+
+// Tests that the filter remembers at least its size of messages, and forgets
+// the old ones.
+func TestDedupFilter_Rotation(t *testing.T) {
+	f := newDedupFilter(100)
+	for i := 0; i < 100; i++ {
+		require.False(t, f.seen(dedupTestSum(i)))
+	}
+	for i := 0; i < 100; i++ {
+		require.True(t, f.seen(dedupTestSum(i)))
+	}
+	for i := 100; i < 300; i++ {
+		f.seen(dedupTestSum(i))
+	}
+	for i := 200; i < 300; i++ {
+		require.True(t, f.seen(dedupTestSum(i)))
+	}
+	forgotten := 0
+	for i := 0; i < 100; i++ {
+		if !f.seen(dedupTestSum(i)) {
+			forgotten++
+		}
+	}
+	require.True(t, forgotten > 90)
+}
+
+func TestDedupFilter_FalsePositives(t *testing.T) {
+	f := newDedupFilter(10000)
+	for i := 0; i < 10000; i++ {
+		f.seen(dedupTestSum(i))
+	}
+	fp := 0
+	for i := 10000; i < 20000; i++ {
+		if f.seen(dedupTestSum(i)) {
+			fp++
+		}
+	}
+	require.True(t, fp < 10, "%d false positives", fp)
+}
+
+// Tests that only the protocols opting in have their duplicates dropped.
+func TestDispatchQueues_Duplicate(t *testing.T) {
+	dq := newDispatchQueues(1)
+	tok := queueToken(1)
+	tok.ProtoID = ProtocolID{1}
+	other := queueToken(2)
+	other.ProtoID = ProtocolID{2}
+	encoding := func() ([]byte, error) { return []byte("rumor"), nil }
+
+	require.False(t, dq.duplicate(tok, network.MessageTypeID{}, encoding))
+	require.False(t, dq.duplicate(tok, network.MessageTypeID{}, encoding))
+
+	dq.setPolicy(tok.ServiceID, tok.ProtoID, DispatchPolicy{Dedup: true})
+	require.False(t, dq.duplicate(tok, network.MessageTypeID{}, encoding))
+	require.True(t, dq.duplicate(tok, network.MessageTypeID{}, encoding))
+	// an instance of the same protocol
+	again := queueToken(3)
+	again.ProtoID = tok.ProtoID
+	require.True(t, dq.duplicate(again, network.MessageTypeID{}, encoding))
+	require.False(t, dq.duplicate(tok, network.MessageTypeID{1}, encoding))
+	require.False(t, dq.duplicate(other, network.MessageTypeID{}, encoding))
+
+	_, _, duplicates := dq.len()
+	require.Equal(t, uint64(2), duplicates)
+}
@@ -0,0 +1,432 @@
+package onet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// DHTBucketSize is the maximum number of contacts in a bucket of the routing
+// table, and the number of closest nodes a lookup returns.
+const DHTBucketSize = 20
+
+// number of FindNode requests a lookup has in flight at the same time
+const dhtAlpha = 3
+
+// how long to wait for the answer of a FindNode request
+const defaultDHTTimeout = 2 * time.Second
+
+// how often the routing table is refreshed once the node joined
+const dhtRefreshInterval = 10 * time.Minute
+
+// DHTFindNodeMsgID of DHTFindNode message as registered in network
+var DHTFindNodeMsgID = network.RegisterMessage(DHTFindNode{})
+
+// DHTNodesMsgID of DHTNodes message as registered in network
+var DHTNodesMsgID = network.RegisterMessage(DHTNodes{})
+
+// DHTKey is a point in the key space of the DHT. Nodes and keys share the
+// same space, and the node responsible for a key is the one whose key is
+// the closest to it in the XOR metric.
+type DHTKey [sha256.Size]byte
+
+// NewDHTKey returns the key of the given data.
+func NewDHTKey(data []byte) DHTKey {
+	return DHTKey(sha256.Sum256(data))
+}
+
+// DHTNodeKey returns the key of the server in the DHT. It is derived from
+// its public key.
+func DHTNodeKey(si *network.ServerIdentity) DHTKey {
+	buf, err := si.Public.MarshalBinary()
+	if err != nil {
+		log.Error("couldn't marshal public key:", err)
+	}
+	return NewDHTKey(buf)
+}
+
+// xor returns the distance between the two keys.
+func (k DHTKey) xor(o DHTKey) DHTKey {
+	var d DHTKey
+	for i := range k {
+		d[i] = k[i] ^ o[i]
+	}
+	return d
+}
+
+// less returns true if k is smaller than o as a big-endian number.
+func (k DHTKey) less(o DHTKey) bool {
+	for i := range k {
+		if k[i] != o[i] {
+			return k[i] < o[i]
+		}
+	}
+	return false
+}
+
+// prefixLen returns the number of leading zero bits of the key.
+func (k DHTKey) prefixLen() int {
+	for i, b := range k {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(k) * 8
+}
+
+// DHTFindNode asks a node for the contacts it knows that are the closest
+// to the target.
+type DHTFindNode struct {
+	ID     uuid.UUID
+	Target DHTKey
+}
+
+// DHTNodes is the answer to a DHTFindNode.
+type DHTNodes struct {
+	ID    uuid.UUID
+	Nodes []*network.ServerIdentity
+}
+
+// DHT is a Kademlia-like routing layer over the servers. Every server
+// answers the requests of the others, but only starts to look for contacts
+// itself once Join is called.
+//
+// It is only a routing layer: it finds the server responsible for a key,
+// storing values is left to the services.
+type DHT struct {
+	server *Server
+	self   DHTKey
+
+	// buckets[i] holds the contacts whose distance to us has i leading
+	// zero bits, the least recently seen first.
+	buckets     [len(DHTKey{}) * 8][]*network.ServerIdentity
+	bucketsLock sync.Mutex
+
+	pending     map[uuid.UUID]chan []*network.ServerIdentity
+	pendingLock sync.Mutex
+	timeout     time.Duration
+
+	joined  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newDHT(s *Server) *DHT {
+	d := &DHT{
+		server:  s,
+		self:    DHTNodeKey(s.ServerIdentity),
+		pending: make(map[uuid.UUID]chan []*network.ServerIdentity),
+		timeout: defaultDHTTimeout,
+		closing: make(chan struct{}),
+	}
+	s.RegisterProcessor(d, DHTFindNodeMsgID, DHTNodesMsgID)
+	return d
+}
+
+// Join adds the peers to the routing table and looks up our own key, so
+// that the nodes close to us learn about us and we learn about them. The
+// routing table is then refreshed regularly until the server is closed.
+func (d *DHT) Join(peers []*network.ServerIdentity) error {
+	for _, si := range peers {
+		d.update(si)
+	}
+	if len(d.Contacts()) == 0 {
+		return xerrors.New("no peer to join")
+	}
+	if _, err := d.FindClosest(d.self, DHTBucketSize); err != nil {
+		return xerrors.Errorf("looking up ourselves: %v", err)
+	}
+
+	d.pendingLock.Lock()
+	defer d.pendingLock.Unlock()
+	if !d.joined {
+		d.joined = true
+		d.wg.Add(1)
+		go d.maintain()
+	}
+	return nil
+}
+
+// Refresh looks up our own key and a random key, so that contacts that left
+// are removed from the routing table and new ones are added.
+func (d *DHT) Refresh() error {
+	var random DHTKey
+	if _, err := rand.Read(random[:]); err != nil {
+		return xerrors.Errorf("random key: %v", err)
+	}
+	for _, k := range []DHTKey{d.self, random} {
+		if _, err := d.FindClosest(k, DHTBucketSize); err != nil {
+			return xerrors.Errorf("lookup: %v", err)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the server responsible for the key, which might be us.
+func (d *DHT) Lookup(key DHTKey) (*network.ServerIdentity, error) {
+	closest, err := d.FindClosest(key, 1)
+	if err != nil {
+		return nil, xerrors.Errorf("finding closest: %v", err)
+	}
+	own := d.server.ServerIdentity
+	if len(closest) == 0 || d.self.xor(key).less(DHTNodeKey(closest[0]).xor(key)) {
+		return own, nil
+	}
+	return closest[0], nil
+}
+
+// Route sends msg to the server responsible for the key and returns it. If
+// we are responsible, the message is dispatched locally.
+func (d *DHT) Route(key DHTKey, msg network.Message) (*network.ServerIdentity, error) {
+	si, err := d.Lookup(key)
+	if err != nil {
+		return nil, xerrors.Errorf("lookup: %v", err)
+	}
+	if _, err := d.server.Send(si, msg); err != nil {
+		return nil, xerrors.Errorf("sending: %v", err)
+	}
+	return si, nil
+}
+
+// FindClosest runs an iterative lookup and returns up to n of the live
+// nodes closest to the key, closest first. We are never part of the result.
+func (d *DHT) FindClosest(key DHTKey, n int) ([]*network.ServerIdentity, error) {
+	shortlist := d.closest(key, DHTBucketSize)
+	if len(shortlist) == 0 {
+		return nil, xerrors.New("routing table is empty")
+	}
+	own := d.server.ServerIdentity
+	queried := make(map[network.ServerIdentityID]bool)
+	failed := make(map[network.ServerIdentityID]bool)
+
+	for {
+		var batch []*network.ServerIdentity
+		for _, si := range shortlist {
+			if len(batch) == dhtAlpha {
+				break
+			}
+			if !queried[si.ID] {
+				batch = append(batch, si)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		answers := make([][]*network.ServerIdentity, len(batch))
+		var wg sync.WaitGroup
+		for i, si := range batch {
+			queried[si.ID] = true
+			wg.Add(1)
+			go func(i int, si *network.ServerIdentity) {
+				defer wg.Done()
+				nodes, err := d.findNode(si, key)
+				if err != nil {
+					log.Lvl3(own, "find node on", si, "failed:", err)
+					return
+				}
+				answers[i] = nodes
+			}(i, si)
+		}
+		wg.Wait()
+
+		known := make(map[network.ServerIdentityID]bool)
+		for _, si := range shortlist {
+			known[si.ID] = true
+		}
+		for i, nodes := range answers {
+			if nodes == nil {
+				failed[batch[i].ID] = true
+			}
+			for _, si := range nodes {
+				if !known[si.ID] && !si.ID.Equal(own.ID) {
+					known[si.ID] = true
+					shortlist = append(shortlist, si)
+				}
+			}
+		}
+
+		var alive []*network.ServerIdentity
+		for _, si := range shortlist {
+			if !failed[si.ID] {
+				alive = append(alive, si)
+			}
+		}
+		sortByDistance(alive, key)
+		if len(alive) > DHTBucketSize {
+			alive = alive[:DHTBucketSize]
+		}
+		shortlist = alive
+	}
+
+	if len(shortlist) > n {
+		shortlist = shortlist[:n]
+	}
+	return shortlist, nil
+}
+
+// Contacts returns all the contacts of the routing table.
+func (d *DHT) Contacts() []*network.ServerIdentity {
+	d.bucketsLock.Lock()
+	defer d.bucketsLock.Unlock()
+	var list []*network.ServerIdentity
+	for _, b := range d.buckets {
+		list = append(list, b...)
+	}
+	return list
+}
+
+// Process implements the network.Processor interface.
+func (d *DHT) Process(env *network.Envelope) {
+	d.update(env.ServerIdentity)
+	switch msg := env.Msg.(type) {
+	case *DHTFindNode:
+		var nodes []*network.ServerIdentity
+		for _, si := range d.closest(msg.Target, DHTBucketSize+1) {
+			if !si.ID.Equal(env.ServerIdentity.ID) && len(nodes) < DHTBucketSize {
+				nodes = append(nodes, si)
+			}
+		}
+		_, err := d.server.Send(env.ServerIdentity, &DHTNodes{ID: msg.ID, Nodes: nodes})
+		if err != nil {
+			log.Lvl2("dht: couldn't answer", env.ServerIdentity, ":", err)
+		}
+	case *DHTNodes:
+		d.pendingLock.Lock()
+		c, ok := d.pending[msg.ID]
+		delete(d.pending, msg.ID)
+		d.pendingLock.Unlock()
+		if ok {
+			c <- msg.Nodes
+		}
+	default:
+		log.Error("dht: unknown message type", env.MsgType)
+	}
+}
+
+// findNode asks the node for its contacts closest to the key. A node that
+// doesn't answer is removed from the routing table.
+func (d *DHT) findNode(si *network.ServerIdentity, key DHTKey) ([]*network.ServerIdentity, error) {
+	req := &DHTFindNode{ID: uuid.NewV4(), Target: key}
+	c := make(chan []*network.ServerIdentity, 1)
+	d.pendingLock.Lock()
+	d.pending[req.ID] = c
+	d.pendingLock.Unlock()
+	defer func() {
+		d.pendingLock.Lock()
+		delete(d.pending, req.ID)
+		d.pendingLock.Unlock()
+	}()
+
+	var err error
+	if _, err = d.server.Send(si, req); err == nil {
+		select {
+		case nodes := <-c:
+			if nodes == nil {
+				nodes = []*network.ServerIdentity{}
+			}
+			return nodes, nil
+		case <-d.closing:
+			err = xerrors.New("closing")
+		case <-d.server.Clock().After(d.timeout):
+			err = xerrors.New("timeout")
+		}
+	}
+	d.remove(si)
+	return nil, xerrors.Errorf("find node: %v", err)
+}
+
+// update moves the contact to the end of its bucket, or adds it if there
+// is room. As in Kademlia, old contacts are preferred over new ones: a full
+// bucket only gets new contacts once old ones failed.
+func (d *DHT) update(si *network.ServerIdentity) {
+	if si == nil || si.Public == nil || si.ID.Equal(d.server.ServerIdentity.ID) {
+		return
+	}
+	idx := d.bucketIndex(DHTNodeKey(si))
+	d.bucketsLock.Lock()
+	defer d.bucketsLock.Unlock()
+	b := d.buckets[idx]
+	for i, c := range b {
+		if c.ID.Equal(si.ID) {
+			d.buckets[idx] = append(append(b[:i:i], b[i+1:]...), si)
+			return
+		}
+	}
+	if len(b) < DHTBucketSize {
+		d.buckets[idx] = append(b, si)
+	}
+}
+
+// remove deletes the contact from the routing table.
+func (d *DHT) remove(si *network.ServerIdentity) {
+	idx := d.bucketIndex(DHTNodeKey(si))
+	d.bucketsLock.Lock()
+	defer d.bucketsLock.Unlock()
+	b := d.buckets[idx]
+	for i, c := range b {
+		if c.ID.Equal(si.ID) {
+			d.buckets[idx] = append(b[:i:i], b[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *DHT) bucketIndex(k DHTKey) int {
+	idx := d.self.xor(k).prefixLen()
+	if idx == len(d.buckets) {
+		// only our own key, which is never stored
+		idx--
+	}
+	return idx
+}
+
+// closest returns up to n contacts of the routing table closest to the key.
+func (d *DHT) closest(key DHTKey, n int) []*network.ServerIdentity {
+	list := d.Contacts()
+	sortByDistance(list, key)
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func (d *DHT) maintain() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.closing:
+			return
+		case <-d.server.Clock().After(dhtRefreshInterval):
+			if err := d.Refresh(); err != nil {
+				log.Lvl2("dht: refresh failed:", err)
+			}
+		}
+	}
+}
+
+// close stops the maintenance of the routing table and the running lookups.
+func (d *DHT) close() {
+	d.pendingLock.Lock()
+	select {
+	case <-d.closing:
+	default:
+		close(d.closing)
+	}
+	d.pendingLock.Unlock()
+	d.wg.Wait()
+}
+
+func sortByDistance(list []*network.ServerIdentity, key DHTKey) {
+	sort.Slice(list, func(i, j int) bool {
+		return DHTNodeKey(list[i]).xor(key).less(DHTNodeKey(list[j]).xor(key))
+	})
+}
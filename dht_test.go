@@ -0,0 +1,99 @@
+package onet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestDHTKey_Distance(t *testing.T) {
+	a := DHTKey{0x80}
+	b := DHTKey{0x01}
+	require.Equal(t, 0, a.xor(b).prefixLen())
+	require.Equal(t, 7, b.xor(DHTKey{}).prefixLen())
+	require.Equal(t, 256, a.xor(a).prefixLen())
+	require.True(t, b.less(a))
+	require.False(t, a.less(a))
+}
+
+// responsible returns the server whose key is the closest to the key.
+func responsible(servers []*Server, key DHTKey) *network.ServerIdentity {
+	var list []*network.ServerIdentity
+	for _, s := range servers {
+		list = append(list, s.ServerIdentity)
+	}
+	sortByDistance(list, key)
+	return list[0]
+}
+
+func TestDHT_Lookup(t *testing.T) {
+	local, servers, count := setupSubset(t, 16)
+	defer local.CloseAll()
+
+	for _, s := range servers[1:] {
+		require.NoError(t, s.dht.Join([]*network.ServerIdentity{servers[0].ServerIdentity}))
+	}
+	// The nodes that joined first learn about the later ones.
+	for _, s := range servers {
+		require.NoError(t, s.dht.Refresh())
+	}
+
+	for i := 0; i < 10; i++ {
+		key := NewDHTKey([]byte(fmt.Sprintf("key%d", i)))
+		exp := responsible(servers, key)
+		for _, s := range servers {
+			si, err := s.dht.Lookup(key)
+			require.NoError(t, err)
+			require.True(t, exp.Equal(si))
+		}
+	}
+
+	// The message goes straight to the responsible server, and to no other
+	// one. Only messages coming from servers[0] are counted.
+	key := NewDHTKey([]byte("route"))
+	exp := responsible(servers, key)
+	si, err := servers[0].dht.Route(key, &subsetTestMsg{1})
+	require.NoError(t, err)
+	require.True(t, exp.Equal(si))
+	idx := -1
+	for i, s := range servers {
+		if s.ServerIdentity.Equal(exp) {
+			idx = i
+		}
+	}
+	require.NotEqual(t, -1, idx)
+	for i := 0; i < 100 && count(idx) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := range servers {
+		if i == idx {
+			require.Equal(t, 1, count(i))
+		} else {
+			require.Equal(t, 0, count(i))
+		}
+	}
+}
+
+func TestDHT_RemoveDead(t *testing.T) {
+	local, servers, _ := setupSubset(t, 3)
+	defer local.CloseAll()
+	for _, s := range servers {
+		s.dht.timeout = 200 * time.Millisecond
+	}
+
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	d := servers[0].dht
+	d.update(dead)
+	d.update(servers[1].ServerIdentity)
+	require.Len(t, d.Contacts(), 2)
+
+	require.NoError(t, d.Join(nil))
+	contacts := d.Contacts()
+	require.Len(t, contacts, 1)
+	require.True(t, contacts[0].Equal(servers[1].ServerIdentity))
+
+	require.Error(t, servers[2].dht.Join(nil))
+}
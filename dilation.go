@@ -0,0 +1,90 @@
+package onet
+
+import (
+	"sync"
+	"time"
+)
+
+// timeScale stretches the timeouts and intervals of the servers, see
+// SetTimeScale.
+var timeScale = struct {
+	factor float64
+	// the clock of the servers without one of their own
+	clock Clock
+	sync.RWMutex
+}{factor: 1, clock: RealClock}
+
+// SetTimeScale stretches the timeouts and intervals of the servers of the
+// process by the factor, like the ones of the health checks, the anycasts or
+// the DHT, so that they don't expire only because the machine is
+// overloaded, as when many simulation hosts share it. The clock of the
+// servers which don't have one of their own also runs slower by the factor,
+// so that the protocols using it see the stretched time. A factor of 1 is the
+// real time, a factor that is not positive is ignored.
+func SetTimeScale(factor float64) {
+	if factor <= 0 {
+		return
+	}
+	timeScale.Lock()
+	defer timeScale.Unlock()
+	timeScale.factor = factor
+	if factor == 1 {
+		timeScale.clock = RealClock
+	} else {
+		timeScale.clock = NewDilatedClock(RealClock, factor)
+	}
+}
+
+// TimeScale returns the factor given to SetTimeScale.
+func TimeScale() float64 {
+	timeScale.RLock()
+	defer timeScale.RUnlock()
+	return timeScale.factor
+}
+
+// Dilate returns the duration stretched by the factor of SetTimeScale, for the
+// timeouts of the services and protocols that don't use the Clock of their
+// server.
+func Dilate(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * TimeScale())
+}
+
+// dilatedClock is a Clock running slower than another one.
+type dilatedClock struct {
+	clock  Clock
+	factor float64
+	start  time.Time
+}
+
+// NewDilatedClock returns a clock running factor times slower than the given
+// one, starting at its current time: its durations are stretched by the
+// factor.
+func NewDilatedClock(clock Clock, factor float64) Clock {
+	return &dilatedClock{clock: clock, factor: factor, start: clock.Now()}
+}
+
+// stretch returns the duration of the underlying clock for d.
+func (dc *dilatedClock) stretch(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * dc.factor)
+}
+
+// Now implements Clock.
+func (dc *dilatedClock) Now() time.Time {
+	elapsed := dc.clock.Now().Sub(dc.start)
+	return dc.start.Add(time.Duration(float64(elapsed) / dc.factor))
+}
+
+// After implements Clock.
+func (dc *dilatedClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	go func() {
+		<-dc.clock.After(dc.stretch(d))
+		ch <- dc.Now()
+	}()
+	return ch
+}
+
+// Sleep implements Clock.
+func (dc *dilatedClock) Sleep(d time.Duration) {
+	dc.clock.Sleep(dc.stretch(d))
+}
@@ -0,0 +1,52 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDilatedClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := NewVirtualClock(start)
+	dc := NewDilatedClock(vc, 2)
+	require.Equal(t, start, dc.Now())
+	vc.Advance(time.Second)
+	require.Equal(t, start.Add(500*time.Millisecond), dc.Now())
+
+	fired := dc.After(time.Second)
+	for vc.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	vc.Advance(time.Second)
+	select {
+	case <-fired:
+		require.Fail(t, "timer fired too early")
+	case <-time.After(10 * time.Millisecond):
+	}
+	vc.Advance(time.Second)
+	require.Equal(t, start.Add(1500*time.Millisecond), <-fired)
+}
+
+func TestSetTimeScale(t *testing.T) {
+	defer SetTimeScale(1)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	server := local.GenServers(1)[0]
+	require.Equal(t, RealClock, server.Clock())
+
+	SetTimeScale(3)
+	require.Equal(t, 3.0, TimeScale())
+	require.Equal(t, 3*time.Second, Dilate(time.Second))
+	require.NotEqual(t, RealClock, server.Clock())
+	// the time passes slower
+	time.Sleep(10 * time.Millisecond)
+	require.True(t, server.Clock().Now().Before(time.Now()))
+
+	SetTimeScale(0)
+	require.Equal(t, 3.0, TimeScale())
+	SetTimeScale(1)
+	require.Equal(t, time.Second, Dilate(time.Second))
+	require.Equal(t, RealClock, server.Clock())
+}
@@ -0,0 +1,271 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// StorageKeySize is the size of the keys encrypting the storage.
+const StorageKeySize = 32
+
+// encryptedMagic starts every encrypted value
+var encryptedMagic = []byte("oenc")
+
+// the bucket holding the ID of the key that encrypted the storage
+var encryptionBucket = []byte("onet_encryption")
+var encryptionKeyID = []byte("keyID")
+
+const storageKeyIDSize = 4
+
+// DeriveStorageKey returns a key to encrypt the storage that is derived
+// from the private key of the conode.
+func DeriveStorageKey(private kyber.Scalar) ([]byte, error) {
+	buf, err := private.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling: %v", err)
+	}
+	h := sha256.New()
+	h.Write([]byte("onet storage encryption"))
+	h.Write(buf)
+	return h.Sum(nil), nil
+}
+
+type storageKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+func newStorageKey(key []byte) (*storageKey, error) {
+	if len(key) != StorageKeySize {
+		return nil, xerrors.Errorf("key must be %d bytes", StorageKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, xerrors.Errorf("cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, xerrors.Errorf("gcm: %v", err)
+	}
+	h := sha256.Sum256(key)
+	return &storageKey{h[:storageKeyIDSize], aead}, nil
+}
+
+// encryptedStorage encrypts the values stored in another Storage with
+// AES-GCM. The names of the buckets and the keys are not encrypted, so
+// the backends can still look them up and sort them.
+type encryptedStorage struct {
+	Storage
+	key *storageKey
+	// old keys that can still decrypt values
+	old []*storageKey
+}
+
+// NewEncryptedStorage wraps st so that all values are encrypted with key.
+// If the data of st was stored in clear or encrypted with one of the
+// oldKeys, it is re-encrypted with key first, so that the old keys can be
+// dropped afterwards.
+func NewEncryptedStorage(st Storage, key []byte, oldKeys ...[]byte) (Storage, error) {
+	k, err := newStorageKey(key)
+	if err != nil {
+		return nil, err
+	}
+	es := &encryptedStorage{Storage: st, key: k}
+	for _, ok := range oldKeys {
+		k, err := newStorageKey(ok)
+		if err != nil {
+			return nil, xerrors.Errorf("old key: %v", err)
+		}
+		es.old = append(es.old, k)
+	}
+	if err := es.rotate(); err != nil {
+		return nil, xerrors.Errorf("rotating key: %v", err)
+	}
+	return es, nil
+}
+
+// rotate re-encrypts all values if the storage isn't encrypted with the
+// current key yet.
+func (s *encryptedStorage) rotate() error {
+	return s.Storage.Update(func(tx StorageTx) error {
+		id, err := tx.Get(encryptionBucket, encryptionKeyID)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(id, s.key.id) {
+			return nil
+		}
+		var decrypt func(bucket, k, v []byte) ([]byte, error)
+		if id == nil {
+			log.Lvl1("Encrypting the storage")
+			decrypt = func(_, _, v []byte) ([]byte, error) { return v, nil }
+		} else {
+			log.Lvl1("Re-encrypting the storage with a new key")
+			decrypt = s.decrypt
+		}
+		for _, b := range tx.Buckets() {
+			if bytes.Equal(b, encryptionBucket) {
+				continue
+			}
+			var keys, values [][]byte
+			err := tx.ForEach(b, func(k, v []byte) error {
+				if v == nil {
+					// nested bucket of bbolt
+					return nil
+				}
+				plain, err := decrypt(b, k, v)
+				if err != nil {
+					return xerrors.Errorf("%s/%x: %v", b, k, err)
+				}
+				keys = append(keys, append([]byte{}, k...))
+				values = append(values, s.encrypt(b, k, plain))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for i := range keys {
+				if err := tx.Put(b, keys[i], values[i]); err != nil {
+					return xerrors.Errorf("put: %v", err)
+				}
+			}
+		}
+		if err := tx.CreateBucket(encryptionBucket); err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		return tx.Put(encryptionBucket, encryptionKeyID, s.key.id)
+	})
+}
+
+// the value is bound to its place in the storage, so that encrypted values
+// can't be swapped
+func additionalData(bucket, key []byte) []byte {
+	return append(append(append([]byte{}, bucket...), 0), key...)
+}
+
+func (s *encryptedStorage) encrypt(bucket, key, value []byte) []byte {
+	nonce := make([]byte, s.key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("no randomness: " + err.Error())
+	}
+	out := append(append(append([]byte{}, encryptedMagic...), s.key.id...), nonce...)
+	return s.key.aead.Seal(out, nonce, value, additionalData(bucket, key))
+}
+
+func (s *encryptedStorage) decrypt(bucket, key, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, encryptedMagic) {
+		return nil, xerrors.New("value is not encrypted")
+	}
+	value = value[len(encryptedMagic):]
+	if len(value) < storageKeyIDSize {
+		return nil, xerrors.New("value too short")
+	}
+	id := value[:storageKeyIDSize]
+	value = value[storageKeyIDSize:]
+	for _, k := range append([]*storageKey{s.key}, s.old...) {
+		if !bytes.Equal(k.id, id) {
+			continue
+		}
+		ns := k.aead.NonceSize()
+		if len(value) < ns {
+			return nil, xerrors.New("value too short")
+		}
+		plain, err := k.aead.Open(nil, value[:ns], value[ns:], additionalData(bucket, key))
+		if err != nil {
+			return nil, xerrors.Errorf("decrypting: %v", err)
+		}
+		return plain, nil
+	}
+	return nil, xerrors.New("value encrypted with an unknown key")
+}
+
+func (s *encryptedStorage) View(f func(tx StorageTx) error) error {
+	return s.Storage.View(func(tx StorageTx) error {
+		return f(&encryptedTx{tx, s})
+	})
+}
+
+func (s *encryptedStorage) Update(f func(tx StorageTx) error) error {
+	return s.Storage.Update(func(tx StorageTx) error {
+		return f(&encryptedTx{tx, s})
+	})
+}
+
+// GetStatus implements the StatusReporter interface.
+func (s *encryptedStorage) GetStatus() *Status {
+	st := &Status{Field: map[string]string{"Open": "true"}}
+	if sr, ok := s.Storage.(StatusReporter); ok {
+		st = sr.GetStatus()
+	}
+	st.Field["Encrypted"] = "true"
+	return st
+}
+
+// Compact implements the Compacter interface if the encrypted storage does.
+func (s *encryptedStorage) Compact() error {
+	c, ok := s.Storage.(Compacter)
+	if !ok {
+		return xerrors.New("storage can't be compacted")
+	}
+	return c.Compact()
+}
+
+// Sync implements the Syncer interface if the encrypted storage does.
+func (s *encryptedStorage) Sync() error {
+	if sy, ok := s.Storage.(Syncer); ok {
+		return sy.Sync()
+	}
+	return nil
+}
+
+type encryptedTx struct {
+	StorageTx
+	s *encryptedStorage
+}
+
+func (t *encryptedTx) Get(bucket, key []byte) ([]byte, error) {
+	v, err := t.StorageTx.Get(bucket, key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	plain, err := t.s.decrypt(bucket, key, v)
+	if err != nil {
+		return nil, xerrors.Errorf("%s/%x: %v", bucket, key, err)
+	}
+	return plain, nil
+}
+
+func (t *encryptedTx) Put(bucket, key, value []byte) error {
+	return t.StorageTx.Put(bucket, key, t.s.encrypt(bucket, key, value))
+}
+
+func (t *encryptedTx) ForEach(bucket []byte, f func(k, v []byte) error) error {
+	return t.StorageTx.ForEach(bucket, func(k, v []byte) error {
+		if v == nil {
+			return f(k, nil)
+		}
+		plain, err := t.s.decrypt(bucket, k, v)
+		if err != nil {
+			return xerrors.Errorf("%s/%x: %v", bucket, k, err)
+		}
+		return f(k, plain)
+	})
+}
+
+// Buckets hides the bucket of the key ID, which isn't encrypted.
+func (t *encryptedTx) Buckets() [][]byte {
+	var names [][]byte
+	for _, b := range t.StorageTx.Buckets() {
+		if !bytes.Equal(b, encryptionBucket) {
+			names = append(names, b)
+		}
+	}
+	return names
+}
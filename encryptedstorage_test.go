@@ -0,0 +1,86 @@
+package onet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	inner := NewMemoryStorage()
+	bucket := []byte("bucket")
+	secret := []byte("very secret value")
+	require.NoError(t, inner.Update(func(tx StorageTx) error {
+		require.NoError(t, tx.CreateBucket(bucket))
+		return tx.Put(bucket, []byte("plain"), secret)
+	}))
+	rawValue := func(key string) []byte {
+		var v []byte
+		inner.View(func(tx StorageTx) error {
+			v = append([]byte{}, getValue(t, tx, bucket, []byte(key))...)
+			return nil
+		})
+		return v
+	}
+
+	key1 := bytes.Repeat([]byte{1}, StorageKeySize)
+	key2 := bytes.Repeat([]byte{2}, StorageKeySize)
+	_, err := NewEncryptedStorage(inner, key1[:16])
+	require.Error(t, err)
+
+	// existing values are encrypted when the storage is opened
+	st, err := NewEncryptedStorage(inner, key1)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(rawValue("plain"), secret))
+	require.NoError(t, st.Update(func(tx StorageTx) error {
+		require.Equal(t, secret, getValue(t, tx, bucket, []byte("plain")))
+		return tx.Put(bucket, []byte("new"), secret)
+	}))
+	require.False(t, bytes.Contains(rawValue("new"), secret))
+
+	// values can't be moved to another key
+	moved := rawValue("new")
+	require.NoError(t, inner.Update(func(tx StorageTx) error {
+		return tx.Put(bucket, []byte("moved"), moved)
+	}))
+	require.NoError(t, st.View(func(tx StorageTx) error {
+		_, err := tx.Get(bucket, []byte("moved"))
+		require.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, inner.Update(func(tx StorageTx) error {
+		return tx.Delete(bucket, []byte("moved"))
+	}))
+
+	// a wrong key fails, rotating needs the old key
+	_, err = NewEncryptedStorage(inner, key2)
+	require.Error(t, err)
+	old := rawValue("plain")
+	st, err = NewEncryptedStorage(inner, key2, key1)
+	require.NoError(t, err)
+	require.NotEqual(t, old, rawValue("plain"))
+	st, err = NewEncryptedStorage(inner, key2)
+	require.NoError(t, err)
+	require.NoError(t, st.View(func(tx StorageTx) error {
+		var values [][]byte
+		require.NoError(t, tx.ForEach(bucket, func(k, v []byte) error {
+			values = append(values, v)
+			return nil
+		}))
+		require.Equal(t, [][]byte{secret, secret}, values)
+		return nil
+	}))
+	require.Equal(t, "true", st.(StatusReporter).GetStatus().Field["Encrypted"])
+}
+
+func TestDeriveStorageKey(t *testing.T) {
+	kp := key.NewKeyPair(tSuite).Private
+	k1, err := DeriveStorageKey(kp)
+	require.NoError(t, err)
+	k2, err := DeriveStorageKey(kp)
+	require.NoError(t, err)
+	require.Equal(t, k1, k2)
+	require.Len(t, k1, StorageKeySize)
+}
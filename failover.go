@@ -0,0 +1,280 @@
+package onet
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// after this number of failures in a row a node is only tried once the other
+// ones failed, until nodeDownPeriod passed since its last failure
+const nodeDownFailures = 3
+const nodeDownPeriod = 30 * time.Second
+
+// NodeHealth is what a client knows about a node of its roster.
+type NodeHealth struct {
+	// Failures is the number of requests in a row that failed.
+	Failures int
+	// LastFailure is when the last request failed.
+	LastFailure time.Time
+	// Latency is the moving average of the time of the successful requests,
+	// zero if there was none.
+	Latency time.Duration
+}
+
+// Down returns true if the node failed too often lately.
+func (h NodeHealth) Down() bool {
+	return h.Failures >= nodeDownFailures && time.Since(h.LastFailure) < Dilate(nodeDownPeriod)
+}
+
+// BalancePolicy chooses the order in which the nodes of the roster of a
+// client are tried. The nodes that are down are moved at the end of the
+// order by the client.
+type BalancePolicy interface {
+	Order(nodes []*network.ServerIdentity, health func(*network.ServerIdentity) NodeHealth) []*network.ServerIdentity
+}
+
+// RoundRobinPolicy starts each request with the next node of the roster. It
+// is the default policy.
+type RoundRobinPolicy struct {
+	next int
+	sync.Mutex
+}
+
+// Order implements BalancePolicy.
+func (p *RoundRobinPolicy) Order(nodes []*network.ServerIdentity, _ func(*network.ServerIdentity) NodeHealth) []*network.ServerIdentity {
+	p.Lock()
+	start := p.next
+	p.next++
+	p.Unlock()
+	order := make([]*network.ServerIdentity, len(nodes))
+	for i := range nodes {
+		order[i] = nodes[(start+i)%len(nodes)]
+	}
+	return order
+}
+
+// RandomPolicy tries the nodes in a random order.
+type RandomPolicy struct{}
+
+// Order implements BalancePolicy.
+func (RandomPolicy) Order(nodes []*network.ServerIdentity, _ func(*network.ServerIdentity) NodeHealth) []*network.ServerIdentity {
+	order := make([]*network.ServerIdentity, len(nodes))
+	for i, j := range rand.Perm(len(nodes)) {
+		order[i] = nodes[j]
+	}
+	return order
+}
+
+// LatencyPolicy tries the fastest nodes first. The nodes that never replied
+// come first, so that their latency is measured.
+type LatencyPolicy struct{}
+
+// Order implements BalancePolicy.
+func (LatencyPolicy) Order(nodes []*network.ServerIdentity, health func(*network.ServerIdentity) NodeHealth) []*network.ServerIdentity {
+	order := append([]*network.ServerIdentity{}, nodes...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return health(order[i]).Latency < health(order[j]).Latency
+	})
+	return order
+}
+
+// SetRoster sets the nodes used by SendProtobufRoster and
+// SendProtobufHedged.
+func (c *Client) SetRoster(ro *Roster) {
+	c.Lock()
+	defer c.Unlock()
+	c.roster = ro
+}
+
+// SetBalancePolicy sets the order in which the nodes of the roster are
+// tried. If p is nil, RoundRobinPolicy is used.
+func (c *Client) SetBalancePolicy(p BalancePolicy) {
+	c.Lock()
+	defer c.Unlock()
+	c.policy = p
+}
+
+// Health returns what the client knows about the node.
+func (c *Client) Health(si *network.ServerIdentity) NodeHealth {
+	c.Lock()
+	defer c.Unlock()
+	return c.healthLocked(si)
+}
+
+func (c *Client) healthLocked(si *network.ServerIdentity) NodeHealth {
+	if h, ok := c.health[si.ID]; ok {
+		return *h
+	}
+	return NodeHealth{}
+}
+
+// report updates the health of the node after a request.
+func (c *Client) report(si *network.ServerIdentity, start time.Time, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.health == nil {
+		c.health = make(map[network.ServerIdentityID]*NodeHealth)
+	}
+	h, ok := c.health[si.ID]
+	if !ok {
+		h = &NodeHealth{}
+		c.health[si.ID] = h
+	}
+	if err != nil {
+		h.Failures++
+		h.LastFailure = time.Now()
+		return
+	}
+	h.Failures = 0
+	if d := time.Since(start); h.Latency == 0 {
+		h.Latency = d
+	} else {
+		h.Latency = (h.Latency*7 + d) / 8
+	}
+}
+
+// order returns the nodes of the roster as chosen by the policy, with the
+// nodes that are down at the end.
+func (c *Client) order() ([]*network.ServerIdentity, error) {
+	c.Lock()
+	ro, policy := c.roster, c.policy
+	c.Unlock()
+	if ro == nil || len(ro.List) == 0 {
+		return nil, xerrors.New("no roster set")
+	}
+	if policy == nil {
+		c.Lock()
+		if c.policy == nil {
+			c.policy = &RoundRobinPolicy{}
+		}
+		policy = c.policy
+		c.Unlock()
+	}
+	order := policy.Order(ro.List, c.Health)
+	c.Lock()
+	defer c.Unlock()
+	sort.SliceStable(order, func(i, j int) bool {
+		return !c.healthLocked(order[i]).Down() && c.healthLocked(order[j]).Down()
+	})
+	return order, nil
+}
+
+// sendNode sends the encoded message to the node and keeps track of its
+// health.
+func (c *Client) sendNode(si *network.ServerIdentity, path string, buf []byte) ([]byte, error) {
+	start := time.Now()
+	reply, err := c.Send(si, path, buf)
+	c.report(si, start, err)
+	return reply, err
+}
+
+// SendProtobufRoster sends the msg to a node of the roster given to
+// SetRoster, and to the next ones as long as they fail. It returns the node
+// that replied, or the first error if all of them failed.
+func (c *Client) SendProtobufRoster(msg interface{}, ret interface{}) (*network.ServerIdentity, error) {
+	order, err := c.order()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+
+	var firstErr error
+	for _, si := range order {
+		reply, err := c.sendNode(si, path, buf)
+		if err == nil && ret != nil {
+			err = network.Decode(reply, ret, c.suite)
+		}
+		if err == nil {
+			return si, nil
+		}
+		log.Lvl2("Failing over from", si, ":", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, xerrors.Errorf("all nodes failed: %v", firstErr)
+}
+
+// SendProtobufHedged sends the msg to a node of the roster given to
+// SetRoster, and to the next one each time the previous ones didn't reply
+// after delay or failed. It returns the node of the first reply, which is
+// decoded in ret, or the first error if all of them failed. The other
+// requests are not cancelled and their replies are dropped.
+func (c *Client) SendProtobufHedged(msg interface{}, ret interface{}, delay time.Duration) (*network.ServerIdentity, error) {
+	order, err := c.order()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+
+	type result struct {
+		si    *network.ServerIdentity
+		reply []byte
+		err   error
+	}
+	results := make(chan result, len(order))
+	send := func(si *network.ServerIdentity) {
+		reply, err := c.sendNode(si, path, buf)
+		results <- result{si, reply, err}
+	}
+
+	go send(order[0])
+	next, pending := 1, 1
+	var firstErr error
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(order) {
+				log.Lvl3("Hedging request to", order[next])
+				go send(order[next])
+				next++
+				pending++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			err := r.err
+			if err == nil && ret != nil {
+				err = network.Decode(r.reply, ret, c.suite)
+			}
+			if err == nil {
+				return r.si, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if next < len(order) {
+				go send(order[next])
+				next++
+				pending++
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, xerrors.Errorf("all nodes failed: %v", firstErr)
+}
@@ -0,0 +1,99 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+func TestClient_SendProtobufRoster(t *testing.T) {
+	l := NewLocalTest(tSuite)
+	defer l.CloseAll()
+
+	_, roster, _ := l.GenTree(2, false)
+	// nobody listens on the websocket of this node
+	dead := network.NewServerIdentity(tSuite.Point().Pick(tSuite.RandomStream()),
+		network.NewAddress(network.Local, "127.0.0.1:2"))
+	cl := NewClient(tSuite, serviceWebSocket)
+	defer cl.Close()
+
+	_, err := cl.SendProtobufRoster(&SimpleResponse{}, nil)
+	require.Error(t, err)
+
+	cl.SetRoster(NewRoster([]*network.ServerIdentity{dead, roster.List[0], roster.List[1]}))
+	cl.SetBalancePolicy(&RoundRobinPolicy{})
+	reply := &SimpleResponse{}
+	si, err := cl.SendProtobufRoster(&SimpleResponse{Val: 1}, reply)
+	require.NoError(t, err)
+	require.True(t, si.Equal(roster.List[0]))
+	require.Equal(t, int64(2), reply.Val)
+	require.Equal(t, 1, cl.Health(dead).Failures)
+	require.NotEqual(t, time.Duration(0), cl.Health(roster.List[0]).Latency)
+
+	// the node failing too often is tried last
+	for i := 0; i < nodeDownFailures; i++ {
+		cl.report(dead, time.Now(), xerrors.New("down"))
+	}
+	require.True(t, cl.Health(dead).Down())
+	for i := 0; i < 3; i++ {
+		order, err := cl.order()
+		require.NoError(t, err)
+		require.True(t, order[2].Equal(dead))
+	}
+
+	// the second node fails the request
+	_, err = cl.SendProtobufRoster(&ErrorRequest{Roster: *roster, Flags: 2}, nil)
+	require.NoError(t, err)
+	_, err = cl.SendProtobufRoster(&ErrorRequest{Roster: *roster, Flags: 3}, nil)
+	require.Error(t, err)
+}
+
+func TestClient_SendProtobufHedged(t *testing.T) {
+	l := NewLocalTest(tSuite)
+	defer l.CloseAll()
+
+	_, roster, _ := l.GenTree(2, false)
+	dead := network.NewServerIdentity(tSuite.Point().Pick(tSuite.RandomStream()),
+		network.NewAddress(network.Local, "127.0.0.1:2"))
+	cl := NewClient(tSuite, serviceWebSocket)
+	defer cl.Close()
+	cl.SetRoster(NewRoster([]*network.ServerIdentity{dead, roster.List[0], roster.List[1]}))
+	cl.SetBalancePolicy(LatencyPolicy{})
+
+	// a failure starts the next request without waiting
+	start := time.Now()
+	reply := &SimpleResponse{}
+	si, err := cl.SendProtobufHedged(&SimpleResponse{Val: 2}, reply, time.Hour)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < time.Minute)
+	require.False(t, si.Equal(dead))
+	require.Equal(t, int64(3), reply.Val)
+
+	_, err = cl.SendProtobufHedged(&SimpleResponse{}, nil, time.Millisecond)
+	require.NoError(t, err)
+	_, err = cl.SendProtobufHedged(&ErrorRequest{Roster: *roster, Flags: 3}, nil, time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestBalancePolicy(t *testing.T) {
+	nodes := []*network.ServerIdentity{
+		network.NewServerIdentity(tSuite.Point(), network.NewAddress(network.Local, "a:1")),
+		network.NewServerIdentity(tSuite.Point(), network.NewAddress(network.Local, "b:1")),
+	}
+	p := &RoundRobinPolicy{}
+	require.Equal(t, nodes[0], p.Order(nodes, nil)[0])
+	require.Equal(t, nodes[1], p.Order(nodes, nil)[0])
+	require.Equal(t, nodes[0], p.Order(nodes, nil)[0])
+	require.Equal(t, 2, len(RandomPolicy{}.Order(nodes, nil)))
+
+	order := LatencyPolicy{}.Order(nodes, func(si *network.ServerIdentity) NodeHealth {
+		if si == nodes[0] {
+			return NodeHealth{Latency: time.Second}
+		}
+		return NodeHealth{Latency: time.Millisecond}
+	})
+	require.Equal(t, nodes[1], order[0])
+}
@@ -0,0 +1,371 @@
+package onet
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// DefaultHeartbeatInterval is how often the overlay sends a heartbeat to the
+// servers it watches.
+const DefaultHeartbeatInterval = time.Second
+
+// how many intervals between heartbeats a PhiAccrualDetector keeps
+const phiAccrualWindow = 100
+
+// HeartbeatMsgID of Heartbeat message as registered in network
+var HeartbeatMsgID = network.RegisterMessage(Heartbeat{})
+
+// Heartbeat is sent regularly to the watched servers, which answer with a
+// Reply, so that a server only needs to watch another one to get its
+// heartbeats.
+type Heartbeat struct {
+	Reply bool
+	// Sent is when the heartbeat was sent, and Received and Replied when
+	// the reply was received and sent, in nanoseconds since the epoch of
+	// the clocks of the servers, to sample the skew between them.
+	Sent     int64
+	Received int64
+	Replied  int64
+}
+
+// FailureDetector decides whether a server is suspected to have failed from
+// the times its heartbeats were received. It must be safe for concurrent
+// use.
+type FailureDetector interface {
+	// Heartbeat records a heartbeat of the server at the time.
+	Heartbeat(id network.ServerIdentityID, at time.Time)
+	// Suspected returns whether the server is suspected at the time. A
+	// server without heartbeat isn't suspected.
+	Suspected(id network.ServerIdentityID, now time.Time) bool
+	// Forget removes what is known about the server.
+	Forget(id network.ServerIdentityID)
+}
+
+// HeartbeatDetector suspects the servers that didn't send a heartbeat for
+// longer than a timeout.
+type HeartbeatDetector struct {
+	timeout time.Duration
+	last    map[network.ServerIdentityID]time.Time
+	sync.Mutex
+}
+
+// NewHeartbeatDetector returns a HeartbeatDetector with the timeout, which
+// should be a few heartbeat intervals.
+func NewHeartbeatDetector(timeout time.Duration) *HeartbeatDetector {
+	return &HeartbeatDetector{
+		timeout: timeout,
+		last:    make(map[network.ServerIdentityID]time.Time),
+	}
+}
+
+// Heartbeat implements FailureDetector.
+func (d *HeartbeatDetector) Heartbeat(id network.ServerIdentityID, at time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	if at.After(d.last[id]) {
+		d.last[id] = at
+	}
+}
+
+// Suspected implements FailureDetector.
+func (d *HeartbeatDetector) Suspected(id network.ServerIdentityID, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	last, ok := d.last[id]
+	return ok && now.Sub(last) > d.timeout
+}
+
+// Forget implements FailureDetector.
+func (d *HeartbeatDetector) Forget(id network.ServerIdentityID) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.last, id)
+}
+
+// PhiAccrualDetector is the accrual failure detector of Hayashibara et al.:
+// it estimates the distribution of the intervals between the heartbeats of
+// each server as a normal one, and gives the suspicion phi that the next
+// heartbeat won't come, the likelihood of a heartbeat that late being
+// 10^-phi. A server is suspected above the threshold, so that the detector
+// adapts to the jitter of the network instead of using a fixed timeout.
+type PhiAccrualDetector struct {
+	threshold float64
+	interval  time.Duration
+	peers     map[network.ServerIdentityID]*phiHistory
+	sync.Mutex
+}
+
+// phiHistory are the last heartbeat of a server and the intervals before it.
+type phiHistory struct {
+	last      time.Time
+	intervals []time.Duration
+}
+
+// NewPhiAccrualDetector returns a PhiAccrualDetector suspecting the servers
+// above the threshold, usually between 1 and 16. The expected interval
+// between the heartbeats is used until the first ones were measured.
+func NewPhiAccrualDetector(threshold float64, interval time.Duration) *PhiAccrualDetector {
+	return &PhiAccrualDetector{
+		threshold: threshold,
+		interval:  interval,
+		peers:     make(map[network.ServerIdentityID]*phiHistory),
+	}
+}
+
+// Heartbeat implements FailureDetector.
+func (d *PhiAccrualDetector) Heartbeat(id network.ServerIdentityID, at time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	h, ok := d.peers[id]
+	if !ok {
+		d.peers[id] = &phiHistory{last: at, intervals: []time.Duration{d.interval}}
+		return
+	}
+	if !at.After(h.last) {
+		return
+	}
+	h.intervals = append(h.intervals, at.Sub(h.last))
+	if len(h.intervals) > phiAccrualWindow {
+		h.intervals = h.intervals[1:]
+	}
+	h.last = at
+}
+
+// Suspected implements FailureDetector.
+func (d *PhiAccrualDetector) Suspected(id network.ServerIdentityID, now time.Time) bool {
+	return d.Phi(id, now) > d.threshold
+}
+
+// Forget implements FailureDetector.
+func (d *PhiAccrualDetector) Forget(id network.ServerIdentityID) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.peers, id)
+}
+
+// Phi returns the suspicion of the server at the time, zero if it didn't
+// send a heartbeat.
+func (d *PhiAccrualDetector) Phi(id network.ServerIdentityID, now time.Time) float64 {
+	d.Lock()
+	defer d.Unlock()
+	h, ok := d.peers[id]
+	if !ok {
+		return 0
+	}
+	var mean, variance float64
+	for _, i := range h.intervals {
+		mean += float64(i)
+	}
+	mean /= float64(len(h.intervals))
+	for _, i := range h.intervals {
+		variance += (float64(i) - mean) * (float64(i) - mean)
+	}
+	variance /= float64(len(h.intervals))
+	// a regular network would make any delay infinitely suspicious
+	stddev := math.Max(math.Sqrt(variance), mean/10)
+
+	later := 0.5 * math.Erfc((float64(now.Sub(h.last))-mean)/(stddev*math.Sqrt2))
+	if later <= 0 {
+		return math.Inf(1)
+	}
+	return -math.Log10(later)
+}
+
+// failureMonitor sends the heartbeats of the overlay to the watched servers
+// and tells the subscribers when they are suspected or come back.
+type failureMonitor struct {
+	detector  FailureDetector
+	interval  time.Duration
+	watched   map[network.ServerIdentityID]*network.ServerIdentity
+	suspected map[network.ServerIdentityID]bool
+	listeners []func(si *network.ServerIdentity, suspected bool)
+	running   bool
+	closing   chan struct{}
+	wg        sync.WaitGroup
+	sync.Mutex
+}
+
+func newFailureMonitor() *failureMonitor {
+	return &failureMonitor{
+		detector:  NewHeartbeatDetector(3 * DefaultHeartbeatInterval),
+		interval:  DefaultHeartbeatInterval,
+		watched:   make(map[network.ServerIdentityID]*network.ServerIdentity),
+		suspected: make(map[network.ServerIdentityID]bool),
+		closing:   make(chan struct{}),
+	}
+}
+
+// SetFailureDetector replaces the detector deciding which servers are
+// suspected, a HeartbeatDetector with a timeout of three intervals by
+// default. The watched servers start again without suspicion.
+func (o *Overlay) SetFailureDetector(fd FailureDetector) {
+	now := o.server.Clock().Now()
+	m := o.failures
+	m.Lock()
+	defer m.Unlock()
+	m.detector = fd
+	for id := range m.watched {
+		fd.Heartbeat(id, now)
+	}
+}
+
+// FailureDetector returns the detector of the overlay.
+func (o *Overlay) FailureDetector() FailureDetector {
+	o.failures.Lock()
+	defer o.failures.Unlock()
+	return o.failures.detector
+}
+
+// SetHeartbeatInterval sets how often the heartbeats are sent, which should
+// stay below the timeout of the detector.
+func (o *Overlay) SetHeartbeatInterval(d time.Duration) {
+	o.failures.Lock()
+	defer o.failures.Unlock()
+	o.failures.interval = d
+}
+
+// Watch starts to send heartbeats to the servers, so that the detector can
+// tell whether they failed, until Unwatch is called or the overlay closed.
+func (o *Overlay) Watch(servers ...*network.ServerIdentity) {
+	now := o.server.Clock().Now()
+	own := o.ServerIdentity().ID
+	m := o.failures
+	m.Lock()
+	defer m.Unlock()
+	for _, si := range servers {
+		if si.ID.Equal(own) || m.watched[si.ID] != nil {
+			continue
+		}
+		m.watched[si.ID] = si
+		// the time it has to answer starts now
+		m.detector.Heartbeat(si.ID, now)
+	}
+	if !m.running && len(m.watched) > 0 {
+		select {
+		case <-m.closing:
+			return
+		default:
+		}
+		m.running = true
+		m.wg.Add(1)
+		go o.monitorFailures()
+	}
+}
+
+// Unwatch stops to send heartbeats to the servers and forgets them.
+func (o *Overlay) Unwatch(servers ...*network.ServerIdentity) {
+	m := o.failures
+	m.Lock()
+	defer m.Unlock()
+	for _, si := range servers {
+		delete(m.watched, si.ID)
+		delete(m.suspected, si.ID)
+		m.detector.Forget(si.ID)
+		o.clockSkews.forget(si.ID)
+	}
+}
+
+// Suspected returns whether the detector suspects that the server failed.
+// The servers that aren't watched are only suspected if the detector got
+// heartbeats from them some other way.
+func (o *Overlay) Suspected(si *network.ServerIdentity) bool {
+	return o.FailureDetector().Suspected(si.ID, o.server.Clock().Now())
+}
+
+// OnSuspect registers a function called when a watched server becomes
+// suspected, and when it isn't anymore. It is called from the goroutine of
+// the heartbeats, so it should return quickly.
+func (o *Overlay) OnSuspect(f func(si *network.ServerIdentity, suspected bool)) {
+	o.failures.Lock()
+	defer o.failures.Unlock()
+	o.failures.listeners = append(o.failures.listeners, f)
+}
+
+// handleHeartbeat records the heartbeat of the sender, and answers it if it
+// wasn't an answer, or else samples the clock of the sender.
+func (o *Overlay) handleHeartbeat(env *network.Envelope) {
+	now := o.server.Clock().Now()
+	o.FailureDetector().Heartbeat(env.ServerIdentity.ID, now)
+	hb := env.Msg.(*Heartbeat)
+	if hb.Reply {
+		o.failures.Lock()
+		watched := o.failures.watched[env.ServerIdentity.ID] != nil
+		o.failures.Unlock()
+		if watched {
+			o.clockSkews.sample(env.ServerIdentity, hb, now)
+		}
+		return
+	}
+	reply := &Heartbeat{
+		Reply:    true,
+		Sent:     hb.Sent,
+		Received: now.UnixNano(),
+		Replied:  o.server.Clock().Now().UnixNano(),
+	}
+	if _, err := o.server.Send(env.ServerIdentity, reply); err != nil {
+		log.Lvl3(o.ServerIdentity(), "couldn't answer the heartbeat of", env.ServerIdentity, ":", err)
+	}
+}
+
+// monitorFailures sends the heartbeats and checks the watched servers at
+// every interval, until the overlay is closed.
+func (o *Overlay) monitorFailures() {
+	m := o.failures
+	defer m.wg.Done()
+	for {
+		m.Lock()
+		interval := m.interval
+		m.Unlock()
+		select {
+		case <-m.closing:
+			return
+		case <-o.server.Clock().After(interval):
+		}
+
+		now := o.server.Clock().Now()
+		type change struct {
+			si        *network.ServerIdentity
+			suspected bool
+		}
+		var changes []change
+		m.Lock()
+		for id, si := range m.watched {
+			// sending can block as long as the connection is retried
+			go func(si *network.ServerIdentity) {
+				hb := &Heartbeat{Sent: o.server.Clock().Now().UnixNano()}
+				if _, err := o.server.Send(si, hb); err != nil {
+					log.Lvl4(o.ServerIdentity(), "heartbeat to", si, "failed:", err)
+				}
+			}(si)
+			if s := m.detector.Suspected(id, now); s != m.suspected[id] {
+				m.suspected[id] = s
+				changes = append(changes, change{si, s})
+			}
+		}
+		listeners := append([]func(*network.ServerIdentity, bool){}, m.listeners...)
+		m.Unlock()
+
+		for _, c := range changes {
+			log.Lvl2(o.ServerIdentity(), "suspects", c.si, ":", c.suspected)
+			for _, f := range listeners {
+				f(c.si, c.suspected)
+			}
+		}
+	}
+}
+
+// close stops the heartbeats.
+func (m *failureMonitor) close() {
+	m.Lock()
+	select {
+	case <-m.closing:
+	default:
+		close(m.closing)
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
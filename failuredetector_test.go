@@ -0,0 +1,82 @@
+package onet
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+func TestHeartbeatDetector(t *testing.T) {
+	d := NewHeartbeatDetector(time.Second)
+	id := network.ServerIdentityID(uuid.NewV4())
+	start := time.Now()
+	require.False(t, d.Suspected(id, start))
+
+	d.Heartbeat(id, start)
+	require.False(t, d.Suspected(id, start.Add(time.Second)))
+	require.True(t, d.Suspected(id, start.Add(2*time.Second)))
+	// an older heartbeat arriving late doesn't count
+	d.Heartbeat(id, start.Add(-time.Second))
+	require.True(t, d.Suspected(id, start.Add(2*time.Second)))
+
+	d.Forget(id)
+	require.False(t, d.Suspected(id, start.Add(2*time.Second)))
+}
+
+func TestPhiAccrualDetector(t *testing.T) {
+	d := NewPhiAccrualDetector(8, 100*time.Millisecond)
+	id := network.ServerIdentityID(uuid.NewV4())
+	now := time.Now()
+	require.Zero(t, d.Phi(id, now))
+	for i := 0; i < 10; i++ {
+		d.Heartbeat(id, now)
+		now = now.Add(100 * time.Millisecond)
+	}
+	last := now.Add(-100 * time.Millisecond)
+
+	require.InDelta(t, -math.Log10(0.5), d.Phi(id, now), 0.01)
+	require.False(t, d.Suspected(id, now))
+	require.True(t, d.Phi(id, now.Add(20*time.Millisecond)) > d.Phi(id, now))
+	require.True(t, d.Suspected(id, last.Add(300*time.Millisecond)))
+
+	d.Forget(id)
+	require.False(t, d.Suspected(id, now))
+}
+
+func TestOverlay_Watch(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(3, false)
+	o := servers[0].overlay
+	o.SetHeartbeatInterval(50 * time.Millisecond)
+	o.SetFailureDetector(NewHeartbeatDetector(300 * time.Millisecond))
+	changes := make(chan bool, 10)
+	o.OnSuspect(func(si *network.ServerIdentity, suspected bool) {
+		require.True(t, si.Equal(servers[1].ServerIdentity))
+		changes <- suspected
+	})
+	o.Watch(servers[1].ServerIdentity, servers[2].ServerIdentity)
+
+	// the watched servers answer the heartbeats
+	time.Sleep(500 * time.Millisecond)
+	require.False(t, o.Suspected(servers[1].ServerIdentity))
+	require.False(t, o.Suspected(servers[2].ServerIdentity))
+	require.Len(t, changes, 0)
+
+	require.NoError(t, servers[1].Close())
+	select {
+	case suspected := <-changes:
+		require.True(t, suspected)
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "closed server not suspected")
+	}
+	require.True(t, o.Suspected(servers[1].ServerIdentity))
+	require.False(t, o.Suspected(servers[2].ServerIdentity))
+
+	o.Unwatch(servers[1].ServerIdentity)
+	require.False(t, o.Suspected(servers[1].ServerIdentity))
+}
@@ -0,0 +1,42 @@
+package onet
+
+import (
+	"go.dedis.ch/onet/v4/network"
+)
+
+// MessageFaults returns the network.FaultFunc of a server where the faults
+// depend on the type of the messages. For the messages of the protocols, f
+// gets the type of the message inside the ProtocolMsg.
+func MessageFaults(f func(remote *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults) network.FaultFunc {
+	return func(remote *network.ServerIdentity, env *network.Envelope) *network.Faults {
+		msgType := env.MsgType
+		if pm, ok := env.Msg.(*ProtocolMsg); ok {
+			msgType = pm.MsgType
+		}
+		return f(remote, msgType)
+	}
+}
+
+// SetFaults injects faults in the messages between the servers of the test,
+// including the ones created afterwards. f returns the faults of the
+// messages of a type sent from one server to another, or nil. It should be
+// called before the servers talk to each other, as the faults only apply to
+// new connections. A seed other than 0 lets the faults be reproduced.
+func (l *LocalTest) SetFaults(seed int64, f func(from, to *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults) {
+	l.faultSeed = seed
+	l.faults = f
+	for _, srv := range l.Servers {
+		l.setServerFaults(srv)
+	}
+}
+
+func (l *LocalTest) setServerFaults(srv *Server) {
+	if l.faults == nil {
+		return
+	}
+	to := srv.ServerIdentity
+	srv.Router.SetFaults(l.faultSeed, MessageFaults(
+		func(remote *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults {
+			return l.faults(remote, to, msgType)
+		}))
+}
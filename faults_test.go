@@ -0,0 +1,75 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+type faultTestMsg struct {
+	Val int
+}
+
+var faultTestMsgID = network.RegisterMessage(faultTestMsg{})
+
+func TestMessageFaults(t *testing.T) {
+	drop := &network.Faults{Drop: 1}
+	f := MessageFaults(func(remote *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults {
+		if msgType.Equal(faultTestMsgID) {
+			return drop
+		}
+		return nil
+	})
+	require.Equal(t, drop, f(nil, &network.Envelope{MsgType: faultTestMsgID}))
+	require.Equal(t, drop, f(nil, &network.Envelope{MsgType: ProtocolMsgID,
+		Msg: &ProtocolMsg{MsgType: faultTestMsgID}}))
+	require.Nil(t, f(nil, &network.Envelope{MsgType: ProtocolMsgID,
+		Msg: &ProtocolMsg{MsgType: SubsetMsgID}}))
+}
+
+func TestLocalTest_SetFaults(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	// the messages from servers[0] to the server created later are lost
+	local.SetFaults(1, func(from, to *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults {
+		if from.Equal(servers[0].ServerIdentity) && !to.Equal(servers[1].ServerIdentity) &&
+			msgType.Equal(faultTestMsgID) {
+			return &network.Faults{Drop: 1}
+		}
+		return nil
+	})
+	servers = append(servers, local.GenServers(1)...)
+
+	received := make(chan int, 10)
+	for _, s := range servers {
+		s.RegisterProcessorFunc(faultTestMsgID, func(env *network.Envelope) error {
+			received <- env.Msg.(*faultTestMsg).Val
+			return nil
+		})
+	}
+	for i, s := range servers[1:] {
+		_, err := servers[0].Send(s.ServerIdentity, &faultTestMsg{i})
+		require.NoError(t, err)
+	}
+	_, err := servers[1].Send(servers[2].ServerIdentity, &faultTestMsg{10})
+	require.NoError(t, err)
+
+	var vals []int
+	for len(vals) < 2 {
+		select {
+		case v := <-received:
+			vals = append(vals, v)
+		case <-time.After(time.Second):
+			require.Fail(t, "didn't get the messages")
+		}
+	}
+	select {
+	case v := <-received:
+		require.Fail(t, "got a message that should be lost", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.ElementsMatch(t, []int{0, 10}, vals)
+}
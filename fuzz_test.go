@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package onet
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/protobuf"
+)
+
+type fuzzMsg struct {
+	I        int64
+	S        string
+	B        []byte
+	P        kyber.Point
+	M        map[string]int64
+	Children []*fuzzMsg
+}
+
+// FuzzProcessClientRequest decodes the requests the clients send to the
+// websocket.
+func FuzzProcessClientRequest(f *testing.F) {
+	srv := NewLocalServer(tSuite, 2000)
+	f.Cleanup(func() { srv.Close() })
+	p := NewServiceProcessor(&Context{server: srv})
+	if err := p.RegisterHandler(func(m *fuzzMsg) (*fuzzMsg, error) {
+		return m, nil
+	}); err != nil {
+		f.Fatal(err)
+	}
+	seed := &fuzzMsg{I: 1, S: "a", B: []byte{1}, P: tSuite.Point().Base(),
+		M: map[string]int64{"a": 1}, Children: []*fuzzMsg{{I: 2}}}
+	buf, err := protobuf.Encode(seed)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf)
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		r := httptest.NewRequest("GET", "/fuzz/fuzzMsg", nil)
+		p.ProcessClientRequest(r, "fuzzMsg", buf)
+	})
+}
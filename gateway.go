@@ -0,0 +1,193 @@
+package onet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+)
+
+// GatewayAPIVersion is the version in the URLs of the JSON gateway to the
+// handlers of the services.
+const GatewayAPIVersion = 4
+
+// the limit of the size of a JSON request
+const gatewayMaxRequest = 10 * 1024 * 1024
+
+// gatewayHandlers is implemented by the services that can be called through
+// the gateways, which are the ones embedding a ServiceProcessor.
+type gatewayHandlers interface {
+	gatewayHandler(name string) (serviceHandler, bool)
+	gatewayHandlerNames() []string
+}
+
+// gatewayHandler returns the handler of the messages with the given name, if
+// it isn't a streaming one.
+func (p *ServiceProcessor) gatewayHandler(name string) (serviceHandler, bool) {
+	h, ok := p.lookupHandler(name)
+	return h, ok && !h.streaming
+}
+
+// gatewayHandlerNames returns the sorted names of the handlers reachable
+// through the gateways.
+func (p *ServiceProcessor) gatewayHandlerNames() []string {
+	var names []string
+	for name, h := range p.handlers {
+		if !h.streaming {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gatewayError is how a request through a gateway failed, with the status
+// of each kind of gateway.
+type gatewayError struct {
+	status int
+	grpc   int
+	msg    string
+}
+
+// gatewayCall checks the token of the client and decodes the request, which
+// it gives to the service in protobuf through the entry point of the
+// websocket, so that the limits of the server and the ProcessClientRequest
+// of the service apply as well. The deprecation of the handler is added to
+// the headers of the reply, which is returned in protobuf, and as the value
+// returned by the handler.
+func (c *Server) gatewayCall(r *http.Request, header http.Header, service, handler string,
+	decode func(msg interface{}) error) ([]byte, interface{}, *gatewayError) {
+	srv := c.serviceManager.service(service)
+	s, ok := srv.(gatewayHandlers)
+	if !ok {
+		return nil, nil, &gatewayError{http.StatusNotFound, grpcUnimplemented, "unknown service"}
+	}
+	h, ok := s.gatewayHandler(handler)
+	if !ok {
+		return nil, h, &gatewayError{http.StatusNotFound, grpcUnimplemented, "unknown handler"}
+	}
+	if h.deprecation != nil {
+		h.deprecation.setHeaders(header)
+	}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		name, ok := c.verifyToken(strings.TrimPrefix(auth, "Bearer "))
+		if !strings.HasPrefix(auth, "Bearer ") || !ok {
+			return nil, h, &gatewayError{http.StatusUnauthorized, grpcUnauthenticated, "invalid token"}
+		}
+		r = withClientIdentity(r, &ClientIdentity{Name: name})
+	}
+	if h.authenticated && ClientIdentityFromRequest(r) == nil {
+		return nil, h, &gatewayError{http.StatusUnauthorized, grpcUnauthenticated,
+			"authentication required"}
+	}
+
+	msg := reflect.New(h.msgType).Interface()
+	if err := decode(msg); err != nil {
+		return nil, h, &gatewayError{http.StatusBadRequest, grpcInvalidArgument,
+			"decoding error " + err.Error()}
+	}
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, h, &gatewayError{http.StatusBadRequest, grpcInvalidArgument,
+			"encoding error " + err.Error()}
+	}
+	log.Lvlf2("gateway request from %s: %s/%s", r.RemoteAddr, service, handler)
+	r, out := withGatewayReply(r)
+	reply, _, err := c.WebSocket.process(r, srv, handler, buf)
+	switch err {
+	case nil:
+		if *out == nil {
+			// the service answered without its ServiceProcessor
+			if ret := reflect.TypeOf(h.handler).Out(0); ret.Kind() == reflect.Ptr {
+				*out = reflect.New(ret.Elem()).Interface()
+				if err := network.Decode(reply, *out, c.Suite()); err != nil {
+					return nil, nil, &gatewayError{http.StatusInternalServerError, grpcInternal,
+						"decoding error " + err.Error()}
+				}
+			}
+		}
+		return reply, *out, nil
+	case ErrDraining:
+		return nil, h, &gatewayError{http.StatusServiceUnavailable, grpcUnavailable,
+			"server is shutting down"}
+	case errTooManyRequests:
+		return nil, h, &gatewayError{http.StatusTooManyRequests, grpcResourceExhausted, err.Error()}
+	}
+	return nil, h, &gatewayError{http.StatusBadRequest, grpcUnknown, "processing error " + err.Error()}
+}
+
+type gatewayReplyKey struct{}
+
+// withGatewayReply returns the request with the place where
+// ProcessClientRequest keeps the reply of the handler, as the replies that
+// are interfaces can't be decoded from protobuf for the JSON gateway.
+func withGatewayReply(r *http.Request) (*http.Request, *interface{}) {
+	out := new(interface{})
+	return r.WithContext(context.WithValue(r.Context(), gatewayReplyKey{}, out)), out
+}
+
+// keepGatewayReply keeps the reply of the handler if the request comes from
+// a gateway.
+func keepGatewayReply(r *http.Request, reply interface{}) {
+	if r == nil {
+		return
+	}
+	if out, ok := r.Context().Value(gatewayReplyKey{}).(*interface{}); ok {
+		*out = reply
+	}
+}
+
+// serveGateway calls the handlers registered with RegisterHandler and
+// RegisterAuthenticatedHandler on POST /v4/<service>/<handler>, with the
+// request and the reply encoded in JSON. The handler is the name of the
+// struct of the request, as on the websocket. The authenticated handlers
+// need a bearer token given to Server.AddClientToken. The handlers
+// registered with RegisterVersionedHandler are on
+// /v4/<service>/v<version>/<handler>.
+//
+// The fields of the messages that are interfaces, like kyber.Point, can't
+// be decoded from JSON, so the handlers using them are only available on
+// the websocket.
+func (c *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, wrapJSONMsg("only POST is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path,
+		fmt.Sprintf("/v%d/", GatewayAPIVersion)), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		http.Error(w, wrapJSONMsg("path must be /service/handler"), http.StatusNotFound)
+		return
+	}
+	_, out, gerr := c.gatewayCall(r, w.Header(), parts[0], strings.Join(parts[1:], "/"), func(msg interface{}) error {
+		buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, gatewayMaxRequest))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(buf, msg)
+	})
+	if gerr != nil {
+		http.Error(w, wrapJSONMsg(gerr.msg), gerr.status)
+		return
+	}
+	if out == nil {
+		http.Error(w, wrapJSONMsg("the reply can't be decoded"), http.StatusInternalServerError)
+		return
+	}
+	reply, err := json.Marshal(out)
+	if err != nil {
+		http.Error(w, wrapJSONMsg(err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
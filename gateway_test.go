@@ -0,0 +1,137 @@
+package onet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func gatewayPost(t *testing.T, url, token string, body string) *http.Response {
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+const gatewayFilterServiceName = "GatewayFilter"
+
+// gatewayFilterService refuses the requests with a negative value in its
+// ProcessClientRequest.
+type gatewayFilterService struct {
+	*ServiceProcessor
+}
+
+func (s *gatewayFilterService) TestMsg(msg *testMsg) (*testMsg, error) {
+	return msg, nil
+}
+
+func (s *gatewayFilterService) ProcessClientRequest(r *http.Request, path string,
+	buf []byte) ([]byte, *StreamingTunnel, error) {
+	msg := &testMsg{}
+	if err := protobuf.Decode(buf, msg); err != nil || msg.I < 0 {
+		return nil, nil, xerrors.New("refused")
+	}
+	return s.ServiceProcessor.ProcessClientRequest(r, path, buf)
+}
+
+func TestServer_Gateway(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	var srv *clientAuthService
+	RegisterNewService(clientAuthServiceName, func(c *Context) (Service, error) {
+		srv = &clientAuthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterAuthenticatedHandler(srv.Secret)
+	})
+	defer UnregisterService(clientAuthServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.NoError(t, h.AddClientToken("alice", "secret"))
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	addr := "http://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/v4/"
+
+	resp := gatewayPost(t, addr+testServiceName+"/testMsg", "", `{"I": 12}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	msg := testMsg{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
+	resp.Body.Close()
+	require.Equal(t, int64(12), msg.I)
+
+	resp = gatewayPost(t, addr+testServiceName+"/testPanicMsg", "", `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+	resp = gatewayPost(t, addr+testServiceName+"/testMsg", "", `not json`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+	resp = gatewayPost(t, addr+testServiceName+"/unknown", "", `{}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	resp = gatewayPost(t, addr+"unknown/testMsg", "", `{}`)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(addr + testServiceName + "/testMsg")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	// authenticated handlers need a token
+	resp = gatewayPost(t, addr+clientAuthServiceName+"/authzMsg", "", `{"Val": 1}`)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+	resp = gatewayPost(t, addr+clientAuthServiceName+"/authzMsg", "wrong", `{"Val": 1}`)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+	resp = gatewayPost(t, addr+clientAuthServiceName+"/authzMsg", "secret", `{"Val": 1}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reply := authzMsg{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	resp.Body.Close()
+	require.Equal(t, 2, reply.Val)
+	require.Equal(t, "alice", srv.client.Name)
+}
+
+func TestServer_GatewayProcessClientRequest(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	RegisterNewService(gatewayFilterServiceName, func(c *Context) (Service, error) {
+		s := &gatewayFilterService{NewServiceProcessor(c)}
+		return s, s.RegisterHandler(s.TestMsg)
+	})
+	defer UnregisterService(gatewayFilterServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	addr := "http://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/v4/" +
+		gatewayFilterServiceName + "/testMsg"
+
+	resp := gatewayPost(t, addr, "", `{"I": 12}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	msg := testMsg{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&msg))
+	resp.Body.Close()
+	require.Equal(t, int64(12), msg.I)
+
+	// the gateway gives the requests to the ProcessClientRequest of the
+	// service, like the websocket
+	resp = gatewayPost(t, addr, "", `{"I": -1}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,242 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/xerrors"
+)
+
+// GRPCPackage is the package of the gRPC services of onet: the handler of a
+// service is reached on /onet.<service>/<handler>.
+const GRPCPackage = "onet"
+
+// the status codes of gRPC
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcGateway is the listener of the gRPC gateway of a server.
+type grpcGateway struct {
+	srv  *http.Server
+	done chan struct{}
+}
+
+// StartGRPC starts the gRPC gateway to the handlers of the services on the
+// given address, with TLS if tlsConfig isn't nil and with cleartext HTTP/2
+// else. The messages are the protobuf encodings onet uses on the websocket,
+// so the gRPC clients can be generated from the definitions returned by
+// GRPCDefinition. The authenticated handlers need a bearer token in the
+// authorization metadata. It returns the address the gateway listens on,
+// and the gateway is stopped by Server.Close.
+func (c *Server) StartGRPC(address string, tlsConfig *tls.Config) (net.Addr, error) {
+	c.grpcLock.Lock()
+	defer c.grpcLock.Unlock()
+	if c.grpc != nil {
+		return nil, xerrors.New("gRPC gateway already started")
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, xerrors.Errorf("listening: %v", err)
+	}
+	g := &grpcGateway{
+		srv:  &http.Server{Handler: http.HandlerFunc(c.serveGRPC)},
+		done: make(chan struct{}),
+	}
+	if tlsConfig != nil {
+		cfg := tlsConfig.Clone()
+		cfg.NextProtos = []string{"h2"}
+		ln = tls.NewListener(ln, cfg)
+	} else {
+		// the gRPC clients speak HTTP/2 without the upgrade of HTTP/1.1
+		g.srv.Handler = h2c.NewHandler(g.srv.Handler, &http2.Server{})
+	}
+	go func() {
+		defer close(g.done)
+		if err := g.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error("gRPC gateway:", err)
+		}
+	}()
+	c.grpc = g
+	log.Lvl2("gRPC gateway listening on", ln.Addr())
+	return ln.Addr(), nil
+}
+
+func (c *Server) stopGRPC() {
+	c.grpcLock.Lock()
+	defer c.grpcLock.Unlock()
+	if c.grpc != nil {
+		c.grpc.srv.Close()
+		<-c.grpc.done
+		c.grpc = nil
+	}
+}
+
+// serveGRPC handles the unary calls of the gRPC clients.
+func (c *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost ||
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "only gRPC is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	// the trailers are declared before the reply, as the HTTP/2 server of
+	// x/net only sends the undeclared ones after a body
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	reply, code, msg := c.grpcCall(w, r)
+	if reply != nil {
+		w.Write(reply)
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// grpcCall returns the framed reply, or the status of the failure.
+func (c *Server) grpcCall(w http.ResponseWriter, r *http.Request) ([]byte, int, string) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], GRPCPackage+".") {
+		return nil, grpcUnimplemented, "unknown method"
+	}
+	service := strings.TrimPrefix(parts[0], GRPCPackage+".")
+
+	buf, _, gerr := c.gatewayCall(r, w.Header(), service, parts[1], func(msg interface{}) error {
+		var header [5]byte
+		if _, err := io.ReadFull(r.Body, header[:]); err != nil {
+			return xerrors.Errorf("reading header: %v", err)
+		}
+		if header[0] != 0 {
+			return xerrors.New("compression is not supported")
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > gatewayMaxRequest {
+			return xerrors.New("message too big")
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r.Body, buf); err != nil {
+			return xerrors.Errorf("reading message: %v", err)
+		}
+		return network.Decode(buf, msg, c.Suite())
+	})
+	if gerr != nil {
+		return nil, gerr.grpc, gerr.msg
+	}
+	reply := make([]byte, 5, 5+len(buf))
+	binary.BigEndian.PutUint32(reply[1:], uint32(len(buf)))
+	return append(reply, buf...), grpcOK, ""
+}
+
+// GRPCDefinition returns the protobuf definition of the gRPC service of the
+// given onet service, with the messages of its handlers. The handlers that
+// reply with an interface, or whose messages have fields that can't be
+// described, are left out, as well as the versions of the handlers
+// registered with RegisterVersionedHandler.
+func (c *Server) GRPCDefinition(service string) (string, error) {
+	s, ok := c.serviceManager.service(service).(gatewayHandlers)
+	if !ok {
+		return "", xerrors.New("unknown service: " + service)
+	}
+
+	var rpcs []string
+	types := make(map[reflect.Type]bool)
+	for _, name := range s.gatewayHandlerNames() {
+		if strings.Contains(name, "/") {
+			continue
+		}
+		h, _ := s.gatewayHandler(name)
+		ht := reflect.TypeOf(h.handler)
+		out := ht.Out(0)
+		if out.Kind() != reflect.Ptr {
+			continue
+		}
+		ts := protoTypes(out.Elem(), nil)
+		if ts != nil {
+			ts = protoTypes(h.msgType, ts)
+		}
+		if ts == nil {
+			continue
+		}
+		var list []interface{}
+		for t := range ts {
+			list = append(list, reflect.New(t).Interface())
+		}
+		if err := protobuf.GenerateProtobufDefinition(ioutil.Discard, list, nil, nil); err != nil {
+			log.Lvl3("Not describing", service, name, ":", err)
+			continue
+		}
+		for t := range ts {
+			types[t] = true
+		}
+		rpcs = append(rpcs, fmt.Sprintf("  rpc %s (%s) returns (%s);",
+			name, h.msgType.Name(), out.Elem().Name()))
+	}
+
+	var list []interface{}
+	for t := range types {
+		list = append(list, reflect.New(t).Interface())
+	}
+	def := &bytes.Buffer{}
+	fmt.Fprintf(def, "syntax = \"proto2\";\n\npackage %s;\n", GRPCPackage)
+	if err := protobuf.GenerateProtobufDefinition(def, list, nil, nil); err != nil {
+		return "", xerrors.Errorf("generating messages: %v", err)
+	}
+	fmt.Fprintf(def, "\nservice %s {\n%s\n}\n", service, strings.Join(rpcs, "\n"))
+	return def.String(), nil
+}
+
+// protoTypes adds t and the structs of its fields to types. It returns nil
+// if a field is an interface, as its messages can't be described.
+func protoTypes(t reflect.Type, types map[reflect.Type]bool) map[reflect.Type]bool {
+	if types == nil {
+		types = make(map[reflect.Type]bool)
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if t.Elem().Kind() == reflect.Uint8 {
+			return types
+		}
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil
+	case reflect.Struct:
+	default:
+		return types
+	}
+	if types[t] {
+		return types
+	}
+	types[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		if types = protoTypes(t.Field(i).Type, types); types == nil {
+			return nil
+		}
+	}
+	return types
+}
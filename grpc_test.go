@@ -0,0 +1,103 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/net/http2"
+)
+
+// grpcInvoke does a unary gRPC call with cleartext HTTP/2 and returns the
+// reply and the status.
+func grpcInvoke(t *testing.T, client *http.Client, url, token string, msg interface{}) ([]byte, string) {
+	buf, err := protobuf.Encode(msg)
+	require.NoError(t, err)
+	body := make([]byte, 5, 5+len(buf))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(buf)))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(append(body, buf...)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reply, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if len(reply) >= 5 {
+		require.Equal(t, int(binary.BigEndian.Uint32(reply[1:5])), len(reply)-5)
+		reply = reply[5:]
+	}
+	return reply, resp.Trailer.Get("Grpc-Status")
+}
+
+func TestServer_GRPC(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	var srv *clientAuthService
+	RegisterNewService(clientAuthServiceName, func(c *Context) (Service, error) {
+		srv = &clientAuthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterAuthenticatedHandler(srv.Secret)
+	})
+	defer UnregisterService(clientAuthServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.NoError(t, h.AddClientToken("alice", "secret"))
+	addr, err := h.StartGRPC("127.0.0.1:0", nil)
+	require.NoError(t, err)
+	_, err = h.StartGRPC("127.0.0.1:0", nil)
+	require.Error(t, err)
+
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+	url := "http://" + addr.String() + "/onet."
+
+	reply, status := grpcInvoke(t, client, url+testServiceName+"/testMsg", "", &testMsg{I: 7})
+	require.Equal(t, "0", status)
+	msg := &testMsg{}
+	require.NoError(t, protobuf.Decode(reply, msg))
+	require.Equal(t, int64(7), msg.I)
+
+	_, status = grpcInvoke(t, client, url+testServiceName+"/unknown", "", &testMsg{})
+	require.Equal(t, "12", status)
+	_, status = grpcInvoke(t, client, url+testServiceName+"/testPanicMsg", "", &testPanicMsg{})
+	require.Equal(t, "2", status)
+
+	_, status = grpcInvoke(t, client, url+clientAuthServiceName+"/authzMsg", "", &authzMsg{Val: 1})
+	require.Equal(t, "16", status)
+	reply, status = grpcInvoke(t, client, url+clientAuthServiceName+"/authzMsg", "secret", &authzMsg{Val: 1})
+	require.Equal(t, "0", status)
+	am := &authzMsg{}
+	require.NoError(t, protobuf.Decode(reply, am))
+	require.Equal(t, 2, am.Val)
+
+	def, err := h.GRPCDefinition(clientAuthServiceName)
+	require.NoError(t, err)
+	require.Contains(t, def, "package onet;")
+	require.Contains(t, def, "message authzMsg {")
+	require.Contains(t, def, "rpc authzMsg (authzMsg) returns (authzMsg);")
+	// the handlers of testService reply with interfaces
+	def, err = h.GRPCDefinition(testServiceName)
+	require.NoError(t, err)
+	require.NotContains(t, def, "rpc")
+	_, err = h.GRPCDefinition("unknown")
+	require.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// how long a HealthCheck may take before the service is reported unhealthy
+const healthCheckTimeout = 5 * time.Second
+
+// HealthChecker can be implemented by a service to take part in the health
+// of the server, as reported by /healthz and /readyz on the websocket port.
+type HealthChecker interface {
+	// HealthCheck returns an error if the service can't do its work. It
+	// must return quickly, as it is called by the probes of the load
+	// balancers.
+	HealthCheck() error
+}
+
+// HealthReport is the reply of /healthz and /readyz.
+type HealthReport struct {
+	// Status is "ok" or "failing".
+	Status string `json:"status"`
+	// Phase is the lifecycle phase of the server, like "ready" or
+	// "draining".
+	Phase string `json:"phase"`
+	// Services are the results of the HealthChecks, "ok" or the error.
+	Services map[string]string `json:"services,omitempty"`
+}
+
+// the names of the phases in the HealthReport
+var phaseNames = map[int]string{
+	phaseCreated:  "created",
+	phaseStarted:  "started",
+	phaseReady:    "ready",
+	phaseDraining: "draining",
+	phaseStopped:  "stopped",
+}
+
+// HealthCheck calls the HealthCheck of the services implementing
+// HealthChecker in parallel, and returns the errors by name of service.
+func (c *Server) HealthCheck() map[string]error {
+	c.serviceManager.servicesMutex.Lock()
+	checkers := make(map[string]HealthChecker)
+	for id, s := range c.serviceManager.services {
+		if hc, ok := s.(HealthChecker); ok {
+			checkers[ServiceFactory.Name(id)] = hc
+		}
+	}
+	c.serviceManager.servicesMutex.Unlock()
+
+	results := make(map[string]error)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, hc := range checkers {
+		wg.Add(1)
+		go func(name string, hc HealthChecker) {
+			defer wg.Done()
+			done := make(chan error, 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						done <- xerrors.Errorf("panic with %v", r)
+					}
+				}()
+				done <- hc.HealthCheck()
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-c.Clock().After(healthCheckTimeout):
+				err = xerrors.New("health check timed out")
+			}
+			lock.Lock()
+			results[name] = err
+			lock.Unlock()
+		}(name, hc)
+	}
+	wg.Wait()
+	return results
+}
+
+// healthReport runs the health checks, and returns the report and whether
+// they all passed.
+func (c *Server) healthReport() (*HealthReport, bool) {
+	c.phaseLock.Lock()
+	phase := c.phase
+	c.phaseLock.Unlock()
+
+	report := &HealthReport{Status: "ok", Phase: phaseNames[phase],
+		Services: make(map[string]string)}
+	healthy := phase < phaseStopped
+	results := c.HealthCheck()
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			report.Services[name] = err.Error()
+			healthy = false
+		} else {
+			report.Services[name] = "ok"
+		}
+	}
+	if !healthy {
+		report.Status = "failing"
+	}
+	return report, healthy
+}
+
+func writeHealthReport(w http.ResponseWriter, report *HealthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ok {
+		report.Status = "failing"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// serveHealthz is the liveness probe: it fails if a service is unhealthy,
+// but not while the server starts or drains.
+func (c *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	report, ok := c.healthReport()
+	writeHealthReport(w, report, ok)
+}
+
+// serveReadyz is the readiness probe: it only passes once the server is
+// ready and until it drains, so that the load balancers don't send clients
+// to a server that can't answer them.
+func (c *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	report, ok := c.healthReport()
+	c.phaseLock.Lock()
+	ready := c.phase == phaseReady
+	c.phaseLock.Unlock()
+	writeHealthReport(w, report, ok && ready)
+}
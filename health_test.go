@@ -0,0 +1,80 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+const healthServiceName = "healthService"
+
+type healthService struct {
+	*ServiceProcessor
+	failing int32
+}
+
+func (s *healthService) HealthCheck() error {
+	if atomic.LoadInt32(&s.failing) != 0 {
+		return xerrors.New("database is gone")
+	}
+	return nil
+}
+
+func TestServer_Health(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	var srv *healthService
+	RegisterNewService(healthServiceName, func(c *Context) (Service, error) {
+		srv = &healthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, nil
+	})
+	defer UnregisterService(healthServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	addr := "http://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1)
+	probe := func(path string) (int, HealthReport) {
+		resp, err := http.Get(addr + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var report HealthReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	code, report := probe("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ok", report.Status)
+	require.Equal(t, "ready", report.Phase)
+	require.Equal(t, "ok", report.Services[healthServiceName])
+	code, _ = probe("/readyz")
+	require.Equal(t, http.StatusOK, code)
+
+	atomic.StoreInt32(&srv.failing, 1)
+	require.Error(t, h.HealthCheck()[healthServiceName])
+	code, report = probe("/healthz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "failing", report.Status)
+	require.Equal(t, "database is gone", report.Services[healthServiceName])
+	code, _ = probe("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+
+	// a draining server is alive, but not ready
+	atomic.StoreInt32(&srv.failing, 0)
+	h.drain(0)
+	code, report = probe("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "draining", report.Phase)
+	code, _ = probe("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,143 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// the scheduler of a LocalTest delivers the next message once the servers
+// didn't send anything during the settle time
+const schedulerSettle = 10 * time.Millisecond
+
+// SetScheduler makes the local connections of the test deliver the messages
+// one at a time, in the order chosen by the policy, so that the protocols
+// can be tested with the orders that are unlikely with the real network.
+// With a VirtualClock, the clock is advanced to the next timer when no
+// message is waiting. It must be called before the servers are created, and
+// doesn't work with NewTCPTest.
+func (l *LocalTest) SetScheduler(policy network.SchedulePolicy) error {
+	l.panicClosed()
+	if l.mode != Local {
+		return xerrors.New("the scheduler needs local connections")
+	}
+	if len(l.Servers) > 0 || l.scheduler != nil {
+		return xerrors.New("the scheduler must be set before the servers are created")
+	}
+	l.scheduler = network.NewSchedulerWithPolicy(policy, schedulerSettle)
+	l.setSchedulerIdle()
+	l.ctx.SetScheduler(l.scheduler)
+	return nil
+}
+
+// setSchedulerIdle advances the virtual clock of the test, if any, when the
+// scheduler has nothing to deliver.
+func (l *LocalTest) setSchedulerIdle() {
+	if l.scheduler == nil {
+		return
+	}
+	if vc, ok := l.clock.(*VirtualClock); ok {
+		l.scheduler.SetIdle(vc.AdvanceToNext)
+	} else {
+		l.scheduler.SetIdle(nil)
+	}
+}
+
+// ExploreInterleavings runs the test once for every order in which the
+// messages can be delivered at the first bound times that more than one
+// message is waiting, after which the messages are delivered in a fixed
+// order. Every run gets a new LocalTest, with a scheduler, that is closed
+// once the test returns. The number of runs grows exponentially with the
+// bound. It stops at the first run that fails, and logs the choices that
+// ReplayInterleaving takes to run it again. It returns the number of runs.
+func ExploreInterleavings(t *testing.T, s network.Suite, bound int, test func(l *LocalTest)) int {
+	in := &interleavings{bound: bound}
+	runs := 0
+	for {
+		runs++
+		ok := func() bool {
+			l := NewLocalTestT(s, t)
+			if err := l.SetScheduler(in.choose); err != nil {
+				t.Fatal(err)
+			}
+			defer l.CloseAll()
+			defer func() {
+				if t.Failed() {
+					t.Logf("failed with the interleaving %v", in.current())
+				}
+			}()
+			test(l)
+			return !t.Failed()
+		}()
+		if !ok || !in.advance() {
+			return runs
+		}
+	}
+}
+
+// ReplayInterleaving returns the policy delivering the messages with the
+// choices logged by ExploreInterleavings.
+func ReplayInterleaving(choices []int) network.SchedulePolicy {
+	in := &interleavings{bound: len(choices), prefix: choices}
+	return in.choose
+}
+
+// interleavings runs through the choices of the messages in depth-first
+// order.
+type interleavings struct {
+	bound int
+	// the choices the run starts with
+	prefix []int
+	// the choices of the run, and how many messages there were to choose
+	// from
+	choices []int
+	options []int
+	sync.Mutex
+}
+
+func (in *interleavings) choose(msgs []network.ScheduledMessage) int {
+	if len(msgs) < 2 {
+		return 0
+	}
+	in.Lock()
+	defer in.Unlock()
+	k := len(in.choices)
+	if k >= in.bound {
+		return 0
+	}
+	c := 0
+	if k < len(in.prefix) {
+		c = in.prefix[k]
+		if c >= len(msgs) {
+			c = len(msgs) - 1
+		}
+	}
+	in.choices = append(in.choices, c)
+	in.options = append(in.options, len(msgs))
+	return c
+}
+
+func (in *interleavings) current() []int {
+	in.Lock()
+	defer in.Unlock()
+	return append([]int{}, in.choices...)
+}
+
+// advance prepares the next run by taking the next message at the last
+// choice which has some left. It returns false once all the choices are
+// done.
+func (in *interleavings) advance() bool {
+	in.Lock()
+	defer in.Unlock()
+	for i := len(in.choices) - 1; i >= 0; i-- {
+		if in.choices[i]+1 < in.options[i] {
+			in.prefix = append(in.choices[:i:i], in.choices[i]+1)
+			in.choices, in.options = nil, nil
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package onet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestInterleavings(t *testing.T) {
+	msgs := func(n int) []network.ScheduledMessage {
+		return make([]network.ScheduledMessage, n)
+	}
+	in := &interleavings{bound: 2}
+	var runs [][]int
+	for {
+		// the single messages are no choice, and the bound stops the
+		// exploration after two choices
+		run := []int{in.choose(msgs(2)), in.choose(msgs(1)), in.choose(msgs(3)),
+			in.choose(msgs(2))}
+		runs = append(runs, run)
+		if !in.advance() {
+			break
+		}
+	}
+	require.Equal(t, [][]int{
+		{0, 0, 0, 0}, {0, 0, 1, 0}, {0, 0, 2, 0},
+		{1, 0, 0, 0}, {1, 0, 1, 0}, {1, 0, 2, 0},
+	}, runs)
+
+	replay := ReplayInterleaving([]int{1, 5})
+	require.Equal(t, 1, replay(msgs(2)))
+	require.Equal(t, 2, replay(msgs(3)))
+	require.Equal(t, 0, replay(msgs(3)))
+}
+
+func TestLocalTest_SetScheduler(t *testing.T) {
+	tcp := NewTCPTest(tSuite)
+	require.Error(t, tcp.SetScheduler(network.RandomPolicy(1)))
+	tcp.CloseAll()
+
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	require.NoError(t, local.SetScheduler(network.RandomPolicy(1)))
+	require.Error(t, local.SetScheduler(network.RandomPolicy(1)))
+	local.GenServers(2)
+}
+
+func TestExploreInterleavings(t *testing.T) {
+	orders := make(map[string]bool)
+	runs := ExploreInterleavings(t, tSuite, 3, func(l *LocalTest) {
+		servers := l.GenServers(3)
+		received := make(chan int, 10)
+		servers[0].RegisterProcessorFunc(partitionTestMsgID, func(env *network.Envelope) error {
+			received <- env.Msg.(*partitionTestMsg).From
+			return nil
+		})
+		for i := 1; i < 3; i++ {
+			_, err := servers[i].Send(servers[0].ServerIdentity, &partitionTestMsg{i})
+			require.NoError(t, err)
+		}
+		var order []int
+		for len(order) < 2 {
+			select {
+			case from := <-received:
+				order = append(order, from)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "message lost")
+			}
+		}
+		orders[fmt.Sprint(order)] = true
+	})
+	require.True(t, runs > 1)
+	require.Equal(t, map[string]bool{"[1 2]": true, "[2 1]": true}, orders)
+}
@@ -0,0 +1,272 @@
+// Package interop checks that the conodes built with this module can still
+// be mixed with the ones of Upstream: that the messages they both know keep
+// their wire format, that a conode accepts the handshake of an upstream
+// conode, and that its websocket answers the requests of an upstream client.
+// The differences that upstream conodes ignore, like the added fields or the
+// announcement of the capabilities, are reported as notes.
+package interop
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// Result is the outcome of a check.
+type Result struct {
+	Name string
+	// Err is why the check failed, nil if it passed.
+	Err error
+	// Notes are the differences with Upstream that don't keep the conodes
+	// from talking.
+	Notes []string
+}
+
+func (r *Result) note(format string, a ...interface{}) {
+	r.Notes = append(r.Notes, fmt.Sprintf(format, a...))
+}
+
+// Report holds the results of the checks of Run.
+type Report struct {
+	Results []*Result
+}
+
+// OK returns whether all the checks passed.
+func (r *Report) OK() bool {
+	for _, res := range r.Results {
+		if res.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns one line per check, followed by its notes.
+func (r *Report) String() string {
+	var b strings.Builder
+	for _, res := range r.Results {
+		if res.Err != nil {
+			fmt.Fprintf(&b, "%s: failed: %v\n", res.Name, res.Err)
+		} else {
+			fmt.Fprintf(&b, "%s: ok\n", res.Name)
+		}
+		for _, n := range res.Notes {
+			fmt.Fprintf(&b, "  %s\n", n)
+		}
+	}
+	if r.OK() {
+		fmt.Fprintf(&b, "interoperable with %s\n", Upstream)
+	} else {
+		fmt.Fprintf(&b, "not interoperable with %s\n", Upstream)
+	}
+	return b.String()
+}
+
+// Options are the conode Run checks.
+type Options struct {
+	// Address is the address of the conode, whose handshake is checked if
+	// it is set.
+	Address network.Address
+	// URL is the websocket of the conode, at the port after the one of
+	// Address if it is empty.
+	URL string
+	// Path is the request sent to the websocket, as "Service/Message". The
+	// websocket isn't checked if it is empty.
+	Path string
+	// Timeout is how long every check waits for the conode, 10 seconds by
+	// default.
+	Timeout time.Duration
+}
+
+// Run checks the wire format of this binary, and then the conode of the
+// options.
+func Run(opts Options) *Report {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	r := &Report{Results: []*Result{CheckWire()}}
+	if opts.Address != "" {
+		r.Results = append(r.Results, CheckHandshake(opts.Address, opts.Timeout))
+	}
+	if opts.Path != "" {
+		url := opts.URL
+		if url == "" && opts.Address != "" {
+			p, err := strconv.Atoi(opts.Address.Port())
+			if err == nil {
+				url = fmt.Sprintf("http://%s:%d", opts.Address.Host(), p+1)
+			}
+		}
+		r.Results = append(r.Results, CheckWebSocket(url, opts.Path, opts.Timeout))
+	}
+	return r
+}
+
+// CheckWire compares the messages registered in this binary with the ones of
+// UpstreamSchema: the upstream messages must keep their IDs, and their fields
+// their protobuf IDs and types. The fields added to them are noted.
+func CheckWire() *Result {
+	r := &Result{Name: "wire"}
+	// the messages of onet are registered by its import
+	_ = onet.ProtocolMsgID
+	upstream := UpstreamSchema()
+	current := network.RegistrySchema()
+	if err := upstream.Compatible(current); err != nil {
+		r.Err = err
+		return r
+	}
+	for _, d := range upstream.Diff(current) {
+		// the added messages and types are only sent to the conodes
+		// knowing them
+		if strings.HasPrefix(d, "message ") || strings.HasPrefix(d, "type ") {
+			continue
+		}
+		r.note("%s, which %s ignores", d, Upstream)
+	}
+	return r
+}
+
+// CheckHandshake connects to the conode as an upstream conode would, with
+// plain TCP: it sends its ServerIdentity and a RequestTree, and checks that
+// the conode keeps the connection and only answers with messages that
+// upstream conodes can decode or drop.
+func CheckHandshake(addr network.Address, timeout time.Duration) *Result {
+	r := &Result{Name: "handshake"}
+	if addr.ConnType() != network.PlainTCP {
+		r.Err = xerrors.Errorf("only the tcp:// handshake is checked, not the one of %s", addr)
+		return r
+	}
+	c, err := net.DialTimeout("tcp", addr.NetworkAddress(), timeout)
+	if err != nil {
+		r.Err = xerrors.Errorf("connecting: %v", err)
+		return r
+	}
+	defer c.Close()
+
+	for _, frame := range upstreamHandshake {
+		buf, err := hex.DecodeString(frame)
+		if err != nil {
+			// the frames are constants
+			panic("decoding the upstream handshake: " + err.Error())
+		}
+		if _, err := c.Write(buf); err != nil {
+			r.Err = xerrors.Errorf("sending: %v", err)
+			return r
+		}
+	}
+
+	suite := suites.MustFind("Ed25519")
+
+	upstream := UpstreamSchema().Messages
+	c.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		buf, err := readFrame(c)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// the conode kept the connection
+				return r
+			}
+			if xerrors.Is(err, io.EOF) {
+				r.Err = xerrors.New("the conode closed the connection, it might ask for a puzzle, which upstream conodes don't solve")
+			} else {
+				r.Err = xerrors.Errorf("receiving: %v", err)
+			}
+			return r
+		}
+		var id uuid.UUID
+		copy(id[:], buf)
+		typ, msg, err := network.Unmarshal(buf, suite)
+		switch {
+		case upstream[id.String()] != "":
+			if err != nil {
+				r.Err = xerrors.Errorf("decoding %s: %v", upstream[id.String()], err)
+				return r
+			}
+		case typ.Equal(network.CapabilityAnnouncementType):
+			r.note("the conode announces its capabilities %v, which %s drops",
+				msg.(*network.CapabilityAnnouncement).Capabilities, Upstream)
+		default:
+			r.note("the conode sends a %s, which %s drops", typ, Upstream)
+		}
+	}
+}
+
+// upstreamHandshake are the frames an upstream conode sends on a new
+// connection, as the network package of Upstream writes them: the size on 4
+// bytes, followed by the type and the protobuf encoding. They are its
+// identity, with a fixed Ed25519 key and the address tcp://192.0.2.1:7770,
+// and the request of an unknown tree. They are encoded with the structures
+// of Upstream, and not with the ones of this module, so that a change of
+// the wire format here doesn't go unnoticed.
+var upstreamHandshake = []string{
+	"0000006a7b9e136cc4885963a0b48201b31a19750a20207f123fdd39445c0fdc30b1f55ceb03e35dfc0337b36fac36ed5fb7" +
+		"ef9e23b91a107fc38feacfad5afa90c15aaf5189220d22147463703a2f2f3139322e302e322e313a373737302a0c6f6e" +
+		"657420696e7465726f703a00",
+	"0000002417896f9a54e958e2a90dbe2978cdacb20a10f61b0bd189e950b8a06f92c1f0cfdf7a1000",
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var size network.Size
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > network.MaxPacketSize {
+		return nil, xerrors.Errorf("too big packet: %d", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// CheckWebSocket sends an empty request to the path of the websocket, as the
+// client of Upstream does, and checks that the conode answers with a binary
+// message, or closes the connection with an error of the service.
+func CheckWebSocket(url, path string, timeout time.Duration) *Result {
+	r := &Result{Name: "websocket"}
+	if url == "" {
+		r.Err = xerrors.New("no URL for the websocket")
+		return r
+	}
+	url = strings.Replace(strings.TrimSuffix(url, "/"), "http", "ws", 1) + "/" + path
+	d := websocket.Dialer{HandshakeTimeout: timeout}
+	ws, _, err := d.Dial(url, nil)
+	if err != nil {
+		r.Err = xerrors.Errorf("connecting to %s: %v", url, err)
+		return r
+	}
+	defer ws.Close()
+	ws.SetWriteDeadline(time.Now().Add(timeout))
+	if err := ws.WriteMessage(websocket.BinaryMessage, nil); err != nil {
+		r.Err = xerrors.Errorf("sending: %v", err)
+		return r
+	}
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	mt, _, err := ws.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); ok && ce.Code == websocket.CloseProtocolError {
+		// the conodes of Upstream close with this code when the service
+		// returns an error, which their client reports
+		r.note("the service refused the empty request: %s", ce.Text)
+		return r
+	}
+	if err != nil {
+		r.Err = xerrors.Errorf("receiving: %v", err)
+		return r
+	}
+	if mt != websocket.BinaryMessage {
+		r.Err = xerrors.Errorf("the answer is a message of type %d instead of binary", mt)
+	}
+	return r
+}
@@ -0,0 +1,107 @@
+package interop
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+var tSuite = suites.MustFind("Ed25519")
+
+const interopServiceName = "interopService"
+
+type interopRequest struct{}
+
+func init() {
+	onet.RegisterNewService(interopServiceName, func(c *onet.Context) (onet.Service, error) {
+		s := onet.NewServiceProcessor(c)
+		return s, s.RegisterHandler(func(*interopRequest) (*interopRequest, error) {
+			return nil, xerrors.New("not implemented")
+		})
+	})
+}
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestCheckWire(t *testing.T) {
+	r := CheckWire()
+	require.NoError(t, r.Err)
+	require.Contains(t, r.Notes, "onet.ProtocolMsg.LogicalTime (10) added, which "+Upstream+" ignores")
+
+	// a field that changes its type breaks the upstream conodes
+	s := UpstreamSchema()
+	fields := s.Types["onet.RequestTree"]
+	require.Equal(t, "Version", fields[1].Name)
+	fields[1].Type = "uint64"
+	require.Error(t, s.Compatible(network.RegistrySchema()))
+}
+
+// TestUpstreamHandshake checks that the frames of the upstream handshake
+// are the identity of a conode and the request of a tree.
+func TestUpstreamHandshake(t *testing.T) {
+	require.Equal(t, 2, len(upstreamHandshake))
+	var msgs []network.Message
+	for _, frame := range upstreamHandshake {
+		buf, err := hex.DecodeString(frame)
+		require.NoError(t, err)
+		require.Equal(t, len(buf)-4, int(buf[0])<<24|int(buf[1])<<16|int(buf[2])<<8|int(buf[3]))
+		_, msg, err := network.Unmarshal(buf[4:], tSuite)
+		require.NoError(t, err)
+		msgs = append(msgs, msg)
+	}
+	si, ok := msgs[0].(*network.ServerIdentity)
+	require.True(t, ok)
+	require.True(t, si.ID.Equal(network.NewServerIdentity(si.Public, si.Address).ID))
+	require.Equal(t, network.NewTCPAddress("192.0.2.1:7770"), si.Address)
+	_, ok = msgs[1].(*onet.RequestTree)
+	require.True(t, ok)
+}
+
+func TestRun(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+	local := onet.NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	servers[0].SetCapability("test", "1")
+	addr := servers[0].ServerIdentity.Address
+
+	report := Run(Options{Address: addr, Path: interopServiceName + "/interopRequest", Timeout: 500 * time.Millisecond})
+	require.True(t, report.OK(), report.String())
+	require.Equal(t, 3, len(report.Results))
+	require.Contains(t, report.String(), "announces its capabilities [{test 1}]")
+	require.Contains(t, report.String(), "the service refused the empty request")
+
+	report = Run(Options{Address: addr, Path: "Unknown/Request", Timeout: 500 * time.Millisecond})
+	require.False(t, report.OK())
+	require.Contains(t, report.String(), "websocket: failed")
+
+	// a conode that doesn't talk the wire format closes the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	r := CheckHandshake(network.NewTCPAddress("127.0.0.1:"+strconv.Itoa(port)), time.Second)
+	require.Error(t, r.Err)
+	require.Error(t, CheckHandshake(network.NewTLSAddress(fmt.Sprintf("127.0.0.1:%d", port)), time.Second).Err)
+}
@@ -0,0 +1,46 @@
+// The onet-interop binary checks whether the conodes built with this module
+// can be mixed with the ones of go.dedis.ch/onet/v3. Without arguments, it
+// only compares the wire format of the messages; given the address of a
+// running conode, of either version, it also checks its handshake, and with
+// -path the websocket API.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.dedis.ch/onet/v4/interop"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func main() {
+	var opts interop.Options
+	flag.StringVar(&opts.URL, "url", "", "URL of the websocket, at the port after the address by default")
+	flag.StringVar(&opts.Path, "path", "", "request sent to the websocket, like Status/Request")
+	flag.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "how long to wait for the conode")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [tcp://host:port]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		opts.Address = network.Address(flag.Arg(0))
+		if !opts.Address.Valid() {
+			fmt.Fprintln(os.Stderr, "invalid address:", flag.Arg(0))
+			os.Exit(2)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	report := interop.Run(opts)
+	fmt.Print(report)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
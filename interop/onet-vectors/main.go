@@ -0,0 +1,58 @@
+// The onet-vectors binary writes the golden encodings of the messages of
+// onet to the file given by -o, for the client libraries in other languages
+// to test against. With -verify, it checks a file of vectors against this
+// version instead, prints the differences, and fails if one of them breaks
+// the encoding of a message the vectors hold.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.dedis.ch/onet/v4/interop"
+)
+
+func main() {
+	output := flag.String("o", "vectors.json", "file to write the vectors to")
+	verify := flag.String("verify", "", "file of vectors to verify instead of writing them")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *verify == "" {
+		v, err := interop.GenerateVectors()
+		if err == nil {
+			err = v.Save(*output)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d messages written to %s\n", len(v.Messages), *output)
+		return
+	}
+
+	v, err := interop.LoadVectors(*verify)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	diff, err := v.Verify()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	breaking := false
+	for _, d := range diff {
+		fmt.Println(d)
+		breaking = breaking || strings.HasPrefix(d, "breaking: ")
+	}
+	if breaking {
+		os.Exit(1)
+	}
+	fmt.Printf("the %d messages are encoded as in %s\n", len(v.Messages), *verify)
+}
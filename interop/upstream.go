@@ -0,0 +1,120 @@
+package interop
+
+import (
+	"encoding/json"
+
+	"go.dedis.ch/onet/v4/network"
+)
+
+// Upstream is the module whose conodes this module is checked against.
+const Upstream = "go.dedis.ch/onet/v3"
+
+// UpstreamSchema returns the wire format of the messages the conodes of
+// Upstream exchange, as network.RegistrySchema describes it.
+func UpstreamSchema() *network.Schema {
+	s := &network.Schema{}
+	if err := json.Unmarshal([]byte(upstreamSchema), s); err != nil {
+		// the schema is a constant
+		panic("decoding the upstream schema: " + err.Error())
+	}
+	return s
+}
+
+// upstreamSchema is the schema of the messages of Upstream: the ones of the
+// handshake, of the protocols, and of the trees and rosters they send along.
+const upstreamSchema = `{
+  "Messages": {
+    "1682b1ca-6b28-5201-ae09-a0e1a4161b98": "onet.RequestRoster",
+    "17896f9a-54e9-58e2-a90d-be2978cdacb2": "onet.RequestTree",
+    "2cb9b5c8-1966-5236-9447-19454b4c6bbc": "onet.GenericConfig",
+    "2dae95c7-320b-5cae-a322-e3cc2bfd21a2": "onet.ProtocolMsg",
+    "3a3cee21-573b-5d8a-92d4-f57e4489fc46": "onet.Tree",
+    "6f059182-cba4-5f19-9c5c-7dab7875cd33": "onet.ResponseTree",
+    "7b9e136c-c488-5963-a0b4-8201b31a1975": "network.ServerIdentity",
+    "a1c5eca3-1899-578f-ab3c-79bcbd1a23df": "onet.ConfigMsg",
+    "b2fe1deb-7ef9-5466-a07e-148195768a80": "onet.TreeNode",
+    "ccbba80f-dd40-5c3e-9074-8604a0921e9e": "onet.Roster",
+    "d6bb9309-386f-5127-b85b-434102f07c09": "onet.tbmStruct",
+    "d73e44bb-6a8d-5399-917c-594232f0a580": "onet.TreeMarshal"
+  },
+  "Types": {
+    "network.ServerIdentity": [
+      {"ID": 1, "Name": "Public", "Type": "kyber.Point"},
+      {"ID": 2, "Name": "ServiceIdentities", "Type": "[]network.ServiceIdentity"},
+      {"ID": 3, "Name": "ID", "Type": "[16]uint8"},
+      {"ID": 4, "Name": "Address", "Type": "network.Address(string)"},
+      {"ID": 5, "Name": "Description", "Type": "string"},
+      {"ID": 7, "Name": "URL", "Type": "string"}
+    ],
+    "network.ServiceIdentity": [
+      {"ID": 1, "Name": "Name", "Type": "string"},
+      {"ID": 2, "Name": "Suite", "Type": "string"},
+      {"ID": 3, "Name": "Public", "Type": "kyber.Point"}
+    ],
+    "onet.ConfigMsg": [
+      {"ID": 1, "Name": "Config", "Type": "onet.GenericConfig"},
+      {"ID": 2, "Name": "Dest", "Type": "[16]uint8"}
+    ],
+    "onet.GenericConfig": [
+      {"ID": 1, "Name": "Data", "Type": "[]uint8"}
+    ],
+    "onet.ProtocolMsg": [
+      {"ID": 1, "Name": "From", "Type": "*onet.Token"},
+      {"ID": 2, "Name": "To", "Type": "*onet.Token"},
+      {"ID": 3, "Name": "ServerIdentity", "Type": "*network.ServerIdentity"},
+      {"ID": 4, "Name": "MsgType", "Type": "[16]uint8"},
+      {"ID": 5, "Name": "Msg", "Type": "network.Message"},
+      {"ID": 6, "Name": "MsgSlice", "Type": "[]uint8"},
+      {"ID": 7, "Name": "Size", "Type": "network.Size(uint32)"}
+    ],
+    "onet.RequestRoster": [
+      {"ID": 1, "Name": "RosterID", "Type": "[16]uint8"}
+    ],
+    "onet.RequestTree": [
+      {"ID": 1, "Name": "TreeID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "Version", "Type": "uint32"}
+    ],
+    "onet.ResponseTree": [
+      {"ID": 1, "Name": "TreeMarshal", "Type": "*onet.TreeMarshal"},
+      {"ID": 2, "Name": "Roster", "Type": "*onet.Roster"}
+    ],
+    "onet.Roster": [
+      {"ID": 1, "Name": "ID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "List", "Type": "[]*network.ServerIdentity"},
+      {"ID": 3, "Name": "Aggregate", "Type": "kyber.Point"}
+    ],
+    "onet.Token": [
+      {"ID": 1, "Name": "RosterID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "TreeID", "Type": "[16]uint8"},
+      {"ID": 3, "Name": "ProtoID", "Type": "[16]uint8"},
+      {"ID": 4, "Name": "ServiceID", "Type": "[16]uint8"},
+      {"ID": 5, "Name": "RoundID", "Type": "[16]uint8"},
+      {"ID": 6, "Name": "TreeNodeID", "Type": "[16]uint8"}
+    ],
+    "onet.Tree": [
+      {"ID": 1, "Name": "ID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "Roster", "Type": "*onet.Roster"},
+      {"ID": 3, "Name": "Root", "Type": "*onet.TreeNode"}
+    ],
+    "onet.TreeMarshal": [
+      {"ID": 1, "Name": "TreeNodeID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "TreeID", "Type": "[16]uint8"},
+      {"ID": 3, "Name": "ServerIdentityID", "Type": "[16]uint8"},
+      {"ID": 4, "Name": "RosterID", "Type": "[16]uint8"},
+      {"ID": 5, "Name": "Children", "Type": "[]*onet.TreeMarshal"}
+    ],
+    "onet.TreeNode": [
+      {"ID": 1, "Name": "ID", "Type": "[16]uint8"},
+      {"ID": 2, "Name": "ServerIdentity", "Type": "*network.ServerIdentity"},
+      {"ID": 3, "Name": "RosterIndex", "Type": "int"},
+      {"ID": 4, "Name": "Parent", "Type": "*onet.TreeNode"},
+      {"ID": 5, "Name": "Children", "Type": "[]*onet.TreeNode"},
+      {"ID": 6, "Name": "PublicAggregateSubTree", "Type": "kyber.Point"}
+    ],
+    "onet.tbmStruct": [
+      {"ID": 1, "Name": "T", "Type": "[]uint8"},
+      {"ID": 2, "Name": "Ro", "Type": "*onet.Roster"}
+    ]
+  }
+}
+`
@@ -0,0 +1,368 @@
+package interop
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// VectorSuite is the suite of the points and scalars of the vectors.
+const VectorSuite = "Ed25519"
+
+// Vectors are golden encodings of the messages of onet, for the client
+// libraries in other languages to check that they encode and decode them
+// like the conodes do. They are saved as JSON, with the bytes in hex.
+type Vectors struct {
+	Suite    string
+	Messages []MessageVector
+	// Handshake are the frames sent when a conode connects to another one.
+	Handshake []HandshakeFrame
+}
+
+// MessageVector is the encoding of a sample value of a registered message.
+type MessageVector struct {
+	// Type is the name of the Go type, like "network.ServerIdentity".
+	Type string
+	// ID is the MessageTypeID on the wire.
+	ID string
+	// Value is the sample, with the structs as objects of their fields,
+	// the bytes, points and scalars in hex, and the times in RFC 3339.
+	Value interface{}
+	// Envelope is the message as network.Marshal encodes it: its ID
+	// followed by its protobuf encoding.
+	Envelope string
+}
+
+// HandshakeFrame is a frame of a connection, as sent on the wire, with its
+// size on 4 bytes before the envelope.
+type HandshakeFrame struct {
+	// From is "dialer" or "listener".
+	From  string
+	Type  string
+	Frame string
+}
+
+// the samples are filled to this depth, so that the recursive types end
+const vectorDepth = 3
+
+// GenerateVectors returns the vectors of the exported messages of the
+// network and onet packages, filled with deterministic values. The messages
+// that can't be encoded without a value set by their package are left out.
+func GenerateVectors() (*Vectors, error) {
+	// the messages of onet are registered by its import
+	_ = onet.ProtocolMsgID
+	suite := suites.MustFind(VectorSuite)
+	v := &Vectors{Suite: VectorSuite}
+	for id, typ := range network.RegisteredMessages() {
+		name := typ.String()
+		if !strings.HasPrefix(name, "network.") && !strings.HasPrefix(name, "onet.") {
+			continue
+		}
+		if !token.IsExported(typ.Name()) {
+			// the clients can't send them
+			continue
+		}
+		msg, err := sampleMessage(typ, suite)
+		if err != nil {
+			continue
+		}
+		buf, err := network.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		v.Messages = append(v.Messages, MessageVector{
+			Type:     name,
+			ID:       uuid.UUID(id).String(),
+			Value:    describeValue(reflect.ValueOf(msg).Elem()),
+			Envelope: hex.EncodeToString(buf),
+		})
+	}
+	sort.Slice(v.Messages, func(i, j int) bool { return v.Messages[i].Type < v.Messages[j].Type })
+
+	// the dialer sends its identity, and both sides their capabilities
+	si, err := sampleMessage(reflect.TypeOf(network.ServerIdentity{}), suite)
+	if err != nil {
+		return nil, xerrors.Errorf("sample identity: %v", err)
+	}
+	caps := &network.CapabilityAnnouncement{Capabilities: []network.Capability{{Name: "Service/feature", Version: "1"}}}
+	for _, f := range []struct {
+		from string
+		msg  network.Message
+	}{{"dialer", si}, {"dialer", caps}, {"listener", caps}} {
+		buf, err := network.Marshal(f.msg)
+		if err != nil {
+			return nil, xerrors.Errorf("encoding %T: %v", f.msg, err)
+		}
+		frame := make([]byte, 4, 4+len(buf))
+		binary.BigEndian.PutUint32(frame, uint32(len(buf)))
+		v.Handshake = append(v.Handshake, HandshakeFrame{
+			From:  f.from,
+			Type:  reflect.TypeOf(f.msg).Elem().String(),
+			Frame: hex.EncodeToString(append(frame, buf...)),
+		})
+	}
+	return v, nil
+}
+
+// LoadVectors reads the vectors saved with Save.
+func LoadVectors(file string) (*Vectors, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("reading vectors: %v", err)
+	}
+	v := &Vectors{}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return nil, xerrors.Errorf("decoding vectors: %v", err)
+	}
+	return v, nil
+}
+
+// Save writes the vectors to the file, as indented JSON.
+func (v *Vectors) Save(file string) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return xerrors.Errorf("encoding vectors: %v", err)
+	}
+	if err := ioutil.WriteFile(file, append(buf, '\n'), 0644); err != nil {
+		return xerrors.Errorf("writing vectors: %v", err)
+	}
+	return nil
+}
+
+// Verify checks the vectors against the messages of this binary, and
+// returns the differences, sorted. The ones starting with "breaking: " are
+// the envelopes this binary doesn't decode, or encodes differently, which
+// would break the client libraries; the others are the messages added or
+// changed since the vectors were generated, which only need the vectors to
+// be generated again.
+func (v *Vectors) Verify() ([]string, error) {
+	suite, err := suites.Find(v.Suite)
+	if err != nil {
+		return nil, xerrors.Errorf("suite: %v", err)
+	}
+	current, err := GenerateVectors()
+	if err != nil {
+		return nil, err
+	}
+	fresh := make(map[string]MessageVector)
+	for _, mv := range current.Messages {
+		fresh[mv.Type] = mv
+	}
+	var diff []string
+	for _, mv := range v.Messages {
+		if err := verifyEnvelope(mv, suite); err != nil {
+			diff = append(diff, fmt.Sprintf("breaking: %s: %v", mv.Type, err))
+		} else if cur, ok := fresh[mv.Type]; !ok {
+			diff = append(diff, fmt.Sprintf("%s has no vector anymore", mv.Type))
+		} else if cur.Envelope != mv.Envelope {
+			diff = append(diff, fmt.Sprintf("%s changed", mv.Type))
+		}
+		delete(fresh, mv.Type)
+	}
+	for name := range fresh {
+		diff = append(diff, fmt.Sprintf("%s added", name))
+	}
+	for _, f := range v.Handshake {
+		buf, err := hex.DecodeString(f.Frame)
+		if err != nil || len(buf) < 4 || int(binary.BigEndian.Uint32(buf)) != len(buf)-4 {
+			diff = append(diff, fmt.Sprintf("breaking: handshake %s of the %s: invalid frame", f.Type, f.From))
+			continue
+		}
+		if err := verifyEnvelope(MessageVector{Type: f.Type, Envelope: hex.EncodeToString(buf[4:])}, suite); err != nil {
+			diff = append(diff, fmt.Sprintf("breaking: handshake %s of the %s: %v", f.Type, f.From, err))
+		}
+	}
+	sort.Strings(diff)
+	return diff, nil
+}
+
+// verifyEnvelope checks that the envelope decodes as its type, and that it
+// is encoded again byte for byte.
+func verifyEnvelope(mv MessageVector, suite network.Suite) error {
+	buf, err := hex.DecodeString(mv.Envelope)
+	if err != nil {
+		return xerrors.Errorf("envelope: %v", err)
+	}
+	id, msg, err := network.Unmarshal(buf, suite)
+	if err != nil {
+		return xerrors.Errorf("decoding: %v", err)
+	}
+	if name := reflect.TypeOf(msg).Elem().String(); name != mv.Type {
+		return xerrors.Errorf("decoded as %s", name)
+	}
+	if mv.ID != "" && uuid.UUID(id).String() != mv.ID {
+		return xerrors.Errorf("ID %s instead of %s", uuid.UUID(id), mv.ID)
+	}
+	again, err := network.Marshal(msg)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	if !bytes.Equal(again, buf) {
+		return xerrors.New("encoded differently")
+	}
+	return nil
+}
+
+var (
+	pointType   = reflect.TypeOf((*kyber.Point)(nil)).Elem()
+	scalarType  = reflect.TypeOf((*kyber.Scalar)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	addressType = reflect.TypeOf(network.Address(""))
+)
+
+// vectorTime is the time of the samples.
+var vectorTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// sampleMessage returns a pointer to a value of the type with all its
+// exported fields set, the same every time.
+func sampleMessage(typ reflect.Type, suite suites.Suite) (msg network.Message, err error) {
+	defer func() {
+		// some types refuse the values of other types in their fields
+		if r := recover(); r != nil {
+			err = xerrors.Errorf("filling %s: %v", typ, r)
+		}
+	}()
+	ptr := reflect.New(typ)
+	fill(ptr.Elem(), "", 0, suite.XOF([]byte(typ.String())), suite)
+	return ptr.Interface(), nil
+}
+
+// fill sets the value named after its field, from the stream of its type.
+func fill(v reflect.Value, name string, depth int, stream cipher.Stream, suite suites.Suite) {
+	switch {
+	case v.Type() == pointType:
+		v.Set(reflect.ValueOf(suite.Point().Pick(stream)))
+		return
+	case v.Type() == scalarType:
+		v.Set(reflect.ValueOf(suite.Scalar().Pick(stream)))
+		return
+	case v.Type() == timeType:
+		v.Set(reflect.ValueOf(vectorTime))
+		return
+	case v.Type() == addressType:
+		v.Set(reflect.ValueOf(network.NewTLSAddress("127.0.0.1:7770")))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(len(name) + depth + 1))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(len(name) + depth + 1))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(len(name)) + 0.5)
+	case reflect.String:
+		v.SetString(strings.ToLower(name))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), name, depth, stream, suite)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			stream.XORKeyStream(v.Slice(0, v.Len()).Bytes(), make([]byte, v.Len()))
+		}
+	case reflect.Slice:
+		if depth >= vectorDepth {
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s = reflect.ValueOf([]byte(name + "-bytes")).Convert(v.Type())
+		} else {
+			fill(s.Index(0), name, depth+1, stream, suite)
+		}
+		v.Set(s)
+	case reflect.Map:
+		if depth >= vectorDepth {
+			return
+		}
+		m := reflect.MakeMap(v.Type())
+		k, e := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fill(k, name+"Key", depth+1, stream, suite)
+		fill(e, name, depth+1, stream, suite)
+		m.SetMapIndex(k, e)
+		v.Set(m)
+	case reflect.Ptr:
+		if depth >= vectorDepth {
+			return
+		}
+		p := reflect.New(v.Type().Elem())
+		fill(p.Elem(), name, depth+1, stream, suite)
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" {
+				fill(v.Field(i), f.Name, depth, stream, suite)
+			}
+		}
+	}
+	// the other interfaces, like network.Message, stay nil
+}
+
+// describeValue returns the value as a tree of JSON values.
+func describeValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		if m, ok := v.Interface().(encoding.BinaryMarshaler); ok && v.Kind() == reflect.Interface {
+			buf, err := m.MarshalBinary()
+			if err != nil {
+				return nil
+			}
+			return hex.EncodeToString(buf)
+		}
+		return describeValue(v.Elem())
+	}
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() == reflect.Uint8:
+		buf := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(buf), v)
+		return hex.EncodeToString(buf)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" {
+				fields[f.Name] = describeValue(v.Field(i))
+			}
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			elems[i] = describeValue(v.Index(i))
+		}
+		return elems
+	case reflect.Map:
+		entries := make(map[string]interface{})
+		for _, k := range v.MapKeys() {
+			entries[fmt.Sprint(describeValue(k))] = describeValue(v.MapIndex(k))
+		}
+		return entries
+	}
+	return v.Interface()
+}
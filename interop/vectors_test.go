@@ -0,0 +1,61 @@
+package interop
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestVectors checks that the messages are still encoded as in the vectors
+// of the client libraries. If a message changed on purpose, the vectors are
+// written again with onet-vectors.
+func TestVectors(t *testing.T) {
+	v, err := LoadVectors("vectors.json")
+	require.NoError(t, err)
+	diff, err := v.Verify()
+	require.NoError(t, err)
+	require.Empty(t, diff)
+	require.Equal(t, 3, len(v.Handshake))
+}
+
+func TestVectors_Verify(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "vectors")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	v, err := GenerateVectors()
+	require.NoError(t, err)
+	file := path.Join(tmp, "vectors.json")
+	require.NoError(t, v.Save(file))
+	v, err = LoadVectors(file)
+	require.NoError(t, err)
+
+	var kept []MessageVector
+	for i, mv := range v.Messages {
+		switch mv.Type {
+		case "onet.RequestTree":
+			// the Version of the tree, which is the last byte
+			v.Messages[i].Envelope = mv.Envelope[:len(mv.Envelope)-2] + "09"
+		case "onet.Roster":
+			v.Messages[i].Envelope = mv.Envelope[:len(mv.Envelope)-4]
+		case "network.ServerIdentity":
+			v.Messages[i].ID = "00000000-0000-0000-0000-000000000000"
+		case "onet.Heartbeat":
+			continue
+		}
+		kept = append(kept, v.Messages[i])
+	}
+	v.Messages = kept
+	v.Handshake[0].Frame = "ff" + v.Handshake[0].Frame[2:]
+	diff, err := v.Verify()
+	require.NoError(t, err)
+	require.Equal(t, 5, len(diff), diff)
+	require.Equal(t, "breaking: handshake network.ServerIdentity of the dialer: invalid frame", diff[0])
+	require.Contains(t, diff[1], "breaking: network.ServerIdentity: ID")
+	require.Contains(t, diff[2], "breaking: onet.Roster: decoding")
+	require.Equal(t, "onet.Heartbeat added", diff[3])
+	require.Equal(t, "onet.RequestTree changed", diff[4])
+}
@@ -0,0 +1,244 @@
+package onet
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// DefaultWebSocketTimeout is how long a message may take to be sent on a
+// websocket, and how long a client waits for a reply, unless its KeepAlive
+// says otherwise.
+const DefaultWebSocketTimeout = 5 * time.Minute
+
+// errStreamClosed is returned when a stream can't reconnect because it was
+// closed by the client.
+var errStreamClosed = xerrors.New("stream closed")
+
+// KeepAlive tells how the websocket connections of the requests and of the
+// streams are kept alive, and when they are given up. The proxies between a
+// client and a conode sometimes drop the connections they find idle without
+// closing them, so that a long stream dies silently: the pings keep them
+// busy, and the timeouts find out when the other side is gone.
+type KeepAlive struct {
+	// PingInterval is how often a ping is sent on the connection. No ping
+	// is sent if it is zero.
+	PingInterval time.Duration
+	// ReadTimeout is how long a connection may stay without receiving
+	// anything, a message, a ping or a pong, before it is given up. It is
+	// DefaultWebSocketTimeout for the clients if zero, and without a limit
+	// for the server. The clients only answer the pings while they wait for
+	// a reply or read a stream.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long a message may take to be sent,
+	// DefaultWebSocketTimeout if zero.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a connection may stay without a request, a
+	// reply or a message of a stream, the pings not counting. The server
+	// closes the idle connections, and the clients dial again instead of
+	// using theirs. There is no limit if it is zero.
+	IdleTimeout time.Duration
+	// Reconnect is how many times in a row a stream of a Client dials again
+	// and sends its request anew when its connection breaks, instead of
+	// returning an error. The service streams from the start of the request
+	// again, so the messages sent while the stream was down are lost, and
+	// some of the others may come twice. The server ignores it.
+	Reconnect int
+}
+
+func (ka KeepAlive) check() error {
+	if ka.PingInterval < 0 || ka.ReadTimeout < 0 || ka.WriteTimeout < 0 ||
+		ka.IdleTimeout < 0 || ka.Reconnect < 0 {
+		return xerrors.New("negative keep-alive")
+	}
+	return nil
+}
+
+// withDefaults returns the KeepAlive with the default timeouts of a client
+// or of the server.
+func (ka KeepAlive) withDefaults(client bool) KeepAlive {
+	if ka.ReadTimeout == 0 && client {
+		ka.ReadTimeout = DefaultWebSocketTimeout
+	}
+	if ka.WriteTimeout == 0 {
+		ka.WriteTimeout = DefaultWebSocketTimeout
+	}
+	return ka
+}
+
+// extendRead gives the other side ReadTimeout more to send something.
+func (ka KeepAlive) extendRead(ws *websocket.Conn) error {
+	if ka.ReadTimeout <= 0 {
+		return ws.SetReadDeadline(time.Time{})
+	}
+	return ws.SetReadDeadline(time.Now().Add(ka.ReadTimeout))
+}
+
+// extendWrite gives WriteTimeout to the next message to be sent.
+func (ka KeepAlive) extendWrite(ws *websocket.Conn) error {
+	return ws.SetWriteDeadline(time.Now().Add(ka.WriteTimeout))
+}
+
+// watch extends the read deadline of the connection with every ping and
+// pong received, and sends the pings until the returned function is called.
+func (ka KeepAlive) watch(ws *websocket.Conn) func() {
+	ws.SetPongHandler(func(string) error {
+		return ka.extendRead(ws)
+	})
+	ws.SetPingHandler(func(data string) error {
+		if err := ka.extendRead(ws); err != nil {
+			return err
+		}
+		// as the default handler does, a pong that can't be sent yet is
+		// no reason to close the connection
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(ka.WriteTimeout))
+		if ne, ok := err.(net.Error); err == websocket.ErrCloseSent || ok && ne.Temporary() {
+			return nil
+		}
+		return err
+	})
+	if ka.PingInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan bool)
+	go func() {
+		tick := time.NewTicker(ka.PingInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(ka.WriteTimeout))
+				if err != nil {
+					log.Lvl3("ping to", ws.RemoteAddr(), "failed:", err)
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// idleTimer closes a connection of the server after IdleTimeout without a
+// request or a reply. A nil idleTimer never closes it.
+type idleTimer struct {
+	*time.Timer
+	timeout time.Duration
+}
+
+func (ka KeepAlive) idleTimer(ws *websocket.Conn) *idleTimer {
+	if ka.IdleTimeout <= 0 {
+		return nil
+	}
+	return &idleTimer{
+		Timer: time.AfterFunc(ka.IdleTimeout, func() {
+			log.Lvl2("closing the idle connection of", ws.RemoteAddr())
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle connection"),
+				time.Now().Add(time.Millisecond*500))
+			ws.Close()
+		}),
+		timeout: ka.IdleTimeout,
+	}
+}
+
+// pause keeps the connection from being idle while a request is processed.
+func (it *idleTimer) pause() {
+	if it != nil {
+		it.Stop()
+	}
+}
+
+// touch starts counting the idle time again.
+func (it *idleTimer) touch() {
+	if it != nil {
+		it.Reset(it.timeout)
+	}
+}
+
+// keepAlive returns the KeepAlive of the connections of the server.
+func (w *WebSocket) keepAlive() KeepAlive {
+	if w == nil {
+		return KeepAlive{}.withDefaults(false)
+	}
+	w.Lock()
+	defer w.Unlock()
+	return w.keepAliveConfig.withDefaults(false)
+}
+
+// SetKeepAlive sets how the client keeps its connections alive. It only
+// applies to the connections opened afterwards.
+func (c *Client) SetKeepAlive(ka KeepAlive) {
+	c.Lock()
+	defer c.Unlock()
+	c.keepAliveConfig = ka
+}
+
+// keepAlive returns the KeepAlive of the client, with the defaults. The
+// client must be locked.
+func (c *Client) keepAlive() KeepAlive {
+	return c.keepAliveConfig.withDefaults(true)
+}
+
+// brokenStream returns true if the error is a broken connection, and not
+// the service or the server refusing the stream.
+func brokenStream(err error) bool {
+	return err != errStreamClosed && !websocket.IsCloseError(err, websocket.CloseProtocolError,
+		websocket.ClosePolicyViolation, closeTooManyRequests)
+}
+
+// reconnect dials the node of the stream again and sends the request anew,
+// unless the stream was closed.
+func (c *StreamingConn) reconnect() error {
+	cl := c.client
+	if cl == nil {
+		return errStreamClosed
+	}
+	cl.Lock()
+	connLock := cl.connectionsLock[c.dest]
+	cl.Unlock()
+	connLock.Lock()
+	defer connLock.Unlock()
+	cl.Lock()
+	closed := cl.connections[c.dest] != c.conn
+	cl.Unlock()
+	if closed {
+		return errStreamClosed
+	}
+
+	conn, err := cl.dial(c.dest.si, c.dest.path)
+	if err != nil {
+		return err
+	}
+	cl.Lock()
+	cl.stopPing(c.conn)
+	c.conn.Close()
+	cl.connections[c.dest] = conn
+	cl.pingers[conn] = c.keepAlive.watch(conn)
+	cl.lastUsed[c.dest] = time.Now()
+	cl.tx += uint64(len(c.request))
+	cl.Unlock()
+	c.conn = conn
+
+	if err := c.keepAlive.extendWrite(conn); err != nil {
+		return xerrors.Errorf("write deadline: %v", err)
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, c.request)
+}
+
+// stopPing stops the pings of the connection. The client must be locked.
+func (c *Client) stopPing(conn *websocket.Conn) {
+	if stop, ok := c.pingers[conn]; ok {
+		stop()
+		delete(c.pingers, conn)
+	}
+}
@@ -0,0 +1,167 @@
+package onet
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestWebSocket_KeepAlive(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.Error(t, h.WebSocket.Configure(WebSocketConfig{KeepAlive: KeepAlive{PingInterval: -1}}))
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{KeepAlive: KeepAlive{
+		PingInterval: 20 * time.Millisecond,
+		ReadTimeout:  200 * time.Millisecond,
+	}}))
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	url := "ws://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/" +
+		testServiceName + "/testMsg"
+	request, err := protobuf.Encode(&testMsg{I: 12})
+	require.NoError(t, err)
+
+	// the client reading its connection answers the pings, so that the
+	// server keeps it
+	alive, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer alive.Close()
+	replies := make(chan error, 1)
+	go func() {
+		_, _, err := alive.ReadMessage()
+		replies <- err
+	}()
+	// the other one doesn't, and the server gives it up
+	dead, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer dead.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	require.NoError(t, alive.WriteMessage(websocket.BinaryMessage, request))
+	select {
+	case err := <-replies:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.Fail(t, "no reply")
+	}
+	dead.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = dead.ReadMessage()
+	require.Error(t, err)
+}
+
+func TestWebSocket_IdleTimeout(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{KeepAlive: KeepAlive{
+		IdleTimeout: 200 * time.Millisecond,
+	}}))
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	url := "ws://" + h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1) + "/" +
+		testServiceName + "/testMsg"
+	request, err := protobuf.Encode(&testMsg{I: 12})
+	require.NoError(t, err)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the requests keep the connection open
+	for i := 0; i < 5; i++ {
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, request))
+		_, _, err := conn.ReadMessage()
+		require.NoError(t, err)
+		time.Sleep(100 * time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{KeepAlive: KeepAlive{
+		ReadTimeout: 200 * time.Millisecond,
+	}}))
+	dest := destination{h.ServerIdentity, "testMsg"}
+	connection := func(cl *Client) *websocket.Conn {
+		cl.Lock()
+		defer cl.Unlock()
+		return cl.connections[dest]
+	}
+
+	// the pings of the client keep its connection open
+	pinging := local.NewClientKeep(testServiceName)
+	defer pinging.Close()
+	pinging.SetKeepAlive(KeepAlive{PingInterval: 20 * time.Millisecond})
+	silent := local.NewClientKeep(testServiceName)
+	defer silent.Close()
+	for _, cl := range []*Client{pinging, silent} {
+		require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+	}
+	conn := connection(pinging)
+	time.Sleep(500 * time.Millisecond)
+	require.NoError(t, pinging.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+	require.True(t, conn == connection(pinging))
+	require.Error(t, silent.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+
+	// the client doesn't use its idle connections
+	idle := local.NewClientKeep(testServiceName)
+	defer idle.Close()
+	idle.SetKeepAlive(KeepAlive{IdleTimeout: 50 * time.Millisecond})
+	require.NoError(t, idle.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+	conn = connection(idle)
+	require.NoError(t, idle.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+	require.True(t, conn == connection(idle))
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, idle.SendProtobuf(h.ServerIdentity, &testMsg{I: 12}, &testMsg{}))
+	require.False(t, conn == connection(idle))
+}
+
+func TestStreamingConn_Reconnect(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "streamingService"
+	serID, err := RegisterNewService(serName, newStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, el, _ := local.GenTree(1, false)
+	local.GetServices(servers, serID)[0].(*StreamingService).gotStopChan = make(chan bool, 10)
+	n := 3
+	r := &SimpleRequest{ServerIdentities: el, Val: int64(n)}
+
+	// without reconnecting, the stream ends with the connection
+	client := local.NewClientKeep(serName)
+	conn, err := client.Stream(servers[0].ServerIdentity, r)
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadMessage(&SimpleResponse{}))
+	conn.conn.UnderlyingConn().Close()
+	require.Error(t, conn.ReadMessage(&SimpleResponse{}))
+
+	client = local.NewClientKeep(serName)
+	defer client.Close()
+	client.SetKeepAlive(KeepAlive{Reconnect: 1})
+	conn, err = client.Stream(servers[0].ServerIdentity, r)
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadMessage(&SimpleResponse{}))
+	conn.conn.UnderlyingConn().Close()
+	// the service streams from the start again
+	for i := 0; i < n; i++ {
+		sr := &SimpleResponse{}
+		require.NoError(t, conn.ReadMessage(sr))
+		require.Equal(t, int64(n), sr.Val)
+	}
+	require.Equal(t, io.EOF, conn.ReadMessage(&SimpleResponse{}))
+}
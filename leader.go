@@ -0,0 +1,207 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// leaderProtocolName is the CollectProtocol running the votes of the leader
+// elections.
+const leaderProtocolName = "LeaderElection"
+
+// DefaultLeaderTimeout is how long Reelect waits for the votes when no
+// timeout is given.
+const DefaultLeaderTimeout = 5 * time.Second
+
+// LeaderVoteMsgID of LeaderVote message as registered in network
+var LeaderVoteMsgID = network.RegisterMessage(LeaderVote{})
+
+// LeaderVotesMsgID of LeaderVotes message as registered in network
+var LeaderVotesMsgID = network.RegisterMessage(LeaderVotes{})
+
+func init() {
+	GlobalProtocolRegister(leaderProtocolName, NewCollectProtocol(respondLeaderVote, reduceLeaderVotes))
+}
+
+// LeaderVote asks the members of a roster to move to a view, in two phases:
+// first if they agree to, then to move to it.
+type LeaderVote struct {
+	View   uint64
+	Commit bool
+}
+
+// LeaderVotes are the votes of a subtree for a LeaderVote, and the highest
+// view its members are in.
+type LeaderVotes struct {
+	Votes int
+	View  uint64
+}
+
+// LeaderElection gives the leader of a roster for a service. The leader of
+// the view v is the member v modulo the size of the roster, so that the
+// views rotate the leadership deterministically, and Reelect moves to the
+// next view when a majority of the members agree that the leader failed.
+type LeaderElection struct {
+	service ServiceID
+	roster  *Roster
+	overlay *Overlay
+
+	lock     sync.Mutex
+	view     uint64
+	failed   func(leader *network.ServerIdentity) bool
+	onChange []func(view uint64, leader *network.ServerIdentity)
+}
+
+// LeaderElection returns the election of the leader of the roster for the
+// service, the same for all the calls with the roster.
+func (c *Context) LeaderElection(ro *Roster) *LeaderElection {
+	return c.overlay.leaderElection(c.serviceID, ro)
+}
+
+func (o *Overlay) leaderElection(service ServiceID, ro *Roster) *LeaderElection {
+	o.leadersLock.Lock()
+	defer o.leadersLock.Unlock()
+	rosters, ok := o.leaders[service]
+	if !ok {
+		rosters = make(map[RosterID]*LeaderElection)
+		o.leaders[service] = rosters
+	}
+	le, ok := rosters[ro.ID]
+	if !ok {
+		le = &LeaderElection{service: service, roster: ro, overlay: o}
+		rosters[ro.ID] = le
+	}
+	return le
+}
+
+// View returns the current view.
+func (le *LeaderElection) View() uint64 {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	return le.view
+}
+
+// Leader returns the leader of the current view.
+func (le *LeaderElection) Leader() *network.ServerIdentity {
+	return le.LeaderAt(le.View())
+}
+
+// LeaderAt returns the leader of the view, for the services rotating the
+// leadership themselves, like one view per epoch.
+func (le *LeaderElection) LeaderAt(view uint64) *network.ServerIdentity {
+	return le.roster.List[view%uint64(len(le.roster.List))]
+}
+
+// SetFailureCheck sets the function telling whether this member agrees that
+// the leader failed when another one asks to move to the next view. By
+// default it always agrees; Context.Suspected makes it follow the failure
+// detector of the overlay, for a watched roster.
+func (le *LeaderElection) SetFailureCheck(failed func(leader *network.ServerIdentity) bool) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	le.failed = failed
+}
+
+// OnChange registers a function called with the new view and leader when
+// this member moves to another view.
+func (le *LeaderElection) OnChange(f func(view uint64, leader *network.ServerIdentity)) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	le.onChange = append(le.onChange, f)
+}
+
+// Reelect asks the members to move to the next view, because the leader
+// failed, and returns the new leader once a majority of them moved to it. If
+// some member is already in a higher view, it moves to the one after.
+func (le *LeaderElection) Reelect(timeout time.Duration) (*network.ServerIdentity, error) {
+	if timeout <= 0 {
+		timeout = DefaultLeaderTimeout
+	}
+	quorum := len(le.roster.List)/2 + 1
+	view := le.View() + 1
+	votes, err := le.vote(&LeaderVote{View: view}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if votes.View >= view {
+		// retry after the highest view known
+		view = votes.View + 1
+		if votes, err = le.vote(&LeaderVote{View: view}, timeout); err != nil {
+			return nil, err
+		}
+	}
+	if votes.Votes < quorum {
+		return nil, xerrors.Errorf("%d votes for view %d, %d needed", votes.Votes, view, quorum)
+	}
+	votes, err = le.vote(&LeaderVote{View: view, Commit: true}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if votes.Votes < quorum {
+		return nil, xerrors.Errorf("view %d committed by %d members, %d needed", view, votes.Votes, quorum)
+	}
+	return le.LeaderAt(view), nil
+}
+
+// vote runs the vote on a star rooted at us, so that a failed member only
+// misses its own vote.
+func (le *LeaderElection) vote(req *LeaderVote, timeout time.Duration) (*LeaderVotes, error) {
+	resp, err := le.overlay.collectStar(leaderProtocolName, le.roster, le.service, req, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("collecting votes: %v", err)
+	}
+	if resp == nil {
+		return &LeaderVotes{}, nil
+	}
+	return resp.(*LeaderVotes), nil
+}
+
+// answer returns the vote of this member for the request.
+func (le *LeaderElection) answer(req *LeaderVote) *LeaderVotes {
+	le.lock.Lock()
+	if !req.Commit {
+		defer le.lock.Unlock()
+		if req.View <= le.view {
+			return &LeaderVotes{View: le.view}
+		}
+		if le.failed != nil && !le.failed(le.LeaderAt(le.view)) {
+			return &LeaderVotes{View: le.view}
+		}
+		return &LeaderVotes{Votes: 1, View: le.view}
+	}
+
+	if req.View <= le.view {
+		le.lock.Unlock()
+		return &LeaderVotes{Votes: 1, View: le.view}
+	}
+	le.view = req.View
+	listeners := append([]func(uint64, *network.ServerIdentity){}, le.onChange...)
+	le.lock.Unlock()
+	leader := le.LeaderAt(req.View)
+	log.Lvl3(le.overlay.ServerIdentity(), "moved to view", req.View, "with leader", leader)
+	for _, f := range listeners {
+		f(req.View, leader)
+	}
+	return &LeaderVotes{Votes: 1, View: req.View}
+}
+
+func respondLeaderVote(n *TreeNodeInstance, req network.Message) (network.Message, error) {
+	le := n.overlay.leaderElection(n.Token().ServiceID, n.Roster())
+	return le.answer(req.(*LeaderVote)), nil
+}
+
+func reduceLeaderVotes(responses []network.Message) (network.Message, error) {
+	sum := &LeaderVotes{}
+	for _, r := range responses {
+		v := r.(*LeaderVotes)
+		sum.Votes += v.Votes
+		if v.View > sum.View {
+			sum.View = v.View
+		}
+	}
+	return sum, nil
+}
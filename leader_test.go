@@ -0,0 +1,97 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// registerLeaderService registers a service doing nothing, and returns the
+// contexts of its servers by their identities.
+func registerLeaderService(t *testing.T, name string) map[network.ServerIdentityID]*Context {
+	var lock sync.Mutex
+	contexts := make(map[network.ServerIdentityID]*Context)
+	_, err := RegisterNewService(name, func(c *Context) (Service, error) {
+		lock.Lock()
+		contexts[c.ServerIdentity().ID] = c
+		lock.Unlock()
+		return &signatureService{NewServiceProcessor(c)}, nil
+	})
+	require.NoError(t, err)
+	return contexts
+}
+
+func TestLeaderElection_Rotation(t *testing.T) {
+	contexts := registerLeaderService(t, "leaderTest")
+	defer UnregisterService("leaderTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(3, true)
+
+	le := contexts[servers[0].ServerIdentity.ID].LeaderElection(ro)
+	require.True(t, le == contexts[servers[0].ServerIdentity.ID].LeaderElection(ro))
+	require.Equal(t, uint64(0), le.View())
+	require.True(t, le.Leader().Equal(ro.List[0]))
+	for v := uint64(0); v < 6; v++ {
+		require.True(t, le.LeaderAt(v).Equal(ro.List[v%3]))
+	}
+}
+
+func TestLeaderElection_Reelect(t *testing.T) {
+	contexts := registerLeaderService(t, "leaderTest")
+	defer UnregisterService("leaderTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(4, true)
+
+	changes := make(chan uint64, len(servers))
+	for _, s := range servers {
+		contexts[s.ServerIdentity.ID].LeaderElection(ro).OnChange(func(view uint64, leader *network.ServerIdentity) {
+			require.True(t, leader.Equal(ro.List[1]))
+			changes <- view
+		})
+	}
+	leader, err := contexts[servers[2].ServerIdentity.ID].LeaderElection(ro).Reelect(time.Second)
+	require.NoError(t, err)
+	require.True(t, leader.Equal(ro.List[1]))
+	for range servers {
+		require.Equal(t, uint64(1), <-changes)
+	}
+	for _, s := range servers {
+		require.True(t, contexts[s.ServerIdentity.ID].LeaderElection(ro).Leader().Equal(ro.List[1]))
+	}
+}
+
+// Tests that the members only move to the next view when a majority of them
+// agrees that the leader failed, and that a closed member doesn't prevent it.
+func TestLeaderElection_Majority(t *testing.T) {
+	contexts := registerLeaderService(t, "leaderTest")
+	defer UnregisterService("leaderTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(5, true)
+
+	alive := func(*network.ServerIdentity) bool { return false }
+	for _, s := range servers[2:4] {
+		contexts[s.ServerIdentity.ID].LeaderElection(ro).SetFailureCheck(alive)
+	}
+	le := contexts[servers[1].ServerIdentity.ID].LeaderElection(ro)
+	require.NoError(t, servers[4].Close())
+	_, err := le.Reelect(time.Second)
+	require.Error(t, err)
+	for _, s := range servers[:4] {
+		require.Equal(t, uint64(0), contexts[s.ServerIdentity.ID].LeaderElection(ro).View())
+	}
+
+	contexts[servers[3].ServerIdentity.ID].LeaderElection(ro).SetFailureCheck(nil)
+	leader, err := le.Reelect(time.Second)
+	require.NoError(t, err)
+	require.True(t, leader.Equal(ro.List[1]))
+	// the commit is followed even by the members that voted against
+	for _, s := range servers[:4] {
+		require.Equal(t, uint64(1), contexts[s.ServerIdentity.ID].LeaderElection(ro).View())
+	}
+}
@@ -0,0 +1,166 @@
+package onet
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// ServiceLifecycle can be implemented by a service that wants to be told
+// about the phases of the server it runs on. A server goes through the
+// phases in order, and a service is only called once for each phase.
+type ServiceLifecycle interface {
+	// StartService is called by Server.Start before the server listens for
+	// connections.
+	StartService() error
+	// Ready is called once the server listens for connections from other
+	// servers and clients.
+	Ready()
+	// Drain is called when the server is about to shut down. New client
+	// requests are rejected from now on, but the protocols that are running
+	// can finish.
+	Drain()
+	// StopService is called by Server.Close, after the running protocols
+	// finished or the drain timeout expired, and before the database is
+	// closed.
+	StopService() error
+}
+
+// ErrDraining is returned when a service creates a protocol while the server
+// shuts down.
+var ErrDraining = xerrors.New("server is shutting down")
+
+// the phases of a server, in the order it goes through them
+const (
+	phaseCreated = iota
+	phaseStarted
+	phaseReady
+	phaseDraining
+	phaseStopped
+)
+
+// lifecycle calls fn for every service implementing ServiceLifecycle, and
+// then for the lifecycles given to NewServer.
+func (c *Server) lifecycle(phase string, fn func(ServiceLifecycle) error) {
+	c.serviceManager.servicesMutex.Lock()
+	services := make(map[string]Service)
+	for id, s := range c.serviceManager.services {
+		services[ServiceFactory.Name(id)] = s
+	}
+	c.serviceManager.servicesMutex.Unlock()
+	for name, s := range services {
+		if l, ok := s.(ServiceLifecycle); ok {
+			if err := fn(l); err != nil {
+				log.Errorf("%s of service %s failed: %v", phase, name, err)
+			}
+		}
+	}
+	for _, l := range c.lifecycles {
+		if err := fn(l); err != nil {
+			log.Errorf("%s of %T failed: %v", phase, l, err)
+		}
+	}
+}
+
+// enterPhase moves the server to the given phase and returns false if it
+// already went through it.
+func (c *Server) enterPhase(phase int) bool {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	if c.phase >= phase {
+		return false
+	}
+	c.phase = phase
+	return true
+}
+
+// Draining returns true once the server started to shut down. The
+// websocket rejects client requests while the server drains.
+func (c *Server) Draining() bool {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	return c.phase >= phaseDraining
+}
+
+// drain rejects new client requests and new protocol instances, tells the
+// services and waits up to timeout for the running protocols to finish. The
+// running protocols can still start sub-protocols. If the server is already
+// draining, it waits for the drain in progress, still up to timeout.
+func (c *Server) drain(timeout time.Duration) {
+	c.phaseLock.Lock()
+	first := c.phase < phaseDraining
+	if first {
+		c.phase = phaseDraining
+		c.drained = make(chan struct{})
+	}
+	drained := c.drained
+	c.phaseLock.Unlock()
+	if first {
+		c.lifecycle("Drain", func(l ServiceLifecycle) error {
+			l.Drain()
+			return nil
+		})
+		close(drained)
+	}
+
+	clock := c.Clock()
+	deadline := clock.Now().Add(timeout)
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-clock.After(timeout):
+			log.Lvl2(c.ServerIdentity, "drain timeout while the services drain")
+			return
+		}
+	}
+	for c.overlay.runningInstances() > 0 {
+		if !clock.Now().Before(deadline) {
+			log.Lvl2(c.ServerIdentity, "drain timeout with",
+				c.overlay.runningInstances(), "protocol instances left")
+			return
+		}
+		clock.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Shutdown drains the server during at most timeout, then closes it: the
+// services are stopped, the storage is flushed and closed, and the
+// connections to the clients and the other servers are closed. Start
+// returns once it's done.
+func (c *Server) Shutdown(timeout time.Duration) error {
+	c.drain(timeout)
+	if err := c.Close(); err != nil {
+		return xerrors.Errorf("closing: %v", err)
+	}
+	return nil
+}
+
+// ShutdownOnSignal shuts the server down gracefully, using Shutdown with the
+// given drain timeout, when the process receives SIGINT or SIGTERM.
+func (c *Server) ShutdownOnSignal(timeout time.Duration) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigs)
+		select {
+		case sig := <-sigs:
+			log.Lvl1("Got signal", sig, "- shutting down")
+			if err := c.Shutdown(timeout); err != nil {
+				log.Error("Shutting down:", err)
+			}
+		case <-c.closing:
+		}
+	}()
+}
+
+// runningInstances returns the number of protocol instances that didn't
+// finish yet.
+func (o *Overlay) runningInstances() int {
+	o.instancesLock.Lock()
+	defer o.instancesLock.Unlock()
+	return len(o.instances)
+}
@@ -0,0 +1,152 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const lifecycleServiceName = "lifecycleService"
+
+type lifecycleRequest struct{}
+
+type lifecycleService struct {
+	*ServiceProcessor
+	sync.Mutex
+	phases []string
+}
+
+func (s *lifecycleService) record(phase string) {
+	s.Lock()
+	defer s.Unlock()
+	s.phases = append(s.phases, phase)
+}
+
+func (s *lifecycleService) recorded() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.phases...)
+}
+
+func (s *lifecycleService) StartService() error { s.record("start"); return nil }
+func (s *lifecycleService) Ready()              { s.record("ready") }
+func (s *lifecycleService) Drain()              { s.record("drain") }
+func (s *lifecycleService) StopService() error  { s.record("stop"); return nil }
+
+func (s *lifecycleService) Request(req *lifecycleRequest) (*lifecycleRequest, error) {
+	return req, nil
+}
+
+func newLifecycleService(c *Context) (Service, error) {
+	s := &lifecycleService{ServiceProcessor: NewServiceProcessor(c)}
+	return s, s.RegisterHandler(s.Request)
+}
+
+// lifecycleProto finishes some time after it started.
+type lifecycleProto struct {
+	*TreeNodeInstance
+}
+
+func (p *lifecycleProto) Start() error {
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		p.Done()
+	}()
+	return nil
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	sid, err := RegisterNewService(lifecycleServiceName, newLifecycleService)
+	require.NoError(t, err)
+	defer UnregisterService(lifecycleServiceName)
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	s := local.GetServices(servers, sid)[0].(*lifecycleService)
+	require.Equal(t, []string{"start", "ready"}, s.recorded())
+
+	cl := NewClient(tSuite, lifecycleServiceName)
+	defer cl.Close()
+	si := servers[0].ServerIdentity
+	require.NoError(t, cl.SendProtobuf(si, &lifecycleRequest{}, &lifecycleRequest{}))
+
+	require.False(t, servers[0].Draining())
+	servers[0].drain(0)
+	require.True(t, servers[0].Draining())
+	require.Equal(t, []string{"start", "ready", "drain"}, s.recorded())
+	err = cl.SendProtobuf(si, &lifecycleRequest{}, &lifecycleRequest{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "shutting down")
+
+	require.NoError(t, servers[0].Close())
+	require.Equal(t, []string{"start", "ready", "drain", "stop"}, s.recorded())
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(1, true)
+	_, err := servers[0].ProtocolRegister("lifecycleProto", func(n *TreeNodeInstance) (ProtocolInstance, error) {
+		return &lifecycleProto{n}, nil
+	})
+	require.NoError(t, err)
+
+	pi, err := servers[0].overlay.CreateProtocol("lifecycleProto", tree, NilServiceID)
+	require.NoError(t, err)
+	require.NoError(t, pi.Start())
+	require.Equal(t, 1, servers[0].overlay.runningInstances())
+
+	// a drain that gave up doesn't make the shutdown skip the wait
+	servers[0].drain(0)
+	start := time.Now()
+	require.NoError(t, servers[0].Shutdown(5*time.Second))
+	require.True(t, time.Since(start) >= 300*time.Millisecond)
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, 0, servers[0].overlay.runningInstances())
+}
+
+func TestServer_ShutdownRefusesProtocols(t *testing.T) {
+	sid, err := RegisterNewService(lifecycleServiceName, newLifecycleService)
+	require.NoError(t, err)
+	defer UnregisterService(lifecycleServiceName)
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(1, true)
+	s := local.GetServices(servers, sid)[0].(*lifecycleService)
+	_, err = servers[0].ProtocolRegister("lifecycleProto", func(n *TreeNodeInstance) (ProtocolInstance, error) {
+		return &lifecycleProto{n}, nil
+	})
+	require.NoError(t, err)
+
+	pi, err := s.CreateProtocol("lifecycleProto", tree)
+	require.NoError(t, err)
+	require.NoError(t, pi.Start())
+	servers[0].drain(0)
+	_, err = s.CreateProtocol("lifecycleProto", tree)
+	require.Equal(t, ErrDraining, err)
+	// the running protocol goes on
+	require.Equal(t, 1, servers[0].overlay.runningInstances())
+}
+
+func TestServer_StartReturnsAfterClose(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	srv := local.newTCPServer(tSuite)
+
+	done := make(chan struct{})
+	go func() {
+		srv.Start()
+		close(done)
+	}()
+	srv.WaitStartup()
+	require.NoError(t, srv.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start didn't return")
+	}
+	require.False(t, srv.Router.Listening())
+	require.False(t, srv.WebSocket.Listening())
+}
@@ -0,0 +1,51 @@
+package onet
+
+import (
+	"go.dedis.ch/onet/v4/network"
+)
+
+type linkKey struct {
+	from, to network.ServerIdentityID
+}
+
+// SetLink emulates a slow or lossy link between two servers of the test, in
+// both directions, with the latency, bandwidth and loss of lc. A nil lc
+// restores the link. As with SetFaults, it should be called before the two
+// servers talk to each other, as the conditions only apply to new
+// connections. The other links are left as they are.
+func (l *LocalTest) SetLink(a, b *Server, lc *network.LinkConditions) {
+	l.panicClosed()
+	l.linksLock.Lock()
+	first := l.links == nil
+	if first {
+		l.links = make(map[linkKey]*network.LinkConditions)
+	}
+	ida, idb := a.ServerIdentity.ID, b.ServerIdentity.ID
+	if lc == nil {
+		delete(l.links, linkKey{ida, idb})
+		delete(l.links, linkKey{idb, ida})
+	} else {
+		l.links[linkKey{ida, idb}] = lc
+		l.links[linkKey{idb, ida}] = lc
+	}
+	l.linksLock.Unlock()
+	if first {
+		for _, srv := range l.Servers {
+			l.setServerLinks(srv)
+		}
+	}
+}
+
+func (l *LocalTest) setServerLinks(srv *Server) {
+	l.linksLock.Lock()
+	defer l.linksLock.Unlock()
+	if l.links == nil {
+		return
+	}
+	to := srv.ServerIdentity.ID
+	srv.Router.SetLinkConditions(func(remote *network.ServerIdentity) *network.LinkConditions {
+		l.linksLock.Lock()
+		defer l.linksLock.Unlock()
+		return l.links[linkKey{remote.ID, to}]
+	})
+}
@@ -0,0 +1,55 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestLocalTest_SetLink(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(3)
+	local.SetLink(servers[0], servers[1], &network.LinkConditions{Latency: 200 * time.Millisecond})
+	local.SetLink(servers[0], servers[2], &network.LinkConditions{Latency: time.Second})
+	local.SetLink(servers[0], servers[2], nil)
+
+	received := make([]chan int, len(servers))
+	listen := func(i int) {
+		ch := make(chan int, 10)
+		received[i] = ch
+		servers[i].RegisterProcessorFunc(partitionTestMsgID, func(env *network.Envelope) error {
+			ch <- env.Msg.(*partitionTestMsg).From
+			return nil
+		})
+	}
+	for i := range servers {
+		listen(i)
+	}
+	delay := func(from, to int) time.Duration {
+		start := time.Now()
+		_, err := servers[from].Send(servers[to].ServerIdentity, &partitionTestMsg{from})
+		require.NoError(t, err)
+		select {
+		case got := <-received[to]:
+			require.Equal(t, from, got)
+		case <-time.After(2 * time.Second):
+			require.Fail(t, "message lost", "from %d to %d", from, to)
+		}
+		return time.Since(start)
+	}
+
+	require.True(t, delay(0, 1) >= 200*time.Millisecond)
+	require.True(t, delay(1, 0) >= 200*time.Millisecond)
+	require.True(t, delay(0, 2) < 200*time.Millisecond)
+	require.True(t, delay(1, 2) < 200*time.Millisecond)
+
+	// the restarted servers keep their links
+	restarted, err := local.Restart(1)
+	require.NoError(t, err)
+	servers[1] = restarted
+	listen(1)
+	require.True(t, delay(0, 1) >= 200*time.Millisecond)
+}
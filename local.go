@@ -75,6 +75,18 @@ type LocalTest struct {
 
 	// keep the latestPort used so that we can add nodes later
 	latestPort int
+	// the faults injected in the messages of the servers
+	faults    func(from, to *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults
+	faultSeed int64
+	// the clock of the servers, nil for the real one
+	clock Clock
+	// the conditions of the links set by SetLink
+	links     map[linkKey]*network.LinkConditions
+	linksLock sync.Mutex
+	// the scheduler of the local connections set by SetScheduler
+	scheduler *network.Scheduler
+	// the servers in the order of their creation, for Restart
+	order []*Server
 }
 
 const (
@@ -133,6 +145,14 @@ func NewTCPTestWithTLS(s network.Suite, wsTLSCertificate []byte,
 	return t
 }
 
+// SetSharedMemory makes the local connections of the test pass the messages
+// by reference, checking one message in checkEvery, as with
+// network.LocalManager.SetSharedMemory. It must be called before the servers
+// are created.
+func (l *LocalTest) SetSharedMemory(checkEvery int) {
+	l.ctx.SetSharedMemory(true, checkEvery)
+}
+
 // StartProtocol takes a name and a tree and will create a
 // new Node with the protocol 'name' running from the tree-root
 func (l *LocalTest) StartProtocol(name string, t *Tree) (ProtocolInstance, error) {
@@ -346,6 +366,10 @@ func (l *LocalTest) CloseAll() {
 	}
 	sd.Wait()
 	l.Servers = map[network.ServerIdentityID]*Server{}
+	l.order = nil
+	if l.scheduler != nil {
+		l.scheduler.Stop()
+	}
 	l.ctx.Stop()
 
 	err := os.RemoveAll(l.path)
@@ -528,7 +552,7 @@ func newTCPServer(s network.Suite, port int, path string, wantsTLS bool) *Server
 
 	router := network.NewRouter(id, tcpHost)
 	router.UnauthOk = true
-	return newServer(s, path, router, priv)
+	return newServer(s, path, StorageConfig{}, router, priv)
 }
 
 // NewLocalServer returns a new server using a LocalRouter (channels) to communicate.
@@ -545,7 +569,7 @@ func NewLocalServer(s network.Suite, port int) *Server {
 	if err != nil {
 		panic(err)
 	}
-	h := newServer(s, dir, localRouter, priv)
+	h := newServer(s, dir, StorageConfig{}, localRouter, priv)
 	h.StartInBackground()
 	return h
 }
@@ -586,7 +610,7 @@ func (l *LocalTest) genLocalHosts(n int) []*Server {
 	return servers
 }
 
-func (l LocalTest) wantsTLS() bool {
+func (l *LocalTest) wantsTLS() bool {
 	return len(l.webSocketTLSCertificate) > 0 && len(l.webSocketTLSCertificateKey) > 0
 }
 
@@ -600,28 +624,9 @@ func (l *LocalTest) NewServer(s network.Suite, port int) *Server {
 	case TCP:
 		server = l.newTCPServer(s)
 		// Set TLS certificate if any configuration available
-		if l.wantsTLS() {
-			server.WebSocket.Lock()
-			if l.webSocketTLSReadFiles {
-				cr, err := NewCertificateReloader(
-					string(l.webSocketTLSCertificate),
-					string(l.webSocketTLSCertificateKey))
-				if err != nil {
-					log.Error("cannot configure TLS reloader", err)
-					return nil
-				}
-				server.WebSocket.TLSConfig = &tls.Config{
-					GetCertificate: cr.GetCertificateFunc(),
-				}
-
-			} else {
-				cert, err := tls.X509KeyPair(l.webSocketTLSCertificate, l.webSocketTLSCertificateKey)
-				if err != nil {
-					panic(err)
-				}
-				server.WebSocket.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
-			}
-			server.WebSocket.Unlock()
+		if err := l.setWebSocketTLS(server); err != nil {
+			log.Error("cannot configure TLS reloader", err)
+			return nil
 		}
 		server.StartInBackground()
 	default:
@@ -630,15 +635,43 @@ func (l *LocalTest) NewServer(s network.Suite, port int) *Server {
 	return server
 }
 
+// setWebSocketTLS configures the websocket of the server for TLS if the test
+// has a certificate.
+func (l *LocalTest) setWebSocketTLS(server *Server) error {
+	if !l.wantsTLS() {
+		return nil
+	}
+	server.WebSocket.Lock()
+	defer server.WebSocket.Unlock()
+	if l.webSocketTLSReadFiles {
+		cr, err := NewCertificateReloader(
+			string(l.webSocketTLSCertificate),
+			string(l.webSocketTLSCertificateKey))
+		if err != nil {
+			return xerrors.Errorf("certificate reloader: %v", err)
+		}
+		server.WebSocket.TLSConfig = &tls.Config{
+			GetCertificate: cr.GetCertificateFunc(),
+		}
+		return nil
+	}
+	cert, err := tls.X509KeyPair(l.webSocketTLSCertificate, l.webSocketTLSCertificateKey)
+	if err != nil {
+		panic(err)
+	}
+	server.WebSocket.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil
+}
+
 // NewTCPServer returns a new TCP Server attached to this LocalTest, configured
 // for TLS if possible (if anything in LocalTest.webSocketTLSCertificate/Key).
 func (l *LocalTest) newTCPServer(s network.Suite) *Server {
 	l.panicClosed()
 	server := newTCPServer(s, 0, l.path, l.wantsTLS())
-	l.Servers[server.ServerIdentity.ID] = server
-	l.Overlays[server.ServerIdentity.ID] = server.overlay
-	l.Services[server.ServerIdentity.ID] = server.serviceManager.services
-
+	l.setServerFaults(server)
+	l.setServerLinks(server)
+	server.SetClock(l.clock)
+	l.addServer(server)
 	return server
 }
 
@@ -651,12 +684,19 @@ func (l *LocalTest) NewLocalServer(s network.Suite, port int) *Server {
 	if err != nil {
 		panic(err)
 	}
-	server := newServer(s, l.path, localRouter, priv)
+	server := newServer(s, l.path, StorageConfig{}, localRouter, priv)
+	l.setServerFaults(server)
+	l.setServerLinks(server)
+	server.SetClock(l.clock)
 	server.StartInBackground()
+	l.addServer(server)
+	return server
+}
+
+// addServer records a new server of the test.
+func (l *LocalTest) addServer(server *Server) {
 	l.Servers[server.ServerIdentity.ID] = server
 	l.Overlays[server.ServerIdentity.ID] = server.overlay
 	l.Services[server.ServerIdentity.ID] = server.serviceManager.services
-
-	return server
-
+	l.order = append(l.order, server)
 }
@@ -106,6 +106,23 @@ func TestLocalTCPGenConnectableRoster(t *testing.T) {
 }
 
 // Tests whether TestClose is called in the service.
+func TestLocalTest_SetSharedMemory(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	local.SetSharedMemory(1)
+	servers, _, tree := local.GenBigTree(7, 7, 2, true)
+	require.True(t, servers[0].Router.SharedMemory())
+
+	pi, err := local.CreateProtocol(collectTestName, tree)
+	require.NoError(t, err)
+	p := pi.(*CollectProtocol)
+	p.Request = &collectCount{N: 2}
+	require.NoError(t, p.Start())
+	res := <-p.Result
+	require.NoError(t, res.Err)
+	require.Equal(t, 14, res.Response.(*collectCount).N)
+}
+
 func TestTestClose(t *testing.T) {
 	l := NewTCPTest(tSuite)
 	servers, _, _ := l.GenTree(1, true)
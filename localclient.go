@@ -0,0 +1,137 @@
+package onet
+
+import (
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// LocalClient calls the client handlers of a service on the servers of a
+// LocalTest directly. The requests go through the same checks and decoding
+// as the ones of the websocket, but without a connection, so that the API
+// of a service can be tested without opening ports, also with NewLocalTest.
+type LocalClient struct {
+	local    *LocalTest
+	service  string
+	identity *ClientIdentity
+}
+
+// NewLocalClient returns a client calling the handlers of the service.
+func (l *LocalTest) NewLocalClient(serviceName string) *LocalClient {
+	return &LocalClient{local: l, service: serviceName}
+}
+
+// SetIdentity lets the client call the handlers registered with
+// RegisterAuthenticatedHandler, as if it authenticated with the identity.
+// A nil identity is an anonymous client.
+func (c *LocalClient) SetIdentity(id *ClientIdentity) {
+	c.identity = id
+}
+
+// Send gives the encoded request to the handler of the path on the server,
+// and returns the encoded reply.
+func (c *LocalClient) Send(dst *network.ServerIdentity, path string, buf []byte) ([]byte, error) {
+	reply, tun, err := c.process(dst, path, buf)
+	if err != nil {
+		return nil, err
+	}
+	if tun != nil {
+		close(tun.close)
+		return nil, xerrors.New("streaming handler, use Stream: " + path)
+	}
+	return reply, nil
+}
+
+// SendProtobuf encodes the message, gives it to the handler of its type on
+// the server, and decodes the reply in ret, if it is not nil, like
+// Client.SendProtobuf.
+func (c *LocalClient) SendProtobuf(dst *network.ServerIdentity, msg interface{}, ret interface{}) error {
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+	reply, err := c.Send(dst, path, buf)
+	if err != nil {
+		return xerrors.Errorf("sending: %v", err)
+	}
+	if ret != nil {
+		err := network.Decode(reply, ret, c.local.Suite)
+		if err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+	}
+	return nil
+}
+
+// Stream gives the message to the streaming handler of its type on the
+// server, and returns the stream of its replies, like Client.Stream.
+func (c *LocalClient) Stream(dst *network.ServerIdentity, msg interface{}) (*LocalStreamingConn, error) {
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
+	_, tun, err := c.process(dst, path, buf)
+	if err != nil {
+		return nil, err
+	}
+	if tun == nil {
+		return nil, xerrors.New("not a streaming handler: " + path)
+	}
+	return &LocalStreamingConn{tun: tun, suite: c.local.Suite}, nil
+}
+
+// process builds the request the websocket of the server would get.
+func (c *LocalClient) process(dst *network.ServerIdentity, path string, buf []byte) ([]byte, *StreamingTunnel, error) {
+	c.local.panicClosed()
+	srv, ok := c.local.Servers[dst.ID]
+	if !ok {
+		return nil, nil, xerrors.Errorf("no server %v in the test", dst)
+	}
+	s, ok := srv.WebSocket.services[c.service]
+	if !ok {
+		return nil, nil, xerrors.New("no service " + c.service)
+	}
+	r := httptest.NewRequest("GET", "/"+c.service+"/"+path, nil)
+	if c.identity != nil {
+		r = withClientIdentity(r, c.identity)
+	}
+	h := wsHandler{service: s, serviceName: c.service, socket: srv.WebSocket}
+	return h.process(r, path, buf)
+}
+
+// LocalStreamingConn reads the replies of a streaming handler called by a
+// LocalClient.
+type LocalStreamingConn struct {
+	tun       *StreamingTunnel
+	suite     network.Suite
+	closeOnce sync.Once
+}
+
+// ReadMessage decodes the next reply in ret. It blocks if there is none, and
+// returns io.EOF once the service closed its channel.
+func (c *LocalStreamingConn) ReadMessage(ret interface{}) error {
+	buf, ok := <-c.tun.out
+	if !ok {
+		c.Close()
+		return io.EOF
+	}
+	err := network.Decode(buf, ret, c.suite)
+	if err != nil {
+		return xerrors.Errorf("decoding: %v", err)
+	}
+	return nil
+}
+
+// Close tells the service to stop streaming.
+func (c *LocalStreamingConn) Close() error {
+	c.closeOnce.Do(func() { close(c.tun.close) })
+	return nil
+}
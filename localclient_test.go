@@ -0,0 +1,79 @@
+package onet
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalClient(t *testing.T) {
+	var srv *clientAuthService
+	RegisterNewService(clientAuthServiceName, func(c *Context) (Service, error) {
+		srv = &clientAuthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterAuthenticatedHandler(srv.Secret)
+	})
+	defer UnregisterService(clientAuthServiceName)
+
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	si := local.GenServers(1)[0].ServerIdentity
+
+	reply := &authzMsg{}
+	cl := local.NewLocalClient(clientAuthServiceName)
+	require.Error(t, cl.SendProtobuf(si, &authzMsg{Val: 1}, reply))
+	cl.SetIdentity(&ClientIdentity{Name: "alice"})
+	require.NoError(t, cl.SendProtobuf(si, &authzMsg{Val: 1}, reply))
+	require.Equal(t, 2, reply.Val)
+	srv.Lock()
+	require.Equal(t, "alice", srv.client.Name)
+	srv.Unlock()
+
+	_, err := cl.Send(si, "unknown", nil)
+	require.Error(t, err)
+	_, err = local.NewLocalClient("unknown").Send(si, "authzMsg", nil)
+	require.Error(t, err)
+	_, err = cl.Stream(si, &authzMsg{Val: 1})
+	require.Error(t, err)
+}
+
+func TestLocalClient_Stream(t *testing.T) {
+	serName := "streamingService"
+	serID, err := RegisterNewService(serName, newStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, el, _ := local.GenTree(2, false)
+	si := servers[0].ServerIdentity
+	cl := local.NewLocalClient(serName)
+
+	n := 3
+	r := &SimpleRequest{ServerIdentities: el, Val: int64(n)}
+	_, err = cl.Send(si, "SimpleRequest", nil)
+	require.Error(t, err)
+	conn, err := cl.Stream(si, r)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		sr := &SimpleResponse{}
+		require.NoError(t, conn.ReadMessage(sr))
+		require.Equal(t, int64(n), sr.Val)
+	}
+	require.Equal(t, io.EOF, conn.ReadMessage(&SimpleResponse{}))
+
+	// closing early stops the service
+	service := local.GetServices(servers, serID)[0].(*StreamingService)
+	service.gotStopChan = make(chan bool, 1)
+	conn, err = cl.Stream(si, r)
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadMessage(&SimpleResponse{}))
+	require.NoError(t, conn.Close())
+	require.NoError(t, conn.Close())
+	select {
+	case <-service.gotStopChan:
+	case <-time.After(time.Second):
+		require.Fail(t, "should have got an early finish signal")
+	}
+}
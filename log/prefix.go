@@ -0,0 +1,85 @@
+package log
+
+import "fmt"
+
+// Prefix logs like the functions of the package, with the prefix in front of
+// the message, so that the output of the hosts running in the same process
+// can be told apart.
+type Prefix string
+
+func (p Prefix) lvld(l int, args ...interface{}) {
+	lvl(l, 3, append([]interface{}{"[" + string(p) + "]"}, args...)...)
+}
+
+func (p Prefix) lvlf(l int, f string, args ...interface{}) {
+	if l > DebugVisible() {
+		return
+	}
+	lvl(l, 3, "["+string(p)+"]", fmt.Sprintf(f, args...))
+}
+
+// Lvl1 is like the Lvl1 of the package, with the prefix.
+func (p Prefix) Lvl1(args ...interface{}) {
+	p.lvld(1, args...)
+}
+
+// Lvl2 is like the Lvl2 of the package, with the prefix.
+func (p Prefix) Lvl2(args ...interface{}) {
+	p.lvld(2, args...)
+}
+
+// Lvl3 is like the Lvl3 of the package, with the prefix.
+func (p Prefix) Lvl3(args ...interface{}) {
+	p.lvld(3, args...)
+}
+
+// Lvl4 is like the Lvl4 of the package, with the prefix.
+func (p Prefix) Lvl4(args ...interface{}) {
+	p.lvld(4, args...)
+}
+
+// Lvl5 is like the Lvl5 of the package, with the prefix.
+func (p Prefix) Lvl5(args ...interface{}) {
+	p.lvld(5, args...)
+}
+
+// Lvlf1 is like Lvl1 but with a format-string
+func (p Prefix) Lvlf1(f string, args ...interface{}) {
+	p.lvlf(1, f, args...)
+}
+
+// Lvlf2 is like Lvl2 but with a format-string
+func (p Prefix) Lvlf2(f string, args ...interface{}) {
+	p.lvlf(2, f, args...)
+}
+
+// Lvlf3 is like Lvl3 but with a format-string
+func (p Prefix) Lvlf3(f string, args ...interface{}) {
+	p.lvlf(3, f, args...)
+}
+
+// Lvlf4 is like Lvl4 but with a format-string
+func (p Prefix) Lvlf4(f string, args ...interface{}) {
+	p.lvlf(4, f, args...)
+}
+
+// Lvlf5 is like Lvl5 but with a format-string
+func (p Prefix) Lvlf5(f string, args ...interface{}) {
+	p.lvlf(5, f, args...)
+}
+
+// Warn is like the Warn of the package, with the prefix.
+func (p Prefix) Warn(args ...interface{}) {
+	p.lvld(lvlWarning, args...)
+}
+
+// Error is like the Error of the package, with the prefix.
+func (p Prefix) Error(args ...interface{}) {
+	last := len(args) - 1
+	if last >= 0 {
+		if err, ok := args[last].(error); ok {
+			args[last] = fmt.Sprintf("%+v", err)
+		}
+	}
+	p.lvld(lvlError, args...)
+}
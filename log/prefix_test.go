@@ -0,0 +1,24 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestPrefix(t *testing.T) {
+	SetDebugVisible(1)
+	GetStdOut()
+	GetStdErr()
+	p := Prefix("host-3")
+
+	p.Lvl1("starting", 1)
+	require.True(t, containsStdOut("[host-3] starting 1"))
+	p.Lvlf1("round %d", 2)
+	require.True(t, containsStdOut("[host-3] round 2"))
+	p.Lvl2("hidden")
+	require.False(t, containsStdOut("hidden"))
+	p.Error("failed:", xerrors.New("oops"))
+	require.True(t, containsStdErr("[host-3] failed: oops"))
+}
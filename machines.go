@@ -0,0 +1,109 @@
+package onet
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// splitList returns the values of a list separated by spaces or commas.
+func splitList(list string) []string {
+	return strings.Fields(strings.Replace(list, ",", " ", -1))
+}
+
+// SplitHosts returns how many of the hosts of the simulation run on each of
+// the machines. They are given by HostsPerMachine, or split in proportion
+// to MachineShares, else evenly, the first machines getting one more host if
+// they can't be split evenly.
+func (s *SimulationBFTree) SplitHosts(machines int) ([]int, error) {
+	if machines <= 0 {
+		return nil, xerrors.New("no machine")
+	}
+	counts := make([]int, machines)
+	switch {
+	case s.HostsPerMachine != "" && s.MachineShares != "":
+		return nil, xerrors.New("only one of HostsPerMachine and MachineShares can be set")
+	case s.HostsPerMachine != "":
+		list := splitList(s.HostsPerMachine)
+		if len(list) > machines {
+			return nil, xerrors.Errorf("HostsPerMachine has %d machines, but there are %d", len(list), machines)
+		}
+		var sum int
+		for i, v := range list {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, xerrors.Errorf("wrong number of hosts %q", v)
+			}
+			counts[i] = n
+			sum += n
+		}
+		if sum != s.Hosts {
+			return nil, xerrors.Errorf("HostsPerMachine has %d hosts instead of %d", sum, s.Hosts)
+		}
+	case s.MachineShares != "":
+		list := splitList(s.MachineShares)
+		if len(list) > machines {
+			return nil, xerrors.Errorf("MachineShares has %d machines, but there are %d", len(list), machines)
+		}
+		shares := make([]float64, len(list))
+		var sum float64
+		for i, v := range list {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return nil, xerrors.Errorf("wrong share %q", v)
+			}
+			shares[i] = f
+			sum += f
+		}
+		if sum == 0 {
+			return nil, xerrors.New("MachineShares are all zero")
+		}
+		// the hosts left after rounding down go to the largest remainders
+		remainders := make([]float64, len(list))
+		left := s.Hosts
+		for i, f := range shares {
+			exact := float64(s.Hosts) * f / sum
+			counts[i] = int(exact)
+			remainders[i] = exact - float64(counts[i])
+			left -= counts[i]
+		}
+		order := make([]int, len(list))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return remainders[order[i]] > remainders[order[j]]
+		})
+		for i := 0; i < left; i++ {
+			counts[order[i%len(order)]]++
+		}
+	default:
+		for i := range counts {
+			counts[i] = s.Hosts / machines
+			if i < s.Hosts%machines {
+				counts[i]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// AssignHosts returns the machine of every host, going through the machines
+// in turn and skipping the ones that already have all their hosts.
+func AssignHosts(counts []int) []int {
+	left := append([]int{}, counts...)
+	var machines []int
+	for more := true; more; {
+		more = false
+		for m := range left {
+			if left[m] > 0 {
+				machines = append(machines, m)
+				left[m]--
+				more = true
+			}
+		}
+	}
+	return machines
+}
@@ -0,0 +1,57 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestSimulationBFTree_SplitHosts(t *testing.T) {
+	split := func(s *SimulationBFTree, machines int) []int {
+		counts, err := s.SplitHosts(machines)
+		require.NoError(t, err)
+		return counts
+	}
+	require.Equal(t, []int{3, 3, 2}, split(&SimulationBFTree{Hosts: 8}, 3))
+	require.Equal(t, []int{1, 1, 0}, split(&SimulationBFTree{Hosts: 2}, 3))
+	require.Equal(t, []int{5, 2, 1, 0}, split(&SimulationBFTree{Hosts: 8, HostsPerMachine: "5, 2 1"}, 4))
+	require.Equal(t, []int{4, 2, 2}, split(&SimulationBFTree{Hosts: 8, MachineShares: "2 1 1"}, 3))
+	require.Equal(t, []int{5, 3, 2}, split(&SimulationBFTree{Hosts: 10, MachineShares: "1.5 1 0.5"}, 3))
+	require.Equal(t, []int{0, 7}, split(&SimulationBFTree{Hosts: 7, MachineShares: "0 1"}, 2))
+
+	for _, s := range []*SimulationBFTree{
+		{Hosts: 8, HostsPerMachine: "4 4", MachineShares: "1 1"},
+		{Hosts: 8, HostsPerMachine: "4 3"},
+		{Hosts: 8, HostsPerMachine: "4 2 2"},
+		{Hosts: 8, HostsPerMachine: "4 x"},
+		{Hosts: 8, MachineShares: "0 0"},
+		{Hosts: 8, MachineShares: "1 -1"},
+	} {
+		_, err := s.SplitHosts(2)
+		require.Error(t, err)
+	}
+	_, err := (&SimulationBFTree{Hosts: 8}).SplitHosts(0)
+	require.Error(t, err)
+}
+
+func TestAssignHosts(t *testing.T) {
+	require.Equal(t, []int{0, 1, 2, 0, 1, 0}, AssignHosts([]int{3, 2, 1}))
+	require.Equal(t, []int{1, 2, 1}, AssignHosts([]int{0, 2, 1}))
+	require.Equal(t, 0, len(AssignHosts([]int{0, 0})))
+}
+
+func TestSimulationBFTree_CreateRoster_shares(t *testing.T) {
+	sc := &SimulationConfig{}
+	sb := &SimulationBFTree{Hosts: 5, Suite: "Ed25519", HostsPerMachine: "3 2"}
+	sb.CreateRoster(sc, []string{"10.0.0.1", "10.0.0.2"}, 2000)
+	var addresses []network.Address
+	for _, si := range sc.Roster.List {
+		addresses = append(addresses, si.Address)
+	}
+	require.Equal(t, []network.Address{
+		"tcp://10.0.0.1:2000", "tcp://10.0.0.2:2000",
+		"tcp://10.0.0.1:2002", "tcp://10.0.0.2:2002",
+		"tcp://10.0.0.1:2004",
+	}, addresses)
+}
@@ -47,12 +47,20 @@ type ProtocolMsg struct {
 	ServerIdentity *network.ServerIdentity
 	// MsgType of the underlying data
 	MsgType network.MessageTypeID
-	// The interface to the actual Data
+	// The interface to the actual Data, also set by the sender instead of
+	// MsgSlice when the router shares memory
 	Msg network.Message
 	// The actual data as binary blob
 	MsgSlice []byte
 	// The size of the data
 	Size network.Size
+	// Clock is the causal past of the message, if the protocol uses causal
+	// clocks, and ClockTick tells whether it is a broadcast counted in it.
+	Clock     VectorClock
+	ClockTick bool
+	// LogicalTime is the Lamport time of the message, if the protocol uses
+	// LogicalTime.
+	LogicalTime uint64
 }
 
 // ConfigMsg is sent by the overlay containing a generic slice of bytes to
@@ -144,6 +152,10 @@ func (t *Token) ChangeTreeNodeID(newid TreeNodeID) *Token {
 type TreeNodeInfo struct {
 	To   *Token
 	From *Token
+	// the causal clock and the Lamport time of the message, see ProtocolMsg
+	Clock       VectorClock
+	ClockTick   bool
+	LogicalTime uint64
 }
 
 // OverlayMsg contains all routing-information about the tree and the
@@ -0,0 +1,91 @@
+package onet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// Migration brings the data of a service from the previous version to the
+// version it is registered with. The bucket is the one used by Context.Save
+// and Context.Load, and additional buckets of the service can be reached
+// through tx.
+type Migration func(tx StorageTx, bucket []byte) error
+
+type migrationStep struct {
+	version int
+	migrate Migration
+}
+
+var migrations = struct {
+	steps map[string][]migrationStep
+	sync.Mutex
+}{steps: make(map[string][]migrationStep)}
+
+// RegisterMigration registers the migration of the database of the service
+// to the given version. When a server starts, the migrations newer than the
+// version stored with Context.SaveVersion are applied in order, before the
+// service is instantiated. Each migration runs in its own transaction, which
+// also stores its version, so a failed migration leaves the database at the
+// previous version.
+//
+// It should be called in the same init function as RegisterNewService.
+func RegisterMigration(service string, version int, m Migration) error {
+	if version <= 0 {
+		return xerrors.New("versions must be positive")
+	}
+	migrations.Lock()
+	defer migrations.Unlock()
+	for _, s := range migrations.steps[service] {
+		if s.version == version {
+			return xerrors.Errorf("migration to version %d of %s already registered",
+				version, service)
+		}
+	}
+	steps := append(migrations.steps[service], migrationStep{version, m})
+	sort.Slice(steps, func(i, j int) bool { return steps[i].version < steps[j].version })
+	migrations.steps[service] = steps
+	return nil
+}
+
+// migrate applies the migrations of the service that are newer than the
+// version of its database.
+func (c *Context) migrate() error {
+	service := ServiceFactory.Name(c.serviceID)
+	migrations.Lock()
+	steps := append([]migrationStep{}, migrations.steps[service]...)
+	migrations.Unlock()
+	if len(steps) == 0 {
+		return nil
+	}
+
+	version, err := c.LoadVersion()
+	if err != nil {
+		return xerrors.Errorf("loading version: %v", err)
+	}
+	for _, s := range steps {
+		if s.version <= version {
+			continue
+		}
+		log.Lvlf2("Migrating %s from version %d to %d", service, version, s.version)
+		err := c.manager.storage.Update(func(tx StorageTx) error {
+			if err := s.migrate(tx, c.bucketName); err != nil {
+				return err
+			}
+			buf := bytes.NewBuffer(nil)
+			if err := binary.Write(buf, binary.LittleEndian, int32(s.version)); err != nil {
+				return xerrors.Errorf("int to bytes: %v", err)
+			}
+			return tx.Put(c.bucketVersionName, dbVersion, buf.Bytes())
+		})
+		if err != nil {
+			return xerrors.Errorf("migrating to version %d: %v", s.version, err)
+		}
+		version = s.version
+	}
+	return nil
+}
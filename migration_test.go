@@ -0,0 +1,63 @@
+package onet
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestContext_Migrate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	c := createContext(t, tmp)
+	defer func() {
+		migrations.Lock()
+		delete(migrations.steps, "testService")
+		migrations.Unlock()
+	}()
+	require.NoError(t, c.manager.storage.Update(func(tx StorageTx) error {
+		return tx.Put(c.bucketName, []byte("name"), []byte("old"))
+	}))
+
+	var order []int
+	rename := func(version int, value string) Migration {
+		return func(tx StorageTx, bucket []byte) error {
+			order = append(order, version)
+			return tx.Put(bucket, []byte("name"), []byte(value))
+		}
+	}
+	// registered out of order, applied in order
+	require.NoError(t, RegisterMigration("testService", 2, rename(2, "v2")))
+	require.NoError(t, RegisterMigration("testService", 1, rename(1, "v1")))
+	require.Error(t, RegisterMigration("testService", 1, rename(1, "v1")))
+	require.Error(t, RegisterMigration("testService", 0, rename(0, "v0")))
+
+	require.NoError(t, c.migrate())
+	require.Equal(t, []int{1, 2}, order)
+	v, err := c.LoadVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+	buf, err := c.LoadRaw([]byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(buf))
+
+	// already applied migrations aren't run again, and a failing one is
+	// rolled back
+	require.NoError(t, RegisterMigration("testService", 3, func(tx StorageTx, bucket []byte) error {
+		order = append(order, 3)
+		require.NoError(t, tx.Put(bucket, []byte("name"), []byte("v3")))
+		return xerrors.New("broken")
+	}))
+	require.Error(t, c.migrate())
+	require.Equal(t, []int{1, 2, 3}, order)
+	v, err = c.LoadVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+	buf, err = c.LoadRaw([]byte("name"))
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(buf))
+}
@@ -0,0 +1,311 @@
+package onet
+
+import (
+	"time"
+
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// SubsetDirectLimit is the number of targets up to which SendToRosterSubset
+// sends the message directly to every target instead of relaying it along a
+// spanning tree of the subset.
+const SubsetDirectLimit = 4
+
+// number of children of every node in the spanning tree of the subset
+const subsetFanout = 3
+
+// how long the origin waits for the delivery reports of a relayed message
+const defaultSubsetReportTimeout = 5 * time.Second
+
+// how long the ID of a relayed message is remembered to drop the replays
+const defaultSubsetDedupWindow = 10 * time.Minute
+
+// SubsetMsgID of SubsetMsg message as registered in network
+var SubsetMsgID = network.RegisterMessage(SubsetMsg{})
+
+// SubsetReportMsgID of SubsetReport message as registered in network
+var SubsetReportMsgID = network.RegisterMessage(SubsetReport{})
+
+// SubsetMsg carries a message to a subset of a roster. Every target delivers
+// the payload to its own processors, reports the delivery to the origin and
+// forwards the SubsetMsg to its children in the n-ary tree rooted at the
+// origin and built over the targets.
+type SubsetMsg struct {
+	ID      uuid.UUID
+	Origin  *network.ServerIdentity
+	Targets []*network.ServerIdentity
+	Fanout  int
+	// Payload is the marshaled message, including its type.
+	Payload []byte
+	// Signature is the Schnorr signature of the origin on all the fields
+	// above, as the relays could pose as another node, or send the message
+	// to other targets, otherwise.
+	Signature []byte
+}
+
+// SubsetReport is sent by every target of a SubsetMsg straight to the
+// origin once the payload has been delivered.
+type SubsetReport struct {
+	ID uuid.UUID
+}
+
+// subsetSeen is when a relayed message was first seen, in the order of the
+// seen messages so that the old ones are pruned from the front.
+type subsetSeen struct {
+	id   uuid.UUID
+	time time.Time
+}
+
+// subsetDelivery keeps track of the reports of a relayed message.
+type subsetDelivery struct {
+	pending map[network.ServerIdentityID]bool
+	done    chan struct{}
+}
+
+// SendToRosterSubset sends msg to the members of the roster given by ids.
+// Up to SubsetDirectLimit targets, the message is sent directly to each of
+// them. Above, it is only sent to a few of them that relay it along a
+// spanning tree of the subset, and every target reports the delivery to us.
+//
+// The returned map holds one entry per requested id: nil if the message has
+// been delivered, or the reason why it hasn't. The error is only set if the
+// message couldn't be sent at all.
+func (o *Overlay) SendToRosterSubset(ro *Roster, ids []network.ServerIdentityID, msg network.Message) (map[network.ServerIdentityID]error, error) {
+	if ro == nil {
+		return nil, xerrors.New("no roster given")
+	}
+	reports := make(map[network.ServerIdentityID]error)
+	own := o.ServerIdentity()
+	var targets []*network.ServerIdentity
+	for _, id := range ids {
+		if _, ok := reports[id]; ok {
+			continue
+		}
+		_, si := ro.Search(id)
+		if si == nil {
+			reports[id] = xerrors.New("not part of the roster")
+			continue
+		}
+		reports[id] = nil
+		if id.Equal(own.ID) {
+			if _, err := o.server.Send(own, msg); err != nil {
+				reports[id] = xerrors.Errorf("dispatching locally: %v", err)
+			}
+			continue
+		}
+		targets = append(targets, si)
+	}
+
+	if len(targets) <= SubsetDirectLimit {
+		for _, si := range targets {
+			if _, err := o.server.Send(si, msg); err != nil {
+				reports[si.ID] = xerrors.Errorf("sending: %v", err)
+			}
+		}
+		return reports, nil
+	}
+
+	payload, err := network.Marshal(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling: %v", err)
+	}
+	sm := &SubsetMsg{
+		ID:      uuid.NewV4(),
+		Origin:  own,
+		Targets: targets,
+		Fanout:  subsetFanout,
+		Payload: payload,
+	}
+	signed, err := subsetSigned(sm)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	if sm.Signature, err = o.signOrigin("subset", sm.ID, signed); err != nil {
+		return nil, xerrors.Errorf("signing: %v", err)
+	}
+	delivery := &subsetDelivery{
+		pending: make(map[network.ServerIdentityID]bool),
+		done:    make(chan struct{}),
+	}
+	for _, si := range targets {
+		delivery.pending[si.ID] = true
+	}
+	o.subsetDeliveriesLock.Lock()
+	o.subsetDeliveries[sm.ID] = delivery
+	o.subsetDeliveriesLock.Unlock()
+
+	if err := o.forwardSubsetMsg(sm); err != nil {
+		log.Lvl2("forwarding subset message:", err)
+	}
+
+	select {
+	case <-delivery.done:
+	case <-o.server.Clock().After(o.subsetReportTimeout):
+	}
+
+	o.subsetDeliveriesLock.Lock()
+	delete(o.subsetDeliveries, sm.ID)
+	for id := range delivery.pending {
+		reports[id] = xerrors.New("no delivery report before timeout")
+	}
+	o.subsetDeliveriesLock.Unlock()
+	return reports, nil
+}
+
+// subsetSigned returns what the origin of the message signs: the message
+// without its signature.
+func subsetSigned(sm *SubsetMsg) ([]byte, error) {
+	unsigned := *sm
+	unsigned.Signature = nil
+	return protobuf.Encode(&unsigned)
+}
+
+// markSubsetSeen returns true if the relayed message has not been seen
+// before. The entries older than the dedup window are pruned on the way.
+func (o *Overlay) markSubsetSeen(id uuid.UUID) bool {
+	o.subsetSeenLock.Lock()
+	defer o.subsetSeenLock.Unlock()
+	now := o.server.Clock().Now()
+	for len(o.subsetSeenOrder) > 0 && now.Sub(o.subsetSeenOrder[0].time) > defaultSubsetDedupWindow {
+		delete(o.subsetSeen, o.subsetSeenOrder[0].id)
+		o.subsetSeenOrder = o.subsetSeenOrder[1:]
+	}
+	if o.subsetSeen[id] {
+		return false
+	}
+	o.subsetSeen[id] = true
+	o.subsetSeenOrder = append(o.subsetSeenOrder, subsetSeen{id, now})
+	return true
+}
+
+// subsetTree returns the spanning tree used to relay the message.
+func subsetTree(sm *SubsetMsg) *Tree {
+	list := append([]*network.ServerIdentity{sm.Origin}, sm.Targets...)
+	ro := NewRoster(list)
+	if ro == nil {
+		return nil
+	}
+	return ro.GenerateNaryTreeWithRoot(sm.Fanout, sm.Origin)
+}
+
+// forwardSubsetMsg sends the message to our children in the spanning tree
+// of the subset.
+func (o *Overlay) forwardSubsetMsg(sm *SubsetMsg) error {
+	tree := subsetTree(sm)
+	if tree == nil {
+		return xerrors.New("invalid list of targets")
+	}
+	own := o.ServerIdentity()
+	for _, tn := range tree.List() {
+		if !tn.ServerIdentity.ID.Equal(own.ID) {
+			continue
+		}
+		for _, child := range tn.Children {
+			if _, err := o.server.Send(child.ServerIdentity, sm); err != nil {
+				log.Lvl2(own, "couldn't relay subset message to", child.ServerIdentity, ":", err)
+			}
+		}
+		return nil
+	}
+	return xerrors.New("not part of the targets")
+}
+
+// handleSubsetMsg relays the message further down the tree, delivers the
+// payload to our processors and reports it to the origin.
+func (o *Overlay) handleSubsetMsg(env *network.Envelope) {
+	sm, ok := env.Msg.(*SubsetMsg)
+	if !ok {
+		log.Error("not a subset message")
+		return
+	}
+	signed, err := subsetSigned(sm)
+	if err == nil {
+		err = o.verifyOrigin("subset", sm.Origin, sm.ID, signed, sm.Signature)
+	}
+	if err != nil {
+		log.Errorf("subset message from %s refused: %v", env.ServerIdentity, err)
+		return
+	}
+	if !o.markSubsetSeen(sm.ID) {
+		log.Lvl3("dropping the replay of subset message", sm.ID)
+		return
+	}
+	if err := o.forwardSubsetMsg(sm); err != nil {
+		log.Error("relaying subset message:", err)
+	}
+
+	typ, inner, err := network.Unmarshal(sm.Payload, o.suite())
+	if err != nil {
+		log.Error("unmarshaling subset payload:", err)
+		return
+	}
+	err = o.server.Dispatch(&network.Envelope{
+		ServerIdentity: sm.Origin,
+		MsgType:        typ,
+		Msg:            inner,
+		Size:           network.Size(len(sm.Payload)),
+	})
+	if err != nil {
+		log.Error("dispatching subset payload:", err)
+		return
+	}
+	if _, err := o.server.Send(sm.Origin, &SubsetReport{ID: sm.ID}); err != nil {
+		log.Lvl2("couldn't report subset delivery to", sm.Origin, ":", err)
+	}
+}
+
+// originMessage is what the origin of a relayed message signs: the kind of
+// the message, its ID, the identity and the address of the origin, and the
+// payload.
+func originMessage(kind string, origin *network.ServerIdentity, id uuid.UUID, payload []byte) []byte {
+	msg := append([]byte(kind), id.Bytes()...)
+	msg = append(msg, origin.ID[:]...)
+	msg = append(msg, origin.Address...)
+	return append(msg, payload...)
+}
+
+// signOrigin signs a message we send to be relayed by the other nodes.
+func (o *Overlay) signOrigin(kind string, id uuid.UUID, payload []byte) ([]byte, error) {
+	return schnorr.Sign(o.suite(), o.server.private,
+		originMessage(kind, o.ServerIdentity(), id, payload))
+}
+
+// verifyOrigin checks that a relayed message has been signed by its origin,
+// whose ID must be the one of its public key, so that the origin can be
+// given to the processors and answered.
+func (o *Overlay) verifyOrigin(kind string, origin *network.ServerIdentity, id uuid.UUID, payload, sig []byte) error {
+	if origin == nil || origin.Public == nil {
+		return xerrors.New("no origin")
+	}
+	if !network.NewServerIdentity(origin.Public, origin.Address).ID.Equal(origin.ID) {
+		return xerrors.New("the ID of the origin isn't the one of its key")
+	}
+	if err := schnorr.Verify(o.suite(), origin.Public, originMessage(kind, origin, id, payload), sig); err != nil {
+		return xerrors.Errorf("signature of the origin: %v", err)
+	}
+	return nil
+}
+
+// handleSubsetReport marks the sender of the report as delivered.
+func (o *Overlay) handleSubsetReport(env *network.Envelope) {
+	rep, ok := env.Msg.(*SubsetReport)
+	if !ok {
+		log.Error("not a subset report")
+		return
+	}
+	o.subsetDeliveriesLock.Lock()
+	defer o.subsetDeliveriesLock.Unlock()
+	delivery, ok := o.subsetDeliveries[rep.ID]
+	if !ok || !delivery.pending[env.ServerIdentity.ID] {
+		return
+	}
+	delete(delivery.pending, env.ServerIdentity.ID)
+	if len(delivery.pending) == 0 {
+		close(delivery.done)
+	}
+}
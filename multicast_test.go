@@ -0,0 +1,182 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+type subsetTestMsg struct {
+	Val int
+}
+
+var subsetTestMsgID = network.RegisterMessage(subsetTestMsg{})
+
+// setupSubset registers a processor counting the subsetTestMsg received by
+// every server.
+func setupSubset(t *testing.T, n int) (*LocalTest, []*Server, func(int) int) {
+	local := NewLocalTest(tSuite)
+	servers := local.GenServers(n)
+	var lock sync.Mutex
+	counts := make([]int, n)
+	for i, s := range servers {
+		i := i
+		s.overlay.subsetReportTimeout = time.Second
+		s.RegisterProcessorFunc(subsetTestMsgID, func(env *network.Envelope) error {
+			// only count messages that claim the right origin
+			if !env.ServerIdentity.ID.Equal(servers[0].ServerIdentity.ID) {
+				return nil
+			}
+			lock.Lock()
+			counts[i]++
+			lock.Unlock()
+			return nil
+		})
+	}
+	return local, servers, func(i int) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return counts[i]
+	}
+}
+
+func TestOverlay_SendToRosterSubsetDirect(t *testing.T) {
+	local, servers, count := setupSubset(t, 4)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	ids := []network.ServerIdentityID{servers[0].ServerIdentity.ID, servers[2].ServerIdentity.ID}
+	reports, err := servers[0].overlay.SendToRosterSubset(ro, ids, &subsetTestMsg{1})
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	for _, id := range ids {
+		require.NoError(t, reports[id])
+	}
+
+	require.Equal(t, 1, count(0))
+	for i := 0; i < 100 && count(2) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, count(2))
+	require.Equal(t, 0, count(1))
+	require.Equal(t, 0, count(3))
+}
+
+func TestOverlay_SendToRosterSubsetRelay(t *testing.T) {
+	local, servers, count := setupSubset(t, 10)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	var ids []network.ServerIdentityID
+	for _, s := range servers[2:] {
+		ids = append(ids, s.ServerIdentity.ID)
+	}
+	reports, err := servers[0].overlay.SendToRosterSubset(ro, ids, &subsetTestMsg{2})
+	require.NoError(t, err)
+	require.Len(t, reports, len(ids))
+	for _, id := range ids {
+		require.NoError(t, reports[id])
+	}
+	require.Equal(t, 0, count(0))
+	require.Equal(t, 0, count(1))
+	for i := 2; i < len(servers); i++ {
+		require.Equal(t, 1, count(i))
+	}
+}
+
+func TestOverlay_SendToRosterSubsetReports(t *testing.T) {
+	local, servers, count := setupSubset(t, 6)
+	defer local.CloseAll()
+
+	// The dead node is the first child of the root, so its subtree doesn't
+	// get the message either, and the unknown id is not in the roster.
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	_, unknown := NewPrivIdentity(tSuite, 9998)
+	list := []*network.ServerIdentity{servers[0].ServerIdentity, dead}
+	for _, s := range servers[1:] {
+		list = append(list, s.ServerIdentity)
+	}
+	ro := NewRoster(list)
+	var ids []network.ServerIdentityID
+	for _, si := range list[1:] {
+		ids = append(ids, si.ID)
+	}
+	ids = append(ids, unknown.ID)
+
+	reports, err := servers[0].overlay.SendToRosterSubset(ro, ids, &subsetTestMsg{3})
+	require.NoError(t, err)
+	require.Len(t, reports, len(ids))
+	require.Error(t, reports[dead.ID])
+	require.Error(t, reports[unknown.ID])
+	delivered := 0
+	for i, s := range servers[1:] {
+		if reports[s.ServerIdentity.ID] == nil {
+			require.Equal(t, 1, count(i+1))
+			delivered++
+		}
+	}
+	require.True(t, delivered > 0)
+}
+
+// TestOverlay_SubsetMsgOrigin checks that a node can't relay a message in the
+// name of another one.
+func TestOverlay_SubsetMsgOrigin(t *testing.T) {
+	local, servers, count := setupSubset(t, 3)
+	defer local.CloseAll()
+
+	payload, err := network.Marshal(&subsetTestMsg{4})
+	require.NoError(t, err)
+	sm := &SubsetMsg{
+		ID:      uuid.NewV4(),
+		Origin:  servers[0].ServerIdentity,
+		Targets: []*network.ServerIdentity{servers[1].ServerIdentity},
+		Fanout:  subsetFanout,
+		Payload: payload,
+	}
+	signed, err := subsetSigned(sm)
+	require.NoError(t, err)
+	// servers[2] isn't the origin and can't sign for it
+	sm.Signature, err = servers[2].overlay.signOrigin("subset", sm.ID, signed)
+	require.NoError(t, err)
+	_, err = servers[2].Send(servers[1].ServerIdentity, sm)
+	require.NoError(t, err)
+
+	// the origin's signature doesn't cover another payload
+	sm.Signature, err = servers[0].overlay.signOrigin("subset", sm.ID, signed)
+	require.NoError(t, err)
+	forged := *sm
+	forged.Payload, err = network.Marshal(&subsetTestMsg{5})
+	require.NoError(t, err)
+	_, err = servers[2].Send(servers[1].ServerIdentity, &forged)
+	require.NoError(t, err)
+	// nor other targets or another fanout
+	forged = *sm
+	forged.Targets = []*network.ServerIdentity{servers[1].ServerIdentity, servers[2].ServerIdentity}
+	_, err = servers[2].Send(servers[1].ServerIdentity, &forged)
+	require.NoError(t, err)
+	forged = *sm
+	forged.Fanout = 1
+	_, err = servers[2].Send(servers[1].ServerIdentity, &forged)
+	require.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 0, count(1))
+	require.Equal(t, 0, count(2))
+
+	// but it may be relayed by anyone
+	_, err = servers[2].Send(servers[1].ServerIdentity, sm)
+	require.NoError(t, err)
+	for i := 0; i < 100 && count(1) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, count(1))
+
+	// and is only delivered once
+	_, err = servers[2].Send(servers[1].ServerIdentity, sm)
+	require.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 1, count(1))
+}
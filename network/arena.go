@@ -0,0 +1,101 @@
+package network
+
+import (
+	"reflect"
+	"sync"
+)
+
+// arenaChunk is the size of the chunks of bytes of an arena.
+const arenaChunk = 64 * 1024
+
+// ArenaUnmarshaler is implemented by the pointers to the messages with their
+// own encoding that take the memory of the values they hold from the arena
+// when they are decoded by Arena.Unmarshal. It is used instead of
+// UnmarshalNetwork.
+type ArenaUnmarshaler interface {
+	UnmarshalArena(buf []byte, suite Suite, arena *Arena) error
+}
+
+// Arena decodes the messages like Unmarshal, but into the messages it
+// released before, so that decoding many small messages, like the ones of a
+// gossip, doesn't allocate a new message each time. The messages decoded
+// since the last Release are given back to the arena by Release, and must
+// not be used after.
+//
+// Only the top-level structs of the messages are pooled. The slices, the
+// pointers and the kyber values they hold are still allocated by protobuf
+// for every message, and left to the garbage collector by Release, unless
+// the message implements ArenaUnmarshaler and takes its memory from Bytes,
+// which is given back by Release too.
+type Arena struct {
+	free map[reflect.Type][]reflect.Value
+	used []reflect.Value
+	// chunks are the memory of Bytes, used up to offset in the last one
+	chunks [][]byte
+	chunk  int
+	offset int
+	lock   sync.Mutex
+}
+
+// NewArena returns an empty arena.
+func NewArena() *Arena {
+	return &Arena{free: make(map[reflect.Type][]reflect.Value)}
+}
+
+// Unmarshal is Unmarshal with the message taken from the arena.
+func (a *Arena) Unmarshal(buf []byte, suite Suite) (MessageTypeID, Message, error) {
+	return unmarshal(buf, suite, a)
+}
+
+// get returns a zero message of the type.
+func (a *Arena) get(typ reflect.Type) reflect.Value {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var ptr reflect.Value
+	if free := a.free[typ]; len(free) > 0 {
+		ptr = free[len(free)-1]
+		a.free[typ] = free[:len(free)-1]
+	} else {
+		ptr = reflect.New(typ)
+	}
+	a.used = append(a.used, ptr)
+	return ptr
+}
+
+// Bytes returns n zero bytes, that are given back to the arena by Release.
+func (a *Arena) Bytes(n int) []byte {
+	if n > arenaChunk {
+		return make([]byte, n)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.chunks) == 0 || a.offset+n > arenaChunk {
+		if len(a.chunks) > 0 {
+			a.chunk++
+		}
+		if a.chunk == len(a.chunks) {
+			a.chunks = append(a.chunks, make([]byte, arenaChunk))
+		}
+		a.offset = 0
+	}
+	b := a.chunks[a.chunk][a.offset : a.offset+n : a.offset+n]
+	a.offset += n
+	for i := range b {
+		b[i] = 0
+	}
+	return b
+}
+
+// Release gives the messages decoded and the bytes returned since the last
+// Release back to the arena.
+func (a *Arena) Release() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, ptr := range a.used {
+		// the interfaces are not reset by protobuf
+		ptr.Elem().Set(reflect.Zero(ptr.Type().Elem()))
+		a.free[ptr.Type().Elem()] = append(a.free[ptr.Type().Elem()], ptr)
+	}
+	a.used = a.used[:0]
+	a.chunk, a.offset = 0, 0
+}
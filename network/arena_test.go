@@ -0,0 +1,158 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// gossipMsg is a small message, as sent many times by a gossip
+type gossipMsg struct {
+	Round int64
+	Peer  string
+	Seen  []int64
+}
+
+var gossipMsgType = RegisterMessage(&gossipMsg{})
+
+// sketch takes its bytes from the arena
+type sketch struct {
+	Counts []byte
+}
+
+var sketchType = RegisterMessage(&sketch{})
+
+func (s *sketch) MarshalNetwork() ([]byte, error) {
+	return s.Counts, nil
+}
+
+func (s *sketch) UnmarshalNetwork(buf []byte, suite Suite) error {
+	s.Counts = append([]byte{}, buf...)
+	return nil
+}
+
+func (s *sketch) UnmarshalArena(buf []byte, suite Suite, arena *Arena) error {
+	if len(buf) == 0 {
+		return xerrors.New("empty sketch")
+	}
+	s.Counts = arena.Bytes(len(buf))
+	copy(s.Counts, buf)
+	return nil
+}
+
+func TestArena(t *testing.T) {
+	a := NewArena()
+	buf, err := Marshal(&gossipMsg{Round: 1, Peer: "a", Seen: []int64{1, 2}})
+	require.NoError(t, err)
+	id, msg, err := a.Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, gossipMsgType, id)
+	first := msg.(*gossipMsg)
+	require.Equal(t, &gossipMsg{Round: 1, Peer: "a", Seen: []int64{1, 2}}, first)
+
+	// a message is only reused after the release
+	_, msg, err = a.Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.False(t, first == msg.(*gossipMsg))
+	a.Release()
+	require.Equal(t, &gossipMsg{}, first)
+
+	buf, err = Marshal(&gossipMsg{Round: 2})
+	require.NoError(t, err)
+	_, msg, err = a.Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, &gossipMsg{Round: 2}, msg)
+	_, msg2, err := a.Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.True(t, msg.(*gossipMsg) == first || msg2.(*gossipMsg) == first)
+
+	_, _, err = a.Unmarshal([]byte{1, 2}, tSuite)
+	require.Error(t, err)
+}
+
+func TestArena_Bytes(t *testing.T) {
+	a := NewArena()
+	buf, err := Marshal(&sketch{Counts: []byte{1, 2, 3}})
+	require.NoError(t, err)
+	id, msg, err := a.Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, sketchType, id)
+	counts := msg.(*sketch).Counts
+	require.Equal(t, []byte{1, 2, 3}, counts)
+	require.Equal(t, 3, cap(counts))
+
+	// Unmarshal doesn't use the arena
+	_, msg, err = Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, msg.(*sketch).Counts)
+
+	buf, err = Marshal(&sketch{})
+	require.NoError(t, err)
+	_, _, err = a.Unmarshal(buf, tSuite)
+	require.Error(t, err)
+
+	a.Release()
+	b := a.Bytes(2)
+	require.Equal(t, []byte{0, 0}, b)
+	require.Equal(t, &counts[0], &b[0])
+	for i := 0; i < 3; i++ {
+		require.Equal(t, arenaChunk/2, len(a.Bytes(arenaChunk/2)))
+	}
+	require.Equal(t, 2*arenaChunk, len(a.Bytes(2*arenaChunk)))
+}
+
+func gossipBuffer(b *testing.B) []byte {
+	buf, err := Marshal(&gossipMsg{Round: 10, Peer: "tls://127.0.0.1:7770", Seen: []int64{1, 2, 3, 4}})
+	require.NoError(b, err)
+	return buf
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	msg := &gossipMsg{Round: 10, Peer: "tls://127.0.0.1:7770", Seen: []int64{1, 2, 3, 4}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	buf := gossipBuffer(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := Unmarshal(buf, tSuite); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkArena_Unmarshal(b *testing.B) {
+	buf := gossipBuffer(b)
+	a := NewArena()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := a.Unmarshal(buf, tSuite); err != nil {
+			b.Fatal(err)
+		}
+		if i%100 == 99 {
+			a.Release()
+		}
+	}
+}
+
+func BenchmarkArena_UnmarshalArena(b *testing.B) {
+	buf, err := Marshal(&sketch{Counts: make([]byte, 256)})
+	require.NoError(b, err)
+	a := NewArena()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := a.Unmarshal(buf, tSuite); err != nil {
+			b.Fatal(err)
+		}
+		if i%100 == 99 {
+			a.Release()
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// EncodeCanonical returns the protobuf encoding of the message, that is the
+// same for all the messages with the same values, so that it can be signed
+// and checked by another binary. Unlike protobuf.Encode, it sorts the
+// entries of the maps by their encoding, instead of writing them in the
+// random order of Go, and it writes the negative zeros of the floats as
+// zeros, and all the NaNs as the same NaN.
+//
+// As protobuf, it writes all the fields, also with the zero values, and the
+// nil and empty slices and maps the same, but not the nil pointers and the
+// pointers to a zero value. The values of the interfaces and of the structs
+// that encode themselves are written as their MarshalBinary returns them.
+// The output can be decoded with protobuf.Decode.
+func EncodeCanonical(msg interface{}) ([]byte, error) {
+	buf, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	typ := reflect.TypeOf(msg)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if err := canonicalize(buf, protoFieldTypes(typ), 1); err != nil {
+		return nil, xerrors.Errorf("canonical encoding: %v", err)
+	}
+	return buf, nil
+}
+
+// MarshalCanonical is Marshal with the message encoded by EncodeCanonical.
+// The messages implementing NetworkMarshaler are encoded by MarshalNetwork,
+// that must then be deterministic itself.
+func MarshalCanonical(msg Message) ([]byte, error) {
+	msgType := MessageType(msg)
+	if msgType == ErrorType {
+		return nil, xerrors.Errorf("type of message %s not registered to the network library", reflect.TypeOf(msg))
+	}
+	var buf []byte
+	var err error
+	if nm, ok := msg.(NetworkMarshaler); ok {
+		buf, err = nm.MarshalNetwork()
+	} else {
+		buf, err = EncodeCanonical(msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := new(bytes.Buffer)
+	if err := binary.Write(b, globalOrder, msgType); err != nil {
+		return nil, xerrors.Errorf("buffer write: %v", err)
+	}
+	b.Write(buf)
+	return b.Bytes(), nil
+}
+
+// protoFieldTypes returns the types of the fields of the struct that
+// protobuf encodes, by their IDs.
+func protoFieldTypes(typ reflect.Type) map[int64]reflect.Type {
+	fields := make(map[int64]reflect.Type)
+	for _, f := range protobuf.ProtoFields(typ) {
+		if f.Field.PkgPath == "" {
+			fields[f.ID] = f.Field.Type
+		}
+	}
+	return fields
+}
+
+// canonicalize rewrites the encoded message in place. As it only reorders
+// the map entries and changes the bits of the floats, the length of the
+// message and of the ones it holds doesn't change.
+func canonicalize(buf []byte, fields map[int64]reflect.Type, depth int) error {
+	if depth > MaxDecodeDepth {
+		return errTooDeep
+	}
+	// the entries of the current map, which protobuf writes one after
+	// the other
+	var run [][]byte
+	runID, runStart, pos := int64(0), 0, 0
+	flush := func() {
+		if len(run) > 1 {
+			sort.Slice(run, func(i, j int) bool { return bytes.Compare(run[i], run[j]) < 0 })
+			var sorted []byte
+			for _, e := range run {
+				sorted = append(sorted, e...)
+			}
+			copy(buf[runStart:], sorted)
+		}
+		run = nil
+	}
+	for pos < len(buf) {
+		id, w, rest, err := readWireField(buf[pos:])
+		if err != nil {
+			return err
+		}
+		end := len(buf) - len(rest)
+		if len(run) > 0 && id != runID {
+			flush()
+		}
+		typ, ok := fields[id]
+		for ok && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		switch {
+		case !ok:
+		case typ.Kind() == reflect.Map:
+			if w.wire != 2 {
+				return xerrors.New("map entry is not a message")
+			}
+			entry := map[int64]reflect.Type{1: typ.Key(), 2: typ.Elem()}
+			if err := canonicalize(w.bytes, entry, depth+1); err != nil {
+				return err
+			}
+			if len(run) == 0 {
+				runID, runStart = id, pos
+			}
+			// copied, as flush overwrites buf
+			run = append(run, append([]byte{}, buf[pos:end]...))
+		default:
+			if err := canonicalValue(buf[pos:end], typ, w, depth); err != nil {
+				return err
+			}
+		}
+		pos = end
+	}
+	flush()
+	return nil
+}
+
+// canonicalValue rewrites the field, that is not a map, in field.
+func canonicalValue(field []byte, typ reflect.Type, w wireValue, depth int) error {
+	switch typ.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if w.wire != 2 {
+			canonicalFloats(field[len(field)-floatSize(typ):], typ.Kind())
+		}
+	case reflect.Struct:
+		if w.wire != 2 || encodesItself(typ) {
+			return nil
+		}
+		return canonicalize(w.bytes, protoFieldTypes(typ), depth+1)
+	case reflect.Slice, reflect.Array:
+		elem := typ.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if w.wire != 2 {
+			return nil
+		}
+		switch elem.Kind() {
+		case reflect.Float32, reflect.Float64:
+			// the floats are packed
+			canonicalFloats(w.bytes, elem.Kind())
+		case reflect.Struct:
+			if !encodesItself(elem) {
+				return canonicalize(w.bytes, protoFieldTypes(elem), depth+1)
+			}
+		}
+	}
+	return nil
+}
+
+// encodesItself returns whether protobuf writes the struct without its
+// fields.
+func encodesItself(typ reflect.Type) bool {
+	return typ == reflect.TypeOf(time.Time{}) || typ.Implements(binaryMarshalerType)
+}
+
+func floatSize(typ reflect.Type) int {
+	if typ.Kind() == reflect.Float32 {
+		return 4
+	}
+	return 8
+}
+
+// canonicalFloats replaces the negative zeros and the NaNs of the little
+// endian floats in buf.
+func canonicalFloats(buf []byte, kind reflect.Kind) {
+	if kind == reflect.Float32 {
+		for i := 0; i+4 <= len(buf); i += 4 {
+			f := math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+			if f != f {
+				f = float32(math.NaN())
+			} else if f == 0 {
+				f = 0
+			}
+			binary.LittleEndian.PutUint32(buf[i:], math.Float32bits(f))
+		}
+		return
+	}
+	for i := 0; i+8 <= len(buf); i += 8 {
+		f := math.Float64frombits(binary.LittleEndian.Uint64(buf[i:]))
+		if math.IsNaN(f) {
+			f = math.NaN()
+		} else if f == 0 {
+			f = 0
+		}
+		binary.LittleEndian.PutUint64(buf[i:], math.Float64bits(f))
+	}
+}
@@ -0,0 +1,108 @@
+package network
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type canonicalInner struct {
+	Tags map[string]int64
+}
+
+type canonicalMsg struct {
+	Map    map[string][]byte
+	Inner  canonicalInner
+	List   []*canonicalInner
+	Nested map[int64]*canonicalInner
+	F      float64
+	Fs     []float32
+	Ints   []int64
+}
+
+var canonicalMsgType = RegisterMessage(&canonicalMsg{})
+
+func newCanonicalMsg(n int, zero float64) *canonicalMsg {
+	m := &canonicalMsg{
+		Map:    make(map[string][]byte),
+		Inner:  canonicalInner{Tags: make(map[string]int64)},
+		List:   []*canonicalInner{{Tags: make(map[string]int64)}},
+		Nested: make(map[int64]*canonicalInner),
+		F:      zero,
+		Fs:     []float32{float32(zero), 1},
+	}
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		m.Map[k] = []byte(k)
+		m.Inner.Tags[k] = int64(i)
+		m.List[0].Tags[k] = int64(i)
+		m.Nested[int64(i)] = &canonicalInner{Tags: map[string]int64{k: 1, "x": 2}}
+	}
+	return m
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	msg := newCanonicalMsg(20, 0)
+	first, err := EncodeCanonical(msg)
+	require.NoError(t, err)
+	plain, err := protobuf.Encode(msg)
+	require.NoError(t, err)
+	require.Equal(t, len(plain), len(first))
+
+	// protobuf writes the maps in a random order
+	random := false
+	for i := 0; i < 20; i++ {
+		buf, err := EncodeCanonical(msg)
+		require.NoError(t, err)
+		require.Equal(t, first, buf)
+		other, err := protobuf.Encode(msg)
+		require.NoError(t, err)
+		random = random || !bytes.Equal(plain, other)
+	}
+	require.True(t, random)
+
+	decoded := &canonicalMsg{}
+	require.NoError(t, protobuf.Decode(first, decoded))
+	require.Equal(t, msg, decoded)
+
+	// the same values give the same encoding
+	other := newCanonicalMsg(20, math.Copysign(0, -1))
+	other.Ints = []int64{}
+	buf, err := EncodeCanonical(other)
+	require.NoError(t, err)
+	require.Equal(t, first, buf)
+	nan1, err := EncodeCanonical(&canonicalMsg{F: math.NaN()})
+	require.NoError(t, err)
+	nan2, err := EncodeCanonical(&canonicalMsg{F: math.Float64frombits(0x7ff8000000000abc)})
+	require.NoError(t, err)
+	require.Equal(t, nan1, nan2)
+
+	// but a pointer to a zero value is written
+	buf, err = EncodeCanonical(&canonicalMsg{List: []*canonicalInner{{}}})
+	require.NoError(t, err)
+	empty, err := EncodeCanonical(&canonicalMsg{})
+	require.NoError(t, err)
+	require.NotEqual(t, empty, buf)
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	msg := newCanonicalMsg(10, 0)
+	buf, err := MarshalCanonical(msg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		other, err := MarshalCanonical(msg)
+		require.NoError(t, err)
+		require.Equal(t, buf, other)
+	}
+	id, decoded, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, canonicalMsgType, id)
+	require.Equal(t, msg, decoded)
+
+	_, err = MarshalCanonical(&canonicalInner{})
+	require.Error(t, err)
+}
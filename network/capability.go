@@ -0,0 +1,135 @@
+package network
+
+import (
+	"bytes"
+	"sort"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// Capability is a feature a server supports, like a compression or a newer
+// version of a message, so that its peers can adapt to it.
+type Capability struct {
+	Name    string
+	Version string
+}
+
+// CapabilityAnnouncement is sent by a router on every new connection, after
+// the handshake, with the capabilities it supports, and again to all its
+// connections when they change. The servers that don't know it drop it.
+type CapabilityAnnouncement struct {
+	Capabilities []Capability
+	// Registry is the hash of the schema of the message registry of the
+	// router, so that the peers encoding the messages differently are
+	// warned about.
+	Registry []byte
+}
+
+// CapabilityAnnouncementType is the type of CapabilityAnnouncement.
+var CapabilityAnnouncementType = RegisterMessage(&CapabilityAnnouncement{})
+
+// the most capabilities kept for a peer
+const maxCapabilities = 256
+
+// SetCapability advertises that the router supports the capability in the
+// version, which replaces its former version. The servers connected
+// afterwards are told in the handshake, and the ones already connected right
+// away. An empty version withdraws the capability.
+func (r *Router) SetCapability(name, version string) {
+	r.Lock()
+	if r.capabilities == nil {
+		r.capabilities = make(map[string]string)
+	}
+	if version == "" {
+		delete(r.capabilities, name)
+	} else {
+		r.capabilities[name] = version
+	}
+	var conns []Conn
+	for _, arr := range r.connections {
+		conns = append(conns, arr...)
+	}
+	r.Unlock()
+
+	ann := r.announcement()
+	for _, c := range conns {
+		if _, err := c.Send(ann); err != nil {
+			log.Lvl3(r.address, "couldn't announce the capabilities to", c.Remote(), ":", err)
+		}
+	}
+}
+
+// Capabilities returns the capabilities the router advertises, sorted by
+// their names.
+func (r *Router) Capabilities() []Capability {
+	return r.announcement().Capabilities
+}
+
+// PeerCapabilities returns the capabilities the remote server advertised,
+// sorted by their names. They are known once a connection was opened with it,
+// whichever side opened it. Nothing is returned for a server that didn't
+// advertise any, or runs a version that doesn't advertise them.
+func (r *Router) PeerCapabilities(id ServerIdentityID) []Capability {
+	r.Lock()
+	defer r.Unlock()
+	return sortedCapabilities(r.peerCapabilities[id])
+}
+
+// PeerCapability returns the version of the capability the remote server
+// advertised, and false if it didn't advertise it.
+func (r *Router) PeerCapability(id ServerIdentityID, name string) (string, bool) {
+	r.Lock()
+	defer r.Unlock()
+	version, ok := r.peerCapabilities[id][name]
+	return version, ok
+}
+
+// announcement returns the announcement of the capabilities of the router.
+func (r *Router) announcement() *CapabilityAnnouncement {
+	registry := RegistrySchema().Hash()
+	r.Lock()
+	defer r.Unlock()
+	return &CapabilityAnnouncement{
+		Capabilities: sortedCapabilities(r.capabilities),
+		Registry:     registry,
+	}
+}
+
+// announce sends the capabilities of the router and the hash of its
+// registry on a new connection.
+func (r *Router) announce(c Conn) (uint64, error) {
+	return c.Send(r.announcement())
+}
+
+// storeCapabilities replaces the capabilities of the remote server by the
+// ones it announced, and warns if its registry isn't the one of the router.
+func (r *Router) storeCapabilities(remote *ServerIdentity, ann *CapabilityAnnouncement) {
+	if ours := RegistrySchema().Hash(); len(ann.Registry) > 0 && !bytes.Equal(ann.Registry, ours) {
+		log.Warnf("%s has another message registry than %s (%x instead of %x): "+
+			"some of its messages may not be understood", remote.Address, r.address,
+			ann.Registry, ours)
+	}
+	caps := make(map[string]string)
+	for _, c := range ann.Capabilities {
+		if len(caps) == maxCapabilities {
+			log.Lvl2(remote.Address, "announced more than", maxCapabilities, "capabilities")
+			break
+		}
+		caps[c.Name] = c.Version
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.peerCapabilities == nil {
+		r.peerCapabilities = make(map[ServerIdentityID]map[string]string)
+	}
+	r.peerCapabilities[remote.ID] = caps
+}
+
+func sortedCapabilities(caps map[string]string) []Capability {
+	ret := make([]Capability, 0, len(caps))
+	for name, version := range caps {
+		ret = append(ret, Capability{Name: name, Version: version})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+)
+
+// waitCapability waits until the router knows the version of the capability
+// of the remote server.
+func waitCapability(t *testing.T, r *Router, remote *ServerIdentity, name, version string) {
+	for i := 0; i < 100; i++ {
+		if v, _ := r.PeerCapability(remote.ID, name); v == version {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Fail(t, "capability not announced", "%s %s", name, version)
+}
+
+func TestRouterCapabilities(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	h1.SetCapability("zip", "1")
+	h1.SetCapability("new", "2")
+	h2.SetCapability("zip", "2")
+	require.Equal(t, []Capability{{"new", "2"}, {"zip", "1"}}, h1.Capabilities())
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{1})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), (<-proc.relay).I)
+	// the capabilities come before the first message
+	v, ok := h2.PeerCapability(h1.ServerIdentity.ID, "zip")
+	require.True(t, ok)
+	require.Equal(t, "1", v)
+	require.Equal(t, 2, len(h2.PeerCapabilities(h1.ServerIdentity.ID)))
+	// and the ones of the other side come back
+	waitCapability(t, h1, h2.ServerIdentity, "zip", "2")
+
+	// the changes reach the open connections
+	h2.SetCapability("zip", "3")
+	waitCapability(t, h1, h2.ServerIdentity, "zip", "3")
+	h2.SetCapability("zip", "")
+	waitCapability(t, h1, h2.ServerIdentity, "zip", "")
+	_, ok = h1.PeerCapability(h2.ServerIdentity.ID, "zip")
+	require.False(t, ok)
+	require.Equal(t, 0, len(h2.Capabilities()))
+}
+
+// TestRouterCapabilities_registry checks that the hash of the registry is
+// announced, and that another one is warned about.
+func TestRouterCapabilities_registry(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	defer h1.Stop()
+	require.Equal(t, RegistrySchema().Hash(), h1.announcement().Registry)
+
+	log.OutputToBuf()
+	defer log.OutputToOs()
+	remote := NewTestServerIdentity(NewLocalAddress("127.0.0.1:2000"))
+	h1.storeCapabilities(remote, h1.announcement())
+	require.NotContains(t, log.GetStdOut()+log.GetStdErr(), "another message registry")
+	h1.storeCapabilities(remote, &CapabilityAnnouncement{Registry: []byte{1}})
+	require.Contains(t, log.GetStdOut()+log.GetStdErr(), "another message registry")
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// MaxDecodeDepth limits how deep the messages are nested when decoding a
+// type that can contain itself. The decoder is recursive, so without a limit
+// a crafted packet could exhaust its stack.
+var MaxDecodeDepth = 64
+
+// MaxDecodeElements limits the number of fields of a message, and so the
+// length of its slices and maps. Every field is decoded in a new value, so a
+// packet of small fields could allocate much more memory than its size.
+var MaxDecodeElements = 1 << 20
+
+// DecodeTimeout limits how long a packet may take to be received once its
+// size is read, so that a peer sending it slowly doesn't hold the memory of
+// the packet.
+var DecodeTimeout = 5 * time.Minute
+
+// errTooDeep is returned for the messages nested deeper than MaxDecodeDepth.
+var errTooDeep = xerrors.New("message nested too deep")
+
+// errTooManyElements is returned for the messages with more fields than
+// MaxDecodeElements.
+var errTooManyElements = xerrors.New("message with too many elements")
+
+// DecodeLimits holds the limits of the messages received by the conode. A
+// zero field keeps the current limit.
+type DecodeLimits struct {
+	// MaxMessageSize sets MaxPacketSize.
+	MaxMessageSize Size
+	// MaxDepth sets MaxDecodeDepth.
+	MaxDepth int
+	// MaxElements sets MaxDecodeElements.
+	MaxElements int
+	// Timeout sets DecodeTimeout.
+	Timeout time.Duration
+}
+
+// SetDecodeLimits sets the limits of all the messages received afterwards.
+// It should be called before the routers are started.
+func SetDecodeLimits(limits DecodeLimits) {
+	if limits.MaxMessageSize > 0 {
+		MaxPacketSize = limits.MaxMessageSize
+	}
+	if limits.MaxDepth > 0 {
+		MaxDecodeDepth = limits.MaxDepth
+	}
+	if limits.MaxElements > 0 {
+		MaxDecodeElements = limits.MaxElements
+	}
+	if limits.Timeout > 0 {
+		DecodeTimeout = limits.Timeout
+	}
+}
+
+// CheckDecode returns an error if the buffer is too big, too deep or has too
+// many elements to be decoded in a message of the type. It is called by Unmarshal, and should be
+// called before decoding the data of a client.
+func CheckDecode(buf []byte, typ reflect.Type) error {
+	if Size(len(buf)) > MaxPacketSize {
+		return xerrors.Errorf("message too big: %v>%v", len(buf), MaxPacketSize)
+	}
+	if fields, _, _ := walkFields(buf, nil); fields > MaxDecodeElements {
+		return errTooManyElements
+	}
+	if recursiveType(typ) {
+		if err := checkDepth(buf, 1); err != nil {
+			return xerrors.Errorf("checking depth: %v", err)
+		}
+	}
+	return nil
+}
+
+// the types that can contain themselves
+var recursiveTypes sync.Map
+
+// recursiveType returns whether a value of the type can contain another
+// value of the same type, so that its depth is only bounded by the data.
+func recursiveType(typ reflect.Type) bool {
+	if r, ok := recursiveTypes.Load(typ); ok {
+		return r.(bool)
+	}
+	r := containsCycle(typ, make(map[reflect.Type]bool), make(map[reflect.Type]bool))
+	recursiveTypes.Store(typ, r)
+	return r
+}
+
+// containsCycle goes through the structs contained in the type. visiting
+// holds the structs being gone through, and done the ones without cycle.
+func containsCycle(typ reflect.Type, visiting, done map[reflect.Type]bool) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return containsCycle(typ.Elem(), visiting, done)
+	case reflect.Map:
+		return containsCycle(typ.Key(), visiting, done) ||
+			containsCycle(typ.Elem(), visiting, done)
+	case reflect.Struct:
+		if visiting[typ] {
+			return true
+		}
+		if done[typ] {
+			return false
+		}
+		visiting[typ] = true
+		for i := 0; i < typ.NumField(); i++ {
+			if containsCycle(typ.Field(i).Type, visiting, done) {
+				return true
+			}
+		}
+		delete(visiting, typ)
+		done[typ] = true
+	}
+	return false
+}
+
+// checkDepth returns errTooDeep if the protobuf message in buf, at the
+// depth, holds messages nested deeper than MaxDecodeDepth, and
+// errTooManyElements if one of them has more than MaxDecodeElements fields.
+// As the wire format doesn't tell the nested messages from the bytes, every
+// field that can be decoded as a message is counted as one.
+func checkDepth(buf []byte, depth int) error {
+	_, _, err := walkFields(buf, func(field []byte) error {
+		fields, ok, _ := walkFields(field, nil)
+		if !ok {
+			return nil
+		}
+		if depth >= MaxDecodeDepth {
+			return errTooDeep
+		}
+		if fields > MaxDecodeElements {
+			return errTooManyElements
+		}
+		return checkDepth(field, depth+1)
+	})
+	return err
+}
+
+// walkFields goes through the fields of the protobuf message in buf, and
+// calls nested, if not nil, with the content of the non-empty
+// length-delimited fields. It returns the number of fields it went through,
+// and false if buf is not a message.
+func walkFields(buf []byte, nested func(field []byte) error) (int, bool, error) {
+	fields := 0
+	for ; len(buf) > 0; fields++ {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 || key>>3 == 0 {
+			return fields, false, nil
+		}
+		buf = buf[n:]
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(buf); n <= 0 {
+				return fields, false, nil
+			}
+			buf = buf[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(buf) < size {
+				return fields, false, nil
+			}
+			buf = buf[size:]
+		case 2:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || l > uint64(len(buf)-n) {
+				return fields, false, nil
+			}
+			field := buf[n : n+int(l)]
+			buf = buf[n+int(l):]
+			if nested != nil && len(field) > 0 {
+				if err := nested(field); err != nil {
+					return fields, false, err
+				}
+			}
+		default:
+			return fields, false, nil
+		}
+	}
+	return fields, true, nil
+}
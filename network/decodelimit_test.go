@@ -0,0 +1,141 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+type nestedMsg struct {
+	Val  int64
+	Next *nestedMsg
+}
+
+type nestedListMsg struct {
+	Children []nestedListMsg
+}
+
+var nestedMsgID = RegisterMessage(&nestedMsg{})
+
+func nested(depth int) *nestedMsg {
+	m := &nestedMsg{Val: 1}
+	for i := 1; i < depth; i++ {
+		m = &nestedMsg{Val: int64(i), Next: m}
+	}
+	return m
+}
+
+func TestRecursiveType(t *testing.T) {
+	require.True(t, recursiveType(reflect.TypeOf(nestedMsg{})))
+	require.True(t, recursiveType(reflect.TypeOf(nestedListMsg{})))
+	require.False(t, recursiveType(reflect.TypeOf(SimpleMessage{})))
+	require.False(t, recursiveType(reflect.TypeOf(ServerIdentity{})))
+}
+
+func TestCheckDecode(t *testing.T) {
+	defer func(d int) { MaxDecodeDepth = d }(MaxDecodeDepth)
+	MaxDecodeDepth = 10
+
+	buf, err := Marshal(nested(10))
+	require.NoError(t, err)
+	id, msg, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, nestedMsgID, id)
+	require.Equal(t, nested(10), msg)
+
+	buf, err = Marshal(nested(11))
+	require.NoError(t, err)
+	_, _, err = Unmarshal(buf, tSuite)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too deep")
+
+	// the other types are not checked
+	require.NoError(t, CheckDecode(bytes.Repeat([]byte{0x0a, 0x00}, 100),
+		reflect.TypeOf(SimpleMessage{})))
+	require.Error(t, CheckDecode(make([]byte, MaxPacketSize+1),
+		reflect.TypeOf(SimpleMessage{})))
+}
+
+func TestReadFrame(t *testing.T) {
+	frame := func(size Size, data []byte) *bytes.Buffer {
+		b := new(bytes.Buffer)
+		require.NoError(t, binary.Write(b, globalOrder, size))
+		b.Write(data)
+		return b
+	}
+	calls := 0
+	var limits []time.Time
+	deadline := func(limit time.Time) {
+		calls++
+		limits = append(limits, limit)
+	}
+	buf, read, err := readFrame(frame(3, []byte("abc")), deadline)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), buf)
+	require.Equal(t, uint64(7), read)
+	require.True(t, calls >= 2)
+	require.True(t, limits[0].IsZero())
+	require.False(t, limits[1].IsZero())
+	require.True(t, time.Until(limits[1]) <= DecodeTimeout)
+
+	_, read, err = readFrame(frame(4, []byte("abc")), deadline)
+	require.Error(t, err)
+	require.Equal(t, uint64(7), read)
+
+	_, _, err = readFrame(frame(MaxPacketSize+1, nil), deadline)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too big")
+	_, _, err = readFrame(bytes.NewBuffer([]byte{1, 2}), deadline)
+	require.Error(t, err)
+}
+
+func TestCheckDecode_Elements(t *testing.T) {
+	defer func(e int) { MaxDecodeElements = e }(MaxDecodeElements)
+	MaxDecodeElements = 10
+
+	list := func(n int) *nestedListMsg {
+		m := &nestedListMsg{}
+		for i := 0; i < n; i++ {
+			m.Children = append(m.Children, nestedListMsg{})
+		}
+		return m
+	}
+	typ := reflect.TypeOf(nestedListMsg{})
+	buf, err := protobuf.Encode(list(10))
+	require.NoError(t, err)
+	require.NoError(t, CheckDecode(buf, typ))
+	buf, err = protobuf.Encode(list(11))
+	require.NoError(t, err)
+	require.Equal(t, errTooManyElements, CheckDecode(buf, typ))
+
+	// also in the nested messages
+	buf, err = protobuf.Encode(&nestedListMsg{Children: []nestedListMsg{*list(11)}})
+	require.NoError(t, err)
+	err = CheckDecode(buf, typ)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too many elements")
+}
+
+func TestSetDecodeLimits(t *testing.T) {
+	defer func(s Size, d, e int, to time.Duration) {
+		MaxPacketSize, MaxDecodeDepth, MaxDecodeElements, DecodeTimeout = s, d, e, to
+	}(MaxPacketSize, MaxDecodeDepth, MaxDecodeElements, DecodeTimeout)
+
+	SetDecodeLimits(DecodeLimits{MaxMessageSize: 100, Timeout: time.Second})
+	require.Equal(t, Size(100), MaxPacketSize)
+	require.Equal(t, time.Second, DecodeTimeout)
+	require.Equal(t, 64, MaxDecodeDepth)
+	SetDecodeLimits(DecodeLimits{MaxDepth: 3, MaxElements: 4})
+	require.Equal(t, Size(100), MaxPacketSize)
+	require.Equal(t, 3, MaxDecodeDepth)
+	require.Equal(t, 4, MaxDecodeElements)
+
+	_, _, err := readFrame(bytes.NewBuffer([]byte{0, 0, 0, 101}), func(time.Time) {})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too big")
+}
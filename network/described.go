@@ -0,0 +1,421 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// DescribedMessage carries a message with the schema of its type, so that a
+// receiver that doesn't know the type, like a gateway or a debugging tool,
+// can still decode it with Decode. The receivers that know the type get the
+// message itself from Unmarshal, and never see the DescribedMessage. As the
+// schema is sent with every message, it is meant for debugging, and is
+// enabled with Router.Describe.
+type DescribedMessage struct {
+	// Type is the name of the type of the message and MsgType its ID.
+	Type    string
+	MsgType MessageTypeID
+	// Schema is the JSON encoding of the Schema of the structs used by the
+	// message.
+	Schema []byte
+	// Data is the protobuf encoding of the message.
+	Data []byte
+}
+
+// DescribedMessageType is the type of the DescribedMessages.
+var DescribedMessageType = RegisterMessage(&DescribedMessage{})
+
+// DescribeMessage returns the message with the schema of its type. The
+// messages with their own encoding can't be described.
+func DescribeMessage(msg Message) (*DescribedMessage, error) {
+	if lm, ok := msg.(*LazyMessage); ok {
+		var err error
+		if msg, err = lm.Decode(); err != nil {
+			return nil, xerrors.Errorf("decoding lazy message: %v", err)
+		}
+	}
+	typ := reflect.TypeOf(msg)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, xerrors.Errorf("not a struct: %v", typ)
+	}
+	if customEncoding(typ) {
+		return nil, xerrors.Errorf("%v has its own encoding", typ)
+	}
+	s := &Schema{Types: make(map[string][]SchemaField)}
+	name := s.typeName(typ)
+	schema, err := json.Marshal(s.Types)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding schema: %v", err)
+	}
+	data, err := protobuf.Encode(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	msgType := MessageType(msg)
+	if msgType == ErrorType {
+		msgType = computeMessageType(msg)
+	}
+	return &DescribedMessage{
+		Type:    name,
+		MsgType: msgType,
+		Schema:  schema,
+		Data:    data,
+	}, nil
+}
+
+// Message returns the message itself if its type is registered.
+func (d *DescribedMessage) Message(suite Suite) (Message, error) {
+	typ, ok := registry.get(d.MsgType)
+	if !ok {
+		return nil, xerrors.Errorf("type %s not registered", d.Type)
+	}
+	if err := CheckDecode(d.Data, typ); err != nil {
+		return nil, xerrors.Errorf("checking %s: %v", d.Type, err)
+	}
+	ptr := reflect.New(typ).Interface()
+	if err := Decode(d.Data, ptr, suite); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	return ptr, nil
+}
+
+// Decode decodes the message with its schema in a map from the names of its
+// fields to their values. The structs are maps too, the slices and arrays
+// are []interface{}, and the maps are keyed by the text of their keys. The
+// integers are int64 or uint64, and the bytes, interfaces, like the points
+// and scalars, and the structs that encode themselves are []byte. The bytes
+// of an interface start with the tag of its type, if it has one.
+func (d *DescribedMessage) Decode() (map[string]interface{}, error) {
+	types := make(map[string][]SchemaField)
+	if err := json.Unmarshal(d.Schema, &types); err != nil {
+		return nil, xerrors.Errorf("decoding schema: %v", err)
+	}
+	if Size(len(d.Data)) > MaxPacketSize {
+		return nil, xerrors.Errorf("message too big: %v>%v", len(d.Data), MaxPacketSize)
+	}
+	dec := genericDecoder{types: types}
+	m, err := dec.message(d.Type, d.Data, 1)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s: %v", d.Type, err)
+	}
+	return m, nil
+}
+
+// genericDecoder decodes protobuf messages with a schema instead of their
+// types.
+type genericDecoder struct {
+	types map[string][]SchemaField
+}
+
+// wireValue is a field as read from the protobuf wire format: the number of
+// the varint and fixed fields, or the bytes of the length-delimited ones.
+type wireValue struct {
+	wire  uint64
+	num   uint64
+	bytes []byte
+}
+
+// message decodes the struct called name.
+func (g genericDecoder) message(name string, buf []byte, depth int) (map[string]interface{}, error) {
+	if depth > MaxDecodeDepth {
+		return nil, errTooDeep
+	}
+	fields, ok := g.types[name]
+	if !ok {
+		return nil, xerrors.Errorf("type %s not in the schema", name)
+	}
+	byID := make(map[int64]SchemaField, len(fields))
+	for _, f := range fields {
+		byID[f.ID] = f
+	}
+	m := make(map[string]interface{})
+	for len(buf) > 0 {
+		id, w, rest, err := readWireField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		f, ok := byID[id]
+		if !ok {
+			// like protobuf, the unknown fields are skipped
+			continue
+		}
+		if err := g.field(m, f, w, depth); err != nil {
+			return nil, xerrors.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return m, nil
+}
+
+// field decodes the value of the field in m. The slices and maps get one
+// value per field, except for the packed numbers.
+func (g genericDecoder) field(m map[string]interface{}, f SchemaField, w wireValue, depth int) error {
+	if key, value, ok := mapTypes(f.Type); ok {
+		entry, err := g.entry(key, value, w, depth)
+		if err != nil {
+			return err
+		}
+		entries, _ := m[f.Name].(map[string]interface{})
+		if entries == nil {
+			entries = make(map[string]interface{})
+			m[f.Name] = entries
+		}
+		for k, v := range entry {
+			entries[k] = v
+		}
+		return nil
+	}
+	if elem, ok := elemType(f.Type); ok && baseKind(elem) != "uint8" {
+		list, _ := m[f.Name].([]interface{})
+		if size := packedSize(baseKind(elem)); size >= 0 && w.wire == 2 {
+			values, err := unpack(elem, w.bytes, size)
+			if err != nil {
+				return err
+			}
+			m[f.Name] = append(list, values...)
+			return nil
+		}
+		v, err := g.value(elem, w, depth)
+		if err != nil {
+			return err
+		}
+		m[f.Name] = append(list, v)
+		return nil
+	}
+	v, err := g.value(f.Type, w, depth)
+	if err != nil {
+		return err
+	}
+	m[f.Name] = v
+	return nil
+}
+
+// entry decodes a field of a map, that is a message with the key as field 1
+// and the value as field 2.
+func (g genericDecoder) entry(key, value string, w wireValue, depth int) (map[string]interface{}, error) {
+	if w.wire != 2 {
+		return nil, xerrors.New("map entry is not a message")
+	}
+	var k, v interface{}
+	buf := w.bytes
+	for len(buf) > 0 {
+		id, fw, rest, err := readWireField(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch id {
+		case 1:
+			k, err = g.value(key, fw, depth)
+		case 2:
+			v, err = g.value(value, fw, depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b, ok := k.([]byte); ok {
+		k = fmt.Sprintf("%x", b)
+	}
+	return map[string]interface{}{fmt.Sprint(k): v}, nil
+}
+
+// value decodes a value that is not a slice or a map.
+func (g genericDecoder) value(typ string, w wireValue, depth int) (interface{}, error) {
+	typ = strings.TrimLeft(typ, "*")
+	if _, ok := g.types[typ]; ok {
+		if w.wire != 2 {
+			return nil, xerrors.Errorf("%s is not a message", typ)
+		}
+		return g.message(typ, w.bytes, depth+1)
+	}
+	kind := baseKind(typ)
+	if elem, ok := elemType(typ); ok && baseKind(elem) == "uint8" {
+		kind = "bytes"
+	}
+	wire := uint64(2)
+	switch kind {
+	case "bool", "int", "int32", "int64", "uint32", "uint64":
+		wire = 0
+	case "sfixed32", "ufixed32", "float32":
+		wire = 5
+	case "sfixed64", "ufixed64", "float64":
+		wire = 1
+	}
+	if w.wire != wire {
+		return nil, xerrors.Errorf("%s has wire type %d instead of %d", typ, w.wire, wire)
+	}
+	switch kind {
+	case "bool":
+		return w.num != 0, nil
+	case "int", "int32", "int64":
+		return int64(w.num>>1) ^ -int64(w.num&1), nil
+	case "uint32", "uint64", "ufixed32", "ufixed64":
+		return w.num, nil
+	case "sfixed32":
+		return int64(int32(w.num)), nil
+	case "sfixed64":
+		if strings.HasPrefix(typ, "time.Time(") {
+			return time.Unix(0, int64(w.num)), nil
+		}
+		return int64(w.num), nil
+	case "float32":
+		return float64(math.Float32frombits(uint32(w.num))), nil
+	case "float64":
+		return math.Float64frombits(w.num), nil
+	case "string":
+		return string(w.bytes), nil
+	}
+	// the bytes, and the interfaces and structs that encode themselves
+	return w.bytes, nil
+}
+
+// unpack decodes the packed numbers, that are varints if size is 0.
+func unpack(elem string, buf []byte, size int) ([]interface{}, error) {
+	var values []interface{}
+	wire := map[int]uint64{0: 0, 4: 5, 8: 1}[size]
+	for len(buf) > 0 {
+		w := wireValue{wire: wire}
+		switch size {
+		case 0:
+			v, n := binary.Uvarint(buf)
+			if n <= 0 {
+				return nil, xerrors.New("invalid packed varint")
+			}
+			w.num, buf = v, buf[n:]
+		default:
+			if len(buf) < size {
+				return nil, xerrors.New("truncated packed number")
+			}
+			if size == 4 {
+				w.num = uint64(binary.LittleEndian.Uint32(buf))
+			} else {
+				w.num = binary.LittleEndian.Uint64(buf)
+			}
+			buf = buf[size:]
+		}
+		v, err := genericDecoder{}.value(elem, w, 0)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// packedSize returns the size of the numbers of the kind when they are
+// packed, 0 for varints, or -1 if they are not packed.
+func packedSize(kind string) int {
+	switch kind {
+	case "bool", "int", "int32", "int64", "uint32", "uint64":
+		return 0
+	case "sfixed32", "ufixed32", "float32":
+		return 4
+	case "sfixed64", "ufixed64", "float64":
+		return 8
+	}
+	return -1
+}
+
+// readWireField reads the first field of buf, and returns its number, its
+// value and the rest of buf.
+func readWireField(buf []byte) (int64, wireValue, []byte, error) {
+	key, n := binary.Uvarint(buf)
+	if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+		return 0, wireValue{}, nil, xerrors.New("invalid field key")
+	}
+	buf = buf[n:]
+	w := wireValue{wire: key & 7}
+	switch w.wire {
+	case 0:
+		w.num, n = binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, w, nil, xerrors.New("invalid varint")
+		}
+		buf = buf[n:]
+	case 1, 5:
+		size := 8
+		if w.wire == 5 {
+			size = 4
+		}
+		if len(buf) < size {
+			return 0, w, nil, xerrors.New("truncated number")
+		}
+		if size == 4 {
+			w.num = uint64(binary.LittleEndian.Uint32(buf))
+		} else {
+			w.num = binary.LittleEndian.Uint64(buf)
+		}
+		buf = buf[size:]
+	case 2:
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || l > uint64(len(buf)-n) {
+			return 0, w, nil, xerrors.New("truncated field")
+		}
+		w.bytes = buf[n : n+int(l)]
+		buf = buf[n+int(l):]
+	default:
+		return 0, w, nil, xerrors.Errorf("unknown wire type %d", w.wire)
+	}
+	return int64(key >> 3), w, buf, nil
+}
+
+// baseKind returns the kind given in parentheses after a named type of the
+// schema, or the type itself.
+func baseKind(typ string) string {
+	if i := strings.LastIndex(typ, "("); i >= 0 && strings.HasSuffix(typ, ")") {
+		return typ[i+1 : len(typ)-1]
+	}
+	return typ
+}
+
+// elemType returns the type of the elements of a slice or an array of the
+// schema.
+func elemType(typ string) (string, bool) {
+	if !strings.HasPrefix(typ, "[") {
+		return "", false
+	}
+	i := strings.Index(typ, "]")
+	if i < 0 {
+		return "", false
+	}
+	if i > 1 {
+		if _, err := strconv.Atoi(typ[1:i]); err != nil {
+			return "", false
+		}
+	}
+	return typ[i+1:], true
+}
+
+// mapTypes returns the types of the keys and the values of a map of the
+// schema.
+func mapTypes(typ string) (string, string, bool) {
+	if !strings.HasPrefix(typ, "map[") {
+		return "", "", false
+	}
+	depth := 0
+	for i := 3; i < len(typ); i++ {
+		switch typ[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return typ[4:i], typ[i+1:], true
+			}
+		}
+	}
+	return "", "", false
+}
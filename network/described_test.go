@@ -0,0 +1,119 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/protobuf"
+)
+
+// describedMsg is not registered, as the receivers of the tests don't know
+// it
+type describedMsg struct {
+	I      int64
+	U      uint32
+	B      bool
+	F      float64
+	S      string
+	Bytes  []byte
+	Ints   []int64
+	Strs   []string
+	Nested *SimpleMessage
+	List   []SimpleMessage
+	Map    map[string]int64
+	Time   time.Time
+	Point  kyber.Point
+	Size   Size
+	Array  [2]byte
+}
+
+func TestDescribedMessage(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	point := tSuite.Point().Pick(tSuite.RandomStream())
+	msg := &describedMsg{I: -3, U: 4, B: true, F: 1.5, S: "text", Bytes: []byte{1, 2},
+		Ints: []int64{-1, 2}, Strs: []string{"a", "b"}, Nested: &SimpleMessage{5},
+		List: []SimpleMessage{{6}, {7}}, Map: map[string]int64{"x": 8}, Time: now,
+		Point: point, Size: 9, Array: [2]byte{3, 4}}
+	d, err := DescribeMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, "network.describedMsg", d.Type)
+	buf, err := Marshal(d)
+	require.NoError(t, err)
+
+	id, got, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, DescribedMessageType, id)
+	m, err := got.(*DescribedMessage).Decode()
+	require.NoError(t, err)
+	pointBuf, err := point.MarshalBinary()
+	require.NoError(t, err)
+	tag := point.(protobuf.InterfaceMarshaler).MarshalID()
+	require.Equal(t, map[string]interface{}{
+		"I":      int64(-3),
+		"U":      uint64(4),
+		"B":      true,
+		"F":      1.5,
+		"S":      "text",
+		"Bytes":  []byte{1, 2},
+		"Ints":   []interface{}{int64(-1), int64(2)},
+		"Strs":   []interface{}{"a", "b"},
+		"Nested": map[string]interface{}{"I": int64(5)},
+		"List": []interface{}{map[string]interface{}{"I": int64(6)},
+			map[string]interface{}{"I": int64(7)}},
+		"Map":   map[string]interface{}{"x": int64(8)},
+		"Time":  now,
+		"Point": append(tag[:], pointBuf...),
+		"Size":  uint64(9),
+		"Array": []byte{3, 4},
+	}, m)
+
+	// a known type is decoded as itself
+	d, err = DescribeMessage(&SimpleMessage{10})
+	require.NoError(t, err)
+	buf, err = Marshal(d)
+	require.NoError(t, err)
+	id, got, err = Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, SimpleMessageType, id)
+	require.Equal(t, &SimpleMessage{10}, got)
+
+	_, err = DescribeMessage(3)
+	require.Error(t, err)
+	d.Data = []byte{0xff}
+	_, err = d.Decode()
+	require.Error(t, err)
+	_, err = d.Message(tSuite)
+	require.Error(t, err)
+}
+
+func TestRouterDescribe(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	h1.Describe = true
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	described := make(chan *DescribedMessage, 1)
+	h2.RegisterProcessorFunc(DescribedMessageType, func(env *Envelope) error {
+		described <- env.Msg.(*DescribedMessage)
+		return nil
+	})
+
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{3})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), (<-proc.relay).I)
+
+	_, err = h1.Send(h2.ServerIdentity, &describedMsg{S: "unknown"})
+	require.NoError(t, err)
+	m, err := (<-described).Decode()
+	require.NoError(t, err)
+	require.Equal(t, "unknown", m["S"])
+}
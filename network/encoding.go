@@ -7,8 +7,6 @@ import (
 	"reflect"
 	"sync"
 
-	"go.dedis.ch/kyber/v3/pairing/bn256"
-	"go.dedis.ch/kyber/v3/suites"
 	"golang.org/x/xerrors"
 
 	"go.dedis.ch/kyber/v3"
@@ -17,19 +15,6 @@ import (
 	uuid "gopkg.in/satori/go.uuid.v1"
 )
 
-func init() {
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteG1().Point() })
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteG1().Scalar() })
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteG2().Point() })
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteG2().Scalar() })
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteGT().Point() })
-	protobuf.RegisterInterface(func() interface{} { return bn256.NewSuiteGT().Scalar() })
-
-	ed25519 := suites.MustFind("Ed25519")
-	protobuf.RegisterInterface(func() interface{} { return ed25519.Point() })
-	protobuf.RegisterInterface(func() interface{} { return ed25519.Scalar() })
-}
-
 /// Encoding part ///
 
 // Suite functionalities used globally by the network library.
@@ -81,15 +66,30 @@ var globalOrder = binary.BigEndian
 
 // RegisterMessage registers any struct or ptr and returns the
 // corresponding MessageTypeID. Once a struct is registered, it can be sent and
-// received by the network library.
+// received by the network library. It panics if another type already has the
+// same MessageTypeID, as the types with the same package name and type name
+// do, so that they are not decoded one as the other: one of them must then
+// be registered with RegisterNamespacedMessage.
 func RegisterMessage(msg Message) MessageTypeID {
-	msgType := computeMessageType(msg)
+	return registerMessage(computeMessageType(msg), msg)
+}
+
+// RegisterNamespacedMessage registers the message like RegisterMessage, but
+// with a MessageTypeID that depends on the namespace too, usually the name
+// of the service, so that two services can have types with the same name.
+func RegisterNamespacedMessage(namespace string, msg Message) MessageTypeID {
+	return registerMessage(namespacedMessageType(namespace, msg), msg)
+}
+
+func registerMessage(msgType MessageTypeID, msg Message) MessageTypeID {
 	val := reflect.ValueOf(msg)
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
 	}
 	t := val.Type()
-	registry.put(msgType, t)
+	if err := registry.put(msgType, t); err != nil {
+		panic(err.Error())
+	}
 	return msgType
 }
 
@@ -106,29 +106,43 @@ func RegisterMessages(msg ...Message) []MessageTypeID {
 }
 
 func computeMessageType(msg Message) MessageTypeID {
+	return namespacedMessageType("", msg)
+}
+
+func namespacedMessageType(namespace string, msg Message) MessageTypeID {
 	val := reflect.ValueOf(msg)
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
 	}
 	url := NamespaceBodyType + val.Type().String()
+	if namespace != "" {
+		url = NamespaceBodyType + namespace + "/" + val.Type().String()
+	}
 	u := uuid.NewV5(uuid.NamespaceURL, url)
 	return MessageTypeID(u)
 }
 
 // MessageType returns a Message's MessageTypeID if registered or ErrorType if
-// the message has not been registered with RegisterMessage().
+// the message has not been registered with RegisterMessage(). A type
+// registered with more than one ID gets the first one.
 func MessageType(msg Message) MessageTypeID {
-	msgType := computeMessageType(msg)
-	_, ok := registry.get(msgType)
-	if !ok {
+	if lm, ok := msg.(*LazyMessage); ok {
+		return lm.MsgType
+	}
+	val := reflect.ValueOf(msg)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if !val.IsValid() {
 		return ErrorType
 	}
-	return msgType
+	return registry.id(val.Type())
 }
 
 // Marshal outputs the type and the byte representation of a structure.  It
 // first marshals the type as a uuid, i.e. a 16 byte length slice, then the
-// struct encoded by protobuf.  That slice of bytes can be then decoded with
+// struct encoded by protobuf, or by its MarshalNetwork if it implements
+// NetworkMarshaler.  That slice of bytes can be then decoded with
 // Unmarshal. msg must be a pointer to the message.
 func Marshal(msg Message) ([]byte, error) {
 	var msgType MessageTypeID
@@ -139,9 +153,18 @@ func Marshal(msg Message) ([]byte, error) {
 	if err := binary.Write(b, globalOrder, msgType); err != nil {
 		return nil, xerrors.Errorf("buffer write: %v", err)
 	}
+	// a lazy message is relayed without being decoded
+	if lm, ok := msg.(*LazyMessage); ok {
+		b.Write(lm.Data)
+		return b.Bytes(), nil
+	}
 	var buf []byte
 	var err error
-	if buf, err = protobuf.Encode(msg); err != nil {
+	if nm, ok := msg.(NetworkMarshaler); ok {
+		if buf, err = nm.MarshalNetwork(); err != nil {
+			return nil, xerrors.Errorf("encoding %T: %v", msg, err)
+		}
+	} else if buf, err = protobuf.Encode(msg); err != nil {
 		log.Errorf("Error for protobuf encoding: %s %+v", msg, err)
 		if log.DebugVisible() > 0 {
 			log.Error(log.Stack())
@@ -159,8 +182,16 @@ func Marshal(msg Message) ([]byte, error) {
 // resulting Message to a *pointer* of the underlying type, i.e. it returns a
 // pointer.  The type must be registered to the network library in order to be
 // decodable and the buffer must have been generated by Marshal otherwise it
-// returns an error.
+// returns an error. The messages of the types registered with
+// RegisterLazyMessage are returned as a *LazyMessage, and the ones
+// implementing NetworkUnmarshaler are decoded by their UnmarshalNetwork.
 func Unmarshal(buf []byte, suite Suite) (MessageTypeID, Message, error) {
+	return unmarshal(buf, suite, nil)
+}
+
+// unmarshal is Unmarshal with the message taken from the arena, if there is
+// one.
+func unmarshal(buf []byte, suite Suite, arena *Arena) (MessageTypeID, Message, error) {
 	b := bytes.NewBuffer(buf)
 	var tID MessageTypeID
 	if err := binary.Read(b, globalOrder, &tID); err != nil {
@@ -170,12 +201,45 @@ func Unmarshal(buf []byte, suite Suite) (MessageTypeID, Message, error) {
 	if !ok {
 		return ErrorType, nil, xerrors.Errorf("type %s not registered", tID.String())
 	}
-	ptrVal := reflect.New(typ)
+	if registry.isLazy(tID) {
+		return tID, &LazyMessage{MsgType: tID, Data: b.Bytes(), suite: suite}, nil
+	}
+	var ptrVal reflect.Value
+	if arena != nil {
+		ptrVal = arena.get(typ)
+	} else {
+		ptrVal = reflect.New(typ)
+	}
+	if au, ok := ptrVal.Interface().(ArenaUnmarshaler); ok && arena != nil {
+		if err := au.UnmarshalArena(b.Bytes(), suite, arena); err != nil {
+			return ErrorType, nil, xerrors.Errorf("decoding %s: %v", tID.String(), err)
+		}
+		return tID, au, nil
+	}
+	if nu, ok := ptrVal.Interface().(NetworkUnmarshaler); ok {
+		if err := nu.UnmarshalNetwork(b.Bytes(), suite); err != nil {
+			return ErrorType, nil, xerrors.Errorf("decoding %s: %v", tID.String(), err)
+		}
+		return tID, nu, nil
+	}
+	if err := CheckDecode(b.Bytes(), typ); err != nil {
+		return ErrorType, nil, xerrors.Errorf("checking %s: %v", tID.String(), err)
+	}
 	ptr := ptrVal.Interface()
-	constructors := DefaultConstructors(suite)
-	if err := protobuf.DecodeWithConstructors(b.Bytes(), ptr, constructors); err != nil {
+	if err := Decode(b.Bytes(), ptr, suite); err != nil {
 		return ErrorType, nil, xerrors.Errorf("decoding: %v", err)
 	}
+	// the receivers that know the type of a described message get the
+	// message itself
+	if d, ok := ptr.(*DescribedMessage); ok && !d.MsgType.Equal(DescribedMessageType) {
+		if _, known := registry.get(d.MsgType); known {
+			msg, err := d.Message(suite)
+			if err != nil {
+				return ErrorType, nil, xerrors.Errorf("described message: %v", err)
+			}
+			return d.MsgType, msg, nil
+		}
+	}
 	return tID, ptrVal.Interface(), nil
 }
 
@@ -186,6 +250,17 @@ func DumpTypes() {
 	}
 }
 
+// RegisteredMessages returns the types of all the registered messages.
+func RegisteredMessages() map[MessageTypeID]reflect.Type {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	types := make(map[MessageTypeID]reflect.Type, len(registry.types))
+	for id, t := range registry.types {
+		types[id] = t
+	}
+	return types
+}
+
 // DefaultConstructors gives a default constructor for protobuf out of the global suite
 func DefaultConstructors(suite Suite) protobuf.Constructors {
 	constructors := make(protobuf.Constructors)
@@ -202,12 +277,18 @@ var registry = newTypeRegistry()
 
 type typeRegistry struct {
 	types map[MessageTypeID]reflect.Type
-	lock  sync.Mutex
+	// ids are the first IDs of the types
+	ids map[reflect.Type]MessageTypeID
+	// lazy are the types decoded on demand
+	lazy map[MessageTypeID]bool
+	lock sync.Mutex
 }
 
 func newTypeRegistry() *typeRegistry {
 	return &typeRegistry{
 		types: make(map[MessageTypeID]reflect.Type),
+		ids:   make(map[reflect.Type]MessageTypeID),
+		lazy:  make(map[MessageTypeID]bool),
 		lock:  sync.Mutex{},
 	}
 }
@@ -221,9 +302,43 @@ func (tr *typeRegistry) get(mid MessageTypeID) (reflect.Type, bool) {
 	return t, ok
 }
 
-// put stores the given type in the typeRegistry.
-func (tr *typeRegistry) put(mid MessageTypeID, typ reflect.Type) {
+// isLazy returns whether the type is decoded on demand.
+func (tr *typeRegistry) isLazy(mid MessageTypeID) bool {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	return tr.lazy[mid]
+}
+
+// setLazy has the type decoded on demand.
+func (tr *typeRegistry) setLazy(mid MessageTypeID) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.lazy[mid] = true
+}
+
+// id returns the MessageTypeID of the type, or ErrorType if it is not
+// registered.
+func (tr *typeRegistry) id(typ reflect.Type) MessageTypeID {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	if mid, ok := tr.ids[typ]; ok {
+		return mid
+	}
+	return ErrorType
+}
+
+// put stores the given type in the typeRegistry, or returns an error if
+// another type has the same MessageTypeID.
+func (tr *typeRegistry) put(mid MessageTypeID, typ reflect.Type) error {
 	tr.lock.Lock()
 	defer tr.lock.Unlock()
+	if old, ok := tr.types[mid]; ok && old != typ {
+		return xerrors.Errorf("types %v (%s) and %v (%s) have the same MessageTypeID %s",
+			old, old.PkgPath(), typ, typ.PkgPath(), uuid.UUID(mid))
+	}
 	tr.types[mid] = typ
+	if _, ok := tr.ids[typ]; !ok {
+		tr.ids[typ] = mid
+	}
+	return nil
 }
@@ -61,9 +61,49 @@ func TestRegisterMessages(t *testing.T) {
 	types := RegisterMessages(&TestRegisterS1{}, &TestRegisterS2{})
 	assert.True(t, MessageType(&TestRegisterS1{}).Equal(types[0]))
 	assert.True(t, MessageType(&TestRegisterS2{}).Equal(types[1]))
+	registered := RegisteredMessages()
+	assert.Equal(t, 2, len(registered))
+	assert.Equal(t, "TestRegisterS1", registered[types[0]].Name())
 	registry = oldRegistry
 }
 
+func TestRegisterMessage_Collision(t *testing.T) {
+	oldRegistry := registry
+	registry = newTypeRegistry()
+	defer func() { registry = oldRegistry }()
+
+	// both of the types are named network.dup
+	first := func() Message {
+		type dup struct{ A int64 }
+		return &dup{}
+	}()
+	second := func() Message {
+		type dup struct{ B string }
+		return &dup{}
+	}()
+	id := RegisterMessage(first)
+	require.Equal(t, id, RegisterMessage(first))
+	require.Panics(t, func() { RegisterMessage(second) })
+	require.Equal(t, ErrorType, MessageType(second))
+
+	other := RegisterNamespacedMessage("other", second)
+	require.NotEqual(t, id, other)
+	require.Equal(t, other, RegisterNamespacedMessage("other", second))
+	require.Equal(t, other, MessageType(second))
+	require.Equal(t, id, MessageType(first))
+
+	buf, err := Marshal(second)
+	require.NoError(t, err)
+	msgType, msg, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, other, msgType)
+	require.Equal(t, second, msg)
+
+	// the first ID of a type is used to send it
+	require.NotEqual(t, id, RegisterNamespacedMessage("again", first))
+	require.Equal(t, id, MessageType(first))
+}
+
 func TestUnmarshalRegister(t *testing.T) {
 	trType := RegisterMessage(&TestRegisterS1{})
 	buff, err := Marshal(&TestRegisterS1{10})
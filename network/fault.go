@@ -0,0 +1,267 @@
+package network
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// how long a reordered message waits for the next message before it is
+// delivered anyway
+const reorderTimeout = 100 * time.Millisecond
+
+// Faults are the probabilities of the faults injected in the messages a
+// Router receives, once SetFaults has been called. They are useful to test
+// how the protocols resist to a network that misbehaves.
+type Faults struct {
+	// Drop is the probability that the message is lost.
+	Drop float64
+	// Duplicate is the probability that the message is delivered twice.
+	Duplicate float64
+	// Delay is the probability that the message is held back for up to
+	// MaxDelay, so that the following messages overtake it.
+	Delay    float64
+	MaxDelay time.Duration
+	// Reorder is the probability that the message is swapped with the next
+	// one.
+	Reorder float64
+	// Corrupt is the probability that a byte of the encoded message
+	// changes. If the message can't be decoded anymore, it is dropped like
+	// any message that fails to decode.
+	Corrupt float64
+}
+
+// FaultFunc returns the faults of a message received from the remote server,
+// or nil to leave it alone.
+type FaultFunc func(remote *ServerIdentity, env *Envelope) *Faults
+
+// SetFaults injects faults in the messages received on the connections that
+// are created afterwards. The faults of every link are drawn from their own
+// source, seeded from seed and the addresses of the link, so that a run can
+// be reproduced. If seed is 0, the runs are random.
+func (r *Router) SetFaults(seed int64, f FaultFunc) {
+	r.Lock()
+	defer r.Unlock()
+	r.faults = f
+	r.faultSeed = seed
+}
+
+// faultConn returns the connection injecting the faults of the messages from
+// the remote server.
+func (r *Router) faultConn(remote *ServerIdentity, c Conn) Conn {
+	r.Lock()
+	f, seed := r.faults, r.faultSeed
+	r.Unlock()
+	if f == nil {
+		return c
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(r.address.String() + "-" + remote.Address.String()))
+		seed ^= int64(h.Sum64())
+	}
+	return newFaultyConn(c, remote, f, rand.New(rand.NewSource(seed)))
+}
+
+// faultyConn injects faults in the messages received on a connection. A
+// go-routine receives the messages and queues the ones to deliver, so that the
+// delayed messages can be delivered while waiting for the next ones.
+type faultyConn struct {
+	Conn
+	remote *ServerIdentity
+	faults FaultFunc
+	rand   *rand.Rand
+	queue  chan linkItem
+	// closed is closed to stop the delivery of the messages
+	closed    chan struct{}
+	closeOnce sync.Once
+	// held is the message waiting to be reordered with the next one, and
+	// heldTimer delivers it if the next one doesn't come
+	held      *Envelope
+	heldTimer *time.Timer
+	sync.Mutex
+}
+
+func newFaultyConn(c Conn, remote *ServerIdentity, f FaultFunc, r *rand.Rand) *faultyConn {
+	fc := &faultyConn{
+		Conn:   c,
+		remote: remote,
+		faults: f,
+		rand:   r,
+		queue:  make(chan linkItem, linkQueueSize),
+		closed: make(chan struct{}),
+	}
+	go fc.read()
+	return fc
+}
+
+// read receives the messages of the connection and injects their faults.
+func (fc *faultyConn) read() {
+	for {
+		env, err := fc.Conn.Receive()
+		if err != nil {
+			if !fc.deliver(linkItem{env: env, err: err}) || closingError(err) {
+				return
+			}
+			continue
+		}
+		fc.inject(env)
+	}
+}
+
+// inject delivers the message with its faults.
+func (fc *faultyConn) inject(env *Envelope) {
+	f := fc.faults(fc.remote, env)
+	if f == nil {
+		fc.deliver(linkItem{env: env})
+		fc.deliverHeld()
+		return
+	}
+	fc.Lock()
+	drop := fc.rand.Float64() < f.Drop
+	dup := fc.rand.Float64() < f.Duplicate
+	corrupt := fc.rand.Float64() < f.Corrupt
+	delay := time.Duration(0)
+	if fc.rand.Float64() < f.Delay && f.MaxDelay > 0 {
+		delay = time.Duration(fc.rand.Int63n(int64(f.MaxDelay)))
+	}
+	reorder := fc.rand.Float64() < f.Reorder && fc.held == nil
+	fc.Unlock()
+
+	if drop {
+		return
+	}
+	item := linkItem{env: env}
+	if corrupt {
+		item = fc.corrupt(env)
+	}
+	items := []linkItem{item}
+	if dup {
+		items = append(items, item)
+	}
+	switch {
+	case delay > 0:
+		time.AfterFunc(delay, func() {
+			for _, it := range items {
+				fc.deliver(it)
+			}
+		})
+	case reorder && item.env != nil:
+		// the message is delivered after the next one
+		fc.Lock()
+		fc.held = item.env
+		fc.heldTimer = time.AfterFunc(reorderTimeout, fc.deliverHeld)
+		fc.Unlock()
+		if dup {
+			fc.deliver(item)
+		}
+	default:
+		for _, it := range items {
+			fc.deliver(it)
+		}
+		fc.deliverHeld()
+	}
+}
+
+// corrupt changes a byte of the encoded message and decodes it again.
+func (fc *faultyConn) corrupt(env *Envelope) linkItem {
+	buf, err := Marshal(env.Msg)
+	if err != nil {
+		return linkItem{err: xerrors.Errorf("marshaling: %v", err)}
+	}
+	fc.Lock()
+	// the first bytes are the type of the message
+	i := 16 + fc.rand.Intn(len(buf)-15)
+	if i < len(buf) {
+		buf[i] ^= byte(1 + fc.rand.Intn(255))
+	} else {
+		buf = append(buf, byte(fc.rand.Intn(256)))
+	}
+	fc.Unlock()
+	id, msg, err := Unmarshal(buf, connSuite(fc.Conn))
+	if err != nil {
+		return linkItem{err: xerrors.Errorf("corrupted message: %v", err)}
+	}
+	return linkItem{env: &Envelope{MsgType: id, Msg: msg, Size: Size(len(buf))}}
+}
+
+// deliverHeld delivers the message waiting to be reordered, if any.
+func (fc *faultyConn) deliverHeld() {
+	fc.Lock()
+	held := fc.held
+	fc.held = nil
+	if fc.heldTimer != nil {
+		fc.heldTimer.Stop()
+		fc.heldTimer = nil
+	}
+	fc.Unlock()
+	if held != nil {
+		fc.deliver(linkItem{env: held})
+	}
+}
+
+// deliver queues an item for Receive. It returns false once the connection
+// is closed.
+func (fc *faultyConn) deliver(item linkItem) bool {
+	select {
+	case fc.queue <- item:
+		return true
+	case <-fc.closed:
+		return false
+	}
+}
+
+// Receive returns the next message.
+func (fc *faultyConn) Receive() (*Envelope, error) {
+	select {
+	case item := <-fc.queue:
+		return item.env, item.err
+	case <-fc.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Close stops the delivery of the messages and closes the connection.
+func (fc *faultyConn) Close() error {
+	fc.closeOnce.Do(func() {
+		close(fc.closed)
+	})
+	return fc.Conn.Close()
+}
+
+// connSuite returns the suite a connection decodes the messages with.
+func connSuite(c Conn) Suite {
+	switch conn := c.(type) {
+	case *TCPConn:
+		return conn.suite
+	case *LocalConn:
+		return conn.suite
+	}
+	return nil
+}
+
+// closingError returns whether the connection stops after the error, like
+// in Router.handleConn.
+func closingError(err error) bool {
+	return xerrors.Is(err, ErrTimeout) || xerrors.Is(err, ErrClosed) ||
+		xerrors.Is(err, ErrEOF) || xerrors.Is(err, ErrUnknown)
+}
+
+// unwrapConn returns the connection under the ones emulating the network.
+func unwrapConn(c Conn) Conn {
+	for {
+		switch conn := c.(type) {
+		case *conditionedConn:
+			c = conn.Conn
+		case *faultyConn:
+			c = conn.Conn
+		default:
+			return c
+		}
+	}
+}
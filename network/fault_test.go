@@ -0,0 +1,121 @@
+package network
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chanConn receives the messages of a channel
+type chanConn struct {
+	Conn
+	envs      chan *Envelope
+	closeOnce sync.Once
+}
+
+func (c *chanConn) Receive() (*Envelope, error) {
+	env, ok := <-c.envs
+	if !ok {
+		return nil, ErrClosed
+	}
+	return env, nil
+}
+
+func (c *chanConn) Close() error {
+	c.closeOnce.Do(func() { close(c.envs) })
+	return nil
+}
+
+func newTestFaultyConn(f *Faults) (*faultyConn, chan *Envelope) {
+	envs := make(chan *Envelope, 10)
+	fc := newFaultyConn(&chanConn{envs: envs}, nil,
+		func(*ServerIdentity, *Envelope) *Faults { return f },
+		rand.New(rand.NewSource(1)))
+	return fc, envs
+}
+
+func receiveSimple(t *testing.T, c Conn) int64 {
+	env, err := c.Receive()
+	require.Nil(t, err)
+	return env.Msg.(*SimpleMessage).I
+}
+
+func TestFaultyConn(t *testing.T) {
+	fc, envs := newTestFaultyConn(&Faults{Duplicate: 1})
+	envs <- &Envelope{MsgType: SimpleMessageType, Msg: &SimpleMessage{1}}
+	require.Equal(t, int64(1), receiveSimple(t, fc))
+	require.Equal(t, int64(1), receiveSimple(t, fc))
+	require.Nil(t, fc.Close())
+
+	fc, envs = newTestFaultyConn(&Faults{Reorder: 1})
+	for i := 1; i <= 3; i++ {
+		envs <- &Envelope{MsgType: SimpleMessageType, Msg: &SimpleMessage{int64(i)}}
+	}
+	require.Equal(t, int64(2), receiveSimple(t, fc))
+	require.Equal(t, int64(1), receiveSimple(t, fc))
+	// the last one doesn't wait for ever
+	require.Equal(t, int64(3), receiveSimple(t, fc))
+	require.Nil(t, fc.Close())
+
+	fc, envs = newTestFaultyConn(&Faults{Delay: 1, MaxDelay: 200 * time.Millisecond})
+	start := time.Now()
+	envs <- &Envelope{MsgType: SimpleMessageType, Msg: &SimpleMessage{1}}
+	receiveSimple(t, fc)
+	require.True(t, time.Since(start) < 200*time.Millisecond)
+	require.Nil(t, fc.Close())
+
+	fc, envs = newTestFaultyConn(&Faults{Drop: 1})
+	envs <- &Envelope{MsgType: SimpleMessageType, Msg: &SimpleMessage{1}}
+	require.Nil(t, fc.Conn.Close())
+	_, err := fc.Receive()
+	require.Equal(t, ErrClosed, err)
+	require.Nil(t, fc.Close())
+}
+
+func TestFaultyConn_corrupt(t *testing.T) {
+	fc, _ := newTestFaultyConn(nil)
+	defer fc.Close()
+	changed := false
+	for i := 0; i < 20; i++ {
+		item := fc.corrupt(&Envelope{MsgType: SimpleMessageType, Msg: &SimpleMessage{1000}})
+		if item.err == nil && item.env.Msg.(*SimpleMessage).I != 1000 {
+			changed = true
+		}
+	}
+	require.True(t, changed)
+}
+
+func TestRouterFaults(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	// the even messages are lost
+	h2.SetFaults(1, func(remote *ServerIdentity, env *Envelope) *Faults {
+		require.True(t, remote.ID.Equal(h1.ServerIdentity.ID))
+		if sm, ok := env.Msg.(*SimpleMessage); ok && sm.I%2 == 0 {
+			return &Faults{Drop: 1}
+		}
+		return nil
+	})
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	for i := 0; i < 6; i++ {
+		_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{int64(i)})
+		require.Nil(t, err)
+	}
+	for i := 1; i < 6; i += 2 {
+		require.Equal(t, int64(i), (<-proc.relay).I)
+	}
+	conns := h2.Connections()
+	require.Equal(t, 1, len(conns))
+	require.Equal(t, PlainTCP, conns[0].Type)
+}
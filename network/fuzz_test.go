@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// FuzzUnmarshal decodes the packets the servers get from the network.
+func FuzzUnmarshal(f *testing.F) {
+	for _, msg := range []Message{&SimpleMessage{3}, nested(5),
+		NewTestServerIdentity(NewLocalAddress("127.0.0.1:2000"))} {
+		buf, err := Marshal(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		id, msg, err := Unmarshal(buf, tSuite)
+		if err == nil && (msg == nil || id == ErrorType) {
+			t.Fatal("decoded without a message")
+		}
+	})
+}
+
+// FuzzReadFrame reads the frames of the TCP connections.
+func FuzzReadFrame(f *testing.F) {
+	frame := new(bytes.Buffer)
+	binary.Write(frame, globalOrder, Size(3))
+	frame.WriteString("abc")
+	f.Add(frame.Bytes())
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf, read, err := readFrame(bytes.NewReader(data), func(time.Time) {})
+		if read > uint64(len(data)) {
+			t.Fatalf("read %d bytes out of %d", read, len(data))
+		}
+		if err == nil && Size(len(buf)) > MaxPacketSize {
+			t.Fatal("packet too big")
+		}
+	})
+}
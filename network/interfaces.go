@@ -0,0 +1,275 @@
+package network
+
+import (
+	"encoding"
+	"encoding/binary"
+	"reflect"
+	"sync"
+
+	"go.dedis.ch/kyber/v3/pairing/bn256"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// Interfaces is a registry of the implementations of the interfaces held by
+// the messages, like protobuf.RegisterInterface but without its global
+// state. protobuf writes the values of the interfaces after the tag given by
+// their MarshalID, and Decode uses the implementation registered for the tag
+// to decode them, so that two registries can hold different implementations
+// for the same tag.
+//
+// As protobuf only writes the tags registered globally, Register also
+// registers globally the tags that are not yet, but Decode only uses the
+// global implementations for the tags that the registry doesn't know. The
+// tags registered globally by other packages with protobuf.RegisterInterface
+// are not supported, and should be registered in the registries too.
+type Interfaces struct {
+	generators map[protobuf.GeneratorID]protobuf.InterfaceGeneratorFunc
+	lock       sync.Mutex
+}
+
+// NewInterfaces returns a registry of the points and scalars of the
+// suites of kyber.
+func NewInterfaces() *Interfaces {
+	i := &Interfaces{
+		generators: make(map[protobuf.GeneratorID]protobuf.InterfaceGeneratorFunc),
+	}
+	ed25519 := suites.MustFind("Ed25519")
+	for _, s := range []Suite{bn256.NewSuiteG1(), bn256.NewSuiteG2(), bn256.NewSuiteGT(), ed25519} {
+		if err := i.RegisterSuite(s); err != nil {
+			panic(err)
+		}
+	}
+	return i
+}
+
+// Register registers the implementation returned by the generator, that
+// must implement protobuf.InterfaceMarshaler, for its tag. It replaces the
+// implementation registered before for the same tag.
+func (i *Interfaces) Register(g protobuf.InterfaceGeneratorFunc) error {
+	val, ok := g().(protobuf.InterfaceMarshaler)
+	if !ok {
+		return xerrors.Errorf("%T doesn't implement protobuf.InterfaceMarshaler", g())
+	}
+	registerTag(val.MarshalID(), g)
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.generators[val.MarshalID()] = g
+	return nil
+}
+
+// tags are the tags registered globally, so that protobuf writes them.
+var tags = struct {
+	ids map[protobuf.GeneratorID]bool
+	sync.Mutex
+}{ids: make(map[protobuf.GeneratorID]bool)}
+
+func registerTag(id protobuf.GeneratorID, g protobuf.InterfaceGeneratorFunc) {
+	tags.Lock()
+	defer tags.Unlock()
+	if !tags.ids[id] {
+		tags.ids[id] = true
+		protobuf.RegisterInterface(g)
+	}
+}
+
+func isTag(id protobuf.GeneratorID) bool {
+	tags.Lock()
+	defer tags.Unlock()
+	return tags.ids[id]
+}
+
+// RegisterSuite registers the points and the scalars of the suite.
+func (i *Interfaces) RegisterSuite(suite Suite) error {
+	if err := i.Register(func() interface{} { return suite.Point() }); err != nil {
+		return xerrors.Errorf("point of %s: %v", suite, err)
+	}
+	if err := i.Register(func() interface{} { return suite.Scalar() }); err != nil {
+		return xerrors.Errorf("scalar of %s: %v", suite, err)
+	}
+	return nil
+}
+
+func (i *Interfaces) get(id protobuf.GeneratorID) protobuf.InterfaceGeneratorFunc {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.generators[id]
+}
+
+// Decode decodes the protobuf encoding of the message in msg, that must be
+// a pointer to a new struct. The values of the interfaces are given by the
+// implementations registered for their tag, and the ones without a tag that
+// the registry knows by the constructors of DefaultConstructors.
+func (i *Interfaces) Decode(buf []byte, msg interface{}, suite Suite) error {
+	typ := reflect.TypeOf(msg)
+	_, self := msg.(encoding.BinaryUnmarshaler)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Struct || self {
+		return protobuf.DecodeWithConstructors(buf, msg, DefaultConstructors(suite))
+	}
+	d := &interfaceDecoder{registry: i, types: make(map[reflect.Type]bool)}
+	untagged, err := d.untag(buf, protoFieldTypes(typ.Elem()), 1)
+	if err != nil {
+		return xerrors.Errorf("reading interfaces: %v", err)
+	}
+	return protobuf.DecodeWithConstructors(untagged, msg, d.constructors(suite))
+}
+
+// interfaceDecoder removes the tags known by the registry from the values of
+// the interfaces, and gives the implementations of the tags to protobuf, in
+// the order it decodes them.
+type interfaceDecoder struct {
+	registry *Interfaces
+	// generators has one entry for each interface protobuf has to
+	// instantiate, nil for the ones without a known tag.
+	generators []protobuf.InterfaceGeneratorFunc
+	types      map[reflect.Type]bool
+}
+
+// untag returns the message without the tags of the interfaces that the
+// registry knows.
+func (d *interfaceDecoder) untag(buf []byte, fields map[int64]reflect.Type, depth int) ([]byte, error) {
+	if depth > MaxDecodeDepth {
+		return nil, errTooDeep
+	}
+	out := make([]byte, 0, len(buf))
+	for len(buf) > 0 {
+		id, w, rest, err := readWireField(buf)
+		if err != nil {
+			return nil, err
+		}
+		field := buf[:len(buf)-len(rest)]
+		buf = rest
+		typ, ok := fields[id]
+		for ok && typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if !ok || w.wire != 2 {
+			out = append(out, field...)
+			continue
+		}
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			// the structs and the interfaces of the slices are
+			// written as one field each
+			typ = typ.Elem()
+			for typ.Kind() == reflect.Ptr {
+				typ = typ.Elem()
+			}
+		}
+		var value []byte
+		switch {
+		case typ.Kind() == reflect.Interface:
+			value = d.value(typ, w.bytes)
+		case typ.Kind() == reflect.Map:
+			entry := map[int64]reflect.Type{1: typ.Key(), 2: typ.Elem()}
+			value, err = d.untag(w.bytes, entry, depth+1)
+		case typ.Kind() == reflect.Struct && !encodesItself(typ):
+			value, err = d.untag(w.bytes, protoFieldTypes(typ), depth+1)
+		default:
+			out = append(out, field...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = appendBytesField(out, id, value)
+	}
+	return out, nil
+}
+
+// value returns the value of the interface without its tag, if the registry
+// knows it.
+func (d *interfaceDecoder) value(typ reflect.Type, buf []byte) []byte {
+	d.types[typ] = true
+	var id protobuf.GeneratorID
+	if len(buf) > len(id) {
+		copy(id[:], buf)
+		if g := d.registry.get(id); g != nil {
+			d.generators = append(d.generators, g)
+			return buf[len(id):]
+		}
+		if isTag(id) {
+			// protobuf uses the global implementation
+			return buf
+		}
+	}
+	d.generators = append(d.generators, nil)
+	return buf
+}
+
+// constructors returns the constructors that give protobuf the
+// implementations of the tags, one after the other.
+func (d *interfaceDecoder) constructors(suite Suite) protobuf.Constructors {
+	defaults := DefaultConstructors(suite)
+	constructors := make(protobuf.Constructors)
+	for t := range d.types {
+		t := t
+		constructors[t] = func() interface{} {
+			var g protobuf.InterfaceGeneratorFunc
+			if len(d.generators) > 0 {
+				g, d.generators = d.generators[0], d.generators[1:]
+			}
+			if g == nil {
+				if g = defaults[t]; g == nil {
+					panic("no constructor for interface " + t.String())
+				}
+			}
+			return g()
+		}
+	}
+	return constructors
+}
+
+// appendBytesField appends the field of wire type 2 to buf.
+func appendBytesField(buf []byte, id int64, value []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(id)<<3|2)]...)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp[:], uint64(len(value)))]...)
+	return append(buf, value...)
+}
+
+var suiteInterfaces = struct {
+	registries map[string]*Interfaces
+	sync.Mutex
+}{registries: make(map[string]*Interfaces)}
+
+// The tags of kyber are registered when the package is loaded, as they were
+// by the init of onet v3, so that protobuf.Encode writes them even before the
+// first message is marshalled.
+func init() {
+	SuiteInterfaces(nil)
+}
+
+// SuiteInterfaces returns the registry of the interfaces used to decode the
+// messages with the suite, so that the services using different suites
+// don't share their implementations. It starts with the ones of NewInterfaces
+// and of the suite, and nil gives the registry of the messages decoded
+// without a suite.
+func SuiteInterfaces(suite Suite) *Interfaces {
+	name := ""
+	if suite != nil {
+		name = suite.String()
+	}
+	suiteInterfaces.Lock()
+	defer suiteInterfaces.Unlock()
+	i, ok := suiteInterfaces.registries[name]
+	if !ok {
+		i = NewInterfaces()
+		if suite != nil {
+			// the suites that don't tag their values need no
+			// registration
+			if err := i.RegisterSuite(suite); err != nil {
+				log.Lvl3("Not registering", suite, err)
+			}
+		}
+		suiteInterfaces.registries[name] = i
+	}
+	return i
+}
+
+// Decode decodes the protobuf encoding of the message in msg with the
+// registry of the suite returned by SuiteInterfaces.
+func Decode(buf []byte, msg interface{}, suite Suite) error {
+	return SuiteInterfaces(suite).Decode(buf, msg, suite)
+}
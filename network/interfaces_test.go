@@ -0,0 +1,93 @@
+package network
+
+import (
+	"encoding"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing/bn256"
+	"go.dedis.ch/protobuf"
+)
+
+type testShape interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// square and circle share the same tag
+type square struct{ Side byte }
+
+func (s *square) MarshalBinary() ([]byte, error)   { return []byte{s.Side}, nil }
+func (s *square) UnmarshalBinary(buf []byte) error { s.Side = buf[0]; return nil }
+func (s *square) MarshalID() [8]byte               { return [8]byte{'t', 's', 't', '.', 's', 'h', 'a', 'p'} }
+
+type circle struct{ Radius byte }
+
+func (c *circle) MarshalBinary() ([]byte, error)   { return []byte{c.Radius}, nil }
+func (c *circle) UnmarshalBinary(buf []byte) error { c.Radius = buf[0]; return nil }
+func (c *circle) MarshalID() [8]byte               { return [8]byte{'t', 's', 't', '.', 's', 'h', 'a', 'p'} }
+
+type shapesMsg struct {
+	Shape  testShape
+	Point  kyber.Point
+	Shapes []testShape
+	Map    map[string]testShape
+	Nested *shapesMsg
+}
+
+func TestInterfaces(t *testing.T) {
+	squares := NewInterfaces()
+	require.NoError(t, squares.Register(func() interface{} { return &square{} }))
+	circles := NewInterfaces()
+	require.NoError(t, circles.Register(func() interface{} { return &circle{} }))
+	require.Error(t, circles.Register(func() interface{} { return 1 }))
+
+	suite := bn256.NewSuiteG2()
+	point := suite.Point().Pick(suite.RandomStream())
+	msg := &shapesMsg{Shape: &square{1}, Point: point, Shapes: []testShape{&square{2}, &square{3}},
+		Map: map[string]testShape{"a": &square{4}}, Nested: &shapesMsg{Shape: &square{5}, Point: point}}
+	buf, err := protobuf.Encode(msg)
+	require.NoError(t, err)
+
+	got := &shapesMsg{}
+	require.NoError(t, squares.Decode(buf, got, tSuite))
+	require.Equal(t, msg.Shapes, got.Shapes)
+	require.Equal(t, msg.Map, got.Map)
+	require.Equal(t, &square{5}, got.Nested.Shape)
+
+	got = &shapesMsg{}
+	require.NoError(t, circles.Decode(buf, got, tSuite))
+	require.Equal(t, &circle{1}, got.Shape)
+	require.Equal(t, []testShape{&circle{2}, &circle{3}}, got.Shapes)
+	require.Equal(t, &circle{4}, got.Map["a"])
+	require.Equal(t, &circle{5}, got.Nested.Shape)
+	require.True(t, point.Equal(got.Point))
+	require.True(t, point.Equal(got.Nested.Point))
+
+	require.Error(t, squares.Decode([]byte{0xff}, &shapesMsg{}, tSuite))
+}
+
+// TestInterfaces_tags checks that the tags of kyber are written by
+// protobuf.Encode without a message being marshalled first.
+func TestInterfaces_tags(t *testing.T) {
+	for _, s := range []Suite{tSuite, bn256.NewSuiteG1(), bn256.NewSuiteG2(), bn256.NewSuiteGT()} {
+		require.True(t, isTag(s.Point().(protobuf.InterfaceMarshaler).MarshalID()), s)
+		require.True(t, isTag(s.Scalar().(protobuf.InterfaceMarshaler).MarshalID()), s)
+	}
+}
+
+func TestSuiteInterfaces(t *testing.T) {
+	require.True(t, SuiteInterfaces(tSuite) == SuiteInterfaces(tSuite))
+	require.False(t, SuiteInterfaces(tSuite) == SuiteInterfaces(bn256.NewSuiteG1()))
+	require.False(t, SuiteInterfaces(tSuite) == SuiteInterfaces(nil))
+
+	// a point of another suite is decoded with its own implementation
+	suite := bn256.NewSuiteG1()
+	msg := &shapesMsg{Point: suite.Point().Pick(suite.RandomStream())}
+	buf, err := protobuf.Encode(msg)
+	require.NoError(t, err)
+	got := &shapesMsg{}
+	require.NoError(t, Decode(buf, got, tSuite))
+	require.True(t, msg.Point.Equal(got.Point))
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"reflect"
+
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// LazyMessage is a message of a type registered with RegisterLazyMessage,
+// that is only decoded when asked. The processors of the type get it instead
+// of the message, so that the messages they filter or relay don't cost their
+// decoding. It is sent again as it was received, without being encoded.
+type LazyMessage struct {
+	// MsgType is the type of the message and Data its protobuf encoding.
+	MsgType MessageTypeID
+	Data    []byte
+	suite   Suite
+}
+
+// RegisterLazyMessage registers the message like RegisterMessage, but the
+// messages of its type are given as LazyMessages by Unmarshal, and so to the
+// processors. It can't be used for the messages of the protocols.
+func RegisterLazyMessage(msg Message) MessageTypeID {
+	id := RegisterMessage(msg)
+	registry.setLazy(id)
+	return id
+}
+
+// Decode returns the message, like Unmarshal would for a type that is not
+// lazy.
+func (m *LazyMessage) Decode() (Message, error) {
+	typ, err := m.check()
+	if err != nil {
+		return nil, err
+	}
+	return m.decode(typ, m.Data)
+}
+
+// DecodeFields returns the message with only the fields with the names
+// decoded, so that the others cost going through their bytes only. The
+// messages with their own encoding can't be decoded by fields.
+func (m *LazyMessage) DecodeFields(names ...string) (Message, error) {
+	typ, err := m.check()
+	if err != nil {
+		return nil, err
+	}
+	if customEncoding(typ) {
+		return nil, xerrors.Errorf("%v has its own encoding", typ)
+	}
+	ids := make(map[int64]bool)
+	for _, name := range names {
+		found := false
+		for _, f := range protobuf.ProtoFields(typ) {
+			if f.Field.Name == name {
+				ids[f.ID] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, xerrors.Errorf("no field %s in %v", name, typ)
+		}
+	}
+	var fields []byte
+	buf := m.Data
+	for len(buf) > 0 {
+		id, _, rest, err := readWireField(buf)
+		if err != nil {
+			return nil, xerrors.Errorf("reading fields: %v", err)
+		}
+		if ids[id] {
+			fields = append(fields, buf[:len(buf)-len(rest)]...)
+		}
+		buf = rest
+	}
+	return m.decode(typ, fields)
+}
+
+// check returns the type of the message, after checking that its data can
+// be decoded.
+func (m *LazyMessage) check() (reflect.Type, error) {
+	typ, ok := registry.get(m.MsgType)
+	if !ok {
+		return nil, xerrors.Errorf("type %s not registered", m.MsgType)
+	}
+	if reflect.PtrTo(typ).Implements(networkUnmarshalerType) {
+		return typ, nil
+	}
+	if err := CheckDecode(m.Data, typ); err != nil {
+		return nil, xerrors.Errorf("checking %s: %v", m.MsgType, err)
+	}
+	return typ, nil
+}
+
+func (m *LazyMessage) decode(typ reflect.Type, buf []byte) (Message, error) {
+	ptr := reflect.New(typ).Interface()
+	if nu, ok := ptr.(NetworkUnmarshaler); ok {
+		if err := nu.UnmarshalNetwork(buf, m.suite); err != nil {
+			return nil, xerrors.Errorf("decoding: %v", err)
+		}
+		return nu, nil
+	}
+	if err := Decode(buf, ptr, m.suite); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	return ptr, nil
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type lazyMsg struct {
+	ID      int64
+	Payload []byte
+	Nested  *SimpleMessage
+}
+
+var lazyMsgType = RegisterLazyMessage(&lazyMsg{})
+
+func TestLazyMessage(t *testing.T) {
+	msg := &lazyMsg{ID: 3, Payload: []byte("payload"), Nested: &SimpleMessage{4}}
+	buf, err := Marshal(msg)
+	require.NoError(t, err)
+	id, got, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, lazyMsgType, id)
+	lm, ok := got.(*LazyMessage)
+	require.True(t, ok)
+	require.Equal(t, lazyMsgType, MessageType(lm))
+
+	decoded, err := lm.Decode()
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+	decoded, err = lm.DecodeFields("ID", "Nested")
+	require.NoError(t, err)
+	require.Equal(t, &lazyMsg{ID: 3, Nested: &SimpleMessage{4}}, decoded)
+	_, err = lm.DecodeFields("Unknown")
+	require.Error(t, err)
+
+	// it is sent again as it was received
+	relayed, err := Marshal(lm)
+	require.NoError(t, err)
+	require.Equal(t, buf, relayed)
+
+	// and described as the message
+	d, err := DescribeMessage(lm)
+	require.NoError(t, err)
+	require.Equal(t, "network.lazyMsg", d.Type)
+
+	lm.Data = []byte{0xff}
+	_, err = lm.Decode()
+	require.Error(t, err)
+	_, err = lm.DecodeFields("ID")
+	require.Error(t, err)
+}
+
+func TestRouterLazyMessage(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	// h2 relays the message back without decoding it
+	h2.RegisterProcessorFunc(lazyMsgType, func(env *Envelope) error {
+		_, err := h2.Send(env.ServerIdentity, env.Msg)
+		return err
+	})
+	received := make(chan *LazyMessage, 1)
+	h1.RegisterProcessorFunc(lazyMsgType, func(env *Envelope) error {
+		received <- env.Msg.(*LazyMessage)
+		return nil
+	})
+	_, err = h1.Send(h2.ServerIdentity, &lazyMsg{ID: 5, Payload: []byte("abc")})
+	require.NoError(t, err)
+	msg, err := (<-received).Decode()
+	require.NoError(t, err)
+	require.Equal(t, &lazyMsg{ID: 5, Payload: []byte("abc")}, msg)
+}
@@ -0,0 +1,173 @@
+package network
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// the shortest retransmission timeout of TCP, as used by linux
+const minRetransmissionTimeout = 200 * time.Millisecond
+
+// how many received messages a link holds back before it stops reading
+const linkQueueSize = 1024
+
+// LinkConditions describe the network to a remote server. They are emulated
+// by a Router where SetLinkConditions has been called, which is useful to
+// study protocols in the simulations on localhost.
+type LinkConditions struct {
+	// Latency is added to every message.
+	Latency time.Duration
+	// Jitter varies the latency of every message by up to this much in
+	// both directions. As with TCP, the messages are never reordered.
+	Jitter time.Duration
+	// Bandwidth of the link in bytes per second, 0 for no limit.
+	Bandwidth uint64
+	// Loss is the probability that a message has to be retransmitted. As
+	// the connections are reliable, a lost message is delayed by the
+	// retransmission timeout of TCP instead of being dropped. It must be
+	// below 1.
+	Loss float64
+}
+
+// SetLinkConditions emulates the network on the connections that are
+// created afterwards. f returns the conditions of the link to the remote
+// server, or nil if the link is to be left as it is. The conditions are
+// applied to the messages this router receives, so all routers need them
+// to emulate both directions of the links.
+func (r *Router) SetLinkConditions(f func(remote *ServerIdentity) *LinkConditions) {
+	r.Lock()
+	defer r.Unlock()
+	r.linkConditions = f
+}
+
+// conditionConn returns the connection emulating the conditions of the link
+// to the remote server.
+func (r *Router) conditionConn(remote *ServerIdentity, c Conn) Conn {
+	r.Lock()
+	f := r.linkConditions
+	r.Unlock()
+	if f == nil {
+		return c
+	}
+	lc := f(remote)
+	if lc == nil {
+		return c
+	}
+	return newConditionedConn(c, *lc)
+}
+
+// a message received on a conditionedConn, with when it is delivered
+type linkItem struct {
+	env *Envelope
+	err error
+	at  time.Time
+}
+
+// conditionedConn delays the messages received on a connection according
+// to its LinkConditions. A go-routine reads the messages as soon as they
+// arrive, so that the latencies of successive messages overlap.
+type conditionedConn struct {
+	Conn
+	lc    LinkConditions
+	queue chan linkItem
+	// closed is closed to stop the delivery of the messages
+	closed    chan struct{}
+	closeOnce sync.Once
+	// when the link is done receiving the last message, and when that
+	// message is delivered
+	busy time.Time
+	last time.Time
+	rand *rand.Rand
+}
+
+func newConditionedConn(c Conn, lc LinkConditions) *conditionedConn {
+	cc := &conditionedConn{
+		Conn:   c,
+		lc:     lc,
+		queue:  make(chan linkItem, linkQueueSize),
+		closed: make(chan struct{}),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go cc.read()
+	return cc
+}
+
+// read receives the messages of the connection and queues them with the
+// time they are to be delivered.
+func (cc *conditionedConn) read() {
+	for {
+		env, err := cc.Conn.Receive()
+		item := linkItem{env: env, err: err, at: time.Now()}
+		if env != nil {
+			item.at = cc.delivery(item.at, uint64(env.Size))
+		}
+		select {
+		case cc.queue <- item:
+		case <-cc.closed:
+			return
+		}
+		if err != nil && closingError(err) {
+			return
+		}
+	}
+}
+
+// delivery returns when a message of the size arriving now is delivered.
+func (cc *conditionedConn) delivery(now time.Time, size uint64) time.Time {
+	start := now
+	if cc.busy.After(start) {
+		start = cc.busy
+	}
+	if cc.lc.Bandwidth > 0 {
+		start = start.Add(time.Duration(size * uint64(time.Second) / cc.lc.Bandwidth))
+	}
+	cc.busy = start
+
+	delay := cc.lc.Latency
+	if cc.lc.Jitter > 0 {
+		delay += time.Duration(cc.rand.Int63n(int64(2*cc.lc.Jitter))) - cc.lc.Jitter
+	}
+	for cc.lc.Loss > 0 && cc.lc.Loss < 1 && cc.rand.Float64() < cc.lc.Loss {
+		rto := 4 * cc.lc.Latency
+		if rto < minRetransmissionTimeout {
+			rto = minRetransmissionTimeout
+		}
+		delay += rto
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	at := start.Add(delay)
+	if at.Before(cc.last) {
+		at = cc.last
+	}
+	cc.last = at
+	return at
+}
+
+// Receive returns the next message once its delay is over.
+func (cc *conditionedConn) Receive() (*Envelope, error) {
+	var item linkItem
+	select {
+	case item = <-cc.queue:
+	case <-cc.closed:
+		return nil, ErrClosed
+	}
+	if wait := time.Until(item.at); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-cc.closed:
+			return nil, ErrClosed
+		}
+	}
+	return item.env, item.err
+}
+
+// Close stops the delivery of the messages and closes the connection.
+func (cc *conditionedConn) Close() error {
+	cc.closeOnce.Do(func() {
+		close(cc.closed)
+	})
+	return cc.Conn.Close()
+}
@@ -0,0 +1,77 @@
+package network
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterLinkConditions(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	latency := 200 * time.Millisecond
+	for _, h := range []*Router{h1, h2} {
+		h.SetLinkConditions(func(remote *ServerIdentity) *LinkConditions {
+			return &LinkConditions{Latency: latency}
+		})
+	}
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h1.RegisterProcessor(proc, SimpleMessageType)
+	h2.RegisterProcessor(proc, SimpleMessageType)
+
+	// the latencies of successive messages overlap
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{int64(i)})
+		require.Nil(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		require.Equal(t, int64(i), (<-proc.relay).I)
+	}
+	elapsed := time.Since(start)
+	require.True(t, elapsed >= latency, elapsed)
+	require.True(t, elapsed < 3*latency, elapsed)
+
+	// the reply is delayed by h1
+	start = time.Now()
+	_, err = h2.Send(h1.ServerIdentity, &SimpleMessage{10})
+	require.Nil(t, err)
+	require.Equal(t, int64(10), (<-proc.relay).I)
+	require.True(t, time.Since(start) >= latency)
+
+	conns := h2.Connections()
+	require.Equal(t, 1, len(conns))
+	require.Equal(t, PlainTCP, conns[0].Type)
+}
+
+func TestConditionedConn_delivery(t *testing.T) {
+	now := time.Now()
+	cc := &conditionedConn{lc: LinkConditions{Latency: time.Second, Bandwidth: 1000}}
+	// 500 bytes take half a second to go through the link
+	require.Equal(t, now.Add(1500*time.Millisecond), cc.delivery(now, 500))
+	// the second message has to wait for the first one
+	require.Equal(t, now.Add(2*time.Second), cc.delivery(now, 500))
+
+	cc = &conditionedConn{lc: LinkConditions{Latency: 10 * time.Millisecond,
+		Jitter: 10 * time.Millisecond, Loss: 0.5}, rand: rand.New(rand.NewSource(1))}
+	last := now
+	retransmitted := false
+	for i := 0; i < 100; i++ {
+		at := cc.delivery(now, 10)
+		require.False(t, at.Before(last))
+		if at.Sub(now) >= minRetransmissionTimeout {
+			retransmitted = true
+		}
+		last = at
+	}
+	require.True(t, retransmitted)
+}
@@ -49,6 +49,13 @@ type LocalManager struct {
 	stopped  bool
 	// a waitgroup to check that all serving goroutines are done
 	wg sync.WaitGroup
+	// scheduler delivers the messages if it is set
+	scheduler *Scheduler
+	// the messages are passed by reference if shared is set, and one in
+	// checkEvery is checked
+	shared     bool
+	checkEvery int
+	sent       uint64
 }
 
 // NewLocalManager returns a fresh new manager that can be used by LocalConn,
@@ -140,18 +147,32 @@ func (lm *LocalManager) connect(local, remote Address, s Suite) (*LocalConn, err
 // send gets the connection denoted by this endpoint and calls queueMsg
 // with the packet as argument to it.
 // It returns ErrClosed if it does not find the connection.
-func (lm *LocalManager) send(e endpoint, msg []byte) error {
+func (lm *LocalManager) send(e endpoint, msg localPacket) error {
 	lm.Lock()
 	defer lm.Unlock()
 	q, ok := lm.conns[e]
 	if !ok {
 		return xerrors.Errorf("closing: %w", ErrClosed)
 	}
+	if lm.scheduler != nil {
+		lm.scheduler.enqueue(e, q.remote.addr, msg)
+		return nil
+	}
 
 	q.incomingQueue <- msg
 	return nil
 }
 
+// deliver gives a message held by the scheduler to the connection, if it is
+// still open.
+func (lm *LocalManager) deliver(e endpoint, msg localPacket) {
+	lm.Lock()
+	defer lm.Unlock()
+	if q, ok := lm.conns[e]; ok {
+		q.incomingQueue <- msg
+	}
+}
+
 // close gets the connection denoted by this endpoint and closes it if
 // it is present.
 func (lm *LocalManager) close(conn *LocalConn) error {
@@ -203,9 +224,9 @@ type LocalConn struct {
 	remote endpoint
 
 	// the channel where incoming messages are dispatched
-	incomingQueue chan []byte
+	incomingQueue chan localPacket
 	// the channel where messages stored can be retrieved with Receive()
-	outgoingQueue chan []byte
+	outgoingQueue chan localPacket
 	// the channel used to communicate the stopping of the operations
 	closeCh chan bool
 	// the confirmation channel for the go routine
@@ -229,8 +250,8 @@ func newLocalConn(lm *LocalManager, local, remote endpoint, s Suite) *LocalConn
 		remote:        remote,
 		local:         local,
 		manager:       lm,
-		incomingQueue: make(chan []byte, LocalMaxBuffer),
-		outgoingQueue: make(chan []byte, LocalMaxBuffer),
+		incomingQueue: make(chan localPacket, LocalMaxBuffer),
+		outgoingQueue: make(chan localPacket, LocalMaxBuffer),
 		closeCh:       make(chan bool),
 		closeConfirm:  make(chan bool),
 		suite:         s,
@@ -275,13 +296,13 @@ func (lc *LocalConn) start(wg *sync.WaitGroup) {
 // will be sent to the remote endpoint.
 // If there is an error in the connection, it will be returned.
 func (lc *LocalConn) Send(msg Message) (uint64, error) {
-	buff, err := Marshal(msg)
+	packet, err := lc.manager.packet(msg)
 	if err != nil {
 		return 0, xerrors.Errorf("marshal: %v", err)
 	}
-	sentLen := uint64(len(buff))
+	sentLen := packet.size()
 	lc.updateTx(sentLen)
-	err = lc.manager.send(lc.remote, buff)
+	err = lc.manager.send(lc.remote, packet)
 	if err != nil {
 		return sentLen, xerrors.Errorf("sending: %w", err)
 	}
@@ -292,12 +313,16 @@ func (lc *LocalConn) Send(msg Message) (uint64, error) {
 // be ready. It returns the received packet.
 // In case of an error the packet is nil and the error is returned.
 func (lc *LocalConn) Receive() (*Envelope, error) {
-	buff, opened := <-lc.outgoingQueue
+	packet, opened := <-lc.outgoingQueue
 	if !opened {
 		return nil, xerrors.Errorf("closing: %w", ErrClosed)
 	}
-	lc.updateRx(uint64(len(buff)))
+	lc.updateRx(packet.size())
+	if packet.msg != nil {
+		return packet.envelope()
+	}
 
+	buff := packet.buf
 	id, body, err := Unmarshal(buff, lc.suite)
 	if err != nil {
 		return nil, xerrors.Errorf("unmarshaling: %v", err)
@@ -0,0 +1,41 @@
+package network
+
+import (
+	"reflect"
+)
+
+// NetworkMarshaler is implemented by the registered messages that give their
+// own encoding to Marshal instead of protobuf, like the bitsets or the
+// compressed sketches that protobuf would write too slowly or too big.
+type NetworkMarshaler interface {
+	MarshalNetwork() ([]byte, error)
+}
+
+// NetworkUnmarshaler is implemented by the pointers to the registered
+// messages that decode the encoding of their MarshalNetwork. Unmarshal calls
+// UnmarshalNetwork on a new message instead of protobuf, and without
+// checking the limits of CheckDecode but the size of the message, so it
+// must check the size of what it allocates itself.
+type NetworkUnmarshaler interface {
+	UnmarshalNetwork(buf []byte, suite Suite) error
+}
+
+var networkMarshalerType = reflect.TypeOf((*NetworkMarshaler)(nil)).Elem()
+var networkUnmarshalerType = reflect.TypeOf((*NetworkUnmarshaler)(nil)).Elem()
+
+// customEncoding returns whether the messages of the type are encoded or
+// decoded by themselves, and not by protobuf.
+func customEncoding(typ reflect.Type) bool {
+	ptr := reflect.PtrTo(typ)
+	return typ.Implements(networkMarshalerType) || ptr.Implements(networkMarshalerType) ||
+		ptr.Implements(networkUnmarshalerType)
+}
+
+// ownEncoding returns whether the message has its own encoding.
+func ownEncoding(msg Message) bool {
+	typ := reflect.TypeOf(msg)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ != nil && customEncoding(typ)
+}
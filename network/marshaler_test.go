@@ -0,0 +1,92 @@
+package network
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// bitset packs its bits, where protobuf would write a byte for each
+type bitset struct {
+	Bits []bool
+}
+
+var bitsetType = RegisterMessage(&bitset{})
+
+func (b *bitset) MarshalNetwork() ([]byte, error) {
+	buf := make([]byte, 4+(len(b.Bits)+7)/8)
+	binary.LittleEndian.PutUint32(buf, uint32(len(b.Bits)))
+	for i, bit := range b.Bits {
+		if bit {
+			buf[4+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return buf, nil
+}
+
+func (b *bitset) UnmarshalNetwork(buf []byte, suite Suite) error {
+	if len(buf) < 4 {
+		return xerrors.New("missing length")
+	}
+	n := int(binary.LittleEndian.Uint32(buf))
+	if len(buf) != 4+(n+7)/8 {
+		return xerrors.New("wrong length")
+	}
+	b.Bits = make([]bool, n)
+	for i := range b.Bits {
+		b.Bits[i] = buf[4+i/8]&(1<<uint(i%8)) != 0
+	}
+	return nil
+}
+
+type lazyBitset struct {
+	bitset
+}
+
+var lazyBitsetType = RegisterLazyMessage(&lazyBitset{})
+
+func TestNetworkMarshaler(t *testing.T) {
+	msg := &bitset{Bits: make([]bool, 100)}
+	msg.Bits[3], msg.Bits[99] = true, true
+	buf, err := Marshal(msg)
+	require.NoError(t, err)
+	require.Equal(t, 16+4+13, len(buf))
+	id, got, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, bitsetType, id)
+	require.Equal(t, msg, got)
+
+	_, _, err = Unmarshal(buf[:len(buf)-1], tSuite)
+	require.Error(t, err)
+
+	canonical, err := MarshalCanonical(msg)
+	require.NoError(t, err)
+	require.Equal(t, buf, canonical)
+
+	_, err = DescribeMessage(msg)
+	require.Error(t, err)
+	require.Equal(t, "network.bitset(network)",
+		RegistrySchema().Messages[uuid.UUID(bitsetType).String()])
+}
+
+func TestNetworkMarshaler_Lazy(t *testing.T) {
+	msg := &lazyBitset{bitset{Bits: []bool{true, false, true}}}
+	buf, err := Marshal(msg)
+	require.NoError(t, err)
+	id, got, err := Unmarshal(buf, tSuite)
+	require.NoError(t, err)
+	require.Equal(t, lazyBitsetType, id)
+	lm := got.(*LazyMessage)
+	decoded, err := lm.Decode()
+	require.NoError(t, err)
+	require.Equal(t, msg, decoded)
+	_, err = lm.DecodeFields("Bits")
+	require.Error(t, err)
+
+	relayed, err := Marshal(lm)
+	require.NoError(t, err)
+	require.Equal(t, buf, relayed)
+}
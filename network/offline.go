@@ -0,0 +1,57 @@
+package network
+
+import (
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// ErrOffline is returned by Router.Send while the router is offline.
+var ErrOffline = xerrors.New("router is offline")
+
+// SetOffline cuts the router from the network, to emulate a server that
+// left or crashed in the simulations. The router then drops the messages it
+// receives and refuses to send, except for the messages of the allowed
+// types, which lets the simulation control an offline server. If
+// closeConns is true, the current connections are closed like when a server
+// leaves, else they stay open but silent like when a server crashes.
+func (r *Router) SetOffline(closeConns bool, allowed ...MessageTypeID) {
+	r.Lock()
+	r.offline = make(map[MessageTypeID]bool)
+	for _, t := range allowed {
+		r.offline[t] = true
+	}
+	var conns []Conn
+	if closeConns {
+		for _, arr := range r.connections {
+			conns = append(conns, arr...)
+		}
+	}
+	r.Unlock()
+	for _, c := range conns {
+		if err := c.Close(); err != nil {
+			log.Lvl3("Closing connection of offline router:", err)
+		}
+	}
+}
+
+// SetOnline reverses SetOffline.
+func (r *Router) SetOnline() {
+	r.Lock()
+	defer r.Unlock()
+	r.offline = nil
+}
+
+// Offline returns whether the router is offline.
+func (r *Router) Offline() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.offline != nil
+}
+
+// passes returns whether the message can be sent or received, which is not
+// the case while offline, except for the allowed messages.
+func (r *Router) passes(t MessageTypeID) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.offline == nil || r.offline[t]
+}
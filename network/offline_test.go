@@ -0,0 +1,67 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type offlineControl struct {
+	I int64
+}
+
+func TestRouterOffline(t *testing.T) {
+	controlType := RegisterMessage(&offlineControl{})
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	control := make(chan bool, 1)
+	h2.RegisterProcessorFunc(controlType, func(*Envelope) error {
+		control <- true
+		return nil
+	})
+
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{1})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), (<-proc.relay).I)
+
+	// a crashed server stays connected, but drops the messages
+	h2.SetOffline(false, controlType)
+	require.True(t, h2.Offline())
+	require.Equal(t, 1, len(h2.Connections()))
+	_, err = h2.Send(h1.ServerIdentity, &SimpleMessage{2})
+	require.True(t, xerrors.Is(err, ErrOffline))
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{3})
+	require.Nil(t, err)
+	select {
+	case <-proc.relay:
+		require.Fail(t, "offline router dispatched a message")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = h1.Send(h2.ServerIdentity, &offlineControl{})
+	require.Nil(t, err)
+	<-control
+
+	h2.SetOnline()
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{4})
+	require.Nil(t, err)
+	require.Equal(t, int64(4), (<-proc.relay).I)
+
+	// a server that leaves closes its connections
+	h2.SetOffline(true)
+	waitTimeout(time.Second, 10, func() bool {
+		return len(h2.Connections()) == 0
+	})
+	h2.SetOnline()
+	require.False(t, h2.Offline())
+}
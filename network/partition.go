@@ -0,0 +1,32 @@
+package network
+
+// Block drops the messages received from the remote servers, also on the
+// current connections, to emulate a partition of the network. The messages
+// sent to them still leave, so both sides have to block each other to cut
+// the link in both directions.
+func (r *Router) Block(remotes ...*ServerIdentity) {
+	r.Lock()
+	defer r.Unlock()
+	if r.blocked == nil {
+		r.blocked = make(map[ServerIdentityID]bool)
+	}
+	for _, si := range remotes {
+		r.blocked[si.ID] = true
+	}
+}
+
+// Unblock reverses Block for the remote servers.
+func (r *Router) Unblock(remotes ...*ServerIdentity) {
+	r.Lock()
+	defer r.Unlock()
+	for _, si := range remotes {
+		delete(r.blocked, si.ID)
+	}
+}
+
+// blocks returns whether the messages from the remote server are dropped.
+func (r *Router) blocks(remote *ServerIdentity) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.blocked[remote.ID]
+}
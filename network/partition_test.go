@@ -0,0 +1,40 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterBlock(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.Nil(t, err)
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{1})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), (<-proc.relay).I)
+
+	// the current connection drops the messages
+	h2.Block(h1.ServerIdentity)
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{2})
+	require.Nil(t, err)
+	select {
+	case <-proc.relay:
+		require.Fail(t, "blocked router dispatched a message")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	h2.Unblock(h1.ServerIdentity)
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{3})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), (<-proc.relay).I)
+}
@@ -0,0 +1,209 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// Puzzle makes the peers connecting to a TCPHost solve a small proof of work
+// before the host handles their connection, so that opening many connections
+// to a public conode costs more to the attacker than to the conode. As the
+// peers can't tell a host with a puzzle from one without, all the conodes of
+// a network must set the same puzzle.
+type Puzzle struct {
+	// Difficulty is the number of leading zero bits the hash of the
+	// solution must have. Every bit doubles the work of the peers.
+	Difficulty int
+	// MaxPending is how many connections may be solving their puzzle at
+	// the same time. The other ones are closed at once. Zero is no limit.
+	MaxPending int
+	// Timeout is how long a peer has to solve its puzzle, and defaults to
+	// puzzleTimeout.
+	Timeout time.Duration
+}
+
+// maxPuzzleDifficulty is the hardest puzzle a peer accepts to solve, so that
+// a host can't have it work forever.
+const maxPuzzleDifficulty = 32
+
+// puzzleTimeout is how long the peers have to solve their puzzle by default.
+const puzzleTimeout = 10 * time.Second
+
+// the challenge is the difficulty followed by random bytes, and its solution
+// a nonce
+const (
+	challengeSize = 1 + 16
+	solutionSize  = 8
+)
+
+// SetPuzzle has the peers connecting to the host solve the puzzle, and the
+// host solve the puzzles of the ones it connects to. It must be called before
+// the host listens. A zero difficulty removes the puzzle.
+func (t *TCPHost) SetPuzzle(p Puzzle) error {
+	if p.Difficulty < 0 || p.Difficulty > maxPuzzleDifficulty {
+		return xerrors.Errorf("difficulty must be between 0 and %d", maxPuzzleDifficulty)
+	}
+	if t.Listening() {
+		return xerrors.New("host already listening")
+	}
+	if p.Difficulty == 0 {
+		t.puzzle = nil
+		return nil
+	}
+	if p.Timeout == 0 {
+		p.Timeout = puzzleTimeout
+	}
+	t.puzzle = &puzzleGate{Puzzle: p}
+	return nil
+}
+
+// SetPuzzle sets the puzzle of the TCPHost of the router, see
+// TCPHost.SetPuzzle.
+func (r *Router) SetPuzzle(p Puzzle) error {
+	h, ok := r.host.(*TCPHost)
+	if !ok {
+		return xerrors.New("only a TCP host has a puzzle")
+	}
+	return h.SetPuzzle(p)
+}
+
+// puzzleGate holds the puzzle of a listener and counts the connections
+// solving theirs.
+type puzzleGate struct {
+	Puzzle
+	pending int64
+}
+
+// accept returns the connection that will give the puzzle to the peer on
+// its first read, or an error if too many connections are solving theirs.
+func (g *puzzleGate) accept(c net.Conn) (net.Conn, error) {
+	if atomic.AddInt64(&g.pending, 1) > int64(g.MaxPending) && g.MaxPending > 0 {
+		atomic.AddInt64(&g.pending, -1)
+		c.Close()
+		return nil, xerrors.New("too many pending puzzles")
+	}
+	return &puzzleConn{Conn: c, gate: g}, nil
+}
+
+// puzzleConn checks the solution of the peer before the first byte is read
+// from or written to the connection. It doesn't allocate anything else than
+// the challenge before, so that the TLS handshake and the decoding of the
+// messages only happen for the peers that did the work.
+type puzzleConn struct {
+	net.Conn
+	gate *puzzleGate
+	once sync.Once
+	err  error
+}
+
+func (c *puzzleConn) Read(b []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *puzzleConn) Write(b []byte) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *puzzleConn) Close() error {
+	c.once.Do(func() {
+		c.err = xerrors.Errorf("puzzle: %w", ErrClosed)
+		atomic.AddInt64(&c.gate.pending, -1)
+	})
+	return c.Conn.Close()
+}
+
+// check gives the puzzle to the peer and checks its solution, once. The
+// deadline of the puzzle is kept for the next read, so that the peer can't
+// hold its connection by not sending its first message.
+func (c *puzzleConn) check() error {
+	c.once.Do(func() {
+		defer func() {
+			atomic.AddInt64(&c.gate.pending, -1)
+			if c.err != nil {
+				log.Lvl3("Puzzle of", c.Conn.RemoteAddr(), "failed:", c.err)
+				c.Conn.Close()
+			}
+		}()
+		c.Conn.SetDeadline(time.Now().Add(c.gate.Timeout))
+		var challenge [challengeSize]byte
+		challenge[0] = byte(c.gate.Difficulty)
+		if _, err := rand.Read(challenge[1:]); err != nil {
+			c.err = xerrors.Errorf("challenge: %v", err)
+			return
+		}
+		if _, err := c.Conn.Write(challenge[:]); err != nil {
+			c.err = xerrors.Errorf("sending challenge: %w", handleError(err))
+			return
+		}
+		var solution [solutionSize]byte
+		if _, err := io.ReadFull(c.Conn, solution[:]); err != nil {
+			c.err = xerrors.Errorf("reading solution: %w", handleError(err))
+			return
+		}
+		if !solves(challenge[:], solution[:], c.gate.Difficulty) {
+			c.err = xerrors.New("wrong solution of the puzzle")
+			return
+		}
+		c.Conn.SetWriteDeadline(time.Time{})
+	})
+	return c.err
+}
+
+// solvePuzzle reads the challenge of the host and sends back its solution.
+func solvePuzzle(c net.Conn) error {
+	c.SetDeadline(time.Now().Add(puzzleTimeout))
+	defer c.SetDeadline(time.Time{})
+	var challenge [challengeSize]byte
+	if _, err := io.ReadFull(c, challenge[:]); err != nil {
+		return xerrors.Errorf("reading challenge: %w", handleError(err))
+	}
+	difficulty := int(challenge[0])
+	if difficulty > maxPuzzleDifficulty {
+		return xerrors.Errorf("puzzle too hard: %d", difficulty)
+	}
+	var solution [solutionSize]byte
+	for nonce := uint64(0); ; nonce++ {
+		binary.BigEndian.PutUint64(solution[:], nonce)
+		if solves(challenge[:], solution[:], difficulty) {
+			break
+		}
+	}
+	if _, err := c.Write(solution[:]); err != nil {
+		return xerrors.Errorf("sending solution: %w", handleError(err))
+	}
+	return nil
+}
+
+// solves returns whether the hash of the challenge and the solution starts
+// with difficulty zero bits.
+func solves(challenge, solution []byte, difficulty int) bool {
+	h := sha256.New()
+	h.Write(challenge)
+	h.Write(solution)
+	hash := h.Sum(nil)
+	zeros := 0
+	for _, b := range hash {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouterPuzzle(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	h2, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	for _, h := range []*Router{h1, h2} {
+		require.NoError(t, h.SetPuzzle(Puzzle{Difficulty: 8}))
+	}
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+	waitListening(t, h1, h2)
+
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h1.RegisterProcessor(proc, SimpleMessageType)
+	h2.RegisterProcessor(proc, SimpleMessageType)
+	_, err = h1.Send(h2.ServerIdentity, &SimpleMessage{3})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), (<-proc.relay).I)
+	_, err = h2.Send(h1.ServerIdentity, &SimpleMessage{4})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), (<-proc.relay).I)
+	require.Error(t, h1.SetPuzzle(Puzzle{Difficulty: 1}))
+
+	h, err := NewTestRouterLocal(0)
+	require.NoError(t, err)
+	require.Error(t, h.SetPuzzle(Puzzle{Difficulty: 1}))
+}
+
+func TestRouterPuzzle_refused(t *testing.T) {
+	h1, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	require.Error(t, h1.SetPuzzle(Puzzle{Difficulty: maxPuzzleDifficulty + 1}))
+	require.NoError(t, h1.SetPuzzle(Puzzle{Difficulty: 8, MaxPending: 1,
+		Timeout: 100 * time.Millisecond}))
+	h2, err := NewTestRouterTCP(0)
+	require.NoError(t, err)
+	go h1.Start()
+	go h2.Start()
+	defer h1.Stop()
+	defer h2.Stop()
+	waitListening(t, h1, h2)
+
+	// a peer without the puzzle doesn't get through
+	proc := &simpleMessageProc{t, make(chan SimpleMessage)}
+	h1.RegisterProcessor(proc, SimpleMessageType)
+	h2.Send(h1.ServerIdentity, &SimpleMessage{3})
+	select {
+	case <-proc.relay:
+		require.Fail(t, "message without the puzzle")
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.Empty(t, h1.Connections())
+
+	// nor a second one while the first has its puzzle
+	addr := h1.ServerIdentity.Address.NetworkAddress()
+	c1, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = c2.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "timeout")
+}
+
+func TestSolvePuzzle(t *testing.T) {
+	for difficulty := 0; difficulty <= 12; difficulty += 4 {
+		challenge := make([]byte, challengeSize)
+		challenge[0] = byte(difficulty)
+		c, s := net.Pipe()
+		done := make(chan error)
+		go func() { done <- solvePuzzle(s) }()
+		_, err := c.Write(challenge)
+		require.NoError(t, err)
+		solution := make([]byte, solutionSize)
+		_, err = c.Read(solution)
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		require.True(t, solves(challenge, solution, difficulty))
+	}
+	require.False(t, solves([]byte("challenge"), nil, 256+1))
+
+	c, s := net.Pipe()
+	go c.Write(append([]byte{maxPuzzleDifficulty + 1}, make([]byte, challengeSize-1)...))
+	err := solvePuzzle(s)
+	require.Error(t, err)
+}
+
+func waitListening(t *testing.T, routers ...*Router) {
+	for _, r := range routers {
+		for i := 0; !r.Listening(); i++ {
+			require.True(t, i < 100, "not listening")
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"strings"
 	"sync"
+	"time"
 
 	"go.dedis.ch/onet/v4/log"
 	"golang.org/x/xerrors"
@@ -58,6 +59,24 @@ type Router struct {
 	UnauthOk bool
 	// Quiets the startup of the server if set to true.
 	Quiet bool
+	// Describe sends the messages to the other servers as DescribedMessages,
+	// so that the ones that don't know their types can still decode them.
+	// The messages with their own encoding are sent as they are.
+	Describe bool
+	// linkConditions returns the network to emulate to a remote server
+	linkConditions func(*ServerIdentity) *LinkConditions
+	// offline holds the messages that pass while the router is offline, it
+	// is nil while online
+	offline map[MessageTypeID]bool
+	// faults returns the faults to inject in the messages received
+	faults    FaultFunc
+	faultSeed int64
+	// blocked are the servers whose messages are dropped
+	blocked map[ServerIdentityID]bool
+	// capabilities are the ones advertised by the router, and
+	// peerCapabilities the ones the remote servers announced
+	capabilities     map[string]string
+	peerCapabilities map[ServerIdentityID]map[string]string
 }
 
 // NewRouter returns a new Router attached to a ServerIdentity and the host we want to
@@ -118,6 +137,7 @@ func (r *Router) Start() {
 			}
 			return
 		}
+		c = r.conditionConn(dst, r.faultConn(dst, c))
 		if err := r.registerConnection(dst, c); err != nil {
 			log.Lvl3(r.address, "does not accept incoming connection from", c.Remote(), "because it's closed")
 			return
@@ -128,6 +148,9 @@ func (r *Router) Start() {
 			log.Lvl3(r.address, "does not accept incoming connection from", c.Remote(), "because it's closed")
 			return
 		}
+		if _, err := r.announce(c); err != nil {
+			log.Lvl3(r.address, "couldn't announce the capabilities to", c.Remote(), ":", err)
+		}
 	})
 	if err != nil {
 		log.Error("Error listening:", err)
@@ -170,6 +193,9 @@ func (r *Router) Send(e *ServerIdentity, msg Message) (uint64, error) {
 	if msg == nil {
 		return 0, xerrors.New("Can't send nil-packet")
 	}
+	if !r.passes(MessageType(msg)) {
+		return 0, xerrors.Errorf("sending: %w", ErrOffline)
+	}
 
 	// Update the message counter with the new message about to be sent.
 	r.msgTraffic.updateTx(1)
@@ -195,6 +221,14 @@ func (r *Router) Send(e *ServerIdentity, msg Message) (uint64, error) {
 		return uint64(len(b)), nil
 	}
 
+	if r.Describe && !ownEncoding(msg) {
+		d, err := DescribeMessage(msg)
+		if err != nil {
+			return 0, xerrors.Errorf("describing: %v", err)
+		}
+		msg = d
+	}
+
 	var totSentLen uint64
 	c := r.connection(e.ID)
 	if c == nil {
@@ -241,7 +275,13 @@ func (r *Router) connect(si *ServerIdentity) (Conn, uint64, error) {
 	if sentLen, err = c.Send(r.ServerIdentity); err != nil {
 		return nil, sentLen, xerrors.Errorf("sending: %v", err)
 	}
+	annLen, err := r.announce(c)
+	sentLen += annLen
+	if err != nil {
+		return nil, sentLen, xerrors.Errorf("announcing capabilities: %v", err)
+	}
 
+	c = r.conditionConn(si, r.faultConn(si, c))
 	if err = r.registerConnection(si, c); err != nil {
 		return nil, sentLen, xerrors.Errorf("register connection: %v", err)
 	}
@@ -345,6 +385,19 @@ func (r *Router) handleConn(remote *ServerIdentity, c Conn) {
 		}
 
 		packet.ServerIdentity = remote
+		if !r.passes(packet.MsgType) {
+			log.Lvl5(r.address, "is offline and drops a message from", remote.Address)
+			continue
+		}
+		if r.blocks(remote) {
+			log.Lvl5(r.address, "is partitioned and drops a message from", remote.Address)
+			continue
+		}
+
+		if packet.MsgType == CapabilityAnnouncementType {
+			r.storeCapabilities(remote, packet.Msg.(*CapabilityAnnouncement))
+			continue
+		}
 
 		// Update the message counter with the new message about to be processed.
 		r.msgTraffic.updateRx(1)
@@ -405,6 +458,45 @@ func (r *Router) Closed() bool {
 	return r.isClosed
 }
 
+// ConnectionStatus describes a connection of the router, as returned by
+// Connections.
+type ConnectionStatus struct {
+	// ID is the ServerIdentity of the remote end of the connection.
+	ID     ServerIdentityID
+	Type   ConnType
+	Local  Address
+	Remote Address
+	Tx     uint64
+	Rx     uint64
+	// RTT is the round-trip time measured by the kernel, or 0 if it isn't
+	// known, like on the local connections.
+	RTT time.Duration
+}
+
+// Connections returns the state of all the connections of the router.
+func (r *Router) Connections() []ConnectionStatus {
+	r.Lock()
+	defer r.Unlock()
+	var ret []ConnectionStatus
+	for id, arr := range r.connections {
+		for _, c := range arr {
+			cs := ConnectionStatus{
+				ID:     id,
+				Type:   c.Type(),
+				Local:  c.Local(),
+				Remote: c.Remote(),
+				Tx:     c.Tx(),
+				Rx:     c.Rx(),
+			}
+			if tc, ok := unwrapConn(c).(*TCPConn); ok {
+				cs.RTT = tcpRTT(tc.tcpConn())
+			}
+			ret = append(ret, cs)
+		}
+	}
+	return ret
+}
+
 // Tx implements monitor/CounterIO
 // It returns the Tx for all connections managed by this router
 func (r *Router) Tx() uint64 {
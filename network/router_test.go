@@ -1,6 +1,7 @@
 package network
 
 import (
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -545,6 +546,29 @@ func TestRouterRxTx(t *testing.T) {
 	defer router1.Stop()
 }
 
+func TestRouterConnections(t *testing.T) {
+	router1, err := NewTestRouterTCP(0)
+	log.ErrFatal(err)
+	router2, err := NewTestRouterTCP(0)
+	log.ErrFatal(err)
+	go router1.Start()
+	go router2.Start()
+	defer router1.Stop()
+	defer router2.Stop()
+
+	_, err = router2.Send(router1.ServerIdentity, router1.ServerIdentity)
+	require.Nil(t, err)
+
+	conns := router2.Connections()
+	require.Equal(t, 1, len(conns))
+	require.Equal(t, router1.ServerIdentity.ID, conns[0].ID)
+	require.Equal(t, PlainTCP, conns[0].Type)
+	require.NotZero(t, conns[0].Tx)
+	if runtime.GOOS == "linux" {
+		require.NotZero(t, conns[0].RTT)
+	}
+}
+
 func waitTimeout(timeout time.Duration, repeat int,
 	f func() bool) {
 	success := make(chan bool)
@@ -0,0 +1,13 @@
+// +build !linux
+
+package network
+
+import (
+	"net"
+	"time"
+)
+
+// tcpRTT is only implemented on Linux.
+func tcpRTT(c net.Conn) time.Duration {
+	return 0
+}
@@ -0,0 +1,222 @@
+package network
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduler delivers the messages of the local connections of a LocalManager
+// one at a time, in an order that only depends on its policy. Before each
+// delivery, it waits for the servers to settle, that is for no message to be
+// sent during the settle time. This makes a run with local connections
+// reproducible, as long as the servers don't depend on the real time.
+type Scheduler struct {
+	policy SchedulePolicy
+	settle time.Duration
+	// the messages waiting to be delivered, by connection
+	queues map[endpoint]*scheduledQueue
+	// when the last message has been sent or delivered
+	last time.Time
+	// idle is called when there is nothing to deliver, it returns whether
+	// it did something that might send messages
+	idle    func() bool
+	manager *LocalManager
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	sync.Mutex
+}
+
+// ScheduledMessage is a message waiting to be delivered by a Scheduler.
+type ScheduledMessage struct {
+	// From is the address of the sender, and To the one of the receiver.
+	From, To Address
+}
+
+// SchedulePolicy chooses the message a Scheduler delivers next, among the
+// first waiting message of each connection, which are given in an order that
+// doesn't change between runs. It returns the index of the message, an index
+// out of range delivers the first one. It is called with the messages held,
+// so it must not wait for the network.
+type SchedulePolicy func(msgs []ScheduledMessage) int
+
+// RandomPolicy returns the policy choosing the messages at random, in an
+// order that only depends on the seed.
+func RandomPolicy(seed int64) SchedulePolicy {
+	r := rand.New(rand.NewSource(seed))
+	return func(msgs []ScheduledMessage) int {
+		return r.Intn(len(msgs))
+	}
+}
+
+// NewScheduler returns a scheduler choosing the messages with the seed,
+// which waits for the settle time before each delivery.
+func NewScheduler(seed int64, settle time.Duration) *Scheduler {
+	return NewSchedulerWithPolicy(RandomPolicy(seed), settle)
+}
+
+// NewSchedulerWithPolicy returns a scheduler choosing the messages with the
+// policy, which waits for the settle time before each delivery.
+func NewSchedulerWithPolicy(policy SchedulePolicy, settle time.Duration) *Scheduler {
+	return &Scheduler{
+		policy: policy,
+		settle: settle,
+		queues: make(map[endpoint]*scheduledQueue),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// SetIdle sets the function called when there is nothing to deliver, like
+// VirtualClock.AdvanceToNext of onet. It returns whether it did something,
+// else the scheduler waits for the next message.
+func (s *Scheduler) SetIdle(f func() bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.idle = f
+}
+
+// SetScheduler makes the scheduler deliver the messages of the connections
+// of the manager. It must be called before any connection is made.
+func (lm *LocalManager) SetScheduler(s *Scheduler) {
+	lm.Lock()
+	lm.scheduler = s
+	lm.Unlock()
+	s.Lock()
+	s.manager = lm
+	s.Unlock()
+	go s.run()
+}
+
+// Stop ends the deliveries of the scheduler.
+func (s *Scheduler) Stop() {
+	s.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	started := s.manager != nil
+	s.Unlock()
+	if started {
+		<-s.done
+	}
+}
+
+// the messages waiting for a connection
+type scheduledQueue struct {
+	// from is the address of the sender
+	from Address
+	msgs []localPacket
+}
+
+// enqueue holds a message from the address for the connection at the
+// endpoint.
+func (s *Scheduler) enqueue(e endpoint, from Address, msg localPacket) {
+	s.Lock()
+	q := s.queues[e]
+	if q == nil {
+		q = &scheduledQueue{from: from}
+		s.queues[e] = q
+	}
+	q.msgs = append(q.msgs, msg)
+	s.last = time.Now()
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	for {
+		if !s.waitSettled() {
+			return
+		}
+		e, msg, ok := s.next()
+		if ok {
+			s.manager.deliver(e, msg)
+			continue
+		}
+		s.Lock()
+		idle := s.idle
+		s.Unlock()
+		if idle != nil && idle() {
+			s.Lock()
+			s.last = time.Now()
+			s.Unlock()
+			continue
+		}
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// waitSettled waits until nothing happened during the settle time. It
+// returns false if the scheduler is stopped.
+func (s *Scheduler) waitSettled() bool {
+	for {
+		s.Lock()
+		wait := s.settle - time.Since(s.last)
+		s.Unlock()
+		if wait <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(wait):
+		case <-s.stop:
+			return false
+		}
+	}
+}
+
+// next removes the next message to deliver, chosen by the policy among the
+// connections with messages waiting. The messages of a connection stay in
+// order.
+func (s *Scheduler) next() (endpoint, localPacket, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.queues) == 0 {
+		return endpoint{}, localPacket{}, false
+	}
+	// sort the connections, as the order of a map changes between runs
+	eps := make([]endpoint, 0, len(s.queues))
+	for e := range s.queues {
+		eps = append(eps, e)
+	}
+	sort.Slice(eps, func(i, j int) bool {
+		a, b := eps[i], eps[j]
+		if a.addr != b.addr {
+			return a.addr < b.addr
+		}
+		if s.queues[a].from != s.queues[b].from {
+			return s.queues[a].from < s.queues[b].from
+		}
+		return a.uid < b.uid
+	})
+	msgs := make([]ScheduledMessage, len(eps))
+	for i, e := range eps {
+		msgs[i] = ScheduledMessage{From: s.queues[e].from, To: e.addr}
+	}
+	i := s.policy(msgs)
+	if i < 0 || i >= len(eps) {
+		i = 0
+	}
+	e := eps[i]
+	q := s.queues[e]
+	msg := q.msgs[0]
+	if len(q.msgs) == 1 {
+		delete(s.queues, e)
+	} else {
+		q.msgs = q.msgs[1:]
+	}
+	s.last = time.Now()
+	return e, msg, true
+}
@@ -0,0 +1,134 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// schedulerRun sends messages from two routers to a third one and returns
+// the order in which it got them.
+func schedulerRun(t *testing.T, seed int64) []string {
+	lm := NewLocalManager()
+	defer lm.Stop()
+	s := NewScheduler(seed, 10*time.Millisecond)
+	idle := make(chan bool, 100)
+	s.SetIdle(func() bool {
+		idle <- true
+		return false
+	})
+	lm.SetScheduler(s)
+	defer s.Stop()
+
+	var routers []*Router
+	for i := 0; i < 3; i++ {
+		addr := NewLocalAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))
+		r, err := NewLocalRouterWithManager(lm, NewTestServerIdentity(addr), tSuite)
+		require.NoError(t, err)
+		go r.Start()
+		defer r.Stop()
+		routers = append(routers, r)
+	}
+	received := make(chan string, 10)
+	routers[0].RegisterProcessorFunc(SimpleMessageType, func(env *Envelope) error {
+		received <- fmt.Sprintf("%s-%d", env.ServerIdentity.Address.Port(), env.Msg.(*SimpleMessage).I)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		for _, r := range routers[1:] {
+			_, err := r.Send(routers[0].ServerIdentity, &SimpleMessage{int64(i)})
+			require.NoError(t, err)
+		}
+	}
+
+	var order []string
+	for len(order) < 10 {
+		select {
+		case o := <-received:
+			order = append(order, o)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "didn't get the messages")
+		}
+	}
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		require.Fail(t, "idle not called")
+	}
+	return order
+}
+
+func TestScheduler(t *testing.T) {
+	order := schedulerRun(t, 1)
+	require.Equal(t, order, schedulerRun(t, 1))
+	require.NotEqual(t, order, schedulerRun(t, 2))
+
+	// the messages of a connection stay in order
+	next := make(map[string]int)
+	for _, o := range order {
+		var port string
+		var i int
+		_, err := fmt.Sscanf(o, "%4s-%d", &port, &i)
+		require.NoError(t, err)
+		require.Equal(t, next[port], i)
+		next[port]++
+	}
+}
+
+// policyRun sends a message from two routers to a third one with the policy
+// and returns the senders in the order the messages were received.
+func policyRun(t *testing.T, policy SchedulePolicy) []string {
+	lm := NewLocalManager()
+	defer lm.Stop()
+	s := NewSchedulerWithPolicy(policy, 10*time.Millisecond)
+	lm.SetScheduler(s)
+	defer s.Stop()
+
+	var routers []*Router
+	for i := 0; i < 3; i++ {
+		addr := NewLocalAddress(fmt.Sprintf("127.0.0.1:%d", 2000+i))
+		r, err := NewLocalRouterWithManager(lm, NewTestServerIdentity(addr), tSuite)
+		require.NoError(t, err)
+		go r.Start()
+		defer r.Stop()
+		routers = append(routers, r)
+	}
+	received := make(chan string, 10)
+	routers[0].RegisterProcessorFunc(SimpleMessageType, func(env *Envelope) error {
+		received <- env.ServerIdentity.Address.Port()
+		return nil
+	})
+	for _, r := range routers[1:] {
+		_, err := r.Send(routers[0].ServerIdentity, &SimpleMessage{1})
+		require.NoError(t, err)
+	}
+	var order []string
+	for len(order) < 2 {
+		select {
+		case o := <-received:
+			order = append(order, o)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "didn't get the messages")
+		}
+	}
+	return order
+}
+
+func TestScheduler_Policy(t *testing.T) {
+	var msgs [][]ScheduledMessage
+	first := func(m []ScheduledMessage) int {
+		msgs = append(msgs, m)
+		return 0
+	}
+	last := func(m []ScheduledMessage) int {
+		return len(m) - 1
+	}
+	require.Equal(t, []string{"2001", "2002"}, policyRun(t, first))
+	require.Equal(t, []string{"2002", "2001"}, policyRun(t, last))
+	require.NotEmpty(t, msgs)
+	for _, m := range msgs {
+		require.NotEmpty(t, m)
+	}
+}
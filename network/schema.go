@@ -0,0 +1,241 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// Schema describes the wire format of the registered messages, so that the
+// formats of two binaries can be compared. It is saved as indented JSON with
+// sorted keys, so that the files of two releases can also be compared with
+// diff.
+type Schema struct {
+	// Messages maps the MessageTypeIDs to the names of their types.
+	Messages map[string]string
+	// Types maps the names of the structs used by the messages to their
+	// fields.
+	Types map[string][]SchemaField
+}
+
+// SchemaField is a field of a struct, as protobuf encodes it.
+type SchemaField struct {
+	ID   int64
+	Name string
+	Type string
+}
+
+// RegistrySchema returns the schema of all the registered messages. The
+// messages with their own encoding get the kind network, without their
+// fields.
+func RegistrySchema() *Schema {
+	s := &Schema{
+		Messages: make(map[string]string),
+		Types:    make(map[string][]SchemaField),
+	}
+	for id, typ := range RegisteredMessages() {
+		if customEncoding(typ) {
+			s.Messages[uuid.UUID(id).String()] = typ.String() + "(network)"
+			continue
+		}
+		s.Messages[uuid.UUID(id).String()] = s.typeName(typ)
+	}
+	return s
+}
+
+// LoadSchema reads a schema saved with Schema.Save.
+func LoadSchema(file string) (*Schema, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("reading schema: %v", err)
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(buf, s); err != nil {
+		return nil, xerrors.Errorf("decoding schema: %v", err)
+	}
+	return s, nil
+}
+
+// Save writes the schema to the file.
+func (s *Schema) Save(file string) error {
+	buf, err := s.encode()
+	if err != nil {
+		return xerrors.Errorf("encoding schema: %v", err)
+	}
+	if err := ioutil.WriteFile(file, buf, 0644); err != nil {
+		return xerrors.Errorf("writing schema: %v", err)
+	}
+	return nil
+}
+
+// Hash returns the hash of the schema. Two binaries with the same hash
+// encode the messages the same way, so the routers send the one of their
+// registry in the CapabilityAnnouncement of every new connection instead of
+// the whole schema.
+func (s *Schema) Hash() []byte {
+	buf, err := s.encode()
+	if err != nil {
+		// the schema only holds strings and numbers
+		panic("encoding schema: " + err.Error())
+	}
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// Diff returns the differences from the schema to the newer one, one per
+// line and sorted. The ones that break the wire format start with
+// "breaking: ".
+func (s *Schema) Diff(newer *Schema) []string {
+	var diff []string
+	for id, name := range s.Messages {
+		if _, ok := newer.Messages[id]; !ok {
+			diff = append(diff, fmt.Sprintf("breaking: message %s %s removed", id, name))
+		}
+	}
+	for id, name := range newer.Messages {
+		if _, ok := s.Messages[id]; !ok {
+			diff = append(diff, fmt.Sprintf("message %s %s added", id, name))
+		}
+	}
+	for name, fields := range s.Types {
+		newFields, ok := newer.Types[name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("type %s removed", name))
+			continue
+		}
+		diff = append(diff, diffFields(name, fields, newFields)...)
+	}
+	for name := range newer.Types {
+		if _, ok := s.Types[name]; !ok {
+			diff = append(diff, fmt.Sprintf("type %s added", name))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Compatible returns an error listing the differences of Diff that keep a
+// binary with the newer schema from talking with one with this schema.
+func (s *Schema) Compatible(newer *Schema) error {
+	var breaking []string
+	for _, d := range s.Diff(newer) {
+		if strings.HasPrefix(d, "breaking: ") {
+			breaking = append(breaking, strings.TrimPrefix(d, "breaking: "))
+		}
+	}
+	if len(breaking) > 0 {
+		return xerrors.Errorf("incompatible schemas: %s", strings.Join(breaking, ", "))
+	}
+	return nil
+}
+
+// diffFields compares the fields of a struct by their protobuf IDs, as the
+// names are not sent.
+func diffFields(name string, old, newer []SchemaField) []string {
+	var diff []string
+	fields := make(map[int64]SchemaField)
+	for _, f := range newer {
+		fields[f.ID] = f
+	}
+	for _, f := range old {
+		nf, ok := fields[f.ID]
+		delete(fields, f.ID)
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("breaking: %s.%s (%d) removed", name, f.Name, f.ID))
+		case nf.Type != f.Type:
+			diff = append(diff, fmt.Sprintf("breaking: %s.%s (%d) changed from %s to %s",
+				name, f.Name, f.ID, f.Type, nf.Type))
+		case nf.Name != f.Name:
+			diff = append(diff, fmt.Sprintf("%s.%s (%d) renamed to %s", name, f.Name, f.ID, nf.Name))
+		}
+	}
+	for _, f := range fields {
+		diff = append(diff, fmt.Sprintf("%s.%s (%d) added", name, f.Name, f.ID))
+	}
+	return diff
+}
+
+// encode returns the canonical encoding of the schema.
+func (s *Schema) encode() ([]byte, error) {
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
+
+// the types that protobuf encodes with a fixed size, with their kinds in the
+// schema
+var fixedKinds = map[reflect.Type]string{
+	reflect.TypeOf(time.Time{}):          "sfixed64",
+	reflect.TypeOf(protobuf.Sfixed32(0)): "sfixed32",
+	reflect.TypeOf(protobuf.Sfixed64(0)): "sfixed64",
+	reflect.TypeOf(protobuf.Ufixed32(0)): "ufixed32",
+	reflect.TypeOf(protobuf.Ufixed64(0)): "ufixed64",
+}
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+// typeName returns the name of the type in the schema, and adds the structs
+// it holds to the types of the schema. The named types that are not structs
+// get their kind, as changing it changes the encoding, and the structs that
+// encode themselves get the kind binary.
+func (s *Schema) typeName(t reflect.Type) string {
+	if kind, ok := fixedKinds[t]; ok {
+		return t.String() + "(" + kind + ")"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + s.typeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + s.typeName(t.Elem())
+	case reflect.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), s.typeName(t.Elem()))
+	case reflect.Map:
+		return "map[" + s.typeName(t.Key()) + "]" + s.typeName(t.Elem())
+	case reflect.Struct:
+		if t.Implements(binaryMarshalerType) {
+			return t.String() + "(binary)"
+		}
+		name := t.String()
+		if _, ok := s.Types[name]; !ok {
+			// the placeholder stops the recursive types
+			s.Types[name] = nil
+			s.Types[name] = s.fields(t)
+		}
+		return name
+	case reflect.Interface:
+		return t.String()
+	}
+	if t.String() != t.Kind().String() {
+		return t.String() + "(" + t.Kind().String() + ")"
+	}
+	return t.String()
+}
+
+// fields returns the fields of the struct that protobuf encodes.
+func (s *Schema) fields(t reflect.Type) []SchemaField {
+	fields := []SchemaField{}
+	for _, f := range protobuf.ProtoFields(t) {
+		if f.Field.PkgPath != "" {
+			continue
+		}
+		fields = append(fields, SchemaField{
+			ID:   f.ID,
+			Name: f.Field.Name,
+			Type: s.typeName(f.Field.Type),
+		})
+	}
+	return fields
+}
@@ -0,0 +1,83 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+func TestRegistrySchema(t *testing.T) {
+	s := RegistrySchema()
+	id := uuid.UUID(MessageType(&SimpleMessage{})).String()
+	require.Equal(t, "network.SimpleMessage", s.Messages[id])
+	require.Equal(t, []SchemaField{{ID: 1, Name: "I", Type: "int64"}},
+		s.Types["network.SimpleMessage"])
+	require.Equal(t, []SchemaField{{ID: 1, Name: "Val", Type: "int64"},
+		{ID: 2, Name: "Next", Type: "*network.nestedMsg"}}, s.Types["network.nestedMsg"])
+	require.Contains(t, s.Types["network.ServerIdentity"],
+		SchemaField{ID: 1, Name: "Public", Type: "kyber.Point"})
+	require.Contains(t, s.Types["network.ServerIdentity"],
+		SchemaField{ID: 2, Name: "ServiceIdentities", Type: "[]network.ServiceIdentity"})
+	require.Contains(t, s.Types["network.ServerIdentity"],
+		SchemaField{ID: 4, Name: "Address", Type: "network.Address(string)"})
+	require.Empty(t, s.Diff(RegistrySchema()))
+	require.Equal(t, s.Hash(), RegistrySchema().Hash())
+
+	dir, err := ioutil.TempDir("", "schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "schema.json")
+	require.NoError(t, s.Save(file))
+	loaded, err := LoadSchema(file)
+	require.NoError(t, err)
+	require.Equal(t, s, loaded)
+	require.Equal(t, s.Hash(), loaded.Hash())
+	_, err = LoadSchema(path.Join(dir, "none"))
+	require.Error(t, err)
+}
+
+func TestSchema_Diff(t *testing.T) {
+	old := &Schema{
+		Messages: map[string]string{"1": "a.A", "2": "a.B"},
+		Types: map[string][]SchemaField{
+			"a.A": {{1, "X", "int64"}, {2, "Y", "string"}, {3, "Z", "bool"}},
+			"a.B": {},
+		},
+	}
+	newer := &Schema{
+		Messages: map[string]string{"1": "a.A", "3": "a.C"},
+		Types: map[string][]SchemaField{
+			"a.A": {{1, "X", "int32"}, {2, "Name", "string"}, {4, "W", "bool"}},
+			"a.C": {},
+		},
+	}
+	require.Equal(t, []string{
+		"a.A.W (4) added",
+		"a.A.Y (2) renamed to Name",
+		"breaking: a.A.X (1) changed from int64 to int32",
+		"breaking: a.A.Z (3) removed",
+		"breaking: message 2 a.B removed",
+		"message 3 a.C added",
+		"type a.B removed",
+		"type a.C added",
+	}, old.Diff(newer))
+	err := old.Compatible(newer)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a.A.Z (3) removed")
+	require.NotContains(t, err.Error(), "renamed")
+
+	// adding messages and fields keeps the old binaries working
+	newer = &Schema{
+		Messages: map[string]string{"1": "a.A", "2": "a.B", "3": "a.C"},
+		Types: map[string][]SchemaField{
+			"a.A": {{1, "X", "int64"}, {2, "Y", "string"}, {3, "Z", "bool"}, {4, "W", "bool"}},
+			"a.B": {},
+		},
+	}
+	require.NoError(t, old.Compatible(newer))
+	require.Error(t, newer.Compatible(old))
+}
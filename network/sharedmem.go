@@ -0,0 +1,120 @@
+package network
+
+import (
+	"bytes"
+	"reflect"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// ErrMessageModified is returned by the Receive of a local connection
+// sharing memory when a checked message was modified after it was sent.
+var ErrMessageModified = xerrors.New("message modified after it was sent")
+
+// localPacket is what the local connections carry: the marshaled message, or
+// with shared memory the message itself.
+type localPacket struct {
+	buf []byte
+	msg Message
+	typ MessageTypeID
+	// check is the encoding of msg when it was sent, if it is checked
+	check []byte
+}
+
+// SetSharedMemory makes the local connections of the manager pass the
+// messages by reference instead of marshaling them, so that thousands of
+// servers can run in a process. The receiver gets the very message given to
+// Send, or a pointer to a copy of it if it isn't a pointer, so neither side
+// may modify a message once it is sent: the messages are shared as if they
+// were copy-on-write, but without the copy. With checkEvery above zero, one
+// message in checkEvery is marshaled when sent and again when received, and
+// dropped with ErrMessageModified if it changed in between, to find the
+// protocols that don't follow the rule.
+//
+// The sizes of the messages aren't known with shared memory, so the
+// connections only count the bytes of the checked messages. It must be set
+// before the servers are created.
+func (lm *LocalManager) SetSharedMemory(enabled bool, checkEvery int) {
+	lm.Lock()
+	defer lm.Unlock()
+	lm.shared = enabled
+	lm.checkEvery = checkEvery
+}
+
+// SharedMemory returns whether the local connections of the manager pass the
+// messages by reference.
+func (lm *LocalManager) SharedMemory() bool {
+	lm.Lock()
+	defer lm.Unlock()
+	return lm.shared
+}
+
+// packet returns what a connection carries for the message. The
+// ServerIdentity exchanged when connecting is always marshaled, as the one of
+// the sender holds its private key.
+func (lm *LocalManager) packet(msg Message) (localPacket, error) {
+	lm.Lock()
+	shared := lm.shared
+	check := false
+	if shared && lm.checkEvery > 0 {
+		lm.sent++
+		check = lm.sent%uint64(lm.checkEvery) == 0
+	}
+	lm.Unlock()
+
+	typ := MessageType(msg)
+	if !shared || typ.Equal(ServerIdentityType) {
+		buf, err := Marshal(msg)
+		return localPacket{buf: buf}, err
+	}
+	if typ.Equal(ErrorType) {
+		return localPacket{}, xerrors.Errorf("type of message %s not registered", reflect.TypeOf(msg))
+	}
+	if v := reflect.ValueOf(msg); v.Kind() != reflect.Ptr {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		msg = ptr.Interface()
+	}
+	p := localPacket{msg: msg, typ: typ}
+	if check {
+		var err error
+		if p.check, err = Marshal(msg); err != nil {
+			return localPacket{}, err
+		}
+	}
+	return p, nil
+}
+
+// size returns the number of bytes of the packet, zero for a message shared
+// without check.
+func (p localPacket) size() uint64 {
+	if p.msg != nil {
+		return uint64(len(p.check))
+	}
+	return uint64(len(p.buf))
+}
+
+// envelope returns the envelope of a shared message, after checking it if it
+// is checked.
+func (p localPacket) envelope() (*Envelope, error) {
+	if p.check != nil {
+		now, err := Marshal(p.msg)
+		if err != nil || !bytes.Equal(now, p.check) {
+			log.Errorf("%s was modified after it was sent", reflect.TypeOf(p.msg))
+			return nil, xerrors.Errorf("%s: %w", reflect.TypeOf(p.msg), ErrMessageModified)
+		}
+	}
+	return &Envelope{
+		MsgType: p.typ,
+		Msg:     p.msg,
+		Size:    Size(len(p.check)),
+	}, nil
+}
+
+// SharedMemory returns whether the router passes the messages by reference,
+// with the local connections of a LocalManager sharing memory.
+func (r *Router) SharedMemory() bool {
+	lh, ok := r.host.(*LocalHost)
+	return ok && lh.lm.SharedMemory()
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// sharedConns returns the two sides of a local connection of a manager
+// sharing memory, and the function stopping it.
+func sharedConns(t *testing.T, checkEvery int) (*LocalManager, Conn, Conn, func()) {
+	lm := NewLocalManager()
+	lm.SetSharedMemory(true, checkEvery)
+	addr := NewLocalAddress("127.0.0.1:2000")
+	listener, err := NewLocalListenerWithManager(lm, addr, tSuite)
+	require.NoError(t, err)
+	incoming := make(chan Conn, 1)
+	go listener.Listen(func(c Conn) {
+		incoming <- c
+	})
+	for !listener.Listening() {
+		time.Sleep(time.Millisecond)
+	}
+	outgoing, err := NewLocalConnWithManager(lm, NewLocalAddress("127.0.0.1:2001"), addr, tSuite)
+	require.NoError(t, err)
+	return lm, outgoing, <-incoming, func() {
+		require.NoError(t, listener.Stop())
+		lm.Stop()
+	}
+}
+
+func TestLocalManager_SharedMemory(t *testing.T) {
+	lm, out, in, stop := sharedConns(t, 0)
+	defer stop()
+	require.True(t, lm.SharedMemory())
+
+	msg := &SimpleMessage{3}
+	_, err := out.Send(msg)
+	require.NoError(t, err)
+	env, err := in.Receive()
+	require.NoError(t, err)
+	require.Equal(t, SimpleMessageType, env.MsgType)
+	require.True(t, env.Msg.(*SimpleMessage) == msg)
+
+	// a value arrives as a pointer
+	_, err = out.Send(SimpleMessage{4})
+	require.NoError(t, err)
+	env, err = in.Receive()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), env.Msg.(*SimpleMessage).I)
+
+	// the ServerIdentity is marshaled
+	si := NewTestServerIdentity(NewLocalAddress("127.0.0.1:2002"))
+	_, err = out.Send(si)
+	require.NoError(t, err)
+	env, err = in.Receive()
+	require.NoError(t, err)
+	require.False(t, env.Msg.(*ServerIdentity) == si)
+	require.True(t, env.Msg.(*ServerIdentity).Equal(si))
+}
+
+func TestLocalManager_SharedMemoryCheck(t *testing.T) {
+	_, out, in, stop := sharedConns(t, 2)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		msg := &SimpleMessage{3}
+		_, err := out.Send(msg)
+		require.NoError(t, err)
+		// the first one isn't checked, the second one is
+		msg.I = 4
+	}
+	env, err := in.Receive()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), env.Msg.(*SimpleMessage).I)
+	_, err = in.Receive()
+	require.True(t, xerrors.Is(err, ErrMessageModified))
+}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"io"
 	"net"
@@ -67,6 +68,9 @@ func SetTCPDialTimeout(dur time.Duration) {
 type TCPConn struct {
 	// The connection used
 	conn net.Conn
+	// the TCP connection under conn if it is a TLS connection, to get its
+	// round-trip time
+	raw net.Conn
 
 	// the suite used to unmarshal messages
 	suite Suite
@@ -88,10 +92,20 @@ type TCPConn struct {
 // NewTCPConn will open a TCPConn to the given address.
 // In case of an error it returns a nil TCPConn and the error.
 func NewTCPConn(addr Address, suite Suite) (conn *TCPConn, err error) {
+	return newTCPConn(addr, suite, false)
+}
+
+// newTCPConn is NewTCPConn, that solves the puzzle of the peer if asked.
+func newTCPConn(addr Address, suite Suite, puzzle bool) (conn *TCPConn, err error) {
 	netAddr := addr.NetworkAddress()
 	for i := 1; i <= MaxRetryConnect; i++ {
 		var c net.Conn
 		c, err = net.DialTimeout("tcp", netAddr, dialTimeout)
+		if err == nil && puzzle {
+			if err = solvePuzzle(c); err != nil {
+				c.Close()
+			}
+		}
 		if err == nil {
 			conn = &TCPConn{
 				conn:  c,
@@ -142,45 +156,69 @@ func (c *TCPConn) receiveRaw() ([]byte, error) {
 func (c *TCPConn) receiveRawProd() ([]byte, error) {
 	c.receiveMutex.Lock()
 	defer c.receiveMutex.Unlock()
-	timeoutLock.RLock()
-	c.conn.SetReadDeadline(time.Now().Add(timeout))
-	timeoutLock.RUnlock()
-	// First read the size
+	buf, read, err := readFrame(c.conn, func(limit time.Time) {
+		timeoutLock.RLock()
+		deadline := time.Now().Add(timeout)
+		timeoutLock.RUnlock()
+		if !limit.IsZero() && limit.Before(deadline) {
+			deadline = limit
+		}
+		c.conn.SetReadDeadline(deadline)
+	})
+	// register how many bytes we read, including the frame size
+	c.updateRx(read)
+	if err != nil {
+		return nil, xerrors.Errorf("%v: %w", c.conn.RemoteAddr().String(), err)
+	}
+	return buf, nil
+}
+
+// frameChunk is the most a frame is read at once.
+const frameChunk = 64 * 1024
+
+// readFrame reads a frame of the wire protocol: the size of the packet, then
+// the packet. Before every read, it calls deadline to set the deadline of the
+// connection, with the time the packet must be read by, or the zero time
+// while waiting for its size. It returns the packet and how many bytes it
+// read. A packet bigger than MaxPacketSize is refused before it is
+// allocated, and the memory of the others only grows with the data read.
+func readFrame(r io.Reader, deadline func(limit time.Time)) ([]byte, uint64, error) {
+	deadline(time.Time{})
 	var total Size
-	if err := binary.Read(c.conn, globalOrder, &total); err != nil {
-		return nil, xerrors.Errorf("buffer read: %w", handleError(err))
+	if err := binary.Read(r, globalOrder, &total); err != nil {
+		return nil, 0, xerrors.Errorf("buffer read: %w", handleError(err))
 	}
 	if total > MaxPacketSize {
-		return nil, xerrors.Errorf("%v sends too big packet: %v>%v",
-			c.conn.RemoteAddr().String(), total, MaxPacketSize)
+		return nil, 0, xerrors.Errorf("too big packet: %v>%v", total, MaxPacketSize)
 	}
 
-	b := make([]byte, total)
+	limit := time.Now().Add(DecodeTimeout)
+	size := total
+	if size > frameChunk {
+		size = frameChunk
+	}
+	b := make([]byte, size)
 	var read Size
 	var buffer bytes.Buffer
 	for read < total {
 		// Read the size of the next packet.
-		timeoutLock.RLock()
-		c.conn.SetReadDeadline(time.Now().Add(timeout))
-		timeoutLock.RUnlock()
-		n, err := c.conn.Read(b)
+		deadline(limit)
+		chunk := b
+		if left := total - read; left < Size(len(chunk)) {
+			chunk = chunk[:left]
+		}
+		n, err := r.Read(chunk)
 		// Quit if there is an error.
 		if err != nil {
-			c.updateRx(4 + uint64(read))
-			return nil, xerrors.Errorf("reading: %w", handleError(err))
+			return nil, 4 + uint64(read), xerrors.Errorf("reading: %w", handleError(err))
 		}
 		// Append the read bytes into the buffer.
-		if _, err := buffer.Write(b[:n]); err != nil {
+		if _, err := buffer.Write(chunk[:n]); err != nil {
 			log.Error("Couldn't write to buffer:", err)
 		}
 		read += Size(n)
-		b = b[n:]
 	}
-
-	// register how many bytes we read. (4 is for the frame size
-	// that we read up above).
-	c.updateRx(4 + uint64(read))
-	return buffer.Bytes(), nil
+	return buffer.Bytes(), 4 + uint64(read), nil
 }
 
 // Send converts the NetworkMessage into an ApplicationMessage
@@ -244,6 +282,14 @@ func (c *TCPConn) Local() Address {
 	return NewTCPAddress(c.conn.LocalAddr().String())
 }
 
+// tcpConn returns the TCP connection, under TLS or not.
+func (c *TCPConn) tcpConn() net.Conn {
+	if c.raw != nil {
+		return c.raw
+	}
+	return c.conn
+}
+
 // Type returns PlainTCP.
 func (c *TCPConn) Type() ConnType {
 	return PlainTCP
@@ -314,6 +360,12 @@ type TCPListener struct {
 
 	// suite that is given to each incoming connection
 	suite Suite
+
+	// tlsConfig, if set, is the configuration of the TLS connections
+	tlsConfig *tls.Config
+
+	// puzzle, if set, is the puzzle the incoming connections must solve
+	puzzle *puzzleGate
 }
 
 // NewTCPListener returns a TCPListener. This function binds globally using
@@ -402,8 +454,19 @@ func (t *TCPListener) listen(fn func(Conn)) error {
 			}
 			continue
 		}
+		if t.puzzle != nil {
+			if conn, err = t.puzzle.accept(conn); err != nil {
+				log.Lvl3("Refusing connection:", err)
+				continue
+			}
+		}
+		raw := conn
+		if t.tlsConfig != nil {
+			conn = tls.Server(conn, t.tlsConfig)
+		}
 		c := TCPConn{
 			conn:  conn,
+			raw:   raw,
 			suite: t.suite,
 		}
 		fn(&c)
@@ -537,13 +600,13 @@ func NewTCPHostWithListenAddr(sid *ServerIdentity, s Suite,
 func (t *TCPHost) Connect(si *ServerIdentity) (Conn, error) {
 	switch si.Address.ConnType() {
 	case PlainTCP:
-		c, err := NewTCPConn(si.Address, t.suite)
+		c, err := newTCPConn(si.Address, t.suite, t.puzzle != nil)
 		if err != nil {
 			return nil, xerrors.Errorf("tcp connection: %v", err)
 		}
 		return c, nil
 	case TLS:
-		c, err := NewTLSConn(t.sid, si, t.suite)
+		c, err := newTLSConn(t.sid, si, t.suite, t.puzzle != nil)
 		if err != nil {
 			return nil, xerrors.Errorf("tcp connection: %v", err)
 		}
@@ -267,7 +267,7 @@ func NewTLSListenerWithListenAddr(si *ServerIdentity, suite Suite,
 	// callback, it will still call us.
 	cfg.ClientAuth = tls.RequireAnyClientCert
 
-	tcp.listener = tls.NewListener(tcp.listener, cfg)
+	tcp.tlsConfig = cfg
 	return tcp, nil
 }
 
@@ -425,6 +425,11 @@ func tlsConfig(suite Suite, us *ServerIdentity) (*tls.Config, error) {
 // it holds the given Public key by self-signing a certificate
 // linked to that key.
 func NewTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite) (conn *TCPConn, err error) {
+	return newTLSConn(us, them, suite, false)
+}
+
+// newTLSConn is NewTLSConn, that solves the puzzle of the peer if asked.
+func newTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite, puzzle bool) (conn *TCPConn, err error) {
 	log.Lvl2("NewTLSConn to:", them)
 	if them.Address.ConnType() != TLS {
 		return nil, xerrors.New("not a tls server")
@@ -443,12 +448,13 @@ func NewTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite) (conn *TC
 
 	netAddr := them.Address.NetworkAddress()
 	for i := 1; i <= MaxRetryConnect; i++ {
-		var c net.Conn
+		var c, raw net.Conn
 		cfg.ServerName = string(nonce)
-		c, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", netAddr, cfg)
+		c, raw, err = dialTLS(netAddr, cfg, puzzle)
 		if err == nil {
 			conn = &TCPConn{
 				conn:  c,
+				raw:   raw,
 				suite: suite,
 			}
 			return
@@ -464,6 +470,32 @@ func NewTLSConn(us *ServerIdentity, them *ServerIdentity, suite Suite) (conn *TC
 	return
 }
 
+// dialTLS connects to the address, solves its puzzle if asked, and does the
+// TLS handshake. It returns the TLS connection and the TCP connection under
+// it.
+func dialTLS(addr string, cfg *tls.Config, puzzle bool) (net.Conn, net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	raw, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if puzzle {
+		if err := solvePuzzle(raw); err != nil {
+			raw.Close()
+			return nil, nil, err
+		}
+	}
+	c := tls.Client(raw, cfg)
+	raw.SetDeadline(time.Now().Add(timeout))
+	err = c.Handshake()
+	raw.SetDeadline(time.Time{})
+	if err != nil {
+		raw.Close()
+		return nil, nil, err
+	}
+	return c, raw, nil
+}
+
 const nonceSize = 256 / 8
 
 func mkNonce(s Suite) []byte {
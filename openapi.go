@@ -0,0 +1,197 @@
+package onet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// how the gateway replies on errors, as in wrapJSONMsg
+var openAPIErrorSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"message": map[string]interface{}{"type": "string"},
+	},
+}
+
+// openAPISchemas builds the JSON schemas of the types, in the components of
+// an OpenAPI document.
+type openAPISchemas map[string]interface{}
+
+// schemaName returns the name of the type in the components. The package is
+// kept so that two messages with the same name don't collide.
+func schemaName(t reflect.Type) string {
+	return strings.Replace(t.String(), "*", "", -1)
+}
+
+// schema returns the JSON schema of t as encoded by encoding/json, and adds
+// the structs it uses to the components.
+func (s openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object",
+			"additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			// placeholder for the recursive types
+			s[name] = nil
+			s[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		// interfaces like kyber.Point
+		return map[string]interface{}{"description": "encoded by " + t.String()}
+	}
+}
+
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		props[name] = s.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// OpenAPI returns an OpenAPI 3 document describing the handlers of the
+// services on the JSON gateway, and the JSON schemas of all the messages
+// registered with network.RegisterMessage. The document is also served on
+// /openapi.json of the websocket.
+func (c *Server) OpenAPI() ([]byte, error) {
+	schemas := openAPISchemas{}
+	paths := make(map[string]interface{})
+
+	services := c.serviceManager.availableServices()
+	sort.Strings(services)
+	for _, service := range services {
+		s, ok := c.serviceManager.service(service).(gatewayHandlers)
+		if !ok {
+			continue
+		}
+		for _, name := range s.gatewayHandlerNames() {
+			h, _ := s.gatewayHandler(name)
+			reply := schemas.schema(reflect.TypeOf(h.handler).Out(0))
+			op := map[string]interface{}{
+				"operationId": service + "." + strings.Replace(name, "/", ".", -1),
+				"tags":        []string{service},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": schemas.schema(h.msgType),
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "reply of the handler",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": reply},
+						},
+					},
+					"default": map[string]interface{}{
+						"description": "error",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"$ref": "#/components/schemas/Error",
+								},
+							},
+						},
+					},
+				},
+			}
+			if h.deprecation != nil {
+				op["deprecated"] = true
+				if h.deprecation.Message != "" {
+					op["description"] = h.deprecation.Message
+				}
+			}
+			if h.authenticated {
+				op["security"] = []interface{}{map[string]interface{}{"token": []string{}}}
+			}
+			path := fmt.Sprintf("/v%d/%s/%s", GatewayAPIVersion, service, name)
+			paths[path] = map[string]interface{}{"post": op}
+		}
+	}
+
+	// the messages of the protocols and the streams are described with the
+	// ID they have on the wire
+	for id, t := range network.RegisteredMessages() {
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		schemas.schema(t)
+		if s, ok := schemas[schemaName(t)].(map[string]interface{}); ok {
+			s["x-onet-message-type-id"] = id.String()
+		}
+	}
+	schemas["Error"] = openAPIErrorSchema
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "onet services of " + c.ServerIdentity.String(),
+			"version": fmt.Sprintf("v%d", GatewayAPIVersion),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func (c *Server) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	doc, err := c.OpenAPI()
+	if err != nil {
+		log.Error("OpenAPI:", err)
+		http.Error(w, "couldn't describe the API", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
@@ -0,0 +1,60 @@
+package onet
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+)
+
+func TestServer_OpenAPI(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	RegisterNewService(clientAuthServiceName, func(c *Context) (Service, error) {
+		srv := &clientAuthService{ServiceProcessor: NewServiceProcessor(c)}
+		return srv, srv.RegisterAuthenticatedHandler(srv.Secret)
+	})
+	defer UnregisterService(clientAuthServiceName)
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+
+	buf, err := h.OpenAPI()
+	require.NoError(t, err)
+	var doc struct {
+		Paths      map[string]map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]map[string]interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf, &doc))
+
+	op := doc.Paths["/v4/"+testServiceName+"/testMsg"]["post"]
+	require.NotNil(t, op)
+	require.Equal(t, testServiceName+".testMsg", op["operationId"])
+	require.Nil(t, op["security"])
+	op = doc.Paths["/v4/"+clientAuthServiceName+"/authzMsg"]["post"]
+	require.NotNil(t, op)
+	require.NotNil(t, op["security"])
+
+	s := doc.Components.Schemas["onet.testMsg"]
+	require.NotNil(t, s)
+	require.Equal(t, map[string]interface{}{"type": "integer"},
+		s["properties"].(map[string]interface{})["I"])
+	require.NotNil(t, s["x-onet-message-type-id"])
+	require.NotNil(t, doc.Components.Schemas["onet.authzMsg"])
+	require.NotNil(t, doc.Components.Schemas["Error"])
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	resp, err := http.Get("http://" + h.ServerIdentity.Address.Host() + ":" +
+		strconv.Itoa(port+1) + "/openapi.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,230 @@
+package onet
+
+import (
+	"crypto/tls"
+	"os"
+
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// Option is an option of NewServer.
+type Option func(*serverOptions) error
+
+// serverOptions are what the options of NewServer set.
+type serverOptions struct {
+	suite      network.Suite
+	si         *network.ServerIdentity
+	listenAddr string
+	tls        *tls.Config
+	storageDir string
+	storage    StorageConfig
+	ws         *WebSocketConfig
+	reporters  []namedReporter
+	services   []string
+	configs    map[string]interface{}
+	lifecycles []ServiceLifecycle
+}
+
+type namedReporter struct {
+	name string
+	sr   StatusReporter
+}
+
+// WithSuite is the suite of the key pair of the server, Ed25519 by default.
+func WithSuite(s network.Suite) Option {
+	return func(o *serverOptions) error {
+		if s == nil {
+			return xerrors.New("nil suite")
+		}
+		o.suite = s
+		return nil
+	}
+}
+
+// WithServerIdentity is the identity of the server, which must hold its
+// private key, as the one of app.CothorityConfig.GetServerIdentity. The
+// connections with the other conodes use TLS if its address is a tls://
+// one. Without it, the server gets a new key pair and a tls:// address at
+// the listen address.
+func WithServerIdentity(si *network.ServerIdentity) Option {
+	return func(o *serverOptions) error {
+		if si == nil || si.GetPrivate() == nil {
+			return xerrors.New("the server identity has no private key")
+		}
+		o.si = si
+		return nil
+	}
+}
+
+// WithListenAddress is where the server listens for the other conodes, like
+// "0.0.0.0:7770", if it is not the address of its identity.
+func WithListenAddress(addr string) Option {
+	return func(o *serverOptions) error {
+		o.listenAddr = addr
+		return nil
+	}
+}
+
+// WithTLS is the TLS configuration of the websocket, for the clients.
+func WithTLS(config *tls.Config) Option {
+	return func(o *serverOptions) error {
+		o.tls = config
+		return nil
+	}
+}
+
+// WithStorageDir is the directory of the database of the services, which is
+// kept when the server is closed. By default it is the one of the
+// CONODE_SERVICE_PATH environment variable, or the data directory of the
+// user.
+func WithStorageDir(dir string) Option {
+	return func(o *serverOptions) error {
+		o.storageDir = dir
+		return nil
+	}
+}
+
+// WithStorage is how the services store their data, bbolt without
+// encryption by default.
+func WithStorage(cfg StorageConfig) Option {
+	return func(o *serverOptions) error {
+		o.storage = cfg
+		return nil
+	}
+}
+
+// WithWebSocketConfig is the configuration of the websocket, as given to
+// WebSocket.Configure.
+func WithWebSocketConfig(cfg WebSocketConfig) Option {
+	return func(o *serverOptions) error {
+		o.ws = &cfg
+		return nil
+	}
+}
+
+// WithMetrics adds the status reporter to the status of the server under
+// the name, for the metrics of the binary embedding the conode.
+func WithMetrics(name string, sr StatusReporter) Option {
+	return func(o *serverOptions) error {
+		if name == "" || sr == nil {
+			return xerrors.New("a status reporter needs a name")
+		}
+		o.reporters = append(o.reporters, namedReporter{name, sr})
+		return nil
+	}
+}
+
+// WithServices only runs the registered services given, instead of all of
+// them, for a program embedding a conode with some of its services. Can be
+// given more than once.
+func WithServices(names ...string) Option {
+	return func(o *serverOptions) error {
+		for _, name := range names {
+			if ServiceFactory.ServiceID(name).Equal(NilServiceID) {
+				return xerrors.New("no such service: " + name)
+			}
+		}
+		o.services = append(o.services, names...)
+		return nil
+	}
+}
+
+// WithConfig gives the configuration of a section registered by a service
+// with Context.RegisterConfigSection, as a structure instead of a part of a
+// configuration file. It is applied once the services are created, and can
+// be changed afterwards with Server.ReloadConfig and ConfigValues.
+func WithConfig(section string, cfg interface{}) Option {
+	return func(o *serverOptions) error {
+		if o.configs == nil {
+			o.configs = make(map[string]interface{})
+		}
+		o.configs[section] = cfg
+		return nil
+	}
+}
+
+// WithLifecycle has the program embedding the conode told about the phases
+// of the server, after its services, as if it was one of them.
+func WithLifecycle(l ServiceLifecycle) Option {
+	return func(o *serverOptions) error {
+		if l == nil {
+			return xerrors.New("nil lifecycle")
+		}
+		o.lifecycles = append(o.lifecycles, l)
+		return nil
+	}
+}
+
+// NewServer returns a new Server with a TCP router, configured by the
+// options, for the binaries embedding a conode without the config file of
+// the app package. Like the other servers, it still has to be started.
+func NewServer(opts ...Option) (*Server, error) {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, xerrors.Errorf("option: %v", err)
+		}
+	}
+	if o.suite == nil {
+		o.suite = suites.MustFind("Ed25519")
+	}
+	si := o.si
+	if si == nil {
+		if o.listenAddr == "" {
+			return nil, xerrors.New("a server needs an identity or a listen address")
+		}
+		si = newServerIdentity(o.suite, network.NewAddress(network.TLS, o.listenAddr))
+	}
+	dir := o.storageDir
+	if dir == "" {
+		dir = dbPathFromEnv()
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, xerrors.Errorf("storage directory: %v", err)
+	}
+
+	r, err := network.NewTCPRouterWithListenAddr(si, o.suite, o.listenAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("router: %v", err)
+	}
+	c := newServerWithDb(o.suite, dir, false, o.storage, r, si.GetPrivate(), o.services)
+	c.lifecycles = o.lifecycles
+	if o.ws != nil {
+		if err := c.WebSocket.Configure(*o.ws); err != nil {
+			c.Close()
+			return nil, xerrors.Errorf("websocket: %v", err)
+		}
+	}
+	if o.tls != nil {
+		c.WebSocket.Lock()
+		c.WebSocket.TLSConfig = o.tls
+		c.WebSocket.Unlock()
+	}
+	for _, nr := range o.reporters {
+		c.statusReporterStruct.RegisterStatusReporter(nr.name, nr.sr)
+	}
+	if len(o.configs) > 0 {
+		updated, err := c.ReloadConfig(ConfigValues(o.configs))
+		if err == nil && len(updated) < len(o.configs) {
+			err = xerrors.Errorf("only the sections %v exist", updated)
+		}
+		if err != nil {
+			c.Close()
+			return nil, xerrors.Errorf("config: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// newServerIdentity returns a new identity with its private key, and the
+// ones of the services, at the address.
+func newServerIdentity(suite network.Suite, addr network.Address) *network.ServerIdentity {
+	kp := key.NewKeyPair(suite)
+	si := network.NewServerIdentity(kp.Public, addr)
+	si.SetPrivate(kp.Private)
+	ServiceFactory.generateKeyPairs(si)
+	return si
+}
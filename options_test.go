@@ -0,0 +1,108 @@
+package onet
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestNewServer(t *testing.T) {
+	_, err := NewServer()
+	require.Error(t, err)
+	_, err = NewServer(WithListenAddress("127.0.0.1:0"), WithMetrics("", nil))
+	require.Error(t, err)
+	_, err = NewServer(WithServerIdentity(&network.ServerIdentity{}))
+	require.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "options")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	kp := key.NewKeyPair(tSuite)
+	si := network.NewServerIdentity(kp.Public, network.NewTCPAddress("127.0.0.1:0"))
+	si.SetPrivate(kp.Private)
+	tlsConfig := &tls.Config{}
+	srv, err := NewServer(
+		WithSuite(tSuite),
+		WithServerIdentity(si),
+		WithStorageDir(dir),
+		WithWebSocketConfig(WebSocketConfig{ListenAddress: "127.0.0.1:0"}),
+		WithTLS(tlsConfig),
+		WithMetrics("Dummy", &dummyTestReporter{5}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, tSuite, srv.Suite())
+	require.True(t, srv.ServerIdentity.Equal(si))
+	require.True(t, srv.WebSocket.TLSConfig == tlsConfig)
+	require.Equal(t, "5", srv.statusReporterStruct.ReportStatus()["Dummy"].Field["Connections"])
+	srv.StartInBackground()
+	require.NoError(t, srv.Close())
+
+	// the database is kept
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	// a server without identity gets a new key pair
+	srv, err = NewServer(WithListenAddress("127.0.0.1:0"), WithStorageDir(dir))
+	require.NoError(t, err)
+	require.Equal(t, network.TLS, string(srv.ServerIdentity.Address.ConnType()))
+	require.NotNil(t, srv.ServerIdentity.GetPrivate())
+	require.NoError(t, srv.Close())
+}
+
+func TestNewServer_Embedded(t *testing.T) {
+	var applied int
+	RegisterNewService("embeddedA", func(c *Context) (Service, error) {
+		return &DummyService{c: c}, c.RegisterConfigSection(ConfigSection{
+			Name:  "embedded",
+			New:   func() interface{} { return &reloadConfig{} },
+			Apply: func(cfg interface{}) { applied = cfg.(*reloadConfig).Value },
+		})
+	})
+	defer UnregisterService("embeddedA")
+	RegisterNewService("embeddedB", func(c *Context) (Service, error) {
+		return &DummyService{c: c}, nil
+	})
+	defer UnregisterService("embeddedB")
+
+	dir, err := ioutil.TempDir("", "embedded")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opts := []Option{
+		WithListenAddress("127.0.0.1:0"),
+		WithStorageDir(dir),
+		WithWebSocketConfig(WebSocketConfig{ListenAddress: "127.0.0.1:0"}),
+		WithServices("embeddedA"),
+	}
+	_, err = NewServer(append(opts, WithServices("embeddedC"))...)
+	require.Error(t, err)
+	_, err = NewServer(append(opts, WithConfig("embedded", "3"))...)
+	require.Error(t, err)
+	_, err = NewServer(append(opts, WithConfig("other", reloadConfig{}))...)
+	require.Error(t, err)
+
+	lc := &lifecycleService{}
+	srv, err := NewServer(append(opts,
+		WithConfig("embedded", reloadConfig{Value: 3}),
+		WithLifecycle(lc))...)
+	require.NoError(t, err)
+	require.NotNil(t, srv.Service("embeddedA"))
+	require.Nil(t, srv.Service("embeddedB"))
+	require.Equal(t, 3, applied)
+
+	_, err = srv.ReloadConfig(ConfigValues(map[string]interface{}{
+		"embedded": &reloadConfig{Value: 4},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 4, applied)
+
+	srv.StartInBackground()
+	require.NoError(t, srv.Shutdown(0))
+	require.Equal(t, []string{"start", "ready", "drain", "stop"}, lc.recorded())
+}
@@ -0,0 +1,501 @@
+package onet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// orderedSyncProtocolName is the CollectProtocol a new sequencer runs to
+// learn the messages ordered by the previous ones.
+const orderedSyncProtocolName = "OrderedSync"
+
+// DefaultOrderedTimeout is how long Broadcast waits for the sequencer before
+// electing another one.
+const DefaultOrderedTimeout = 5 * time.Second
+
+// orderedHistory is how many delivered messages a member keeps to send them
+// again to the members that missed them.
+const orderedHistory = 1024
+
+// OrderedSubmitMsgID of OrderedSubmit message as registered in network
+var OrderedSubmitMsgID = network.RegisterMessage(OrderedSubmit{})
+
+// OrderedMsgID of OrderedMsg message as registered in network
+var OrderedMsgID = network.RegisterMessage(OrderedMsg{})
+
+// OrderedFetchMsgID of OrderedFetch message as registered in network
+var OrderedFetchMsgID = network.RegisterMessage(OrderedFetch{})
+
+// OrderedSyncRequestMsgID of OrderedSyncRequest message as registered in network
+var OrderedSyncRequestMsgID = network.RegisterMessage(OrderedSyncRequest{})
+
+// OrderedSyncStateMsgID of OrderedSyncState message as registered in network
+var OrderedSyncStateMsgID = network.RegisterMessage(OrderedSyncState{})
+
+func init() {
+	GlobalProtocolRegister(orderedSyncProtocolName, NewCollectProtocol(respondOrderedSync, reduceOrderedSync))
+}
+
+// OrderedSubmit asks the sequencer of an OrderedBroadcast to order a message.
+type OrderedSubmit struct {
+	Service ServiceID
+	Roster  RosterID
+	ID      uuid.UUID
+	// Payload is the marshaled message, including its type.
+	Payload []byte
+}
+
+// OrderedMsg is a message of an OrderedBroadcast with its sequence number,
+// sent by the sequencer of the view to all the members.
+type OrderedMsg struct {
+	Service ServiceID
+	Roster  RosterID
+	View    uint64
+	Seq     uint64
+	ID      uuid.UUID
+	Payload []byte
+}
+
+// OrderedFetch asks the sequencer for the messages from From to To, which
+// the sender missed.
+type OrderedFetch struct {
+	Service  ServiceID
+	Roster   RosterID
+	From, To uint64
+}
+
+// OrderedSyncRequest asks the members for the messages after the sequence
+// number After.
+type OrderedSyncRequest struct {
+	After uint64
+}
+
+// OrderedSyncState are the messages known by the members of a subtree for an
+// OrderedSyncRequest.
+type OrderedSyncState struct {
+	Messages []OrderedMsg
+}
+
+// OrderedBroadcast delivers the messages broadcast by the members of the
+// roster of a service to all of them in the same order. The leader of the
+// roster, as given by the LeaderElection of the service, is the sequencer
+// numbering the messages. When it doesn't order a message in time, Broadcast
+// elects another one, which first learns the messages that the members got
+// from the previous one.
+//
+// It is scaffolding for state machine replication experiments: a sequencer
+// that failed after sending a message to only some members, which are then
+// out of reach during the election of the next one, can make them diverge.
+type OrderedBroadcast struct {
+	service  ServiceID
+	roster   *Roster
+	overlay  *Overlay
+	election *LeaderElection
+
+	lock    sync.Mutex
+	timeout time.Duration
+	// next is the sequence number of the next message to deliver
+	next uint64
+	// view is the highest view of the sequencers we got a message from
+	view uint64
+	// assigned is the last sequence number we gave as sequencer
+	assigned uint64
+	// syncing is set while we learn the messages of the previous
+	// sequencers, during which the submits wait in deferred
+	syncing  bool
+	deferred []*OrderedSubmit
+	history  map[uint64]*OrderedMsg
+	ordered  map[uuid.UUID]uint64
+	pending  map[uint64]*OrderedMsg
+	waiting  map[uuid.UUID]chan uint64
+
+	// delivering keeps the listeners called in order
+	delivering sync.Mutex
+	onDeliver  []func(seq uint64, msg network.Message)
+}
+
+// OrderedBroadcast returns the ordered broadcast of the roster for the
+// service, the same for all the calls with the roster. All the members must
+// create it, so that they accept its messages.
+func (c *Context) OrderedBroadcast(ro *Roster) *OrderedBroadcast {
+	return c.overlay.orderedBroadcast(c.serviceID, ro)
+}
+
+func (o *Overlay) orderedBroadcast(service ServiceID, ro *Roster) *OrderedBroadcast {
+	o.orderedLock.Lock()
+	defer o.orderedLock.Unlock()
+	rosters, ok := o.ordered[service]
+	if !ok {
+		rosters = make(map[RosterID]*OrderedBroadcast)
+		o.ordered[service] = rosters
+	}
+	ob, ok := rosters[ro.ID]
+	if !ok {
+		ob = &OrderedBroadcast{
+			service:  service,
+			roster:   ro,
+			overlay:  o,
+			election: o.leaderElection(service, ro),
+			timeout:  DefaultOrderedTimeout,
+			next:     1,
+			history:  make(map[uint64]*OrderedMsg),
+			ordered:  make(map[uuid.UUID]uint64),
+			pending:  make(map[uint64]*OrderedMsg),
+			waiting:  make(map[uuid.UUID]chan uint64),
+		}
+		ob.election.OnChange(ob.viewChanged)
+		rosters[ro.ID] = ob
+	}
+	return ob
+}
+
+// lookupOrdered returns the ordered broadcast of the roster for the service,
+// nil if it wasn't created.
+func (o *Overlay) lookupOrdered(service ServiceID, id RosterID) *OrderedBroadcast {
+	o.orderedLock.Lock()
+	defer o.orderedLock.Unlock()
+	return o.ordered[service][id]
+}
+
+// SetTimeout sets how long Broadcast waits for the sequencer.
+func (ob *OrderedBroadcast) SetTimeout(timeout time.Duration) {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	ob.timeout = timeout
+}
+
+// OnDeliver registers a function called with every message in order, with
+// its sequence number. It must not wait for a Broadcast, which would only be
+// delivered after it returns.
+func (ob *OrderedBroadcast) OnDeliver(f func(seq uint64, msg network.Message)) {
+	ob.delivering.Lock()
+	defer ob.delivering.Unlock()
+	ob.onDeliver = append(ob.onDeliver, f)
+}
+
+// Delivered returns the sequence number of the last message delivered.
+func (ob *OrderedBroadcast) Delivered() uint64 {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	return ob.next - 1
+}
+
+// Broadcast orders the message and returns its sequence number once it is
+// delivered here. If the sequencer doesn't order it in time, another one is
+// elected, up to one per member.
+func (ob *OrderedBroadcast) Broadcast(msg network.Message) (uint64, error) {
+	payload, err := network.Marshal(msg)
+	if err != nil {
+		return 0, xerrors.Errorf("marshaling: %v", err)
+	}
+	submit := &OrderedSubmit{Service: ob.service, Roster: ob.roster.ID, ID: uuid.NewV4(), Payload: payload}
+	delivered := make(chan uint64, 1)
+	ob.lock.Lock()
+	ob.waiting[submit.ID] = delivered
+	timeout := ob.timeout
+	ob.lock.Unlock()
+	defer func() {
+		ob.lock.Lock()
+		delete(ob.waiting, submit.ID)
+		ob.lock.Unlock()
+	}()
+
+	own := ob.overlay.ServerIdentity()
+	for range ob.roster.List {
+		sequencer := ob.election.Leader()
+		if sequencer.Equal(own) {
+			ob.sequence(submit, own)
+		} else if _, err := ob.overlay.server.Send(sequencer, submit); err != nil {
+			log.Lvl2(own, "couldn't submit to sequencer", sequencer, ":", err)
+		}
+		select {
+		case seq := <-delivered:
+			return seq, nil
+		case <-ob.overlay.server.Clock().After(timeout):
+		}
+		log.Lvl2(own, "sequencer", sequencer, "didn't order the message, electing another one")
+		if _, err := ob.election.Reelect(timeout); err != nil {
+			return 0, xerrors.Errorf("electing a sequencer: %v", err)
+		}
+	}
+	return 0, xerrors.New("no sequencer ordered the message")
+}
+
+// sequence gives the next sequence number to the message and sends it to all
+// the members.
+func (ob *OrderedBroadcast) sequence(submit *OrderedSubmit, from *network.ServerIdentity) {
+	ob.lock.Lock()
+	if ob.syncing {
+		ob.deferred = append(ob.deferred, submit)
+		ob.lock.Unlock()
+		return
+	}
+	if seq, ok := ob.ordered[submit.ID]; ok {
+		// the submitter lost it
+		m := ob.history[seq]
+		ob.lock.Unlock()
+		if m != nil {
+			ob.send(from, ob.restamp(m))
+		}
+		return
+	}
+	ob.assigned++
+	m := &OrderedMsg{
+		Service: ob.service,
+		Roster:  ob.roster.ID,
+		View:    ob.election.View(),
+		Seq:     ob.assigned,
+		ID:      submit.ID,
+		Payload: submit.Payload,
+	}
+	ob.ordered[m.ID] = m.Seq
+	ob.lock.Unlock()
+	ob.sendAll(m)
+}
+
+// restamp returns a copy of the message sent again in our view.
+func (ob *OrderedBroadcast) restamp(m *OrderedMsg) *OrderedMsg {
+	c := *m
+	c.View = ob.election.View()
+	return &c
+}
+
+func (ob *OrderedBroadcast) send(to *network.ServerIdentity, m *OrderedMsg) {
+	if to.Equal(ob.overlay.ServerIdentity()) {
+		ob.receive(m, to)
+		return
+	}
+	if _, err := ob.overlay.server.Send(to, m); err != nil {
+		log.Lvl2(ob.overlay.ServerIdentity(), "couldn't send ordered message to", to, ":", err)
+	}
+}
+
+// sendAll delivers the message here, and sends it to the other members in
+// parallel, so that the ones that can't be reached don't delay the others.
+func (ob *OrderedBroadcast) sendAll(m *OrderedMsg) {
+	own := ob.overlay.ServerIdentity()
+	ob.receive(m, own)
+	for _, si := range ob.roster.List {
+		if !si.Equal(own) {
+			go ob.send(si, m)
+		}
+	}
+}
+
+// receive delivers the message and the ones waiting for it, and asks the
+// sender for the ones missing before it.
+func (ob *OrderedBroadcast) receive(m *OrderedMsg, from *network.ServerIdentity) {
+	ob.delivering.Lock()
+	defer ob.delivering.Unlock()
+
+	ob.lock.Lock()
+	if m.View < ob.view {
+		ob.lock.Unlock()
+		log.Lvl3(ob.overlay.ServerIdentity(), "dropping ordered message of view", m.View)
+		return
+	}
+	if m.View > ob.view {
+		// the new sequencer sends again the messages it kept
+		ob.view = m.View
+		ob.pending = make(map[uint64]*OrderedMsg)
+	}
+	if m.Seq < ob.next {
+		ob.lock.Unlock()
+		return
+	}
+	ob.pending[m.Seq] = m
+	var ready []*OrderedMsg
+	for {
+		p, ok := ob.pending[ob.next]
+		if !ok {
+			break
+		}
+		delete(ob.pending, ob.next)
+		ob.history[p.Seq] = p
+		ob.ordered[p.ID] = p.Seq
+		if p.Seq > orderedHistory {
+			if old, ok := ob.history[p.Seq-orderedHistory]; ok {
+				delete(ob.history, old.Seq)
+				delete(ob.ordered, old.ID)
+			}
+		}
+		if p.Seq > ob.assigned {
+			ob.assigned = p.Seq
+		}
+		if c, ok := ob.waiting[p.ID]; ok {
+			c <- p.Seq
+			delete(ob.waiting, p.ID)
+		}
+		ready = append(ready, p)
+		ob.next++
+	}
+	var fetch *OrderedFetch
+	if len(ob.pending) > 0 && ob.pending[ob.next] == nil {
+		fetch = &OrderedFetch{Service: ob.service, Roster: ob.roster.ID, From: ob.next, To: m.Seq - 1}
+	}
+	ob.lock.Unlock()
+
+	for _, p := range ready {
+		_, msg, err := network.Unmarshal(p.Payload, ob.overlay.suite())
+		if err != nil {
+			log.Error("unmarshaling ordered message:", err)
+			continue
+		}
+		for _, f := range ob.onDeliver {
+			f(p.Seq, msg)
+		}
+	}
+	if fetch != nil && fetch.From <= fetch.To && !from.Equal(ob.overlay.ServerIdentity()) {
+		if _, err := ob.overlay.server.Send(from, fetch); err != nil {
+			log.Lvl2(ob.overlay.ServerIdentity(), "couldn't fetch ordered messages:", err)
+		}
+	}
+}
+
+// viewChanged takes over as sequencer when we are the new leader.
+func (ob *OrderedBroadcast) viewChanged(view uint64, leader *network.ServerIdentity) {
+	if leader.Equal(ob.overlay.ServerIdentity()) {
+		go ob.takeOver(view)
+	}
+}
+
+// takeOver learns the messages ordered by the previous sequencers, sends them
+// to all the members, and orders the submits received meanwhile.
+func (ob *OrderedBroadcast) takeOver(view uint64) {
+	ob.lock.Lock()
+	ob.syncing = true
+	after := ob.next - 1
+	timeout := ob.timeout
+	ob.lock.Unlock()
+
+	// half of the timeout, so that the submitters waiting for the new
+	// sequencer don't elect another one
+	resp, err := ob.overlay.collectStar(orderedSyncProtocolName, ob.roster, ob.service, &OrderedSyncRequest{After: after}, timeout/2)
+	if err != nil {
+		log.Lvl2(ob.overlay.ServerIdentity(), "couldn't learn the ordered messages:", err)
+	}
+	var msgs []OrderedMsg
+	if resp != nil {
+		msgs = resp.(*OrderedSyncState).Messages
+	}
+	// nobody delivered the messages after a missing one, their submitters
+	// will send them again
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Seq != after+uint64(i)+1 {
+			break
+		}
+		m.View = view
+		ob.sendAll(m)
+	}
+
+	ob.lock.Lock()
+	ob.assigned = ob.next - 1
+	ob.syncing = false
+	deferred := ob.deferred
+	ob.deferred = nil
+	ob.lock.Unlock()
+	own := ob.overlay.ServerIdentity()
+	for _, submit := range deferred {
+		ob.sequence(submit, own)
+	}
+}
+
+// state returns the messages delivered or pending after the sequence number.
+func (ob *OrderedBroadcast) state(after uint64) *OrderedSyncState {
+	ob.lock.Lock()
+	defer ob.lock.Unlock()
+	st := &OrderedSyncState{}
+	for _, msgs := range []map[uint64]*OrderedMsg{ob.history, ob.pending} {
+		for seq, m := range msgs {
+			if seq > after {
+				st.Messages = append(st.Messages, *m)
+			}
+		}
+	}
+	return st
+}
+
+// handleOrderedSubmit orders the message if we are the sequencer.
+func (o *Overlay) handleOrderedSubmit(env *network.Envelope) {
+	submit, ok := env.Msg.(*OrderedSubmit)
+	if !ok {
+		log.Error("not an ordered submit")
+		return
+	}
+	ob := o.lookupOrdered(submit.Service, submit.Roster)
+	if ob == nil {
+		log.Lvl2(o.ServerIdentity(), "no ordered broadcast for", submit.Roster)
+		return
+	}
+	if !ob.election.Leader().Equal(o.ServerIdentity()) {
+		log.Lvl2(o.ServerIdentity(), "not the sequencer of", submit.Roster)
+		return
+	}
+	ob.sequence(submit, env.ServerIdentity)
+}
+
+// handleOrderedMsg delivers the message in order.
+func (o *Overlay) handleOrderedMsg(env *network.Envelope) {
+	m, ok := env.Msg.(*OrderedMsg)
+	if !ok {
+		log.Error("not an ordered message")
+		return
+	}
+	ob := o.lookupOrdered(m.Service, m.Roster)
+	if ob == nil {
+		log.Lvl2(o.ServerIdentity(), "no ordered broadcast for", m.Roster)
+		return
+	}
+	ob.receive(m, env.ServerIdentity)
+}
+
+// handleOrderedFetch sends the messages asked for again.
+func (o *Overlay) handleOrderedFetch(env *network.Envelope) {
+	fetch, ok := env.Msg.(*OrderedFetch)
+	if !ok {
+		log.Error("not an ordered fetch")
+		return
+	}
+	ob := o.lookupOrdered(fetch.Service, fetch.Roster)
+	if ob == nil {
+		return
+	}
+	for seq := fetch.From; seq <= fetch.To; seq++ {
+		ob.lock.Lock()
+		m := ob.history[seq]
+		ob.lock.Unlock()
+		if m != nil {
+			ob.send(env.ServerIdentity, ob.restamp(m))
+		}
+	}
+}
+
+func respondOrderedSync(n *TreeNodeInstance, req network.Message) (network.Message, error) {
+	ob := n.overlay.lookupOrdered(n.Token().ServiceID, n.Roster().ID)
+	if ob == nil {
+		return &OrderedSyncState{}, nil
+	}
+	return ob.state(req.(*OrderedSyncRequest).After), nil
+}
+
+func reduceOrderedSync(responses []network.Message) (network.Message, error) {
+	bySeq := make(map[uint64]OrderedMsg)
+	for _, r := range responses {
+		for _, m := range r.(*OrderedSyncState).Messages {
+			bySeq[m.Seq] = m
+		}
+	}
+	st := &OrderedSyncState{}
+	for _, m := range bySeq {
+		st.Messages = append(st.Messages, m)
+	}
+	sort.Slice(st.Messages, func(i, j int) bool { return st.Messages[i].Seq < st.Messages[j].Seq })
+	return st, nil
+}
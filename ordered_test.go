@@ -0,0 +1,109 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// orderedTestDeliveries records the values delivered by the members, in
+// order.
+type orderedTestDeliveries struct {
+	sync.Mutex
+	values map[network.ServerIdentityID][]int
+}
+
+func newOrderedTest(t *testing.T, contexts map[network.ServerIdentityID]*Context, servers []*Server,
+	ro *Roster) *orderedTestDeliveries {
+	d := &orderedTestDeliveries{values: make(map[network.ServerIdentityID][]int)}
+	for _, s := range servers {
+		id := s.ServerIdentity.ID
+		ob := contexts[id].OrderedBroadcast(ro)
+		ob.SetTimeout(500 * time.Millisecond)
+		ob.OnDeliver(func(seq uint64, msg network.Message) {
+			d.Lock()
+			defer d.Unlock()
+			require.Equal(t, uint64(len(d.values[id])+1), seq)
+			d.values[id] = append(d.values[id], msg.(*subsetTestMsg).Val)
+		})
+	}
+	return d
+}
+
+// wait returns the values of the member once it delivered n of them.
+func (d *orderedTestDeliveries) wait(t *testing.T, id network.ServerIdentityID, n int) []int {
+	for i := 0; i < 100; i++ {
+		d.Lock()
+		values := d.values[id]
+		d.Unlock()
+		if len(values) >= n {
+			return values
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Fail(t, "messages not delivered")
+	return nil
+}
+
+func TestOrderedBroadcast(t *testing.T) {
+	contexts := registerLeaderService(t, "orderedTest")
+	defer UnregisterService("orderedTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(4, true)
+	d := newOrderedTest(t, contexts, servers, ro)
+
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, ob *OrderedBroadcast) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				_, err := ob.Broadcast(&subsetTestMsg{i*10 + j})
+				require.NoError(t, err)
+			}
+		}(i, contexts[s.ServerIdentity.ID].OrderedBroadcast(ro))
+	}
+	wg.Wait()
+
+	order := d.wait(t, servers[0].ServerIdentity.ID, 20)
+	require.Len(t, order, 20)
+	for _, s := range servers[1:] {
+		require.Equal(t, order, d.wait(t, s.ServerIdentity.ID, 20))
+		require.Equal(t, uint64(20), contexts[s.ServerIdentity.ID].OrderedBroadcast(ro).Delivered())
+	}
+}
+
+// Tests that another sequencer is elected when the first one is closed, and
+// that it keeps the order of the messages ordered before.
+func TestOrderedBroadcast_Failover(t *testing.T) {
+	contexts := registerLeaderService(t, "orderedTest")
+	defer UnregisterService("orderedTest")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, ro, _ := local.GenTree(4, true)
+	d := newOrderedTest(t, contexts, servers, ro)
+
+	seq, err := contexts[servers[1].ServerIdentity.ID].OrderedBroadcast(ro).Broadcast(&subsetTestMsg{1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), seq)
+	for _, s := range servers {
+		d.wait(t, s.ServerIdentity.ID, 1)
+	}
+
+	require.NoError(t, servers[0].Close())
+	ob := contexts[servers[2].ServerIdentity.ID].OrderedBroadcast(ro)
+	seq, err = ob.Broadcast(&subsetTestMsg{2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), seq)
+	require.True(t, contexts[servers[2].ServerIdentity.ID].LeaderElection(ro).Leader().Equal(ro.List[1]))
+	seq, err = contexts[servers[3].ServerIdentity.ID].OrderedBroadcast(ro).Broadcast(&subsetTestMsg{3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), seq)
+	for _, s := range servers[1:] {
+		require.Equal(t, []int{1, 2, 3}, d.wait(t, s.ServerIdentity.ID, 3))
+	}
+}
@@ -2,7 +2,6 @@ package onet
 
 import (
 	"fmt"
-	"math/rand"
 	"sync"
 	"time"
 
@@ -24,8 +23,10 @@ type Overlay struct {
 	treeStorage *treeStorage
 
 	// TreeNodeInstance part
-	instances         map[TokenID]*TreeNodeInstance
-	instancesInfo     map[TokenID]bool
+	instances map[TokenID]*TreeNodeInstance
+	// finished are the instances that are done, so that their late
+	// messages don't start them again, for at least globalProtocolTimeout
+	finished          *tokenGenerations
 	instancesLock     sync.Mutex
 	protocolInstances map[TokenID]ProtocolInstance
 
@@ -43,17 +44,60 @@ type Overlay struct {
 	// lock associated with pending ProtocolMsg
 	pendingMsgLock sync.Mutex
 
-	transmitMux sync.Mutex
+	// queues run the protocol messages of the instances, and tokenLocks
+	// keep the instances from getting two messages at the same time
+	queues     *dispatchQueues
+	tokenLocks tokenLocks
+	// budget runs the instances giving their messages to their protocol
+	budget goroutineBudget
 
 	protoIO *messageProxyStore
 
-	pendingConfigs    map[TokenID]*GenericConfig
+	// pendingConfigs are the configs of the instances that are not yet
+	// started, forgotten after globalProtocolTimeout at least
+	pendingConfigs    *tokenGenerations
 	pendingConfigsMut sync.Mutex
 
 	HybridRumorsSent          []HybridRumorSent
 	ReceivedHybridRumors      []HybridRumor
 	ModifyHybridRumorResponse func([]byte) []byte
 	storeHybridRumorMux       sync.Mutex
+
+	// delivery reports of the messages sent with SendToRosterSubset
+	subsetDeliveries     map[uuid.UUID]*subsetDelivery
+	subsetDeliveriesLock sync.Mutex
+	subsetReportTimeout  time.Duration
+	// the relayed messages already seen, to drop the replays
+	subsetSeen      map[uuid.UUID]bool
+	subsetSeenOrder []subsetSeen
+	subsetSeenLock  sync.Mutex
+
+	// round-trip times measured and acknowledgements awaited by Anycast
+	latencies      map[network.ServerIdentityID]*peerLatency
+	anycastAcks    map[uuid.UUID]chan struct{}
+	latenciesLock  sync.Mutex
+	anycastTimeout time.Duration
+
+	// receipts awaited by BroadcastAck
+	broadcasts     map[uuid.UUID]*broadcastDelivery
+	broadcastsLock sync.Mutex
+
+	// leader elections by service and roster
+	leaders     map[ServiceID]map[RosterID]*LeaderElection
+	leadersLock sync.Mutex
+
+	// random beacons by service and roster
+	beacons     map[ServiceID]map[RosterID]*Beacon
+	beaconsLock sync.Mutex
+
+	// ordered broadcasts by service and roster
+	ordered     map[ServiceID]map[RosterID]*OrderedBroadcast
+	orderedLock sync.Mutex
+
+	// heartbeats and suspicions of the watched servers
+	failures *failureMonitor
+	// clocks of the peers sampled by the heartbeats
+	clockSkews *clockSkews
 }
 
 // NewOverlay creates a new overlay-structure
@@ -62,18 +106,32 @@ func NewOverlay(c *Server) *Overlay {
 		server:               c,
 		treeStorage:          newTreeStorage(globalProtocolTimeout),
 		instances:            make(map[TokenID]*TreeNodeInstance),
-		instancesInfo:        make(map[TokenID]bool),
 		protocolInstances:    make(map[TokenID]ProtocolInstance),
 		pendingTreeMarshal:   make(map[RosterID][]*TreeMarshal),
-		pendingConfigs:       make(map[TokenID]*GenericConfig),
+		queues:               newDispatchQueues(defaultDispatchWorkers),
 		HybridRumorsSent:     make([]HybridRumorSent, 0),
 		ReceivedHybridRumors: make([]HybridRumor, 0),
 		// By default no modifications are done to Rumor Responses
 		ModifyHybridRumorResponse: func(message []byte) []byte {
 			return message
 		},
-	}
-	o.protoIO = newMessageProxyStore(c.suite, c, o)
+		subsetDeliveries:    make(map[uuid.UUID]*subsetDelivery),
+		subsetReportTimeout: defaultSubsetReportTimeout,
+		subsetSeen:          make(map[uuid.UUID]bool),
+		latencies:           make(map[network.ServerIdentityID]*peerLatency),
+		anycastAcks:         make(map[uuid.UUID]chan struct{}),
+		anycastTimeout:      defaultAnycastTimeout,
+		broadcasts:          make(map[uuid.UUID]*broadcastDelivery),
+		leaders:             make(map[ServiceID]map[RosterID]*LeaderElection),
+		beacons:             make(map[ServiceID]map[RosterID]*Beacon),
+		ordered:             make(map[ServiceID]map[RosterID]*OrderedBroadcast),
+		failures:            newFailureMonitor(),
+		clockSkews:          newClockSkews(),
+	}
+	now := func() time.Time { return c.Clock().Now() }
+	o.finished = newTokenGenerations(globalProtocolTimeout, now)
+	o.pendingConfigs = newTokenGenerations(globalProtocolTimeout, now)
+	o.protoIO = newMessageProxyStore(c.suite, c.Router.SharedMemory(), c, o)
 	// messages going to protocol instances
 	c.RegisterProcessor(o,
 		ProtocolMsgID,     // protocol instance's messages
@@ -84,18 +142,71 @@ func NewOverlay(c *Server) *Overlay {
 		SendTreeMsgID,
 		ConfigMsgID, // fetch config information
 		HybridRumorMsgID,
-		HybridRumorResponseMsgID)
+		HybridRumorResponseMsgID,
+		SubsetMsgID,
+		SubsetReportMsgID,
+		AnycastMsgID,
+		AnycastAckMsgID,
+		BroadcastMsgID,
+		BroadcastReceiptMsgID,
+		OrderedSubmitMsgID,
+		OrderedMsgID,
+		OrderedFetchMsgID,
+		HeartbeatMsgID)
 	return o
 }
 
 // Process implements the Processor interface so it process the messages that it
 // wants.
+// The protocol messages are given to the instances by the workers of the
+// overlay, in their order for each instance, following the DispatchPolicy of
+// their service.
 func (o *Overlay) Process(env *network.Envelope) {
 	// Messages handled by the overlay directly without any messageProxyIO
 	if env.MsgType.Equal(ConfigMsgID) {
 		o.handleConfigMessage(env)
 		return
 	}
+	if env.MsgType.Equal(SubsetMsgID) {
+		o.handleSubsetMsg(env)
+		return
+	}
+	if env.MsgType.Equal(SubsetReportMsgID) {
+		o.handleSubsetReport(env)
+		return
+	}
+	if env.MsgType.Equal(AnycastMsgID) {
+		o.handleAnycastMsg(env)
+		return
+	}
+	if env.MsgType.Equal(AnycastAckMsgID) {
+		o.handleAnycastAck(env)
+		return
+	}
+	if env.MsgType.Equal(BroadcastMsgID) {
+		o.handleBroadcastMsg(env)
+		return
+	}
+	if env.MsgType.Equal(BroadcastReceiptMsgID) {
+		o.handleBroadcastReceipt(env)
+		return
+	}
+	if env.MsgType.Equal(OrderedSubmitMsgID) {
+		o.handleOrderedSubmit(env)
+		return
+	}
+	if env.MsgType.Equal(OrderedMsgID) {
+		o.handleOrderedMsg(env)
+		return
+	}
+	if env.MsgType.Equal(OrderedFetchMsgID) {
+		o.handleOrderedFetch(env)
+		return
+	}
+	if env.MsgType.Equal(HeartbeatMsgID) {
+		o.handleHeartbeat(env)
+		return
+	}
 
 	// get messageProxy or default one
 	io := o.protoIO.getByPacketType(env.MsgType)
@@ -130,12 +241,27 @@ func (o *Overlay) Process(env *network.Envelope) {
 			Msg:            inner,
 			MsgType:        typ,
 			Size:           env.Size,
+			Clock:          info.TreeNodeInfo.Clock,
+			ClockTick:      info.TreeNodeInfo.ClockTick,
+			LogicalTime:    info.TreeNodeInfo.LogicalTime,
 		}
-		err = o.TransmitMsg(protoMsg, io)
-		if err != nil {
-			log.Errorf("Msg %s from %s produced error: %s", protoMsg.MsgType,
-				protoMsg.ServerIdentity, err.Error())
+		encoding := func() ([]byte, error) {
+			if pm, ok := env.Msg.(*ProtocolMsg); ok && pm.Msg == nil {
+				return pm.MsgSlice, nil
+			}
+			return network.Marshal(inner)
+		}
+		if o.queues.duplicate(protoMsg.To, typ, encoding) {
+			log.Lvl5("Dropping duplicate message for", protoMsg.To.ID())
+			return
 		}
+		o.queues.push(protoMsg.To, func() {
+			err := o.TransmitMsg(protoMsg, io)
+			if err != nil {
+				log.Errorf("Msg %s from %s produced error: %s", protoMsg.MsgType,
+					protoMsg.ServerIdentity, err.Error())
+			}
+		})
 	}
 }
 
@@ -161,13 +287,12 @@ func (o *Overlay) TransmitMsg(onetMsg *ProtocolMsg, io MessageProxy) error {
 		return nil
 	}
 
-	o.transmitMux.Lock()
-	defer o.transmitMux.Unlock()
+	defer o.tokenLocks.lock(onetMsg.To.ID())()
 	// TreeNodeInstance
 	var pi ProtocolInstance
 	o.instancesLock.Lock()
 	pi, ok := o.protocolInstances[onetMsg.To.ID()]
-	done := o.instancesInfo[onetMsg.To.ID()]
+	_, done := o.finished.get(onetMsg.To.ID())
 	o.instancesLock.Unlock()
 	if done {
 		log.Lvl5("Message for TreeNodeInstance that is already finished")
@@ -175,6 +300,11 @@ func (o *Overlay) TransmitMsg(onetMsg *ProtocolMsg, io MessageProxy) error {
 	}
 	// if the TreeNodeInstance is not there, creates it
 	if !ok {
+		if o.server.Draining() {
+			log.Lvlf2("%s: refusing new instance of %x while shutting down",
+				o.server.ServerIdentity, onetMsg.To.ID())
+			return nil
+		}
 		log.Lvlf4("Creating TreeNodeInstance at %s %x", o.server.ServerIdentity, onetMsg.To.ID())
 		tn, err := o.TreeNodeFromTree(tree, onetMsg.To.TreeNodeID)
 		if err != nil {
@@ -194,6 +324,7 @@ func (o *Overlay) TransmitMsg(onetMsg *ProtocolMsg, io MessageProxy) error {
 		if pi == nil {
 			return nil
 		}
+		dispatch := tni.dispatch(pi)
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -204,7 +335,7 @@ func (o *Overlay) TransmitMsg(onetMsg *ProtocolMsg, io MessageProxy) error {
 				}
 			}()
 
-			err := pi.Dispatch()
+			err := dispatch()
 			if err != nil {
 				svc := ServiceFactory.Name(tni.Token().ServiceID)
 				log.Errorf("%v %s.Dispatch() returned error %s", o.server.ServerIdentity, svc, err)
@@ -217,6 +348,9 @@ func (o *Overlay) TransmitMsg(onetMsg *ProtocolMsg, io MessageProxy) error {
 		log.Lvl4(o.server.Address(), "Overlay created new ProtocolInstace msg => ",
 			fmt.Sprintf("%+v", onetMsg.To))
 	}
+	if r := o.server.traceRecorder(); r != nil {
+		r.record(o.server.protocols.ProtocolIDToName(onetMsg.To.ProtoID), tree, onetMsg)
+	}
 	// TODO Check if TreeNodeInstance is already Done
 	pi.ProcessProtocolMsg(onetMsg)
 	return nil
@@ -262,11 +396,13 @@ func (o *Overlay) checkPendingMessages(t *Tree) {
 		o.pendingMsgLock.Unlock()
 
 		for _, msg := range remaining {
-			err := o.TransmitMsg(msg.ProtocolMsg, msg.MessageProxy)
-			if err != nil {
-				log.Error("TransmitMsg failed:", err)
-				continue
-			}
+			msg := msg
+			o.queues.push(msg.To, func() {
+				err := o.TransmitMsg(msg.ProtocolMsg, msg.MessageProxy)
+				if err != nil {
+					log.Error("TransmitMsg failed:", err)
+				}
+			})
 		}
 	}()
 }
@@ -342,6 +478,30 @@ func (o *Overlay) RegisterTree(t *Tree) {
 	o.checkPendingMessages(t)
 }
 
+// SetTreeCache changes the limits of the cache of the trees. The zero values
+// keep the current ones.
+func (o *Overlay) SetTreeCache(c TreeCacheConfig) {
+	o.treeStorage.setConfig(c)
+}
+
+// TreeCacheStats returns the metrics of the cache of the trees.
+func (o *Overlay) TreeCacheStats() TreeCacheStats {
+	return o.treeStorage.getStats()
+}
+
+// InvalidateTree removes the tree from the cache, unless a running protocol
+// uses it, and returns whether it was removed. The tree is asked again to
+// the other servers when they send messages for it.
+func (o *Overlay) InvalidateTree(id TreeID) bool {
+	return o.treeStorage.Invalidate(id)
+}
+
+// InvalidateRoster removes the trees of the roster from the cache, like
+// InvalidateTree, and returns how many were removed.
+func (o *Overlay) InvalidateRoster(id RosterID) int {
+	return o.treeStorage.InvalidateRoster(id)
+}
+
 // TreeNodeFromTree returns the treeNode corresponding to the id
 func (o *Overlay) TreeNodeFromTree(tree *Tree, id TreeNodeID) (*TreeNode, error) {
 	tn := tree.Search(id)
@@ -544,7 +704,14 @@ func (o *Overlay) handleConfigMessage(env *network.Envelope) {
 
 	o.pendingConfigsMut.Lock()
 	defer o.pendingConfigsMut.Unlock()
-	o.pendingConfigs[config.Dest] = &config.Config
+	o.pendingConfigs.put(config.Dest, pendingConfig{config.Dest, &config.Config})
+}
+
+// pendingConfig keeps the token of the config, as the tokens are stored by
+// a part of them only.
+type pendingConfig struct {
+	dest   TokenID
+	config *GenericConfig
 }
 
 // getConfig returns the generic config corresponding to this node if present,
@@ -552,9 +719,11 @@ func (o *Overlay) handleConfigMessage(env *network.Envelope) {
 func (o *Overlay) getConfig(id TokenID) *GenericConfig {
 	o.pendingConfigsMut.Lock()
 	defer o.pendingConfigsMut.Unlock()
-	c := o.pendingConfigs[id]
-	delete(o.pendingConfigs, id)
-	return c
+	pc, ok := o.pendingConfigs.take(id)
+	if !ok || !pc.(pendingConfig).dest.Equal(id) {
+		return nil
+	}
+	return pc.(pendingConfig).config
 }
 
 // SendToTreeNode sends a message to a treeNode
@@ -566,6 +735,13 @@ func (o *Overlay) getConfig(id TokenID) *GenericConfig {
 // in the `NewProtocol` method if a Service has created the protocol and set the
 // config with `SetConfig`. It can be nil.
 func (o *Overlay) SendToTreeNode(from *Token, to *TreeNode, msg network.Message, io MessageProxy, c *GenericConfig) (uint64, error) {
+	return o.sendToTreeNode(from, to, msg, io, c, nil, false, 0)
+}
+
+// sendToTreeNode is SendToTreeNode with the causal clock and the Lamport time
+// of the message.
+func (o *Overlay) sendToTreeNode(from *Token, to *TreeNode, msg network.Message, io MessageProxy, c *GenericConfig,
+	clock VectorClock, tick bool, logical uint64) (uint64, error) {
 	tokenTo := from.ChangeTreeNodeID(to.ID)
 	var totSentLen uint64
 
@@ -582,8 +758,11 @@ func (o *Overlay) SendToTreeNode(from *Token, to *TreeNode, msg network.Message,
 	var final interface{}
 	info := &OverlayMsg{
 		TreeNodeInfo: &TreeNodeInfo{
-			From: from,
-			To:   tokenTo,
+			From:        from,
+			To:          tokenTo,
+			Clock:       clock,
+			ClockTick:   tick,
+			LogicalTime: logical,
 		},
 	}
 	final, err := io.Wrap(msg, info)
@@ -624,27 +803,11 @@ func (o *Overlay) nodeDelete(token *Token) {
 	delete(o.protocolInstances, tok)
 	delete(o.instances, tok)
 
-	o.cleanTreeStorage(token)
+	// the tree is kept for a while if no other instance uses it
+	o.treeStorage.Unpin(token.TreeID)
 
 	// mark it done !
-	o.instancesInfo[tok] = true
-}
-
-// checks if another instance is using the same tree and clean it
-// only if not. Note that this function assumes that o.instances
-// is locked (e.g. Overlay.nodeDone)
-func (o *Overlay) cleanTreeStorage(token *Token) {
-	notUsed := true
-	for _, inst := range o.instances {
-		if inst.token.TreeID.Equal(token.TreeID) {
-			// another instance is using the same tree
-			notUsed = false
-		}
-	}
-
-	if notUsed {
-		o.treeStorage.Remove(token.TreeID)
-	}
+	o.finished.put(tok, struct{}{})
 }
 
 func (o *Overlay) suite() network.Suite {
@@ -662,6 +825,16 @@ func (o *Overlay) Close() {
 
 	// force cleaning routines to shutdown
 	o.treeStorage.Close()
+
+	o.beaconsLock.Lock()
+	for _, rosters := range o.beacons {
+		for _, b := range rosters {
+			b.Stop()
+		}
+	}
+	o.beaconsLock.Unlock()
+
+	o.failures.close()
 }
 
 // CreateProtocol creates a ProtocolInstance, registers it to the Overlay.
@@ -678,6 +851,7 @@ func (o *Overlay) CreateProtocol(name string, t *Tree, sid ServiceID) (ProtocolI
 	if err = o.RegisterProtocolInstance(pi); err != nil {
 		return nil, xerrors.Errorf("registering protocol instance: %v", err)
 	}
+	dispatch := tni.dispatch(pi)
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
@@ -685,7 +859,7 @@ func (o *Overlay) CreateProtocol(name string, t *Tree, sid ServiceID) (ProtocolI
 			}
 		}()
 
-		err := pi.Dispatch()
+		err := dispatch()
 		if err != nil {
 			log.Errorf("%s.Dispatch() created in service %s returned error %s",
 				name, ServiceFactory.Name(sid), err)
@@ -765,6 +939,9 @@ func (o *Overlay) newTreeNodeInstanceFromToken(tn *TreeNode, tok *Token, io Mess
 	tni := newTreeNodeInstance(o, tok, tn, io)
 	o.instancesLock.Lock()
 	defer o.instancesLock.Unlock()
+	if _, ok := o.instances[tok.ID()]; !ok {
+		o.treeStorage.Pin(tok.TreeID)
+	}
 	o.instances[tok.ID()] = tni
 	return tni
 }
@@ -923,7 +1100,7 @@ func (o *Overlay) SendHybridRumor(roster Roster, childrenNodeNumber int, msg []b
 
 // Get a random roster list from a roster, with a max size passed as the second parameter
 func (o *Overlay) getRandomRosterList(roster Roster, maxSizeNewRoster int) []*network.ServerIdentity {
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	r := o.server.Rand()
 	// Randomly select tree nodes
 	newRosterList := make([]*network.ServerIdentity, maxSizeNewRoster)
 	perm := r.Perm(len(roster.List))
@@ -1045,22 +1222,32 @@ type pendingMsg struct {
 // wire format protocol,i.e. it wraps a message into a ProtocolMessage
 type defaultProtoIO struct {
 	suite network.Suite
+	// shared passes the protocol messages without marshaling them, for a
+	// router sharing memory
+	shared bool
 }
 
 // Wrap implements the MessageProxy interface for the Overlay.
 func (d *defaultProtoIO) Wrap(msg interface{}, info *OverlayMsg) (interface{}, error) {
 	if msg != nil {
+		typ := network.MessageType(msg)
+		protoMsg := &ProtocolMsg{
+			From:        info.TreeNodeInfo.From,
+			To:          info.TreeNodeInfo.To,
+			MsgType:     typ,
+			Clock:       info.TreeNodeInfo.Clock,
+			ClockTick:   info.TreeNodeInfo.ClockTick,
+			LogicalTime: info.TreeNodeInfo.LogicalTime,
+		}
+		if d.shared && !typ.Equal(network.ErrorType) {
+			protoMsg.Msg = msg
+			return protoMsg, nil
+		}
 		buff, err := network.Marshal(msg)
 		if err != nil {
 			return nil, xerrors.Errorf("marshaling: %v", err)
 		}
-		typ := network.MessageType(msg)
-		protoMsg := &ProtocolMsg{
-			From:     info.TreeNodeInfo.From,
-			To:       info.TreeNodeInfo.To,
-			MsgSlice: buff,
-			MsgType:  typ,
-		}
+		protoMsg.MsgSlice = buff
 		return protoMsg, nil
 	}
 	var returnMsg interface{}
@@ -1094,15 +1281,21 @@ func (d *defaultProtoIO) Unwrap(msg interface{}) (interface{}, *OverlayMsg, erro
 	switch inner := msg.(type) {
 	case *ProtocolMsg:
 		onetMsg := inner
-		var err error
-		_, protoMsg, err := network.Unmarshal(onetMsg.MsgSlice, d.suite)
-		if err != nil {
-			return nil, nil, xerrors.Errorf("unmarshaling: %v", err)
+		protoMsg := onetMsg.Msg
+		if protoMsg == nil {
+			var err error
+			_, protoMsg, err = network.Unmarshal(onetMsg.MsgSlice, d.suite)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("unmarshaling: %v", err)
+			}
 		}
 		// Put the msg into ProtocolMsg
 		returnOverlay.TreeNodeInfo = &TreeNodeInfo{
-			To:   onetMsg.To,
-			From: onetMsg.From,
+			To:          onetMsg.To,
+			From:        onetMsg.From,
+			Clock:       onetMsg.Clock,
+			ClockTick:   onetMsg.ClockTick,
+			LogicalTime: onetMsg.LogicalTime,
 		}
 		returnMsg = protoMsg
 	case *RequestTree:
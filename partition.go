@@ -0,0 +1,39 @@
+package onet
+
+import (
+	"go.dedis.ch/onet/v4/network"
+)
+
+// Partition cuts the network between the two groups of servers of the test:
+// the messages between a server of groupA and one of groupB are lost, in
+// both directions, until Heal is called. The servers that are in none of the
+// groups still talk to everyone. Several partitions add up.
+func (l *LocalTest) Partition(groupA, groupB []*Server) {
+	l.panicClosed()
+	identities := func(group []*Server) []*network.ServerIdentity {
+		var sis []*network.ServerIdentity
+		for _, srv := range group {
+			sis = append(sis, srv.ServerIdentity)
+		}
+		return sis
+	}
+	a, b := identities(groupA), identities(groupB)
+	for _, srv := range groupA {
+		srv.Router.Block(b...)
+	}
+	for _, srv := range groupB {
+		srv.Router.Block(a...)
+	}
+}
+
+// Heal reverses all the partitions of the test.
+func (l *LocalTest) Heal() {
+	l.panicClosed()
+	var all []*network.ServerIdentity
+	for _, srv := range l.Servers {
+		all = append(all, srv.ServerIdentity)
+	}
+	for _, srv := range l.Servers {
+		srv.Router.Unblock(all...)
+	}
+}
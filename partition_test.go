@@ -0,0 +1,77 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+type partitionTestMsg struct {
+	From int
+}
+
+var partitionTestMsgID = network.RegisterMessage(partitionTestMsg{})
+
+func TestLocalTest_Partition(t *testing.T) {
+	for _, local := range []*LocalTest{NewLocalTest(tSuite), NewTCPTest(tSuite)} {
+		testPartition(t, local)
+	}
+}
+
+func testPartition(t *testing.T, local *LocalTest) {
+	defer local.CloseAll()
+	servers := local.GenServers(3)
+	received := make([]chan int, len(servers))
+	for i, s := range servers {
+		ch := make(chan int, 10)
+		received[i] = ch
+		s.RegisterProcessorFunc(partitionTestMsgID, func(env *network.Envelope) error {
+			ch <- env.Msg.(*partitionTestMsg).From
+			return nil
+		})
+	}
+	send := func(from, to int) {
+		_, err := servers[from].Send(servers[to].ServerIdentity, &partitionTestMsg{from})
+		require.NoError(t, err)
+	}
+	gets := func(to, from int) {
+		select {
+		case got := <-received[to]:
+			require.Equal(t, from, got)
+		case <-time.After(time.Second):
+			require.Fail(t, "message lost", "from %d to %d", from, to)
+		}
+	}
+	loses := func(to int) {
+		select {
+		case got := <-received[to]:
+			require.Fail(t, "message got through", "from %d to %d", got, to)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// connect the servers
+	send(0, 1)
+	gets(1, 0)
+	send(1, 0)
+	gets(0, 1)
+
+	local.Partition(servers[:1], servers[1:2])
+	send(0, 1)
+	loses(1)
+	send(1, 0)
+	loses(0)
+	// the third server is on both sides
+	send(0, 2)
+	gets(2, 0)
+	send(2, 1)
+	gets(1, 2)
+
+	local.Heal()
+	send(0, 1)
+	gets(1, 0)
+	send(1, 0)
+	gets(0, 1)
+}
@@ -0,0 +1,282 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// PeerSamplingViewSize is the number of peers in the partial view of a
+// server.
+const PeerSamplingViewSize = 20
+
+// number of peers exchanged in a shuffle
+const peerShuffleSize = 8
+
+// how long to wait for the answer of a shuffle
+const defaultPeerShuffleTimeout = 2 * time.Second
+
+// how often a server shuffles its view once it joined
+const peerShuffleInterval = 10 * time.Second
+
+// PeerShuffleMsgID of PeerShuffle message as registered in network
+var PeerShuffleMsgID = network.RegisterMessage(PeerShuffle{})
+
+// PeerShuffleReplyMsgID of PeerShuffleReply message as registered in network
+var PeerShuffleReplyMsgID = network.RegisterMessage(PeerShuffleReply{})
+
+// PeerEntry is a peer of a partial view, with the number of shuffles since
+// its entry was created by the peer itself.
+type PeerEntry struct {
+	ServerIdentity *network.ServerIdentity
+	Age            int
+}
+
+// PeerShuffle offers some peers of the view of the sender, including itself,
+// in exchange for some of the view of the receiver.
+type PeerShuffle struct {
+	ID    uuid.UUID
+	Peers []PeerEntry
+}
+
+// PeerShuffleReply is the answer to a PeerShuffle.
+type PeerShuffleReply struct {
+	ID    uuid.UUID
+	Peers []PeerEntry
+}
+
+// PeerSampling keeps a partial view of the servers, maintained by gossip as
+// in Cyclon: regularly, a server swaps some peers of its view with the peer it
+// heard of the longest ago, which removes the peers that left and mixes the
+// views, so that they converge to uniform samples of the live servers. Every
+// server answers the shuffles of the others, but only starts to shuffle
+// itself once Join is called.
+type PeerSampling struct {
+	server *Server
+
+	// view holds at most PeerSamplingViewSize peers, never us
+	view     []PeerEntry
+	viewLock sync.Mutex
+
+	pending     map[uuid.UUID]chan []PeerEntry
+	pendingLock sync.Mutex
+	timeout     time.Duration
+
+	joined  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newPeerSampling(s *Server) *PeerSampling {
+	p := &PeerSampling{
+		server:  s,
+		pending: make(map[uuid.UUID]chan []PeerEntry),
+		timeout: defaultPeerShuffleTimeout,
+		closing: make(chan struct{}),
+	}
+	s.RegisterProcessor(p, PeerShuffleMsgID, PeerShuffleReplyMsgID)
+	return p
+}
+
+// Join adds the peers to the view and starts to shuffle it regularly, until
+// the server is closed.
+func (p *PeerSampling) Join(peers []*network.ServerIdentity) error {
+	var entries []PeerEntry
+	for _, si := range peers {
+		entries = append(entries, PeerEntry{ServerIdentity: si})
+	}
+	p.merge(entries, nil)
+	if len(p.Peers()) == 0 {
+		return xerrors.New("no peer to join")
+	}
+
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	if !p.joined {
+		p.joined = true
+		p.wg.Add(1)
+		go p.maintain()
+	}
+	return nil
+}
+
+// GetRandomPeers returns up to n peers picked at random from the view.
+func (p *PeerSampling) GetRandomPeers(n int) []*network.ServerIdentity {
+	peers := p.Peers()
+	if n > len(peers) {
+		n = len(peers)
+	}
+	out := make([]*network.ServerIdentity, n)
+	for i, j := range p.server.Rand().Perm(len(peers))[:n] {
+		out[i] = peers[j]
+	}
+	return out
+}
+
+// Peers returns the peers of the view.
+func (p *PeerSampling) Peers() []*network.ServerIdentity {
+	p.viewLock.Lock()
+	defer p.viewLock.Unlock()
+	peers := make([]*network.ServerIdentity, len(p.view))
+	for i, e := range p.view {
+		peers[i] = e.ServerIdentity
+	}
+	return peers
+}
+
+// Shuffle swaps some peers of the view with the oldest one. The oldest one is
+// removed from the view, and only comes back if it answered or another peer
+// gives it again.
+func (p *PeerSampling) Shuffle() error {
+	p.viewLock.Lock()
+	if len(p.view) == 0 {
+		p.viewLock.Unlock()
+		return xerrors.New("view is empty")
+	}
+	oldest := 0
+	for i := range p.view {
+		p.view[i].Age++
+		if p.view[i].Age > p.view[oldest].Age {
+			oldest = i
+		}
+	}
+	target := p.view[oldest].ServerIdentity
+	p.view = append(p.view[:oldest:oldest], p.view[oldest+1:]...)
+	sent := append([]PeerEntry{{ServerIdentity: p.server.ServerIdentity}}, p.pick(peerShuffleSize-1, nil)...)
+	p.viewLock.Unlock()
+
+	req := &PeerShuffle{ID: uuid.NewV4(), Peers: sent}
+	c := make(chan []PeerEntry, 1)
+	p.pendingLock.Lock()
+	p.pending[req.ID] = c
+	p.pendingLock.Unlock()
+	defer func() {
+		p.pendingLock.Lock()
+		delete(p.pending, req.ID)
+		p.pendingLock.Unlock()
+	}()
+
+	var err error
+	if _, err = p.server.Send(target, req); err == nil {
+		select {
+		case peers := <-c:
+			p.merge(peers, sent[1:])
+			// it is alive, so it comes back if there is room
+			p.merge([]PeerEntry{{ServerIdentity: target}}, nil)
+			return nil
+		case <-p.closing:
+			err = xerrors.New("closing")
+		case <-p.server.Clock().After(p.timeout):
+			err = xerrors.New("timeout")
+		}
+	}
+	return xerrors.Errorf("shuffling with %v: %v", target, err)
+}
+
+// Process implements the network.Processor interface.
+func (p *PeerSampling) Process(env *network.Envelope) {
+	switch msg := env.Msg.(type) {
+	case *PeerShuffle:
+		p.viewLock.Lock()
+		sent := p.pick(peerShuffleSize, env.ServerIdentity)
+		p.viewLock.Unlock()
+		_, err := p.server.Send(env.ServerIdentity, &PeerShuffleReply{ID: msg.ID, Peers: sent})
+		if err != nil {
+			log.Lvl2("peer sampling: couldn't answer", env.ServerIdentity, ":", err)
+			return
+		}
+		p.merge(msg.Peers, sent)
+	case *PeerShuffleReply:
+		p.pendingLock.Lock()
+		c, ok := p.pending[msg.ID]
+		delete(p.pending, msg.ID)
+		p.pendingLock.Unlock()
+		if ok {
+			c <- msg.Peers
+		}
+	default:
+		log.Error("peer sampling: unknown message type", env.MsgType)
+	}
+}
+
+// pick returns up to n entries of the view at random, other than the one of
+// except. The view must be locked.
+func (p *PeerSampling) pick(n int, except *network.ServerIdentity) []PeerEntry {
+	var out []PeerEntry
+	for _, j := range p.server.Rand().Perm(len(p.view)) {
+		if len(out) == n {
+			break
+		}
+		if except == nil || !p.view[j].ServerIdentity.ID.Equal(except.ID) {
+			out = append(out, p.view[j])
+		}
+	}
+	return out
+}
+
+// merge adds the received entries to the view, except us and the peers
+// already in it. Once the view is full, they replace the entries that were
+// sent in exchange.
+func (p *PeerSampling) merge(received, sent []PeerEntry) {
+	own := p.server.ServerIdentity.ID
+	p.viewLock.Lock()
+	defer p.viewLock.Unlock()
+	for _, e := range received {
+		if e.ServerIdentity == nil || e.ServerIdentity.ID.Equal(own) || p.index(e.ServerIdentity.ID) >= 0 {
+			continue
+		}
+		if len(p.view) < PeerSamplingViewSize {
+			p.view = append(p.view, e)
+			continue
+		}
+		for len(sent) > 0 {
+			i := p.index(sent[0].ServerIdentity.ID)
+			sent = sent[1:]
+			if i >= 0 {
+				p.view[i] = e
+				break
+			}
+		}
+	}
+}
+
+// index returns the index of the peer in the view, -1 if it isn't in it. The
+// view must be locked.
+func (p *PeerSampling) index(id network.ServerIdentityID) int {
+	for i, e := range p.view {
+		if e.ServerIdentity.ID.Equal(id) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *PeerSampling) maintain() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.closing:
+			return
+		case <-p.server.Clock().After(peerShuffleInterval):
+			if err := p.Shuffle(); err != nil {
+				log.Lvl2("peer sampling:", err)
+			}
+		}
+	}
+}
+
+// close stops the shuffles.
+func (p *PeerSampling) close() {
+	p.pendingLock.Lock()
+	select {
+	case <-p.closing:
+	default:
+		close(p.closing)
+	}
+	p.pendingLock.Unlock()
+	p.wg.Wait()
+}
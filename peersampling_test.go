@@ -0,0 +1,59 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestPeerSampling_Shuffle(t *testing.T) {
+	local, servers, _ := setupSubset(t, 25)
+	defer local.CloseAll()
+
+	require.Error(t, servers[0].rps.Join(nil))
+	require.NoError(t, servers[0].rps.Join([]*network.ServerIdentity{servers[1].ServerIdentity}))
+	for _, s := range servers[1:] {
+		require.NoError(t, s.rps.Join([]*network.ServerIdentity{servers[0].ServerIdentity}))
+	}
+	for round := 0; round < 10; round++ {
+		for _, s := range servers {
+			require.NoError(t, s.rps.Shuffle())
+		}
+	}
+
+	known := make(map[network.ServerIdentityID]bool)
+	for _, s := range servers {
+		peers := s.rps.Peers()
+		require.NotEmpty(t, peers)
+		require.True(t, len(peers) <= PeerSamplingViewSize)
+		seen := make(map[network.ServerIdentityID]bool)
+		for _, si := range peers {
+			require.False(t, si.ID.Equal(s.ServerIdentity.ID))
+			require.False(t, seen[si.ID])
+			seen[si.ID] = true
+			known[si.ID] = true
+		}
+	}
+	// the peers that joined late are in the views of the others too
+	require.Len(t, known, len(servers))
+
+	sample := servers[5].rps.GetRandomPeers(3)
+	require.Len(t, sample, 3)
+	require.NotEqual(t, sample[0].ID, sample[1].ID)
+	require.Len(t, servers[5].rps.GetRandomPeers(100), len(servers[5].rps.Peers()))
+}
+
+// Tests that a peer that doesn't answer the shuffle is removed from the view.
+func TestPeerSampling_Closed(t *testing.T) {
+	local, servers, _ := setupSubset(t, 2)
+	defer local.CloseAll()
+
+	servers[0].rps.timeout = 100 * time.Millisecond
+	require.NoError(t, servers[0].rps.Join([]*network.ServerIdentity{servers[1].ServerIdentity}))
+	require.NoError(t, servers[1].Close())
+	require.Error(t, servers[0].rps.Shuffle())
+	require.Empty(t, servers[0].rps.Peers())
+	require.Error(t, servers[0].rps.Shuffle())
+}
@@ -23,7 +23,8 @@ import (
 // a pointer. It will process client requests that have been registered
 // with RegisterMessage.
 type ServiceProcessor struct {
-	handlers map[string]serviceHandler
+	handlers    map[string]serviceHandler
+	rpcHandlers map[string]serviceHandler
 	*Context
 }
 
@@ -32,13 +33,18 @@ type serviceHandler struct {
 	handler   interface{}
 	msgType   reflect.Type
 	streaming bool
+	// the handler takes the *ClientIdentity as first argument
+	authenticated bool
+	// if set, the clients are told the handler will go away
+	deprecation *Deprecation
 }
 
 // NewServiceProcessor initializes your ServiceProcessor.
 func NewServiceProcessor(c *Context) *ServiceProcessor {
 	return &ServiceProcessor{
-		handlers: make(map[string]serviceHandler),
-		Context:  c,
+		handlers:    make(map[string]serviceHandler),
+		rpcHandlers: make(map[string]serviceHandler),
+		Context:     c,
 	}
 }
 
@@ -69,6 +75,34 @@ func (p *ServiceProcessor) RegisterHandler(f interface{}) error {
 	return nil
 }
 
+// RegisterAuthenticatedHandler stores a handler that is only called for
+// clients that authenticated on the websocket, see Server.AddClientToken and
+// Client.SetPrivate. f must be in the following form:
+// func(client *ClientIdentity, msg interface{})(ret interface{}, err error)
+//
+// The other clients get an error without f being called.
+func (p *ServiceProcessor) RegisterAuthenticatedHandler(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func {
+		return xerrors.New("Input is not a function")
+	}
+	if ft.NumIn() != 2 || ft.In(0) != reflect.TypeOf(&ClientIdentity{}) {
+		return xerrors.New("Need two arguments: *ClientIdentity and *struct")
+	}
+	cr := ft.In(1)
+	if cr.Kind() != reflect.Ptr || cr.Elem().Kind() != reflect.Struct {
+		return xerrors.New("2nd argument must be a pointer to a struct")
+	}
+
+	pm, sh, err := createServiceHandler(f)
+	if err != nil {
+		return xerrors.Errorf("creating handler: %v", err)
+	}
+	sh.authenticated = true
+	p.handlers[pm] = sh
+	return nil
+}
+
 // RegisterStreamingHandler stores a handler that is responsible for streaming
 // messages to the client via a channel. Websocket will accept requests for
 // this handler at "ws://service_name/struct_name", where struct_name is
@@ -125,7 +159,7 @@ func (p *ServiceProcessor) RegisterStreamingHandler(f interface{}) error {
 	cr := ft.In(0)
 	log.Lvl4("Registering streaming handler", cr.String())
 	pm := strings.Split(cr.Elem().String(), ".")[1]
-	p.handlers[pm] = serviceHandler{f, cr.Elem(), true}
+	p.handlers[pm] = serviceHandler{f, cr.Elem(), true, false, nil}
 
 	return nil
 }
@@ -338,11 +372,11 @@ func createServiceHandler(f interface{}) (string, serviceHandler, error) {
 			xerrors.New("2nd return value has to implement error, but is: " + ft.Out(1).String())
 	}
 
-	cr := ft.In(0)
+	cr := ft.In(ft.NumIn() - 1)
 	log.Lvl4("Registering handler", cr.String())
 	pm := strings.Split(cr.Elem().String(), ".")[1]
 
-	return pm, serviceHandler{f, cr.Elem(), false}, nil
+	return pm, serviceHandler{f, cr.Elem(), false, false, nil}, nil
 }
 
 func handlerInputCheck(f interface{}) error {
@@ -407,19 +441,24 @@ type StreamingTunnel struct {
 	close chan bool
 }
 
-func callInterfaceFunc(handler, input interface{}, streaming bool) (intf interface{}, ch chan bool, err error) {
+func callInterfaceFunc(handler, input interface{}, streaming bool, client ...*ClientIdentity) (intf interface{}, ch chan bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = xerrors.Errorf("panic with %v", r)
 		}
 	}()
 
-	to := reflect.TypeOf(handler).In(0)
+	ht := reflect.TypeOf(handler)
+	to := ht.In(ht.NumIn() - 1)
 	f := reflect.ValueOf(handler)
 
 	arg := reflect.New(to.Elem())
 	arg.Elem().Set(reflect.ValueOf(input).Elem())
-	ret := f.Call([]reflect.Value{arg})
+	var args []reflect.Value
+	for _, c := range client {
+		args = append(args, reflect.ValueOf(c))
+	}
+	ret := f.Call(append(args, arg))
 
 	if streaming {
 		ierr := ret[2].Interface()
@@ -445,18 +484,27 @@ func callInterfaceFunc(handler, input interface{}, streaming bool) (intf interfa
 // ProcessClientRequest implements the Service interface, see the interface
 // documentation.
 func (p *ServiceProcessor) ProcessClientRequest(req *http.Request, path string, buf []byte) ([]byte, *StreamingTunnel, error) {
-	mh, ok := p.handlers[path]
+	mh, ok := p.lookupHandler(path)
 	reply, stopServiceChan, err := func() (interface{}, chan bool, error) {
 		if !ok {
 			err := xerrors.New("The requested message hasn't been registered: " + path)
 			log.Error(err)
 			return nil, nil, err
 		}
+		if err := network.CheckDecode(buf, mh.msgType); err != nil {
+			return nil, nil, xerrors.Errorf("checking: %v", err)
+		}
 		msg := reflect.New(mh.msgType).Interface()
-		if err := protobuf.DecodeWithConstructors(buf, msg,
-			network.DefaultConstructors(p.Context.server.Suite())); err != nil {
+		if err := network.Decode(buf, msg, p.Context.server.Suite()); err != nil {
 			return nil, nil, xerrors.Errorf("decoding: %v", err)
 		}
+		if mh.authenticated {
+			client := ClientIdentityFromRequest(req)
+			if client == nil {
+				return nil, nil, xerrors.New("authentication required for " + path)
+			}
+			return callInterfaceFunc(mh.handler, msg, false, client)
+		}
 		return callInterfaceFunc(mh.handler, msg, mh.streaming)
 	}()
 	if err != nil {
@@ -504,6 +552,7 @@ func (p *ServiceProcessor) ProcessClientRequest(req *http.Request, path string,
 		return nil, &StreamingTunnel{outChan, stopServiceChan}, nil
 	}
 
+	keepGatewayReply(req, reply)
 	buf, err = protobuf.Encode(reply)
 	if err != nil {
 		log.Error(err)
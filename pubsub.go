@@ -0,0 +1,330 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// DefaultTopicFanout is the number of children each node forwards a topic
+// message to when the publisher doesn't specify one.
+const DefaultTopicFanout = 3
+
+// how long a node waits for a TopicAck before resending a TopicMessage
+const defaultTopicAckTimeout = 2 * time.Second
+
+// how many times a TopicMessage is resent to a silent child before the
+// sender takes over the subtree of that child
+const defaultTopicRetries = 2
+
+// how long the ID of a message is remembered to suppress duplicates
+const defaultTopicDedupWindow = 10 * time.Minute
+
+// TopicMessageID of TopicMessage message as registered in network
+var TopicMessageID = network.RegisterMessage(TopicMessage{})
+
+// TopicAckID of TopicAck message as registered in network
+var TopicAckID = network.RegisterMessage(TopicAck{})
+
+// TopicMsgID uniquely identifies a message published on a topic.
+type TopicMsgID uuid.UUID
+
+// String returns the canonical representation of the TopicMsgID.
+func (id TopicMsgID) String() string {
+	return uuid.UUID(id).String()
+}
+
+// TopicMessage carries the data published on a topic. It is disseminated
+// along the n-ary tree rooted at the origin and built over the Roster, so
+// every node can compute its own children without further communication.
+type TopicMessage struct {
+	ID     TopicMsgID
+	Topic  string
+	Origin *network.ServerIdentity
+	Roster *Roster
+	Fanout int
+	Data   []byte
+	// Signature is the Schnorr signature of the origin on all the fields
+	// above, as the relays could pose as another publisher, or change the
+	// nodes the message is forwarded to, otherwise.
+	Signature []byte
+}
+
+// TopicAck is sent back to the sender of a TopicMessage once it has been
+// received, so that the sender stops retransmitting it.
+type TopicAck struct {
+	ID TopicMsgID
+}
+
+// TopicHandler is called for every message delivered on a subscribed topic.
+// It is called from the network receive loop and must not block.
+type TopicHandler func(origin *network.ServerIdentity, data []byte)
+
+type topicAckKey struct {
+	msg  TopicMsgID
+	from network.ServerIdentityID
+}
+
+// topicSeen is when a message was first seen, in the order of the seen
+// messages so that the old ones are pruned from the front.
+type topicSeen struct {
+	id   TopicMsgID
+	time time.Time
+}
+
+// pubSub keeps the topic subscriptions of the services of a Server and
+// takes care of forwarding, acknowledging and deduplicating topic messages.
+type pubSub struct {
+	server *Server
+
+	subscribers map[string]map[ServiceID]TopicHandler
+	subsLock    sync.Mutex
+
+	seen      map[TopicMsgID]bool
+	seenOrder []topicSeen
+	seenLock  sync.Mutex
+
+	acks     map[topicAckKey]chan struct{}
+	acksLock sync.Mutex
+
+	ackTimeout  time.Duration
+	retries     int
+	dedupWindow time.Duration
+
+	wg      sync.WaitGroup
+	closing chan struct{}
+	closed  bool
+}
+
+func newPubSub(s *Server) *pubSub {
+	ps := &pubSub{
+		server:      s,
+		subscribers: make(map[string]map[ServiceID]TopicHandler),
+		seen:        make(map[TopicMsgID]bool),
+		acks:        make(map[topicAckKey]chan struct{}),
+		ackTimeout:  defaultTopicAckTimeout,
+		retries:     defaultTopicRetries,
+		dedupWindow: defaultTopicDedupWindow,
+		closing:     make(chan struct{}),
+	}
+	s.RegisterProcessor(ps, TopicMessageID, TopicAckID)
+	return ps
+}
+
+// subscribe registers the handler of a service for the given topic. A
+// second call for the same service replaces the handler.
+func (ps *pubSub) subscribe(topic string, sid ServiceID, h TopicHandler) {
+	ps.subsLock.Lock()
+	defer ps.subsLock.Unlock()
+	if ps.subscribers[topic] == nil {
+		ps.subscribers[topic] = make(map[ServiceID]TopicHandler)
+	}
+	ps.subscribers[topic][sid] = h
+}
+
+// unsubscribe removes the handler of the service for the given topic.
+func (ps *pubSub) unsubscribe(topic string, sid ServiceID) {
+	ps.subsLock.Lock()
+	defer ps.subsLock.Unlock()
+	delete(ps.subscribers[topic], sid)
+	if len(ps.subscribers[topic]) == 0 {
+		delete(ps.subscribers, topic)
+	}
+}
+
+// publish sends data on the topic to all members of the roster. The
+// message is delivered locally too if one of our services subscribed.
+func (ps *pubSub) publish(topic string, ro *Roster, fanout int, data []byte) (TopicMsgID, error) {
+	if ro == nil || len(ro.List) == 0 {
+		return TopicMsgID{}, xerrors.New("cannot publish to an empty roster")
+	}
+	if fanout <= 0 {
+		fanout = DefaultTopicFanout
+	}
+	own := ps.server.ServerIdentity
+	if i, _ := ro.Search(own.ID); i < 0 {
+		return TopicMsgID{}, xerrors.New("publisher must be part of the roster")
+	}
+	msg := &TopicMessage{
+		ID:     TopicMsgID(uuid.NewV4()),
+		Topic:  topic,
+		Origin: own,
+		Roster: ro,
+		Fanout: fanout,
+		Data:   data,
+	}
+	payload, err := topicPayload(msg)
+	if err != nil {
+		return TopicMsgID{}, xerrors.Errorf("encoding: %v", err)
+	}
+	if msg.Signature, err = ps.server.overlay.signOrigin("topic", uuid.UUID(msg.ID), payload); err != nil {
+		return TopicMsgID{}, xerrors.Errorf("signing: %v", err)
+	}
+	ps.markSeen(msg.ID)
+	ps.deliver(msg)
+	if err := ps.forward(msg); err != nil {
+		return msg.ID, xerrors.Errorf("forwarding: %v", err)
+	}
+	return msg.ID, nil
+}
+
+// Process implements the network.Processor interface.
+func (ps *pubSub) Process(env *network.Envelope) {
+	switch msg := env.Msg.(type) {
+	case *TopicMessage:
+		ps.handleMessage(env.ServerIdentity, msg)
+	case *TopicAck:
+		ps.handleAck(env.ServerIdentity, msg)
+	default:
+		log.Error("pubsub: unknown message type", env.MsgType)
+	}
+}
+
+// topicPayload returns what the origin of the message signs.
+func topicPayload(msg *TopicMessage) ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signature = nil
+	return protobuf.Encode(&unsigned)
+}
+
+func (ps *pubSub) handleMessage(from *network.ServerIdentity, msg *TopicMessage) {
+	payload, err := topicPayload(msg)
+	if err == nil {
+		err = ps.server.overlay.verifyOrigin("topic", msg.Origin, uuid.UUID(msg.ID), payload, msg.Signature)
+	}
+	if err != nil {
+		log.Errorf("pubsub: message from %s refused: %v", from, err)
+		return
+	}
+	// Always acknowledge, even duplicates, so the sender stops resending.
+	if _, err := ps.server.Send(from, &TopicAck{ID: msg.ID}); err != nil {
+		log.Lvl2("pubsub: couldn't acknowledge", msg.ID, "to", from, ":", err)
+	}
+	if !ps.markSeen(msg.ID) {
+		log.Lvl4("pubsub: dropping duplicate", msg.ID)
+		return
+	}
+	ps.deliver(msg)
+	if err := ps.forward(msg); err != nil {
+		log.Error("pubsub: forwarding failed:", err)
+	}
+}
+
+func (ps *pubSub) handleAck(from *network.ServerIdentity, ack *TopicAck) {
+	ps.acksLock.Lock()
+	defer ps.acksLock.Unlock()
+	key := topicAckKey{ack.ID, from.ID}
+	if c, ok := ps.acks[key]; ok {
+		close(c)
+		delete(ps.acks, key)
+	}
+}
+
+// markSeen returns true if the message has not been seen before. Entries
+// older than the dedup window are pruned on the way.
+func (ps *pubSub) markSeen(id TopicMsgID) bool {
+	ps.seenLock.Lock()
+	defer ps.seenLock.Unlock()
+	now := ps.server.Clock().Now()
+	for len(ps.seenOrder) > 0 && now.Sub(ps.seenOrder[0].time) > ps.dedupWindow {
+		delete(ps.seen, ps.seenOrder[0].id)
+		ps.seenOrder = ps.seenOrder[1:]
+	}
+	if ps.seen[id] {
+		return false
+	}
+	ps.seen[id] = true
+	ps.seenOrder = append(ps.seenOrder, topicSeen{id, now})
+	return true
+}
+
+func (ps *pubSub) deliver(msg *TopicMessage) {
+	ps.subsLock.Lock()
+	handlers := make([]TopicHandler, 0, len(ps.subscribers[msg.Topic]))
+	for _, h := range ps.subscribers[msg.Topic] {
+		handlers = append(handlers, h)
+	}
+	ps.subsLock.Unlock()
+	for _, h := range handlers {
+		h(msg.Origin, msg.Data)
+	}
+}
+
+// forward sends the message to our children in the dissemination tree.
+func (ps *pubSub) forward(msg *TopicMessage) error {
+	tree := msg.Roster.GenerateNaryTreeWithRoot(msg.Fanout, msg.Origin)
+	if tree == nil {
+		return xerrors.New("origin is not part of the roster")
+	}
+	var self *TreeNode
+	for _, tn := range tree.List() {
+		if tn.ServerIdentity.ID.Equal(ps.server.ServerIdentity.ID) {
+			self = tn
+			break
+		}
+	}
+	if self == nil {
+		return xerrors.New("we are not part of the roster")
+	}
+	for _, child := range self.Children {
+		ps.sendReliable(msg, child)
+	}
+	return nil
+}
+
+// sendReliable sends the message to the node and waits for its
+// acknowledgement in the background. If the node stays silent, the message
+// is sent directly to its children, so that its subtree still gets it.
+func (ps *pubSub) sendReliable(msg *TopicMessage, tn *TreeNode) {
+	ps.acksLock.Lock()
+	if ps.closed {
+		ps.acksLock.Unlock()
+		return
+	}
+	key := topicAckKey{msg.ID, tn.ServerIdentity.ID}
+	acked := make(chan struct{})
+	ps.acks[key] = acked
+	ps.wg.Add(1)
+	ps.acksLock.Unlock()
+
+	go func() {
+		defer ps.wg.Done()
+		for try := 0; try <= ps.retries; try++ {
+			if _, err := ps.server.Send(tn.ServerIdentity, msg); err != nil {
+				log.Lvl2("pubsub: sending", msg.ID, "to", tn.ServerIdentity, "failed:", err)
+			}
+			select {
+			case <-acked:
+				return
+			case <-ps.closing:
+				return
+			case <-ps.server.Clock().After(ps.ackTimeout):
+			}
+		}
+		ps.acksLock.Lock()
+		delete(ps.acks, key)
+		ps.acksLock.Unlock()
+		log.Lvl2("pubsub:", tn.ServerIdentity, "didn't acknowledge", msg.ID, "- taking over its subtree")
+		for _, child := range tn.Children {
+			ps.sendReliable(msg, child)
+		}
+	}()
+}
+
+// close stops all retransmissions and waits for them to finish.
+func (ps *pubSub) close() {
+	ps.acksLock.Lock()
+	if ps.closed {
+		ps.acksLock.Unlock()
+		return
+	}
+	ps.closed = true
+	close(ps.closing)
+	ps.acksLock.Unlock()
+	ps.wg.Wait()
+}
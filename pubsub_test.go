@@ -0,0 +1,183 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+const pubSubServiceName = "pubSubService"
+
+type pubSubService struct {
+	*ServiceProcessor
+	sync.Mutex
+	received [][]byte
+	got      chan bool
+}
+
+func (s *pubSubService) messages() [][]byte {
+	s.Lock()
+	defer s.Unlock()
+	return append([][]byte{}, s.received...)
+}
+
+func newPubSubService(c *Context) (Service, error) {
+	s := &pubSubService{
+		ServiceProcessor: NewServiceProcessor(c),
+		got:              make(chan bool, 10),
+	}
+	c.Subscribe("test", func(origin *network.ServerIdentity, data []byte) {
+		s.Lock()
+		s.received = append(s.received, data)
+		s.Unlock()
+		s.got <- true
+	})
+	return s, nil
+}
+
+func setupPubSub(t *testing.T, n int) (*LocalTest, []*Server, []*pubSubService) {
+	sid, err := RegisterNewService(pubSubServiceName, newPubSubService)
+	require.NoError(t, err)
+	local := NewLocalTest(tSuite)
+	servers := local.GenServers(n)
+	services := make([]*pubSubService, n)
+	for i, s := range local.GetServices(servers, sid) {
+		services[i] = s.(*pubSubService)
+		servers[i].pubSub.ackTimeout = 100 * time.Millisecond
+	}
+	return local, servers, services
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	local, servers, services := setupPubSub(t, 7)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	_, err := services[2].PublishWithFanout("test", ro, 2, []byte("hello"))
+	require.NoError(t, err)
+
+	for _, s := range services {
+		select {
+		case <-s.got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+	// give duplicates a chance to show up
+	time.Sleep(200 * time.Millisecond)
+	for _, s := range services {
+		require.Equal(t, [][]byte{[]byte("hello")}, s.messages())
+	}
+}
+
+func TestPubSub_Dedup(t *testing.T) {
+	local, servers, services := setupPubSub(t, 2)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	msg := &TopicMessage{
+		ID:     TopicMsgID{1},
+		Topic:  "test",
+		Origin: servers[0].ServerIdentity,
+		Roster: ro,
+		Fanout: 2,
+		Data:   []byte("once"),
+	}
+	payload, err := topicPayload(msg)
+	require.NoError(t, err)
+	msg.Signature, err = servers[0].overlay.signOrigin("topic", uuid.UUID(msg.ID), payload)
+	require.NoError(t, err)
+	servers[1].pubSub.handleMessage(servers[0].ServerIdentity, msg)
+	servers[1].pubSub.handleMessage(servers[0].ServerIdentity, msg)
+	require.Equal(t, [][]byte{[]byte("once")}, services[1].messages())
+	require.Empty(t, services[0].messages())
+}
+
+func TestPubSub_Forged(t *testing.T) {
+	local, servers, services := setupPubSub(t, 3)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers...)
+	msg := &TopicMessage{
+		ID:     TopicMsgID{2},
+		Topic:  "test",
+		Origin: servers[0].ServerIdentity,
+		Roster: ro,
+		Fanout: 2,
+		Data:   []byte("forged"),
+	}
+	payload, err := topicPayload(msg)
+	require.NoError(t, err)
+
+	// a relay can't pose as the publisher
+	msg.Signature, err = servers[1].overlay.signOrigin("topic", uuid.UUID(msg.ID), payload)
+	require.NoError(t, err)
+	servers[2].pubSub.handleMessage(servers[1].ServerIdentity, msg)
+	require.Empty(t, services[2].messages())
+
+	// nor change where the message is forwarded
+	msg.Signature, err = servers[0].overlay.signOrigin("topic", uuid.UUID(msg.ID), payload)
+	require.NoError(t, err)
+	msg.Fanout = 1
+	servers[2].pubSub.handleMessage(servers[1].ServerIdentity, msg)
+	require.Empty(t, services[2].messages())
+	msg.Fanout = 2
+	servers[2].pubSub.handleMessage(servers[1].ServerIdentity, msg)
+	require.Equal(t, [][]byte{[]byte("forged")}, services[2].messages())
+}
+
+func TestPubSub_Unsubscribe(t *testing.T) {
+	local, servers, services := setupPubSub(t, 2)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	services[1].Unsubscribe("test")
+	ro := local.GenRosterFromHost(servers...)
+	_, err := services[0].Publish("test", ro, []byte("hi"))
+	require.NoError(t, err)
+	<-services[0].got
+	time.Sleep(200 * time.Millisecond)
+	require.Empty(t, services[1].messages())
+}
+
+func TestPubSub_FailedNode(t *testing.T) {
+	local, servers, services := setupPubSub(t, 4)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	// The second node of the roster never answers, so its children must
+	// be reached by the root directly.
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	list := []*network.ServerIdentity{servers[0].ServerIdentity, dead}
+	for _, s := range servers[1:] {
+		list = append(list, s.ServerIdentity)
+	}
+	ro := NewRoster(list)
+	_, err := services[0].PublishWithFanout("test", ro, 2, []byte("robust"))
+	require.NoError(t, err)
+
+	for _, s := range services {
+		select {
+		case <-s.got:
+		case <-time.After(10 * time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+}
+
+func TestPubSub_NotInRoster(t *testing.T) {
+	local, servers, services := setupPubSub(t, 3)
+	defer UnregisterService(pubSubServiceName)
+	defer local.CloseAll()
+
+	ro := local.GenRosterFromHost(servers[1:]...)
+	_, err := services[0].Publish("test", ro, []byte("nope"))
+	require.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package onet
+
+import (
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// defaultDispatchWorkers is how many protocol messages the overlay processes
+// at the same time by default.
+const defaultDispatchWorkers = 16
+
+// defaultDispatchBuffer is how many messages of an instance wait in the
+// bounded queues when DispatchPolicy.Buffer is not set.
+const defaultDispatchBuffer = 100
+
+// DispatchMode is how the overlay gives the messages to a protocol instance.
+type DispatchMode int
+
+const (
+	// DispatchBuffered queues up to Buffer messages of the instance for the
+	// workers of the overlay, and then has the connections wait for the
+	// queue to have some room. It is the default.
+	DispatchBuffered DispatchMode = iota
+	// DispatchBlocking processes the messages in the goroutine of the
+	// connection they arrived on, which waits for them.
+	DispatchBlocking
+	// DispatchDropOldest queues up to Buffer messages of the instance, and
+	// then drops the oldest ones, for the protocols to which the stale
+	// messages are useless, like gossiping.
+	DispatchDropOldest
+	// DispatchUnbounded queues the messages of the instance without a
+	// limit, so that the connections never wait, but a slow instance can
+	// take all the memory.
+	DispatchUnbounded
+)
+
+// DispatchPolicy is how the overlay gives the messages to the protocol
+// instances of a service.
+type DispatchPolicy struct {
+	Mode DispatchMode
+	// Buffer is the size of the queues of DispatchBuffered and
+	// DispatchDropOldest, 100 if it is zero.
+	Buffer int
+	// Dedup drops the messages that were already given to an instance of
+	// the same protocol, like the ones gossiped again, by their type and
+	// their encoding. It remembers at least the last DedupSize messages of
+	// each protocol, 10000 if it is zero, and wrongly drops about one
+	// message in 10000.
+	Dedup     bool
+	DedupSize int
+}
+
+func (p DispatchPolicy) buffer() int {
+	if p.Buffer <= 0 {
+		return defaultDispatchBuffer
+	}
+	return p.Buffer
+}
+
+func (p DispatchPolicy) dedupSize() int {
+	if p.DedupSize <= 0 {
+		return defaultDedupSize
+	}
+	return p.DedupSize
+}
+
+// SetDispatchPolicy sets how the messages of the instances of the protocol
+// started by the service are given to them, or of all its protocols if name
+// is empty. It applies to the messages arriving afterwards.
+func (c *Context) SetDispatchPolicy(name string, p DispatchPolicy) {
+	var id ProtocolID
+	if name != "" {
+		id = ProtocolNameToID(name)
+	}
+	c.overlay.queues.setPolicy(c.serviceID, id, p)
+}
+
+// SetDispatchWorkers changes how many protocol messages the overlay
+// processes at the same time, for different protocol instances. The
+// messages of an instance are always processed in the order they arrived.
+// Zero or less gives the default.
+func (c *Server) SetDispatchWorkers(n int) {
+	c.overlay.queues.setWorkers(n)
+}
+
+// dispatchQueues runs the jobs of the protocol instances with a pool of
+// workers, one job of an instance at a time and in their order, so that a
+// slow instance only delays its own messages. The queues exist only while
+// they have jobs, and the workers only while there are queues waiting, so
+// there is nothing to stop.
+type dispatchQueues struct {
+	sync.Mutex
+	workers int
+	running int
+	queues  map[TokenID]*dispatchQueue
+	// ready are the queues waiting for a worker, the others being run
+	ready   []*dispatchQueue
+	queued  int
+	dropped uint64
+	// room is signalled when a job leaves a queue
+	room     *sync.Cond
+	policies map[dispatchKey]DispatchPolicy
+	// filters are the dedup filters of the protocols, and duplicates the
+	// messages they dropped
+	filters    map[dispatchKey]*dedupFilter
+	duplicates uint64
+}
+
+// dispatchKey are the protocols of a service the policies are for, the nil
+// ProtocolID meaning all of them.
+type dispatchKey struct {
+	service  ServiceID
+	protocol ProtocolID
+}
+
+type dispatchQueue struct {
+	id   TokenID
+	jobs []func()
+}
+
+func newDispatchQueues(workers int) *dispatchQueues {
+	dq := &dispatchQueues{
+		queues:   make(map[TokenID]*dispatchQueue),
+		policies: make(map[dispatchKey]DispatchPolicy),
+		filters:  make(map[dispatchKey]*dedupFilter),
+	}
+	dq.room = sync.NewCond(&dq.Mutex)
+	dq.setWorkers(workers)
+	return dq
+}
+
+func (dq *dispatchQueues) setWorkers(n int) {
+	if n <= 0 {
+		n = defaultDispatchWorkers
+	}
+	dq.Lock()
+	defer dq.Unlock()
+	dq.workers = n
+	dq.start()
+}
+
+func (dq *dispatchQueues) setPolicy(service ServiceID, protocol ProtocolID, p DispatchPolicy) {
+	dq.Lock()
+	defer dq.Unlock()
+	dq.policies[dispatchKey{service, protocol}] = p
+}
+
+// policy returns the policy of the instance of the token, the one of its
+// protocol first, then the one of its service.
+func (dq *dispatchQueues) policy(tok *Token) DispatchPolicy {
+	dq.Lock()
+	defer dq.Unlock()
+	if p, ok := dq.policies[dispatchKey{tok.ServiceID, tok.ProtoID}]; ok {
+		return p
+	}
+	return dq.policies[dispatchKey{service: tok.ServiceID}]
+}
+
+// push adds the job after the other ones of the instance of the token, or
+// runs it for DispatchBlocking.
+func (dq *dispatchQueues) push(tok *Token, job func()) {
+	p := dq.policy(tok)
+	if p.Mode == DispatchBlocking {
+		runJob(job)
+		return
+	}
+	id := tok.ID()
+	dq.Lock()
+	defer dq.Unlock()
+	for {
+		q, ok := dq.queues[id]
+		if !ok || p.Mode == DispatchUnbounded || len(q.jobs) < p.buffer() {
+			break
+		}
+		if p.Mode == DispatchDropOldest {
+			q.jobs = q.jobs[1:]
+			dq.queued--
+			dq.dropped++
+			continue
+		}
+		dq.room.Wait()
+	}
+	q, ok := dq.queues[id]
+	if !ok {
+		q = &dispatchQueue{id: id}
+		dq.queues[id] = q
+		dq.ready = append(dq.ready, q)
+	}
+	q.jobs = append(q.jobs, job)
+	dq.queued++
+	dq.start()
+}
+
+// start starts the workers for the queues waiting. It must be called with
+// the lock.
+func (dq *dispatchQueues) start() {
+	for dq.running < dq.workers && dq.running < len(dq.ready) {
+		dq.running++
+		go dq.work()
+	}
+}
+
+// work runs one job of a queue after the other, the queues taking turns,
+// until none is waiting.
+func (dq *dispatchQueues) work() {
+	dq.Lock()
+	for len(dq.ready) > 0 && dq.running <= dq.workers {
+		q := dq.ready[0]
+		dq.ready = dq.ready[1:]
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		dq.queued--
+		dq.room.Broadcast()
+		dq.Unlock()
+
+		runJob(job)
+
+		dq.Lock()
+		if len(q.jobs) == 0 {
+			delete(dq.queues, q.id)
+		} else {
+			dq.ready = append(dq.ready, q)
+		}
+	}
+	dq.running--
+	dq.Unlock()
+}
+
+func runJob(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("Panic while dispatching a protocol message:", r)
+			log.Error(log.Stack())
+		}
+	}()
+	job()
+}
+
+// len returns how many jobs wait for a worker, how many were dropped and how
+// many duplicates were dropped.
+func (dq *dispatchQueues) len() (int, uint64, uint64) {
+	dq.Lock()
+	defer dq.Unlock()
+	return dq.queued, dq.dropped, dq.duplicates
+}
+
+// tokenLocks are the locks of the tokens being transmitted, so that the
+// messages of different instances don't wait for each other.
+type tokenLocks struct {
+	sync.Mutex
+	locks map[TokenID]*tokenLock
+}
+
+type tokenLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks the token and returns the function unlocking it.
+func (tl *tokenLocks) lock(id TokenID) func() {
+	tl.Lock()
+	if tl.locks == nil {
+		tl.locks = make(map[TokenID]*tokenLock)
+	}
+	l, ok := tl.locks[id]
+	if !ok {
+		l = &tokenLock{}
+		tl.locks[id] = l
+	}
+	l.users++
+	tl.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		tl.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(tl.locks, id)
+		}
+		tl.Unlock()
+	}
+}
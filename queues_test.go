@@ -0,0 +1,168 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Tests that the jobs of an instance are run in order, and the ones of the
+// other instances while it is blocked.
+func TestDispatchQueues_Order(t *testing.T) {
+	dq := newDispatchQueues(2)
+
+	block := make(chan struct{})
+	dq.push(queueToken(1), func() { <-block })
+
+	var mut sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		i := i
+		dq.push(queueToken(2), func() {
+			mut.Lock()
+			order = append(order, i)
+			mut.Unlock()
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	for i := range order {
+		require.Equal(t, i, order[i])
+	}
+
+	done := make(chan struct{})
+	dq.push(queueToken(1), func() { close(done) })
+	queued, _, _ := dq.len()
+	require.Equal(t, 1, queued)
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job of the blocked instance not run")
+	}
+}
+
+// Tests that no more jobs than workers run at the same time.
+func TestDispatchQueues_Workers(t *testing.T) {
+	dq := newDispatchQueues(1)
+	dq.setWorkers(3)
+
+	var mut sync.Mutex
+	running, max := 0, 0
+	var wg sync.WaitGroup
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		dq.push(queueToken(byte(i)), func() {
+			mut.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mut.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mut.Lock()
+			running--
+			mut.Unlock()
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	require.Equal(t, 3, max)
+	queued, _, _ := dq.len()
+	require.Equal(t, 0, queued)
+}
+
+func queueToken(i byte) *Token {
+	return &Token{RoundID: RoundID{i}, ServiceID: ServiceID{1}}
+}
+
+// Tests that the bounded queues wait or drop the oldest jobs when full, that
+// the unbounded ones don't, and that the blocking policy runs the jobs
+// directly.
+func TestDispatchQueues_Policies(t *testing.T) {
+	dq := newDispatchQueues(1)
+	tok := queueToken(1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	dq.push(tok, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	dq.setPolicy(tok.ServiceID, ProtocolID{}, DispatchPolicy{Mode: DispatchDropOldest, Buffer: 2})
+	var mut sync.Mutex
+	var got []int
+	for i := 0; i < 5; i++ {
+		i := i
+		dq.push(tok, func() {
+			mut.Lock()
+			got = append(got, i)
+			mut.Unlock()
+		})
+	}
+	queued, dropped, _ := dq.len()
+	require.Equal(t, 2, queued)
+	require.Equal(t, uint64(3), dropped)
+
+	// the queues are bounded by default
+	dq.setPolicy(tok.ServiceID, ProtocolID{}, DispatchPolicy{Buffer: 2})
+	pushed := make(chan struct{})
+	last := make(chan struct{})
+	go func() {
+		dq.push(tok, func() { close(last) })
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("pushed to a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	dq.setPolicy(tok.ServiceID, ProtocolID{}, DispatchPolicy{Mode: DispatchUnbounded, Buffer: 2})
+	for i := 0; i < 3; i++ {
+		dq.push(tok, func() {})
+	}
+	queued, _, _ = dq.len()
+	require.Equal(t, 5, queued)
+	close(block)
+	<-pushed
+
+	// the policy of the protocol comes before the one of the service
+	proto := queueToken(2)
+	proto.ProtoID = ProtocolID{2}
+	dq.setPolicy(proto.ServiceID, proto.ProtoID, DispatchPolicy{Mode: DispatchBlocking})
+	ran := false
+	dq.push(proto, func() { ran = true })
+	require.True(t, ran)
+
+	<-last
+	mut.Lock()
+	require.Equal(t, []int{3, 4}, got)
+	mut.Unlock()
+}
+
+func TestTokenLocks(t *testing.T) {
+	var tl tokenLocks
+	unlock := tl.lock(TokenID{1})
+	tl.lock(TokenID{2})()
+
+	locked := make(chan struct{})
+	go func() {
+		tl.lock(TokenID{1})()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("token locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+	tl.Lock()
+	require.Equal(t, 0, len(tl.locks))
+	tl.Unlock()
+}
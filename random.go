@@ -0,0 +1,54 @@
+package onet
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lockedSource is a rand.Source that can be used by several goroutines.
+type lockedSource struct {
+	src rand.Source
+	sync.Mutex
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed)}
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.src.Seed(seed)
+}
+
+// serverRand is the source of randomness of a server.
+type serverRand struct {
+	src  *lockedSource
+	rand *rand.Rand
+}
+
+func newServerRand() serverRand {
+	src := newLockedSource(time.Now().UnixNano())
+	return serverRand{src, rand.New(src)}
+}
+
+// SetRandSeed seeds the randomness given to the services and protocols of the
+// server, so that a run can be replayed. It is seeded with the time by
+// default. The random values are not secure, use the suite for the
+// cryptographic ones.
+func (c *Server) SetRandSeed(seed int64) {
+	c.random.src.Seed(seed)
+}
+
+// Rand returns the source of randomness of the server. It can be used by
+// several goroutines.
+func (c *Server) Rand() *rand.Rand {
+	return c.random.rand
+}
@@ -0,0 +1,19 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SetRandSeed(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+
+	servers[0].SetRandSeed(1)
+	servers[1].SetRandSeed(1)
+	require.Equal(t, servers[0].Rand().Perm(10), servers[1].Rand().Perm(10))
+	servers[1].SetRandSeed(2)
+	require.NotEqual(t, servers[0].Rand().Int63(), servers[1].Rand().Int63())
+}
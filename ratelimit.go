@@ -0,0 +1,183 @@
+package onet
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// the close code sent to a client that went over its limits, like the 429
+// status of HTTP
+const closeTooManyRequests = 4029
+
+// above this number of clients, or after this time, the ones that are idle
+// are forgotten
+const (
+	clientLimiterPrune         = 1024
+	clientLimiterPruneInterval = time.Minute
+)
+
+var errTooManyRequests = xerrors.New("too many requests")
+
+// ClientLimits are the limits of the requests a client can send on the
+// websocket. A client is counted by its IP address, and also by its
+// ClientIdentity if it authenticated, so that a new key doesn't give it new
+// requests. The zero values mean no limit.
+type ClientLimits struct {
+	// Rate is the number of requests per second a client can send on
+	// average.
+	Rate float64
+	// Burst is the number of requests a client can send at once, at least 1
+	// if Rate is set.
+	Burst int
+	// MaxInFlight is how many requests of a client can be processed at the
+	// same time.
+	MaxInFlight int
+}
+
+type clientBucket struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// clientLimiter enforces the ClientLimits with a token bucket per client.
+type clientLimiter struct {
+	limits  ClientLimits
+	clients map[string]*clientBucket
+	pruned  time.Time
+	// metrics
+	accepted uint64
+	rejected uint64
+	sync.Mutex
+}
+
+func newClientLimiter() *clientLimiter {
+	return &clientLimiter{clients: make(map[string]*clientBucket)}
+}
+
+// SetClientLimits changes the limits of the requests of the clients on the
+// websocket. The requests over the limits are refused, and the connection
+// is closed with the code 4029.
+func (c *Server) SetClientLimits(l ClientLimits) {
+	c.clientLimiter.Lock()
+	defer c.clientLimiter.Unlock()
+	c.clientLimiter.limits = l
+	c.clientLimiter.clients = make(map[string]*clientBucket)
+}
+
+// clientKeys returns how the client of the request is counted: by its IP
+// address, and by its identity if it authenticated.
+func clientKeys(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"ip:" + host}
+	if id := ClientIdentityFromRequest(r); id != nil {
+		if id.Public != nil {
+			keys = append(keys, "key:"+id.Public.String())
+		} else {
+			keys = append(keys, "token:"+id.Name)
+		}
+	}
+	return keys
+}
+
+func (l *clientLimiter) burst() float64 {
+	if l.limits.Burst < 1 {
+		return 1
+	}
+	return float64(l.limits.Burst)
+}
+
+// acquire counts a new request of the client. If the request is within the
+// limits, the returned function must be called once it is processed.
+func (l *clientLimiter) acquire(r *http.Request) (func(), error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.limits.Rate <= 0 && l.limits.MaxInFlight <= 0 {
+		l.accepted++
+		return func() {}, nil
+	}
+
+	now := time.Now()
+	if len(l.clients) >= clientLimiterPrune || now.Sub(l.pruned) > clientLimiterPruneInterval {
+		l.prune(now)
+	}
+	var buckets []*clientBucket
+	for _, key := range clientKeys(r) {
+		b, ok := l.clients[key]
+		if !ok {
+			b = &clientBucket{tokens: l.burst(), last: now}
+			l.clients[key] = b
+		}
+		if l.limits.Rate > 0 {
+			b.tokens += now.Sub(b.last).Seconds() * l.limits.Rate
+			if b.tokens > l.burst() {
+				b.tokens = l.burst()
+			}
+			b.last = now
+			if b.tokens < 1 {
+				l.rejected++
+				return nil, errTooManyRequests
+			}
+		}
+		if l.limits.MaxInFlight > 0 && b.inFlight >= l.limits.MaxInFlight {
+			l.rejected++
+			return nil, errTooManyRequests
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		if l.limits.Rate > 0 {
+			b.tokens--
+		}
+		b.inFlight++
+	}
+	l.accepted++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.Lock()
+			for _, b := range buckets {
+				b.inFlight--
+			}
+			l.Unlock()
+		})
+	}, nil
+}
+
+// prune forgets the clients that have no request in flight and a full
+// bucket, as they are the same as new clients.
+func (l *clientLimiter) prune(now time.Time) {
+	l.pruned = now
+	for k, b := range l.clients {
+		full := l.limits.Rate <= 0 ||
+			b.tokens+now.Sub(b.last).Seconds()*l.limits.Rate >= l.burst()
+		if b.inFlight == 0 && full {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// GetStatus implements the StatusReporter interface.
+func (l *clientLimiter) GetStatus() *Status {
+	l.Lock()
+	defer l.Unlock()
+	inFlight := 0
+	for _, b := range l.clients {
+		inFlight += b.inFlight
+	}
+	return &Status{Field: map[string]string{
+		"Clients":  strconv.Itoa(len(l.clients)),
+		"InFlight": strconv.Itoa(inFlight),
+		"Accepted": strconv.FormatUint(l.accepted, 10),
+		"Rejected": strconv.FormatUint(l.rejected, 10),
+	}}
+}
@@ -0,0 +1,109 @@
+package onet
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientLimiter(t *testing.T) {
+	l := newClientLimiter()
+	r1 := &http.Request{RemoteAddr: "10.0.0.1:1234"}
+	r2 := &http.Request{RemoteAddr: "10.0.0.2:1234"}
+
+	// no limits
+	for i := 0; i < 10; i++ {
+		release, err := l.acquire(r1)
+		require.NoError(t, err)
+		release()
+	}
+
+	l.limits = ClientLimits{Rate: 0.001, Burst: 2}
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(r1)
+		require.NoError(t, err)
+		release()
+	}
+	_, err := l.acquire(r1)
+	require.Equal(t, errTooManyRequests, err)
+	// another client isn't affected
+	_, err = l.acquire(r2)
+	require.NoError(t, err)
+
+	l.limits = ClientLimits{MaxInFlight: 1}
+	l.clients = make(map[string]*clientBucket)
+	release, err := l.acquire(r1)
+	require.NoError(t, err)
+	_, err = l.acquire(r1)
+	require.Equal(t, errTooManyRequests, err)
+	release()
+	release()
+	release, err = l.acquire(r1)
+	require.NoError(t, err)
+	release()
+
+	st := l.GetStatus()
+	require.Equal(t, "2", st.Field["Rejected"])
+	require.Equal(t, "0", st.Field["InFlight"])
+
+	// a new key doesn't give the client new requests
+	l.limits = ClientLimits{Rate: 0.001, Burst: 2}
+	l.clients = make(map[string]*clientBucket)
+	for i := 0; i < 2; i++ {
+		r := withClientIdentity(r1, &ClientIdentity{Public: tSuite.Point().Pick(tSuite.RandomStream())})
+		release, err := l.acquire(r)
+		require.NoError(t, err)
+		release()
+	}
+	_, err = l.acquire(withClientIdentity(r1, &ClientIdentity{Public: tSuite.Point().Pick(tSuite.RandomStream())}))
+	require.Equal(t, errTooManyRequests, err)
+	// and an identity has its limits on all the addresses
+	for i := 0; i < 2; i++ {
+		release, err = l.acquire(withClientIdentity(r2, &ClientIdentity{Name: "alice"}))
+		require.NoError(t, err)
+		release()
+	}
+	r3 := &http.Request{RemoteAddr: "10.0.0.3:1234"}
+	_, err = l.acquire(withClientIdentity(r3, &ClientIdentity{Name: "alice"}))
+	require.Equal(t, errTooManyRequests, err)
+
+	// the idle clients are forgotten after a while
+	l.limits = ClientLimits{MaxInFlight: 1}
+	l.pruned = time.Now().Add(-2 * clientLimiterPruneInterval)
+	release, err = l.acquire(r1)
+	require.NoError(t, err)
+	release()
+	require.Len(t, l.clients, 1)
+	require.Contains(t, l.clients, "ip:10.0.0.1")
+}
+
+func TestServer_SetClientLimits(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	RegisterNewService(backForthServiceName, func(c *Context) (Service, error) {
+		return &simpleService{
+			ctx: c,
+		}, nil
+	})
+	defer ServiceFactory.Unregister(backForthServiceName)
+
+	servers, el, _ := local.GenTree(2, false)
+	servers[0].SetClientLimits(ClientLimits{Rate: 0.001, Burst: 2})
+	client := local.NewClient(backForthServiceName)
+	r := &SimpleRequest{
+		ServerIdentities: el,
+		Val:              10,
+	}
+	sr := &SimpleResponse{}
+	require.NoError(t, client.SendProtobuf(servers[0].ServerIdentity, r, sr))
+	require.NoError(t, client.SendProtobuf(servers[0].ServerIdentity, r, sr))
+	err := client.SendProtobuf(servers[0].ServerIdentity, r, sr)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too many requests")
+
+	st := servers[0].statusReporterStruct.ReportStatus()["ClientLimits"]
+	require.Equal(t, "1", st.Field["Rejected"])
+}
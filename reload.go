@@ -0,0 +1,122 @@
+package onet
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// ConfigSection is a part of the configuration of the conode that a
+// service can reload without restarting the conode.
+type ConfigSection struct {
+	// Name of the section in the configuration file.
+	Name string
+	// New returns a pointer to a fresh configuration structure the section
+	// is decoded into.
+	New func() interface{}
+	// Validate is optional: if it returns an error, the reload is aborted
+	// and no section is applied.
+	Validate func(cfg interface{}) error
+	// Apply gives the new configuration to the service.
+	Apply func(cfg interface{})
+}
+
+// ConfigDecoder decodes the section with the given name into cfg. It
+// returns false if the section is not present, in which case the
+// configuration of the section is kept as it is.
+type ConfigDecoder func(name string, cfg interface{}) (bool, error)
+
+// configSections holds the reloadable sections of the services of a server.
+type configSections struct {
+	sections map[string]ConfigSection
+	sync.Mutex
+	// only one reload at a time, so that sections are applied atomically
+	reloadLock sync.Mutex
+}
+
+// RegisterConfigSection registers a section of the configuration that can
+// be reloaded with Server.ReloadConfig. Section names are shared by all the
+// services of a server.
+func (c *Context) RegisterConfigSection(s ConfigSection) error {
+	if s.Name == "" || s.New == nil || s.Apply == nil {
+		return xerrors.New("a section needs a name, New and Apply")
+	}
+	cs := &c.server.config
+	cs.Lock()
+	defer cs.Unlock()
+	if cs.sections == nil {
+		cs.sections = make(map[string]ConfigSection)
+	}
+	if _, ok := cs.sections[s.Name]; ok {
+		return xerrors.New("section already registered: " + s.Name)
+	}
+	cs.sections[s.Name] = s
+	return nil
+}
+
+// ReloadConfig decodes all the registered sections and gives them to the
+// services. Either all the sections present are applied, or none of them
+// if one fails to decode or to validate. It returns the names of the
+// sections that have been applied.
+func (c *Server) ReloadConfig(decode ConfigDecoder) ([]string, error) {
+	cs := &c.config
+	cs.reloadLock.Lock()
+	defer cs.reloadLock.Unlock()
+
+	cs.Lock()
+	var names []string
+	sections := make(map[string]ConfigSection)
+	for name, s := range cs.sections {
+		names = append(names, name)
+		sections[name] = s
+	}
+	cs.Unlock()
+	sort.Strings(names)
+
+	var updated []string
+	configs := make(map[string]interface{})
+	for _, name := range names {
+		s := sections[name]
+		cfg := s.New()
+		ok, err := decode(name, cfg)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding section %s: %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+		if s.Validate != nil {
+			if err := s.Validate(cfg); err != nil {
+				return nil, xerrors.Errorf("invalid section %s: %v", name, err)
+			}
+		}
+		updated = append(updated, name)
+		configs[name] = cfg
+	}
+	for _, name := range updated {
+		sections[name].Apply(configs[name])
+	}
+	return updated, nil
+}
+
+// ConfigValues returns a ConfigDecoder giving the configurations of the
+// sections from the values, for the programs embedding a conode without a
+// configuration file. A value is the structure of the section or a pointer
+// to it.
+func ConfigValues(values map[string]interface{}) ConfigDecoder {
+	return func(name string, cfg interface{}) (bool, error) {
+		v, ok := values[name]
+		if !ok {
+			return false, nil
+		}
+		dst := reflect.ValueOf(cfg).Elem()
+		src := reflect.Indirect(reflect.ValueOf(v))
+		if !src.IsValid() || !src.Type().AssignableTo(dst.Type()) {
+			return false, xerrors.Errorf("%T is not a %s", v, dst.Type())
+		}
+		dst.Set(src)
+		return true, nil
+	}
+}
@@ -0,0 +1,69 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type reloadConfig struct {
+	Value int
+}
+
+func TestServer_ReloadConfig(t *testing.T) {
+	var c *Context
+	RegisterNewService("reloadService", func(ctx *Context) (Service, error) {
+		c = ctx
+		return &DummyService{c: ctx}, nil
+	})
+	defer UnregisterService("reloadService")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+
+	applied := make(map[string]int)
+	section := func(name string, max int) ConfigSection {
+		return ConfigSection{
+			Name: name,
+			New:  func() interface{} { return &reloadConfig{} },
+			Validate: func(cfg interface{}) error {
+				if cfg.(*reloadConfig).Value > max {
+					return xerrors.New("too big")
+				}
+				return nil
+			},
+			Apply: func(cfg interface{}) {
+				applied[name] = cfg.(*reloadConfig).Value
+			},
+		}
+	}
+	require.NoError(t, c.RegisterConfigSection(section("a", 10)))
+	require.NoError(t, c.RegisterConfigSection(section("b", 5)))
+	require.Error(t, c.RegisterConfigSection(section("b", 5)))
+	require.Error(t, c.RegisterConfigSection(ConfigSection{Name: "c"}))
+
+	values := map[string]int{"a": 1, "b": 2}
+	decode := func(name string, cfg interface{}) (bool, error) {
+		v, ok := values[name]
+		cfg.(*reloadConfig).Value = v
+		return ok, nil
+	}
+	updated, err := servers[0].ReloadConfig(decode)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, updated)
+	require.Equal(t, map[string]int{"a": 1, "b": 2}, applied)
+
+	// b is invalid, so a must not be applied either
+	values = map[string]int{"a": 3, "b": 6}
+	_, err = servers[0].ReloadConfig(decode)
+	require.Error(t, err)
+	require.Equal(t, map[string]int{"a": 1, "b": 2}, applied)
+
+	// missing sections are left untouched
+	values = map[string]int{"b": 4}
+	updated, err = servers[0].ReloadConfig(decode)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, updated)
+	require.Equal(t, map[string]int{"a": 1, "b": 4}, applied)
+}
@@ -0,0 +1,66 @@
+package onet
+
+import (
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// Restart stops the server of the test at the given index, in the order of
+// their creation, and brings it back up with the same key, database and
+// address, so that the services and the protocols can be tested when
+// recovering from a crash. The server is stopped with Close, but its
+// database is kept and opened again by the new services. The other servers
+// reconnect on their next message. It returns the new server, which replaces
+// the old one in the Servers, Overlays and Services of the test. The
+// partitions the server was part of are lost and must be made again.
+func (l *LocalTest) Restart(serverIndex int) (*Server, error) {
+	l.panicClosed()
+	if serverIndex < 0 || serverIndex >= len(l.order) {
+		return nil, xerrors.Errorf("no server at index %d", serverIndex)
+	}
+	old := l.order[serverIndex]
+	si := old.ServerIdentity
+	log.Lvl3("Restarting server", si.Address)
+
+	old.serviceManager.delDb = false
+	if err := old.Close(); err != nil {
+		return nil, xerrors.Errorf("closing: %v", err)
+	}
+	for old.Listening() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var server *Server
+	switch l.mode {
+	case TCP:
+		tcpHost, err := network.NewTCPHost(si, l.Suite)
+		if err != nil {
+			return nil, xerrors.Errorf("tcp host: %v", err)
+		}
+		router := network.NewRouter(si, tcpHost)
+		router.UnauthOk = true
+		server = newServer(l.Suite, l.path, StorageConfig{}, router, old.private)
+		if err := l.setWebSocketTLS(server); err != nil {
+			return nil, xerrors.Errorf("websocket: %v", err)
+		}
+	default:
+		router, err := network.NewLocalRouterWithManager(l.ctx, si, l.Suite)
+		if err != nil {
+			return nil, xerrors.Errorf("local router: %v", err)
+		}
+		server = newServer(l.Suite, l.path, StorageConfig{}, router, old.private)
+	}
+	l.setServerFaults(server)
+	l.setServerLinks(server)
+	server.SetClock(l.clock)
+	server.StartInBackground()
+
+	l.Servers[si.ID] = server
+	l.Overlays[si.ID] = server.overlay
+	l.Services[si.ID] = server.serviceManager.services
+	l.order[serverIndex] = server
+	return server, nil
+}
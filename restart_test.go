@@ -0,0 +1,76 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestLocalTest_Restart(t *testing.T) {
+	for _, local := range []*LocalTest{NewLocalTest(tSuite), NewTCPTest(tSuite)} {
+		testRestart(t, local)
+	}
+}
+
+func testRestart(t *testing.T, local *LocalTest) {
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	bucket, key := []byte("restart"), []byte("key")
+	require.NoError(t, servers[1].serviceManager.storage.Update(func(tx StorageTx) error {
+		if err := tx.CreateBucket(bucket); err != nil {
+			return err
+		}
+		return tx.Put(bucket, key, []byte("value"))
+	}))
+	received := make(chan int, 10)
+	listen := func(s *Server) {
+		s.RegisterProcessorFunc(partitionTestMsgID, func(env *network.Envelope) error {
+			received <- env.Msg.(*partitionTestMsg).From
+			return nil
+		})
+	}
+	listen(servers[1])
+	_, err := servers[0].Send(servers[1].ServerIdentity, &partitionTestMsg{0})
+	require.NoError(t, err)
+	require.Equal(t, 0, <-received)
+
+	_, err = local.Restart(2)
+	require.Error(t, err)
+	old := servers[1]
+	restarted, err := local.Restart(1)
+	require.NoError(t, err)
+	require.NotEqual(t, old, restarted)
+	require.Equal(t, old.ServerIdentity, restarted.ServerIdentity)
+	require.True(t, old.private.Equal(restarted.private))
+	require.Equal(t, restarted, local.Servers[old.ServerIdentity.ID])
+	require.Equal(t, restarted.overlay, local.Overlays[old.ServerIdentity.ID])
+
+	// the database survived
+	require.NoError(t, restarted.serviceManager.storage.View(func(tx StorageTx) error {
+		require.Equal(t, []byte("value"), getValue(t, tx, bucket, key))
+		return nil
+	}))
+
+	// the other servers still reach it
+	listen(restarted)
+	for i := 0; ; i++ {
+		_, err = servers[0].Send(restarted.ServerIdentity, &partitionTestMsg{0})
+		if err == nil {
+			break
+		}
+		require.True(t, i < 100, "couldn't reach the restarted server: %v", err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case got := <-received:
+		require.Equal(t, 0, got)
+	case <-time.After(time.Second):
+		require.Fail(t, "no message after the restart")
+	}
+
+	// it can be restarted again
+	_, err = local.Restart(1)
+	require.NoError(t, err)
+}
@@ -0,0 +1,136 @@
+package onet
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// simulationRound tells a server that the round starts.
+type simulationRound struct {
+	Round int
+}
+
+// simulationRoundDone is the reply to simulationRound, once the server is
+// ready for the round.
+type simulationRoundDone struct {
+	Round int
+}
+
+func (d *simulationRoundDone) reply() (int, error) {
+	return d.Round, nil
+}
+
+var simulationRoundID = network.RegisterMessage(simulationRound{})
+var simulationRoundDoneID = network.RegisterMessage(simulationRoundDone{})
+
+// how long StartRound waits for the servers
+const roundTimeout = time.Minute
+
+// simulationRounds holds what the server of a simulation does when a round
+// starts.
+type simulationRounds struct {
+	handler func(round int)
+	// receives the replies of the servers to StartRound
+	done chan roundReply
+	sync.Mutex
+}
+
+// roundReply is the reply of a server to a message of the root for a round,
+// with the error of the server if it failed.
+type roundReply interface {
+	reply() (round int, err error)
+}
+
+// sendRound sends the message of the round to all the servers, and waits
+// for all of them to reply on replies, until the timeout. It is what the
+// root does for StartRound and Checkpoint.
+func (sc *SimulationConfig) sendRound(round int, msg network.Message, replies <-chan roundReply,
+	timeout time.Duration) error {
+	for _, si := range sc.Roster.List {
+		if _, err := sc.Server.Send(si, msg); err != nil {
+			return xerrors.Errorf("sending: %v", err)
+		}
+	}
+	expired := sc.Server.Clock().After(timeout)
+	for n := 0; n < len(sc.Roster.List); {
+		select {
+		case r := <-replies:
+			replied, err := r.reply()
+			if replied != round {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		case <-expired:
+			return xerrors.New("timed out")
+		}
+	}
+	return nil
+}
+
+// registerRound makes the server answer the messages of the root of type
+// request with what answer returns, and gives the replies to the root on
+// replies, which drops them if no one waits for them.
+func (sc *SimulationConfig) registerRound(request, reply network.MessageTypeID,
+	answer func(network.Message) network.Message, replies chan roundReply) {
+	server := sc.Server
+	server.RegisterProcessorFunc(request, func(env *network.Envelope) error {
+		_, err := server.Send(env.ServerIdentity, answer(env.Msg))
+		return err
+	})
+	server.RegisterProcessorFunc(reply, func(env *network.Envelope) error {
+		select {
+		case replies <- env.Msg.(roundReply):
+		default:
+		}
+		return nil
+	})
+}
+
+// StartRound tells all the servers that the round starts, and waits for
+// them to be ready. It is called by Run before every round, for the options
+// of the simulation working by rounds, like the profiling.
+func (sc *SimulationConfig) StartRound(round int) error {
+	if sc.rounds == nil {
+		return xerrors.New("the simulation has no server")
+	}
+	err := sc.sendRound(round, &simulationRound{round}, sc.rounds.done, roundTimeout)
+	if err != nil {
+		return xerrors.Errorf("starting the round: %v", err)
+	}
+	return nil
+}
+
+// SetRoundHandler sets the function called on the server of the
+// configuration when the root starts a round, before it replies.
+func (sc *SimulationConfig) SetRoundHandler(f func(round int)) {
+	if sc.rounds == nil {
+		return
+	}
+	sc.rounds.Lock()
+	defer sc.rounds.Unlock()
+	sc.rounds.handler = f
+}
+
+// registerRounds makes the server of the configuration reply to
+// StartRound.
+func (sc *SimulationConfig) registerRounds() {
+	sc.rounds = &simulationRounds{
+		done: make(chan roundReply, len(sc.Roster.List)),
+	}
+	sc.registerRound(simulationRoundID, simulationRoundDoneID, func(msg network.Message) network.Message {
+		round := msg.(*simulationRound).Round
+		sc.rounds.Lock()
+		handler := sc.rounds.handler
+		sc.rounds.Unlock()
+		if handler != nil {
+			handler(round)
+		}
+		return &simulationRoundDone{round}
+	}, sc.rounds.done)
+}
@@ -0,0 +1,44 @@
+package onet
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulationConfig_StartRound(t *testing.T) {
+	require.Error(t, (&SimulationConfig{}).StartRound(0))
+
+	sc, _, err := createBFTree(3, 2, false, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "round")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, sc.Save(dir))
+	scs, err := LoadSimulationConfig("Ed25519", dir, "127.0.0.1")
+	require.NoError(t, err)
+	defer closeAll(scs)
+
+	var lock sync.Mutex
+	rounds := make(map[int][]int)
+	for i, s := range scs {
+		i := i
+		s.SetRoundHandler(func(round int) {
+			lock.Lock()
+			rounds[round] = append(rounds[round], i)
+			lock.Unlock()
+		})
+		s.Server.StartInBackground()
+	}
+
+	require.NoError(t, scs[0].StartRound(2))
+	require.NoError(t, scs[0].StartRound(3))
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, 2, len(rounds))
+	require.ElementsMatch(t, []int{0, 1, 2}, rounds[2])
+	require.ElementsMatch(t, []int{0, 1, 2}, rounds[3])
+}
@@ -0,0 +1,321 @@
+package onet
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// number of times a request is sent during the timeout of a Call
+const rpcTransmissions = 3
+
+// how long the answer to a request is kept to answer retransmissions
+const rpcResponseCacheTime = time.Minute
+
+// RPCRequestMsgID of RPCRequest message as registered in network
+var RPCRequestMsgID = network.RegisterMessage(RPCRequest{})
+
+// RPCResponseMsgID of RPCResponse message as registered in network
+var RPCResponseMsgID = network.RegisterMessage(RPCResponse{})
+
+// RPCRequest is sent by ServiceProcessor.Call to the same service on another
+// server.
+type RPCRequest struct {
+	ID      uuid.UUID
+	Service string
+	// Method is the name of the structure of the request, without its
+	// package.
+	Method  string
+	Payload []byte
+}
+
+// RPCResponse is the answer to an RPCRequest with the same ID. If the
+// handler failed, Error is set and Payload is empty.
+type RPCResponse struct {
+	ID      uuid.UUID
+	Payload []byte
+	Error   string
+}
+
+// rpcProcessor is implemented by ServiceProcessor, so that every service
+// embedding it can answer RPCRequests.
+type rpcProcessor interface {
+	processRPC(si *network.ServerIdentity, method string, payload []byte) ([]byte, error)
+}
+
+// rpcKey identifies a request: the IDs are chosen by the senders, so that
+// one sender can't answer the requests of another with its own.
+type rpcKey struct {
+	from network.ServerIdentityID
+	id   uuid.UUID
+}
+
+// rpcAnswered says when a request was answered, to remove its answer from
+// the cache once rpcResponseCacheTime has passed.
+type rpcAnswered struct {
+	key rpcKey
+	at  time.Time
+}
+
+// rpcDispatcher matches the RPCResponses to the running calls and gives the
+// RPCRequests to the services of the server.
+type rpcDispatcher struct {
+	server *Server
+
+	pending map[uuid.UUID]chan *RPCResponse
+	// answers of the requests being processed (nil) or processed recently
+	answered map[rpcKey]*RPCResponse
+	// the requests processed, by the time of their answer
+	answeredOrder []rpcAnswered
+	sync.Mutex
+
+	wg     sync.WaitGroup
+	closed bool
+}
+
+func newRPCDispatcher(s *Server) *rpcDispatcher {
+	r := &rpcDispatcher{
+		server:   s,
+		pending:  make(map[uuid.UUID]chan *RPCResponse),
+		answered: make(map[rpcKey]*RPCResponse),
+	}
+	s.RegisterProcessor(r, RPCRequestMsgID, RPCResponseMsgID)
+	return r
+}
+
+// Process implements the network.Processor interface.
+func (r *rpcDispatcher) Process(env *network.Envelope) {
+	switch msg := env.Msg.(type) {
+	case *RPCRequest:
+		r.handleRequest(env.ServerIdentity, msg)
+	case *RPCResponse:
+		r.Lock()
+		c, ok := r.pending[msg.ID]
+		delete(r.pending, msg.ID)
+		r.Unlock()
+		if ok {
+			c <- msg
+		}
+	default:
+		log.Error("rpc: unknown message type", env.MsgType)
+	}
+}
+
+// handleRequest calls the handler in a new go-routine, so that handlers can
+// make calls themselves without blocking the connection.
+func (r *rpcDispatcher) handleRequest(si *network.ServerIdentity, req *RPCRequest) {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	now := r.server.Clock().Now()
+	for len(r.answeredOrder) > 0 && now.Sub(r.answeredOrder[0].at) > rpcResponseCacheTime {
+		delete(r.answered, r.answeredOrder[0].key)
+		r.answeredOrder = r.answeredOrder[1:]
+	}
+	key := rpcKey{si.ID, req.ID}
+	if resp, ok := r.answered[key]; ok {
+		r.Unlock()
+		// Retransmission: answer again if we're done, else the answer
+		// will come once the handler returns.
+		if resp != nil {
+			r.reply(si, resp)
+		}
+		return
+	}
+	r.answered[key] = nil
+	r.wg.Add(1)
+	r.Unlock()
+
+	go func() {
+		defer r.wg.Done()
+		resp := &RPCResponse{ID: req.ID}
+		buf, err := func() ([]byte, error) {
+			if !r.server.authz.allowMethod(r.server, si, req.Service, req.Method) {
+				return nil, xerrors.New("not authorized")
+			}
+			svc, ok := r.server.serviceManager.service(req.Service).(rpcProcessor)
+			if !ok {
+				return nil, xerrors.New("unknown service or service without ServiceProcessor: " + req.Service)
+			}
+			return svc.processRPC(si, req.Method, req.Payload)
+		}()
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Payload = buf
+		}
+		r.Lock()
+		r.answered[key] = resp
+		r.answeredOrder = append(r.answeredOrder, rpcAnswered{key, r.server.Clock().Now()})
+		r.Unlock()
+		r.reply(si, resp)
+	}()
+}
+
+func (r *rpcDispatcher) reply(si *network.ServerIdentity, resp *RPCResponse) {
+	if _, err := r.server.Send(si, resp); err != nil {
+		log.Lvl2("rpc: couldn't send response to", si, ":", err)
+	}
+}
+
+// call sends the request and resends it until it gets the response or the
+// timeout expires.
+func (r *rpcDispatcher) call(si *network.ServerIdentity, req *RPCRequest, timeout time.Duration) (*RPCResponse, error) {
+	c := make(chan *RPCResponse, 1)
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return nil, xerrors.New("server is closing")
+	}
+	r.pending[req.ID] = c
+	r.Unlock()
+	defer func() {
+		r.Lock()
+		delete(r.pending, req.ID)
+		r.Unlock()
+	}()
+
+	clock := r.server.Clock()
+	deadline := clock.After(timeout)
+	for {
+		if _, err := r.server.Send(si, req); err != nil {
+			log.Lvl3("rpc: sending request to", si, "failed:", err)
+		}
+		select {
+		case resp := <-c:
+			return resp, nil
+		case <-deadline:
+			return nil, xerrors.New("timeout while waiting for the response")
+		case <-clock.After(timeout / rpcTransmissions):
+		}
+	}
+}
+
+// close waits for the running handlers and refuses new requests.
+func (r *rpcDispatcher) close() {
+	r.Lock()
+	r.closed = true
+	r.Unlock()
+	r.wg.Wait()
+}
+
+// RegisterRPCHandler stores a handler that answers the calls made with
+// Call by the same service on other servers. The handler must be of the
+// form:
+// func(si *network.ServerIdentity, msg interface{})(ret interface{}, err error)
+//
+//  * si is the server that made the call.
+//  * msg is a pointer to a structure to the request sent.
+//  * ret is a pointer to a struct of the response.
+//  * err is an error, it can be nil, or any type that implements error.
+//
+// As for RegisterHandler, handlers are identified by the name of the
+// structure of msg, stripped of its package-name.
+func (p *ServiceProcessor) RegisterRPCHandler(f interface{}) error {
+	ft := reflect.TypeOf(f)
+	if ft.Kind() != reflect.Func {
+		return xerrors.New("Input is not a function")
+	}
+	if ft.NumIn() != 2 {
+		return xerrors.New("Need two arguments: *network.ServerIdentity and *struct")
+	}
+	if ft.In(0) != reflect.TypeOf(&network.ServerIdentity{}) {
+		return xerrors.New("1st argument must be a *network.ServerIdentity")
+	}
+	cr := ft.In(1)
+	if cr.Kind() != reflect.Ptr || cr.Elem().Kind() != reflect.Struct {
+		return xerrors.New("2nd argument must be a pointer to a struct")
+	}
+	if ft.NumOut() != 2 {
+		return xerrors.New("Need 2 return values: *struct and error")
+	}
+	if ret := ft.Out(0); ret.Kind() != reflect.Ptr || ret.Elem().Kind() != reflect.Struct {
+		return xerrors.New("1st return value must be a pointer to a struct")
+	}
+	if !ft.Out(1).Implements(errType) {
+		return xerrors.New("2nd return value has to implement error, but is: " + ft.Out(1).String())
+	}
+
+	log.Lvl4("Registering RPC handler", cr.String())
+	p.rpcHandlers[rpcMethodName(cr.Elem())] = serviceHandler{f, cr.Elem(), false, false, nil}
+	return nil
+}
+
+// Call sends req to the same service on the given server and decodes its
+// answer into resp, which must be a pointer to the structure returned by the
+// handler registered with RegisterRPCHandler. The request is resent a few
+// times if no answer comes in, and Call fails once the timeout expired.
+func (p *ServiceProcessor) Call(si *network.ServerIdentity, req, resp interface{}, timeout time.Duration) error {
+	rt := reflect.TypeOf(req)
+	if rt == nil || rt.Kind() != reflect.Ptr || rt.Elem().Kind() != reflect.Struct {
+		return xerrors.New("request must be a pointer to a struct")
+	}
+	buf, err := protobuf.Encode(req)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	rr, err := p.server.rpc.call(si, &RPCRequest{
+		ID:      uuid.NewV4(),
+		Service: ServiceFactory.Name(p.ServiceID()),
+		Method:  rpcMethodName(rt.Elem()),
+		Payload: buf,
+	}, timeout)
+	if err != nil {
+		return xerrors.Errorf("calling %s: %v", si, err)
+	}
+	if rr.Error != "" {
+		return xerrors.Errorf("remote error: %s", rr.Error)
+	}
+	err = network.Decode(rr.Payload, resp, p.server.Suite())
+	if err != nil {
+		return xerrors.Errorf("decoding: %v", err)
+	}
+	return nil
+}
+
+// processRPC implements the rpcProcessor interface.
+func (p *ServiceProcessor) processRPC(si *network.ServerIdentity, method string, payload []byte) ([]byte, error) {
+	mh, ok := p.rpcHandlers[method]
+	if !ok {
+		return nil, xerrors.New("no RPC handler registered for " + method)
+	}
+	msg := reflect.New(mh.msgType)
+	if err := network.Decode(payload, msg.Interface(), p.server.Suite()); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+
+	reply, err := func() (reply interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = xerrors.Errorf("panic with %v", r)
+			}
+		}()
+		ret := reflect.ValueOf(mh.handler).Call([]reflect.Value{reflect.ValueOf(si), msg})
+		if ierr := ret[1].Interface(); ierr != nil {
+			return nil, ierr.(error)
+		}
+		return ret[0].Interface(), nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := protobuf.Encode(reply)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding: %v", err)
+	}
+	return buf, nil
+}
+
+func rpcMethodName(t reflect.Type) string {
+	s := t.String()
+	return s[strings.LastIndex(s, ".")+1:]
+}
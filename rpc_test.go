@@ -0,0 +1,172 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+const rpcServiceName = "rpcService"
+
+type rpcPing struct {
+	Val     int
+	Forward *network.ServerIdentity
+}
+
+type rpcPong struct {
+	Val  int
+	From *network.ServerIdentity
+}
+
+type rpcService struct {
+	*ServiceProcessor
+	sync.Mutex
+	calls int
+}
+
+func (s *rpcService) ping(si *network.ServerIdentity, req *rpcPing) (*rpcPong, error) {
+	s.Lock()
+	s.calls++
+	s.Unlock()
+	if req.Val < 0 {
+		return nil, xerrors.New("negative value")
+	}
+	if req.Forward != nil {
+		// Call back the caller, which must not block its connection.
+		resp := &rpcPong{}
+		err := s.Call(req.Forward, &rpcPing{Val: req.Val + 1}, resp, time.Second)
+		return resp, err
+	}
+	return &rpcPong{Val: req.Val + 1, From: s.ServerIdentity()}, nil
+}
+
+func newRPCService(c *Context) (Service, error) {
+	s := &rpcService{ServiceProcessor: NewServiceProcessor(c)}
+	if err := s.RegisterRPCHandler(s.ping); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func TestServiceProcessor_RegisterRPCHandler(t *testing.T) {
+	p := NewServiceProcessor(nil)
+	require.Error(t, p.RegisterRPCHandler(func(req *rpcPing) (*rpcPong, error) { return nil, nil }))
+	require.Error(t, p.RegisterRPCHandler(func(si *network.ServerIdentity, req rpcPing) (*rpcPong, error) { return nil, nil }))
+	require.Error(t, p.RegisterRPCHandler(func(si *network.ServerIdentity, req *rpcPing) *rpcPong { return nil }))
+	require.NoError(t, p.RegisterRPCHandler(func(si *network.ServerIdentity, req *rpcPing) (*rpcPong, error) { return nil, nil }))
+}
+
+func TestServiceProcessor_Call(t *testing.T) {
+	sid, err := RegisterNewService(rpcServiceName, newRPCService)
+	require.NoError(t, err)
+	defer UnregisterService(rpcServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	services := local.GetServices(servers, sid)
+	s0 := services[0].(*rpcService)
+
+	resp := &rpcPong{}
+	require.NoError(t, s0.Call(servers[1].ServerIdentity, &rpcPing{Val: 1}, resp, time.Second))
+	require.Equal(t, 2, resp.Val)
+	require.True(t, resp.From.Equal(servers[1].ServerIdentity))
+
+	err = s0.Call(servers[1].ServerIdentity, &rpcPing{Val: -1}, resp, time.Second)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "negative value")
+
+	require.Error(t, s0.Call(servers[1].ServerIdentity, &rpcPong{}, resp, time.Second))
+
+	resp = &rpcPong{}
+	require.NoError(t, s0.Call(servers[1].ServerIdentity,
+		&rpcPing{Val: 10, Forward: servers[0].ServerIdentity}, resp, 2*time.Second))
+	require.Equal(t, 12, resp.Val)
+	require.True(t, resp.From.Equal(servers[0].ServerIdentity))
+
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	start := time.Now()
+	require.Error(t, s0.Call(dead, &rpcPing{}, resp, 300*time.Millisecond))
+	require.True(t, time.Since(start) >= 300*time.Millisecond)
+}
+
+func TestServiceProcessor_CallRetransmission(t *testing.T) {
+	sid, err := RegisterNewService(rpcServiceName, newRPCService)
+	require.NoError(t, err)
+	defer UnregisterService(rpcServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(3)
+	s1 := local.GetServices(servers, sid)[1].(*rpcService)
+	vc := NewVirtualClock(time.Now())
+	servers[1].SetClock(vc)
+	calls := func() int {
+		s1.Lock()
+		defer s1.Unlock()
+		return s1.calls
+	}
+
+	// The same request received twice is only handled once, and answered
+	// twice.
+	req := &RPCRequest{ID: uuid.NewV4(), Service: rpcServiceName, Method: "rpcPing"}
+	for i := 0; i < 2; i++ {
+		_, err := servers[0].rpc.call(servers[1].ServerIdentity, req, time.Second)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, calls())
+
+	// Another sender using the same ID gets its own answer.
+	_, err = servers[2].rpc.call(servers[1].ServerIdentity, req, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls())
+
+	// The answers are forgotten after rpcResponseCacheTime.
+	vc.Advance(rpcResponseCacheTime + time.Second)
+	_, err = servers[0].rpc.call(servers[1].ServerIdentity, req, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls())
+	servers[1].rpc.Lock()
+	require.Equal(t, 1, len(servers[1].rpc.answeredOrder))
+	servers[1].rpc.Unlock()
+}
+
+func TestServiceProcessor_CallClock(t *testing.T) {
+	sid, err := RegisterNewService(rpcServiceName, newRPCService)
+	require.NoError(t, err)
+	defer UnregisterService(rpcServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	s0 := local.GetServices(servers, sid)[0].(*rpcService)
+	vc := NewVirtualClock(time.Now())
+	servers[0].SetClock(vc)
+
+	// The timeout only expires when the clock of the server is advanced.
+	_, dead := NewPrivIdentity(tSuite, 9999)
+	done := make(chan error, 1)
+	go func() {
+		done <- s0.Call(dead, &rpcPing{}, &rpcPong{}, time.Hour)
+	}()
+	for vc.Timers() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		require.Fail(t, "call returned before the timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+	for i := 0; i < 2*rpcTransmissions; i++ {
+		vc.Advance(time.Hour / rpcTransmissions)
+		select {
+		case err := <-done:
+			require.Error(t, err)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	require.Fail(t, "call didn't time out with the clock")
+}
@@ -0,0 +1,270 @@
+package onet
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// Schedule returns when a task has to run next.
+type Schedule interface {
+	// Next returns the first time after t the task has to run, or the zero
+	// time if it mustn't run anymore.
+	Next(t time.Time) time.Time
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// Every returns a Schedule running a task every interval, counted from the
+// end of the previous run.
+func Every(interval time.Duration) Schedule {
+	return everySchedule(interval)
+}
+
+type jitterSchedule struct {
+	Schedule
+	max time.Duration
+}
+
+func (s jitterSchedule) Next(t time.Time) time.Time {
+	next := s.Schedule.Next(t)
+	if next.IsZero() || s.max <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(s.max))))
+}
+
+// WithJitter delays every run of s by a random duration up to max, so that
+// the servers of a roster don't all run the same task at the same time.
+func WithJitter(s Schedule, max time.Duration) Schedule {
+	return jitterSchedule{s, max}
+}
+
+// cronSchedule holds one bit per allowed value of each field.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+}
+
+// ParseCron parses a schedule in the standard cron format with five fields:
+// minute, hour, day of month, month and day of week. Each field can be a
+// '*', a number, a range 'a-b', a list 'a,b' and have a step '/n'. As in
+// cron, if both the day of month and the day of week are restricted, the
+// task runs on the days matching either of them.
+func ParseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, xerrors.Errorf("need 5 fields, got %d", len(fields))
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var bits [5]uint64
+	for i, f := range fields {
+		b, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, xerrors.Errorf("field %d: %v", i+1, err)
+		}
+		bits[i] = b
+	}
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{bits[0], bits[1], bits[2], bits[3], bits[4]}, nil
+}
+
+func parseCronField(f string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, xerrors.New("invalid step: " + part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			if i := strings.Index(part, "-"); i >= 0 {
+				lo, err = strconv.Atoi(part[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(part[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(part)
+				hi = lo
+			}
+			if err != nil {
+				return 0, xerrors.New("invalid value: " + part)
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, xerrors.Errorf("%s out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	allDom := s.dom&0xfffffffe == 0xfffffffe
+	allDow := s.dow&0x7f == 0x7f
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if allDom || allDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// if nothing matches in five years, nothing ever will
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type scheduledTask struct {
+	name     string
+	schedule Schedule
+	f        func()
+	stop     chan struct{}
+}
+
+// scheduler runs the background tasks of the services of a server.
+type scheduler struct {
+	tasks map[string]*scheduledTask
+	// the clock of the server
+	clock func() Clock
+	sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+func newScheduler(clock func() Clock) *scheduler {
+	return &scheduler{tasks: make(map[string]*scheduledTask), clock: clock}
+}
+
+func (s *scheduler) add(t *scheduledTask) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return xerrors.New("server is closing")
+	}
+	if _, ok := s.tasks[t.name]; ok {
+		return xerrors.New("task already scheduled: " + t.name)
+	}
+	s.tasks[t.name] = t
+	s.wg.Add(1)
+	go s.run(t)
+	return nil
+}
+
+func (s *scheduler) remove(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	t, ok := s.tasks[name]
+	if ok {
+		delete(s.tasks, name)
+		close(t.stop)
+	}
+	return ok
+}
+
+// run waits for the next time of the task and runs it. As the next time is
+// computed once the task returned, runs never overlap: the runs that would
+// have happened meanwhile are skipped.
+func (s *scheduler) run(t *scheduledTask) {
+	defer s.wg.Done()
+	for {
+		clock := s.clock()
+		now := clock.Now()
+		next := t.schedule.Next(now)
+		if next.IsZero() {
+			log.Lvl3("Task", t.name, "has no next run")
+			s.remove(t.name)
+			return
+		}
+		select {
+		case <-t.stop:
+			return
+		case <-clock.After(next.Sub(now)):
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("task %s panicked: %v", t.name, r)
+				}
+			}()
+			t.f()
+		}()
+	}
+}
+
+// close stops all tasks and waits for the running ones to return.
+func (s *scheduler) close() {
+	s.Lock()
+	s.closed = true
+	for name, t := range s.tasks {
+		delete(s.tasks, name)
+		close(t.stop)
+	}
+	s.Unlock()
+	s.wg.Wait()
+}
+
+// Schedule runs f in the background following the schedule s, until
+// Unschedule is called or the server is closed. The runs of a task never
+// overlap, and a panic in f is logged and doesn't stop the task. The name
+// must be unique for the service.
+func (c *Context) Schedule(name string, s Schedule, f func()) error {
+	if s == nil || f == nil {
+		return xerrors.New("need a schedule and a function")
+	}
+	now := c.server.Clock().Now()
+	if next := s.Next(now); !next.IsZero() && !next.After(now) {
+		return xerrors.New("schedule doesn't move forward")
+	}
+	return c.server.scheduler.add(&scheduledTask{
+		name:     c.taskName(name),
+		schedule: s,
+		f:        f,
+		stop:     make(chan struct{}),
+	})
+}
+
+// Unschedule stops the task with the given name. A run that is going on
+// finishes. It returns false if no such task is scheduled.
+func (c *Context) Unschedule(name string) bool {
+	return c.server.scheduler.remove(c.taskName(name))
+}
+
+func (c *Context) taskName(name string) string {
+	return ServiceFactory.Name(c.ServiceID()) + "/" + name
+}
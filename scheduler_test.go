@@ -0,0 +1,92 @@
+package onet
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	loc := time.UTC
+	at := time.Date(2019, 3, 15, 10, 7, 30, 0, loc)
+	for _, c := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, 3, 15, 10, 8, 0, 0, loc)},
+		{"*/15 * * * *", time.Date(2019, 3, 15, 10, 15, 0, 0, loc)},
+		{"0 9-17 * * *", time.Date(2019, 3, 15, 11, 0, 0, 0, loc)},
+		{"30 2 1 * *", time.Date(2019, 4, 1, 2, 30, 0, 0, loc)},
+		// the 15th of March 2019 is a Friday
+		{"0 0 * * 0", time.Date(2019, 3, 17, 0, 0, 0, 0, loc)},
+		{"0 0 * * 7", time.Date(2019, 3, 17, 0, 0, 0, 0, loc)},
+		{"0 0 20 * 1", time.Date(2019, 3, 18, 0, 0, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, loc)},
+	} {
+		s, err := ParseCron(c.spec)
+		require.NoError(t, err, c.spec)
+		require.Equal(t, c.next, s.Next(at), c.spec)
+	}
+
+	// the hours start at the round hours of the zone, not of UTC
+	india := time.FixedZone("IST", 5*3600+1800)
+	s, err := ParseCron("0 9 * * *")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2019, 3, 15, 9, 0, 0, 0, india),
+		s.Next(time.Date(2019, 3, 15, 7, 45, 0, 0, india)))
+
+	s, err = ParseCron("0 0 31 2 *")
+	require.NoError(t, err)
+	require.True(t, s.Next(at).IsZero())
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "a * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := ParseCron(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestContext_Schedule(t *testing.T) {
+	var c *Context
+	RegisterNewService("schedulerService", func(ctx *Context) (Service, error) {
+		c = ctx
+		return &DummyService{c: ctx}, nil
+	})
+	defer UnregisterService("schedulerService")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	local.GenServers(1)
+
+	var runs, running, overlaps, panics int32
+	err := c.Schedule("slow", WithJitter(Every(10*time.Millisecond), 5*time.Millisecond), func() {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	require.NoError(t, err)
+	require.Error(t, c.Schedule("slow", Every(time.Second), func() {}))
+	require.Error(t, c.Schedule("zero", Every(0), func() {}))
+	require.NoError(t, c.Schedule("panic", Every(10*time.Millisecond), func() {
+		atomic.AddInt32(&panics, 1)
+		panic("oops")
+	}))
+
+	for i := 0; i < 100 && (atomic.LoadInt32(&runs) < 3 || atomic.LoadInt32(&panics) < 3); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(t, atomic.LoadInt32(&runs) >= 3)
+	require.True(t, atomic.LoadInt32(&panics) >= 3)
+	require.Equal(t, int32(0), atomic.LoadInt32(&overlaps))
+
+	require.True(t, c.Unschedule("panic"))
+	require.False(t, c.Unschedule("panic"))
+	time.Sleep(20 * time.Millisecond)
+	p := atomic.LoadInt32(&panics)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, p, atomic.LoadInt32(&panics))
+	// closing the server stops the remaining task
+}
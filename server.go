@@ -31,6 +31,54 @@ type Server struct {
 	treesLock            sync.Mutex
 	serviceManager       *serviceManager
 	statusReporterStruct *statusReporterStruct
+	// pubSub disseminates topic messages between services
+	pubSub *pubSub
+	// dht routes requests to the server responsible for a key
+	dht *DHT
+	// rps keeps a random sample of the servers for the gossip protocols
+	rps *PeerSampling
+	// rpc matches the requests and responses of ServiceProcessor.Call
+	rpc *rpcDispatcher
+	// streams holds the open streams between services
+	streams *streamManager
+	// sessions holds the sessions of the clients with the services
+	sessions *sessionManager
+	// cacheInvalidator tells the clients which cached replies are stale
+	cacheInvalidator *cacheInvalidator
+	// the gRPC gateway, if started
+	grpc     *grpcGateway
+	grpcLock sync.Mutex
+	// authz holds which peers may invoke the handlers of the services
+	authz *peerAuthz
+	audit auditLog
+	// the lifecycle phase of the server and its services, and the
+	// lifecycles of the program embedding the server
+	phase      int
+	phaseLock  sync.Mutex
+	lifecycles []ServiceLifecycle
+	// closed once the services have been told to drain
+	drained chan struct{}
+	// closed once the server starts to close, and once it's done
+	closing    chan struct{}
+	closed     chan struct{}
+	closedOnce sync.Once
+	// reloadable configuration of the services
+	config configSections
+	// scheduler runs the background tasks of the services
+	scheduler *scheduler
+	// the time and randomness of the services and protocols
+	clock  serverClock
+	random serverRand
+	// records the messages of the protocol instances, if set
+	trace serverTrace
+	// tokens of the clients that may authenticate on the websocket
+	clientAuth clientAuth
+	// tokens of the operators using the admin API
+	adminAuth adminAuth
+	// limits of the requests of the clients on the websocket
+	clientLimiter *clientLimiter
+	// the byzantine behaviours of the server in the protocols
+	adversaries adversaries
 	// protocols holds a map of all available protocols and how to create an
 	// instance of it
 	protocols *protocolStorage
@@ -56,8 +104,8 @@ func dbPathFromEnv() string {
 // NewServer returns a fresh Server tied to a given Router.
 // If dbPath is "", the server will write its database to the default
 // location. If dbPath is != "", it is considered a temp dir, and the
-// DB is deleted on close.
-func newServer(s network.Suite, dbPath string, r *network.Router, pkey kyber.Scalar) *Server {
+// DB is deleted on close. The storage describes where the database is held.
+func newServer(s network.Suite, dbPath string, storage StorageConfig, r *network.Router, pkey kyber.Scalar) *Server {
 	delDb := false
 	if dbPath == "" {
 		dbPath = dbPathFromEnv()
@@ -65,7 +113,13 @@ func newServer(s network.Suite, dbPath string, r *network.Router, pkey kyber.Sca
 	} else {
 		delDb = true
 	}
+	return newServerWithDb(s, dbPath, delDb, storage, r, pkey, nil)
+}
 
+// newServerWithDb is like newServer, with the database in dbPath, deleted on
+// close if delDb is true, and only the services given, or all of them if
+// services is nil.
+func newServerWithDb(s network.Suite, dbPath string, delDb bool, storage StorageConfig, r *network.Router, pkey kyber.Scalar, services []string) *Server {
 	c := &Server{
 		private:              pkey,
 		statusReporterStruct: newStatusReporterStruct(),
@@ -73,11 +127,41 @@ func newServer(s network.Suite, dbPath string, r *network.Router, pkey kyber.Sca
 		protocols:            newProtocolStorage(),
 		suite:                s,
 		closeitChannel:       make(chan bool),
+		authz:                newPeerAuthz(),
+		closing:              make(chan struct{}),
+		closed:               make(chan struct{}),
+		clientLimiter:        newClientLimiter(),
+		random:               newServerRand(),
 	}
 	c.overlay = NewOverlay(c)
+	c.pubSub = newPubSub(c)
+	c.dht = newDHT(c)
+	c.rps = newPeerSampling(c)
+	c.rpc = newRPCDispatcher(c)
+	c.streams = newStreamManager(c)
+	c.sessions = newSessionManager(c)
+	c.cacheInvalidator = newCacheInvalidator()
+	c.scheduler = newScheduler(c.Clock)
 	c.WebSocket = NewWebSocket(r.ServerIdentity)
-	c.serviceManager = newServiceManager(c, c.overlay, dbPath, delDb)
+	c.WebSocket.rejectClients = c.Draining
+	c.WebSocket.authenticate = c.authenticateClient
+	c.WebSocket.limit = c.clientLimiter.acquire
+	c.WebSocket.openSession = c.sessions.serve
+	c.WebSocket.watchCache = c.cacheInvalidator.serve
+	c.WebSocket.mux.HandleFunc(fmt.Sprintf("/v%d/", GatewayAPIVersion), c.serveGateway)
+	c.WebSocket.mux.HandleFunc("/openapi.json", c.serveOpenAPI)
+	c.WebSocket.mux.HandleFunc("/healthz", c.serveHealthz)
+	c.WebSocket.mux.HandleFunc("/readyz", c.serveReadyz)
+	c.WebSocket.mux.HandleFunc("/status", c.serveStatus)
+	c.WebSocket.mux.HandleFunc("/admin/", c.serveAdmin)
+	if allowBackup() {
+		log.Warn("HTTP backups are enabled")
+		c.WebSocket.mux.HandleFunc("/backup", c.serveBackup)
+	}
+	c.serviceManager = newServiceManager(c, c.overlay, dbPath, storage, delDb, services)
 	c.statusReporterStruct.RegisterStatusReporter("Generic", c)
+	c.statusReporterStruct.RegisterStatusReporter("ClientLimits", c.clientLimiter)
+	c.statusReporterStruct.RegisterStatusReporter("Goroutines", &c.overlay.budget)
 	return c
 }
 
@@ -93,9 +177,16 @@ func NewServerTCP(e *network.ServerIdentity, suite network.Suite) *Server {
 // TcpRouter listening on the given address as Router.
 func NewServerTCPWithListenAddr(e *network.ServerIdentity, suite network.Suite,
 	listenAddr string) *Server {
+	return NewServerTCPWithStorage(e, suite, listenAddr, StorageConfig{})
+}
+
+// NewServerTCPWithStorage is like NewServerTCPWithListenAddr, but the
+// services store their data in the given storage.
+func NewServerTCPWithStorage(e *network.ServerIdentity, suite network.Suite,
+	listenAddr string, storage StorageConfig) *Server {
 	r, err := network.NewTCPRouterWithListenAddr(e, suite, listenAddr)
 	log.ErrFatal(err)
-	return newServer(suite, "", r, e.GetPrivate())
+	return newServer(suite, "", storage, r, e.GetPrivate())
 }
 
 // Suite can (and should) be used to get the underlying Suite.
@@ -126,6 +217,7 @@ func (c *Server) GetStatus() *Status {
 		"Description": c.ServerIdentity.Description,
 		"ConnType":    string(c.ServerIdentity.Address.ConnType()),
 		"GoRoutines":  fmt.Sprintf("%v", runtime.NumGoroutine()),
+		"Registry":    fmt.Sprintf("%x", network.RegistrySchema().Hash()[:8]),
 	}}
 
 	goverOnce.Do(func() {
@@ -153,12 +245,33 @@ func (c *Server) Close() error {
 	}
 	c.Unlock()
 
+	// protocols still running are not waited for, use Shutdown for that
+	c.drain(0)
+	c.scheduler.close()
+	if c.enterPhase(phaseStopped) {
+		c.lifecycle("StopService", func(l ServiceLifecycle) error {
+			return l.StopService()
+		})
+		close(c.closing)
+	}
+	// the services saved their state in StopService
+	c.serviceManager.flushDatabase()
+	c.pubSub.close()
+	c.dht.close()
+	c.rps.close()
+	c.rpc.close()
+	c.streams.close()
+	c.sessions.close()
+	c.cacheInvalidator.close()
+	// the clients first, so that their last requests can still use the
+	// other servers
+	c.stopGRPC()
+	c.WebSocket.stop()
 	err := c.Router.Stop()
 	if err != nil {
 		err = xerrors.Errorf("stopping: %v", err)
 		log.Error("While stopping router:", err)
 	}
-	c.WebSocket.stop()
 	c.overlay.Close()
 	err = c.serviceManager.closeDatabase()
 	if err != nil {
@@ -166,6 +279,7 @@ func (c *Server) Close() error {
 		log.Lvl3("Error closing database: " + err.Error())
 	}
 	log.Lvl3("Host Close", c.ServerIdentity.Address, "listening?", c.Router.Listening())
+	c.closedOnce.Do(func() { close(c.closed) })
 	return err
 }
 
@@ -201,7 +315,13 @@ func (c *Server) protocolInstantiate(protoID ProtocolID, tni *TreeNodeInstance)
 	if !ok {
 		return nil, xerrors.New("No protocol constructor with this ID")
 	}
-	pi, err := fn(tni)
+	var pi ProtocolInstance
+	var err error
+	if a := c.adversary(protoID); a != nil && a.NewProtocol != nil {
+		pi, err = a.NewProtocol(tni, fn)
+	} else {
+		pi, err = fn(tni)
+	}
 	if err != nil {
 		return nil, xerrors.Errorf("creating protocol: %v", err)
 	}
@@ -212,6 +332,11 @@ func (c *Server) protocolInstantiate(protoID ProtocolID, tni *TreeNodeInstance)
 // ports. It returns once all servers are started.
 func (c *Server) Start() {
 	InformServerStarted()
+	if c.enterPhase(phaseStarted) {
+		c.lifecycle("StartService", func(l ServiceLifecycle) error {
+			return l.StartService()
+		})
+	}
 	c.started = time.Now()
 	if !c.Quiet {
 		log.Lvlf1("Starting server at %s on address %s",
@@ -223,11 +348,19 @@ func (c *Server) Start() {
 	for !c.Router.Listening() || !c.WebSocket.Listening() {
 		time.Sleep(50 * time.Millisecond)
 	}
+	if c.enterPhase(phaseReady) {
+		c.lifecycle("Ready", func(l ServiceLifecycle) error {
+			l.Ready()
+			return nil
+		})
+	}
 	c.Lock()
 	c.IsStarted = true
 	c.Unlock()
-	// Wait for closing of the channel
+	// Wait for closing of the channel, and for Close to be done so that
+	// the process can exit
 	<-c.closeitChannel
+	<-c.closed
 }
 
 // StartInBackground starts the services and returns once everything
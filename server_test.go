@@ -34,10 +34,11 @@ func TestServer_GetService(t *testing.T) {
 
 func TestServer_Database(t *testing.T) {
 	c := NewLocalServer(tSuite, 0)
-	require.NotNil(t, c.serviceManager.db)
+	db := c.serviceManager.storage.(*bboltStorage).db
+	require.NotNil(t, db)
 
 	for _, s := range c.serviceManager.availableServices() {
-		c.serviceManager.db.Update(func(tx *bbolt.Tx) error {
+		db.Update(func(tx *bbolt.Tx) error {
 			b := tx.Bucket([]byte(s))
 			require.NotNil(t, b)
 			return nil
@@ -6,9 +6,9 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"strconv"
 	"sync"
 
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/suites"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v4/log"
@@ -295,9 +295,9 @@ type serviceManager struct {
 	servicesMutex sync.Mutex
 	// the onet host
 	server *Server
-	// a bbolt database for all services
-	db     *bbolt.DB
-	dbPath string
+	// the database for all services
+	storage Storage
+	dbPath  string
 	// should the db be deleted on close?
 	delDb bool
 	// the dispatcher can take registration of Processors
@@ -305,7 +305,7 @@ type serviceManager struct {
 }
 
 // newServiceManager will create a serviceStore out of all the registered Service
-func newServiceManager(srv *Server, o *Overlay, dbPath string, delDb bool) *serviceManager {
+func newServiceManager(srv *Server, o *Overlay, dbPath string, storage StorageConfig, delDb bool, enabled []string) *serviceManager {
 	services := make(map[ServiceID]Service)
 	s := &serviceManager{
 		services:   services,
@@ -317,23 +317,37 @@ func newServiceManager(srv *Server, o *Overlay, dbPath string, delDb bool) *serv
 
 	s.updateDbFileName()
 
-	db, err := openDb(s.dbFileName())
+	st, err := openStorage(storage, s.dbFileName())
 	if err != nil {
 		log.Panic("Failed to create new database: " + err.Error())
 	}
-	s.db = db
+	s.storage = st
 
 	for name, inst := range protocols.instantiators {
 		log.Lvl4("Registering global protocol", name)
 		srv.ProtocolRegister(name, inst)
 	}
 
+	var only map[string]bool
+	if enabled != nil {
+		only = make(map[string]bool)
+		for _, name := range enabled {
+			only[name] = true
+		}
+	}
 	ids := ServiceFactory.registeredServiceIDs()
 	for _, id := range ids {
 		name := ServiceFactory.Name(id)
+		if only != nil && !only[name] {
+			log.Lvl3("Service not enabled", name)
+			continue
+		}
 		log.Lvl3("Starting service", name)
 
 		cont := newContext(srv, o, id, s)
+		if err := cont.migrate(); err != nil {
+			log.Fatalf("Migrating the database of service %v: %v", name, err)
+		}
 
 		srvc, err := ServiceFactory.start(name, cont)
 		if err != nil {
@@ -366,10 +380,17 @@ func (s *serviceManager) dbFileNameOld() string {
 }
 
 func (s *serviceManager) dbFileName() string {
-	pub, _ := s.server.ServerIdentity.Public.MarshalBinary()
+	return DatabaseFile(s.dbPath, s.server.ServerIdentity.Public)
+}
+
+// DatabaseFile returns the file of the database of the services of the
+// conode with the public key, in the directory, like the one of
+// CONODE_SERVICE_PATH. It changes with the key of the conode.
+func DatabaseFile(dir string, public kyber.Point) string {
+	pub, _ := public.MarshalBinary()
 	h := sha256.New()
 	h.Write(pub)
-	return path.Join(s.dbPath, fmt.Sprintf("%x.db", h.Sum(nil)))
+	return path.Join(dir, fmt.Sprintf("%x.db", h.Sum(nil)))
 }
 
 // updateDbFileName checks if the old database file name exists, if it does, it
@@ -387,22 +408,35 @@ func (s *serviceManager) updateDbFileName() {
 // Process implements the Processor interface: service manager will relay
 // messages to the right Service.
 func (s *serviceManager) Process(env *network.Envelope) {
+	if !s.server.authz.allowMessage(s.server, env.ServerIdentity, env.MsgType) {
+		return
+	}
 	// will launch a go routine for that message
 	s.Dispatch(env)
 }
 
+// flushDatabase writes the changes kept in memory by the storage to disk.
+func (s *serviceManager) flushDatabase() {
+	if sy, ok := s.storage.(Syncer); ok {
+		if err := sy.Sync(); err != nil {
+			log.Error("Flushing database failed with: " + err.Error())
+		}
+	}
+}
+
 // closeDatabase closes the database.
 // It also removes the database file if the path is not default (i.e. testing config)
 func (s *serviceManager) closeDatabase() error {
-	if s.db != nil {
-		err := s.db.Close()
+	if s.storage != nil {
+		err := s.storage.Close()
 		if err != nil {
 			log.Error("Close database failed with: " + err.Error())
 		}
 	}
 
 	if s.delDb {
-		err := os.Remove(s.dbFileName())
+		// backends other than bbolt might use a directory, or nothing at all
+		err := os.RemoveAll(s.dbFileName())
 		if err != nil {
 			return xerrors.Errorf("removing file: %v", err)
 		}
@@ -412,31 +446,13 @@ func (s *serviceManager) closeDatabase() error {
 
 // GetStatus is a function that returns the status report of the server.
 func (s *serviceManager) GetStatus() *Status {
-	if s.db == nil {
+	if s.storage == nil {
 		return &Status{Field: map[string]string{"Open": "false"}}
 	}
-	st := s.db.Stats()
-	return &Status{Field: map[string]string{
-		"Open":             "true",
-		"FreePageN":        strconv.Itoa(st.FreePageN),
-		"PendingPageN":     strconv.Itoa(st.PendingPageN),
-		"FreeAlloc":        strconv.Itoa(st.FreeAlloc),
-		"FreelistInuse":    strconv.Itoa(st.FreelistInuse),
-		"TxN":              strconv.Itoa(st.TxN),
-		"OpenTxN":          strconv.Itoa(st.OpenTxN),
-		"Tx.PageCount":     strconv.Itoa(st.TxStats.PageCount),
-		"Tx.PageAlloc":     strconv.Itoa(st.TxStats.PageAlloc),
-		"Tx.CursorCount":   strconv.Itoa(st.TxStats.CursorCount),
-		"Tx.NodeCount":     strconv.Itoa(st.TxStats.NodeCount),
-		"Tx.NodeDeref":     strconv.Itoa(st.TxStats.NodeDeref),
-		"Tx.Rebalance":     strconv.Itoa(st.TxStats.Rebalance),
-		"Tx.RebalanceTime": st.TxStats.RebalanceTime.String(),
-		"Tx.Split":         strconv.Itoa(st.TxStats.Split),
-		"Tx.Spill":         strconv.Itoa(st.TxStats.Spill),
-		"Tx.SpillTime":     st.TxStats.SpillTime.String(),
-		"Tx.Write":         strconv.Itoa(st.TxStats.Write),
-		"Tx.WriteTime":     st.TxStats.WriteTime.String(),
-	}}
+	if sr, ok := s.storage.(StatusReporter); ok {
+		return sr.GetStatus()
+	}
+	return &Status{Field: map[string]string{"Open": "true"}}
 }
 
 // registerProcessor the processor to the service manager and tells the host to dispatch
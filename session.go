@@ -0,0 +1,605 @@
+package onet
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// SessionMaxUnacked is the number of messages one side of a session can send
+// before the other side acknowledged them.
+const SessionMaxUnacked = 1024
+
+// how long a session waits for its client to come back before it is closed
+const defaultSessionResumeTimeout = 30 * time.Second
+
+// a frame only acknowledging is sent after this number of messages received
+// without sending any
+const sessionAckInterval = 16
+
+// how long the hello and the welcome of a session can take
+const sessionHelloTimeout = 10 * time.Second
+
+const sessionWriteTimeout = time.Minute
+
+// how often a client tries to reconnect a broken session
+const sessionReconnectAttempts = 5
+
+// SessionHello is the first message of a client on the websocket of a
+// session. An empty ID opens a new session, else the session is resumed.
+type SessionHello struct {
+	ID uuid.UUID
+	// Received is the number of messages the client received.
+	Received uint64
+}
+
+// SessionWelcome is the reply of the server to SessionHello.
+type SessionWelcome struct {
+	ID uuid.UUID
+	// Received is the number of messages the server received.
+	Received uint64
+}
+
+// SessionFrame carries a message of a session. The frames are numbered from
+// 1, and a frame with Seq 0 only acknowledges the frames of the other side.
+type SessionFrame struct {
+	Seq uint64
+	// Ack is the number of frames received from the other side.
+	Ack  uint64
+	Data []byte
+}
+
+// sessionPeer is one side of a session. It keeps the messages it sent until
+// the other side acknowledged them, so they can be sent again on a new
+// connection.
+type sessionPeer struct {
+	id    uuid.UUID
+	suite network.Suite
+
+	sync.Mutex
+	conn     *websocket.Conn
+	sent     []*SessionFrame
+	nextSeq  uint64
+	received uint64
+	// the last acknowledgement sent
+	acked uint64
+	in    chan network.Message
+	done  chan struct{}
+	err   error
+}
+
+func newSessionPeer(id uuid.UUID, suite network.Suite) *sessionPeer {
+	return &sessionPeer{
+		id:    id,
+		suite: suite,
+		in:    make(chan network.Message, sessionAckInterval),
+		done:  make(chan struct{}),
+	}
+}
+
+// ID returns the identifier of the session.
+func (p *sessionPeer) ID() uuid.UUID {
+	p.Lock()
+	defer p.Unlock()
+	return p.id
+}
+
+// Done is closed once the session is closed.
+func (p *sessionPeer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Send sends the message to the other side. The message must be registered
+// with network.RegisterMessage. It doesn't wait for the message to arrive,
+// but the message is sent again if the connection breaks before.
+func (p *sessionPeer) Send(msg interface{}) error {
+	data, err := network.Marshal(msg)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if len(p.sent) >= SessionMaxUnacked {
+		return xerrors.New("too many messages not acknowledged")
+	}
+	p.nextSeq++
+	f := &SessionFrame{Seq: p.nextSeq, Ack: p.received, Data: data}
+	p.sent = append(p.sent, f)
+	p.acked = p.received
+	p.writeLocked(f)
+	return nil
+}
+
+// Receive returns the next message of the other side. It returns io.EOF
+// once the session is closed.
+func (p *sessionPeer) Receive() (network.Message, error) {
+	select {
+	case m := <-p.in:
+		return m, nil
+	default:
+	}
+	select {
+	case m := <-p.in:
+		return m, nil
+	case <-p.done:
+		p.Lock()
+		defer p.Unlock()
+		return nil, p.err
+	}
+}
+
+// writeLocked sends the frame on the current connection. A failure is only
+// logged, as the frame is sent again on the next connection.
+func (p *sessionPeer) writeLocked(f *SessionFrame) {
+	if p.conn == nil {
+		return
+	}
+	buf, err := protobuf.Encode(f)
+	if err != nil {
+		log.Error("encoding frame:", err)
+		return
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+	if err := p.conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+		log.Lvl3("session write:", err)
+	}
+}
+
+// ackLocked forgets the frames the other side received.
+func (p *sessionPeer) ackLocked(n uint64) {
+	i := 0
+	for i < len(p.sent) && p.sent[i].Seq <= n {
+		i++
+	}
+	p.sent = p.sent[i:]
+}
+
+// resumeLocked continues the session on conn, sending the frames the other
+// side didn't receive.
+func (p *sessionPeer) resumeLocked(conn *websocket.Conn, received uint64) {
+	p.conn = conn
+	p.ackLocked(received)
+	for _, f := range p.sent {
+		f.Ack = p.received
+		p.writeLocked(f)
+	}
+	p.acked = p.received
+}
+
+// handleFrame processes a frame of the other side. Frames that have already
+// been received on a previous connection are dropped.
+func (p *sessionPeer) handleFrame(buf []byte) error {
+	f := &SessionFrame{}
+	if err := protobuf.Decode(buf, f); err != nil {
+		return xerrors.Errorf("decoding frame: %v", err)
+	}
+	p.Lock()
+	p.ackLocked(f.Ack)
+	if f.Seq == 0 || f.Seq <= p.received {
+		p.Unlock()
+		return nil
+	}
+	if f.Seq != p.received+1 {
+		p.Unlock()
+		return xerrors.Errorf("missing frames before %d", f.Seq)
+	}
+	p.received = f.Seq
+	if p.received-p.acked >= sessionAckInterval {
+		p.acked = p.received
+		p.writeLocked(&SessionFrame{Ack: p.received})
+	}
+	p.Unlock()
+
+	_, msg, err := network.Unmarshal(f.Data, p.suite)
+	if err != nil {
+		return xerrors.Errorf("decoding message: %v", err)
+	}
+	select {
+	case p.in <- msg:
+	case <-p.done:
+	}
+	return nil
+}
+
+// closeLocked ends the session with err, which is io.EOF for a normal end,
+// and tells the other side.
+func (p *sessionPeer) closeLocked(err error) {
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	close(p.done)
+	if p.conn != nil {
+		code, text := websocket.CloseNormalClosure, ""
+		if err != io.EOF {
+			code, text = websocket.CloseInternalServerErr, err.Error()
+		}
+		p.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, text),
+			time.Now().Add(time.Millisecond*500))
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// SessionHandler is called in a new go-routine for every session a client
+// opens with Client.OpenSession. The session is closed once the handler
+// returns, and the error, if any, is given to the client.
+type SessionHandler func(s *ClientSession) error
+
+// ClientSession is the side of the service of a session with a client. If
+// the connection breaks, the session waits for the client to reconnect and
+// the messages that were not received on either side are sent again.
+type ClientSession struct {
+	*sessionPeer
+	mgr    *sessionManager
+	client *ClientIdentity
+	expire *time.Timer
+}
+
+// Client returns the identity of the client if it authenticated when it
+// opened the session, or nil.
+func (s *ClientSession) Client() *ClientIdentity {
+	return s.client
+}
+
+func (s *ClientSession) close(err error) {
+	s.Lock()
+	s.closeLocked(err)
+	if s.expire != nil {
+		s.expire.Stop()
+	}
+	s.Unlock()
+	s.mgr.remove(s)
+}
+
+// detach is called when the connection breaks, and closes the session if
+// the client doesn't come back in time.
+func (s *ClientSession) detach(conn *websocket.Conn) {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn != conn || s.err != nil {
+		return
+	}
+	s.conn = nil
+	s.expire = time.AfterFunc(s.mgr.resumeTimeout, func() {
+		s.close(xerrors.New("session expired"))
+	})
+}
+
+// sessionManager keeps the handlers of the services and the open sessions.
+type sessionManager struct {
+	server        *Server
+	handlers      map[string]SessionHandler
+	sessions      map[uuid.UUID]*ClientSession
+	resumeTimeout time.Duration
+	sync.Mutex
+
+	wg     sync.WaitGroup
+	closed bool
+}
+
+func newSessionManager(s *Server) *sessionManager {
+	return &sessionManager{
+		server:        s,
+		handlers:      make(map[string]SessionHandler),
+		sessions:      make(map[uuid.UUID]*ClientSession),
+		resumeTimeout: defaultSessionResumeTimeout,
+	}
+}
+
+// AcceptSessions registers the handler of the sessions the clients open
+// with Client.OpenSession under the given name.
+func (c *Context) AcceptSessions(name string, h SessionHandler) {
+	m := c.server.sessions
+	m.Lock()
+	m.handlers[ServiceFactory.Name(c.serviceID)+"/"+name] = h
+	m.Unlock()
+}
+
+// serve takes over the connection if the path is the one of a session
+// handler of the service, and returns false otherwise.
+func (m *sessionManager) serve(service, path string, r *http.Request, ws *websocket.Conn) bool {
+	m.Lock()
+	h, ok := m.handlers[service+"/"+path]
+	m.Unlock()
+	if !ok {
+		return false
+	}
+	if err := m.serveConn(h, r, ws); err != nil {
+		log.Warnf("session of %s: %v", r.RemoteAddr, err)
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseProtocolError, err.Error()),
+			time.Now().Add(time.Millisecond*500))
+	}
+	return true
+}
+
+func (m *sessionManager) serveConn(h SessionHandler, r *http.Request, ws *websocket.Conn) error {
+	ws.SetReadDeadline(time.Now().Add(sessionHelloTimeout))
+	_, buf, err := ws.ReadMessage()
+	if err != nil {
+		return xerrors.Errorf("reading hello: %v", err)
+	}
+	ws.SetReadDeadline(time.Time{})
+	hello := &SessionHello{}
+	if err := protobuf.Decode(buf, hello); err != nil {
+		return xerrors.Errorf("decoding hello: %v", err)
+	}
+	s, err := m.session(h, r, hello.ID)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	if s.err != nil {
+		s.Unlock()
+		return xerrors.New("session closed")
+	}
+	if s.conn != nil {
+		// the client didn't notice yet that it was disconnected
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+		s.expire = nil
+	}
+	buf, err = protobuf.Encode(&SessionWelcome{ID: s.id, Received: s.received})
+	if err == nil {
+		ws.SetWriteDeadline(time.Now().Add(sessionHelloTimeout))
+		err = ws.WriteMessage(websocket.BinaryMessage, buf)
+	}
+	if err != nil {
+		s.Unlock()
+		s.detach(nil)
+		return xerrors.Errorf("sending welcome: %v", err)
+	}
+	s.resumeLocked(ws, hello.Received)
+	s.Unlock()
+
+	for {
+		_, buf, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				// the client closed the session
+				s.close(io.EOF)
+			} else {
+				s.detach(ws)
+			}
+			return nil
+		}
+		if err := s.handleFrame(buf); err != nil {
+			s.close(err)
+			return nil
+		}
+	}
+}
+
+// session returns the session to resume, or a new one if id is empty.
+func (m *sessionManager) session(h SessionHandler, r *http.Request, id uuid.UUID) (*ClientSession, error) {
+	client := ClientIdentityFromRequest(r)
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil, xerrors.New("server is closing")
+	}
+	if id != uuid.Nil {
+		s, ok := m.sessions[id]
+		if !ok || !sameClient(s.client, client) {
+			return nil, xerrors.New("unknown session")
+		}
+		return s, nil
+	}
+
+	s := &ClientSession{
+		sessionPeer: newSessionPeer(uuid.NewV4(), m.server.Suite()),
+		mgr:         m,
+		client:      client,
+	}
+	m.sessions[s.id] = s
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := h(s)
+		if err == nil {
+			err = io.EOF
+		}
+		s.close(err)
+	}()
+	return s, nil
+}
+
+// sameClient makes sure only the client that opened a session can resume it.
+func sameClient(a, b *ClientIdentity) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Public != nil || b.Public != nil {
+		return a.Public != nil && b.Public != nil && a.Public.Equal(b.Public)
+	}
+	return a.Name == b.Name
+}
+
+func (m *sessionManager) remove(s *ClientSession) {
+	m.Lock()
+	defer m.Unlock()
+	if m.sessions[s.id] == s {
+		delete(m.sessions, s.id)
+	}
+}
+
+// close ends all sessions and waits for the handlers to return.
+func (m *sessionManager) close() {
+	m.Lock()
+	m.closed = true
+	sessions := make([]*ClientSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.Unlock()
+	for _, s := range sessions {
+		s.close(xerrors.New("server is closing"))
+	}
+	m.wg.Wait()
+}
+
+// Session is the side of the client of a session opened with
+// Client.OpenSession. If the connection breaks, the session reconnects and
+// the messages that were not received on either side are sent again.
+type Session struct {
+	*sessionPeer
+	client *Client
+	dst    *network.ServerIdentity
+	path   string
+}
+
+// OpenSession opens a session with the handler the service registered
+// under the given name with Context.AcceptSessions.
+func (c *Client) OpenSession(dst *network.ServerIdentity, name string) (*Session, error) {
+	s := &Session{
+		sessionPeer: newSessionPeer(uuid.Nil, c.suite),
+		client:      c,
+		dst:         dst,
+		path:        name,
+	}
+	if err := s.connect(); err != nil {
+		return nil, xerrors.Errorf("opening session: %v", err)
+	}
+	go s.readLoop()
+	return s, nil
+}
+
+// Close ends the session. The handler of the service reads io.EOF.
+func (s *Session) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closeLocked(io.EOF)
+	return nil
+}
+
+// connect opens a new connection and sends the hello of the session.
+func (s *Session) connect() error {
+	conn, err := s.client.dial(s.dst, s.path)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	hello := &SessionHello{ID: s.id, Received: s.received}
+	s.Unlock()
+	buf, err := protobuf.Encode(hello)
+	if err == nil {
+		err = conn.WriteMessage(websocket.BinaryMessage, buf)
+	}
+	if err != nil {
+		conn.Close()
+		return xerrors.Errorf("sending hello: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(sessionHelloTimeout))
+	_, buf, err = conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			// the server refused the session
+			return ce
+		}
+		return xerrors.Errorf("reading welcome: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	welcome := &SessionWelcome{}
+	if err := protobuf.Decode(buf, welcome); err != nil {
+		conn.Close()
+		return xerrors.Errorf("decoding welcome: %v", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		conn.Close()
+		return s.err
+	}
+	s.id = welcome.ID
+	s.resumeLocked(conn, welcome.Received)
+	return nil
+}
+
+// readLoop receives the frames of the server and reconnects if the
+// connection breaks.
+func (s *Session) readLoop() {
+	for {
+		s.Lock()
+		conn := s.conn
+		s.Unlock()
+		if conn == nil {
+			return
+		}
+		_, buf, err := conn.ReadMessage()
+		if err == nil {
+			if err := s.handleFrame(buf); err != nil {
+				s.fail(err)
+				return
+			}
+			continue
+		}
+		if ce, ok := err.(*websocket.CloseError); ok {
+			// the server ended the session
+			if ce.Code == websocket.CloseNormalClosure {
+				s.fail(io.EOF)
+			} else {
+				s.fail(xerrors.Errorf("session closed: %v", ce.Text))
+			}
+			return
+		}
+
+		s.Lock()
+		closed := s.err != nil
+		if s.conn == conn {
+			s.conn = nil
+		}
+		s.Unlock()
+		conn.Close()
+		if closed {
+			return
+		}
+		if err := s.reconnect(); err != nil {
+			s.fail(xerrors.Errorf("reconnecting: %v", err))
+			return
+		}
+	}
+}
+
+func (s *Session) reconnect() error {
+	var err error
+	for a := 0; a < sessionReconnectAttempts; a++ {
+		log.Lvl2("Reconnecting session", s.id)
+		if err = s.connect(); err == nil {
+			return nil
+		}
+		if _, ok := err.(*websocket.CloseError); ok {
+			return err
+		}
+		select {
+		case <-s.done:
+			return io.EOF
+		case <-time.After(time.Second):
+		}
+	}
+	return err
+}
+
+func (s *Session) fail(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.closeLocked(err)
+}
@@ -0,0 +1,147 @@
+package onet
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+const sessionServiceName = "sessionService"
+
+// counts the messages of the session, and ends it on a negative value
+func countingSession(s *ClientSession) error {
+	n := 0
+	for {
+		msg, err := s.Receive()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m := msg.(*authzMsg)
+		if m.Val < 0 {
+			return nil
+		}
+		n++
+		if err := s.Send(&authzMsg{Val: n}); err != nil {
+			return err
+		}
+	}
+}
+
+func newSessionTest() (*LocalTest, *Server, *Client) {
+	RegisterNewService(sessionServiceName, func(c *Context) (Service, error) {
+		c.AcceptSessions("count", countingSession)
+		return NewServiceProcessor(c), nil
+	})
+	local := NewTCPTest(tSuite)
+	servers := local.GenServers(1)
+	return local, servers[0], local.NewClient(sessionServiceName)
+}
+
+func receiveVal(t *testing.T, s *Session) int {
+	msg, err := s.Receive()
+	require.NoError(t, err)
+	return msg.(*authzMsg).Val
+}
+
+func TestSession(t *testing.T) {
+	local, server, cl := newSessionTest()
+	defer UnregisterService(sessionServiceName)
+	defer local.CloseAll()
+
+	s, err := cl.OpenSession(server.ServerIdentity, "count")
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, s.Send(&authzMsg{Val: i}))
+		require.Equal(t, i, receiveVal(t, s))
+	}
+
+	// the handler ends the session
+	require.NoError(t, s.Send(&authzMsg{Val: -1}))
+	_, err = s.Receive()
+	require.Equal(t, io.EOF, err)
+	require.Error(t, s.Send(&authzMsg{Val: 1}))
+
+	// the client ends the session
+	s, err = cl.OpenSession(server.ServerIdentity, "count")
+	require.NoError(t, err)
+	require.NoError(t, s.Send(&authzMsg{Val: 1}))
+	require.Equal(t, 1, receiveVal(t, s))
+	require.NoError(t, s.Close())
+	for i := 0; i < 50; i++ {
+		server.sessions.Lock()
+		n := len(server.sessions.sessions)
+		server.sessions.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Empty(t, server.sessions.sessions)
+
+	_, err = cl.OpenSession(server.ServerIdentity, "unknown")
+	require.Error(t, err)
+}
+
+func TestSession_Resume(t *testing.T) {
+	local, server, cl := newSessionTest()
+	defer UnregisterService(sessionServiceName)
+	defer local.CloseAll()
+
+	s, err := cl.OpenSession(server.ServerIdentity, "count")
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Send(&authzMsg{Val: 1}))
+	require.Equal(t, 1, receiveVal(t, s))
+
+	// break the connection, the session goes on with the same count
+	s.Lock()
+	s.conn.Close()
+	s.Unlock()
+	require.NoError(t, s.Send(&authzMsg{Val: 1}))
+	require.Equal(t, 2, receiveVal(t, s))
+	require.NoError(t, s.Send(&authzMsg{Val: 1}))
+	require.Equal(t, 3, receiveVal(t, s))
+
+	// a session that doesn't exist can't be resumed
+	other := &Session{
+		sessionPeer: newSessionPeer(uuid.NewV4(), tSuite),
+		client:      cl,
+		dst:         server.ServerIdentity,
+		path:        "count",
+	}
+	require.Error(t, other.connect())
+}
+
+func TestSession_Expire(t *testing.T) {
+	local, server, cl := newSessionTest()
+	defer UnregisterService(sessionServiceName)
+	defer local.CloseAll()
+	server.sessions.resumeTimeout = 50 * time.Millisecond
+
+	s, err := cl.OpenSession(server.ServerIdentity, "count")
+	require.NoError(t, err)
+	id := s.ID()
+	// the connection goes away without the client reconnecting
+	s.Lock()
+	s.err = io.EOF
+	close(s.done)
+	s.conn.Close()
+	s.Unlock()
+
+	for i := 0; i < 50; i++ {
+		server.sessions.Lock()
+		_, ok := server.sessions.sessions[id]
+		server.sessions.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Fail(t, "session didn't expire")
+}
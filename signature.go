@@ -0,0 +1,237 @@
+package onet
+
+import (
+	"sort"
+	"time"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/kyber/v3/sign"
+	"go.dedis.ch/kyber/v3/sign/bdn"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// SignatureRequestMsgID of SignatureRequest message as registered in network
+var SignatureRequestMsgID = network.RegisterMessage(SignatureRequest{})
+
+// SignatureSharesMsgID of SignatureShares message as registered in network
+var SignatureSharesMsgID = network.RegisterMessage(SignatureShares{})
+
+// SignatureScheme is how the nodes of a SignatureProtocol sign, and how their
+// signatures are aggregated.
+type SignatureScheme interface {
+	Sign(private kyber.Scalar, msg []byte) ([]byte, error)
+	Verify(public kyber.Point, msg, sig []byte) error
+	// Aggregate returns the aggregate of the signatures of the signers set
+	// in the mask, in the order of their index in publics, or nil if the
+	// scheme can't aggregate them.
+	Aggregate(publics []kyber.Point, mask []byte, sigs [][]byte) ([]byte, error)
+}
+
+type schnorrScheme struct {
+	suite schnorr.Suite
+}
+
+// SchnorrScheme returns the Schnorr signatures of the suite, which are not
+// aggregated.
+func SchnorrScheme(suite schnorr.Suite) SignatureScheme {
+	return schnorrScheme{suite}
+}
+
+func (s schnorrScheme) Sign(private kyber.Scalar, msg []byte) ([]byte, error) {
+	return schnorr.Sign(s.suite, private, msg)
+}
+
+func (s schnorrScheme) Verify(public kyber.Point, msg, sig []byte) error {
+	return schnorr.Verify(s.suite, public, msg, sig)
+}
+
+func (s schnorrScheme) Aggregate([]kyber.Point, []byte, [][]byte) ([]byte, error) {
+	return nil, nil
+}
+
+type blsScheme struct {
+	suite pairing.Suite
+}
+
+// BLSScheme returns the BLS signatures of the pairing suite, which are
+// aggregated into one with kyber's sign/bdn, to be verified with the
+// aggregate of the public keys of the signers given by bdn.AggregatePublicKeys
+// and the mask. Unlike the plain sum of the signatures, it is safe against
+// the signers choosing their key from the ones of the others.
+func BLSScheme(suite pairing.Suite) SignatureScheme {
+	return blsScheme{suite}
+}
+
+func (s blsScheme) Sign(private kyber.Scalar, msg []byte) ([]byte, error) {
+	return bdn.Sign(s.suite, private, msg)
+}
+
+func (s blsScheme) Verify(public kyber.Point, msg, sig []byte) error {
+	return bdn.Verify(s.suite, public, msg, sig)
+}
+
+func (s blsScheme) Aggregate(publics []kyber.Point, mask []byte, sigs [][]byte) ([]byte, error) {
+	m, err := sign.NewMask(s.suite, publics, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("mask: %v", err)
+	}
+	if err := m.SetMask(mask); err != nil {
+		return nil, xerrors.Errorf("mask: %v", err)
+	}
+	agg, err := bdn.AggregateSignatures(s.suite, sigs, m)
+	if err != nil {
+		return nil, xerrors.Errorf("aggregating: %v", err)
+	}
+	return agg.MarshalBinary()
+}
+
+// SignatureRequest is the request of a SignatureProtocol.
+type SignatureRequest struct {
+	Message []byte
+}
+
+// SignatureShares are the signatures of a subtree of a SignatureProtocol.
+type SignatureShares struct {
+	Shares []SignatureShare
+}
+
+// SignatureShare is the signature of a node, by its index in the roster.
+type SignatureShare struct {
+	Index     int
+	Signature []byte
+}
+
+// SignatureResult are the signatures collected by a SignatureProtocol.
+type SignatureResult struct {
+	Message []byte
+	// Signatures are the valid signatures by the indexes of the signers
+	// in the roster, and Mask has the bit of the index set for each of
+	// them, as kyber's sign.Mask.
+	Signatures map[int][]byte
+	Mask       []byte
+	// Aggregate is the aggregate of the signatures, if the scheme
+	// aggregates them.
+	Aggregate []byte
+	// Missing are the nodes that didn't give a valid signature.
+	Missing []*network.ServerIdentity
+}
+
+// SignatureProtocol collects the signatures of the nodes of a tree on a
+// message, with the keys of the service, using a CollectProtocol. It is
+// registered by the services with NewSignatureProtocol, and run with
+// Context.CollectSignatures.
+type SignatureProtocol struct {
+	*CollectProtocol
+	scheme SignatureScheme
+}
+
+// NewSignatureProtocol returns the constructor of a SignatureProtocol with
+// the scheme. If check is not nil, the nodes only sign the messages it
+// accepts.
+func NewSignatureProtocol(scheme SignatureScheme, check func(n *TreeNodeInstance, msg []byte) error) NewProtocol {
+	respond := func(n *TreeNodeInstance, req network.Message) (network.Message, error) {
+		msg := req.(*SignatureRequest).Message
+		if check != nil {
+			if err := check(n, msg); err != nil {
+				return nil, xerrors.Errorf("refusing to sign: %v", err)
+			}
+		}
+		sig, err := scheme.Sign(n.Private(), msg)
+		if err != nil {
+			return nil, xerrors.Errorf("signing: %v", err)
+		}
+		return &SignatureShares{Shares: []SignatureShare{{n.TreeNode().RosterIndex, sig}}}, nil
+	}
+	reduce := func(responses []network.Message) (network.Message, error) {
+		shares := &SignatureShares{}
+		for _, r := range responses {
+			shares.Shares = append(shares.Shares, r.(*SignatureShares).Shares...)
+		}
+		return shares, nil
+	}
+	collect := NewCollectProtocol(respond, reduce)
+	return func(n *TreeNodeInstance) (ProtocolInstance, error) {
+		pi, err := collect(n)
+		if err != nil {
+			return nil, err
+		}
+		return &SignatureProtocol{CollectProtocol: pi.(*CollectProtocol), scheme: scheme}, nil
+	}
+}
+
+// Sign starts the protocol at the root and returns the signatures of the
+// nodes on msg, or an error if fewer than threshold of them signed it.
+func (p *SignatureProtocol) Sign(msg []byte, threshold int, timeout time.Duration) (*SignatureResult, error) {
+	p.Request = &SignatureRequest{Message: msg}
+	if timeout > 0 {
+		p.Timeout = timeout
+	}
+	if err := p.Start(); err != nil {
+		return nil, xerrors.Errorf("starting: %v", err)
+	}
+	res := <-p.Result
+	if res.Err != nil {
+		return nil, xerrors.Errorf("collecting: %v", res.Err)
+	}
+
+	publics := p.Publics()
+	sr := &SignatureResult{
+		Message:    msg,
+		Signatures: make(map[int][]byte),
+		Mask:       make([]byte, (len(publics)+7)/8),
+	}
+	if res.Response != nil {
+		for _, s := range res.Response.(*SignatureShares).Shares {
+			if s.Index < 0 || s.Index >= len(publics) {
+				continue
+			}
+			if p.scheme.Verify(publics[s.Index], msg, s.Signature) != nil {
+				continue
+			}
+			sr.Signatures[s.Index] = s.Signature
+			sr.Mask[s.Index/8] |= byte(1) << uint(s.Index&7)
+		}
+	}
+	for _, tn := range p.Tree().List() {
+		if _, ok := sr.Signatures[tn.RosterIndex]; !ok {
+			sr.Missing = append(sr.Missing, tn.ServerIdentity)
+		}
+	}
+	if len(sr.Signatures) < threshold {
+		return sr, xerrors.Errorf("%d signatures for a threshold of %d", len(sr.Signatures), threshold)
+	}
+
+	indexes := make([]int, 0, len(sr.Signatures))
+	for i := range sr.Signatures {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	sigs := make([][]byte, len(indexes))
+	for i, idx := range indexes {
+		sigs[i] = sr.Signatures[idx]
+	}
+	agg, err := p.scheme.Aggregate(publics, sr.Mask, sigs)
+	if err != nil {
+		return sr, xerrors.Errorf("aggregating: %v", err)
+	}
+	sr.Aggregate = agg
+	return sr, nil
+}
+
+// CollectSignatures runs the SignatureProtocol registered under name over
+// the tree, of which we must be the root, and returns the signatures of the
+// nodes on msg, or an error if fewer than threshold of them signed it.
+func (c *Context) CollectSignatures(name string, tree *Tree, msg []byte, threshold int, timeout time.Duration) (*SignatureResult, error) {
+	pi, err := c.CreateProtocol(name, tree)
+	if err != nil {
+		return nil, xerrors.Errorf("creating protocol: %v", err)
+	}
+	p, ok := pi.(*SignatureProtocol)
+	if !ok {
+		return nil, xerrors.Errorf("%s is not a signature protocol", name)
+	}
+	return p.Sign(msg, threshold, timeout)
+}
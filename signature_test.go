@@ -0,0 +1,109 @@
+package onet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/kyber/v3/sign"
+	"go.dedis.ch/kyber/v3/sign/bdn"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+type signatureService struct {
+	*ServiceProcessor
+}
+
+// registerSignatureService registers a service with the signature protocol,
+// and returns the contexts of its servers by their identities.
+func registerSignatureService(t *testing.T, name string, suite suites.Suite, scheme SignatureScheme,
+	check func(*TreeNodeInstance, []byte) error) map[network.ServerIdentityID]*Context {
+	var lock sync.Mutex
+	contexts := make(map[network.ServerIdentityID]*Context)
+	newService := func(c *Context) (Service, error) {
+		lock.Lock()
+		contexts[c.ServerIdentity().ID] = c
+		lock.Unlock()
+		if _, err := c.ProtocolRegister(name, NewSignatureProtocol(scheme, check)); err != nil {
+			return nil, err
+		}
+		return &signatureService{NewServiceProcessor(c)}, nil
+	}
+	var err error
+	if suite != nil {
+		_, err = RegisterNewServiceWithSuite(name, suite, newService)
+	} else {
+		_, err = RegisterNewService(name, newService)
+	}
+	require.NoError(t, err)
+	return contexts
+}
+
+func TestSignatureProtocol_BLS(t *testing.T) {
+	suite := pairing.NewSuiteBn256()
+	contexts := registerSignatureService(t, "sigTestBLS", suite, BLSScheme(suite), nil)
+	defer UnregisterService("sigTestBLS")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(5, true)
+
+	msg := []byte("block")
+	res, err := contexts[servers[0].ServerIdentity.ID].CollectSignatures("sigTestBLS", tree, msg, 5, time.Second)
+	require.NoError(t, err)
+	require.Len(t, res.Signatures, 5)
+	require.Empty(t, res.Missing)
+	require.Equal(t, []byte{0x1f}, res.Mask)
+
+	var publics []kyber.Point
+	for _, si := range tree.Roster.List {
+		publics = append(publics, si.ServicePublic("sigTestBLS"))
+	}
+	mask, err := sign.NewMask(suite, publics, nil)
+	require.NoError(t, err)
+	require.NoError(t, mask.SetMask(res.Mask))
+	agg, err := bdn.AggregatePublicKeys(suite, mask)
+	require.NoError(t, err)
+	require.NoError(t, bdn.Verify(suite, agg, msg, res.Aggregate))
+	// the keys are weighted, so that a signer can't cancel the others
+	require.Error(t, bdn.Verify(suite, bls.AggregatePublicKeys(suite, publics...), msg, res.Aggregate))
+}
+
+// Tests that the nodes refusing to sign are missing, and that the threshold
+// is enforced.
+func TestSignatureProtocol_Threshold(t *testing.T) {
+	var refuse network.ServerIdentityID
+	check := func(n *TreeNodeInstance, msg []byte) error {
+		if n.ServerIdentity().ID.Equal(refuse) {
+			return xerrors.New("refusing")
+		}
+		return nil
+	}
+	contexts := registerSignatureService(t, "sigTestSchnorr", nil, SchnorrScheme(tSuite), check)
+	defer UnregisterService("sigTestSchnorr")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(5, true)
+	refuse = servers[3].ServerIdentity.ID
+	c := contexts[servers[0].ServerIdentity.ID]
+
+	msg := []byte("config")
+	res, err := c.CollectSignatures("sigTestSchnorr", tree, msg, 4, time.Second)
+	require.NoError(t, err)
+	require.Len(t, res.Signatures, 4)
+	require.Len(t, res.Missing, 1)
+	require.True(t, res.Missing[0].ID.Equal(refuse))
+	require.Nil(t, res.Aggregate)
+	for i, sig := range res.Signatures {
+		require.NoError(t, schnorr.Verify(tSuite, tree.Roster.List[i].Public, msg, sig))
+	}
+
+	_, err = c.CollectSignatures("sigTestSchnorr", tree, msg, 5, time.Second)
+	require.Error(t, err)
+}
@@ -3,6 +3,7 @@ package simul
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -28,9 +29,19 @@ var simRange = ""
 var race = false
 var runWait = 180 * time.Second
 var experimentWait = 0 * time.Second
+var resume = false
+var dashboardAddr = ""
+var prometheusURL = ""
+var influxURL = ""
+
+// shows the measures of the current run if dashboardAddr is set
+var dashboard *monitor.Dashboard
+
+// send the measures to the databases given by prometheusURL and influxURL
+var exporters []*monitor.Exporter
 
 func init() {
-	flag.StringVar(&platformDst, "platform", platformDst, "platform to deploy to [localhost,mininet,deterlab]")
+	flag.StringVar(&platformDst, "platform", platformDst, "platform to deploy to [localhost,docker,mininet,deterlab,cloud]")
 	flag.BoolVar(&nobuild, "nobuild", false, "Don't rebuild all helpers")
 	flag.BoolVar(&clean, "clean", false, "Only clean platform")
 	flag.StringVar(&build, "build", "", "List of packages to build")
@@ -40,6 +51,10 @@ func init() {
 	flag.StringVar(&simRange, "range", simRange, "Range of simulations to run. 0: or 3:4 or :4")
 	flag.DurationVar(&runWait, "runwait", runWait, "How long to wait for each simulation to finish - overwrites .toml-value")
 	flag.DurationVar(&experimentWait, "experimentwait", experimentWait, "How long to wait for the whole experiment to finish")
+	flag.BoolVar(&resume, "resume", false, "Resume the simulations after the last checkpoint")
+	flag.StringVar(&dashboardAddr, "dashboard", dashboardAddr, "Address where to serve a dashboard of the running simulation, like :8080")
+	flag.StringVar(&prometheusURL, "prometheus", prometheusURL, "URL of a Prometheus remote write endpoint where to send the measures")
+	flag.StringVar(&influxURL, "influxdb", influxURL, "URL of an InfluxDB write endpoint where to send the measures, with the database")
 	log.RegisterFlags()
 }
 
@@ -51,6 +66,19 @@ func startBuild() {
 		log.Fatal("Platform not recognized.", platformDst)
 	}
 	log.Lvl1("Deploying to", platformDst)
+	if dashboardAddr != "" {
+		dashboard = monitor.NewDashboard()
+		go func() {
+			log.Error("Dashboard stopped:", http.ListenAndServe(dashboardAddr, dashboard))
+		}()
+		log.Lvl1("Dashboard on", dashboardAddr)
+	}
+	if prometheusURL != "" {
+		exporters = append(exporters, monitor.NewPrometheusExporter(prometheusURL))
+	}
+	if influxURL != "" {
+		exporters = append(exporters, monitor.NewInfluxExporter(influxURL))
+	}
 
 	simulations := flag.Args()
 	if len(simulations) == 0 {
@@ -79,6 +107,9 @@ func startBuild() {
 			}
 		} else {
 			logname := strings.Replace(filepath.Base(simulation), ".toml", "", 1)
+			for _, e := range exporters {
+				e.SetLabel("simulation", logname)
+			}
 			testsDone := make(chan bool)
 			timeout, err := getExperimentWait(runconfigs)
 			if err != nil {
@@ -117,9 +148,22 @@ func RunTests(deployP platform.Platform, name string, runconfigs []*platform.Run
 	}
 
 	mkTestDir()
+	cpFile := checkpointFileName(name)
+	profilePrefix := profileFilePrefix(name)
+	var cp *checkpoint
+	if resume {
+		var err error
+		cp, err = loadCheckpoint(cpFile)
+		if err != nil {
+			log.Fatal("Couldn't resume:", err)
+		}
+		if cp == nil {
+			log.Lvl1("No checkpoint to resume for", name)
+		}
+	}
 	args := os.O_CREATE | os.O_RDWR | os.O_TRUNC
-	// If a range is given, we only append
-	if simRange != "" {
+	// If a range is given or if we resume, we only append
+	if simRange != "" || cp != nil {
 		args = os.O_CREATE | os.O_RDWR | os.O_APPEND
 	}
 	files := []*os.File{}
@@ -138,11 +182,26 @@ func RunTests(deployP platform.Platform, name string, runconfigs []*platform.Run
 			log.Lvl2("Skipping", rc, "because of range")
 			continue
 		}
+		if cp != nil && i < cp.Run {
+			log.Lvl2("Skipping", rc, "because it is done")
+			continue
+		}
+		var resumed *checkpoint
+		if cp != nil && i == cp.Run && cp.Round >= 0 {
+			resumed = cp
+		}
 
 		// run test t nTimes times
 		// take the average of all successful runs
 		log.Lvl1("Running test with config:", rc)
-		stats, err := RunTest(deployP, rc)
+		stats, err := runTest(deployP, rc, resumed, func(c *checkpoint) {
+			c.Run = i
+			if err := c.save(cpFile); err != nil {
+				log.Error("Couldn't save checkpoint:", err)
+			}
+		}, func(p *monitor.Profile) {
+			saveProfile(p, fmt.Sprintf("%s-%d", profilePrefix, i))
+		})
 		if err != nil {
 			log.Error("Error running test:", err)
 			continue
@@ -178,17 +237,36 @@ func RunTests(deployP platform.Platform, name string, runconfigs []*platform.Run
 				log.Fatal("error syncing data to test file:", err)
 			}
 		}
+		if err := (&checkpoint{Run: i + 1, Round: -1}).save(cpFile); err != nil {
+			log.Error("Couldn't save checkpoint:", err)
+		}
+	}
+	if err := os.Remove(cpFile); err != nil && !os.IsNotExist(err) {
+		log.Error("Couldn't remove checkpoint:", err)
 	}
 }
 
 // RunTest a single test - takes a test-file as a string that will be copied
 // to the deterlab-server
 func RunTest(deployP platform.Platform, rc *platform.RunConfig) ([]*monitor.Stats, error) {
+	return runTest(deployP, rc, nil, nil, nil)
+}
+
+// runTest is like RunTest, but it resumes after the checkpoint if it is not
+// nil, and gives a new checkpoint to save after every round.
+func runTest(deployP platform.Platform, rc *platform.RunConfig, resumed *checkpoint,
+	save func(*checkpoint), profile func(*monitor.Profile)) ([]*monitor.Stats, error) {
 	CheckHosts(rc)
 	rc.Delete("simulation")
+	// the round where it resumes is not part of the results
+	statsConfig := rc.Map()
+	if resumed != nil {
+		log.Lvl1("Resuming after round", resumed.Round)
+		rc.Put("ResumeRound", strconv.Itoa(resumed.Round+1))
+	}
 	stats := []*monitor.Stats{
 		// this is the global bucket
-		monitor.NewStats(rc.Map(), "hosts", "bf"),
+		monitor.NewStats(statsConfig, "hosts", "bf"),
 	}
 
 	if err := deployP.Cleanup(); err != nil {
@@ -204,6 +282,16 @@ func RunTest(deployP platform.Platform, rc *platform.RunConfig) ([]*monitor.Stat
 	m := monitor.NewMonitor(stats[0])
 	m.SinkPort = uint16(monitorPort)
 	defer m.Stop()
+	if dashboard != nil {
+		dashboard.NewRun(rc.String())
+		m.SetDashboard(dashboard)
+	}
+	for _, e := range exporters {
+		m.AddExporter(e)
+	}
+	if profile != nil {
+		m.SetProfile(profile)
+	}
 
 	// create the buckets that will split the statistics of the hosts
 	// according to the configuration file
@@ -216,11 +304,27 @@ func RunTest(deployP platform.Platform, rc *platform.RunConfig) ([]*monitor.Stat
 		// Do nothing, there won't be any bucket.
 	} else {
 		for i, rules := range buckets {
-			bs := monitor.NewStats(rc.Map(), "hosts", "bf")
+			bs := monitor.NewStats(statsConfig, "hosts", "bf")
 			stats = append(stats, bs)
 			m.InsertBucket(i, rules, bs)
 		}
 	}
+	if resumed != nil {
+		for i, measures := range resumed.Measures {
+			if i < len(stats) {
+				stats[i].AddMeasures(measures)
+			}
+		}
+	}
+	if save != nil {
+		m.SetCheckpoint(func(round int) {
+			c := &checkpoint{Round: round}
+			for _, s := range stats {
+				c.Measures = append(c.Measures, s.Measures())
+			}
+			save(c)
+		})
+	}
 
 	done := make(chan error)
 	go func() {
@@ -0,0 +1,66 @@
+package simul
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+// checkpoint is where the simulations of a run file are, saved after every
+// round, so that they can resume with the -resume flag.
+type checkpoint struct {
+	// Run is the index of the current run config
+	Run int
+	// Round is the last round done in this run, -1 if none
+	Round int
+	// Measures are the values received for the global stats and for the
+	// buckets
+	Measures []map[string][]float64
+}
+
+// checkpointFileName returns the absolute path of the checkpoint, as the
+// platforms might change the working directory.
+func checkpointFileName(name string) string {
+	file := fmt.Sprintf("test_data/%s.checkpoint", name)
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+// loadCheckpoint reads the checkpoint of the file, or returns nil if there is
+// none.
+func loadCheckpoint(file string) (*checkpoint, error) {
+	buf, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("reading checkpoint: %v", err)
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(buf, cp); err != nil {
+		return nil, xerrors.Errorf("decoding checkpoint: %v", err)
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint to the file, which is replaced only once it is
+// written.
+func (cp *checkpoint) save(file string) error {
+	buf, err := json.Marshal(cp)
+	if err != nil {
+		return xerrors.Errorf("encoding checkpoint: %v", err)
+	}
+	if err := ioutil.WriteFile(file+".tmp", buf, 0660); err != nil {
+		return xerrors.Errorf("writing checkpoint: %v", err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return xerrors.Errorf("writing checkpoint: %v", err)
+	}
+	return nil
+}
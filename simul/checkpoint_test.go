@@ -0,0 +1,31 @@
+package simul
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.checkpoint")
+
+	cp, err := loadCheckpoint(file)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	cp = &checkpoint{Run: 2, Round: 5, Measures: []map[string][]float64{{"round_wall": {1, 2}}}}
+	require.NoError(t, cp.save(file))
+	cp2, err := loadCheckpoint(file)
+	require.NoError(t, err)
+	require.Equal(t, cp, cp2)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("nope"), 0660))
+	_, err = loadCheckpoint(file)
+	require.Error(t, err)
+}
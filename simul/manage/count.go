@@ -118,7 +118,7 @@ func (p *ProtocolCount) Dispatch() error {
 			} else {
 				p.Replies++
 			}
-		case <-time.After(time.Duration(p.Timeout())):
+		case <-time.After(onet.Dilate(p.Timeout())):
 			log.Lvl3(p.Info(), "timed out while waiting for", p.Timeout())
 			if p.IsRoot() {
 				log.Lvl2("Didn't get all children in time:", p.Replies)
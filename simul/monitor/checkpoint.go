@@ -0,0 +1,48 @@
+package monitor
+
+// the name of the measure telling that a round is complete
+const checkpointName = "checkpoint"
+
+// Checkpoint tells the monitor that the round is complete, so that the
+// measures received so far are saved, and an interrupted simulation can
+// resume after this round.
+func Checkpoint(round int) {
+	RecordSingleMeasure(checkpointName, float64(round))
+}
+
+// SetCheckpoint sets the function called when a round is complete, after the
+// measures received before are in the stats. It must be called before Listen.
+func (m *Monitor) SetCheckpoint(f func(round int)) {
+	m.checkpoint = f
+}
+
+// Measures returns a copy of the values received for every measure, but
+// the ones already discarded as warm-up.
+func (s *Stats) Measures() map[string][]float64 {
+	s.Lock()
+	defer s.Unlock()
+	ret := make(map[string][]float64, len(s.values))
+	for name, v := range s.values {
+		v.Lock()
+		ret[name] = append([]float64{}, v.store...)
+		v.Unlock()
+	}
+	for _, k := range s.heldKeys() {
+		ret[k.name] = append(ret[k.name], s.held[k]...)
+	}
+	return ret
+}
+
+// AddMeasures adds the values to the stats, as if they were received, for
+// example the ones returned by Measures before a simulation was interrupted.
+// They don't count for the warm-up.
+func (s *Stats) AddMeasures(measures map[string][]float64) {
+	s.Lock()
+	defer s.Unlock()
+	for name, values := range measures {
+		value := s.value(name)
+		for _, v := range values {
+			value.Store(v)
+		}
+	}
+}
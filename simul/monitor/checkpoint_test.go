@@ -0,0 +1,42 @@
+package monitor
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor_SetCheckpoint(t *testing.T) {
+	stat := NewStats(map[string]string{"servers": "1"})
+	mon := NewMonitor(stat)
+	mon.SinkPort = 0
+	rounds := make(chan int, 1)
+	var measures map[string][]float64
+	mon.SetCheckpoint(func(round int) {
+		measures = stat.Measures()
+		rounds <- round
+	})
+	go mon.Listen()
+	defer mon.Stop()
+	port := <-mon.sinkPortChan
+	require.NoError(t, ConnectSink("localhost:"+strconv.Itoa(int(port))))
+
+	RecordSingleMeasure("round", 10)
+	RecordSingleMeasure("round", 20)
+	Checkpoint(1)
+	select {
+	case r := <-rounds:
+		require.Equal(t, 1, r)
+	case <-time.After(time.Second):
+		require.Fail(t, "no checkpoint")
+	}
+	EndAndCleanup()
+	require.Equal(t, map[string][]float64{"round": {10, 20}}, measures)
+	require.Nil(t, stat.Value(checkpointName))
+
+	restored := NewStats(map[string]string{"servers": "1"})
+	restored.AddMeasures(measures)
+	require.Equal(t, measures, restored.Measures())
+}
@@ -0,0 +1,257 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// Dashboard is a web page showing the measures received by a monitor while
+// the simulation is still running, so that a broken run can be stopped early.
+// It is an http.Handler serving the page at "/", the current state as JSON
+// at "/state" and a stream of the states as server-sent events at "/events".
+type Dashboard struct {
+	state dashboardState
+	// the clients of "/events", notified when the state changes
+	clients map[chan struct{}]bool
+	sync.Mutex
+}
+
+// dashboardState is what the dashboard shows.
+type dashboardState struct {
+	// Run describes the current run
+	Run string
+	// Started is when the run started and Updated when the last measure
+	// was received
+	Started, Updated time.Time
+	// Round is the last round completed, from Checkpoint, or -1
+	Round    int
+	Measures map[string]*dashboardMeasure
+}
+
+// dashboardMeasure sums up the values received for a measure.
+type dashboardMeasure struct {
+	Count               int
+	Last, Min, Max, Sum float64
+	// Hosts holds the last value by host index
+	Hosts map[int]float64
+}
+
+// NewDashboard returns a dashboard without any measure.
+func NewDashboard() *Dashboard {
+	d := &Dashboard{clients: make(map[chan struct{}]bool)}
+	d.NewRun("")
+	return d
+}
+
+// NewRun forgets the measures of the previous run and shows the description
+// of the new one.
+func (d *Dashboard) NewRun(run string) {
+	d.Lock()
+	now := time.Now()
+	d.state = dashboardState{
+		Run:      run,
+		Started:  now,
+		Updated:  now,
+		Round:    -1,
+		Measures: make(map[string]*dashboardMeasure),
+	}
+	d.Unlock()
+	d.notify()
+}
+
+// SetDashboard makes the monitor show the measures it receives on the
+// dashboard. It must be called before Listen.
+func (m *Monitor) SetDashboard(d *Dashboard) {
+	m.dashboard = d
+}
+
+// update adds the measure to the state.
+func (d *Dashboard) update(meas *singleMeasure) {
+	d.Lock()
+	d.state.Updated = time.Now()
+	if meas.Name == checkpointName {
+		d.state.Round = int(meas.Value)
+	} else {
+		dm := d.state.Measures[meas.Name]
+		if dm == nil {
+			dm = &dashboardMeasure{Min: meas.Value, Max: meas.Value,
+				Hosts: make(map[int]float64)}
+			d.state.Measures[meas.Name] = dm
+		}
+		dm.Count++
+		dm.Last = meas.Value
+		dm.Sum += meas.Value
+		if meas.Value < dm.Min {
+			dm.Min = meas.Value
+		}
+		if meas.Value > dm.Max {
+			dm.Max = meas.Value
+		}
+		dm.Hosts[meas.Host] = meas.Value
+	}
+	d.Unlock()
+	d.notify()
+}
+
+// notify tells the clients that the state changed. A client that is still
+// sending a previous state only sends the latest one afterwards.
+func (d *Dashboard) notify() {
+	d.Lock()
+	defer d.Unlock()
+	for c := range d.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// marshal returns the current state as JSON.
+func (d *Dashboard) marshal() ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+	return json.Marshal(&d.state)
+}
+
+// ServeHTTP implements http.Handler.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, dashboardPage)
+	case "/state":
+		buf, err := d.marshal()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf)
+	case "/events":
+		d.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents sends the state every time it changes, until the client goes
+// away.
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan struct{}, 1)
+	c <- struct{}{}
+	d.Lock()
+	d.clients[c] = true
+	d.Unlock()
+	defer func() {
+		d.Lock()
+		delete(d.clients, c)
+		d.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-c:
+		case <-r.Context().Done():
+			return
+		}
+		buf, err := d.marshal()
+		if err != nil {
+			log.Error("Couldn't marshal the dashboard:", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", buf); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Simulation</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.stale { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Simulation</h1>
+<p id="run"></p>
+<p id="status"></p>
+<table id="measures"></table>
+<table id="hosts"></table>
+<script>
+var state = null;
+function cell(tag, text) {
+	var c = document.createElement(tag);
+	c.textContent = text;
+	return c;
+}
+function row(table, tag, cells) {
+	var r = document.createElement("tr");
+	cells.forEach(function(t) { r.appendChild(cell(tag, t)); });
+	table.appendChild(r);
+}
+function num(v) {
+	return v === undefined ? "" : v.toFixed(4);
+}
+function render() {
+	if (state === null) {
+		return;
+	}
+	var now = new Date();
+	var idle = (now - new Date(state.Updated)) / 1000;
+	document.getElementById("run").textContent = state.Run;
+	var status = document.getElementById("status");
+	status.textContent = "Running for " + Math.round((now - new Date(state.Started)) / 1000) +
+		"s, last round " + state.Round + ", last measure " + Math.round(idle) + "s ago";
+	status.className = idle > 60 ? "stale" : "";
+
+	var names = Object.keys(state.Measures).sort();
+	var measures = document.getElementById("measures");
+	measures.innerHTML = "";
+	row(measures, "th", ["measure", "count", "last", "min", "avg", "max"]);
+	names.forEach(function(n) {
+		var m = state.Measures[n];
+		row(measures, "td", [n, m.Count, num(m.Last), num(m.Min), num(m.Sum / m.Count), num(m.Max)]);
+	});
+
+	var hosts = {};
+	names.forEach(function(n) {
+		Object.keys(state.Measures[n].Hosts).forEach(function(h) { hosts[h] = true; });
+	});
+	var table = document.getElementById("hosts");
+	table.innerHTML = "";
+	row(table, "th", ["host"].concat(names));
+	Object.keys(hosts).sort(function(a, b) { return a - b; }).forEach(function(h) {
+		row(table, "td", [h].concat(names.map(function(n) {
+			return num(state.Measures[n].Hosts[h]);
+		})));
+	});
+}
+new EventSource("events").onmessage = function(e) {
+	state = JSON.parse(e.data);
+	render();
+};
+setInterval(render, 1000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboard(t *testing.T) {
+	d := NewDashboard()
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	require.NoError(t, err)
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(page), "EventSource")
+
+	resp, err = http.Get(srv.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	next := func() dashboardState {
+		for {
+			line, err := events.ReadString('\n')
+			require.NoError(t, err)
+			if strings.HasPrefix(line, "data: ") {
+				var s dashboardState
+				require.NoError(t, json.Unmarshal([]byte(line[6:]), &s))
+				return s
+			}
+		}
+	}
+	require.Equal(t, -1, next().Round)
+
+	d.NewRun("hosts = 2")
+	d.update(newSingleMeasureWithHost("round_wall", 2, 0))
+	d.update(newSingleMeasureWithHost("round_wall", 4, 1))
+	d.update(newSingleMeasure(checkpointName, 3))
+	var s dashboardState
+	for s.Round != 3 {
+		s = next()
+	}
+	require.Equal(t, "hosts = 2", s.Run)
+	require.Equal(t, &dashboardMeasure{Count: 2, Last: 4, Min: 2, Max: 4, Sum: 6,
+		Hosts: map[int]float64{0: 2, 1: 4}}, s.Measures["round_wall"])
+
+	resp2, err := http.Get(srv.URL + "/state")
+	require.NoError(t, err)
+	var s2 dashboardState
+	require.NoError(t, json.NewDecoder(resp2.Body).Decode(&s2))
+	resp2.Body.Close()
+	require.Equal(t, s.Measures, s2.Measures)
+
+	d.NewRun("hosts = 4")
+	for s.Run != "hosts = 4" {
+		s = next()
+	}
+	require.Equal(t, 0, len(s.Measures))
+
+	resp2, err = http.Get(srv.URL + "/unknown")
+	require.NoError(t, err)
+	resp2.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp2.StatusCode)
+}
+
+func TestMonitor_SetDashboard(t *testing.T) {
+	mon := NewMonitor(NewStats(map[string]string{"servers": "1"}))
+	d := NewDashboard()
+	mon.SetDashboard(d)
+	mon.update(newSingleMeasure("round", 10))
+	mon.update(newSingleMeasure(checkpointName, 0))
+	require.Equal(t, 1, d.state.Measures["round"].Count)
+	require.Equal(t, 0, d.state.Round)
+}
@@ -0,0 +1,264 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// exportBatch is how many measures an Exporter holds before sending them.
+const exportBatch = 500
+
+// exportInterval is how long an Exporter holds the measures at most, for
+// the database to follow the simulation while it runs.
+const exportInterval = time.Second
+
+// Exporter sends the measures received by a monitor to a time series
+// database, besides the .csv, to keep the results of many simulations and
+// look at them with Grafana for example. The measures are labelled with the
+// host and the variables of their run, and grouped in batches.
+type Exporter struct {
+	url    string
+	encode func(measures []exportedMeasure) []byte
+	header http.Header
+	client *http.Client
+	labels map[string]string
+	batch  []exportedMeasure
+	last   time.Time
+	sync.Mutex
+}
+
+// exportedMeasure is a measure waiting to be sent.
+type exportedMeasure struct {
+	name   string
+	value  float64
+	time   time.Time
+	labels map[string]string
+}
+
+// NewPrometheusExporter returns an exporter sending the measures to the
+// remote write endpoint at the url, like
+// http://localhost:9090/api/v1/write.
+// The name of a measure gets the prefix "onet_".
+func NewPrometheusExporter(url string) *Exporter {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/x-protobuf")
+	header.Set("Content-Encoding", "snappy")
+	header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return newExporter(url, encodePrometheus, header)
+}
+
+// NewInfluxExporter returns an exporter sending the measures in the line
+// protocol to the write endpoint at the url, like
+// http://localhost:8086/write?db=onet.
+// Every measure is a point with a "value" field.
+func NewInfluxExporter(url string) *Exporter {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return newExporter(url, encodeInflux, header)
+}
+
+func newExporter(url string, encode func([]exportedMeasure) []byte, header http.Header) *Exporter {
+	return &Exporter{
+		url:    url,
+		encode: encode,
+		header: header,
+		client: &http.Client{Timeout: 10 * time.Second},
+		labels: make(map[string]string),
+		last:   time.Now(),
+	}
+}
+
+// SetLabel adds a label to all the measures exported afterwards, like the
+// name of the simulation.
+func (e *Exporter) SetLabel(name, value string) {
+	e.Lock()
+	defer e.Unlock()
+	e.labels[name] = value
+}
+
+// AddExporter makes the monitor send the measures it receives to the
+// exporter, labelled with the static fields of its stats. It must be called
+// before Listen, and the remaining measures are sent when Listen returns.
+func (m *Monitor) AddExporter(e *Exporter) {
+	m.stats.Lock()
+	labels := make(map[string]string, len(m.stats.static))
+	for k, v := range m.stats.static {
+		labels[k] = strings.Trim(v, `"`)
+	}
+	m.stats.Unlock()
+	m.exporters = append(m.exporters, e)
+	m.exportLabels = labels
+}
+
+// export adds the measure to the batch, and sends the batch if it is full
+// or old enough.
+func (e *Exporter) export(meas *singleMeasure, labels map[string]string) {
+	e.Lock()
+	all := make(map[string]string, len(labels)+len(e.labels)+1)
+	for k, v := range labels {
+		all[k] = v
+	}
+	for k, v := range e.labels {
+		all[k] = v
+	}
+	if meas.Host != InvalidHostIndex {
+		all["host"] = strconv.Itoa(meas.Host)
+	}
+	e.batch = append(e.batch, exportedMeasure{meas.Name, meas.Value, time.Now(), all})
+	full := len(e.batch) >= exportBatch || time.Since(e.last) >= exportInterval
+	e.Unlock()
+	if full {
+		if err := e.Flush(); err != nil {
+			log.Error("Couldn't export measures:", err)
+		}
+	}
+}
+
+// Flush sends the measures that are waiting.
+func (e *Exporter) Flush() error {
+	e.Lock()
+	batch := e.batch
+	e.batch = nil
+	e.last = time.Now()
+	e.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(e.encode(batch)))
+	if err != nil {
+		return xerrors.Errorf("request: %v", err)
+	}
+	for k, v := range e.header {
+		req.Header[k] = v
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("sending: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return xerrors.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the labels in order.
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeInflux returns the measures in the InfluxDB line protocol.
+func encodeInflux(measures []exportedMeasure) []byte {
+	escape := strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	var buf bytes.Buffer
+	for _, m := range measures {
+		buf.WriteString(escape.Replace(m.name))
+		for _, k := range sortedKeys(m.labels) {
+			if m.labels[k] == "" {
+				continue
+			}
+			fmt.Fprintf(&buf, ",%s=%s", escape.Replace(k), escape.Replace(m.labels[k]))
+		}
+		fmt.Fprintf(&buf, " value=%s %d\n",
+			strconv.FormatFloat(m.value, 'g', -1, 64), m.time.UnixNano())
+	}
+	return buf.Bytes()
+}
+
+// prometheusName replaces the characters that are not allowed in the names
+// of Prometheus.
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// encodePrometheus returns the measures as a remote write request, one time
+// series per measure. The protobuf message and its snappy compression are
+// written by hand to not depend on the Prometheus libraries.
+func encodePrometheus(measures []exportedMeasure) []byte {
+	var req []byte
+	for _, m := range measures {
+		var series []byte
+		label := func(name, value string) {
+			var l []byte
+			l = protoBytes(l, 1, []byte(name))
+			l = protoBytes(l, 2, []byte(value))
+			series = protoBytes(series, 1, l)
+		}
+		labels := make(map[string]string, len(m.labels)+1)
+		for k, v := range m.labels {
+			labels[prometheusName(k)] = v
+		}
+		labels["__name__"] = "onet_" + prometheusName(m.name)
+		// the labels must be sorted by name
+		for _, k := range sortedKeys(labels) {
+			label(k, labels[k])
+		}
+		var sample []byte
+		sample = appendUvarint(sample, 1<<3|1)
+		var value [8]byte
+		binary.LittleEndian.PutUint64(value[:], math.Float64bits(m.value))
+		sample = append(sample, value[:]...)
+		sample = appendUvarint(sample, 2<<3)
+		sample = appendUvarint(sample, uint64(m.time.UnixNano()/int64(time.Millisecond)))
+		series = protoBytes(series, 2, sample)
+		req = protoBytes(req, 1, series)
+	}
+	return snappyLiteral(req)
+}
+
+// appendUvarint appends the varint of the protobuf and snappy encodings.
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+// protoBytes appends the length-delimited field to the protobuf message.
+func protoBytes(msg []byte, field int, value []byte) []byte {
+	msg = appendUvarint(msg, uint64(field)<<3|2)
+	msg = appendUvarint(msg, uint64(len(value)))
+	return append(msg, value...)
+}
+
+// snappyLiteral returns the data in the snappy block format, without
+// compressing it: the length followed by literals of at most 64kB.
+func snappyLiteral(data []byte) []byte {
+	out := appendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		if n <= 60 {
+			out = append(out, byte(n-1)<<2)
+		} else {
+			out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
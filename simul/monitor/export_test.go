@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// exportServer returns a server giving the bodies and headers of the
+// requests it receives.
+func exportServer(t *testing.T) (*httptest.Server, chan *http.Request, chan []byte) {
+	reqs := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		reqs <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return srv, reqs, bodies
+}
+
+func TestInfluxExporter(t *testing.T) {
+	srv, reqs, bodies := exportServer(t)
+	defer srv.Close()
+
+	mon := NewMonitor(NewStats(map[string]string{"hosts": "4", "suite": `"Ed25519"`}))
+	e := NewInfluxExporter(srv.URL + "/write?db=onet")
+	e.SetLabel("simulation", "count test")
+	mon.AddExporter(e)
+	mon.update(newSingleMeasureWithHost("round_wall", 1.5, 2))
+	mon.update(newSingleMeasure("setup", 3))
+	mon.update(newSingleMeasure(checkpointName, 0))
+	require.NoError(t, e.Flush())
+
+	r := <-reqs
+	require.Equal(t, "/write?db=onet", r.URL.String())
+	lines := strings.Split(strings.TrimSpace(string(<-bodies)), "\n")
+	require.Equal(t, 2, len(lines))
+	require.True(t, strings.HasPrefix(lines[0],
+		`round_wall,host=2,hosts=4,simulation=count\ test,suite=Ed25519 value=1.5 `), lines[0])
+	require.True(t, strings.HasPrefix(lines[1],
+		`setup,hosts=4,simulation=count\ test,suite=Ed25519 value=3 `), lines[1])
+
+	// nothing left to send
+	require.NoError(t, e.Flush())
+	require.Equal(t, 0, len(reqs))
+}
+
+// readVarint reads a varint from the buffer.
+func readVarint(t *testing.T, buf *[]byte) uint64 {
+	v, n := binary.Uvarint(*buf)
+	require.True(t, n > 0)
+	*buf = (*buf)[n:]
+	return v
+}
+
+// readProto returns the fields of a protobuf message, the length-delimited
+// ones as []byte, the others as uint64.
+func readProto(t *testing.T, msg []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(msg) > 0 {
+		key := readVarint(t, &msg)
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			fields[field] = append(fields[field], readVarint(t, &msg))
+		case 1:
+			fields[field] = append(fields[field], binary.LittleEndian.Uint64(msg))
+			msg = msg[8:]
+		case 2:
+			n := readVarint(t, &msg)
+			fields[field] = append(fields[field], msg[:n])
+			msg = msg[n:]
+		default:
+			require.Fail(t, "unknown wire type")
+		}
+	}
+	return fields
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	srv, reqs, bodies := exportServer(t)
+	defer srv.Close()
+
+	mon := NewMonitor(NewStats(map[string]string{"hosts": "4"}))
+	e := NewPrometheusExporter(srv.URL)
+	mon.AddExporter(e)
+	mon.update(newSingleMeasureWithHost("round.wall", 1.5, 2))
+	require.NoError(t, e.Flush())
+
+	r := <-reqs
+	require.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+	body := <-bodies
+	// the snappy block only has literals
+	length := readVarint(t, &body)
+	require.Equal(t, byte(61<<2), body[0])
+	n := int(body[1]) | int(body[2])<<8 + 1
+	require.Equal(t, int(length), n)
+	series := readProto(t, body[3:])[1]
+	require.Equal(t, 1, len(series))
+	ts := readProto(t, series[0].([]byte))
+
+	var labels [][2]string
+	for _, l := range ts[1] {
+		f := readProto(t, l.([]byte))
+		labels = append(labels, [2]string{string(f[1][0].([]byte)), string(f[2][0].([]byte))})
+	}
+	require.Equal(t, [][2]string{{"__name__", "onet_round_wall"}, {"host", "2"},
+		{"hosts", "4"}}, labels)
+	sample := readProto(t, ts[2][0].([]byte))
+	require.Equal(t, 1.5, math.Float64frombits(sample[1][0].(uint64)))
+	require.True(t, sample[2][0].(uint64) > 0)
+}
+
+func TestSnappyLiteral(t *testing.T) {
+	require.Equal(t, []byte{3, 2 << 2, 'a', 'b', 'c'}, snappyLiteral([]byte("abc")))
+	data := make([]byte, 1<<16+10)
+	out := snappyLiteral(data)
+	buf := out
+	require.Equal(t, uint64(len(data)), readVarint(t, &buf))
+	require.Equal(t, []byte{61 << 2, 0xff, 0xff}, buf[:3])
+	require.Equal(t, byte(9<<2), buf[3+1<<16])
+	require.Equal(t, 3+3+1<<16+1+10, len(out))
+}
+
+func TestExporter_error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no database", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	mon := NewMonitor(NewStats(map[string]string{}))
+	e := NewInfluxExporter(srv.URL)
+	mon.AddExporter(e)
+	mon.update(newSingleMeasure("setup", 3))
+	err := e.Flush()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no database")
+}
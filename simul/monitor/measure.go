@@ -51,6 +51,8 @@ type singleMeasure struct {
 	Name  string
 	Value float64
 	Host  int
+	// Profile is only set for the measures of RecordProfile
+	Profile *Profile `json:",omitempty"`
 }
 
 // TimeMeasure represents a measure regarding time: It includes the wallclock
@@ -61,6 +61,16 @@ type Monitor struct {
 
 	SinkPort     uint16
 	sinkPortChan chan uint16
+
+	// called when a round is complete
+	checkpoint func(round int)
+	// shows the measures while they are received
+	dashboard *Dashboard
+	// send the measures to databases, with the labels of the run
+	exporters    []*Exporter
+	exportLabels map[string]string
+	// called with the profiles of the hosts
+	profile func(p *Profile)
 }
 
 // NewMonitor returns a new monitor given the stats
@@ -149,6 +159,11 @@ func (m *Monitor) Listen() error {
 		}
 	}
 	log.Lvl2("Monitor finished waiting")
+	for _, e := range m.exporters {
+		if err := e.Flush(); err != nil {
+			log.Error("Couldn't export measures:", err)
+		}
+	}
 	m.mutexConn.Lock()
 	m.conns = make(map[string]net.Conn)
 	m.mutexConn.Unlock()
@@ -212,6 +227,26 @@ func (m *Monitor) handleConnection(conn net.Conn) {
 // updateBucket will add that specific measure to all the bucket
 // that match the network address.
 func (m *Monitor) update(meas *singleMeasure) {
+	if meas.Name == profileName {
+		if m.profile != nil && meas.Profile != nil {
+			m.profile(meas.Profile)
+		}
+		return
+	}
+	if m.dashboard != nil {
+		m.dashboard.update(meas)
+	}
+	for _, e := range m.exporters {
+		if meas.Name != checkpointName {
+			e.export(meas, m.exportLabels)
+		}
+	}
+	if meas.Name == checkpointName {
+		if m.checkpoint != nil {
+			m.checkpoint(int(meas.Value))
+		}
+		return
+	}
 	// global stats
 	m.stats.Update(meas)
 	// per bucket stats if defined
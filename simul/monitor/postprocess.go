@@ -0,0 +1,265 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.dedis.ch/onet/v4/log"
+)
+
+// postProcessing is the statistical processing of the measures, taken out
+// of the run config. Keys expected are:
+// warmup = n => discards the n first values of every host for the measures
+// a host records more than n times, like the first n rounds
+// percentiles = "50 95 99" => adds a column per percentile to every measure
+// confidence = 0.95 => adds the half-width of the confidence interval of the
+// average to every measure
+// outliers = k => discards the values further than k times the
+// interquartile range from the quartiles
+type postProcessing struct {
+	warmup      int
+	percentiles []float64
+	confidence  float64
+	outliers    float64
+}
+
+// newPostProcessing returns the processing of the run config, which does
+// nothing if the keys are absent.
+func newPostProcessing(rc map[string]string) postProcessing {
+	var pp postProcessing
+	value := func(key string) string {
+		return strings.Trim(rc[key], `"`)
+	}
+	if v := value("warmup"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Error("Stats: wrong warmup:", v)
+		} else {
+			pp.warmup = n
+		}
+	}
+	for _, v := range strings.Fields(strings.Replace(value("percentiles"), ",", " ", -1)) {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || p > 100 {
+			log.Error("Stats: wrong percentile:", v)
+			continue
+		}
+		pp.percentiles = append(pp.percentiles, p)
+	}
+	if v := value("confidence"); v != "" {
+		c, err := strconv.ParseFloat(v, 64)
+		if err != nil || c <= 0 || c >= 1 {
+			log.Error("Stats: wrong confidence:", v)
+		} else {
+			pp.confidence = c
+		}
+	}
+	if v := value("outliers"); v != "" {
+		k, err := strconv.ParseFloat(v, 64)
+		if err != nil || k <= 0 {
+			log.Error("Stats: wrong outliers:", v)
+		} else {
+			pp.outliers = k
+		}
+	}
+	return pp
+}
+
+// warmupKey are the values of a measure from a host.
+type warmupKey struct {
+	name string
+	host int
+}
+
+// headerFields returns the names of the columns added to the measure.
+func (pp *postProcessing) headerFields(name string) []string {
+	var fields []string
+	for _, p := range pp.percentiles {
+		fields = append(fields, name+"_p"+strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	if pp.confidence > 0 {
+		fields = append(fields, name+"_ci")
+	}
+	return fields
+}
+
+// values returns the columns added to the measure.
+func (pp *postProcessing) values(v *Value) []string {
+	var values []string
+	for _, p := range pp.percentiles {
+		values = append(values, fmt.Sprintf("%f", v.Percentile(p)))
+	}
+	if pp.confidence > 0 {
+		values = append(values, fmt.Sprintf("%f", v.ConfidenceInterval(pp.confidence)))
+	}
+	return values
+}
+
+// noValues returns the columns added to a measure without statistics, for
+// the individual stats.
+func (pp *postProcessing) noValues() []string {
+	n := len(pp.percentiles)
+	if pp.confidence > 0 {
+		n++
+	}
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "NaN"
+	}
+	return values
+}
+
+// rejectOutliers returns the values between the fences of Tukey, k times
+// the interquartile range away from the quartiles.
+func rejectOutliers(values []float64, k float64) []float64 {
+	if len(values) < 4 {
+		return values
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	q1, q3 := percentile(sorted, 25), percentile(sorted, 75)
+	low, high := q1-k*(q3-q1), q3+k*(q3-q1)
+	var kept []float64
+	for _, v := range values {
+		if v >= low && v <= high {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) < len(values) {
+		log.Lvl3("Stats: rejected", len(values)-len(kept), "outliers")
+	}
+	return kept
+}
+
+// percentile returns the p-th percentile of the sorted values, interpolating
+// between the closest ones.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	i := int(rank)
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (rank-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// Percentile returns the p-th percentile of the values, between 0 and 100.
+func (t *Value) Percentile(p float64) float64 {
+	t.Lock()
+	sorted := append([]float64{}, t.store...)
+	t.Unlock()
+	sort.Float64s(sorted)
+	return percentile(sorted, p)
+}
+
+// ConfidenceInterval returns the half-width of the confidence interval of
+// the average for the confidence level, like 0.95, with the t-distribution of
+// Student.
+func (t *Value) ConfidenceInterval(confidence float64) float64 {
+	t.Lock()
+	defer t.Unlock()
+	n := float64(len(t.store))
+	if n < 2 {
+		return math.NaN()
+	}
+	var sum, squares float64
+	for _, v := range t.store {
+		sum += v
+	}
+	mean := sum / n
+	for _, v := range t.store {
+		squares += (v - mean) * (v - mean)
+	}
+	dev := math.Sqrt(squares / (n - 1))
+	return studentQuantile((1+confidence)/2, n-1) * dev / math.Sqrt(n)
+}
+
+// rejectOutliers discards the outliers of the values.
+func (t *Value) rejectOutliers(k float64) {
+	t.Lock()
+	defer t.Unlock()
+	t.store = rejectOutliers(t.store, k)
+}
+
+// studentQuantile returns the quantile of the t-distribution with df degrees
+// of freedom, for p above 0.5.
+func studentQuantile(p, df float64) float64 {
+	low, high := 0.0, 1.0
+	for studentCDF(high, df) < p {
+		high *= 2
+	}
+	for i := 0; i < 100; i++ {
+		mid := (low + high) / 2
+		if studentCDF(mid, df) < p {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// studentCDF returns the cumulative distribution of the t-distribution with
+// df degrees of freedom, for a positive t.
+func studentCDF(t, df float64) float64 {
+	return 1 - 0.5*incompleteBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// incompleteBeta returns the regularized incomplete beta function
+// I_x(a, b), with its continued fraction.
+func incompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// the continued fraction converges faster on this side
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(1-x, b, a)/b
+	}
+	return front * betaFraction(x, a, b) / a
+}
+
+// betaFraction evaluates the continued fraction of incompleteBeta with the
+// algorithm of Lentz.
+func betaFraction(x, a, b float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+	for m := 1.0; m <= 200; m++ {
+		// the even and the odd steps
+		for _, num := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= c * d
+		}
+		if math.Abs(c*d-1) < 1e-15 {
+			break
+		}
+	}
+	return f
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStudentQuantile(t *testing.T) {
+	for _, tc := range []struct{ p, df, q float64 }{
+		{0.975, 1, 12.7062},
+		{0.975, 9, 2.2622},
+		{0.995, 4, 4.6041},
+		{0.95, 30, 1.6973},
+		{0.975, 1e6, 1.9600},
+	} {
+		require.InDelta(t, tc.q, studentQuantile(tc.p, tc.df), 1e-4)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	require.Equal(t, 1.0, percentile(sorted, 0))
+	require.Equal(t, 3.0, percentile(sorted, 50))
+	require.Equal(t, 4.6, math.Round(percentile(sorted, 90)*10)/10)
+	require.Equal(t, 5.0, percentile(sorted, 100))
+	require.True(t, math.IsNaN(percentile(nil, 50)))
+}
+
+func TestRejectOutliers(t *testing.T) {
+	values := []float64{10, 11, 9, 10, 12, 100, 10, 11}
+	require.Equal(t, []float64{10, 11, 9, 10, 12, 10, 11}, rejectOutliers(values, 1.5))
+	require.Equal(t, []float64{1, 100}, rejectOutliers([]float64{1, 100}, 1.5))
+}
+
+func TestStats_warmup(t *testing.T) {
+	s := NewStats(map[string]string{"servers": "2", "warmup": "2"})
+	// the root records a round measure 4 times, the hosts their bandwidth
+	// only once
+	for i := 1; i <= 4; i++ {
+		s.Update(newSingleMeasure("round", float64(i)))
+	}
+	s.Update(newSingleMeasureWithHost("bandwidth", 10, 0))
+	s.Update(newSingleMeasureWithHost("bandwidth", 20, 1))
+	require.Equal(t, map[string][]float64{"round": {3, 4}, "bandwidth": {10, 20}}, s.Measures())
+	s.Collect()
+	require.Equal(t, 3.5, s.Value("round").Avg())
+	require.Equal(t, 15.0, s.Value("bandwidth").Avg())
+}
+
+func TestStats_postProcessing(t *testing.T) {
+	rc := map[string]string{"servers": "2", "percentiles": `"50 90"`,
+		"confidence": "0.95", "outliers": "1.5"}
+	s := NewStats(rc)
+	for _, v := range []float64{10, 11, 9, 10, 12, 100, 10, 11} {
+		s.Update(newSingleMeasure("round", v))
+	}
+	var header, values bytes.Buffer
+	s.WriteHeader(&header)
+	s.WriteValues(&values)
+	fields := strings.Split(strings.TrimSpace(header.String()), ",")
+	require.Equal(t, []string{"confidence", "outliers", "percentiles", "servers",
+		"round_min", "round_max", "round_avg", "round_sum", "round_dev",
+		"round_p50", "round_p90", "round_ci"}, fields)
+	row := strings.Split(strings.TrimSpace(values.String()), ",")
+	require.Equal(t, len(fields), len(row))
+	// the outlier is gone
+	require.Equal(t, "12.000000", row[5])
+	require.Equal(t, "10.000000", row[9])
+	require.Equal(t, "11.400000", row[10])
+	require.Equal(t, "0.902557", row[11])
+
+	// the individual stats have the same columns
+	var individual bytes.Buffer
+	require.NoError(t, s.WriteIndividualStats(&individual))
+	row = strings.Split(strings.Split(individual.String(), "\n")[0], ",")
+	require.Equal(t, len(fields), len(row))
+}
@@ -0,0 +1,43 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the name of the measure holding a profile
+const profileName = "profile"
+
+// Profile is a pprof profile of a host during a round of the simulation,
+// read with "go tool pprof".
+type Profile struct {
+	// Kind is "cpu" or "heap"
+	Kind string
+	// Host is the address of the host
+	Host  string
+	Round int
+	Data  []byte
+}
+
+// FileName returns the name of the file of the profile, with the prefix.
+func (p *Profile) FileName(prefix string) string {
+	host := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, p.Host)
+	return fmt.Sprintf("%s-%s-%s-round%d.pprof", prefix, p.Kind, host, p.Round)
+}
+
+// RecordProfile sends the profile to the monitor.
+func RecordProfile(p *Profile) error {
+	return send(&singleMeasure{Name: profileName, Profile: p})
+}
+
+// SetProfile sets the function called with the profiles received by the
+// monitor. The profiles are dropped if it isn't set. It must be called before
+// Listen.
+func (m *Monitor) SetProfile(f func(p *Profile)) {
+	m.profile = f
+}
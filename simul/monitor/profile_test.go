@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor_SetProfile(t *testing.T) {
+	stat := NewStats(map[string]string{"servers": "1"})
+	mon := NewMonitor(stat)
+	mon.SinkPort = 0
+	profiles := make(chan *Profile, 1)
+	mon.SetProfile(func(p *Profile) {
+		profiles <- p
+	})
+	go mon.Listen()
+	defer mon.Stop()
+	port := <-mon.sinkPortChan
+	require.NoError(t, ConnectSink("localhost:"+strconv.Itoa(int(port))))
+
+	p := &Profile{Kind: "cpu", Host: "127.0.0.1:2000", Round: 3, Data: []byte{1, 2, 3}}
+	require.NoError(t, RecordProfile(p))
+	select {
+	case received := <-profiles:
+		require.Equal(t, p, received)
+	case <-time.After(time.Second):
+		require.Fail(t, "no profile")
+	}
+	EndAndCleanup()
+	require.Nil(t, stat.Value(profileName))
+}
+
+func TestProfile_FileName(t *testing.T) {
+	p := &Profile{Kind: "heap", Host: "127.0.0.1:2000", Round: 3}
+	require.Equal(t, "test_data/count-heap-127.0.0.1_2000-round3.pprof", p.FileName("test_data/count"))
+}
@@ -33,6 +33,12 @@ type Stats struct {
 
 	// The filter used to filter out abberant data
 	filter DataFilter
+	// The statistics of the output, and the warm-up: how many values came
+	// from the hosts, and the first ones, discarded for the measures
+	// recorded more than warmup times.
+	post postProcessing
+	seen map[warmupKey]int
+	held map[warmupKey][]float64
 	sync.Mutex
 }
 
@@ -50,6 +56,8 @@ func (s *Stats) init() *Stats {
 	s.keys = make([]string, 0)
 	s.static = make(map[string]string)
 	s.staticKeys = make([]string, 0)
+	s.seen = make(map[warmupKey]int)
+	s.held = make(map[warmupKey][]float64)
 	return s
 }
 
@@ -57,16 +65,33 @@ func (s *Stats) init() *Stats {
 func (s *Stats) Update(m *singleMeasure) {
 	s.Lock()
 	defer s.Unlock()
-	var value *Value
-	var ok bool
-	value, ok = s.values[m.Name]
+	value := s.value(m.Name)
+	if s.post.warmup > 0 {
+		k := warmupKey{m.Name, m.Host}
+		s.seen[k]++
+		switch {
+		case s.seen[k] <= s.post.warmup:
+			s.held[k] = append(s.held[k], m.Value)
+			return
+		case s.seen[k] == s.post.warmup+1:
+			// the first values were the warm-up
+			delete(s.held, k)
+		}
+	}
+	value.Store(m.Value)
+}
+
+// value returns the Value of the measure, created if needed. It must be
+// called with the lock.
+func (s *Stats) value(name string) *Value {
+	value, ok := s.values[name]
 	if !ok {
-		value = NewValue(m.Name)
-		s.values[m.Name] = value
-		s.keys = append(s.keys, m.Name)
+		value = NewValue(name)
+		s.values[name] = value
+		s.keys = append(s.keys, name)
 		sort.Strings(s.keys)
 	}
-	value.Store(m.Value)
+	return value
 }
 
 // WriteHeader will write the header to the writer
@@ -85,6 +110,7 @@ func (s *Stats) WriteHeader(w io.Writer) {
 	for _, k := range s.keys {
 		v := s.values[k]
 		fields = append(fields, v.HeaderFields()...)
+		fields = append(fields, s.post.headerFields(k)...)
 	}
 	fmt.Fprintf(w, "%s", strings.Join(fields, ","))
 	fmt.Fprintf(w, "\n")
@@ -107,6 +133,7 @@ func (s *Stats) WriteValues(w io.Writer) {
 	for _, k := range s.keys {
 		v := s.values[k]
 		values = append(values, v.Values()...)
+		values = append(values, s.post.values(v)...)
 	}
 	fmt.Fprintf(w, "%s", strings.Join(values, ","))
 	fmt.Fprintf(w, "\n")
@@ -149,6 +176,7 @@ func (s *Stats) WriteIndividualStats(w io.Writer) error {
 		for _, k := range s.keys {
 			v := s.values[k]
 			values = append(values, v.SingleValues(entry)...)
+			values = append(values, s.post.noValues()...)
 		}
 
 		all := append(static, values...)
@@ -173,6 +201,7 @@ func AverageStats(stats []*Stats) *Stats {
 	s := new(Stats).init()
 	stats[0].Lock()
 	s.filter = stats[0].filter
+	s.post = stats[0].post
 	s.static = stats[0].static
 	s.staticKeys = stats[0].staticKeys
 	s.keys = stats[0].keys
@@ -274,12 +303,44 @@ func (df *DataFilter) Filter(measure string, values []float64) []float64 {
 func (s *Stats) Collect() {
 	s.Lock()
 	defer s.Unlock()
+	s.releaseHeld()
 	for _, v := range s.values {
 		v.Filter(s.filter)
+		if s.post.outliers > 0 {
+			v.rejectOutliers(s.post.outliers)
+		}
 		v.Collect()
 	}
 }
 
+// releaseHeld keeps the values held for the warm-up of the measures that
+// weren't recorded more than warmup times by their host, in the order of the
+// hosts. It must be called with the lock.
+func (s *Stats) releaseHeld() {
+	for _, k := range s.heldKeys() {
+		for _, v := range s.held[k] {
+			s.values[k.name].Store(v)
+		}
+		delete(s.held, k)
+	}
+}
+
+// heldKeys returns the keys of the values held for the warm-up, sorted by
+// measure and host. It must be called with the lock.
+func (s *Stats) heldKeys() []warmupKey {
+	keys := make([]warmupKey, 0, len(s.held))
+	for k := range s.held {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].host < keys[j].host
+	})
+	return keys
+}
+
 // Value returns the value object corresponding to this name in this Stats
 func (s *Stats) Value(name string) *Value {
 	s.Lock()
@@ -341,6 +402,7 @@ func (s *Stats) readRunConfig(rc map[string]string, defaults ...string) {
 
 	// let the filter figure out itself what it is supposed to be doing
 	s.filter = NewDataFilter(rc)
+	s.post = newPostProcessing(rc)
 }
 
 // Value is used to compute the statistics
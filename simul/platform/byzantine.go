@@ -0,0 +1,73 @@
+package platform
+
+import (
+	"math/rand"
+	"strings"
+
+	"go.dedis.ch/onet/v4"
+	"golang.org/x/xerrors"
+)
+
+// byzantineConf are the adversarial servers of the simulation .toml.
+type byzantineConf struct {
+	// AdversaryRatio is the fraction of the servers that are adversarial.
+	// The root of the tree is always honest.
+	AdversaryRatio tomlFloat
+	// Adversary is the name of the behaviour of the adversarial servers,
+	// registered with onet.AdversaryRegister, "silent" by default
+	Adversary string
+	// AdversaryProtocols are the protocols where the servers are
+	// adversarial, as a semicolon separated list, all by default
+	AdversaryProtocols string
+	// AdversarySeed chooses other adversarial servers
+	AdversarySeed int64
+}
+
+// adversary returns the behaviour of the server at index own in the roster,
+// or nil if it is honest. The choice only depends on the configuration, so
+// that all the machines of a simulation agree on it.
+func (bc *byzantineConf) adversary(roster *onet.Roster, root, own int) (*onet.Adversary, error) {
+	if bc.AdversaryRatio < 0 || bc.AdversaryRatio > 1 {
+		return nil, xerrors.New("AdversaryRatio must be between 0 and 1")
+	}
+	name := bc.Adversary
+	if name == "" {
+		name = "silent"
+	}
+	a := onet.RegisteredAdversary(name)
+	if a == nil {
+		return nil, xerrors.Errorf("unknown adversary %q", name)
+	}
+	n := len(roster.List)
+	count := int(float64(bc.AdversaryRatio)*float64(n) + 0.5)
+	if count >= n {
+		count = n - 1
+	}
+	var chosen int
+	for _, i := range rand.New(rand.NewSource(bc.AdversarySeed)).Perm(n) {
+		if chosen == count {
+			break
+		}
+		if i == root {
+			continue
+		}
+		if i == own {
+			return a, nil
+		}
+		chosen++
+	}
+	return nil, nil
+}
+
+// setAdversary makes the server byzantine in the protocols of the
+// configuration. It stays honest in the protocol ending the simulation.
+func (bc *byzantineConf) setAdversary(server *onet.Server, a *onet.Adversary) {
+	protocols := strings.Split(bc.AdversaryProtocols, ";")
+	for _, p := range protocols {
+		p = strings.TrimSpace(p)
+		if p != "" || len(protocols) == 1 {
+			server.SetAdversary(p, a)
+		}
+	}
+	server.SetAdversary("CloseAll", &onet.Adversary{})
+}
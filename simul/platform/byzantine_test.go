@@ -0,0 +1,34 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+)
+
+func TestByzantineConf_adversary(t *testing.T) {
+	roster := onet.NewRoster(genLinkServers(10))
+	count := func(bc *byzantineConf, root int) int {
+		var n int
+		for i := range roster.List {
+			a, err := bc.adversary(roster, root, i)
+			require.NoError(t, err)
+			if a != nil {
+				require.NotEqual(t, root, i)
+				require.Equal(t, onet.SilentAdversary, a)
+				n++
+			}
+		}
+		return n
+	}
+	require.Equal(t, 0, count(&byzantineConf{}, 0))
+	require.Equal(t, 3, count(&byzantineConf{AdversaryRatio: 0.3}, 0))
+	require.Equal(t, 3, count(&byzantineConf{AdversaryRatio: 0.3, AdversarySeed: 5}, 4))
+	require.Equal(t, 9, count(&byzantineConf{AdversaryRatio: 1}, 0))
+
+	_, err := (&byzantineConf{AdversaryRatio: 2}).adversary(roster, 0, 1)
+	require.Error(t, err)
+	_, err = (&byzantineConf{Adversary: "unknown"}).adversary(roster, 0, 1)
+	require.Error(t, err)
+}
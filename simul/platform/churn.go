@@ -0,0 +1,255 @@
+package platform
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"go.dedis.ch/onet/v4/simul/monitor"
+	"golang.org/x/xerrors"
+)
+
+// churnConf is the churn of the simulation .toml: while the rounds run, the
+// servers leave or crash, and come back later. The root of the tree stays.
+type churnConf struct {
+	// ChurnLeaveRate is how often an online server leaves, per second
+	ChurnLeaveRate tomlFloat
+	// ChurnCrashRate is how often an online server crashes, per second
+	ChurnCrashRate tomlFloat
+	// ChurnJoinRate is how often an offline server comes back, per second.
+	// Without it, the servers don't come back.
+	ChurnJoinRate tomlFloat
+	// ChurnSession is the distribution of the time a server stays online,
+	// which replaces the leave and crash rates. It is one of "exp:mean",
+	// "const:time", "uniform:min:max", "pareto:scale:shape" or
+	// "weibull:scale:shape", like "exp:30s" or "pareto:10s:1.5".
+	ChurnSession string
+	// ChurnDowntime is the distribution of the time a server stays
+	// offline, which replaces the join rate.
+	ChurnDowntime string
+	// ChurnCrash is the probability that a server crashes at the end of a
+	// ChurnSession, instead of leaving.
+	ChurnCrash tomlFloat
+	// ChurnSeed makes the churn the same in every run if it is not 0.
+	ChurnSeed int64
+}
+
+// churnModel describes when the servers go offline and come back.
+type churnModel struct {
+	session  *distribution
+	downtime *distribution
+	// probability that a server crashes instead of leaving
+	crash float64
+	seed  int64
+}
+
+// churnModel returns the churn of the configuration, or nil if there is
+// none.
+func (cc *churnConf) churnModel() (*churnModel, error) {
+	m := &churnModel{crash: float64(cc.ChurnCrash), seed: cc.ChurnSeed}
+	var err error
+	if cc.ChurnSession != "" {
+		if m.session, err = parseDistribution(cc.ChurnSession); err != nil {
+			return nil, xerrors.Errorf("ChurnSession: %v", err)
+		}
+	} else if rate := float64(cc.ChurnLeaveRate + cc.ChurnCrashRate); rate > 0 {
+		m.session = &distribution{kind: "exp", a: 1 / rate}
+		m.crash = float64(cc.ChurnCrashRate) / rate
+	} else {
+		return nil, nil
+	}
+	if cc.ChurnDowntime != "" {
+		if m.downtime, err = parseDistribution(cc.ChurnDowntime); err != nil {
+			return nil, xerrors.Errorf("ChurnDowntime: %v", err)
+		}
+	} else if cc.ChurnJoinRate > 0 {
+		m.downtime = &distribution{kind: "exp", a: 1 / float64(cc.ChurnJoinRate)}
+	}
+	if m.crash < 0 || m.crash > 1 {
+		return nil, xerrors.New("ChurnCrash must be between 0 and 1")
+	}
+	return m, nil
+}
+
+// distribution of durations, with the parameters in seconds
+type distribution struct {
+	kind string
+	a, b float64
+}
+
+// parseDistribution parses "kind:param[:param]".
+func parseDistribution(s string) (*distribution, error) {
+	parts := strings.Split(s, ":")
+	d := &distribution{kind: parts[0]}
+	params := 2
+	switch d.kind {
+	case "exp", "const":
+		params = 1
+	case "uniform", "pareto", "weibull":
+	default:
+		return nil, xerrors.Errorf("unknown distribution %q", d.kind)
+	}
+	if len(parts) != params+1 {
+		return nil, xerrors.Errorf("%s needs %d parameters", d.kind, params)
+	}
+	dur, err := time.ParseDuration(parts[1])
+	if err != nil || dur <= 0 {
+		return nil, xerrors.Errorf("wrong duration %q", parts[1])
+	}
+	d.a = dur.Seconds()
+	switch d.kind {
+	case "uniform":
+		dur, err = time.ParseDuration(parts[2])
+		if err != nil || dur.Seconds() < d.a {
+			return nil, xerrors.Errorf("wrong maximum %q", parts[2])
+		}
+		d.b = dur.Seconds()
+	case "pareto", "weibull":
+		d.b, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil || d.b <= 0 {
+			return nil, xerrors.Errorf("wrong shape %q", parts[2])
+		}
+	}
+	return d, nil
+}
+
+// sample returns a random duration of the distribution.
+func (d *distribution) sample(r *rand.Rand) time.Duration {
+	var s float64
+	switch d.kind {
+	case "exp":
+		s = r.ExpFloat64() * d.a
+	case "const":
+		s = d.a
+	case "uniform":
+		s = d.a + r.Float64()*(d.b-d.a)
+	case "pareto":
+		s = d.a / math.Pow(1-r.Float64(), 1/d.b)
+	case "weibull":
+		s = d.a * math.Pow(-math.Log(1-r.Float64()), 1/d.b)
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// churner makes a server leave, crash and come back according to the model.
+type churner struct {
+	server *onet.Server
+	model  *churnModel
+	// index of the server in the roster, for the monitor
+	host int
+	// the messages that still pass while offline
+	allowed []network.MessageTypeID
+	rand    *rand.Rand
+	stopped chan struct{}
+	done    chan struct{}
+	// whether run has been started, and whether stop has been called
+	started  bool
+	stopping bool
+	sync.Mutex
+}
+
+func newChurner(server *onet.Server, model *churnModel, host int,
+	allowed ...network.MessageTypeID) *churner {
+	seed := time.Now().UnixNano()
+	if model.seed != 0 {
+		seed = model.seed
+	}
+	return &churner{
+		server:  server,
+		model:   model,
+		host:    host,
+		allowed: allowed,
+		rand:    rand.New(rand.NewSource(seed + int64(host))),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// start lets the churn begin, unless it is already stopped.
+func (c *churner) start() {
+	c.Lock()
+	defer c.Unlock()
+	if c.started || c.stopping {
+		return
+	}
+	c.started = true
+	go c.run()
+}
+
+// run goes through the sessions of the server until stop is called.
+func (c *churner) run() {
+	defer close(c.done)
+	defer c.server.Router.SetOnline()
+	for {
+		session := c.model.session.sample(c.rand)
+		if !c.sleep(session) {
+			return
+		}
+		if c.rand.Float64() < c.model.crash {
+			log.Lvl2(c.server.ServerIdentity, "crashes after", session)
+			c.server.Router.SetOffline(false, c.allowed...)
+			c.killProtocols()
+			monitor.RecordSingleMeasureWithHost("churn_crash", session.Seconds(), c.host)
+		} else {
+			log.Lvl2(c.server.ServerIdentity, "leaves after", session)
+			c.server.Router.SetOffline(true, c.allowed...)
+			monitor.RecordSingleMeasureWithHost("churn_leave", session.Seconds(), c.host)
+		}
+		if c.model.downtime == nil {
+			<-c.stopped
+			return
+		}
+		downtime := c.model.downtime.sample(c.rand)
+		if !c.sleep(downtime) {
+			return
+		}
+		log.Lvl2(c.server.ServerIdentity, "joins after", downtime)
+		c.server.Router.SetOnline()
+		monitor.RecordSingleMeasureWithHost("churn_join", downtime.Seconds(), c.host)
+	}
+}
+
+// sleep returns false if the churner is stopped before d is over.
+func (c *churner) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-c.stopped:
+		return false
+	}
+}
+
+// killProtocols removes the protocol instances of the server, which lose
+// their state when it crashes.
+func (c *churner) killProtocols() {
+	status, err := c.server.DetailedStatus()
+	if err != nil {
+		log.Error("Couldn't get the protocols:", err)
+		return
+	}
+	for _, p := range status.Protocols {
+		if err := c.server.KillProtocol(p.Token); err != nil {
+			log.Lvl3("Couldn't kill protocol:", err)
+		}
+	}
+}
+
+// stop ends the churn and waits for the server to be back online.
+func (c *churner) stop() {
+	c.Lock()
+	if !c.stopping {
+		c.stopping = true
+		close(c.stopped)
+	}
+	started := c.started
+	c.Unlock()
+	if started {
+		<-c.done
+	}
+}
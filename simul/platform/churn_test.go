@@ -0,0 +1,95 @@
+package platform
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/suites"
+	"go.dedis.ch/onet/v4"
+)
+
+func TestChurnConf_churnModel(t *testing.T) {
+	cfg := &conf{}
+	m, err := cfg.churnModel()
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	_, err = toml.Decode("churnleaverate = 1\nchurncrashrate = 3\nchurnjoinrate = 0.5\n", cfg)
+	require.NoError(t, err)
+	m, err = cfg.churnModel()
+	require.NoError(t, err)
+	require.Equal(t, &distribution{kind: "exp", a: 0.25}, m.session)
+	require.Equal(t, &distribution{kind: "exp", a: 2}, m.downtime)
+	require.Equal(t, 0.75, m.crash)
+
+	cfg = &conf{}
+	_, err = toml.Decode(`churnsession = "pareto:10s:1.5"
+churncrash = 0.2
+`, cfg)
+	require.NoError(t, err)
+	m, err = cfg.churnModel()
+	require.NoError(t, err)
+	require.Equal(t, &distribution{kind: "pareto", a: 10, b: 1.5}, m.session)
+	require.Nil(t, m.downtime)
+	require.Equal(t, 0.2, m.crash)
+
+	cfg.ChurnCrash = 2
+	_, err = cfg.churnModel()
+	require.Error(t, err)
+	for _, d := range []string{"normal:1s", "exp", "exp:1s:2s", "const:soon",
+		"uniform:2s:1s", "weibull:1s:-1", "exp:-1s"} {
+		_, err = parseDistribution(d)
+		require.Error(t, err, d)
+	}
+}
+
+func TestDistribution_sample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, s := range []string{"exp:1s", "const:1s", "uniform:1s:2s", "pareto:1s:2", "weibull:1s:2"} {
+		d, err := parseDistribution(s)
+		require.NoError(t, err)
+		var sum time.Duration
+		for i := 0; i < 1000; i++ {
+			v := d.sample(r)
+			require.True(t, v >= 0, s)
+			if d.kind == "uniform" || d.kind == "pareto" {
+				require.True(t, v >= time.Second, s)
+			}
+			sum += v
+		}
+		// all the means are between 0.8s and 2s
+		mean := sum / 1000
+		require.True(t, mean > 800*time.Millisecond && mean < 2*time.Second, s, mean)
+	}
+}
+
+func TestChurner(t *testing.T) {
+	local := onet.NewTCPTest(suites.MustFind("Ed25519"))
+	defer local.CloseAll()
+	server := local.GenServers(1)[0]
+
+	model := &churnModel{
+		session:  &distribution{kind: "const", a: 0.05},
+		downtime: &distribution{kind: "const", a: 0.05},
+		crash:    1,
+	}
+	c := newChurner(server, model, 0)
+	// the churn doesn't start after it has been stopped
+	c.stop()
+	c.start()
+	require.False(t, server.Router.Offline())
+
+	c = newChurner(server, model, 0)
+	c.start()
+	offline := false
+	for i := 0; i < 100 && !offline; i++ {
+		time.Sleep(5 * time.Millisecond)
+		offline = server.Router.Offline()
+	}
+	require.True(t, offline)
+	c.stop()
+	require.False(t, server.Router.Offline())
+}
@@ -0,0 +1,598 @@
+// Cloud is the platform that runs a simulation on a fleet of virtual
+// machines it rents from a cloud provider. The fleet is created through the
+// command line tools of the provider, `aws` or `gcloud`, which have to be
+// installed and logged in, and it is deleted again after every run.
+
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/app"
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/simul/monitor"
+	"golang.org/x/xerrors"
+)
+
+// how long to wait for the ssh of a new machine
+const cloudSSHTimeout = 5 * time.Minute
+
+// cloudRun runs the tools of the providers and returns their output. It is
+// a variable so that the tests don't need an account.
+var cloudRun = func(name string, args ...string) ([]byte, error) {
+	log.Lvl3("Running", name, args)
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, xerrors.Errorf("%s: %v: %s", name, err, ee.Stderr)
+		}
+		return nil, xerrors.Errorf("%s: %v", name, err)
+	}
+	return out, nil
+}
+
+// cloudVM is one machine of the fleet.
+type cloudVM struct {
+	Name string
+	// PublicIP is used to copy the files and start the simulation
+	PublicIP string
+	// PrivateIP is used between the machines
+	PrivateIP string
+}
+
+// cloudProvider creates and deletes the machines of a fleet.
+type cloudProvider interface {
+	// create starts n machines labelled with the fleet and returns them
+	// once they are running.
+	create(fleet string, n int) ([]cloudVM, error)
+	// destroy deletes all the machines labelled with the fleet.
+	destroy(fleet string) error
+}
+
+// Cloud holds the configuration of a simulation on cloud machines.
+type Cloud struct {
+	// Need mutex because build.go has a global variable that
+	// is used for multiple experiments
+	sync.Mutex
+
+	// Provider is "aws" or "gcp"
+	Provider string
+	// Region for aws, or zone for gcp, of the machines
+	Region string
+	// Project of gcp
+	Project string
+	// InstanceType is the type of the machines, like "t3.medium" for aws
+	// or "e2-medium" for gcp
+	InstanceType string
+	// Image is the AMI for aws, or the image family for gcp
+	Image string
+	// ImageProject is the project of the image family for gcp
+	ImageProject string
+	// KeyName is the ssh key pair of aws
+	KeyName string
+	// SecurityGroup of aws, it must allow ssh and the traffic between the
+	// machines
+	SecurityGroup string
+	// Login to use for ssh
+	Login string
+	// Arch of the machines, amd64 by default
+	Arch string
+	// Price of one machine per hour, to report the cost of the runs
+	Price float64
+	// Fleet labels the machines, "onet-<simulation>" by default
+	Fleet string
+
+	// Simulation to be run
+	Simulation string
+	// Number of machines
+	Servers int
+	// Debugging-level: 0 is none - 5 is everything
+	Debug int
+	// The time to wait for the simulation to finish
+	RunWait string
+	// Suite used for the simulation
+	Suite string
+	// PreScript is run on each machine before the simulation is started
+	PreScript string
+	// Tags to use when compiling
+	Tags string
+	// Port number of the monitor
+	MonitorPort int
+
+	provider cloudProvider
+	// Directory we start - the simulation-directory of the service/protocol
+	wd string
+	// Directory for building
+	buildDir string
+	// Directory for deploying
+	deployDir string
+	// The machines of the current run
+	vms []cloudVM
+	// When the machines of the current run have been created
+	created time.Time
+	// WaitGroup and errors of the running simulations
+	wgRun   sync.WaitGroup
+	errChan chan error
+}
+
+// Configure implements the Platform-interface. It is called once to set up
+// the necessary internal variables.
+func (c *Cloud) Configure(pc *Config) {
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	c.wd, _ = os.Getwd()
+	c.buildDir = c.wd + "/build"
+	c.deployDir = c.wd + "/deploy"
+	c.Suite = pc.Suite
+	c.Debug = pc.Debug
+	c.MonitorPort = pc.MonitorPort
+	if c.Simulation == "" {
+		log.Fatal("No simulation defined in runconfig")
+	}
+	if c.Fleet == "" {
+		c.Fleet = "onet-" + strings.ToLower(c.Simulation)
+	}
+	if c.Arch == "" {
+		c.Arch = "amd64"
+	}
+	c.provider, err = c.newProvider()
+	log.ErrFatal(err)
+
+	// Clean the build- and deploy-dir, then (re-)create them
+	for _, dir := range []string{c.buildDir, c.deployDir} {
+		os.RemoveAll(dir)
+		log.ErrFatal(os.Mkdir(dir, 0770))
+	}
+}
+
+// newProvider returns the provider of the configuration.
+func (c *Cloud) newProvider() (cloudProvider, error) {
+	switch c.Provider {
+	case "aws":
+		if c.Login == "" {
+			c.Login = "admin"
+		}
+		return &awsProvider{region: c.Region, instanceType: c.InstanceType,
+			image: c.Image, keyName: c.KeyName, securityGroup: c.SecurityGroup}, nil
+	case "gcp":
+		if c.Login == "" {
+			c.Login = "onet"
+		}
+		return &gcpProvider{project: c.Project, zone: c.Region,
+			machineType: c.InstanceType, imageFamily: c.Image,
+			imageProject: c.ImageProject}, nil
+	default:
+		return nil, xerrors.Errorf("unknown provider %q, use aws or gcp", c.Provider)
+	}
+}
+
+// Build implements the Platform interface: it compiles the simulation for
+// the machines.
+func (c *Cloud) Build(build string, arg ...string) error {
+	log.Lvl1("Building for", c.Provider, c.Arch)
+	start := time.Now()
+	var tags []string
+	if c.Tags != "" {
+		tags = append([]string{"-tags"}, strings.Split(c.Tags, " ")...)
+	}
+	out, err := Build(".", c.buildDir+"/conode", c.Arch, "linux", append(arg, tags...)...)
+	if err != nil {
+		return xerrors.Errorf(err.Error() + " " + out)
+	}
+	log.Lvl1("Build is finished after", time.Since(start))
+	return nil
+}
+
+// Cleanup deletes all machines of the fleet, also the ones left over by a
+// former run that didn't finish.
+func (c *Cloud) Cleanup() error {
+	log.Lvl2("Deleting the machines of", c.Fleet)
+	if err := c.provider.destroy(c.Fleet); err != nil {
+		return xerrors.Errorf("deleting fleet: %v", err)
+	}
+	return nil
+}
+
+// Deploy creates the machines, then writes the configuration of the
+// simulation and copies it to them together with the binary.
+func (c *Cloud) Deploy(rc *RunConfig) error {
+	c.Lock()
+	defer c.Unlock()
+
+	// Check for PreScript and copy it to the deploy-dir
+	c.PreScript = rc.Get("PreScript")
+	if c.PreScript != "" {
+		if err := app.Copy(c.deployDir, c.PreScript); err != nil {
+			return xerrors.Errorf("copying: %v", err)
+		}
+	}
+	if err := app.Copy(c.deployDir, c.buildDir+"/conode"); err != nil {
+		return xerrors.Errorf("copying: %v", err)
+	}
+
+	servers, err := rc.GetInt("servers")
+	if err == nil {
+		c.Servers = servers
+	}
+	if c.Servers < 1 {
+		c.Servers = 1
+	}
+	log.Lvl1("Creating", c.Servers, "machines on", c.Provider)
+	c.created = time.Now()
+	c.vms, err = c.provider.create(c.Fleet, c.Servers)
+	if err != nil {
+		return xerrors.Errorf("creating fleet: %v", err)
+	}
+	if err := c.setup(rc); err != nil {
+		if errCleanup := c.provider.destroy(c.Fleet); errCleanup != nil {
+			log.Error("Couldn't delete the machines - they might still be billed:", errCleanup)
+		}
+		return err
+	}
+	log.Lvl2("Cloud: Done deploying")
+	return nil
+}
+
+// setup writes the simulation to the deploy directory and copies it to
+// the new machines.
+func (c *Cloud) setup(rc *RunConfig) error {
+	if err := c.waitSSH(); err != nil {
+		return xerrors.Errorf("waiting for machines: %v", err)
+	}
+
+	sim, err := onet.NewSimulation(c.Simulation, string(rc.Toml()))
+	if err != nil {
+		return xerrors.Errorf("simulation error: %v", err)
+	}
+	addresses := make([]string, len(c.vms))
+	for i, vm := range c.vms {
+		addresses[i] = vm.PrivateIP
+	}
+	sc, err := sim.Setup(c.deployDir, addresses)
+	if err != nil {
+		return xerrors.Errorf("simulation setup: %v", err)
+	}
+	sc.Config = string(rc.Toml())
+	if err := sc.Save(c.deployDir); err != nil {
+		return xerrors.Errorf("saving folder: %v", err)
+	}
+
+	log.Lvl2("Copying the simulation to the machines")
+	err = c.forEach(func(vm cloudVM) error {
+		return Rsync(c.Login, vm.PublicIP, c.deployDir+"/", "remote/")
+	})
+	if err != nil {
+		return xerrors.Errorf("copying files: %v", err)
+	}
+	return nil
+}
+
+// Start runs the simulation on all machines and returns. The monitor of
+// the machines is forwarded through their ssh connections.
+func (c *Cloud) Start(args ...string) error {
+	c.Lock()
+	defer c.Unlock()
+	// The cost is sent to the monitor once the machines are gone.
+	err := monitor.ConnectSink("localhost:" + strconv.Itoa(c.MonitorPort))
+	if err != nil {
+		return xerrors.Errorf("monitor: %v", err)
+	}
+
+	c.errChan = make(chan error, len(c.vms)+1)
+	c.wgRun.Add(len(c.vms))
+	for _, vm := range c.vms {
+		go func(vm cloudVM) {
+			defer c.wgRun.Done()
+			if err := c.run(vm); err != nil {
+				log.Error("Error running on", vm.Name, ":", err)
+				c.errChan <- err
+			}
+		}(vm)
+	}
+	return nil
+}
+
+// run starts the simulation on the machine and returns when it is done.
+func (c *Cloud) run(vm cloudVM) error {
+	port := strconv.Itoa(c.MonitorPort)
+	command := fmt.Sprintf("./conode -address %s -simul %s -monitor localhost:%s -suite %s -debug %d",
+		vm.PrivateIP, c.Simulation, port, c.Suite, c.Debug)
+	if c.PreScript != "" {
+		command = "./" + filepath.Base(c.PreScript) + " cloud && " + command
+	}
+	cmd := exec.Command("ssh", "-o", "StrictHostKeyChecking=no", "-o", "ExitOnForwardFailure=yes",
+		"-R", port+":localhost:"+port, c.Login+"@"+vm.PublicIP, "cd remote; "+command)
+	log.Lvl3("Starting", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return xerrors.Errorf("ssh: %v: %s", err, out)
+	}
+	return nil
+}
+
+// Wait waits for the simulation to finish, deletes the machines and sends
+// their cost to the monitor.
+func (c *Cloud) Wait() error {
+	c.Lock()
+	defer c.Unlock()
+	log.Lvl3("Waiting for the machines to finish")
+
+	wait, err := time.ParseDuration(c.RunWait)
+	if err != nil || wait == 0 {
+		wait = 600 * time.Second
+		err = nil
+	}
+	go func() {
+		c.wgRun.Wait()
+		c.errChan <- nil
+	}()
+	select {
+	case err = <-c.errChan:
+		log.Lvl3("Finished waiting for machines:", err)
+	case <-time.After(wait):
+		log.Lvl1("Quitting after waiting", wait)
+	}
+
+	if errCleanup := c.Cleanup(); errCleanup != nil {
+		log.Error("Couldn't delete the machines - they might still be billed:", errCleanup)
+	}
+	hours, cost := cloudCost(len(c.vms), time.Since(c.created), c.Price)
+	log.Lvlf1("The fleet ran for %.2f machine-hours and cost %.2f", hours, cost)
+	monitor.RecordSingleMeasure("machine_hours", hours)
+	monitor.RecordSingleMeasure("cost", cost)
+	monitor.EndAndCleanup()
+	c.vms = nil
+	return err
+}
+
+// waitSSH returns once all machines accept ssh connections.
+func (c *Cloud) waitSSH() error {
+	return c.forEach(func(vm cloudVM) error {
+		deadline := time.Now().Add(cloudSSHTimeout)
+		for {
+			_, err := SSHRun(c.Login, vm.PublicIP, "true")
+			if err == nil {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return xerrors.Errorf("ssh of %s: %v", vm.Name, err)
+			}
+			time.Sleep(5 * time.Second)
+		}
+	})
+}
+
+// forEach calls f for all machines in parallel and returns the first error.
+func (c *Cloud) forEach(f func(vm cloudVM) error) error {
+	errs := make(chan error, len(c.vms))
+	for _, vm := range c.vms {
+		go func(vm cloudVM) {
+			errs <- f(vm)
+		}(vm)
+	}
+	var err error
+	for range c.vms {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// cloudCost returns the machine-hours of the fleet and their cost. The
+// providers bill at least a minute per machine.
+func cloudCost(machines int, d time.Duration, price float64) (float64, float64) {
+	if d < time.Minute {
+		d = time.Minute
+	}
+	hours := float64(machines) * d.Hours()
+	return hours, hours * price
+}
+
+// awsProvider creates the machines on EC2.
+type awsProvider struct {
+	region        string
+	instanceType  string
+	image         string
+	keyName       string
+	securityGroup string
+}
+
+// the part of the replies of the aws tool that is used
+type awsReservations struct {
+	Reservations []struct {
+		Instances []awsInstance
+	}
+	Instances []awsInstance
+}
+
+type awsInstance struct {
+	InstanceID       string `json:"InstanceId"`
+	PublicIpAddress  string
+	PrivateIpAddress string
+}
+
+func (a *awsProvider) aws(args ...string) ([]byte, error) {
+	args = append(args, "--output", "json")
+	if a.region != "" {
+		args = append(args, "--region", a.region)
+	}
+	return cloudRun("aws", append([]string{"ec2"}, args...)...)
+}
+
+func (a *awsProvider) create(fleet string, n int) ([]cloudVM, error) {
+	args := []string{"run-instances", "--count", strconv.Itoa(n),
+		"--image-id", a.image, "--instance-type", a.instanceType,
+		"--tag-specifications", "ResourceType=instance,Tags=[{Key=onet-simul,Value=" + fleet + "}]"}
+	if a.keyName != "" {
+		args = append(args, "--key-name", a.keyName)
+	}
+	if a.securityGroup != "" {
+		args = append(args, "--security-group-ids", a.securityGroup)
+	}
+	out, err := a.aws(args...)
+	if err != nil {
+		return nil, err
+	}
+	var started awsReservations
+	if err := json.Unmarshal(out, &started); err != nil {
+		return nil, xerrors.Errorf("decoding run-instances: %v", err)
+	}
+	ids := make([]string, len(started.Instances))
+	for i, inst := range started.Instances {
+		ids[i] = inst.InstanceID
+	}
+	if len(ids) != n {
+		return nil, xerrors.Errorf("got %d instead of %d instances", len(ids), n)
+	}
+	if _, err := a.aws(append([]string{"wait", "instance-running", "--instance-ids"}, ids...)...); err != nil {
+		return nil, err
+	}
+	out, err = a.aws(append([]string{"describe-instances", "--instance-ids"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseAWSInstances(out)
+}
+
+func (a *awsProvider) destroy(fleet string) error {
+	out, err := a.aws("describe-instances", "--filters", "Name=tag:onet-simul,Values="+fleet,
+		"Name=instance-state-name,Values=pending,running,stopping,stopped")
+	if err != nil {
+		return err
+	}
+	vms, err := parseAWSInstances(out)
+	if err != nil {
+		return err
+	}
+	if len(vms) == 0 {
+		return nil
+	}
+	ids := []string{"terminate-instances", "--instance-ids"}
+	for _, vm := range vms {
+		ids = append(ids, vm.Name)
+	}
+	_, err = a.aws(ids...)
+	return err
+}
+
+// parseAWSInstances returns the machines of the reply of
+// describe-instances, named by their instance id.
+func parseAWSInstances(out []byte) ([]cloudVM, error) {
+	var reply awsReservations
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return nil, xerrors.Errorf("decoding describe-instances: %v", err)
+	}
+	var vms []cloudVM
+	for _, r := range reply.Reservations {
+		for _, inst := range r.Instances {
+			vms = append(vms, cloudVM{Name: inst.InstanceID,
+				PublicIP: inst.PublicIpAddress, PrivateIP: inst.PrivateIpAddress})
+		}
+	}
+	return vms, nil
+}
+
+// gcpProvider creates the machines on Compute Engine.
+type gcpProvider struct {
+	project      string
+	zone         string
+	machineType  string
+	imageFamily  string
+	imageProject string
+}
+
+// the part of the replies of the gcloud tool that is used
+type gcpInstance struct {
+	Name              string
+	NetworkInterfaces []struct {
+		NetworkIP     string
+		AccessConfigs []struct {
+			NatIP string
+		}
+	}
+}
+
+func (g *gcpProvider) gcloud(args ...string) ([]byte, error) {
+	args = append([]string{"compute", "instances"}, args...)
+	if g.project != "" {
+		args = append(args, "--project", g.project)
+	}
+	return cloudRun("gcloud", args...)
+}
+
+func (g *gcpProvider) create(fleet string, n int) ([]cloudVM, error) {
+	args := []string{"create"}
+	for i := 1; i <= n; i++ {
+		args = append(args, fmt.Sprintf("%s-%d", fleet, i))
+	}
+	args = append(args, "--zone", g.zone, "--machine-type", g.machineType,
+		"--labels", "onet-simul="+fleet, "--format", "json")
+	if g.imageFamily != "" {
+		args = append(args, "--image-family", g.imageFamily)
+	}
+	if g.imageProject != "" {
+		args = append(args, "--image-project", g.imageProject)
+	}
+	out, err := g.gcloud(args...)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := parseGCPInstances(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) != n {
+		return nil, xerrors.Errorf("got %d instead of %d instances", len(vms), n)
+	}
+	return vms, nil
+}
+
+func (g *gcpProvider) destroy(fleet string) error {
+	out, err := g.gcloud("list", "--filter", "labels.onet-simul="+fleet, "--format", "json")
+	if err != nil {
+		return err
+	}
+	vms, err := parseGCPInstances(out)
+	if err != nil {
+		return err
+	}
+	if len(vms) == 0 {
+		return nil
+	}
+	args := []string{"delete"}
+	for _, vm := range vms {
+		args = append(args, vm.Name)
+	}
+	_, err = g.gcloud(append(args, "--zone", g.zone, "--quiet")...)
+	return err
+}
+
+// parseGCPInstances returns the machines of the json replies of gcloud.
+func parseGCPInstances(out []byte) ([]cloudVM, error) {
+	var reply []gcpInstance
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return nil, xerrors.Errorf("decoding instances: %v", err)
+	}
+	vms := make([]cloudVM, len(reply))
+	for i, inst := range reply {
+		vms[i].Name = inst.Name
+		if len(inst.NetworkInterfaces) > 0 {
+			ni := inst.NetworkInterfaces[0]
+			vms[i].PrivateIP = ni.NetworkIP
+			if len(ni.AccessConfigs) > 0 {
+				vms[i].PublicIP = ni.AccessConfigs[0].NatIP
+			}
+		}
+	}
+	return vms, nil
+}
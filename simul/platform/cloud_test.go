@@ -0,0 +1,98 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloud replaces the tools of the providers with the replies in the
+// map, by name of the command.
+func fakeCloud(t *testing.T, replies map[string]string) (*[]string, func()) {
+	var calls []string
+	old := cloudRun
+	cloudRun = func(name string, args ...string) ([]byte, error) {
+		call := name + " " + strings.Join(args, " ")
+		calls = append(calls, call)
+		for cmd, reply := range replies {
+			if strings.HasPrefix(call, cmd) {
+				return []byte(reply), nil
+			}
+		}
+		t.Fatal("unexpected command", call)
+		return nil, nil
+	}
+	return &calls, func() { cloudRun = old }
+}
+
+const awsDescribe = `{"Reservations": [{"Instances": [
+	{"InstanceId": "i-1", "PublicIpAddress": "1.2.3.4", "PrivateIpAddress": "10.0.0.1"},
+	{"InstanceId": "i-2", "PublicIpAddress": "1.2.3.5", "PrivateIpAddress": "10.0.0.2"}]}]}`
+
+func TestCloud_aws(t *testing.T) {
+	calls, restore := fakeCloud(t, map[string]string{
+		"aws ec2 run-instances":      `{"Instances": [{"InstanceId": "i-1"}, {"InstanceId": "i-2"}]}`,
+		"aws ec2 wait":               "",
+		"aws ec2 describe-instances": awsDescribe,
+		"aws ec2 terminate":          "{}",
+	})
+	defer restore()
+
+	c := &Cloud{Provider: "aws", Region: "eu-west-1", InstanceType: "t3.micro"}
+	p, err := c.newProvider()
+	require.NoError(t, err)
+	require.Equal(t, "admin", c.Login)
+	vms, err := p.create("onet-test", 2)
+	require.NoError(t, err)
+	require.Equal(t, []cloudVM{{"i-1", "1.2.3.4", "10.0.0.1"}, {"i-2", "1.2.3.5", "10.0.0.2"}}, vms)
+	require.Contains(t, (*calls)[0], "--count 2")
+	require.Contains(t, (*calls)[0], "Value=onet-test")
+	require.Contains(t, (*calls)[0], "--region eu-west-1")
+	_, err = p.create("onet-test", 3)
+	require.Error(t, err)
+
+	*calls = nil
+	require.NoError(t, p.destroy("onet-test"))
+	require.Equal(t, 2, len(*calls))
+	require.Contains(t, (*calls)[0], "Name=tag:onet-simul,Values=onet-test")
+	require.Contains(t, (*calls)[1], "terminate-instances --instance-ids i-1 i-2")
+}
+
+func TestCloud_gcp(t *testing.T) {
+	list := `[{"name": "onet-test-1", "networkInterfaces": [
+		{"networkIP": "10.0.0.1", "accessConfigs": [{"natIP": "1.2.3.4"}]}]}]`
+	calls, restore := fakeCloud(t, map[string]string{
+		"gcloud compute instances create": list,
+		"gcloud compute instances list":   list,
+		"gcloud compute instances delete": "",
+	})
+	defer restore()
+
+	c := &Cloud{Provider: "gcp", Region: "europe-west6-a", Project: "simul"}
+	p, err := c.newProvider()
+	require.NoError(t, err)
+	vms, err := p.create("onet-test", 1)
+	require.NoError(t, err)
+	require.Equal(t, []cloudVM{{"onet-test-1", "1.2.3.4", "10.0.0.1"}}, vms)
+	require.Contains(t, (*calls)[0], "create onet-test-1 --zone europe-west6-a")
+	require.Contains(t, (*calls)[0], "--labels onet-simul=onet-test")
+	require.Contains(t, (*calls)[0], "--project simul")
+
+	*calls = nil
+	require.NoError(t, p.destroy("onet-test"))
+	require.Contains(t, (*calls)[1], "delete onet-test-1 --zone europe-west6-a --quiet")
+
+	c.Provider = "azure"
+	_, err = c.newProvider()
+	require.Error(t, err)
+}
+
+func TestCloudCost(t *testing.T) {
+	hours, cost := cloudCost(4, 30*time.Minute, 0.1)
+	require.InDelta(t, 2, hours, 1e-9)
+	require.InDelta(t, 0.2, cost, 1e-9)
+	hours, _ = cloudCost(60, time.Second, 0)
+	require.InDelta(t, 1, hours, 1e-9)
+}
@@ -0,0 +1,31 @@
+package platform
+
+import "runtime"
+
+// cpuConf shares the CPUs of a machine between its servers in the
+// simulation .toml, so that the crypto of a server doesn't distort the
+// latencies measured by the others.
+type cpuConf struct {
+	// CPUsPerHost pins the goroutines of each server giving the messages to
+	// its protocol instances to CPUsPerHost CPUs of its own, as with
+	// onet.Server.SetCPUAffinity. The servers share the CPUs if there are
+	// not enough of them. It only works on Linux.
+	CPUsPerHost int
+	// GOMAXPROCS is how many threads at most run Go code at the same time
+	// in the process of the servers of a machine, all the CPUs if it is 0.
+	GOMAXPROCS int
+}
+
+// hostCPUs returns the CPUs of the server at the index of the servers of
+// the process, or nil if they are not pinned.
+func (cc *cpuConf) hostCPUs(host int) []int {
+	if cc.CPUsPerHost <= 0 {
+		return nil
+	}
+	n := runtime.NumCPU()
+	cpus := make([]int, 0, cc.CPUsPerHost)
+	for i := 0; i < cc.CPUsPerHost && i < n; i++ {
+		cpus = append(cpus, (host*cc.CPUsPerHost+i)%n)
+	}
+	return cpus
+}
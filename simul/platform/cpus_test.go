@@ -0,0 +1,23 @@
+package platform
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUConf_hostCPUs(t *testing.T) {
+	cc := cpuConf{}
+	require.Nil(t, cc.hostCPUs(0))
+
+	n := runtime.NumCPU()
+	cc.CPUsPerHost = 1
+	require.Equal(t, []int{0}, cc.hostCPUs(0))
+	require.Equal(t, []int{1 % n}, cc.hostCPUs(1))
+	// the servers share the CPUs when there are not enough of them
+	require.Equal(t, []int{0}, cc.hostCPUs(n))
+
+	cc.CPUsPerHost = n + 1
+	require.Len(t, cc.hostCPUs(0), n)
+}
@@ -0,0 +1,101 @@
+package platform
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// deterministicConf is the deterministic mode of the simulation .toml.
+type deterministicConf struct {
+	// Deterministic runs the servers of the localhost platform with local
+	// connections, delivering their messages one at a time in an order
+	// depending on Seed, and gives them a virtual clock, so that a run can
+	// be replayed.
+	Deterministic bool
+	// Seed seeds the order of the messages and the randomness of the
+	// servers, and the churn and the faults if they have no seed
+	Seed int64
+}
+
+// the time is only advanced by the deterministic scheduler after the servers
+// didn't send anything during the settle time.
+const deterministicSettle = 2 * time.Millisecond
+
+// the virtual time when a deterministic simulation starts
+var deterministicStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// localRun is shared by the hosts of the localhost platform using local
+// connections, which all run in the same process.
+var localRun struct {
+	refs      int
+	manager   *network.LocalManager
+	scheduler *network.Scheduler
+	clock     *onet.VirtualClock
+	sync.Mutex
+}
+
+// useSeed seeds the churn, the faults and the clocks with Seed if they have
+// no seed.
+func (cfg *conf) useSeed() {
+	if cfg.ChurnSeed == 0 {
+		cfg.ChurnSeed = cfg.Seed
+	}
+	if cfg.FaultSeed == 0 {
+		cfg.FaultSeed = cfg.Seed
+	}
+	if cfg.ClockSeed == 0 {
+		cfg.ClockSeed = cfg.Seed
+	}
+}
+
+// loadLocal creates the servers of the host with the shared local manager,
+// and in the deterministic mode the shared clock and scheduler. The returned
+// function must be called once the servers are closed. It only works for the
+// addresses of localhost.
+func (cfg *conf) loadLocal(suite, serverAddress string) ([]*onet.SimulationConfig, func(), error) {
+	run := &localRun
+	run.Lock()
+	if run.refs == 0 {
+		run.manager = network.NewLocalManager()
+		run.manager.SetSharedMemory(cfg.SharedMemory, cfg.SharedMemoryCheck)
+		run.clock, run.scheduler = nil, nil
+		if cfg.Deterministic {
+			run.clock = onet.NewVirtualClock(deterministicStart)
+			run.scheduler = network.NewScheduler(cfg.Seed, deterministicSettle)
+			run.scheduler.SetIdle(run.clock.AdvanceToNext)
+			run.manager.SetScheduler(run.scheduler)
+		}
+	}
+	run.refs++
+	lm, clock := run.manager, run.clock
+	run.Unlock()
+	release := func() {
+		run.Lock()
+		defer run.Unlock()
+		run.refs--
+		if run.refs == 0 {
+			if run.scheduler != nil {
+				run.scheduler.Stop()
+			}
+			run.manager.Stop()
+		}
+	}
+
+	scs, err := onet.LoadSimulationConfigLocal(suite, ".", serverAddress, lm)
+	if err != nil {
+		release()
+		return nil, nil, xerrors.Errorf("loading config: %v", err)
+	}
+	if cfg.Deterministic {
+		for _, sc := range scs {
+			own, _ := sc.Roster.Search(sc.Server.ServerIdentity.ID)
+			sc.Server.SetClock(clock)
+			sc.Server.SetRandSeed(cfg.Seed + int64(own))
+		}
+	}
+	return scs, release, nil
+}
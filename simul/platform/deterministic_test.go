@@ -0,0 +1,18 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConf_useSeed(t *testing.T) {
+	cfg := &conf{}
+	_, err := toml.Decode("Deterministic = true\nSeed = 3\nFaultSeed = 5", cfg)
+	require.NoError(t, err)
+	require.True(t, cfg.Deterministic)
+	cfg.useSeed()
+	require.Equal(t, int64(3), cfg.ChurnSeed)
+	require.Equal(t, int64(5), cfg.FaultSeed)
+}
@@ -0,0 +1,42 @@
+package platform
+
+import (
+	"strings"
+
+	"go.dedis.ch/onet/v4"
+	"golang.org/x/xerrors"
+)
+
+// dilationConf is the time dilation of the simulation .toml, which stretches
+// the timeouts of the servers, given to onet.SetTimeScale.
+type dilationConf struct {
+	// TimeScale is the factor stretching the timeouts, 1 by default
+	TimeScale tomlFloat
+	// TimeScaleHosts is the number of hosts a machine runs without
+	// dilation. With more hosts, the timeouts are stretched further in
+	// proportion of the hosts of the machine.
+	TimeScaleHosts int
+}
+
+// timeScale returns the factor stretching the timeouts on the machine of the
+// address, running the hosts of the configurations. On localhost, all the
+// hosts of the roster run on the machine.
+func (dc *dilationConf) timeScale(roster *onet.Roster, address string, hosts int) (float64, error) {
+	factor := float64(dc.TimeScale)
+	if factor == 0 {
+		factor = 1
+	}
+	if factor < 0 {
+		return 0, xerrors.New("TimeScale must be positive")
+	}
+	if dc.TimeScaleHosts < 0 {
+		return 0, xerrors.New("TimeScaleHosts must be positive")
+	}
+	if strings.HasPrefix(address, "127.0.0.") {
+		hosts = len(roster.List)
+	}
+	if dc.TimeScaleHosts > 0 && hosts > dc.TimeScaleHosts {
+		factor *= float64(hosts) / float64(dc.TimeScaleHosts)
+	}
+	return factor, nil
+}
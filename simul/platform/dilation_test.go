@@ -0,0 +1,29 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+)
+
+func TestDilationConf_timeScale(t *testing.T) {
+	roster := onet.NewRoster(genLinkServers(6))
+	scale := func(dc dilationConf, address string, hosts int) float64 {
+		f, err := dc.timeScale(roster, address, hosts)
+		require.NoError(t, err)
+		return f
+	}
+	require.Equal(t, 1.0, scale(dilationConf{}, "10.0.0.1", 10))
+	require.Equal(t, 2.5, scale(dilationConf{TimeScale: 2.5}, "10.0.0.1", 10))
+	require.Equal(t, 2.5, scale(dilationConf{TimeScaleHosts: 4}, "10.0.0.1", 10))
+	require.Equal(t, 1.0, scale(dilationConf{TimeScaleHosts: 20}, "10.0.0.1", 10))
+	require.Equal(t, 5.0, scale(dilationConf{TimeScale: 2, TimeScaleHosts: 4}, "10.0.0.1", 10))
+	// all the hosts of localhost are on the machine
+	require.Equal(t, 3.0, scale(dilationConf{TimeScaleHosts: 2}, "127.0.0.1", 1))
+
+	_, err := (&dilationConf{TimeScale: -1}).timeScale(roster, "10.0.0.1", 1)
+	require.Error(t, err)
+	_, err = (&dilationConf{TimeScaleHosts: -1}).timeScale(roster, "10.0.0.1", 1)
+	require.Error(t, err)
+}
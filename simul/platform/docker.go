@@ -0,0 +1,335 @@
+// Docker is the platform that runs a simulation in docker containers on the
+// local machine, started with docker compose. Every container gets its own
+// address on a private network, so it is a middle ground between localhost,
+// where all servers share one process, and deterlab.
+
+package platform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/app"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// the name of the compose file written to the deploy directory
+const dockerComposeFile = "docker-compose.yml"
+
+// where the deploy directory is mounted in the containers
+const dockerSimulDir = "/simul"
+
+// the name the containers use to reach the monitor on the host
+const dockerMonitorHost = "host.docker.internal"
+
+// Docker holds the configuration to run a simulation with docker compose.
+type Docker struct {
+	// Need mutex because build.go has a global variable that
+	// is used for multiple experiments
+	sync.Mutex
+
+	// Simulation to be run
+	Simulation string
+	// Number of containers, each with its own address
+	Servers int
+	// Debugging-level: 0 is none - 5 is everything
+	Debug int
+	// The time to wait for the simulation to finish
+	RunWait string
+	// Suite used for the simulation
+	Suite string
+	// PreScript is run in each container before the simulation is started
+	PreScript string
+	// Tags to use when compiling
+	Tags string
+	// Port number of the monitor
+	MonitorPort int
+
+	// Image is the name of the conode image, "onet-<simulation>" by default
+	Image string
+	// BaseImage is the image the conode image is built from
+	BaseImage string
+	// Subnet of the network of the containers
+	Subnet string
+	// Compose is the command running docker compose
+	Compose string
+
+	// Directory we start - the simulation-directory of the service/protocol
+	wd string
+	// Directory for building the binary and the image
+	buildDir string
+	// Directory for deploying, mounted in the containers
+	deployDir string
+	// Addresses of all containers
+	addresses []string
+	// errors of the running compose go here
+	errChan chan error
+}
+
+// Configure implements the Platform-interface. It is called once to set up
+// the necessary internal variables.
+func (d *Docker) Configure(pc *Config) {
+	d.Lock()
+	defer d.Unlock()
+	d.wd, _ = os.Getwd()
+	d.buildDir = d.wd + "/build"
+	d.deployDir = d.wd + "/deploy"
+	d.Suite = pc.Suite
+	d.Debug = pc.Debug
+	d.MonitorPort = pc.MonitorPort
+	if d.Simulation == "" {
+		log.Fatal("No simulation defined in runconfig")
+	}
+	if d.Image == "" {
+		d.Image = "onet-" + strings.ToLower(d.Simulation)
+	}
+	if d.BaseImage == "" {
+		d.BaseImage = "debian:stable-slim"
+	}
+	if d.Subnet == "" {
+		d.Subnet = "172.28.0.0/16"
+	}
+	if d.Compose == "" {
+		d.Compose = "docker compose"
+	}
+
+	// Clean the build- and deploy-dir, then (re-)create them
+	for _, dir := range []string{d.buildDir, d.deployDir} {
+		os.RemoveAll(dir)
+		log.ErrFatal(os.Mkdir(dir, 0770))
+	}
+	log.Lvl3("Docker dirs: BuildDir", d.buildDir, "DeployDir", d.deployDir)
+}
+
+// Build implements the Platform interface: it compiles the simulation for
+// linux and builds the conode image with it.
+func (d *Docker) Build(build string, arg ...string) error {
+	log.Lvl1("Building image", d.Image, "for", d.Simulation)
+	start := time.Now()
+
+	var tags []string
+	if d.Tags != "" {
+		tags = append([]string{"-tags"}, strings.Split(d.Tags, " ")...)
+	}
+	out, err := Build(".", d.buildDir+"/conode", runtime.GOARCH, "linux",
+		append(arg, tags...)...)
+	if err != nil {
+		return xerrors.Errorf(err.Error() + " " + out)
+	}
+
+	dockerfile := fmt.Sprintf("FROM %s\nCOPY conode /usr/local/bin/conode\nWORKDIR %s\n",
+		d.BaseImage, dockerSimulDir)
+	err = ioutil.WriteFile(d.buildDir+"/Dockerfile", []byte(dockerfile), 0660)
+	if err != nil {
+		return xerrors.Errorf("writing Dockerfile: %v", err)
+	}
+	cmd := exec.Command("docker", "build", "-t", d.Image, d.buildDir)
+	cmd.Stderr = os.Stderr
+	if log.DebugVisible() > 1 {
+		cmd.Stdout = os.Stdout
+	}
+	if err := cmd.Run(); err != nil {
+		return xerrors.Errorf("building image: %v", err)
+	}
+
+	log.Lvl1("Build is finished after", time.Since(start))
+	return nil
+}
+
+// Cleanup stops and removes the containers of an eventual former run.
+func (d *Docker) Cleanup() error {
+	out, err := d.compose("down", "--remove-orphans").CombinedOutput()
+	if err != nil {
+		log.Lvl2("Error while cleaning up:", err, string(out))
+	}
+	return nil
+}
+
+// Deploy writes the configuration of the simulation and the compose file to
+// the deploy directory.
+func (d *Docker) Deploy(rc *RunConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	// Check for PreScript and copy it to the deploy-dir
+	d.PreScript = rc.Get("PreScript")
+	if d.PreScript != "" {
+		_, err := os.Stat(d.PreScript)
+		if !os.IsNotExist(err) {
+			if err := app.Copy(d.deployDir, d.PreScript); err != nil {
+				return xerrors.Errorf("copying: %v", err)
+			}
+		}
+	}
+
+	servers, err := rc.GetInt("servers")
+	if err == nil {
+		d.Servers = servers
+	}
+	if d.Servers < 1 {
+		d.Servers = 1
+	}
+	log.Lvl2("Docker: Deploying and writing config-files for", d.Servers, "containers")
+	sim, err := onet.NewSimulation(d.Simulation, string(rc.Toml()))
+	if err != nil {
+		return xerrors.Errorf("simulation error: %v", err)
+	}
+	d.addresses, err = dockerAddresses(d.Subnet, d.Servers)
+	if err != nil {
+		return xerrors.Errorf("addresses: %v", err)
+	}
+	sc, err := sim.Setup(d.deployDir, d.addresses)
+	if err != nil {
+		return xerrors.Errorf("simulation setup: %v", err)
+	}
+	sc.Config = string(rc.Toml())
+	if err := sc.Save(d.deployDir); err != nil {
+		return xerrors.Errorf("saving folder: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(d.deployDir, dockerComposeFile),
+		[]byte(d.composeFile()), 0660)
+	if err != nil {
+		return xerrors.Errorf("writing compose file: %v", err)
+	}
+	log.Lvl2("Docker: Done deploying")
+	return nil
+}
+
+// Start runs all containers and returns.
+func (d *Docker) Start(args ...string) error {
+	d.Lock()
+	defer d.Unlock()
+	d.errChan = make(chan error, 1)
+	cmd := d.compose("up", "--no-color")
+	cmd.Stderr = os.Stderr
+	if log.DebugVisible() > 1 {
+		cmd.Stdout = os.Stdout
+	}
+	log.Lvl1("Starting", d.Servers, "containers of", d.Image)
+	if err := cmd.Start(); err != nil {
+		return xerrors.Errorf("starting compose: %v", err)
+	}
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			err = xerrors.Errorf("compose: %v", err)
+		}
+		d.errChan <- err
+	}()
+	return nil
+}
+
+// Wait waits for all containers to finish, then removes them.
+func (d *Docker) Wait() error {
+	d.Lock()
+	defer d.Unlock()
+	log.Lvl3("Waiting for containers to finish")
+
+	wait, err := time.ParseDuration(d.RunWait)
+	if err != nil || wait == 0 {
+		wait = 600 * time.Second
+		err = nil
+	}
+	select {
+	case err = <-d.errChan:
+		log.Lvl3("Finished waiting for containers:", err)
+	case <-time.After(wait):
+		log.Lvl1("Quitting after waiting", wait)
+	}
+	if errCleanup := d.Cleanup(); errCleanup != nil {
+		log.Error("Couldn't remove the containers:", errCleanup)
+	}
+	log.Lvl2("Containers finished")
+	return err
+}
+
+// compose returns the command running docker compose with the arguments
+// on the project of this simulation.
+func (d *Docker) compose(args ...string) *exec.Cmd {
+	cmdArgs := strings.Fields(d.Compose)
+	cmdArgs = append(cmdArgs, "-p", d.Image)
+	file := filepath.Join(d.deployDir, dockerComposeFile)
+	if _, err := os.Stat(file); err == nil {
+		cmdArgs = append(cmdArgs, "-f", file)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	return exec.Command(cmdArgs[0], cmdArgs[1:]...)
+}
+
+// composeFile returns the compose file with one service per address. The
+// deploy directory is mounted in all containers, which then connect to the
+// monitor on the host.
+func (d *Docker) composeFile() string {
+	var b strings.Builder
+	b.WriteString("# Written by the docker platform of the onet simulation\n")
+	b.WriteString("services:\n")
+	for i, addr := range d.addresses {
+		args := []string{"conode", "-address", addr, "-simul", d.Simulation,
+			"-monitor", dockerMonitorHost + ":" + strconv.Itoa(d.MonitorPort),
+			"-suite", d.Suite, "-debug", strconv.Itoa(d.Debug)}
+		if d.PreScript != "" {
+			args = []string{"sh", "-c", "./" + filepath.Base(d.PreScript) +
+				" docker && exec " + strings.Join(args, " ")}
+		}
+		quoted := make([]string, len(args))
+		for j, a := range args {
+			quoted[j] = strconv.Quote(a)
+		}
+		fmt.Fprintf(&b, "  node%d:\n", i+1)
+		fmt.Fprintf(&b, "    image: %s\n", d.Image)
+		fmt.Fprintf(&b, "    command: [%s]\n", strings.Join(quoted, ", "))
+		fmt.Fprintf(&b, "    working_dir: %s\n", dockerSimulDir)
+		fmt.Fprintf(&b, "    volumes:\n      - %q\n", d.deployDir+":"+dockerSimulDir)
+		fmt.Fprintf(&b, "    extra_hosts:\n      - %q\n", dockerMonitorHost+":host-gateway")
+		fmt.Fprintf(&b, "    networks:\n      simul:\n        ipv4_address: %s\n", addr)
+	}
+	b.WriteString("networks:\n  simul:\n    ipam:\n      config:\n")
+	fmt.Fprintf(&b, "        - subnet: %s\n", d.Subnet)
+	return b.String()
+}
+
+// dockerAddresses returns n addresses of the subnet for the containers,
+// skipping the network address and the first one, which docker uses as
+// the gateway.
+func dockerAddresses(subnet string, n int) ([]string, error) {
+	ip, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing subnet: %v", err)
+	}
+	ip = ip.Mask(ipnet.Mask).To4()
+	if ip == nil {
+		return nil, xerrors.New("only IPv4 subnets are supported")
+	}
+	ones, bits := ipnet.Mask.Size()
+	// without the network, the gateway and the broadcast addresses
+	if available := (1 << uint(bits-ones)) - 3; n > available {
+		return nil, xerrors.Errorf("subnet %s only has %d addresses for %d containers",
+			subnet, available, n)
+	}
+	addresses := make([]string, n)
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := -1; i < n; i++ {
+		for j := len(next) - 1; j >= 0; j-- {
+			next[j]++
+			if next[j] != 0 {
+				break
+			}
+		}
+		if i >= 0 {
+			addresses[i] = next.String()
+		}
+	}
+	return addresses, nil
+}
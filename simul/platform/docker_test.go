@@ -0,0 +1,54 @@
+package platform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerAddresses(t *testing.T) {
+	addr, err := dockerAddresses("172.28.0.0/16", 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"172.28.0.2", "172.28.0.3", "172.28.0.4"}, addr)
+
+	addr, err = dockerAddresses("10.0.0.0/24", 253)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.254", addr[252])
+	_, err = dockerAddresses("10.0.0.0/24", 254)
+	require.Error(t, err)
+
+	addr, err = dockerAddresses("10.0.0.0/16", 300)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.1.0", addr[254])
+
+	_, err = dockerAddresses("10.0.0.0", 1)
+	require.Error(t, err)
+	_, err = dockerAddresses("fd00::/64", 1)
+	require.Error(t, err)
+}
+
+func TestDocker_composeFile(t *testing.T) {
+	d := &Docker{
+		Simulation:  "test",
+		Suite:       "Ed25519",
+		Debug:       2,
+		MonitorPort: 10000,
+		Image:       "onet-test",
+		Subnet:      "172.28.0.0/16",
+		deployDir:   "/tmp/deploy",
+		addresses:   []string{"172.28.0.2", "172.28.0.3"},
+	}
+	compose := d.composeFile()
+	require.Equal(t, 2, strings.Count(compose, "image: onet-test"))
+	require.Contains(t, compose, "  node2:\n")
+	require.Contains(t, compose, "ipv4_address: 172.28.0.3")
+	require.Contains(t, compose, `command: ["conode", "-address", "172.28.0.2", "-simul", "test", `+
+		`"-monitor", "host.docker.internal:10000", "-suite", "Ed25519", "-debug", "2"]`)
+	require.Contains(t, compose, `- "/tmp/deploy:/simul"`)
+	require.Contains(t, compose, "- subnet: 172.28.0.0/16")
+
+	d.PreScript = "scripts/pre.sh"
+	compose = d.composeFile()
+	require.Contains(t, compose, `command: ["sh", "-c", "./pre.sh docker && exec conode -address 172.28.0.2`)
+}
@@ -0,0 +1,200 @@
+package platform
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// faultConf are the faults injected in the messages of the simulation .toml.
+type faultConf struct {
+	// FaultDrop is the probability that a message is lost
+	FaultDrop tomlFloat
+	// FaultDuplicate is the probability that a message is delivered twice
+	FaultDuplicate tomlFloat
+	// FaultDelay is the probability that a message is held back for up to
+	// FaultMaxDelay, like "500ms"
+	FaultDelay    tomlFloat
+	FaultMaxDelay string
+	// FaultReorder is the probability that a message is swapped with the
+	// next one
+	FaultReorder tomlFloat
+	// FaultCorrupt is the probability that a byte of a message changes
+	FaultCorrupt tomlFloat
+	// FaultSeed makes the faults the same in every run if it is not 0
+	FaultSeed int64
+	// FaultLinks overrides the faults of some links, like NetLinks, with
+	// the keys drop, duplicate, delay, maxdelay, reorder and corrupt.
+	FaultLinks string
+	// FaultTypes overrides the faults of some messages, as a semicolon
+	// separated list of "type key:value ...", where type is the name of the
+	// message like "manage.CountMsg".
+	FaultTypes string
+}
+
+// faultRule are the faults of the messages of a link or of a type.
+type faultRule struct {
+	link    *linkRule
+	msgType *network.MessageTypeID
+	set     func(f *network.Faults)
+}
+
+// faults returns the faults in the messages received by the server at index
+// own in the roster, or nil if there are none. The messages of the types in
+// exempt, which drive the simulation, don't get any fault.
+func (fc *faultConf) faults(roster *onet.Roster, own int, exempt ...network.MessageTypeID) (network.FaultFunc, error) {
+	def := network.Faults{
+		Drop:      float64(fc.FaultDrop),
+		Duplicate: float64(fc.FaultDuplicate),
+		Delay:     float64(fc.FaultDelay),
+		Reorder:   float64(fc.FaultReorder),
+		Corrupt:   float64(fc.FaultCorrupt),
+	}
+	var err error
+	if def.MaxDelay, err = parseDuration(fc.FaultMaxDelay); err != nil {
+		return nil, xerrors.Errorf("FaultMaxDelay: %v", err)
+	}
+	for _, p := range []float64{def.Drop, def.Duplicate, def.Delay, def.Reorder, def.Corrupt} {
+		if p < 0 || p > 1 {
+			return nil, xerrors.New("the probabilities of the faults must be between 0 and 1")
+		}
+	}
+	rules, err := parseFaultLinks(fc.FaultLinks)
+	if err != nil {
+		return nil, xerrors.Errorf("FaultLinks: %v", err)
+	}
+	typeRules, err := parseFaultTypes(fc.FaultTypes)
+	if err != nil {
+		return nil, xerrors.Errorf("FaultTypes: %v", err)
+	}
+	rules = append(rules, typeRules...)
+	if def == (network.Faults{}) && len(rules) == 0 {
+		return nil, nil
+	}
+	return onet.MessageFaults(func(remote *network.ServerIdentity, msgType network.MessageTypeID) *network.Faults {
+		for _, t := range exempt {
+			if t.Equal(msgType) {
+				return nil
+			}
+		}
+		f := def
+		idx, _ := roster.Search(remote.ID)
+		for _, r := range rules {
+			if r.link != nil && (idx < 0 || !r.link.matches(own, idx)) {
+				continue
+			}
+			if r.msgType != nil && !r.msgType.Equal(msgType) {
+				continue
+			}
+			r.set(&f)
+		}
+		return &f
+	}), nil
+}
+
+// parseFaultLinks parses the FaultLinks of the configuration.
+func parseFaultLinks(links string) ([]faultRule, error) {
+	var rules []faultRule
+	for _, link := range strings.Split(links, ";") {
+		fields := strings.Fields(link)
+		if len(fields) == 0 {
+			continue
+		}
+		ends := strings.Split(fields[0], "-")
+		if len(ends) != 2 {
+			return nil, xerrors.Errorf("link %q is not from-to", fields[0])
+		}
+		lr := &linkRule{}
+		var err error
+		if lr.from, err = parseLinkEnd(ends[0]); err != nil {
+			return nil, err
+		}
+		if lr.to, err = parseLinkEnd(ends[1]); err != nil {
+			return nil, err
+		}
+		set, err := parseFaultSettings(fields[1:])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, faultRule{link: lr, set: set})
+	}
+	return rules, nil
+}
+
+// parseFaultTypes parses the FaultTypes of the configuration.
+func parseFaultTypes(types string) ([]faultRule, error) {
+	var rules []faultRule
+	for _, typ := range strings.Split(types, ";") {
+		fields := strings.Fields(typ)
+		if len(fields) == 0 {
+			continue
+		}
+		id, err := messageTypeByName(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		set, err := parseFaultSettings(fields[1:])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, faultRule{msgType: &id, set: set})
+	}
+	return rules, nil
+}
+
+// messageTypeByName returns the type of the registered message with the
+// name, with or without its package.
+func messageTypeByName(name string) (network.MessageTypeID, error) {
+	for id, t := range network.RegisteredMessages() {
+		if t.String() == name || t.Name() == name {
+			return id, nil
+		}
+	}
+	return network.ErrorType, xerrors.Errorf("unknown message %q", name)
+}
+
+// parseFaultSettings parses the "key:value" of a rule.
+func parseFaultSettings(kvs []string) (func(f *network.Faults), error) {
+	var setters []func(f *network.Faults)
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) != 2 {
+			return nil, xerrors.Errorf("%q is not key:value", kv)
+		}
+		if parts[0] == "maxdelay" {
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return nil, xerrors.Errorf("maxdelay: %v", err)
+			}
+			setters = append(setters, func(f *network.Faults) { f.MaxDelay = d })
+			continue
+		}
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, xerrors.Errorf("%s must be a probability", parts[0])
+		}
+		switch parts[0] {
+		case "drop":
+			setters = append(setters, func(f *network.Faults) { f.Drop = p })
+		case "duplicate":
+			setters = append(setters, func(f *network.Faults) { f.Duplicate = p })
+		case "delay":
+			setters = append(setters, func(f *network.Faults) { f.Delay = p })
+		case "reorder":
+			setters = append(setters, func(f *network.Faults) { f.Reorder = p })
+		case "corrupt":
+			setters = append(setters, func(f *network.Faults) { f.Corrupt = p })
+		default:
+			return nil, xerrors.Errorf("unknown fault %q", parts[0])
+		}
+	}
+	return func(f *network.Faults) {
+		for _, set := range setters {
+			set(f)
+		}
+	}, nil
+}
@@ -0,0 +1,47 @@
+package platform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+)
+
+type faultsTestMsg struct{}
+
+func TestFaultConf_faults(t *testing.T) {
+	testID := network.RegisterMessage(faultsTestMsg{})
+	exemptID := network.RegisterMessage(simulInitDone{})
+	sis := genLinkServers(3)
+	roster := onet.NewRoster(sis)
+
+	fc := &faultConf{}
+	f, err := fc.faults(roster, 0)
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	_, err = toml.Decode(`faultdrop = 0.1
+faultmaxdelay = "1s"
+faultlinks = "0-2 drop:0.5 maxdelay:2s; *-1 corrupt:1"
+faulttypes = "faultsTestMsg duplicate:1 drop:0"
+`, fc)
+	require.NoError(t, err)
+	f, err = fc.faults(roster, 0, exemptID)
+	require.NoError(t, err)
+	env := &network.Envelope{MsgType: onet.ProtocolMsgID, Msg: &onet.ProtocolMsg{}}
+	require.Equal(t, &network.Faults{Drop: 0.1, MaxDelay: time.Second, Corrupt: 1}, f(sis[1], env))
+	require.Equal(t, &network.Faults{Drop: 0.5, MaxDelay: 2 * time.Second}, f(sis[2], env))
+	env = &network.Envelope{MsgType: onet.ProtocolMsgID, Msg: &onet.ProtocolMsg{MsgType: testID}}
+	require.Equal(t, &network.Faults{Duplicate: 1, MaxDelay: 2 * time.Second}, f(sis[2], env))
+	require.Nil(t, f(sis[2], &network.Envelope{MsgType: exemptID}))
+
+	for _, wrong := range []faultConf{{FaultDrop: 2}, {FaultMaxDelay: "soon"},
+		{FaultLinks: "0-1 drop"}, {FaultLinks: "0 drop:1"}, {FaultLinks: "0-1 lose:1"},
+		{FaultTypes: "noSuchMsg drop:1"}, {FaultTypes: "faultsTestMsg drop:-1"}} {
+		_, err = wrong.faults(roster, 0)
+		require.Error(t, err, wrong)
+	}
+}
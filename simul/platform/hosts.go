@@ -0,0 +1,49 @@
+package platform
+
+import (
+	"math/rand"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"golang.org/x/xerrors"
+)
+
+// hostConf gives the servers of the simulation .toml their own clock, so
+// that the servers running in the same process don't all agree on the time.
+type hostConf struct {
+	// ClockSkew is the largest offset of the clock of a server, like "50ms".
+	// The offset of each server is picked uniformly between -ClockSkew and
+	// ClockSkew.
+	ClockSkew string
+	// ClockDrift is the largest rate the clock of a server runs faster or
+	// slower, like 1e-4 for 0.1ms every second.
+	ClockDrift tomlFloat
+	// ClockSeed makes the clocks the same in every run if it is not 0.
+	ClockSeed int64
+}
+
+// hostClock returns the clock of the server at the index of the roster,
+// drifting from base, or nil if the servers share the time.
+func (hc *hostConf) hostClock(base onet.Clock, own int) (onet.Clock, error) {
+	var skew time.Duration
+	if hc.ClockSkew != "" {
+		var err error
+		if skew, err = time.ParseDuration(hc.ClockSkew); err != nil {
+			return nil, xerrors.Errorf("ClockSkew: %v", err)
+		}
+	}
+	drift := float64(hc.ClockDrift)
+	if skew < 0 || drift < 0 || drift >= 1 {
+		return nil, xerrors.New("ClockSkew must be positive and ClockDrift between 0 and 1")
+	}
+	if skew == 0 && drift == 0 {
+		return nil, nil
+	}
+	seed := hc.ClockSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed + int64(own)))
+	offset := time.Duration((2*r.Float64() - 1) * float64(skew))
+	return onet.NewDriftClock(base, offset, (2*r.Float64()-1)*drift), nil
+}
@@ -0,0 +1,37 @@
+package platform
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4"
+)
+
+func TestHostConf_hostClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := onet.NewVirtualClock(start)
+	clock, err := (&hostConf{}).hostClock(vc, 0)
+	require.NoError(t, err)
+	require.Nil(t, clock)
+
+	hc := &hostConf{ClockSkew: "50ms", ClockDrift: 1e-3, ClockSeed: 1}
+	offsets := make(map[time.Duration]bool)
+	for i := 0; i < 4; i++ {
+		clock, err := hc.hostClock(vc, i)
+		require.NoError(t, err)
+		offset := clock.Now().Sub(start)
+		require.True(t, offset >= -50*time.Millisecond && offset <= 50*time.Millisecond)
+		offsets[offset] = true
+	}
+	require.Len(t, offsets, 4)
+	// the same seed gives the same clocks
+	a, _ := hc.hostClock(vc, 2)
+	b, _ := hc.hostClock(vc, 2)
+	require.Equal(t, a.Now(), b.Now())
+
+	_, err = (&hostConf{ClockSkew: "soon"}).hostClock(vc, 0)
+	require.Error(t, err)
+	_, err = (&hostConf{ClockDrift: 2}).hostClock(vc, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package platform
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// netConf is the emulated network of the simulation .toml, for the
+// platforms that don't have one of their own like localhost.
+type netConf struct {
+	// NetLatency, like "50ms", is added to all messages
+	NetLatency string
+	// NetJitter varies the latency of the messages
+	NetJitter string
+	// NetBandwidth of the links in Mbps
+	NetBandwidth tomlFloat
+	// NetLoss is the probability that a message needs to be retransmitted
+	NetLoss tomlFloat
+	// NetLinks overrides the conditions of some links, as a semicolon
+	// separated list of "from-to key:value ...", where from and to are
+	// indexes in the roster or "*", and the keys are latency, jitter,
+	// bandwidth and loss. The links go both ways. As the run-files don't
+	// allow '=' and ',' in the values, they are not used.
+	NetLinks string
+}
+
+// tomlFloat is a float64 that can also be written as an integer in the
+// .toml.
+type tomlFloat float64
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (f *tomlFloat) UnmarshalTOML(v interface{}) error {
+	switch n := v.(type) {
+	case int64:
+		*f = tomlFloat(n)
+	case float64:
+		*f = tomlFloat(n)
+	default:
+		return xerrors.Errorf("%v is not a number", v)
+	}
+	return nil
+}
+
+// linkRule are the conditions of the links between two groups of servers.
+type linkRule struct {
+	from, to int
+	set      func(lc *network.LinkConditions)
+}
+
+// matches returns whether the rule is for the link between a and b.
+func (r linkRule) matches(a, b int) bool {
+	match := func(x, y int) bool {
+		return (r.from < 0 || r.from == x) && (r.to < 0 || r.to == y)
+	}
+	return match(a, b) || match(b, a)
+}
+
+// linkConditions returns the conditions of the links of the server at index
+// own in the roster, or nil if the network is not emulated.
+func (nc *netConf) linkConditions(roster *onet.Roster, own int) (func(*network.ServerIdentity) *network.LinkConditions, error) {
+	def := network.LinkConditions{Loss: float64(nc.NetLoss),
+		Bandwidth: mbpsToBytes(float64(nc.NetBandwidth))}
+	var err error
+	if def.Latency, err = parseDuration(nc.NetLatency); err != nil {
+		return nil, xerrors.Errorf("NetLatency: %v", err)
+	}
+	if def.Jitter, err = parseDuration(nc.NetJitter); err != nil {
+		return nil, xerrors.Errorf("NetJitter: %v", err)
+	}
+	if def.Loss < 0 || def.Loss >= 1 {
+		return nil, xerrors.New("NetLoss must be at least 0 and below 1")
+	}
+	rules, err := parseLinkRules(nc.NetLinks)
+	if err != nil {
+		return nil, xerrors.Errorf("NetLinks: %v", err)
+	}
+	if def == (network.LinkConditions{}) && len(rules) == 0 {
+		return nil, nil
+	}
+	return func(remote *network.ServerIdentity) *network.LinkConditions {
+		lc := def
+		idx, _ := roster.Search(remote.ID)
+		if idx >= 0 {
+			for _, r := range rules {
+				if r.matches(own, idx) {
+					r.set(&lc)
+				}
+			}
+		}
+		return &lc
+	}, nil
+}
+
+// parseLinkRules parses the NetLinks of the configuration.
+func parseLinkRules(links string) ([]linkRule, error) {
+	var rules []linkRule
+	for _, link := range strings.Split(links, ";") {
+		fields := strings.Fields(link)
+		if len(fields) == 0 {
+			continue
+		}
+		ends := strings.Split(fields[0], "-")
+		if len(ends) != 2 {
+			return nil, xerrors.Errorf("link %q is not from-to", fields[0])
+		}
+		var r linkRule
+		var err error
+		if r.from, err = parseLinkEnd(ends[0]); err != nil {
+			return nil, err
+		}
+		if r.to, err = parseLinkEnd(ends[1]); err != nil {
+			return nil, err
+		}
+		var setters []func(lc *network.LinkConditions)
+		for _, kv := range fields[1:] {
+			set, err := parseLinkSetting(kv)
+			if err != nil {
+				return nil, err
+			}
+			setters = append(setters, set)
+		}
+		r.set = func(lc *network.LinkConditions) {
+			for _, set := range setters {
+				set(lc)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// parseLinkEnd returns the index of the end of a link, -1 for all.
+func parseLinkEnd(s string) (int, error) {
+	if s == "*" {
+		return -1, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil || i < 0 {
+		return 0, xerrors.Errorf("wrong server index %q", s)
+	}
+	return i, nil
+}
+
+// parseLinkSetting parses one "key:value" of a link.
+func parseLinkSetting(kv string) (func(lc *network.LinkConditions), error) {
+	parts := strings.SplitN(kv, ":", 2)
+	if len(parts) != 2 {
+		return nil, xerrors.Errorf("%q is not key:value", kv)
+	}
+	switch parts[0] {
+	case "latency", "jitter":
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", parts[0], err)
+		}
+		if parts[0] == "latency" {
+			return func(lc *network.LinkConditions) { lc.Latency = d }, nil
+		}
+		return func(lc *network.LinkConditions) { lc.Jitter = d }, nil
+	case "bandwidth", "loss":
+		f, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", parts[0], err)
+		}
+		if parts[0] == "bandwidth" {
+			return func(lc *network.LinkConditions) { lc.Bandwidth = mbpsToBytes(f) }, nil
+		}
+		if f < 0 || f >= 1 {
+			return nil, xerrors.New("loss must be at least 0 and below 1")
+		}
+		return func(lc *network.LinkConditions) { lc.Loss = f }, nil
+	default:
+		return nil, xerrors.Errorf("unknown link setting %q", parts[0])
+	}
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// mbpsToBytes returns the bandwidth in Mbps as bytes per second.
+func mbpsToBytes(mbps float64) uint64 {
+	return uint64(mbps * 1e6 / 8)
+}
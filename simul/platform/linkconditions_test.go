@@ -0,0 +1,66 @@
+package platform
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/kyber/v3/group/edwards25519"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestNetConf_linkConditions(t *testing.T) {
+	roster := onet.NewRoster(genLinkServers(3))
+
+	cfg := &conf{}
+	_, err := toml.Decode("individualstats = \"\"\n", cfg)
+	require.NoError(t, err)
+	f, err := cfg.linkConditions(roster, 0)
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	_, err = toml.Decode(`netlatency = "50ms"
+netjitter = "5ms"
+netbandwidth = 8
+netlinks = "0-2 latency:200ms loss:0.1; *-1 bandwidth:80"
+`, cfg)
+	require.NoError(t, err)
+	f, err = cfg.linkConditions(roster, 0)
+	require.NoError(t, err)
+	require.Equal(t, &network.LinkConditions{Latency: 200 * time.Millisecond,
+		Jitter: 5 * time.Millisecond, Bandwidth: 1e6, Loss: 0.1}, f(roster.List[2]))
+	require.Equal(t, &network.LinkConditions{Latency: 50 * time.Millisecond,
+		Jitter: 5 * time.Millisecond, Bandwidth: 1e7}, f(roster.List[1]))
+
+	// the links go both ways
+	f, err = cfg.linkConditions(roster, 2)
+	require.NoError(t, err)
+	require.Equal(t, 200*time.Millisecond, f(roster.List[0]).Latency)
+	require.Equal(t, 50*time.Millisecond, f(roster.List[1]).Latency)
+	require.Equal(t, uint64(1e7), f(roster.List[1]).Bandwidth)
+
+	for _, links := range []string{"0", "a-1", "0-1 latency", "0-1 latency:fast",
+		"0-1 speed:1", "0-1 loss:much", "0-1 loss:1"} {
+		cfg.NetLinks = links
+		_, err = cfg.linkConditions(roster, 0)
+		require.Error(t, err, links)
+	}
+	cfg.NetLinks = ""
+	cfg.NetLatency = "soon"
+	_, err = cfg.linkConditions(roster, 0)
+	require.Error(t, err)
+}
+
+func genLinkServers(n int) []*network.ServerIdentity {
+	suite := edwards25519.NewBlakeSHA256Ed25519()
+	list := make([]*network.ServerIdentity, n)
+	for i := range list {
+		addr := network.NewTCPAddress("127.0.0.1:" + strconv.Itoa(2000+i))
+		list[i] = network.NewServerIdentity(key.NewKeyPair(suite).Public, addr)
+	}
+	return list
+}
@@ -230,6 +230,16 @@ func (m *MiniNet) Deploy(rc *RunConfig) error {
 	if err != nil {
 		return xerrors.Errorf("writing file: %v", err)
 	}
+	topology, err := m.getTopology(rc, list)
+	if err != nil {
+		return xerrors.Errorf("topology: %v", err)
+	}
+	// Also written when empty, to replace the one of the previous run
+	log.Lvl3("Topology is:", topology)
+	err = ioutil.WriteFile(m.deployDir+"/topology", []byte(topology), 0660)
+	if err != nil {
+		return xerrors.Errorf("writing file: %v", err)
+	}
 	simulConfig, err := sim.Setup(m.deployDir, hosts)
 	if err != nil {
 		return xerrors.Errorf("simulation setup: %v", err)
@@ -408,16 +418,27 @@ func (m *MiniNet) getHostList(rc *RunConfig) (hosts []string, list string, err e
 		return
 	}
 
+	split := onet.SimulationBFTree{Hosts: nbrHosts}
+	if _, err = toml.Decode(string(rc.Toml()), &split); err != nil {
+		err = xerrors.Errorf("config: %v", err)
+		return
+	}
+	counts, err := split.SplitHosts(nbrServers)
+	if err != nil {
+		err = xerrors.Errorf("splitting hosts: %v", err)
+		return
+	}
+
 	// Map all required conodes to Mininet-hosts
-	for i := 0; i < nbrHosts; i++ {
-		ip := ips[i%nbrServers]
+	for _, server := range onet.AssignHosts(counts) {
+		ip := ips[server]
 		for j := len(ip) - 1; j >= 0; j-- {
 			ip[j]++
 			if ip[j] > 0 {
 				break
 			}
 		}
-		ips[i%nbrServers] = ip
+		ips[server] = ip
 		hosts = append(hosts, ip.String())
 	}
 
@@ -435,15 +456,11 @@ func (m *MiniNet) getHostList(rc *RunConfig) (hosts []string, list string, err e
 	// Add descriptions for `start.py` to know which mininet-network it has to
 	// run on what physical server with how many hosts.
 	for i, s := range nets {
-		if i >= nbrHosts {
-			break
+		if counts[i] == 0 {
+			continue
 		}
-		// Magical formula to get how many hosts run on each
-		// physical server if we distribute them evenly, starting
-		// from the first server.
-		h := (nbrHosts + nbrServers - 1 - i) / nbrServers
 		list += fmt.Sprintf("%s %s %d\n",
-			m.HostIPs[i], s.String(), h)
+			m.HostIPs[i], s.String(), counts[i])
 	}
 	return
 }
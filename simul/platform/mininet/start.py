@@ -14,11 +14,12 @@ router for the net, and ".2"..".nbr+1" will be the nodes.
 
 from __future__ import print_function
 import sys, time, threading, os, datetime, contextlib, errno, platform, shutil, re
+from functools import partial
 from mininet.topo import Topo
 from mininet.net import Mininet
 from mininet.cli import CLI
 from mininet.log import lg, setLogLevel
-from mininet.node import Node, Host, OVSController
+from mininet.node import Node, Host, OVSController, OVSBridge
 from mininet.util import netParse, ipAdd, irange
 from mininet.nodelib import NAT
 from mininet.link import TCLink
@@ -45,6 +46,11 @@ socatRcv = "udp4-listen"
 socatDirect = True
 # If we want to end up in the CLI
 startCLI = False
+# The file describing the topologies of the networks, written by the
+# simulation, empty if they are stars
+topologyFile = "topology"
+# The topology of each network, as the lines of topologyFile
+topologies = {}
 # 10.internalNet.x.y will be used for the ip2ip tunnels. ICCluster uses
 # 10.0/16 for it's own internal network, and 10.90/16 for access to the machines.
 # So we take 10.89/16 for our ip2ip tunnels, limiting the total number of
@@ -182,6 +188,41 @@ class InternetTopo(Topo):
                 dbg( 3, "Adding link", host, switch )
                 self.addLink(host, switch, bw=bandwidth, delay=delay)
 
+class GraphTopo(Topo):
+        """Create the switches and links of the topology, with the hosts
+        connected to their switches and host .1 as router - all in subnet
+        10.x.0.0/16"""
+        def __init__(self, myNet=None, rootLog=None, lines=[], **opts):
+            Topo.__init__(self, **opts)
+            server, mn, n = myNet[0]
+            baseIp, prefix = netParse(mn)
+            gw = ipAdd(1, prefix, baseIp)
+            dbg( 2, "Gw", gw, "baseIp", baseIp, prefix, "Topology:", len(lines), "lines")
+            hostgw = self.addNode('h0', cls=BaseRouter,
+                                  ip='%s/%d' % (gw, prefix),
+                                  inNamespace=False,
+                                  rootLog=rootLog)
+            for line in lines:
+                fields = line.split(' ')
+                if fields[0] == "switch":
+                    self.addSwitch(fields[1])
+                elif fields[0] == "link":
+                    a, b, bw, d = fields[1:]
+                    dbg( 3, "Adding link", a, b )
+                    self.addLink(a, b, bw=int(bw), delay=d + "ms")
+                elif fields[0] == "host":
+                    i, switch, bw, d = fields[1:]
+                    ipStr = ipAdd(int(i) + 1, prefix, baseIp)
+                    host = self.addHost('h%s' % i, cls=Conode,
+                                        ip = '%s/%d' % (ipStr, prefix),
+                                        defaultRoute='via %s' % gw,
+                                        simul=simulation, suite=suite, gw=gw,
+                                        rootLog=rootLog)
+                    dbg( 3, "Adding link", host, switch )
+                    self.addLink(host, switch, bw=int(bw), delay=d + "ms")
+                elif fields[0] == "router":
+                    self.addLink(fields[1], hostgw)
+
 def RunNet():
     """RunNet will start the mininet and add the routes to the other
     mininet-services"""
@@ -190,11 +231,21 @@ def RunNet():
         i, p = netParse(otherNets[0][1])
         rootLog = ipAdd(1, p, i)
     dbg( 2, "Creating network", myNet )
-    topo = InternetTopo(myNet=myNet, rootLog=rootLog)
+    lines = topologies.get(myNet[0][1])
+    if lines:
+        # The topologies can have loops, so the switches run the spanning
+        # tree protocol instead of using a controller
+        topo = GraphTopo(myNet=myNet, rootLog=rootLog, lines=lines)
+        net = Mininet(topo=topo, link=TCLink, controller=None,
+                      switch=partial(OVSBridge, stp=True))
+    else:
+        topo = InternetTopo(myNet=myNet, rootLog=rootLog)
+        net = Mininet(topo=topo, link=TCLink, controller = OVSController)
     dbg( 3, "Starting on", myNet )
-
-    net = Mininet(topo=topo, link=TCLink, controller = OVSController)
     net.start()
+    if lines and hasattr(net, "waitConnected"):
+        dbg( 2, "Waiting for the spanning tree" )
+        net.waitConnected()
 
     for host in net.hosts[1:]:
         host.startConode()
@@ -256,6 +307,15 @@ def GetNetworks(filename):
     for line in content:
         list.append(line.rstrip().split(' '))
 
+    if os.path.exists(topologyFile):
+        with open(topologyFile) as f:
+            lines = None
+            for line in f.read().splitlines():
+                if line.startswith("net "):
+                    lines = topologies.setdefault(line.split(' ')[1], [])
+                elif line != "":
+                    lines.append(line)
+
     otherNets = []
     myNet = None
     pos = 0
@@ -85,6 +85,21 @@ func TestMiniNet_getHostList2(t *testing.T) {
 	assert.Equal(t, "10.1.1.1", h[255])
 }
 
+func TestMiniNet_getHostList_shares(t *testing.T) {
+	mn := &MiniNet{Simulation: "cosi", Suite: "Ed25519",
+		HostIPs: []string{"local1", "local2", "local3"}}
+	rc := makeRunConfig(3, 4)
+	rc.Put("MachineShares", `"3 0 1"`)
+	h, l, err := mn.getHostList(rc)
+	log.ErrFatal(err)
+	assert.Equal(t, []string{"10.1.0.2", "10.3.0.2", "10.1.0.3", "10.1.0.4"}, h)
+	assert.Equal(t, "cosi Ed25519 0 0\n0 false false false\n\nlocal1 10.1.0.0/16 3\nlocal3 10.3.0.0/16 1\n", l)
+
+	rc.Put("MachineShares", `"0 0"`)
+	_, _, err = mn.getHostList(rc)
+	assert.Error(t, err)
+}
+
 func TestMiniNet_parseServers(t *testing.T) {
 	tmpfile, err := ioutil.TempFile("", "server_list")
 	log.ErrFatal(err)
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"go/build"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -77,9 +78,11 @@ type Config struct {
 var deterlab = "deterlab"
 var localhost = "localhost"
 var mininet = "mininet"
+var docker = "docker"
+var cloud = "cloud"
 
 // NewPlatform returns the appropriate platform
-// [deterlab,localhost]
+// [deterlab,localhost,mininet,docker,cloud]
 func NewPlatform(t string) Platform {
 	var p Platform
 	switch t {
@@ -87,6 +90,10 @@ func NewPlatform(t string) Platform {
 		p = &Deterlab{}
 	case localhost:
 		p = &Localhost{}
+	case docker:
+		p = &Docker{}
+	case cloud:
+		p = &Cloud{}
 	case mininet:
 		p = &MiniNet{}
 		_, err := os.Stat("server_list")
@@ -130,9 +137,22 @@ func NewPlatform(t string) Platform {
 //
 // The Name1...Namen are global configuration-options.
 // n1..nn are configuration-options for one run
+// A value of a run can be a grid of values between braces, like {2 4 8}:
+// the line is then run with all the combinations of the values of its grids,
+// and every run gets a Sweep option with the index of its line.
 // Both the global and the run-configuration are copied to both
 // the platform and the app-configuration.
+// A file ending in .yaml, .yml or .json is read by readStructuredRunFile
+// instead.
 func ReadRunFile(p Platform, filename string) []*RunConfig {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml", ".json":
+		runconfigs, err := readStructuredRunFile(p, filename)
+		if err != nil {
+			log.Fatal("Simulation file:", filename, err)
+		}
+		return runconfigs
+	}
 	var runconfigs []*RunConfig
 	masterConfig := NewRunConfig()
 	log.Lvl3("Reading file", filename)
@@ -183,16 +203,31 @@ func ReadRunFile(p Platform, filename string) []*RunConfig {
 		}
 	}
 	args := strings.Split(scanner.Text(), ",")
-	for scanner.Scan() {
+	var sweep bool
+	var lines []int
+	for run := 0; scanner.Scan(); {
 		if len(scanner.Text()) == 0 || scanner.Text()[0] == '#' {
 			continue
 		}
-		rc := masterConfig.Clone()
-		// put each individual test configs
-		for i, value := range strings.Split(scanner.Text(), ",") {
-			rc.Put(strings.TrimSpace(args[i]), strings.TrimSpace(value))
+		cells := strings.Split(scanner.Text(), ",")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
 		}
-		runconfigs = append(runconfigs, rc)
+		runs, grid := expandSweep(cells)
+		sweep = sweep || grid
+		for _, values := range runs {
+			rc := masterConfig.Clone()
+			// put each individual test configs
+			for i, value := range values {
+				rc.Put(strings.TrimSpace(args[i]), value)
+			}
+			runconfigs = append(runconfigs, rc)
+			lines = append(lines, run)
+		}
+		run++
+	}
+	if sweep {
+		tagSweep(runconfigs, lines)
 	}
 
 	return runconfigs
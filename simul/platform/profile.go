@@ -0,0 +1,191 @@
+package platform
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/simul/monitor"
+	"golang.org/x/xerrors"
+)
+
+// profileConf is the profiling of the simulation .toml.
+type profileConf struct {
+	// Profile are the pprof profiles collected on every host during the
+	// rounds given to SimulationConfig.StartRound, separated by commas:
+	// "cpu" and "heap"
+	Profile string
+	// ProfileRounds are the rounds to profile, like "3", or "2:5" for the
+	// rounds 2 to 4, all by default
+	ProfileRounds string
+}
+
+// profiler collects the profiles of a process and sends them to the
+// monitor. As pprof profiles the whole process, there is one for all the
+// hosts of the localhost platform, which run in the same process.
+type profiler struct {
+	cpu, heap bool
+	// the rounds to profile, stop is -1 for no end
+	start, stop int
+	host        string
+	// the round being profiled, or -1
+	round int
+	// the last round started
+	last int
+	buf  bytes.Buffer
+	sync.Mutex
+}
+
+// profilers is the profiler of the process, with the number of hosts using
+// it.
+var profilers struct {
+	refs int
+	p    *profiler
+	sync.Mutex
+}
+
+// parseRounds returns the first round to profile and the one after the
+// last, or -1 for no end.
+func parseRounds(rounds string) (int, int, error) {
+	if rounds == "" {
+		return 0, -1, nil
+	}
+	bounds := strings.Split(rounds, ":")
+	if len(bounds) > 2 {
+		return 0, 0, xerrors.Errorf("too many bounds in %q", rounds)
+	}
+	start, stop := 0, -1
+	var err error
+	if bounds[0] != "" {
+		start, err = strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, 0, xerrors.Errorf("start: %v", err)
+		}
+	}
+	if len(bounds) == 1 {
+		return start, start + 1, nil
+	}
+	if bounds[1] != "" {
+		stop, err = strconv.Atoi(bounds[1])
+		if err != nil {
+			return 0, 0, xerrors.Errorf("stop: %v", err)
+		}
+	}
+	return start, stop, nil
+}
+
+// profiler returns the profiler of the process, labelling the profiles with
+// the host, or nil if there is no profiling. The returned function must be
+// called once the servers of the host are closed.
+func (pc *profileConf) profiler(host string) (*profiler, func(), error) {
+	if pc.Profile == "" {
+		return nil, nil, nil
+	}
+	p := &profiler{host: host, round: -1, last: -1}
+	for _, kind := range strings.Split(pc.Profile, ",") {
+		switch strings.TrimSpace(kind) {
+		case "cpu":
+			p.cpu = true
+		case "heap":
+			p.heap = true
+		default:
+			return nil, nil, xerrors.Errorf("unknown profile %q", kind)
+		}
+	}
+	var err error
+	p.start, p.stop, err = parseRounds(pc.ProfileRounds)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("wrong rounds: %v", err)
+	}
+
+	profilers.Lock()
+	defer profilers.Unlock()
+	if profilers.refs == 0 {
+		profilers.p = p
+	}
+	profilers.refs++
+	p = profilers.p
+	return p, func() {
+		profilers.Lock()
+		defer profilers.Unlock()
+		profilers.refs--
+		if profilers.refs == 0 {
+			p.stopRound()
+		}
+	}, nil
+}
+
+// profiled returns whether the round is profiled.
+func (p *profiler) profiled(round int) bool {
+	return round >= p.start && (p.stop < 0 || round < p.stop)
+}
+
+// startRound sends the profiles of the previous round, and starts
+// profiling the round if it is selected. It is called by all the servers of
+// the process, only the first call for a round does something.
+func (p *profiler) startRound(round int) {
+	p.Lock()
+	defer p.Unlock()
+	if round <= p.last {
+		return
+	}
+	p.last = round
+	p.finish()
+	if !p.profiled(round) {
+		return
+	}
+	log.Lvl2("Profiling round", round, "of", p.host)
+	p.round = round
+	if p.cpu {
+		p.buf.Reset()
+		if err := pprof.StartCPUProfile(&p.buf); err != nil {
+			log.Error("Couldn't start the CPU profile:", err)
+		}
+	}
+}
+
+// stopRound sends the profiles of the round being profiled, when the
+// simulation stops.
+func (p *profiler) stopRound() {
+	p.Lock()
+	defer p.Unlock()
+	p.finish()
+}
+
+// finish sends the profiles of the round being profiled, if any. It must
+// be called with the lock.
+func (p *profiler) finish() {
+	if p.round < 0 {
+		return
+	}
+	if p.cpu {
+		pprof.StopCPUProfile()
+		p.send("cpu", append([]byte{}, p.buf.Bytes()...))
+	}
+	if p.heap {
+		// the heap profile is as of the last garbage collection
+		runtime.GC()
+		var buf bytes.Buffer
+		if err := pprof.WriteHeapProfile(&buf); err != nil {
+			log.Error("Couldn't write the heap profile:", err)
+		} else {
+			p.send("heap", buf.Bytes())
+		}
+	}
+	p.round = -1
+}
+
+func (p *profiler) send(kind string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	err := monitor.RecordProfile(&monitor.Profile{Kind: kind, Host: p.host,
+		Round: p.round, Data: data})
+	if err != nil {
+		log.Error("Couldn't send the", kind, "profile:", err)
+	}
+}
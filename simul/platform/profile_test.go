@@ -0,0 +1,64 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRounds(t *testing.T) {
+	for rounds, bounds := range map[string][2]int{
+		"":    {0, -1},
+		"3":   {3, 4},
+		"2:5": {2, 5},
+		"2:":  {2, -1},
+		":4":  {0, 4},
+	} {
+		start, stop, err := parseRounds(rounds)
+		require.NoError(t, err)
+		require.Equal(t, bounds, [2]int{start, stop}, rounds)
+	}
+	for _, rounds := range []string{"a", "1:b", "1:2:3"} {
+		_, _, err := parseRounds(rounds)
+		require.Error(t, err)
+	}
+}
+
+func TestProfileConf_profiler(t *testing.T) {
+	p, _, err := (&profileConf{}).profiler("127.0.0.1")
+	require.NoError(t, err)
+	require.Nil(t, p)
+	_, _, err = (&profileConf{Profile: "cpu,disk"}).profiler("127.0.0.1")
+	require.Error(t, err)
+	_, _, err = (&profileConf{Profile: "cpu", ProfileRounds: "a"}).profiler("127.0.0.1")
+	require.Error(t, err)
+
+	// the hosts of a process share the profiler
+	pc := &profileConf{Profile: "cpu, heap", ProfileRounds: "1:3"}
+	p1, release1, err := pc.profiler("127.0.0.1")
+	require.NoError(t, err)
+	p2, release2, err := pc.profiler("127.0.0.2")
+	require.NoError(t, err)
+	require.True(t, p1 == p2)
+	require.True(t, p1.cpu && p1.heap)
+	require.Equal(t, "127.0.0.1", p1.host)
+
+	p1.startRound(0)
+	require.Equal(t, -1, p1.round)
+	p1.startRound(1)
+	require.Equal(t, 1, p1.round)
+	// another server of the process starting the same round
+	p2.startRound(1)
+	require.Equal(t, 1, p1.round)
+	p1.startRound(3)
+	require.Equal(t, -1, p1.round)
+	p1.startRound(2)
+	require.Equal(t, -1, p1.round)
+	release1()
+	release2()
+
+	p3, release3, err := pc.profiler("127.0.0.3")
+	require.NoError(t, err)
+	require.False(t, p1 == p3)
+	release3()
+}
@@ -0,0 +1,160 @@
+package platform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// runsKey is the key of the list of runs in a YAML or JSON run file.
+const runsKey = "Runs"
+
+// readStructuredRunFile reads a run file in YAML or JSON. Its global options
+// are the keys of the top-level table, and its runs are the tables of the
+// list under Runs, which must all have the same keys, like the columns of a
+// TOML file. A list of values in a run is a grid, as {2 4 8} in a TOML file,
+// and the grids are combined in the order of the keys of the first run. The
+// Simulation option is required, and the options of the platform must have
+// the type of its fields.
+func readStructuredRunFile(p Platform, filename string) ([]*RunConfig, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, xerrors.Errorf("reading: %v", err)
+	}
+	// JSON is also YAML, and a MapSlice keeps the order of the keys
+	var top yaml.MapSlice
+	if err := yaml.Unmarshal(buf, &top); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+
+	masterConfig := NewRunConfig()
+	var runs []interface{}
+	var simulation bool
+	for _, item := range top {
+		key := fmt.Sprint(item.Key)
+		if key == runsKey {
+			list, ok := item.Value.([]interface{})
+			if !ok {
+				return nil, xerrors.Errorf("%s must be a list of runs", runsKey)
+			}
+			runs = list
+			continue
+		}
+		value, err := runValue(item.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", key, err)
+		}
+		simulation = simulation || strings.EqualFold(key, "Simulation")
+		masterConfig.Put(key, value)
+		if _, err := toml.Decode(key+" = "+value, p); err != nil {
+			return nil, xerrors.Errorf("%s: wrong type for the platform: %v", key, err)
+		}
+	}
+	if !simulation {
+		return nil, xerrors.New("missing required key Simulation")
+	}
+	if len(runs) == 0 {
+		return nil, xerrors.Errorf("missing required key %s, or it has no run", runsKey)
+	}
+
+	var keys []string
+	var runconfigs []*RunConfig
+	var sweep bool
+	var lines []int
+	for i, r := range runs {
+		table, ok := r.(yaml.MapSlice)
+		if !ok {
+			return nil, xerrors.Errorf("run %d is not a table", i+1)
+		}
+		cells := make(map[string]string)
+		for _, item := range table {
+			key := fmt.Sprint(item.Key)
+			cell, err := runCell(item.Value)
+			if err != nil {
+				return nil, xerrors.Errorf("run %d: %s: %v", i+1, key, err)
+			}
+			cells[key] = cell
+			if i == 0 {
+				keys = append(keys, key)
+			} else if !contains(keys, key) {
+				return nil, xerrors.Errorf("run %d: unknown key %s, not in the first run", i+1, key)
+			}
+		}
+		row := make([]string, len(keys))
+		for j, key := range keys {
+			cell, ok := cells[key]
+			if !ok {
+				return nil, xerrors.Errorf("run %d: missing key %s of the first run", i+1, key)
+			}
+			row[j] = cell
+		}
+
+		expanded, grid := expandSweep(row)
+		sweep = sweep || grid
+		for _, values := range expanded {
+			rc := masterConfig.Clone()
+			for j, value := range values {
+				rc.Put(keys[j], value)
+			}
+			runconfigs = append(runconfigs, rc)
+			lines = append(lines, i)
+		}
+	}
+	if sweep {
+		tagSweep(runconfigs, lines)
+	}
+	return runconfigs, nil
+}
+
+// runCell returns the cell of a run as in a TOML file, with a list of values
+// written as a grid.
+func runCell(v interface{}) (string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return runValue(v)
+	}
+	values := make([]string, len(list))
+	for i, e := range list {
+		value, err := runValue(e)
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+	return "{" + strings.Join(values, " ") + "}", nil
+}
+
+// runValue returns the value as in a TOML file.
+func runValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, uint64:
+		return fmt.Sprint(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case nil:
+		return "", xerrors.New("has no value")
+	}
+	return "", xerrors.Errorf("must be a string, a number or a boolean, not %T", v)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
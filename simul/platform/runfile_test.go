@@ -0,0 +1,90 @@
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testfileYAML = `
+Simulation: CountTest
+App: sign
+Machines: 8
+RunWait: 33s
+Runs:
+  - {Hosts: 3, Delay: [50, 100], Ratio: 0.1, Other: "string 1"}
+  - {Hosts: 7, Delay: 50, Ratio: 1e-5, Other: "string 2"}
+`
+
+const testfileJSON = `{
+	"Simulation": "CountTest",
+	"App": "sign",
+	"Machines": 8,
+	"RunWait": "33s",
+	"Runs": [
+		{"Hosts": 3, "Delay": [50, 100], "Ratio": 0.1, "Other": "string 1"},
+		{"Hosts": 7, "Delay": 50, "Ratio": 1e-5, "Other": "string 2"}
+	]
+}`
+
+func TestReadRunFile_structured(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "runfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	for name, content := range map[string]string{"run.yaml": testfileYAML, "run.json": testfileJSON} {
+		file := path.Join(tmp, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+		tplat := &TPlat{}
+		tests := ReadRunFile(tplat, file)
+		require.Equal(t, "sign", tplat.App, name)
+		require.Equal(t, 8, tplat.Machines, name)
+		require.Equal(t, 33*time.Second, tplat.RunWait.Duration, name)
+
+		var got [][]string
+		for _, rc := range tests {
+			require.Equal(t, "CountTest", rc.Get("simulation"))
+			require.Equal(t, "8", rc.Get("machines"))
+			got = append(got, []string{rc.Get("hosts"), rc.Get("delay"),
+				rc.Get("ratio"), rc.Get("other"), rc.Get("sweep")})
+		}
+		require.Equal(t, [][]string{
+			{"3", "50", "0.1", "string 1", "0"},
+			{"3", "100", "0.1", "string 1", "0"},
+			{"7", "50", "1e-05", "string 2", "1"},
+		}, got, name)
+	}
+}
+
+func TestReadRunFile_structuredErrors(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "runfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	for content, msg := range map[string]string{
+		"App: sign\nRuns: [{Hosts: 2}]":                                    "missing required key Simulation",
+		"Simulation: Count":                                                "missing required key Runs",
+		"Simulation: Count\nRuns: 2":                                       "Runs must be a list of runs",
+		"Simulation: Count\nMachines: many\nRuns: [{Hosts: 2}]":            "Machines: wrong type for the platform",
+		"Simulation: Count\nApp: {a: b}\nRuns: [{Hosts: 2}]":               "App: must be a string, a number or a boolean",
+		"Simulation: Count\nRuns: [{Hosts: 2}, {Host: 4}]":                 "run 2: unknown key Host",
+		"Simulation: Count\nRuns: [{Hosts: 2, BF: 2}, {Hosts: 4}]":         "run 2: missing key BF",
+		"Simulation: Count\nRuns: [{Hosts: 2}, 4]":                         "run 2 is not a table",
+		"Simulation: Count\nRuns: [{Hosts: 2, Delay: }]":                   "run 1: Delay: has no value",
+		"Simulation: Count\nRuns: [{Hosts: 2, Delay: [1, [2]]}]":           "run 1: Delay: must be a string",
+		"Simulation: Count\nRuns: [{Hosts: 2}\n":                           "decoding",
+		"{\"Simulation\": \"Count\", \"Runs\": [{\"Hosts\": 2}, {}]}":      "run 2: missing key Hosts",
+		"{\"Simulation\": \"Count\", \"Machines\": \"8\", \"Runs\": [{}]}": "Machines: wrong type",
+	} {
+		file := path.Join(tmp, "run.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+		_, err := readStructuredRunFile(&TPlat{}, file)
+		require.Error(t, err, content)
+		require.Contains(t, err.Error(), msg, content)
+	}
+}
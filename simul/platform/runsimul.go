@@ -1,7 +1,10 @@
 package platform
 
 import (
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -16,14 +19,52 @@ import (
 type simulInit struct{}
 type simulInitDone struct{}
 
+// simulStop lets the servers come back and behave for the end of the
+// simulation.
+type simulStop struct{}
+type simulStopDone struct{}
+
 // Simulate starts the server and will setup the protocol.
 func Simulate(suite, serverAddress, simul, monitorAddress string) error {
-	scs, err := onet.LoadSimulationConfig(suite, ".", serverAddress)
+	cfg := &conf{}
+	if all, err := onet.LoadSimulationConfig(suite, ".", ""); err == nil {
+		_, err := toml.Decode(all[0].Config, cfg)
+		if err != nil {
+			return xerrors.New("error while decoding config: " + err.Error())
+		}
+	}
+	if cfg.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(cfg.GOMAXPROCS)
+	}
+	var scs []*onet.SimulationConfig
+	var err error
+	if cfg.Deterministic || cfg.SharedMemory {
+		if !strings.HasPrefix(serverAddress, "127.0.0.") {
+			return xerrors.New("the deterministic mode and the shared memory only work with the localhost platform")
+		}
+		if cfg.Deterministic {
+			cfg.useSeed()
+		}
+		var release func()
+		scs, release, err = cfg.loadLocal(suite, serverAddress)
+		if err == nil {
+			defer release()
+		}
+	} else {
+		scs, err = onet.LoadSimulationConfig(suite, ".", serverAddress)
+	}
 	if err != nil {
 		// We probably are not needed
 		log.Lvl2(err, serverAddress)
 		return nil
 	}
+	scale, err := cfg.timeScale(scs[0].Roster, serverAddress, len(scs))
+	if err != nil {
+		return xerrors.New("wrong time scale: " + err.Error())
+	}
+	// also set when it is 1, in case the process ran another simulation
+	log.Lvl3(serverAddress, "stretches the timeouts by", scale)
+	onet.SetTimeScale(scale)
 	if monitorAddress != "" {
 		if err := monitor.ConnectSink(monitorAddress); err != nil {
 			log.Error("Couldn't connect monitor to sink:", err)
@@ -33,32 +74,98 @@ func Simulate(suite, serverAddress, simul, monitorAddress string) error {
 	sims := make([]onet.Simulation, len(scs))
 	simulInitID := network.RegisterMessage(simulInit{})
 	simulInitDoneID := network.RegisterMessage(simulInitDone{})
+	simulStopID := network.RegisterMessage(simulStop{})
+	simulStopDoneID := network.RegisterMessage(simulStopDone{})
 	var rootSC *onet.SimulationConfig
 	var rootSim onet.Simulation
+	var rootMisbehaving *int32
 	// having a waitgroup so the binary stops when all servers are closed
-	var wgServer, wgSimulInit sync.WaitGroup
+	var wgServer, wgSimulInit, wgStop sync.WaitGroup
 	var ready = make(chan bool)
 	measureNodeBW := true
 	measuresLock := sync.Mutex{}
 	measures := make([]*monitor.CounterIOMeasure, len(scs))
-	if len(scs) > 0 {
-		cfg := &conf{}
-		_, err := toml.Decode(scs[0].Config, cfg)
-		if err != nil {
-			return xerrors.New("error while decoding config: " + err.Error())
-		}
-		measureNodeBW = cfg.IndividualStats == ""
+	measureNodeBW = cfg.IndividualStats == ""
+	churn, err := cfg.churnModel()
+	if err != nil {
+		return xerrors.New("wrong churn: " + err.Error())
+	}
+	prof, releaseProfiler, err := cfg.profiler(serverAddress)
+	if err != nil {
+		return xerrors.New("wrong profile: " + err.Error())
+	}
+	if prof != nil {
+		defer releaseProfiler()
 	}
 	for i, sc := range scs {
 		// Starting all servers for that server
 		server := sc.Server
+		own, _ := sc.Roster.Search(server.ServerIdentity.ID)
+		links, err := cfg.linkConditions(sc.Roster, own)
+		if err != nil {
+			return xerrors.New("wrong network emulation: " + err.Error())
+		}
+		if links != nil {
+			server.Router.SetLinkConditions(links)
+		}
+		clock, err := cfg.hostClock(server.Clock(), own)
+		if err != nil {
+			return xerrors.New("wrong clocks: " + err.Error())
+		}
+		if clock != nil {
+			server.SetClock(clock)
+		}
+		if cfg.GoroutineBudget != 0 {
+			server.SetGoroutineBudget(cfg.GoroutineBudget)
+		}
+		if cpus := cfg.hostCPUs(i); cpus != nil {
+			if err := server.SetCPUAffinity(cpus); err != nil {
+				return xerrors.New("wrong CPUs: " + err.Error())
+			}
+		}
+		faults, err := cfg.faults(sc.Roster, own, simulInitID, simulInitDoneID,
+			simulStopID, simulStopDoneID)
+		if err != nil {
+			return xerrors.New("wrong faults: " + err.Error())
+		}
+		// the servers only misbehave once the simulation is set up
+		misbehaving := new(int32)
+		if faults != nil {
+			server.Router.SetFaults(cfg.FaultSeed, func(remote *network.ServerIdentity, env *network.Envelope) *network.Faults {
+				if atomic.LoadInt32(misbehaving) == 0 {
+					return nil
+				}
+				return faults(remote, env)
+			})
+		}
+		recorder, closeTrace, err := cfg.traceRecorder(own)
+		if err != nil {
+			return xerrors.New("couldn't record the messages: " + err.Error())
+		}
+		if recorder != nil {
+			server.SetTraceRecorder(recorder)
+			defer closeTrace()
+		}
+		isRoot := server.ServerIdentity.ID.Equal(sc.Tree.Root.ServerIdentity.ID)
+		root, _ := sc.Roster.Search(sc.Tree.Root.ServerIdentity.ID)
+		adversary, err := cfg.adversary(sc.Roster, root, own)
+		if err != nil {
+			return xerrors.New("wrong adversary: " + err.Error())
+		}
+		if prof != nil {
+			sc.SetRoundHandler(prof.startRound)
+		}
+		var ch *churner
+		if churn != nil && !isRoot {
+			ch = newChurner(server, churn, own, simulStopID, simulStopDoneID)
+		}
 
 		if measureNodeBW {
 			hostIndex, _ := sc.Roster.Search(sc.Server.ServerIdentity.ID)
 			measures[i] = monitor.NewCounterIOMeasureWithHost("bandwidth", sc.Server, hostIndex)
 		}
 
-		log.Lvl3(serverAddress, "Starting server", server.ServerIdentity.Address)
+		sc.Log.Lvl3("Starting server", server.ServerIdentity.Address)
 		// Launch a server and notifies when it's done
 		wgServer.Add(1)
 		measure := measures[i]
@@ -96,8 +203,16 @@ func Simulate(suite, serverAddress, simul, monitorAddress string) error {
 
 			err = sim.Node(scTmp)
 			log.ErrFatal(err)
+			if adversary != nil {
+				scTmp.Log.Lvl2(scTmp.Server.ServerIdentity, "is adversarial")
+				cfg.setAdversary(scTmp.Server, adversary)
+			}
+			atomic.StoreInt32(misbehaving, 1)
 			_, err := scTmp.Server.Send(env.ServerIdentity, &simulInitDone{})
 			log.ErrFatal(err)
+			if ch != nil {
+				ch.start()
+			}
 			// not reached because of ErrFatal, but return it anyway.
 			return nil
 		})
@@ -111,10 +226,26 @@ func Simulate(suite, serverAddress, simul, monitorAddress string) error {
 			}
 			return nil
 		})
-		if server.ServerIdentity.ID.Equal(sc.Tree.Root.ServerIdentity.ID) {
+		server.RegisterProcessorFunc(simulStopID, func(env *network.Envelope) error {
+			if ch != nil {
+				ch.stop()
+			}
+			if prof != nil {
+				prof.stopRound()
+			}
+			atomic.StoreInt32(misbehaving, 0)
+			_, err := scTmp.Server.Send(env.ServerIdentity, &simulStopDone{})
+			return err
+		})
+		server.RegisterProcessorFunc(simulStopDoneID, func(env *network.Envelope) error {
+			wgStop.Done()
+			return nil
+		})
+		if isRoot {
 			log.Lvl2(serverAddress, "is root-node, will start protocol")
 			rootSim = sim
 			rootSC = sc
+			rootMisbehaving = misbehaving
 		}
 	}
 
@@ -168,6 +299,8 @@ func Simulate(suite, serverAddress, simul, monitorAddress string) error {
 		measureNet := monitor.NewCounterIOMeasure("bandwidth_root", rootSC.Server)
 		simError = rootSim.Run(rootSC)
 		measureNet.Record()
+		atomic.StoreInt32(rootMisbehaving, 0)
+		stopMisbehaving(rootSC, &wgStop)
 
 		// Test if all ServerIdentities are used in the tree, else we'll run into
 		// troubles with CloseAll
@@ -200,6 +333,49 @@ func Simulate(suite, serverAddress, simul, monitorAddress string) error {
 	return nil
 }
 
+// stopMisbehaving brings all servers back online and stops the faults, so
+// that they can be closed.
+func stopMisbehaving(sc *onet.SimulationConfig, wg *sync.WaitGroup) {
+	log.Lvl2("Stopping the churn and the faults")
+	for _, si := range sc.Roster.List {
+		if si.Equal(sc.Server.ServerIdentity) {
+			continue
+		}
+		wg.Add(1)
+		go func(si *network.ServerIdentity) {
+			// a server that just left might still close its connections
+			for i := 0; ; i++ {
+				_, err := sc.Server.Send(si, &simulStop{})
+				if err == nil {
+					return
+				}
+				if i == 10 {
+					log.Error("Couldn't stop the misbehaviour of", si, ":", err)
+					wg.Done()
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}(si)
+	}
+	wg.Wait()
+}
+
 type conf struct {
 	IndividualStats string
+	// GoroutineBudget is how many goroutines at most give the messages to
+	// the protocol instances of each server, as with
+	// onet.Server.SetGoroutineBudget.
+	GoroutineBudget int
+	netConf
+	churnConf
+	faultConf
+	byzantineConf
+	deterministicConf
+	traceConf
+	profileConf
+	dilationConf
+	hostConf
+	sharedMemoryConf
+	cpuConf
 }
@@ -0,0 +1,15 @@
+package platform
+
+// sharedMemoryConf is the shared memory of the simulation .toml, for the
+// localhost platform with thousands of servers.
+type sharedMemoryConf struct {
+	// SharedMemory runs the servers of the localhost platform with local
+	// connections passing the messages by reference, without marshaling
+	// them, as with network.LocalManager.SetSharedMemory. The protocols
+	// must not modify a message once it is sent or received.
+	SharedMemory bool
+	// SharedMemoryCheck checks one message in SharedMemoryCheck, dropping
+	// the ones modified after they were sent. The checked messages are
+	// marshaled, and the bandwidth only counts them.
+	SharedMemoryCheck int
+}
@@ -0,0 +1,56 @@
+package platform
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sweepKey is added to the run configurations of a file with parameter
+// grids. It is the index of the line of the run, so that the results of a
+// grid can be told apart in the .csv.
+const sweepKey = "Sweep"
+
+// sweepValues returns the values of a cell of a run line. A cell with a grid
+// of values is written between braces and separated by spaces, like
+// "{2 4 8}", any other cell has only one value.
+func sweepValues(cell string) []string {
+	if !strings.HasPrefix(cell, "{") || !strings.HasSuffix(cell, "}") {
+		return []string{cell}
+	}
+	values := strings.Fields(cell[1 : len(cell)-1])
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+// expandSweep returns all the combinations of the values of the cells of a
+// run line, the last cell varying the fastest, and whether there was a grid.
+func expandSweep(cells []string) ([][]string, bool) {
+	runs := [][]string{nil}
+	var grid bool
+	for _, cell := range cells {
+		values := sweepValues(cell)
+		if len(values) > 1 || values[0] != cell {
+			grid = true
+		}
+		var next [][]string
+		for _, run := range runs {
+			for _, v := range values {
+				r := make([]string, len(run), len(run)+1)
+				copy(r, run)
+				next = append(next, append(r, v))
+			}
+		}
+		runs = next
+	}
+	return runs, grid
+}
+
+// tagSweep tags the runs with the index of their line, to tell the
+// combinations of one line apart from the others.
+func tagSweep(runconfigs []*RunConfig, lines []int) {
+	for i, rc := range runconfigs {
+		rc.Put(sweepKey, strconv.Itoa(lines[i]))
+	}
+}
@@ -0,0 +1,53 @@
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandSweep(t *testing.T) {
+	runs, grid := expandSweep([]string{"2", "30", `"string 1"`})
+	require.False(t, grid)
+	require.Equal(t, [][]string{{"2", "30", `"string 1"`}}, runs)
+
+	runs, grid = expandSweep([]string{"{2 4}", "30", "{64  256 1024}"})
+	require.True(t, grid)
+	require.Equal(t, [][]string{
+		{"2", "30", "64"}, {"2", "30", "256"}, {"2", "30", "1024"},
+		{"4", "30", "64"}, {"4", "30", "256"}, {"4", "30", "1024"},
+	}, runs)
+
+	runs, grid = expandSweep([]string{"{8}", "{}"})
+	require.True(t, grid)
+	require.Equal(t, [][]string{{"8", ""}}, runs)
+}
+
+var testfileSweep = `Machines = 8
+
+BF, Hosts
+{2 4}, {16 32}
+8, 64`
+
+func TestReadRunfile_sweep(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "testrun.toml")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.Write([]byte(testfileSweep))
+	require.NoError(t, err)
+	require.NoError(t, tmpfile.Close())
+
+	tests := ReadRunFile(&TPlat{}, tmpfile.Name())
+	require.Equal(t, 5, len(tests))
+	var got [][]string
+	for _, rc := range tests {
+		require.Equal(t, "8", rc.Get("machines"))
+		got = append(got, []string{rc.Get("bf"), rc.Get("hosts"), rc.Get("sweep")})
+	}
+	require.Equal(t, [][]string{
+		{"2", "16", "0"}, {"2", "32", "0"}, {"4", "16", "0"}, {"4", "32", "0"},
+		{"8", "64", "1"},
+	}, got)
+}
@@ -0,0 +1,194 @@
+package platform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/xerrors"
+)
+
+// topologyConf is the network built by mininet on every physical server,
+// from the simulation .toml. The hosts of a physical server are connected
+// through switches, and reach the other physical servers through the router
+// of their network.
+type topologyConf struct {
+	// Topology is one of "star", where all the hosts are on the same
+	// switch, "fattree" or "ring". It is "star" by default.
+	Topology string
+	// FatTreeK is the number of ports of the switches of the fat-tree, an
+	// even number, 4 by default. The tree has k pods of k/2 edge and k/2
+	// aggregation switches, and (k/2)^2 core switches.
+	FatTreeK int
+	// Datacenters is the number of datacenters of the ring, 2 by
+	// default. Every datacenter has a switch for its hosts, the switches
+	// being linked in a ring.
+	Datacenters int
+	// WANDelay in ms of the links between the datacenters
+	WANDelay int
+	// WANBandwidth in Mbps of the links between the datacenters, the
+	// Bandwidth of the hosts by default
+	WANBandwidth int
+}
+
+// topoLink is a link of the topology, with its bandwidth in Mbps and delay
+// in ms.
+type topoLink struct {
+	from, to         string
+	bandwidth, delay int
+}
+
+// topology is the network of one mininet.
+type topology struct {
+	switches []string
+	links    []topoLink
+	// the switch of every host
+	hosts  []topoLink
+	router string
+}
+
+// addSwitch adds a switch to the topology and returns its name.
+func (t *topology) addSwitch() string {
+	name := "s" + strconv.Itoa(len(t.switches)+1)
+	t.switches = append(t.switches, name)
+	return name
+}
+
+// String returns the description of the topology read by start.py, with one
+// line by element, which is one of "switch name", "link switch1 switch2
+// bandwidth delay", "host index switch bandwidth delay" or "router switch".
+func (t *topology) String() string {
+	var lines []string
+	for _, s := range t.switches {
+		lines = append(lines, "switch "+s)
+	}
+	for _, l := range t.links {
+		lines = append(lines, fmt.Sprintf("link %s %s %d %d", l.from, l.to, l.bandwidth, l.delay))
+	}
+	for _, h := range t.hosts {
+		lines = append(lines, fmt.Sprintf("host %s %s %d %d", h.from, h.to, h.bandwidth, h.delay))
+	}
+	lines = append(lines, "router "+t.router)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// build returns the topology of a mininet with the given number of hosts,
+// or nil for the star that start.py builds by itself.
+func (tc *topologyConf) build(hosts, bandwidth, delay int) (*topology, error) {
+	t := &topology{}
+	// connects the hosts to the switches in turn
+	attach := func(switches []string) {
+		for i := 1; i <= hosts; i++ {
+			t.hosts = append(t.hosts, topoLink{strconv.Itoa(i), switches[(i-1)%len(switches)],
+				bandwidth, delay})
+		}
+	}
+	switch tc.Topology {
+	case "", "star":
+		return nil, nil
+	case "fattree":
+		k := tc.FatTreeK
+		if k == 0 {
+			k = 4
+		}
+		if k < 2 || k%2 != 0 {
+			return nil, xerrors.Errorf("FatTreeK must be an even number, not %d", k)
+		}
+		var cores, edges []string
+		for i := 0; i < k*k/4; i++ {
+			cores = append(cores, t.addSwitch())
+		}
+		for pod := 0; pod < k; pod++ {
+			var aggs []string
+			for i := 0; i < k/2; i++ {
+				aggs = append(aggs, t.addSwitch())
+			}
+			// the i-th aggregation switch of every pod goes to its own
+			// k/2 core switches
+			for i, agg := range aggs {
+				for _, core := range cores[i*k/2 : (i+1)*k/2] {
+					t.links = append(t.links, topoLink{agg, core, bandwidth, 0})
+				}
+			}
+			for i := 0; i < k/2; i++ {
+				edge := t.addSwitch()
+				edges = append(edges, edge)
+				for _, agg := range aggs {
+					t.links = append(t.links, topoLink{edge, agg, bandwidth, 0})
+				}
+			}
+		}
+		attach(edges)
+		t.router = cores[0]
+	case "ring":
+		n := tc.Datacenters
+		if n == 0 {
+			n = 2
+		}
+		if n < 1 {
+			return nil, xerrors.Errorf("wrong number of datacenters %d", n)
+		}
+		wanBandwidth := tc.WANBandwidth
+		if wanBandwidth == 0 {
+			wanBandwidth = bandwidth
+		}
+		var dcs []string
+		for i := 0; i < n; i++ {
+			dcs = append(dcs, t.addSwitch())
+		}
+		// below three datacenters, the ring is a line
+		links := n
+		if n <= 2 {
+			links = n - 1
+		}
+		for i := 0; i < links; i++ {
+			t.links = append(t.links, topoLink{dcs[i], dcs[(i+1)%n], wanBandwidth, tc.WANDelay})
+		}
+		attach(dcs)
+		t.router = dcs[0]
+	default:
+		return nil, xerrors.Errorf("unknown topology %q", tc.Topology)
+	}
+	return t, nil
+}
+
+// getTopology returns the description of the networks of the list made by
+// getHostList, or an empty string if they are stars, for start.py. Every network starts
+// with a line "net MininetNet/16", followed by its topology.
+func (m *MiniNet) getTopology(rc *RunConfig, list string) (string, error) {
+	tc := &topologyConf{}
+	if _, err := toml.Decode(string(rc.Toml()), tc); err != nil {
+		return "", xerrors.Errorf("decoding toml: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(list), "\n")
+	if len(lines) < 3 {
+		return "", xerrors.New("list too short")
+	}
+	var bandwidth, delay int
+	header := strings.Fields(lines[0])
+	if len(header) != 4 {
+		return "", xerrors.Errorf("wrong list header %q", lines[0])
+	}
+	if _, err := fmt.Sscan(header[2]+" "+header[3], &bandwidth, &delay); err != nil {
+		return "", xerrors.Errorf("wrong list header: %v", err)
+	}
+
+	var desc string
+	for _, line := range lines[3:] {
+		var server, net string
+		var hosts int
+		if _, err := fmt.Sscan(line, &server, &net, &hosts); err != nil {
+			return "", xerrors.Errorf("wrong list line %q: %v", line, err)
+		}
+		t, err := tc.build(hosts, bandwidth, delay)
+		if err != nil {
+			return "", xerrors.Errorf("topology: %v", err)
+		}
+		if t == nil {
+			return "", nil
+		}
+		desc += "net " + net + "\n" + t.String()
+	}
+	return desc, nil
+}
@@ -0,0 +1,78 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopologyConf_build(t *testing.T) {
+	tc := &topologyConf{}
+	topo, err := tc.build(4, 100, 5)
+	require.NoError(t, err)
+	require.Nil(t, topo)
+
+	tc.Topology = "fattree"
+	topo, err = tc.build(10, 100, 5)
+	require.NoError(t, err)
+	// 4 core switches, and 4 pods of 2 aggregation and 2 edge switches
+	require.Equal(t, 20, len(topo.switches))
+	require.Equal(t, 32, len(topo.links))
+	require.Equal(t, 10, len(topo.hosts))
+	require.Equal(t, "s1", topo.router)
+	// the first edge switch comes after the aggregation switches of the
+	// first pod
+	require.Equal(t, topoLink{"1", "s7", 100, 5}, topo.hosts[0])
+	require.Equal(t, topoLink{"9", "s7", 100, 5}, topo.hosts[8])
+	require.Contains(t, topo.links, topoLink{"s5", "s1", 100, 0})
+	require.Contains(t, topo.links, topoLink{"s6", "s4", 100, 0})
+	require.Contains(t, topo.links, topoLink{"s8", "s6", 100, 0})
+
+	tc.FatTreeK = 3
+	_, err = tc.build(10, 100, 5)
+	require.Error(t, err)
+
+	tc = &topologyConf{Topology: "ring", Datacenters: 3, WANDelay: 50}
+	topo, err = tc.build(4, 100, 5)
+	require.NoError(t, err)
+	require.Equal(t, []string{"s1", "s2", "s3"}, topo.switches)
+	require.Equal(t, []topoLink{{"s1", "s2", 100, 50}, {"s2", "s3", 100, 50},
+		{"s3", "s1", 100, 50}}, topo.links)
+	require.Equal(t, topoLink{"4", "s1", 100, 5}, topo.hosts[3])
+
+	tc = &topologyConf{Topology: "ring", WANBandwidth: 10}
+	topo, err = tc.build(4, 100, 5)
+	require.NoError(t, err)
+	require.Equal(t, []topoLink{{"s1", "s2", 10, 0}}, topo.links)
+
+	tc.Topology = "mesh"
+	_, err = tc.build(4, 100, 5)
+	require.Error(t, err)
+}
+
+func TestMiniNet_getTopology(t *testing.T) {
+	mn := &MiniNet{Simulation: "cosi", Suite: "Ed25519",
+		HostIPs: []string{"local1", "local2"}}
+	rc := makeRunConfig(2, 3)
+	rc.Put("Bandwidth", "100")
+	rc.Put("Delay", "5")
+	_, list, err := mn.getHostList(rc)
+	require.NoError(t, err)
+
+	topo, err := mn.getTopology(rc, list)
+	require.NoError(t, err)
+	require.Equal(t, "", topo)
+
+	rc.Put("Topology", `"ring"`)
+	rc.Put("WANDelay", "50")
+	topo, err = mn.getTopology(rc, list)
+	require.NoError(t, err)
+	require.Equal(t, "net 10.1.0.0/16\nswitch s1\nswitch s2\nlink s1 s2 100 50\n"+
+		"host 1 s1 100 5\nhost 2 s2 100 5\nrouter s1\n"+
+		"net 10.2.0.0/16\nswitch s1\nswitch s2\nlink s1 s2 100 50\n"+
+		"host 1 s1 100 5\nrouter s1\n", topo)
+
+	rc.Put("Topology", `"mesh"`)
+	_, err = mn.getTopology(rc, list)
+	require.Error(t, err)
+}
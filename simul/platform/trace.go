@@ -0,0 +1,41 @@
+package platform
+
+import (
+	"fmt"
+	"os"
+
+	"go.dedis.ch/onet/v4"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// traceConf is the recording of the messages of the simulation .toml.
+type traceConf struct {
+	// Trace records the messages received by the protocol instances of each
+	// server in the file <Trace>-<index>.trace, next to the simulation
+	// files, to be read with onet.ReadTrace
+	Trace string
+}
+
+// traceRecorder returns the recorder of the server at index own in the
+// roster, or nil if there is no recording. The returned function closes the
+// file.
+func (tc *traceConf) traceRecorder(own int) (*onet.TraceRecorder, func(), error) {
+	if tc.Trace == "" {
+		return nil, nil, nil
+	}
+	name := fmt.Sprintf("%s-%d.trace", tc.Trace, own)
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("creating trace: %v", err)
+	}
+	r := onet.NewTraceRecorder(f)
+	return r, func() {
+		if err := r.Err(); err != nil {
+			log.Error("Couldn't record", name, ":", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Error("Couldn't close", name, ":", err)
+		}
+	}, nil
+}
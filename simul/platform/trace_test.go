@@ -0,0 +1,27 @@
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceConf_traceRecorder(t *testing.T) {
+	r, _, err := (&traceConf{}).traceRecorder(0)
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	dir, err := ioutil.TempDir("", "trace")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tc := &traceConf{Trace: filepath.Join(dir, "run")}
+	r, closeTrace, err := tc.traceRecorder(3)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	closeTrace()
+	_, err = os.Stat(filepath.Join(dir, "run-3.trace"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,30 @@
+package simul
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/simul/monitor"
+)
+
+// profileFilePrefix returns the start of the names of the profiles of the
+// simulation, which stays right when the platform changes the directory.
+func profileFilePrefix(name string) string {
+	prefix := fmt.Sprintf("test_data/%s", name)
+	if abs, err := filepath.Abs(prefix); err == nil {
+		return abs
+	}
+	return prefix
+}
+
+// saveProfile writes the profile received from a host in its file.
+func saveProfile(p *monitor.Profile, prefix string) {
+	file := p.FileName(prefix)
+	if err := ioutil.WriteFile(file, p.Data, 0660); err != nil {
+		log.Error("Couldn't save profile:", err)
+		return
+	}
+	log.Lvl2("Saved the", p.Kind, "profile of", p.Host, "in", file)
+}
@@ -3,8 +3,10 @@ Package simul allows for easy simulation on different platforms. THe following p
 are available:
 
 	- localhost - for up to 100 nodes
+	- docker - for up to a few hundred nodes in containers on one machine
 	- mininet - for up to 1'000 nodes
 	- deterlab - for up to 50'000 nodes
+	- cloud - on virtual machines rented from aws or gcp
 
 Usually you start small, then work your way up to the full potential of your
 protocol!
@@ -53,12 +53,19 @@ func (e *simulation) Setup(dir string, hosts []string) (
 // Run is used on the destination machines and runs a number of
 // rounds
 func (e *simulation) Run(config *onet.SimulationConfig) error {
-	size := config.Tree.Size()
-	log.Lvl2("Size is:", size, "rounds:", e.Rounds)
-	for round := 0; round < e.Rounds; round++ {
-		log.Lvl1("Starting round", round)
+	log.Lvl2("Size is:", config.Tree.Size(), "rounds:", e.Rounds)
+	for i := e.ResumeRound; i < e.Rounds; i++ {
+		log.Lvl1("Starting round", i)
+		if err := config.StartRound(i); err != nil {
+			return xerrors.Errorf("starting round: %v", err)
+		}
+		tree, err := e.CommitteeTree(config, i)
+		if err != nil {
+			return xerrors.Errorf("committee: %v", err)
+		}
+		size := tree.Size()
 		round := monitor.NewTimeMeasure("round")
-		p, err := config.Overlay.CreateProtocol("Count", config.Tree, onet.NilServiceID)
+		p, err := config.Overlay.CreateProtocol("Count", tree, onet.NilServiceID)
 		if err != nil {
 			return xerrors.Errorf("creating protocol: %v", err)
 		}
@@ -69,6 +76,10 @@ func (e *simulation) Run(config *onet.SimulationConfig) error {
 			return xerrors.New("Didn't get " + strconv.Itoa(size) +
 				" children")
 		}
+		if err := config.Checkpoint(i); err != nil {
+			return xerrors.Errorf("checkpoint: %v", err)
+		}
+		monitor.Checkpoint(i)
 	}
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -66,6 +67,20 @@ type SimulationConfig struct {
 	TLS bool
 	// Additional configuration used to run
 	Config string
+	// HostIndex is the index of our server in the roster, to tag the
+	// measures with monitor.NewTimeMeasureWithHost and the like. It is -1
+	// when the configuration isn't the one of a server.
+	HostIndex int
+	// HostDir is the directory of our server, which holds its database, so
+	// that the servers running in the same process don't share files.
+	HostDir string
+	// Log logs with the prefix of our server, like "host-3", to tell the
+	// output of the servers of a process apart.
+	Log log.Prefix
+	// receives the replies of the servers to Checkpoint
+	checkpointDone chan roundReply
+	// what the server does when a round starts
+	rounds *simulationRounds
 }
 
 // SimulationPrivateKey contains the default private key and the service
@@ -96,8 +111,20 @@ type SimulationConfigFile struct {
 // LoadSimulationConfig gets all configuration from dir + SimulationFileName and instantiates the
 // corresponding host 'ca'.
 func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
-	// Have all servers created by NewServerTCP below put their
-	// db's into this simulation directory.
+	return loadSimulationConfig(s, dir, ca, nil)
+}
+
+// LoadSimulationConfigLocal is like LoadSimulationConfig, but the servers use
+// local connections of the manager instead of TCP, so that all the servers
+// of a process can be run by its Scheduler. It only works for the addresses
+// of localhost.
+func LoadSimulationConfigLocal(s, dir, ca string, lm *network.LocalManager) ([]*SimulationConfig, error) {
+	return loadSimulationConfig(s, dir, ca, lm)
+}
+
+func loadSimulationConfig(s, dir, ca string, lm *network.LocalManager) ([]*SimulationConfig, error) {
+	// The servers below have their own directory, this one is for the
+	// servers the simulations create themselves.
 	os.Setenv("CONODE_SERVICE_PATH", dir)
 
 	// TODO: Figure this out from the incoming simulation file somehow
@@ -119,6 +146,7 @@ func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
 		PrivateKeys: scf.PrivateKeys,
 		TLS:         scf.TLS,
 		Config:      scf.Config,
+		HostIndex:   -1,
 	}
 	sc.Tree, err = scf.TreeMarshal.MakeTree(sc.Roster)
 	if err != nil {
@@ -132,7 +160,7 @@ func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
 			// 10.255.0.1 would also match 10.255.0.10 and others
 			ca += ":"
 		}
-		for _, e := range sc.Roster.List {
+		for index, e := range sc.Roster.List {
 			if strings.Contains(e.Address.String(), ca) {
 				e.SetPrivate(scf.PrivateKeys[e.Address].Private)
 				// Populate the private key in the same array order
@@ -145,12 +173,36 @@ func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
 					e.ServiceIdentities[i] = network.NewServiceIdentity(sid.Name, suite, sid.Public, privkey)
 				}
 
-				server := NewServerTCP(e, suite)
+				storage, err := checkpointStorage(dir, index, sc.Config)
+				if err != nil {
+					return nil, xerrors.Errorf("checkpoint: %v", err)
+				}
+				hostDir := simulationHostDir(dir, index)
+				if err := os.MkdirAll(hostDir, 0750); err != nil {
+					return nil, xerrors.Errorf("host directory: %v", err)
+				}
+				var r *network.Router
+				if lm != nil {
+					_, port, _ := net.SplitHostPort(e.Address.NetworkAddress())
+					e.Address = network.NewLocalAddress("127.0.0.1:" + port)
+					r, err = network.NewLocalRouterWithManager(lm, e, suite)
+				} else {
+					r, err = network.NewTCPRouterWithListenAddr(e, suite, "")
+				}
+				if err != nil {
+					return nil, xerrors.Errorf("router: %v", err)
+				}
+				server := newServerWithDb(suite, hostDir, false, storage, r, e.GetPrivate(), nil)
 				server.UnauthOk = true
 				server.Quiet = true
 				scNew := *sc
 				scNew.Server = server
 				scNew.Overlay = server.overlay
+				scNew.HostIndex = index
+				scNew.HostDir = hostDir
+				scNew.Log = log.Prefix(simulationHostName(index))
+				scNew.registerCheckpoint(checkpointFile(dir, index))
+				scNew.registerRounds()
 				ret = append(ret, &scNew)
 			}
 		}
@@ -166,7 +218,9 @@ func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
 		for i := range sc.Roster.List {
 			_, port, _ := net.SplitHostPort(sc.Roster.List[i].Address.NetworkAddress())
 			// put 127.0.0.1 because 127.0.0.X is not reachable on Mac OS X
-			if sc.TLS {
+			if lm != nil {
+				sc.Roster.List[i].Address = network.NewLocalAddress("127.0.0.1:" + port)
+			} else if sc.TLS {
 				sc.Roster.List[i].Address = network.NewTLSAddress("127.0.0.1:" + port)
 			} else {
 				sc.Roster.List[i].Address = network.NewTCPAddress("127.0.0.1:" + port)
@@ -176,6 +230,18 @@ func LoadSimulationConfig(s, dir, ca string) ([]*SimulationConfig, error) {
 	return ret, nil
 }
 
+// simulationHostDir returns the directory of the server at the index of the
+// roster.
+func simulationHostDir(dir string, index int) string {
+	return filepath.Join(dir, simulationHostName(index))
+}
+
+// simulationHostName returns the name of the server at the index of the
+// roster in the directories and logs.
+func simulationHostName(index int) string {
+	return "host-" + strconv.Itoa(index)
+}
+
 // Save takes everything in the SimulationConfig structure and saves it to
 // dir + SimulationFileName
 func (sc *SimulationConfig) Save(dir string) error {
@@ -243,12 +309,30 @@ type SimulationBFTree struct {
 	Suite      string
 	PreScript  string // executable script to run before the simulation on each machine
 	TLS        bool   // tells if using TLS or PlainTCP addresses
+	// ResumeRound is the first round to run, after the last checkpoint if
+	// the simulation resumes
+	ResumeRound int
+	// HostsPerMachine is the number of hosts of every machine, in the order
+	// of the addresses, like "16 8 8"
+	HostsPerMachine string
+	// MachineShares splits the hosts in proportion of the shares of the
+	// machines, like "2 1 1", instead of evenly
+	MachineShares string
+	// Committee are the indexes in the roster of the hosts running the
+	// protocol, given to CommitteeTree, like "0 10-19"
+	Committee string
+	// CommitteeSize is the number of hosts running the protocol, drawn at
+	// random in every round
+	CommitteeSize int
+	// CommitteeSeed makes other random committees
+	CommitteeSeed int64
 }
 
 // CreateRoster creates an Roster with the host-names in 'addresses'.
 // It creates 's.Hosts' entries, starting from 'port' for each round through
-// 'addresses'. The network.Address(es) created are of type TLS or PlainTCP,
-// depending on the value 'TLS' in 'sc'.
+// 'addresses', split between them following SplitHosts. The
+// network.Address(es) created are of type TLS or PlainTCP, depending on the
+// value 'TLS' in 'sc'.
 func (s *SimulationBFTree) CreateRoster(sc *SimulationConfig, addresses []string, port int) {
 	start := time.Now()
 	sc.TLS = s.TLS
@@ -278,11 +362,21 @@ func (s *SimulationBFTree) CreateRoster(sc *SimulationConfig, addresses []string
 	}
 	entities := make([]*network.ServerIdentity, hosts)
 	log.Lvl3("Doing", hosts, "hosts")
+	counts, err := s.SplitHosts(nbrAddr)
+	if err != nil {
+		log.Fatal("Couldn't split the hosts:", err)
+	}
+	machines := AssignHosts(counts)
+	// how many hosts each machine already has
+	slots := make([]int, nbrAddr)
 	key := key.NewKeyPair(suite)
 	for c := 0; c < hosts; c++ {
 		key.Private.Add(key.Private, suite.Scalar().One())
 		key.Public.Add(key.Public, suite.Point().Base())
-		address := addresses[c%nbrAddr] + ":"
+		machine := machines[c]
+		slot := slots[machine]
+		slots[machine]++
+		address := addresses[machine] + ":"
 		var add network.Address
 		if localhosts {
 			// If we have localhosts, we have to search for an empty port
@@ -309,7 +403,7 @@ func (s *SimulationBFTree) CreateRoster(sc *SimulationConfig, addresses []string
 			}
 			log.Lvl4("Found free port", address)
 		} else {
-			address += strconv.Itoa(port + (c/nbrAddr)*2)
+			address += strconv.Itoa(port + slot*2)
 			if sc.TLS {
 				add = network.NewTLSAddress(address)
 			} else {
@@ -1,8 +1,10 @@
 package onet
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -126,6 +128,18 @@ func TestSimulationMultipleInstances(t *testing.T) {
 	if sc2[0].Server.ServerIdentity.ID.Equal(sc2[1].Server.ServerIdentity.ID) {
 		t.Fatal("Hosts are not copies")
 	}
+	// every host has its own index, directory and database
+	dirs := make(map[string]bool)
+	for _, c := range sc2 {
+		own, _ := c.Roster.Search(c.Server.ServerIdentity.ID)
+		require.Equal(t, own, c.HostIndex)
+		require.Equal(t, log.Prefix(fmt.Sprintf("host-%d", own)), c.Log)
+		dbs, err := filepath.Glob(filepath.Join(c.HostDir, "*.db"))
+		require.NoError(t, err)
+		require.Len(t, dbs, 1)
+		dirs[c.HostDir] = true
+	}
+	require.Len(t, dirs, 4)
 }
 
 func closeAll(scs []*SimulationConfig) {
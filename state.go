@@ -0,0 +1,213 @@
+package onet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// ServerState is a snapshot of the state of a server of a LocalTest, to be
+// compared with the one of the other servers, or of the same server at
+// another time.
+type ServerState struct {
+	// Server is the identity of the server.
+	Server *network.ServerIdentity
+	// Storage holds the values of the database of the services, by bucket
+	// and then by key.
+	Storage map[string]map[string][]byte
+	// Instances counts the protocol instances of the overlay by protocol.
+	Instances map[string]int
+	// PendingMessages is the number of protocol messages waiting for their
+	// tree or roster.
+	PendingMessages int
+	// Trees are the trees cached by the overlay, sorted.
+	Trees []TreeID
+}
+
+// State returns the state of the server.
+func (l *LocalTest) State(srv *Server) (*ServerState, error) {
+	l.panicClosed()
+	s := &ServerState{
+		Server:    srv.ServerIdentity,
+		Storage:   make(map[string]map[string][]byte),
+		Instances: make(map[string]int),
+	}
+	snap, err := takeSnapshot(srv.serviceManager.storage, func([]byte) bool { return true })
+	if err != nil {
+		return nil, xerrors.Errorf("snapshot: %v", err)
+	}
+	for _, b := range snap.Buckets {
+		values := make(map[string][]byte)
+		for _, e := range b.Entries {
+			values[string(e.Key)] = e.Value
+		}
+		s.Storage[string(b.Name)] = values
+	}
+
+	o := srv.overlay
+	o.instancesLock.Lock()
+	for _, tni := range o.instances {
+		s.Instances[tni.ProtocolName()]++
+	}
+	o.instancesLock.Unlock()
+	o.pendingMsgLock.Lock()
+	s.PendingMessages = len(o.pendingMsg)
+	o.pendingMsgLock.Unlock()
+	o.treeStorage.Lock()
+	for id, tree := range o.treeStorage.trees {
+		if tree != nil {
+			s.Trees = append(s.Trees, id)
+		}
+	}
+	o.treeStorage.Unlock()
+	sort.Slice(s.Trees, func(i, j int) bool {
+		return bytes.Compare(s.Trees[i][:], s.Trees[j][:]) < 0
+	})
+	return s, nil
+}
+
+// States returns the state of all the servers of the test, in the order of
+// their creation.
+func (l *LocalTest) States() ([]*ServerState, error) {
+	l.panicClosed()
+	var states []*ServerState
+	for _, srv := range l.order {
+		s, err := l.State(srv)
+		if err != nil {
+			return nil, xerrors.Errorf("%v: %v", srv.ServerIdentity, err)
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Diff returns the differences between the two states, one per line, or nil
+// if they are the same. The identities of the servers are not
+// compared.
+func (s *ServerState) Diff(other *ServerState) []string {
+	buckets := make(map[string][]byte)
+	for bucket := range s.Storage {
+		buckets[bucket] = nil
+	}
+	for bucket := range other.Storage {
+		buckets[bucket] = nil
+	}
+	var diffs []string
+	for _, bucket := range sortedKeys(buckets) {
+		diffs = append(diffs, diffValues(bucket, s.Storage[bucket], other.Storage[bucket])...)
+	}
+	counts := func(m map[string]int) map[string][]byte {
+		ret := make(map[string][]byte)
+		for name, n := range m {
+			ret[name] = []byte(fmt.Sprint(n))
+		}
+		return ret
+	}
+	diffs = append(diffs, diffValues("instances", counts(s.Instances), counts(other.Instances))...)
+	if s.PendingMessages != other.PendingMessages {
+		diffs = append(diffs, fmt.Sprintf("pending messages: %d != %d",
+			s.PendingMessages, other.PendingMessages))
+	}
+	if fmt.Sprint(s.Trees) != fmt.Sprint(other.Trees) {
+		diffs = append(diffs, fmt.Sprintf("trees: %v != %v", s.Trees, other.Trees))
+	}
+	return diffs
+}
+
+// SameStorage returns an error describing the differences if the servers
+// don't hold the same values in the bucket of their database, which is the
+// name of the service for the values stored with Context.Save. Without
+// servers, all the servers of the test are compared.
+func (l *LocalTest) SameStorage(bucket string, servers ...*Server) error {
+	return l.compare(servers, func(a, b *ServerState) []string {
+		return diffValues(bucket, a.Storage[bucket], b.Storage[bucket])
+	})
+}
+
+// SameValue returns an error if the servers don't hold the same value under
+// the key in the bucket of their database, which is one line for checking
+// that the servers converged. Without servers, all the servers of the test
+// are compared.
+func (l *LocalTest) SameValue(bucket string, key []byte, servers ...*Server) error {
+	value := func(s *ServerState) map[string][]byte {
+		v, ok := s.Storage[bucket][string(key)]
+		if !ok {
+			return nil
+		}
+		return map[string][]byte{string(key): v}
+	}
+	return l.compare(servers, func(a, b *ServerState) []string {
+		return diffValues(bucket, value(a), value(b))
+	})
+}
+
+// compare returns an error listing the differences between the first server
+// and each of the others.
+func (l *LocalTest) compare(servers []*Server, diff func(a, b *ServerState) []string) error {
+	l.panicClosed()
+	if len(servers) == 0 {
+		servers = l.order
+	}
+	var states []*ServerState
+	for _, srv := range servers {
+		s, err := l.State(srv)
+		if err != nil {
+			return xerrors.Errorf("%v: %v", srv.ServerIdentity, err)
+		}
+		states = append(states, s)
+	}
+	if len(states) < 2 {
+		return nil
+	}
+	var diffs []string
+	for _, s := range states[1:] {
+		for _, d := range diff(states[0], s) {
+			diffs = append(diffs, fmt.Sprintf("%v and %v: %s", states[0].Server, s.Server, d))
+		}
+	}
+	if len(diffs) > 0 {
+		return xerrors.New("the servers differ:\n" + strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// diffValues returns the keys of the bucket whose values differ.
+func diffValues(bucket string, a, b map[string][]byte) []string {
+	all := make(map[string][]byte)
+	for k, v := range a {
+		all[k] = v
+	}
+	for k, v := range b {
+		all[k] = v
+	}
+	var diffs []string
+	for _, k := range sortedKeys(all) {
+		va, oka := a[k]
+		vb, okb := b[k]
+		if oka != okb || !bytes.Equal(va, vb) {
+			diffs = append(diffs, fmt.Sprintf("%s %q: %s != %s", bucket, k,
+				showValue(va, oka), showValue(vb, okb)))
+		}
+	}
+	return diffs
+}
+
+func showValue(v []byte, ok bool) string {
+	if !ok {
+		return "missing"
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
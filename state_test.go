@@ -0,0 +1,50 @@
+package onet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTest_State(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(3, true)
+	bucket := []byte("state")
+	put := func(srv *Server, key, value string) {
+		require.NoError(t, srv.serviceManager.storage.Update(func(tx StorageTx) error {
+			if err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+			return tx.Put(bucket, []byte(key), []byte(value))
+		}))
+	}
+	for _, srv := range servers {
+		put(srv, "value", "1")
+	}
+	require.NoError(t, local.SameValue("state", []byte("value")))
+	require.NoError(t, local.SameStorage("state"))
+
+	put(servers[2], "value", "2")
+	put(servers[1], "other", "1")
+	require.NoError(t, local.SameValue("state", []byte("value"), servers[0], servers[1]))
+	err := local.SameValue("state", []byte("value"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `state "value": 31 != 32`)
+	err = local.SameStorage("state", servers[0], servers[1])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `state "other": missing != 31`)
+
+	states, err := local.States()
+	require.NoError(t, err)
+	require.Len(t, states, 3)
+	require.Equal(t, servers[1].ServerIdentity, states[1].Server)
+	require.Equal(t, []byte("1"), states[1].Storage["state"]["other"])
+	require.Equal(t, []TreeID{tree.ID}, states[0].Trees)
+	require.Empty(t, states[0].Instances)
+	require.Empty(t, states[0].Diff(states[0]))
+	require.Equal(t, []string{
+		`state "other": missing != 31`,
+		"trees: [" + tree.ID.String() + "] != []",
+	}, states[0].Diff(states[1]))
+}
@@ -1,5 +1,18 @@
 package onet
 
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
 // Status holds key/value pairs of the status to be returned to the requester.
 type Status struct {
 	Field map[string]string
@@ -37,3 +50,231 @@ func (s *statusReporterStruct) ReportStatus() map[string]*Status {
 	}
 	return m
 }
+
+// DetailedStatus is the structured status of a server, as returned by
+// Server.DetailedStatus and served as JSON on /status of the websocket.
+type DetailedStatus struct {
+	Build       BuildStatus
+	Uptime      time.Duration
+	Services    []string
+	Protocols   []ProtocolStatus
+	Connections []PeerStatus
+	Queues      QueueStatus
+	TreeCache   TreeCacheStats
+	// Storage holds the size of the buckets of the database, which are
+	// named after the services using them.
+	Storage []BucketStatus
+	// ClockSkews are the clocks of the peers sampled by the heartbeats.
+	ClockSkews []ClockSkewStatus
+}
+
+// BuildStatus tells which binary a server is running.
+type BuildStatus struct {
+	GoVersion string
+	OS        string
+	Arch      string
+	// Module and Version are the main module of the binary, and its version
+	// as known by the go tool, like "(devel)" for a local build.
+	Module  string
+	Version string
+	// Deps are the versions of the modules the binary was built with.
+	Deps map[string]string `json:",omitempty"`
+}
+
+// ProtocolStatus describes a protocol instance running on the server.
+type ProtocolStatus struct {
+	Name  string
+	Token string
+	// TreeSize is the number of nodes of the tree of the protocol, and
+	// Root the address of its root.
+	TreeSize int
+	Root     network.Address
+	IsRoot   bool
+	Age      time.Duration
+	// QueuedMessages is the number of messages waiting to be dispatched
+	// to the protocol.
+	QueuedMessages int
+}
+
+// PeerStatus describes a connection of the server to another node.
+type PeerStatus struct {
+	// ID is the ID of the ServerIdentity of the peer.
+	ID     string
+	Type   network.ConnType
+	Local  network.Address
+	Remote network.Address
+	Tx     uint64
+	Rx     uint64
+	// RTT is the round-trip time measured by the kernel, or 0 if it isn't
+	// known.
+	RTT time.Duration
+	// Capabilities are the ones the peer advertised.
+	Capabilities []network.Capability
+}
+
+// QueueStatus holds the number of messages the overlay keeps until it can
+// handle them.
+type QueueStatus struct {
+	// PendingMessages wait for their tree or roster.
+	PendingMessages int
+	// PendingTrees wait for their roster.
+	PendingTrees int
+	// PendingConfigs wait for their protocol instance.
+	PendingConfigs int
+	// FinishedInstances are remembered to drop their late messages.
+	FinishedInstances int
+	// DispatchQueued protocol messages wait for a worker of the overlay,
+	// DispatchDropped were dropped by DispatchDropOldest and
+	// DispatchDuplicates by the dedup filters.
+	DispatchQueued     int
+	DispatchDropped    uint64
+	DispatchDuplicates uint64
+}
+
+// BucketStatus is the size of a bucket of the database.
+type BucketStatus struct {
+	Name  string
+	Keys  int
+	Bytes int
+}
+
+// DetailedStatus returns the state of the protocols, connections, queues
+// and database of the server.
+func (c *Server) DetailedStatus() (*DetailedStatus, error) {
+	st := &DetailedStatus{
+		Build:      buildStatus(),
+		Uptime:     time.Since(c.started),
+		Services:   c.serviceManager.availableServices(),
+		Protocols:  c.overlay.protocolStatus(),
+		Queues:     c.overlay.queueStatus(),
+		TreeCache:  c.overlay.TreeCacheStats(),
+		ClockSkews: c.overlay.clockSkews.status(),
+	}
+	for _, cs := range c.Router.Connections() {
+		st.Connections = append(st.Connections, PeerStatus{
+			ID:           cs.ID.String(),
+			Type:         cs.Type,
+			Local:        cs.Local,
+			Remote:       cs.Remote,
+			Tx:           cs.Tx,
+			Rx:           cs.Rx,
+			RTT:          cs.RTT,
+			Capabilities: c.PeerCapabilities(cs.ID),
+		})
+	}
+	sort.Strings(st.Services)
+	sort.Slice(st.Connections, func(i, j int) bool {
+		return st.Connections[i].Remote < st.Connections[j].Remote
+	})
+	if c.serviceManager.storage != nil {
+		buckets, err := storageStatus(c.serviceManager.storage)
+		if err != nil {
+			return nil, xerrors.Errorf("reading storage: %v", err)
+		}
+		st.Storage = buckets
+	}
+	return st, nil
+}
+
+func buildStatus() BuildStatus {
+	bs := BuildStatus{
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		bs.Module = info.Main.Path
+		bs.Version = info.Main.Version
+		bs.Deps = make(map[string]string)
+		for _, dep := range info.Deps {
+			bs.Deps[dep.Path] = dep.Version
+		}
+	}
+	return bs
+}
+
+func (o *Overlay) protocolStatus() []ProtocolStatus {
+	o.instancesLock.Lock()
+	tnis := make([]*TreeNodeInstance, 0, len(o.instances))
+	for _, tni := range o.instances {
+		tnis = append(tnis, tni)
+	}
+	o.instancesLock.Unlock()
+
+	ret := make([]ProtocolStatus, 0, len(tnis))
+	for _, tni := range tnis {
+		ps := ProtocolStatus{
+			Name:   tni.ProtocolName(),
+			Token:  tni.Token().ID().String(),
+			IsRoot: tni.IsRoot(),
+			Age:    time.Since(tni.created),
+		}
+		if tree := o.treeStorage.Get(tni.Token().TreeID); tree != nil {
+			ps.TreeSize = tree.Size()
+			ps.Root = tree.Root.ServerIdentity.Address
+		}
+		tni.msgDispatchQueueMutex.Lock()
+		ps.QueuedMessages = len(tni.msgDispatchQueue)
+		tni.msgDispatchQueueMutex.Unlock()
+		ret = append(ret, ps)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Age > ret[j].Age })
+	return ret
+}
+
+func (o *Overlay) queueStatus() QueueStatus {
+	var qs QueueStatus
+	o.pendingMsgLock.Lock()
+	qs.PendingMessages = len(o.pendingMsg)
+	o.pendingMsgLock.Unlock()
+	o.pendingTreeLock.Lock()
+	for _, tms := range o.pendingTreeMarshal {
+		qs.PendingTrees += len(tms)
+	}
+	o.pendingTreeLock.Unlock()
+	o.pendingConfigsMut.Lock()
+	qs.PendingConfigs = o.pendingConfigs.len()
+	o.pendingConfigsMut.Unlock()
+	o.instancesLock.Lock()
+	qs.FinishedInstances = o.finished.len()
+	o.instancesLock.Unlock()
+	qs.DispatchQueued, qs.DispatchDropped, qs.DispatchDuplicates = o.queues.len()
+	return qs
+}
+
+// storageStatus counts the keys and bytes of every bucket.
+func storageStatus(s Storage) ([]BucketStatus, error) {
+	var ret []BucketStatus
+	err := s.View(func(tx StorageTx) error {
+		for _, name := range tx.Buckets() {
+			bs := BucketStatus{Name: string(name)}
+			err := tx.ForEach(name, func(k, v []byte) error {
+				bs.Keys++
+				bs.Bytes += len(k) + len(v)
+				return nil
+			})
+			if err != nil {
+				return xerrors.Errorf("bucket %s: %v", name, err)
+			}
+			ret = append(ret, bs)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (c *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := c.DetailedStatus()
+	if err != nil {
+		log.Error("DetailedStatus:", err)
+		http.Error(w, "couldn't get the status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(st)
+}
@@ -1,12 +1,16 @@
 package onet
 
 import (
+	"encoding/json"
+	"net/http"
 	"strings"
 	"testing"
 
 	"strconv"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
 )
 
 func TestSRStruct(t *testing.T) {
@@ -30,6 +34,47 @@ func TestStatusHost(t *testing.T) {
 	a := ServiceFactory.RegisteredServiceNames()
 	services := strings.Split(stats.Field["Available_Services"], ",")
 	assert.Equal(t, len(services), len(a))
+	assert.Len(t, stats.Field["Registry"], 16)
+}
+
+func TestServer_DetailedStatus(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	l := NewTCPTest(tSuite)
+	defer l.CloseAll()
+	servers, _, tree := l.GenTree(2, true)
+
+	p, err := l.CreateProtocol(ProtocolChannelsName, tree)
+	require.NoError(t, err)
+	defer p.(*ProtocolChannels).Done()
+	_, err = servers[0].Send(servers[1].ServerIdentity, &NodeTestMsg{})
+	require.NoError(t, err)
+
+	st, err := servers[0].DetailedStatus()
+	require.NoError(t, err)
+	require.NotEmpty(t, st.Build.GoVersion)
+	require.Equal(t, 1, len(st.Protocols))
+	ps := st.Protocols[0]
+	require.Equal(t, ProtocolChannelsName, ps.Name)
+	require.Equal(t, 2, ps.TreeSize)
+	require.Equal(t, servers[0].ServerIdentity.Address, ps.Root)
+	require.True(t, ps.IsRoot)
+	require.NotEqual(t, 0, len(st.Connections))
+	require.Equal(t, servers[1].ServerIdentity.ID.String(), st.Connections[0].ID)
+	require.NotZero(t, st.Connections[0].Tx)
+	require.NotEmpty(t, st.Storage)
+
+	port, err := strconv.Atoi(servers[0].ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	resp, err := http.Get("http://" + servers[0].ServerIdentity.Address.Host() + ":" +
+		strconv.Itoa(port+1) + "/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply DetailedStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.Equal(t, 1, len(reply.Protocols))
+	require.Equal(t, ps.Token, reply.Protocols[0].Token)
 }
 
 type dummyTestReporter struct {
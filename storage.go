@@ -0,0 +1,485 @@
+package onet
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"go.dedis.ch/onet/v4/log"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// DefaultStorage is the name of the storage backend used when none is
+// given. It can be overridden with the CONODE_STORAGE environment variable.
+const DefaultStorage = "bbolt"
+
+// Storage is where the services of a server persist their data. The data
+// is organised in buckets of key/value pairs, and all accesses go through
+// transactions.
+type Storage interface {
+	// View runs f in a read-only transaction.
+	View(f func(tx StorageTx) error) error
+	// Update runs f in a read-write transaction. If f returns an error,
+	// none of its changes are kept.
+	Update(f func(tx StorageTx) error) error
+	// Close releases the resources of the storage.
+	Close() error
+}
+
+// StorageTx is a transaction on a Storage. The slices returned by a
+// transaction are only valid until the transaction ends, and the slices
+// given to it must not be modified until it ends.
+type StorageTx interface {
+	// CreateBucket creates the bucket if it doesn't exist yet.
+	CreateBucket(name []byte) error
+	// Get returns the value of the key, or nil if the key or the bucket
+	// doesn't exist.
+	Get(bucket, key []byte) ([]byte, error)
+	// Put stores the value under the key in an existing bucket.
+	Put(bucket, key, value []byte) error
+	// Delete removes the key from the bucket.
+	Delete(bucket, key []byte) error
+	// ForEach calls f for every key of the bucket, in the order of the keys.
+	ForEach(bucket []byte, f func(k, v []byte) error) error
+	// Buckets returns the names of all the buckets, sorted.
+	Buckets() [][]byte
+}
+
+// StorageOpener opens a storage backend. The path is the one of the
+// database of the server and can be ignored by the backends that don't
+// persist their data.
+type StorageOpener func(path string) (Storage, error)
+
+var storageBackends = struct {
+	openers map[string]StorageOpener
+	sync.Mutex
+}{openers: map[string]StorageOpener{
+	"badger": openBadgerStorage,
+	"bbolt":  openBboltStorage,
+	"memory": func(string) (Storage, error) { return NewMemoryStorage(), nil },
+}}
+
+// RegisterStorage makes a storage backend available under the given name,
+// so that other databases can be plugged in without onet depending on
+// them. Onet has the bbolt, the badger and the memory backends.
+func RegisterStorage(name string, open StorageOpener) error {
+	storageBackends.Lock()
+	defer storageBackends.Unlock()
+	if _, ok := storageBackends.openers[name]; ok {
+		return xerrors.New("storage already registered: " + name)
+	}
+	storageBackends.openers[name] = open
+	return nil
+}
+
+func storageFromEnv() string {
+	if s := os.Getenv("CONODE_STORAGE"); s != "" {
+		return s
+	}
+	return DefaultStorage
+}
+
+// StorageConfig selects the storage of a server.
+type StorageConfig struct {
+	// Backend is the name of a registered backend, the default one if
+	// empty.
+	Backend string
+	// Key, if set, encrypts the values of the storage. It must be
+	// StorageKeySize bytes long.
+	Key []byte
+	// OldKeys are the keys that encrypted the storage before Key. The
+	// values are re-encrypted with Key when the server starts.
+	OldKeys [][]byte
+	// Restore is the file of a snapshot written by Server.Backup. If set,
+	// the storage is replaced by the snapshot before the services start.
+	Restore string
+}
+
+// openStorage opens the storage described by cfg.
+func openStorage(cfg StorageConfig, path string) (Storage, error) {
+	name := cfg.Backend
+	if name == "" {
+		name = storageFromEnv()
+	}
+	storageBackends.Lock()
+	open, ok := storageBackends.openers[name]
+	storageBackends.Unlock()
+	if !ok {
+		return nil, xerrors.New("unknown storage: " + name)
+	}
+	st, err := open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("opening %s storage: %v", name, err)
+	}
+	if cfg.Key != nil {
+		est, err := NewEncryptedStorage(st, cfg.Key, cfg.OldKeys...)
+		if err != nil {
+			st.Close()
+			return nil, xerrors.Errorf("encrypting storage: %v", err)
+		}
+		st = est
+	}
+	if cfg.Restore != "" {
+		if err := restoreSnapshotFile(st, cfg.Restore); err != nil {
+			st.Close()
+			return nil, xerrors.Errorf("restoring: %v", err)
+		}
+	}
+	return st, nil
+}
+
+// Compacter is implemented by the storages that can give back to the file
+// system the space of the deleted values.
+type Compacter interface {
+	Compact() error
+}
+
+// Syncer is implemented by the storages that buffer the changes, so that
+// they can be written to disk before the server closes.
+type Syncer interface {
+	Sync() error
+}
+
+// bboltStorage stores all the data in one bbolt file.
+type bboltStorage struct {
+	db *bbolt.DB
+	// whether the db has been given to the services, which keep it
+	shared bool
+	// the db is replaced by Compact
+	sync.RWMutex
+}
+
+func openBboltStorage(path string) (Storage, error) {
+	db, err := openDb(path)
+	if err != nil {
+		return nil, err
+	}
+	return &bboltStorage{db: db}, nil
+}
+
+func (s *bboltStorage) View(f func(tx StorageTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return f(bboltTx{tx})
+	})
+}
+
+func (s *bboltStorage) Update(f func(tx StorageTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return f(bboltTx{tx})
+	})
+}
+
+// sharedDB returns the db to a service, which may keep it, so that Compact
+// can't replace it anymore.
+func (s *bboltStorage) sharedDB() *bbolt.DB {
+	s.Lock()
+	defer s.Unlock()
+	s.shared = true
+	return s.db
+}
+
+func (s *bboltStorage) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.db.Close()
+}
+
+// Sync implements the Syncer interface.
+func (s *bboltStorage) Sync() error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.db.Sync()
+}
+
+// Compact implements the Compacter interface. bbolt never shrinks its
+// file, so the data is copied to a new file that replaces the old one.
+// All the transactions wait until it is done. It refuses to compact once a
+// service got the db with GetAdditionalBucket, as it would keep the closed
+// one.
+func (s *bboltStorage) Compact() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.shared {
+		return xerrors.New("the db is used directly by a service")
+	}
+	path := s.db.Path()
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	dst, err := openDb(tmp)
+	if err != nil {
+		return xerrors.Errorf("opening copy: %v", err)
+	}
+	err = s.db.View(func(src *bbolt.Tx) error {
+		return dst.Update(func(tx *bbolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bbolt.Bucket) error {
+				nb, err := tx.CreateBucket(name)
+				if err != nil {
+					return xerrors.Errorf("creating bucket: %v", err)
+				}
+				return copyBucket(nb, b)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("copying: %v", err)
+	}
+	if err := s.db.Close(); err != nil {
+		return xerrors.Errorf("closing db: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		// the old file is still there, so we can go on with it
+		log.Error("Couldn't replace the db:", err)
+		os.Remove(tmp)
+	}
+	db, err := openDb(path)
+	if err != nil {
+		return xerrors.Errorf("reopening db: %v", err)
+	}
+	s.db = db
+	return nil
+}
+
+// copyBucket copies the keys and the nested buckets of src to dst.
+func copyBucket(dst, src *bbolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nb, err := dst.CreateBucket(k)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		return copyBucket(nb, src.Bucket(k))
+	})
+}
+
+// GetStatus implements the StatusReporter interface.
+func (s *bboltStorage) GetStatus() *Status {
+	s.RLock()
+	st := s.db.Stats()
+	s.RUnlock()
+	return &Status{Field: map[string]string{
+		"Open":             "true",
+		"FreePageN":        strconv.Itoa(st.FreePageN),
+		"PendingPageN":     strconv.Itoa(st.PendingPageN),
+		"FreeAlloc":        strconv.Itoa(st.FreeAlloc),
+		"FreelistInuse":    strconv.Itoa(st.FreelistInuse),
+		"TxN":              strconv.Itoa(st.TxN),
+		"OpenTxN":          strconv.Itoa(st.OpenTxN),
+		"Tx.PageCount":     strconv.Itoa(st.TxStats.PageCount),
+		"Tx.PageAlloc":     strconv.Itoa(st.TxStats.PageAlloc),
+		"Tx.CursorCount":   strconv.Itoa(st.TxStats.CursorCount),
+		"Tx.NodeCount":     strconv.Itoa(st.TxStats.NodeCount),
+		"Tx.NodeDeref":     strconv.Itoa(st.TxStats.NodeDeref),
+		"Tx.Rebalance":     strconv.Itoa(st.TxStats.Rebalance),
+		"Tx.RebalanceTime": st.TxStats.RebalanceTime.String(),
+		"Tx.Split":         strconv.Itoa(st.TxStats.Split),
+		"Tx.Spill":         strconv.Itoa(st.TxStats.Spill),
+		"Tx.SpillTime":     st.TxStats.SpillTime.String(),
+		"Tx.Write":         strconv.Itoa(st.TxStats.Write),
+		"Tx.WriteTime":     st.TxStats.WriteTime.String(),
+	}}
+}
+
+type bboltTx struct {
+	tx *bbolt.Tx
+}
+
+func (t bboltTx) CreateBucket(name []byte) error {
+	_, err := t.tx.CreateBucketIfNotExists(name)
+	return err
+}
+
+func (t bboltTx) Get(bucket, key []byte) ([]byte, error) {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	return b.Get(key), nil
+}
+
+func (t bboltTx) Put(bucket, key, value []byte) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return xerrors.New("no such bucket: " + string(bucket))
+	}
+	return b.Put(key, value)
+}
+
+func (t bboltTx) Delete(bucket, key []byte) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return xerrors.New("no such bucket: " + string(bucket))
+	}
+	return b.Delete(key)
+}
+
+func (t bboltTx) ForEach(bucket []byte, f func(k, v []byte) error) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return xerrors.New("no such bucket: " + string(bucket))
+	}
+	return b.ForEach(f)
+}
+
+func (t bboltTx) Buckets() [][]byte {
+	var names [][]byte
+	t.tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+		names = append(names, name)
+		return nil
+	})
+	return names
+}
+
+// MemoryStorage keeps all the data in memory. It is lost when the server
+// closes, which makes it useful for tests.
+type MemoryStorage struct {
+	buckets map[string]map[string][]byte
+	sync.RWMutex
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{buckets: make(map[string]map[string][]byte)}
+}
+
+// View implements the Storage interface.
+func (s *MemoryStorage) View(f func(tx StorageTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	return f(&memoryTx{s: s})
+}
+
+// Update implements the Storage interface. The changes are undone if f
+// returns an error.
+func (s *MemoryStorage) Update(f func(tx StorageTx) error) error {
+	s.Lock()
+	defer s.Unlock()
+	tx := &memoryTx{s: s, writable: true}
+	if err := f(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// Close implements the Storage interface.
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// memoryUndo restores a key of a bucket, or removes the bucket if key is
+// nil.
+type memoryUndo struct {
+	bucket, key string
+	value       []byte
+	existed     bool
+}
+
+type memoryTx struct {
+	s        *MemoryStorage
+	writable bool
+	undo     []memoryUndo
+}
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		u := t.undo[i]
+		switch {
+		case u.key == "" && !u.existed:
+			delete(t.s.buckets, u.bucket)
+		case u.existed:
+			t.s.buckets[u.bucket][u.key] = u.value
+		default:
+			delete(t.s.buckets[u.bucket], u.key)
+		}
+	}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) error {
+	if !t.writable {
+		return xerrors.New("read-only transaction")
+	}
+	if len(name) == 0 {
+		return xerrors.New("empty bucket name")
+	}
+	if _, ok := t.s.buckets[string(name)]; !ok {
+		t.s.buckets[string(name)] = make(map[string][]byte)
+		t.undo = append(t.undo, memoryUndo{bucket: string(name)})
+	}
+	return nil
+}
+
+func (t *memoryTx) Get(bucket, key []byte) ([]byte, error) {
+	return t.s.buckets[string(bucket)][string(key)], nil
+}
+
+func (t *memoryTx) set(bucket, key []byte, value []byte, del bool) error {
+	if !t.writable {
+		return xerrors.New("read-only transaction")
+	}
+	b, ok := t.s.buckets[string(bucket)]
+	if !ok {
+		return xerrors.New("no such bucket: " + string(bucket))
+	}
+	if len(key) == 0 {
+		return xerrors.New("empty key")
+	}
+	old, existed := b[string(key)]
+	t.undo = append(t.undo, memoryUndo{string(bucket), string(key), old, existed})
+	if del {
+		delete(b, string(key))
+	} else {
+		b[string(key)] = append([]byte{}, value...)
+	}
+	return nil
+}
+
+func (t *memoryTx) Put(bucket, key, value []byte) error {
+	return t.set(bucket, key, value, false)
+}
+
+func (t *memoryTx) Delete(bucket, key []byte) error {
+	return t.set(bucket, key, nil, true)
+}
+
+func (t *memoryTx) ForEach(bucket []byte, f func(k, v []byte) error) error {
+	b, ok := t.s.buckets[string(bucket)]
+	if !ok {
+		return xerrors.New("no such bucket: " + string(bucket))
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := f([]byte(k), b[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) Buckets() [][]byte {
+	names := make([]string, 0, len(t.s.buckets))
+	for n := range t.s.buckets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	res := make([][]byte, len(names))
+	for i, n := range names {
+		res[i] = []byte(n)
+	}
+	return res
+}
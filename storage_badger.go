@@ -0,0 +1,186 @@
+package onet
+
+import (
+	"encoding/binary"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// the discard ratio of the value log files rewritten by Compact
+const badgerGCDiscardRatio = 0.5
+
+// The buckets of the badgerStorage are prefixes of its keys: the names of
+// the buckets are stored under badgerBucketPrefix, and the values under
+// badgerKeyPrefix followed by the length of the bucket name, the name and
+// the key, so that the keys of a bucket are contiguous and sorted.
+const (
+	badgerBucketPrefix = 'b'
+	badgerKeyPrefix    = 'k'
+)
+
+// badgerStorage stores the data in a BadgerDB directory, next to where the
+// bbolt file would be.
+type badgerStorage struct {
+	db *badger.DB
+}
+
+func openBadgerStorage(path string) (Storage, error) {
+	dir := strings.TrimSuffix(path, ".db") + ".badger"
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(badgerLogger{}))
+	if err != nil {
+		return nil, xerrors.Errorf("opening badger: %v", err)
+	}
+	return &badgerStorage{db: db}, nil
+}
+
+func (s *badgerStorage) View(f func(tx StorageTx) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		return f(badgerTx{txn})
+	})
+}
+
+func (s *badgerStorage) Update(f func(tx StorageTx) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return f(badgerTx{txn})
+	})
+}
+
+func (s *badgerStorage) Close() error {
+	return s.db.Close()
+}
+
+// Sync implements the Syncer interface.
+func (s *badgerStorage) Sync() error {
+	return s.db.Sync()
+}
+
+// Compact implements the Compacter interface. It rewrites the value log
+// files until none has enough deleted values to be worth it.
+func (s *badgerStorage) Compact() error {
+	for {
+		err := s.db.RunValueLogGC(badgerGCDiscardRatio)
+		if xerrors.Is(err, badger.ErrNoRewrite) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type badgerTx struct {
+	txn *badger.Txn
+}
+
+func badgerBucketKey(name []byte) []byte {
+	return append([]byte{badgerBucketPrefix}, name...)
+}
+
+func badgerKey(bucket, key []byte) []byte {
+	k := make([]byte, 5, 5+len(bucket)+len(key))
+	k[0] = badgerKeyPrefix
+	binary.BigEndian.PutUint32(k[1:], uint32(len(bucket)))
+	k = append(k, bucket...)
+	return append(k, key...)
+}
+
+func (t badgerTx) hasBucket(name []byte) (bool, error) {
+	_, err := t.txn.Get(badgerBucketKey(name))
+	if xerrors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkBucket returns an error if the bucket doesn't exist.
+func (t badgerTx) checkBucket(name []byte) error {
+	ok, err := t.hasBucket(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return xerrors.New("no such bucket: " + string(name))
+	}
+	return nil
+}
+
+func (t badgerTx) CreateBucket(name []byte) error {
+	if len(name) == 0 {
+		return xerrors.New("empty bucket name")
+	}
+	ok, err := t.hasBucket(name)
+	if err != nil || ok {
+		return err
+	}
+	return t.txn.Set(badgerBucketKey(name), nil)
+}
+
+func (t badgerTx) Get(bucket, key []byte) ([]byte, error) {
+	item, err := t.txn.Get(badgerKey(bucket, key))
+	if xerrors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTx) Put(bucket, key, value []byte) error {
+	if err := t.checkBucket(bucket); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return xerrors.New("empty key")
+	}
+	return t.txn.Set(badgerKey(bucket, key), value)
+}
+
+func (t badgerTx) Delete(bucket, key []byte) error {
+	if err := t.checkBucket(bucket); err != nil {
+		return err
+	}
+	return t.txn.Delete(badgerKey(bucket, key))
+}
+
+func (t badgerTx) ForEach(bucket []byte, f func(k, v []byte) error) error {
+	if err := t.checkBucket(bucket); err != nil {
+		return err
+	}
+	prefix := badgerKey(bucket, nil)
+	it := t.txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := f(item.KeyCopy(nil)[len(prefix):], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t badgerTx) Buckets() [][]byte {
+	prefix := []byte{badgerBucketPrefix}
+	it := t.txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+	defer it.Close()
+	var names [][]byte
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		names = append(names, it.Item().KeyCopy(nil)[1:])
+	}
+	return names
+}
+
+// badgerLogger sends the logs of badger to the log of onet.
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(f string, args ...interface{})   { log.Errorf("badger: "+f, args...) }
+func (badgerLogger) Warningf(f string, args ...interface{}) { log.Warnf("badger: "+f, args...) }
+func (badgerLogger) Infof(f string, args ...interface{})    { log.Lvlf3("badger: "+f, args...) }
+func (badgerLogger) Debugf(f string, args ...interface{})   { log.Lvlf4("badger: "+f, args...) }
@@ -0,0 +1,186 @@
+package onet
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+func TestStorage(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	bs, err := openStorage(StorageConfig{Backend: "bbolt"}, path.Join(tmp, "test.db"))
+	require.NoError(t, err)
+	ms, err := openStorage(StorageConfig{Backend: "memory"}, "")
+	require.NoError(t, err)
+	gs, err := openStorage(StorageConfig{Backend: "badger"}, path.Join(tmp, "test.db"))
+	require.NoError(t, err)
+	_, err = openStorage(StorageConfig{Backend: "unknown"}, "")
+	require.Error(t, err)
+
+	for _, st := range []Storage{bs, ms, gs} {
+		bucket := []byte("bucket")
+		require.NoError(t, st.Update(func(tx StorageTx) error {
+			require.NoError(t, tx.CreateBucket(bucket))
+			require.NoError(t, tx.CreateBucket(bucket))
+			require.NoError(t, tx.Put(bucket, []byte("b"), []byte("2")))
+			require.NoError(t, tx.Put(bucket, []byte("a"), []byte("1")))
+			require.Error(t, tx.Put([]byte("none"), []byte("a"), []byte("1")))
+			return nil
+		}))
+
+		// a failing transaction doesn't change anything
+		err := st.Update(func(tx StorageTx) error {
+			require.NoError(t, tx.CreateBucket([]byte("other")))
+			require.NoError(t, tx.Put(bucket, []byte("a"), []byte("3")))
+			require.NoError(t, tx.Delete(bucket, []byte("b")))
+			return xerrors.New("abort")
+		})
+		require.Error(t, err)
+
+		require.NoError(t, st.View(func(tx StorageTx) error {
+			require.Equal(t, []byte("1"), getValue(t, tx, bucket, []byte("a")))
+			require.Nil(t, getValue(t, tx, bucket, []byte("c")))
+			require.Nil(t, getValue(t, tx, []byte("none"), []byte("a")))
+			require.Equal(t, [][]byte{bucket}, tx.Buckets())
+			var keys []string
+			require.NoError(t, tx.ForEach(bucket, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}))
+			require.Equal(t, []string{"a", "b"}, keys)
+			require.Error(t, tx.Put(bucket, []byte("a"), []byte("3")))
+			return nil
+		}))
+		require.NoError(t, st.Close())
+	}
+
+	require.Error(t, RegisterStorage("memory", nil))
+}
+
+func TestStorage_Compact(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	file := path.Join(tmp, "test.db")
+	st, err := openStorage(StorageConfig{Backend: "bbolt"}, file)
+	require.NoError(t, err)
+	defer st.Close()
+
+	bucket := []byte("bucket")
+	value := make([]byte, 1024)
+	require.NoError(t, st.Update(func(tx StorageTx) error {
+		require.NoError(t, tx.CreateBucket(bucket))
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, tx.Put(bucket, []byte(strconv.Itoa(i)), value))
+		}
+		return nil
+	}))
+	require.NoError(t, st.Update(func(tx StorageTx) error {
+		for i := 1; i < 1000; i++ {
+			require.NoError(t, tx.Delete(bucket, []byte(strconv.Itoa(i))))
+		}
+		return nil
+	}))
+	before, err := os.Stat(file)
+	require.NoError(t, err)
+
+	require.NoError(t, st.(Compacter).Compact())
+	after, err := os.Stat(file)
+	require.NoError(t, err)
+	require.True(t, after.Size() < before.Size())
+	require.NoError(t, st.View(func(tx StorageTx) error {
+		require.Equal(t, value, getValue(t, tx, bucket, []byte("0")))
+		require.Nil(t, getValue(t, tx, bucket, []byte("1")))
+		return nil
+	}))
+
+	// the db given to a service isn't closed by a compaction
+	db := st.(*bboltStorage).sharedDB()
+	require.Error(t, st.(Compacter).Compact())
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		require.Equal(t, value, tx.Bucket(bucket).Get([]byte("0")))
+		return nil
+	}))
+
+	var ms Storage = NewMemoryStorage()
+	_, ok := ms.(Compacter)
+	require.False(t, ok)
+}
+
+func TestServer_MemoryStorage(t *testing.T) {
+	os.Setenv("CONODE_STORAGE", "memory")
+	defer os.Unsetenv("CONODE_STORAGE")
+	var c *Context
+	RegisterNewService("memoryStorageService", func(ctx *Context) (Service, error) {
+		c = ctx
+		return &DummyService{c: ctx}, nil
+	})
+	defer UnregisterService("memoryStorageService")
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(1)
+	_, ok := servers[0].serviceManager.storage.(*MemoryStorage)
+	require.True(t, ok)
+
+	testLoadSave(t, c)
+	require.NoError(t, c.SaveVersion(2))
+	v, err := c.LoadVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+	require.Equal(t, "true", servers[0].serviceManager.GetStatus().Field["Open"])
+}
+
+// getValue returns the value of the key in the transaction, and fails the
+// test if it can't be read.
+func getValue(t *testing.T, tx StorageTx, bucket, key []byte) []byte {
+	v, err := tx.Get(bucket, key)
+	require.NoError(t, err)
+	return v
+}
+
+func TestStorage_Badger(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	file := path.Join(tmp, "test.db")
+	st, err := openStorage(StorageConfig{Backend: "badger"}, file)
+	require.NoError(t, err)
+
+	// the keys of a bucket don't mix with the ones of a bucket whose name
+	// starts the same
+	require.NoError(t, st.Update(func(tx StorageTx) error {
+		for _, b := range []string{"a", "ab"} {
+			require.NoError(t, tx.CreateBucket([]byte(b)))
+			require.NoError(t, tx.Put([]byte(b), []byte("key"), []byte(b)))
+		}
+		return nil
+	}))
+	require.NoError(t, st.(Syncer).Sync())
+	require.NoError(t, st.(Compacter).Compact())
+	require.NoError(t, st.Close())
+
+	// the data is kept in a directory next to the bbolt file
+	_, err = os.Stat(path.Join(tmp, "test.badger"))
+	require.NoError(t, err)
+	st, err = openStorage(StorageConfig{Backend: "badger"}, file)
+	require.NoError(t, err)
+	defer st.Close()
+	require.NoError(t, st.View(func(tx StorageTx) error {
+		require.Equal(t, [][]byte{[]byte("a"), []byte("ab")}, tx.Buckets())
+		var values []string
+		require.NoError(t, tx.ForEach([]byte("a"), func(k, v []byte) error {
+			values = append(values, string(k)+"="+string(v))
+			return nil
+		}))
+		require.Equal(t, []string{"key=a"}, values)
+		return nil
+	}))
+}
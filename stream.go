@@ -0,0 +1,441 @@
+package onet
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+	uuid "gopkg.in/satori/go.uuid.v1"
+)
+
+// StreamChunkSize is the maximum size of the data carried by one chunk of a
+// Stream.
+const StreamChunkSize = 64 * 1024
+
+// StreamWindow is the number of chunks a writer can send before the reader
+// consumed them.
+const StreamWindow = 16
+
+// how long OpenStream waits for the peer to accept, and a writer waits for
+// the reader to make room in the window
+const defaultStreamTimeout = 10 * time.Second
+
+// StreamOpenMsgID of StreamOpen message as registered in network
+var StreamOpenMsgID = network.RegisterMessage(StreamOpen{})
+
+// StreamOpenReplyMsgID of StreamOpenReply message as registered in network
+var StreamOpenReplyMsgID = network.RegisterMessage(StreamOpenReply{})
+
+// StreamChunkMsgID of StreamChunk message as registered in network
+var StreamChunkMsgID = network.RegisterMessage(StreamChunk{})
+
+// StreamAckMsgID of StreamAck message as registered in network
+var StreamAckMsgID = network.RegisterMessage(StreamAck{})
+
+// StreamOpen asks the service of a peer to accept a new stream.
+type StreamOpen struct {
+	ID      uuid.UUID
+	Service string
+}
+
+// StreamOpenReply tells whether the stream has been accepted.
+type StreamOpenReply struct {
+	ID    uuid.UUID
+	Error string
+}
+
+// StreamChunk carries data of a stream. Chunks are numbered from 0 and the
+// last one has EOF set.
+type StreamChunk struct {
+	ID   uuid.UUID
+	Seq  uint64
+	Data []byte
+	EOF  bool
+}
+
+// StreamAck tells the writer how many chunks the reader consumed, which
+// gives it room to send more.
+type StreamAck struct {
+	ID       uuid.UUID
+	Consumed uint64
+}
+
+// StreamHandler is called in a new go-routine for every stream a peer
+// opens to the service. The handler owns the stream and must close it.
+type StreamHandler func(s *Stream)
+
+type streamKey struct {
+	peer network.ServerIdentityID
+	id   uuid.UUID
+}
+
+// streamManager keeps the open streams of a server and the services that
+// accept streams.
+type streamManager struct {
+	server    *Server
+	acceptors map[string]StreamHandler
+	streams   map[streamKey]*Stream
+	opening   map[uuid.UUID]chan *StreamOpenReply
+	timeout   time.Duration
+	sync.Mutex
+
+	wg     sync.WaitGroup
+	closed bool
+}
+
+func newStreamManager(s *Server) *streamManager {
+	m := &streamManager{
+		server:    s,
+		acceptors: make(map[string]StreamHandler),
+		streams:   make(map[streamKey]*Stream),
+		opening:   make(map[uuid.UUID]chan *StreamOpenReply),
+		timeout:   defaultStreamTimeout,
+	}
+	s.RegisterProcessor(m, StreamOpenMsgID, StreamOpenReplyMsgID,
+		StreamChunkMsgID, StreamAckMsgID)
+	return m
+}
+
+// Stream is an ordered and flow-controlled byte stream between a service
+// and the same service on another server. Writes are split in chunks, and a
+// writer blocks when the reader is StreamWindow chunks behind. Each side
+// closes its writing direction with Close, after which the other side reads
+// io.EOF.
+type Stream struct {
+	mgr  *streamManager
+	peer *network.ServerIdentity
+	id   uuid.UUID
+
+	sync.Mutex
+	// closed and replaced on every change, to wake up the waiting calls
+	changed chan struct{}
+	err     error
+
+	// reading side
+	chunks   map[uint64]*StreamChunk
+	nextRead uint64
+	current  []byte
+	eof      bool
+
+	// writing side
+	nextWrite   uint64
+	consumed    uint64
+	writeClosed bool
+}
+
+func (m *streamManager) newStream(peer *network.ServerIdentity, id uuid.UUID) *Stream {
+	return &Stream{
+		mgr:     m,
+		peer:    peer,
+		id:      id,
+		changed: make(chan struct{}),
+		chunks:  make(map[uint64]*StreamChunk),
+	}
+}
+
+// openStream asks the service of the peer to accept a stream.
+func (m *streamManager) openStream(peer *network.ServerIdentity, service string) (*Stream, error) {
+	if peer.ID.Equal(m.server.ServerIdentity.ID) {
+		return nil, xerrors.New("cannot open a stream to ourselves")
+	}
+	id := uuid.NewV4()
+	reply := make(chan *StreamOpenReply, 1)
+	s := m.newStream(peer, id)
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return nil, xerrors.New("server is closing")
+	}
+	m.opening[id] = reply
+	m.streams[streamKey{peer.ID, id}] = s
+	m.Unlock()
+
+	err := func() error {
+		if _, err := m.server.Send(peer, &StreamOpen{ID: id, Service: service}); err != nil {
+			return xerrors.Errorf("sending: %v", err)
+		}
+		select {
+		case r := <-reply:
+			if r.Error != "" {
+				return xerrors.Errorf("refused: %s", r.Error)
+			}
+			return nil
+		case <-m.server.Clock().After(m.timeout):
+			return xerrors.New("timeout")
+		}
+	}()
+	m.Lock()
+	delete(m.opening, id)
+	if err != nil {
+		delete(m.streams, streamKey{peer.ID, id})
+	}
+	m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Process implements the network.Processor interface.
+func (m *streamManager) Process(env *network.Envelope) {
+	switch msg := env.Msg.(type) {
+	case *StreamOpen:
+		m.handleOpen(env.ServerIdentity, msg)
+	case *StreamOpenReply:
+		m.Lock()
+		c, ok := m.opening[msg.ID]
+		m.Unlock()
+		if ok {
+			c <- msg
+		}
+	case *StreamChunk:
+		if s := m.stream(env.ServerIdentity, msg.ID); s != nil {
+			s.receive(msg)
+		}
+	case *StreamAck:
+		if s := m.stream(env.ServerIdentity, msg.ID); s != nil {
+			s.ack(msg)
+		}
+	default:
+		log.Error("stream: unknown message type", env.MsgType)
+	}
+}
+
+func (m *streamManager) stream(peer *network.ServerIdentity, id uuid.UUID) *Stream {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.streams[streamKey{peer.ID, id}]
+	if !ok {
+		log.Lvl3("stream: message for unknown stream", id, "of", peer)
+	}
+	return s
+}
+
+func (m *streamManager) handleOpen(peer *network.ServerIdentity, req *StreamOpen) {
+	reply := &StreamOpenReply{ID: req.ID}
+	m.Lock()
+	h, ok := m.acceptors[req.Service]
+	switch {
+	case m.closed:
+		reply.Error = "server is closing"
+	case !ok:
+		reply.Error = "service doesn't accept streams: " + req.Service
+	}
+	var s *Stream
+	if reply.Error == "" {
+		s = m.newStream(peer, req.ID)
+		m.streams[streamKey{peer.ID, req.ID}] = s
+		m.wg.Add(1)
+	}
+	m.Unlock()
+
+	if _, err := m.server.Send(peer, reply); err != nil {
+		log.Lvl2("stream: couldn't answer", peer, ":", err)
+	}
+	if s != nil {
+		go func() {
+			defer m.wg.Done()
+			h(s)
+		}()
+	}
+}
+
+// remove forgets the stream once both directions are done.
+func (m *streamManager) remove(s *Stream) {
+	m.Lock()
+	delete(m.streams, streamKey{s.peer.ID, s.id})
+	m.Unlock()
+}
+
+// close fails all open streams and waits for the handlers to return.
+func (m *streamManager) close() {
+	m.Lock()
+	m.closed = true
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, s := range m.streams {
+		streams = append(streams, s)
+	}
+	m.Unlock()
+	for _, s := range streams {
+		s.fail(xerrors.New("server is closing"))
+	}
+	m.wg.Wait()
+}
+
+// Peer returns the server at the other end of the stream.
+func (s *Stream) Peer() *network.ServerIdentity {
+	return s.peer
+}
+
+// Read implements io.Reader. It blocks until data is available, the peer
+// closed its side, or the stream failed.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.current) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if c, ok := s.chunks[s.nextRead]; ok {
+			delete(s.chunks, s.nextRead)
+			s.nextRead++
+			if c.EOF {
+				s.eof = true
+				s.doneLocked()
+			} else {
+				s.current = c.Data
+				ack := &StreamAck{ID: s.id, Consumed: s.nextRead}
+				s.Unlock()
+				err := s.send(ack)
+				s.Lock()
+				if err != nil {
+					s.failLocked(err)
+				}
+			}
+			continue
+		}
+		if s.eof {
+			return 0, io.EOF
+		}
+		changed := s.changed
+		s.Unlock()
+		<-changed
+		s.Lock()
+	}
+	n := copy(p, s.current)
+	s.current = s.current[n:]
+	return n, nil
+}
+
+// Write implements io.Writer. It blocks while the window is full.
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + StreamChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := s.writeChunk(p[written:end], false); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// Close closes the writing direction of the stream: the peer will read
+// io.EOF once it got all the data. Reading is still possible until the peer
+// closes its side.
+func (s *Stream) Close() error {
+	s.Lock()
+	closed := s.writeClosed
+	s.Unlock()
+	if closed {
+		return nil
+	}
+	if err := s.writeChunk(nil, true); err != nil {
+		return xerrors.Errorf("closing: %v", err)
+	}
+	return nil
+}
+
+func (s *Stream) writeChunk(data []byte, eof bool) error {
+	s.Lock()
+	defer s.Unlock()
+	timeout := s.mgr.server.Clock().After(s.mgr.timeout)
+	for {
+		if s.err != nil {
+			return s.err
+		}
+		if s.writeClosed {
+			return xerrors.New("stream is closed for writing")
+		}
+		// the EOF doesn't need room, as it carries no data
+		if eof || s.nextWrite < s.consumed+StreamWindow {
+			break
+		}
+		changed := s.changed
+		s.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			s.Lock()
+			return xerrors.New("timeout while waiting for the reader")
+		}
+		s.Lock()
+	}
+	// the receiver keeps the slice, so it must not be reused by the caller
+	buf := append([]byte{}, data...)
+	c := &StreamChunk{ID: s.id, Seq: s.nextWrite, Data: buf, EOF: eof}
+	s.nextWrite++
+	if eof {
+		s.writeClosed = true
+		s.doneLocked()
+	}
+	// don't hold the lock while sending, so that the messages of the peer
+	// can still be processed
+	s.Unlock()
+	err := s.send(c)
+	s.Lock()
+	if err != nil {
+		s.failLocked(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) send(msg interface{}) error {
+	if _, err := s.mgr.server.Send(s.peer, msg); err != nil {
+		return xerrors.Errorf("sending: %v", err)
+	}
+	return nil
+}
+
+func (s *Stream) receive(c *StreamChunk) {
+	s.Lock()
+	defer s.Unlock()
+	if c.Seq < s.nextRead || c.Seq >= s.nextRead+StreamWindow+1 {
+		log.Lvl2("stream: chunk out of window", c.Seq)
+		return
+	}
+	s.chunks[c.Seq] = c
+	s.signalLocked()
+}
+
+func (s *Stream) ack(a *StreamAck) {
+	s.Lock()
+	defer s.Unlock()
+	if a.Consumed > s.consumed {
+		s.consumed = a.Consumed
+		s.signalLocked()
+	}
+}
+
+func (s *Stream) fail(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.failLocked(err)
+}
+
+func (s *Stream) failLocked(err error) {
+	if s.err == nil {
+		s.err = err
+		s.signalLocked()
+		s.mgr.remove(s)
+	}
+}
+
+// doneLocked removes the stream from the manager once both sides are closed.
+func (s *Stream) doneLocked() {
+	if s.eof && s.writeClosed {
+		s.mgr.remove(s)
+	}
+}
+
+func (s *Stream) signalLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
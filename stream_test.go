@@ -0,0 +1,96 @@
+package onet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const streamServiceName = "streamService"
+
+type streamService struct {
+	*ServiceProcessor
+}
+
+// The handler reads everything and answers with its hash.
+func newStreamService(c *Context) (Service, error) {
+	s := &streamService{NewServiceProcessor(c)}
+	c.AcceptStreams(func(st *Stream) {
+		defer st.Close()
+		buf, err := ioutil.ReadAll(st)
+		if err != nil {
+			return
+		}
+		h := sha256.Sum256(buf)
+		st.Write(h[:])
+	})
+	return s, nil
+}
+
+func TestStream(t *testing.T) {
+	sid, err := RegisterNewService(streamServiceName, newStreamService)
+	require.NoError(t, err)
+	defer UnregisterService(streamServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	s0 := local.GetServices(servers, sid)[0].(*streamService)
+
+	_, err = s0.OpenStream(servers[0].ServerIdentity)
+	require.Error(t, err)
+
+	st, err := s0.OpenStream(servers[1].ServerIdentity)
+	require.NoError(t, err)
+	require.True(t, st.Peer().Equal(servers[1].ServerIdentity))
+
+	// More than a window, so the writer must wait for the reader.
+	data := bytes.Repeat([]byte("0123456789abcdef"), StreamWindow*StreamChunkSize/8)
+	n, err := st.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.NoError(t, st.Close())
+	_, err = st.Write([]byte{1})
+	require.Error(t, err)
+
+	h, err := ioutil.ReadAll(st)
+	require.NoError(t, err)
+	exp := sha256.Sum256(data)
+	require.Equal(t, exp[:], h)
+	n, err = st.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 0, n)
+}
+
+func TestStream_Refused(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(2, false)
+
+	_, err := servers[0].streams.openStream(servers[1].ServerIdentity, "unknown")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't accept streams")
+}
+
+func TestStream_ServerClose(t *testing.T) {
+	sid, err := RegisterNewService(streamServiceName, newStreamService)
+	require.NoError(t, err)
+	defer UnregisterService(streamServiceName)
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers := local.GenServers(2)
+	s0 := local.GetServices(servers, sid)[0].(*streamService)
+
+	st, err := s0.OpenStream(servers[1].ServerIdentity)
+	require.NoError(t, err)
+	errs := make(chan error)
+	go func() {
+		_, err := st.Read(make([]byte, 1))
+		errs <- err
+	}()
+	servers[0].streams.close()
+	require.Error(t, <-errs)
+}
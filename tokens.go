@@ -0,0 +1,83 @@
+package onet
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// tokenGenerations holds values for the protocol instances, keyed by the
+// first 8 bytes of their TokenID, for at least retention and at most twice
+// as long. The values are kept in two generations that are swapped every
+// retention, so that the ones of the instances that finished long ago are
+// forgotten without walking them, which matters for the runs with millions
+// of short instances. It must be used with the lock of its owner.
+type tokenGenerations struct {
+	retention time.Duration
+	now       func() time.Time
+	rotated   time.Time
+	current   map[uint64]interface{}
+	previous  map[uint64]interface{}
+}
+
+func newTokenGenerations(retention time.Duration, now func() time.Time) *tokenGenerations {
+	return &tokenGenerations{
+		retention: retention,
+		now:       now,
+		rotated:   now(),
+		current:   make(map[uint64]interface{}),
+		previous:  make(map[uint64]interface{}),
+	}
+}
+
+// tokenKey returns the compact key of the token. As the tokens are hashes,
+// about 2^32 instances are needed before two of them share a key.
+func tokenKey(id TokenID) uint64 {
+	return binary.BigEndian.Uint64(id[:8])
+}
+
+// rotate drops the oldest generation when it is older than the retention.
+func (tg *tokenGenerations) rotate() {
+	now := tg.now()
+	switch age := now.Sub(tg.rotated); {
+	case age >= 2*tg.retention:
+		tg.previous = make(map[uint64]interface{})
+		tg.current = make(map[uint64]interface{})
+	case age >= tg.retention:
+		tg.previous = tg.current
+		tg.current = make(map[uint64]interface{})
+	default:
+		return
+	}
+	tg.rotated = now
+}
+
+func (tg *tokenGenerations) put(id TokenID, v interface{}) {
+	tg.rotate()
+	k := tokenKey(id)
+	delete(tg.previous, k)
+	tg.current[k] = v
+}
+
+func (tg *tokenGenerations) get(id TokenID) (interface{}, bool) {
+	tg.rotate()
+	k := tokenKey(id)
+	if v, ok := tg.current[k]; ok {
+		return v, true
+	}
+	v, ok := tg.previous[k]
+	return v, ok
+}
+
+// take returns the value and removes it.
+func (tg *tokenGenerations) take(id TokenID) (interface{}, bool) {
+	v, ok := tg.get(id)
+	k := tokenKey(id)
+	delete(tg.current, k)
+	delete(tg.previous, k)
+	return v, ok
+}
+
+func (tg *tokenGenerations) len() int {
+	tg.rotate()
+	return len(tg.current) + len(tg.previous)
+}
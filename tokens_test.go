@@ -0,0 +1,77 @@
+package onet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+// Tests that the values are kept for at least the retention, and forgotten
+// after twice the retention.
+func TestTokenGenerations_Rotation(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	tg := newTokenGenerations(time.Minute, clock.Now)
+
+	tok1 := TokenID{1}
+	tok2 := TokenID{2}
+	tg.put(tok1, 1)
+	clock.Advance(50 * time.Second)
+	tg.put(tok2, 2)
+	require.Equal(t, 2, tg.len())
+
+	clock.Advance(20 * time.Second)
+	v, ok := tg.get(tok1)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	clock.Advance(time.Minute)
+	_, ok = tg.get(tok1)
+	require.False(t, ok)
+	_, ok = tg.get(tok2)
+	require.False(t, ok)
+	require.Equal(t, 0, tg.len())
+
+	tg.put(tok1, 3)
+	clock.Advance(3 * time.Minute)
+	require.Equal(t, 0, tg.len())
+}
+
+func TestTokenGenerations_Take(t *testing.T) {
+	clock := NewVirtualClock(time.Unix(0, 0))
+	tg := newTokenGenerations(time.Minute, clock.Now)
+
+	tok := TokenID{1}
+	tg.put(tok, 1)
+	clock.Advance(time.Minute)
+	v, ok := tg.take(tok)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	_, ok = tg.take(tok)
+	require.False(t, ok)
+	require.Equal(t, 0, tg.len())
+}
+
+// Tests that a config for another token with the same key is not given to
+// the instance.
+func TestOverlay_PendingConfigKey(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	srv := local.GenServers(1)[0]
+
+	dest := TokenID{1, 2, 3}
+	other := dest
+	other[15] = 4
+	srv.overlay.handleConfigMessage(&network.Envelope{
+		Msg: &ConfigMsg{Config: GenericConfig{Data: []byte("data")}, Dest: dest},
+	})
+	require.Equal(t, 1, srv.overlay.queueStatus().PendingConfigs)
+	require.Nil(t, srv.overlay.getConfig(other))
+	require.Equal(t, 0, srv.overlay.queueStatus().PendingConfigs)
+
+	srv.overlay.handleConfigMessage(&network.Envelope{
+		Msg: &ConfigMsg{Config: GenericConfig{Data: []byte("data")}, Dest: dest},
+	})
+	require.Equal(t, []byte("data"), srv.overlay.getConfig(dest).Data)
+}
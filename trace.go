@@ -0,0 +1,207 @@
+package onet
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"go.dedis.ch/onet/v4/network"
+	"golang.org/x/xerrors"
+)
+
+// TraceEvent is a message received by a protocol instance, as recorded by a
+// TraceRecorder.
+type TraceEvent struct {
+	// Time is when the message was received, in nanoseconds since 1970
+	Time int64
+	// Protocol is the name of the protocol
+	Protocol string
+	// From and To are the tokens of the sending and receiving instances
+	From *Token
+	To   *Token
+	// FromIndex and ToIndex are the roster indexes of their tree nodes
+	FromIndex int
+	ToIndex   int
+	// MsgType is the type of the message, and Msg the marshalled message
+	MsgType network.MessageTypeID
+	Msg     []byte
+}
+
+// TraceEventID is the type of a TraceEvent.
+var TraceEventID = network.RegisterMessage(TraceEvent{})
+
+// Trace is a list of recorded messages, in the order they were received.
+type Trace []*TraceEvent
+
+// Filter returns the events of the trace for which keep returns true.
+func (t Trace) Filter(keep func(e *TraceEvent) bool) Trace {
+	var ret Trace
+	for _, e := range t {
+		if keep(e) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// TraceRecorder writes the messages received by the protocol instances of a
+// server, set with Server.SetTraceRecorder.
+type TraceRecorder struct {
+	w   io.Writer
+	err error
+	sync.Mutex
+}
+
+// NewTraceRecorder returns a recorder writing the messages to w, which can
+// be read back with ReadTrace.
+func NewTraceRecorder(w io.Writer) *TraceRecorder {
+	return &TraceRecorder{w: w}
+}
+
+// Err returns the first error while writing the trace.
+func (r *TraceRecorder) Err() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.err
+}
+
+// record writes a message for the protocol in the tree.
+func (r *TraceRecorder) record(protocol string, tree *Tree, msg *ProtocolMsg) {
+	e := &TraceEvent{
+		Time:      time.Now().UnixNano(),
+		Protocol:  protocol,
+		From:      msg.From,
+		To:        msg.To,
+		FromIndex: -1,
+		ToIndex:   -1,
+		MsgType:   msg.MsgType,
+	}
+	if tn := tree.Search(msg.From.TreeNodeID); tn != nil {
+		e.FromIndex = tn.RosterIndex
+	}
+	if tn := tree.Search(msg.To.TreeNodeID); tn != nil {
+		e.ToIndex = tn.RosterIndex
+	}
+	var err error
+	e.Msg, err = network.Marshal(msg.Msg)
+	if err != nil {
+		log.Lvl2("Couldn't record message", msg.MsgType, ":", err)
+		return
+	}
+	buf, err := network.Marshal(e)
+	if err != nil {
+		log.Lvl2("Couldn't record message", msg.MsgType, ":", err)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := binary.Write(r.w, binary.BigEndian, uint32(len(buf))); err != nil {
+		r.err = xerrors.Errorf("writing trace: %v", err)
+		return
+	}
+	if _, err := r.w.Write(buf); err != nil {
+		r.err = xerrors.Errorf("writing trace: %v", err)
+	}
+}
+
+// ReadTrace reads all the events written by a TraceRecorder.
+func ReadTrace(rd io.Reader) (Trace, error) {
+	var t Trace
+	for {
+		var l uint32
+		err := binary.Read(rd, binary.BigEndian, &l)
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("reading trace: %v", err)
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, xerrors.Errorf("reading trace: %v", err)
+		}
+		_, msg, err := network.Unmarshal(buf, nil)
+		if err != nil {
+			return nil, xerrors.Errorf("unmarshaling event: %v", err)
+		}
+		e, ok := msg.(*TraceEvent)
+		if !ok {
+			return nil, xerrors.New("not a trace event")
+		}
+		t = append(t, e)
+	}
+}
+
+// ReplayTrace gives the events of the trace received by the tree node of the
+// protocol instance to it, in the same order, as if they were received again.
+// The tree nodes are matched by their index in the roster, so that a trace
+// can be replayed on another tree of the same shape, like the one of a
+// LocalTest, and Trace.Filter chooses the recorded instance to replay. The
+// protocol instance must embed a TreeNodeInstance, and its messages are still
+// sent to the servers of its tree.
+func ReplayTrace(pi ProtocolInstance, t Trace) error {
+	n, ok := pi.(interface {
+		Tree() *Tree
+		TreeNode() *TreeNode
+		Suite() network.Suite
+	})
+	if !ok {
+		return xerrors.New("the protocol instance has no TreeNodeInstance")
+	}
+	tree, own := n.Tree(), n.TreeNode().RosterIndex
+	for _, e := range t {
+		if e.ToIndex != own {
+			continue
+		}
+		var from *TreeNode
+		for _, tn := range tree.List() {
+			if tn.RosterIndex == e.FromIndex {
+				from = tn
+				break
+			}
+		}
+		if from == nil {
+			return xerrors.Errorf("no tree node with index %d", e.FromIndex)
+		}
+		typ, msg, err := network.Unmarshal(e.Msg, n.Suite())
+		if err != nil {
+			return xerrors.Errorf("unmarshaling message: %v", err)
+		}
+		pi.ProcessProtocolMsg(&ProtocolMsg{
+			From:           pi.Token().ChangeTreeNodeID(from.ID),
+			To:             pi.Token(),
+			ServerIdentity: from.ServerIdentity,
+			MsgType:        typ,
+			Msg:            msg,
+			Size:           network.Size(len(e.Msg)),
+		})
+	}
+	return nil
+}
+
+// serverTrace holds the trace recorder of a server.
+type serverTrace struct {
+	recorder *TraceRecorder
+	sync.Mutex
+}
+
+// SetTraceRecorder records the messages received by the protocol instances of
+// the server with r, until it is called with nil.
+func (c *Server) SetTraceRecorder(r *TraceRecorder) {
+	c.trace.Lock()
+	defer c.trace.Unlock()
+	c.trace.recorder = r
+}
+
+// traceRecorder returns the trace recorder of the server, or nil.
+func (c *Server) traceRecorder() *TraceRecorder {
+	c.trace.Lock()
+	defer c.trace.Unlock()
+	return c.trace.recorder
+}
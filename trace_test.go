@@ -0,0 +1,69 @@
+package onet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/network"
+)
+
+func TestTraceRecorder(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(3, true)
+
+	var buf bytes.Buffer
+	r := NewTraceRecorder(&buf)
+	servers[1].SetTraceRecorder(r)
+	servers[2].SetTraceRecorder(r)
+	require.Equal(t, map[int]int{1: 1, 2: 1}, byzantineTestRun(t, local, tree, 2))
+	servers[1].SetTraceRecorder(nil)
+	servers[2].SetTraceRecorder(nil)
+	require.NoError(t, r.Err())
+
+	trace, err := ReadTrace(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(trace))
+	for _, e := range trace {
+		require.Equal(t, byzantineTestName, e.Protocol)
+		require.Equal(t, 0, e.FromIndex)
+		require.Equal(t, tree.Root.ID, e.From.TreeNodeID)
+		require.Equal(t, tree.ID, e.To.TreeID)
+		require.NotZero(t, e.Time)
+		_, msg, err := network.Unmarshal(e.Msg, tSuite)
+		require.NoError(t, err)
+		require.Equal(t, &ByzantineTestMsg{1}, msg)
+	}
+	require.Equal(t, 1, len(trace.Filter(func(e *TraceEvent) bool {
+		return e.ToIndex == 2
+	})))
+}
+
+func TestReplayTrace(t *testing.T) {
+	local := NewLocalTest(tSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(3, true)
+	servers[1].overlay.RegisterTree(tree)
+
+	msg, err := network.Marshal(&ByzantineTestMsg{3})
+	require.NoError(t, err)
+	trace := Trace{
+		{Protocol: byzantineTestName, FromIndex: 0, ToIndex: 2, Msg: msg},
+		{Protocol: byzantineTestName, FromIndex: 0, ToIndex: 1, Msg: msg},
+	}
+
+	tni, err := local.NewTreeNodeInstance(tree.List()[1], byzantineTestName)
+	require.NoError(t, err)
+	pi, err := newByzantineTest(tni)
+	require.NoError(t, err)
+	require.Error(t, ReplayTrace(pi, Trace{{FromIndex: 5, ToIndex: 1, Msg: msg}}))
+	require.NoError(t, ReplayTrace(pi, trace))
+	select {
+	case r := <-byzantineTestCh:
+		require.Equal(t, byzantineTestReceived{1, 3}, r)
+	case <-time.After(time.Second):
+		require.Fail(t, "message not replayed")
+	}
+}
@@ -704,13 +704,17 @@ func (ro *Roster) RandomServerIdentity() *network.ServerIdentity {
 // RandomSubset returns a new Roster which starts with root and is
 // followed by a random subset of n elements of ro, not including root.
 func (ro *Roster) RandomSubset(root *network.ServerIdentity, n int) *Roster {
+	return ro.randomSubset(root, n, securePermute(len(ro.List)))
+}
+
+// randomSubset is RandomSubset taking the elements in the order of perm.
+func (ro *Roster) randomSubset(root *network.ServerIdentity, n int, perm []int) *Roster {
 	if n > len(ro.List) {
 		n = len(ro.List)
 	}
 	out := make([]*network.ServerIdentity, 1, n+1)
 	out[0] = root
 
-	perm := securePermute(len(ro.List))
 	for _, p := range perm {
 		if !ro.List[p].ID.Equal(root.ID) {
 			out = append(out, ro.List[p])
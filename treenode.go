@@ -6,8 +6,10 @@ import (
 	"go.dedis.ch/onet/v4/log"
 	"go.dedis.ch/onet/v4/network"
 	"golang.org/x/xerrors"
+	"math/rand"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // TreeNodeInstance represents a protocol-instance in a given TreeNode. It embeds an
@@ -39,10 +41,15 @@ type TreeNodeInstance struct {
 	msgDispatchQueue []*ProtocolMsg
 	// locking for msgqueue
 	msgDispatchQueueMutex sync.Mutex
-	// kicking off new message
-	msgDispatchQueueWait chan bool
+	// whether the messages are being given to the protocol
+	dispatching bool
 	// whether this node is closing
 	closing bool
+	// closed when the Dispatch of the protocol returns, nil if the overlay
+	// doesn't run it
+	dispatchDone chan struct{}
+	// when the instance was created
+	created time.Time
 
 	protoIO MessageProxy
 
@@ -55,6 +62,11 @@ type TreeNodeInstance struct {
 	// used for the CounterIO interface
 	tx safeAdder
 	rx safeAdder
+
+	// vector clock of the messages, see SetCausalMode
+	causal causalState
+	// the time mode and the Lamport clock of the protocol
+	logical logicalState
 }
 
 type safeAdder struct {
@@ -98,11 +110,10 @@ func newTreeNodeInstance(o *Overlay, tok *Token, tn *TreeNode, io MessageProxy)
 		msgQueue:             make(map[network.MessageTypeID][]*ProtocolMsg),
 		treeNode:             tn,
 		msgDispatchQueue:     make([]*ProtocolMsg, 0, 1),
-		msgDispatchQueueWait: make(chan bool, 1),
 		protoIO:              io,
 		sentTo:               make(map[TreeNodeID]bool),
+		created:              time.Now(),
 	}
-	go n.dispatchMsgReader()
 	return n
 }
 
@@ -117,6 +128,17 @@ func (n *TreeNodeInstance) ServerIdentity() *network.ServerIdentity {
 	return n.treeNode.ServerIdentity
 }
 
+// Clock returns the clock of the server, to use instead of the time package
+// so that the protocol can run in virtual time.
+func (n *TreeNodeInstance) Clock() Clock {
+	return n.overlay.server.Clock()
+}
+
+// Rand returns the seeded source of randomness of the server.
+func (n *TreeNodeInstance) Rand() *rand.Rand {
+	return n.overlay.server.Rand()
+}
+
 // Parent returns the parent-TreeNode of ourselves
 func (n *TreeNodeInstance) Parent() *TreeNode {
 	return n.treeNode.Parent
@@ -148,6 +170,11 @@ func (n *TreeNodeInstance) IsLeaf() bool {
 
 // SendTo sends to a given node
 func (n *TreeNodeInstance) SendTo(to *TreeNode, msg interface{}) error {
+	return n.sendTo(to, msg, n.stamp(false), false)
+}
+
+// sendTo sends to a given node with the causal clock of the message.
+func (n *TreeNodeInstance) sendTo(to *TreeNode, msg interface{}, clock VectorClock, tick bool) error {
 	if to == nil {
 		return xerrors.New("Sent to a nil TreeNode")
 	}
@@ -157,6 +184,11 @@ func (n *TreeNodeInstance) SendTo(to *TreeNode, msg interface{}) error {
 		return xerrors.New("is closing")
 	}
 	n.msgDispatchQueueMutex.Unlock()
+	if a := n.overlay.server.adversary(n.token.ProtoID); a != nil && a.Mutate != nil {
+		if msg = a.Mutate(n, to, msg); msg == nil {
+			return nil
+		}
+	}
 	var c *GenericConfig
 	// only sends the config once
 	n.configMut.Lock()
@@ -166,7 +198,7 @@ func (n *TreeNodeInstance) SendTo(to *TreeNode, msg interface{}) error {
 	}
 	n.configMut.Unlock()
 
-	sentLen, err := n.overlay.SendToTreeNode(n.token, to, msg, n.protoIO, c)
+	sentLen, err := n.overlay.sendToTreeNode(n.token, to, msg, n.protoIO, c, clock, tick, n.logicalSend())
 	n.tx.add(sentLen)
 	if err != nil {
 		return xerrors.Errorf("sending: %v", err)
@@ -243,8 +275,8 @@ func (n *TreeNodeInstance) RegisterChannelLength(c interface{}, length int) erro
 	if cr.Elem().Kind() != reflect.Struct {
 		return xerrors.New("Input is not channel of structure")
 	}
-	if cr.Elem().NumField() != 2 {
-		return xerrors.New("Input is not channel of structure with 2 elements")
+	if !handlerFields(cr.Elem()) {
+		return xerrors.New("Input is not channel of structure with 2 elements, or 3 with a VectorClock")
 	}
 	if cr.Elem().Field(0).Type != reflect.TypeOf(&TreeNode{}) {
 		return xerrors.New("Input-channel doesn't have TreeNode as element")
@@ -311,8 +343,8 @@ func (n *TreeNodeInstance) RegisterHandler(c interface{}) error {
 	if ci.Kind() != reflect.Struct {
 		return xerrors.New("Input is not a structure")
 	}
-	if ci.NumField() != 2 {
-		return xerrors.New("Input is not a structure with 2 elements")
+	if !handlerFields(ci) {
+		return xerrors.New("Input is not a structure with 2 elements, or 3 with a VectorClock")
 	}
 	if ci.Field(0).Type != reflect.TypeOf(&TreeNode{}) {
 		return xerrors.New("Input-handler doesn't have TreeNode as element")
@@ -363,7 +395,6 @@ func (n *TreeNodeInstance) closeDispatch() error {
 	log.Lvl3("Closing node", n.Info())
 	n.msgDispatchQueueMutex.Lock()
 	n.closing = true
-	close(n.msgDispatchQueueWait)
 	n.msgDispatchQueueMutex.Unlock()
 	log.Lvl3("Closed node", n.Info())
 	pni := n.ProtocolInstance()
@@ -377,6 +408,27 @@ func (n *TreeNodeInstance) closeDispatch() error {
 	return nil
 }
 
+// dispatch returns the function running the Dispatch of the protocol of the
+// node, which tells when it returns.
+func (n *TreeNodeInstance) dispatch(pi ProtocolInstance) func() error {
+	done := make(chan struct{})
+	n.msgDispatchQueueMutex.Lock()
+	n.dispatchDone = done
+	n.msgDispatchQueueMutex.Unlock()
+	return func() error {
+		defer close(done)
+		return pi.Dispatch()
+	}
+}
+
+// dispatched returns the channel closed when the Dispatch of the protocol
+// returns, or nil if the overlay doesn't run it.
+func (n *TreeNodeInstance) dispatched() <-chan struct{} {
+	n.msgDispatchQueueMutex.Lock()
+	defer n.msgDispatchQueueMutex.Unlock()
+	return n.dispatchDone
+}
+
 // ProtocolName will return the string representing that protocol
 func (n *TreeNodeInstance) ProtocolName() string {
 	return n.overlay.server.protocols.ProtocolIDToName(n.token.ProtoID)
@@ -429,6 +481,9 @@ func (n *TreeNodeInstance) createValueAndVerify(t reflect.Type, msg *ProtocolMsg
 		if tn != nil {
 			m.Field(0).Set(reflect.ValueOf(tn))
 			m.Field(1).Set(reflect.Indirect(reflect.ValueOf(msg.Msg)))
+			if m.NumField() > 2 {
+				m.Field(2).Set(reflect.ValueOf(msg.Clock.Copy()))
+			}
 		}
 		// Check whether the sender treenode actually is the same as the node who sent it.
 		// We can trust msg.ServerIdentity, because it is written in Router.handleConn and
@@ -502,48 +557,36 @@ func (n *TreeNodeInstance) ProcessProtocolMsg(msg *ProtocolMsg) {
 		return
 	}
 	n.msgDispatchQueue = append(n.msgDispatchQueue, msg)
-	n.notifyDispatch()
-}
-
-func (n *TreeNodeInstance) notifyDispatch() {
-	select {
-	case n.msgDispatchQueueWait <- true:
-		return
-	default:
-		// Channel write would block: already been notified.
-		// So, nothing to do here.
+	if !n.dispatching {
+		n.dispatching = true
+		n.overlay.budget.run(n.dispatchMsg)
 	}
 }
 
-func (n *TreeNodeInstance) dispatchMsgReader() {
-	log.Lvl3("Starting node", n.Info())
-	for {
-		n.msgDispatchQueueMutex.Lock()
-		if n.closing {
-			log.Lvl3("Closing reader")
-			n.msgDispatchQueueMutex.Unlock()
-			return
-		}
-		if len(n.msgDispatchQueue) > 0 {
-			log.Lvl4(n.Info(), "Read message and dispatching it",
-				len(n.msgDispatchQueue))
-			msg := n.msgDispatchQueue[0]
-			n.msgDispatchQueue = n.msgDispatchQueue[1:]
-			n.msgDispatchQueueMutex.Unlock()
-			err := n.dispatchMsgToProtocol(msg)
-			if err != nil {
-				log.Errorf("%s: error while dispatching message %s: %s",
-					n.Name(), reflect.TypeOf(msg.Msg), err)
-			}
-		} else {
-			n.msgDispatchQueueMutex.Unlock()
-			log.Lvl4(n.Info(), "Waiting for message")
-			// Allow for closing of the channel
-			select {
-			case <-n.msgDispatchQueueWait:
-			}
+// dispatchMsg gives the first message of the queue to the protocol, and
+// returns whether there are more, with the goroutine budget of the overlay
+// so that the instances without messages have no goroutine.
+func (n *TreeNodeInstance) dispatchMsg() bool {
+	n.msgDispatchQueueMutex.Lock()
+	if n.closing || len(n.msgDispatchQueue) == 0 {
+		n.dispatching = false
+		n.msgDispatchQueueMutex.Unlock()
+		return false
+	}
+	log.Lvl4(n.Info(), "Read message and dispatching it",
+		len(n.msgDispatchQueue))
+	msg := n.msgDispatchQueue[0]
+	n.msgDispatchQueue = n.msgDispatchQueue[1:]
+	n.msgDispatchQueueMutex.Unlock()
+	for _, msg := range n.deliverable(msg) {
+		n.logicalReceive(msg.LogicalTime)
+		err := n.dispatchMsgToProtocol(msg)
+		if err != nil {
+			log.Errorf("%s: error while dispatching message %s: %s",
+				n.Name(), reflect.TypeOf(msg.Msg), err)
 		}
 	}
+	return true
 }
 
 // dispatchMsgToProtocol will dispatch this onet.Data to the right instance
@@ -757,9 +800,10 @@ func (n *TreeNodeInstance) Index() int {
 // Broadcast sends a given message from the calling node directly to all other TreeNodes
 func (n *TreeNodeInstance) Broadcast(msg interface{}) []error {
 	var errs []error
+	clock := n.stamp(true)
 	for _, node := range n.List() {
 		if !node.Equal(n.TreeNode()) {
-			if err := n.SendTo(node, msg); err != nil {
+			if err := n.sendTo(node, msg, clock, true); err != nil {
 				errs = append(errs, xerrors.Errorf("sending: %v", err))
 			}
 		}
@@ -5,20 +5,65 @@ import (
 	"time"
 )
 
+// defaultMaxTrees is how many trees the overlay keeps by default.
+const defaultMaxTrees = 10000
+
+// TreeCacheConfig are the limits of the cache of the trees of the overlay.
+type TreeCacheConfig struct {
+	// TTL is how long a tree is kept after the last protocol using it is
+	// done, so that the other servers can still ask for it.
+	TTL time.Duration
+	// MaxTrees is how many trees are kept, the least recently used ones
+	// being removed first. The trees of the running protocols are always
+	// kept, and a negative value means no limit.
+	MaxTrees int
+}
+
+// TreeCacheStats are the metrics of the cache of the trees of the overlay.
+type TreeCacheStats struct {
+	// Trees is the number of trees known, and Pinned the ones used by
+	// running protocols.
+	Trees  int
+	Pinned int
+	// Hits and Misses count the lookups of the trees, Evictions the trees
+	// removed because of MaxTrees, and Expirations the ones removed
+	// because of the TTL.
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// SetTreeCache changes the limits of the cache of the trees of the overlay.
+// The zero values keep the current ones.
+func (c *Server) SetTreeCache(cfg TreeCacheConfig) {
+	c.overlay.SetTreeCache(cfg)
+}
+
 type treeStorage struct {
 	sync.Mutex
 	timeout       time.Duration
+	maxTrees      int
 	wg            sync.WaitGroup
 	trees         map[TreeID]*Tree
 	cancellations map[TreeID]chan struct{}
-	closed        bool
+	// pins counts the running protocols using the trees
+	pins map[TreeID]int
+	// lastUse orders the trees by their last use, for the evictions
+	lastUse map[TreeID]uint64
+	uses    uint64
+	stats   TreeCacheStats
+	closed  bool
 }
 
 func newTreeStorage(t time.Duration) *treeStorage {
 	return &treeStorage{
 		timeout:       t,
+		maxTrees:      defaultMaxTrees,
 		trees:         make(map[TreeID]*Tree),
 		cancellations: make(map[TreeID]chan struct{}),
+		pins:          make(map[TreeID]int),
+		lastUse:       make(map[TreeID]uint64),
 		closed:        false,
 	}
 }
@@ -26,8 +71,10 @@ func newTreeStorage(t time.Duration) *treeStorage {
 // Register creates the key for tree so it is known
 func (ts *treeStorage) Register(id TreeID) {
 	ts.Lock()
+	defer ts.Unlock()
 	ts.trees[id] = nil
-	ts.Unlock()
+	ts.use(id)
+	ts.evict()
 }
 
 // Unregister makes sure the tree is either set or the key is removed
@@ -38,7 +85,7 @@ func (ts *treeStorage) Unregister(id TreeID) {
 	if tree := ts.trees[id]; tree == nil {
 		// if another goroutine set the tree inbetween, we keep the tree
 		// but if it is nil, we need to remove the key
-		delete(ts.trees, id)
+		ts.delete(id)
 	}
 }
 
@@ -56,7 +103,7 @@ func (ts *treeStorage) Get(id TreeID) *Tree {
 	ts.Lock()
 	defer ts.Unlock()
 
-	return ts.trees[id]
+	return ts.lookup(id)
 }
 
 // getAndRefresh cancels any pending remove and return the
@@ -67,7 +114,7 @@ func (ts *treeStorage) getAndRefresh(id TreeID) *Tree {
 
 	ts.cancelDeletion(id)
 
-	return ts.trees[id]
+	return ts.lookup(id)
 }
 
 // Set sets the given tree and cancel potential removal
@@ -78,9 +125,35 @@ func (ts *treeStorage) Set(tree *Tree) {
 	ts.cancelDeletion(tree.ID)
 
 	ts.trees[tree.ID] = tree
+	ts.use(tree.ID)
+	ts.evict()
+}
+
+// Pin keeps the tree while a protocol uses it.
+func (ts *treeStorage) Pin(id TreeID) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	ts.cancelDeletion(id)
+	ts.pins[id]++
+}
+
+// Unpin is called when a protocol using the tree is done, and starts the
+// timeout to remove it if it was the last one.
+func (ts *treeStorage) Unpin(id TreeID) {
+	ts.Lock()
+	if ts.pins[id] > 1 {
+		ts.pins[id]--
+		ts.Unlock()
+		return
+	}
+	delete(ts.pins, id)
+	ts.Unlock()
+	ts.Remove(id)
 }
 
-// Remove starts a timeout to remove the tree from the storage
+// Remove starts a timeout to remove the tree from the storage, if no
+// protocol uses it.
 func (ts *treeStorage) Remove(id TreeID) {
 	ts.Lock()
 	defer ts.Unlock()
@@ -90,6 +163,11 @@ func (ts *treeStorage) Remove(id TreeID) {
 		return
 	}
 
+	if ts.pins[id] > 0 {
+		// it will be removed when the protocols are done
+		return
+	}
+
 	_, ok := ts.cancellations[id]
 	if ok {
 		// already planned to be removed
@@ -110,8 +188,10 @@ func (ts *treeStorage) Remove(id TreeID) {
 		// after we're done locally and then it needs to be kept around for some time
 		case <-timer.C:
 			ts.Lock()
-			delete(ts.trees, id)
-			delete(ts.cancellations, id)
+			if ts.cancellations[id] == c {
+				ts.delete(id)
+				ts.stats.Expirations++
+			}
 			ts.Unlock()
 		case <-c:
 			timer.Stop()
@@ -120,13 +200,42 @@ func (ts *treeStorage) Remove(id TreeID) {
 	}()
 }
 
+// Invalidate removes the tree now, unless a protocol uses it, and returns
+// whether it was removed.
+func (ts *treeStorage) Invalidate(id TreeID) bool {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if _, ok := ts.trees[id]; !ok || ts.pins[id] > 0 {
+		return false
+	}
+	ts.delete(id)
+	return true
+}
+
+// InvalidateRoster removes the trees of the roster that no protocol uses,
+// and returns how many were removed.
+func (ts *treeStorage) InvalidateRoster(id RosterID) int {
+	ts.Lock()
+	defer ts.Unlock()
+
+	n := 0
+	for tid, tree := range ts.trees {
+		if tree != nil && tree.Roster.ID.Equal(id) && ts.pins[tid] == 0 {
+			ts.delete(tid)
+			n++
+		}
+	}
+	return n
+}
+
 // GetRoster looks for the roster in the list of trees or returns nil
 func (ts *treeStorage) GetRoster(id RosterID) *Roster {
 	ts.Lock()
 	defer ts.Unlock()
 
 	for _, tree := range ts.trees {
-		if tree.Roster.ID.Equal(id) {
+		if tree != nil && tree.Roster.ID.Equal(id) {
 			return tree.Roster
 		}
 	}
@@ -134,6 +243,32 @@ func (ts *treeStorage) GetRoster(id RosterID) *Roster {
 	return nil
 }
 
+// setConfig changes the limits of the storage. The zero values keep the
+// current ones.
+func (ts *treeStorage) setConfig(c TreeCacheConfig) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	if c.TTL > 0 {
+		ts.timeout = c.TTL
+	}
+	if c.MaxTrees != 0 {
+		ts.maxTrees = c.MaxTrees
+	}
+	ts.evict()
+}
+
+// getStats returns the metrics of the storage.
+func (ts *treeStorage) getStats() TreeCacheStats {
+	ts.Lock()
+	defer ts.Unlock()
+
+	stats := ts.stats
+	stats.Trees = len(ts.trees)
+	stats.Pinned = len(ts.pins)
+	return stats
+}
+
 // Close forces cleaning goroutines to be shutdown
 func (ts *treeStorage) Close() {
 	ts.Lock()
@@ -160,3 +295,51 @@ func (ts *treeStorage) cancelDeletion(id TreeID) {
 		delete(ts.cancellations, id)
 	}
 }
+
+// lookup returns the tree and counts the hit or the miss. It must be called
+// with the lock.
+func (ts *treeStorage) lookup(id TreeID) *Tree {
+	tree := ts.trees[id]
+	if tree == nil {
+		ts.stats.Misses++
+		return nil
+	}
+	ts.stats.Hits++
+	ts.use(id)
+	return tree
+}
+
+// use marks the tree as the last used. It must be called with the lock.
+func (ts *treeStorage) use(id TreeID) {
+	ts.uses++
+	ts.lastUse[id] = ts.uses
+}
+
+// delete removes the tree. It must be called with the lock.
+func (ts *treeStorage) delete(id TreeID) {
+	ts.cancelDeletion(id)
+	delete(ts.trees, id)
+	delete(ts.lastUse, id)
+}
+
+// evict removes the least recently used trees that no protocol uses, until
+// there are no more than maxTrees. It must be called with the lock.
+func (ts *treeStorage) evict() {
+	for ts.maxTrees > 0 && len(ts.trees) > ts.maxTrees {
+		var oldest TreeID
+		found := false
+		for id := range ts.trees {
+			if ts.pins[id] > 0 {
+				continue
+			}
+			if !found || ts.lastUse[id] < ts.lastUse[oldest] {
+				oldest, found = id, true
+			}
+		}
+		if !found {
+			return
+		}
+		ts.delete(oldest)
+		ts.stats.Evictions++
+	}
+}
@@ -160,3 +160,77 @@ func TestTreeStorage_Race(t *testing.T) {
 
 	checkLeakingGoroutines(t)
 }
+
+// Tests that the trees of the running protocols are kept
+func TestTreeStorage_Pin(t *testing.T) {
+	store := newTreeStorage(treeStoreTimeout)
+	defer store.Close()
+
+	tree := &Tree{ID: TreeID{1}, Roster: &Roster{ID: RosterID{1}}}
+	store.Set(tree)
+	store.Pin(tree.ID)
+	store.Pin(tree.ID)
+	require.Equal(t, 1, store.getStats().Pinned)
+
+	store.Remove(tree.ID)
+	require.False(t, store.Invalidate(tree.ID))
+	require.Equal(t, 0, store.InvalidateRoster(RosterID{1}))
+	store.Unpin(tree.ID)
+	time.Sleep(treeStoreTimeout + 50*time.Millisecond)
+	require.NotNil(t, store.Get(tree.ID))
+
+	// the last protocol starts the removal
+	store.Unpin(tree.ID)
+	require.Equal(t, 0, store.getStats().Pinned)
+	require.NotNil(t, store.Get(tree.ID))
+	time.Sleep(treeStoreTimeout + 50*time.Millisecond)
+	require.Nil(t, store.Get(tree.ID))
+	require.Equal(t, uint64(1), store.getStats().Expirations)
+}
+
+// Tests that the least recently used trees are removed first
+func TestTreeStorage_MaxTrees(t *testing.T) {
+	store := newTreeStorage(treeStoreTimeout)
+	defer store.Close()
+	store.setConfig(TreeCacheConfig{MaxTrees: 2})
+
+	trees := []*Tree{{ID: TreeID{1}}, {ID: TreeID{2}}, {ID: TreeID{3}}, {ID: TreeID{4}}}
+	store.Set(trees[0])
+	store.Set(trees[1])
+	store.Pin(trees[1].ID)
+	store.Get(trees[0].ID)
+	// the second tree is used by a protocol
+	store.Set(trees[2])
+	require.NotNil(t, store.Get(trees[1].ID))
+	require.Nil(t, store.Get(trees[0].ID))
+	store.Register(trees[3].ID)
+	require.False(t, store.IsRegistered(trees[2].ID))
+
+	stats := store.getStats()
+	require.Equal(t, TreeCacheStats{Trees: 2, Pinned: 1, Hits: 2, Misses: 1, Evictions: 2}, stats)
+
+	store.setConfig(TreeCacheConfig{MaxTrees: -1})
+	store.Set(trees[0])
+	store.Set(trees[2])
+	require.Equal(t, 4, store.getStats().Trees)
+}
+
+// Tests the explicit removal of the trees
+func TestTreeStorage_Invalidate(t *testing.T) {
+	store := newTreeStorage(treeStoreTimeout)
+	defer store.Close()
+
+	store.Set(&Tree{ID: TreeID{1}, Roster: &Roster{ID: RosterID{1}}})
+	store.Set(&Tree{ID: TreeID{2}, Roster: &Roster{ID: RosterID{1}}})
+	store.Set(&Tree{ID: TreeID{3}, Roster: &Roster{ID: RosterID{2}}})
+	store.Register(TreeID{4})
+	require.Nil(t, store.GetRoster(RosterID{3}))
+
+	store.Remove(TreeID{3})
+	require.True(t, store.Invalidate(TreeID{3}))
+	require.False(t, store.Invalidate(TreeID{3}))
+	require.Equal(t, 2, store.InvalidateRoster(RosterID{1}))
+	require.True(t, store.IsRegistered(TreeID{4}))
+	require.Equal(t, 1, store.getStats().Trees)
+	require.Empty(t, store.cancellations)
+}
@@ -0,0 +1,306 @@
+package onet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v4/log"
+	"golang.org/x/xerrors"
+)
+
+// UpgradeMember is a server of a rolling upgrade, reached on its websocket.
+type UpgradeMember struct {
+	// Name is how the member is reported, its URL if empty.
+	Name string
+	// URL is the base URL of the websocket, like "http://example.com:7771".
+	URL string
+	// Token is an admin token of the server, see Server.AddAdminToken.
+	Token string
+}
+
+func (m UpgradeMember) String() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.URL
+}
+
+// UpgradeConfig tells RollingUpgrade how to upgrade the members.
+type UpgradeConfig struct {
+	Members []UpgradeMember
+	// MaxDown is how many members may be down at the same time, counting
+	// the one being upgraded, by default the (n-1)/3 faults a byzantine
+	// protocol tolerates, but at least one.
+	MaxDown int
+	// DrainTimeout is how long the running protocols of a member have to
+	// finish when it shuts down, 30 seconds by default.
+	DrainTimeout time.Duration
+	// RejoinTimeout is how long a member may take to shut down, restart
+	// and be ready again, 5 minutes by default.
+	RejoinTimeout time.Duration
+	// Version, if set, is the version the members must run once they are
+	// back, as in BuildStatus.Version.
+	Version string
+	// Restart, if set, is called once a member shut down, to start it again
+	// with the new binary. Without it, the members must be restarted by
+	// their supervisor, like systemd or kubernetes.
+	Restart func(m UpgradeMember) error
+	// Progress, if set, is called at every step of the upgrade.
+	Progress func(UpgradeEvent)
+	// PollInterval is how often the members are asked whether they are
+	// back, a second by default.
+	PollInterval time.Duration
+	// Client is used to reach the members, a client with a timeout of 10
+	// seconds if nil.
+	Client *http.Client
+}
+
+// The steps of an UpgradeEvent.
+const (
+	UpgradeCheck   = "check"
+	UpgradeDrain   = "drain"
+	UpgradeRestart = "restart"
+	UpgradeRejoin  = "rejoin"
+	UpgradeDone    = "done"
+	UpgradeAbort   = "abort"
+)
+
+// UpgradeEvent is a step of a rolling upgrade for a member.
+type UpgradeEvent struct {
+	Time   time.Time
+	Member UpgradeMember
+	Step   string
+	// Down are the members which weren't ready when the step started.
+	Down []UpgradeMember
+	Err  error
+}
+
+func (ue UpgradeEvent) String() string {
+	s := fmt.Sprintf("%s %s: %s", ue.Time.Format(time.RFC3339), ue.Member, ue.Step)
+	if len(ue.Down) > 0 {
+		names := make([]string, len(ue.Down))
+		for i, m := range ue.Down {
+			names[i] = m.String()
+		}
+		s += " (down: " + strings.Join(names, ", ") + ")"
+	}
+	if ue.Err != nil {
+		s += ": " + ue.Err.Error()
+	}
+	return s
+}
+
+// RollingUpgrade restarts the members one after the other for a software
+// upgrade, so that the others keep the roster alive: it checks that not too
+// many members are down, shuts the member down with the admin API, letting
+// its protocols finish, waits for it to be restarted, by Restart or its
+// supervisor, and to be ready again and running the new Version, and only
+// then goes on with the next one. It stops at the first member that doesn't
+// come back, or when upgrading one more member would leave more than
+// MaxDown of them down. It returns the events of the upgrade.
+func RollingUpgrade(cfg UpgradeConfig) ([]UpgradeEvent, error) {
+	if len(cfg.Members) == 0 {
+		return nil, xerrors.New("no members to upgrade")
+	}
+	if cfg.MaxDown == 0 {
+		cfg.MaxDown = (len(cfg.Members) - 1) / 3
+		if cfg.MaxDown < 1 {
+			cfg.MaxDown = 1
+		}
+	}
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = adminDefaultTimeout
+	}
+	if cfg.RejoinTimeout == 0 {
+		cfg.RejoinTimeout = 5 * time.Minute
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	u := &upgrade{UpgradeConfig: cfg}
+	for _, m := range cfg.Members {
+		if err := u.member(m); err != nil {
+			u.event(m, UpgradeAbort, nil, err)
+			return u.events, xerrors.Errorf("upgrade of %s: %v", m, err)
+		}
+	}
+	return u.events, nil
+}
+
+// upgrade is a running RollingUpgrade.
+type upgrade struct {
+	UpgradeConfig
+	events []UpgradeEvent
+}
+
+func (u *upgrade) event(m UpgradeMember, step string, down []UpgradeMember, err error) {
+	ue := UpgradeEvent{Time: time.Now(), Member: m, Step: step, Down: down, Err: err}
+	log.Lvl2("upgrade:", ue)
+	u.events = append(u.events, ue)
+	if u.Progress != nil {
+		u.Progress(ue)
+	}
+}
+
+// member upgrades one member.
+func (u *upgrade) member(m UpgradeMember) error {
+	down := u.down()
+	upgrading := 1
+	for _, d := range down {
+		if d.URL == m.URL {
+			// it doesn't count twice
+			upgrading = 0
+		}
+	}
+	u.event(m, UpgradeCheck, down, nil)
+	if len(down)+upgrading > u.MaxDown {
+		return xerrors.Errorf("%d members are down, at most %d may be", len(down)+upgrading, u.MaxDown)
+	}
+
+	started := time.Now()
+	before, err := u.status(m)
+	if err != nil {
+		return xerrors.Errorf("status: %v", err)
+	}
+	if err := u.shutdown(m); err != nil {
+		return xerrors.Errorf("shutdown: %v", err)
+	}
+	u.event(m, UpgradeDrain, nil, nil)
+	deadline := time.Now().Add(u.RejoinTimeout)
+
+	if u.Restart != nil {
+		// the old process must be gone before the new one starts
+		for {
+			if _, err := u.status(m); err != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return xerrors.New("didn't shut down")
+			}
+			time.Sleep(u.PollInterval)
+		}
+		if err := u.Restart(m); err != nil {
+			return xerrors.Errorf("restart: %v", err)
+		}
+	}
+	u.event(m, UpgradeRestart, nil, nil)
+
+	for {
+		err := u.rejoined(m, before, started)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return xerrors.Errorf("didn't rejoin within %s: %v", u.RejoinTimeout, err)
+		}
+		time.Sleep(u.PollInterval)
+	}
+	u.event(m, UpgradeRejoin, nil, nil)
+	u.event(m, UpgradeDone, nil, nil)
+	return nil
+}
+
+// down returns the members which aren't ready, asking them at the same
+// time.
+func (u *upgrade) down() []UpgradeMember {
+	ready := make([]bool, len(u.Members))
+	var wg sync.WaitGroup
+	for i, m := range u.Members {
+		wg.Add(1)
+		go func(i int, m UpgradeMember) {
+			defer wg.Done()
+			ready[i] = u.ready(m) == nil
+		}(i, m)
+	}
+	wg.Wait()
+	var down []UpgradeMember
+	for i, m := range u.Members {
+		if !ready[i] {
+			down = append(down, m)
+		}
+	}
+	return down
+}
+
+// ready returns an error if the member doesn't answer ok on /readyz.
+func (u *upgrade) ready(m UpgradeMember) error {
+	resp, err := u.Client.Get(strings.TrimSuffix(m.URL, "/") + "/readyz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var report HealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return xerrors.Errorf("decoding: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("%s while %s", report.Status, report.Phase)
+	}
+	return nil
+}
+
+// status returns the status of the member from /status.
+func (u *upgrade) status(m UpgradeMember) (*DetailedStatus, error) {
+	resp, err := u.Client.Get(strings.TrimSuffix(m.URL, "/") + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("status %s", resp.Status)
+	}
+	st := &DetailedStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(st); err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	return st, nil
+}
+
+// shutdown asks the member to drain and close.
+func (u *upgrade) shutdown(m UpgradeMember) error {
+	body, err := json.Marshal(&adminRequest{Timeout: u.DrainTimeout.String()})
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(m.URL, "/")+"/admin/shutdown",
+		bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return xerrors.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// rejoined returns an error until the member runs a new process, is ready,
+// and runs the version of the upgrade.
+func (u *upgrade) rejoined(m UpgradeMember, before *DetailedStatus, started time.Time) error {
+	st, err := u.status(m)
+	if err != nil {
+		return err
+	}
+	// the new process started after the upgrade of the member
+	if st.Uptime >= time.Since(started) || st.Uptime >= before.Uptime {
+		return xerrors.New("not restarted yet")
+	}
+	if u.Version != "" && st.Build.Version != u.Version {
+		return xerrors.Errorf("runs version %s instead of %s", st.Build.Version, u.Version)
+	}
+	return u.ready(m)
+}
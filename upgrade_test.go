@@ -0,0 +1,94 @@
+package onet
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+)
+
+func upgradeMembers(t *testing.T, servers []*Server) []UpgradeMember {
+	var members []UpgradeMember
+	for i, s := range servers {
+		require.NoError(t, s.AddAdminToken("upgrade", "secret"))
+		port, err := strconv.Atoi(s.ServerIdentity.Address.Port())
+		require.NoError(t, err)
+		members = append(members, UpgradeMember{
+			Name:  strconv.Itoa(i),
+			URL:   "http://" + s.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1),
+			Token: "secret",
+		})
+	}
+	return members
+}
+
+// TestRollingUpgrade restarts the servers one after the other, checking that
+// the others are up while one is restarted.
+func TestRollingUpgrade(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(3, false)
+	members := upgradeMembers(t, servers)
+
+	var restarted []string
+	events, err := RollingUpgrade(UpgradeConfig{
+		Members:      members,
+		DrainTimeout: time.Second,
+		PollInterval: 50 * time.Millisecond,
+		Restart: func(m UpgradeMember) error {
+			i, _ := strconv.Atoi(m.Name)
+			for j := range servers {
+				if j != i {
+					require.False(t, local.order[j].Closed(), m.Name)
+				}
+			}
+			restarted = append(restarted, m.Name)
+			s, err := local.Restart(i)
+			if err != nil {
+				return err
+			}
+			return s.AddAdminToken("upgrade", "secret")
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "1", "2"}, restarted)
+	var steps []string
+	for _, e := range events[:5] {
+		require.Equal(t, "0", e.Member.Name)
+		steps = append(steps, e.Step)
+	}
+	require.Equal(t, []string{UpgradeCheck, UpgradeDrain, UpgradeRestart, UpgradeRejoin, UpgradeDone}, steps)
+	require.Equal(t, 15, len(events))
+}
+
+// TestRollingUpgrade_abort checks that the upgrade doesn't start if too
+// many servers are down, nor goes on with a server that doesn't come back.
+func TestRollingUpgrade_abort(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(4, false)
+	members := upgradeMembers(t, servers)
+
+	require.NoError(t, servers[3].Close())
+	events, err := RollingUpgrade(UpgradeConfig{Members: members, PollInterval: 50 * time.Millisecond})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 members are down, at most 1 may be")
+	require.Equal(t, 2, len(events))
+	require.Equal(t, UpgradeAbort, events[1].Step)
+	require.Equal(t, "3", events[0].Down[0].Name)
+
+	// the server isn't restarted
+	events, err = RollingUpgrade(UpgradeConfig{
+		Members:       members[:1],
+		DrainTimeout:  time.Second,
+		RejoinTimeout: 500 * time.Millisecond,
+		PollInterval:  50 * time.Millisecond,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "didn't rejoin")
+	require.Equal(t, UpgradeAbort, events[len(events)-1].Step)
+}
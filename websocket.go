@@ -4,10 +4,12 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v4/log"
 	"go.dedis.ch/onet/v4/network"
 	"go.dedis.ch/protobuf"
@@ -108,6 +111,29 @@ type WebSocket struct {
 	startstop chan bool
 	started   bool
 	TLSConfig *tls.Config // can only be modified before Start is called
+	// if set and returning true, client requests are refused
+	rejectClients func() bool
+	// if set, checks the credentials of the clients
+	authenticate func(r *http.Request, ws *websocket.Conn) (*ClientIdentity, error)
+	// if set, counts the requests of the clients and refuses the ones over
+	// the limits
+	limit func(r *http.Request) (func(), error)
+	// if set, serves the connection if it is for a session and returns true
+	openSession func(service, path string, r *http.Request, ws *websocket.Conn) bool
+	// if set, sends the invalidations of the cached replies of the service
+	watchCache func(service string, ws *websocket.Conn)
+	// the services whose clients may compress, with the size from which
+	// the replies are compressed
+	compression map[string]int
+	// the origins allowed, all of them if empty, and the trusted proxies,
+	// see Configure
+	origins []string
+	proxies []*net.IPNet
+	// if set, the Unix socket to listen on instead of the port
+	unixSocket string
+	unixMode   os.FileMode
+	// how the connections of the requests and the streams are kept alive
+	keepAliveConfig KeepAlive
 	sync.Mutex
 }
 
@@ -163,7 +189,7 @@ func NewWebSocket(si *network.ServerIdentity) *WebSocket {
 		Timeout: 100 * time.Millisecond,
 		Server: &http.Server{
 			Addr:    webHost,
-			Handler: w.mux,
+			Handler: http.HandlerFunc(w.serveHTTP),
 		},
 		NoSignalHandling: true,
 	}
@@ -183,12 +209,21 @@ func (w *WebSocket) start() {
 	w.Lock()
 	w.started = true
 	w.server.Server.TLSConfig = w.TLSConfig
-	log.Lvl2("Starting to listen on", w.server.Server.Addr)
+	unixSocket, unixMode := w.unixSocket, w.unixMode
+	if unixSocket != "" {
+		log.Lvl2("Starting to listen on", unixSocket)
+	} else {
+		log.Lvl2("Starting to listen on", w.server.Server.Addr)
+	}
 	started := make(chan bool)
 	go func() {
 		// Check if server is configured for TLS
 		started <- true
-		if w.server.Server.TLSConfig != nil && (w.server.TLSConfig.GetCertificate != nil || len(w.server.Server.TLSConfig.Certificates) >= 1) {
+		if unixSocket != "" {
+			if err := w.serveUnix(unixSocket, unixMode); err != nil {
+				log.Error("websocket:", err)
+			}
+		} else if w.server.Server.TLSConfig != nil && (w.server.TLSConfig.GetCertificate != nil || len(w.server.Server.TLSConfig.Certificates) >= 1) {
 			w.server.ListenAndServeTLS("", "")
 		} else {
 			w.server.ListenAndServe()
@@ -210,6 +245,7 @@ func (w *WebSocket) registerService(service string, s Service) error {
 	h := &wsHandler{
 		service:     s,
 		serviceName: service,
+		socket:      w,
 	}
 	w.mux.Handle(fmt.Sprintf("/%s/", service), h)
 	return nil
@@ -232,6 +268,7 @@ func (w *WebSocket) stop() {
 type wsHandler struct {
 	serviceName string
 	service     Service
+	socket      *WebSocket
 }
 
 // Wrapper-function so that http.Requests get 'upgraded' to websockets
@@ -245,51 +282,102 @@ func (t wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Lvl2("ws close", r.RemoteAddr, "n", n, "rx", rx, "tx", tx)
 	}()
 
+	// The mobile app on iOS doesn't support compression well, so it is
+	// only enabled for the services asking for it.
+	compress, minSize := t.socket.compressionFor(t.serviceName)
 	u := websocket.Upgrader{
-		// The mobile app on iOS doesn't support compression well...
-		EnableCompression: false,
+		EnableCompression: compress,
 		// As the website will not be served from ourselves, we
-		// need to accept _all_ origins. Cross-site scripting is
-		// required.
-		CheckOrigin: func(*http.Request) bool {
-			return true
+		// need to accept all the origins, unless they are restricted by
+		// the configuration.
+		CheckOrigin: func(r *http.Request) bool {
+			return t.socket == nil || t.socket.checkOrigin(r)
 		},
 	}
-	ws, err := u.Upgrade(w, r, http.Header{})
+	header := http.Header{}
+	if d, ok := t.service.(handlerDeprecations); ok {
+		path := strings.TrimPrefix(r.URL.Path, "/"+t.serviceName+"/")
+		if dep := d.handlerDeprecation(path); dep != nil {
+			log.Lvlf2("%s uses the deprecated %s/%s", r.RemoteAddr, t.serviceName, path)
+			dep.setHeaders(header)
+		}
+	}
+	ws, err := u.Upgrade(w, r, header)
 	if err != nil {
 		log.Error(err)
 		return
 	}
 	defer ws.Close()
+	// the requests are refused before they are read if they are too big
+	ws.SetReadLimit(int64(network.MaxPacketSize))
+	ka := t.socket.keepAlive()
+
+	if t.socket != nil && t.socket.authenticate != nil {
+		id, err := t.socket.authenticate(r, ws)
+		if err != nil {
+			log.Warnf("authentication of %s failed: %v", r.RemoteAddr, err)
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"),
+				time.Now().Add(time.Millisecond*500))
+			return
+		}
+		if id != nil {
+			r = withClientIdentity(r, id)
+		}
+	}
+
+	if t.socket != nil && t.socket.watchCache != nil &&
+		r.URL.Path == "/"+t.serviceName+"/"+CacheInvalidationPath {
+		t.socket.watchCache(t.serviceName, ws)
+		return
+	}
+
+	if t.socket != nil && t.socket.openSession != nil &&
+		t.socket.openSession(t.serviceName,
+			strings.TrimPrefix(r.URL.Path, "/"+t.serviceName+"/"), r, ws) {
+		return
+	}
+
+	stopPing := ka.watch(ws)
+	defer stopPing()
+	idle := ka.idleTimer(ws)
+	defer idle.pause()
 
 	// Loop for each message
 outerReadLoop:
 	for err == nil {
+		if err = ka.extendRead(ws); err != nil {
+			break
+		}
 		mt, buf, rerr := ws.ReadMessage()
 		if rerr != nil {
 			err = rerr
 			break
 		}
+		idle.pause()
 		rx += len(buf)
 		n++
 
-		s := t.service
 		var reply []byte
 		var tun *StreamingTunnel
 		path := strings.TrimPrefix(r.URL.Path, "/"+t.serviceName+"/")
 		log.Lvlf2("ws request from %s: %s/%s", r.RemoteAddr, t.serviceName, path)
-		reply, tun, err = s.ProcessClientRequest(r, path, buf)
+		reply, tun, err = t.process(r, path, buf)
+		if err == ErrDraining || err == errTooManyRequests {
+			break
+		}
 		if err == nil {
 			if tun == nil {
 				tx += len(reply)
-				if err = ws.SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+				if err = ka.extendWrite(ws); err != nil {
 					log.Error(err)
 					break
 				}
-				if err = ws.WriteMessage(mt, reply); err != nil {
+				if err = writeCompressed(ws, mt, reply, minSize); err != nil {
 					log.Error(err)
 					break
 				}
+				idle.touch()
 			} else {
 				closing := make(chan bool)
 				go func() {
@@ -311,21 +399,22 @@ outerReadLoop:
 						return
 					case reply, ok := <-tun.out:
 						if !ok {
-							err = xerrors.New("service finished streaming")
+							err = errStreamFinished
 							close(tun.close)
 							break outerReadLoop
 						}
 						tx += len(reply)
-						if err = ws.SetWriteDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+						if err = ka.extendWrite(ws); err != nil {
 							log.Error(err)
 							close(tun.close)
 							break outerReadLoop
 						}
-						if err = ws.WriteMessage(mt, reply); err != nil {
+						if err = writeCompressed(ws, mt, reply, minSize); err != nil {
 							log.Error(err)
 							close(tun.close)
 							break outerReadLoop
 						}
+						idle.touch()
 					}
 				}
 			}
@@ -338,13 +427,47 @@ outerReadLoop:
 	if err != nil {
 		errMessage += err.Error()
 	}
+	code := websocket.CloseProtocolError
+	switch err {
+	case errTooManyRequests:
+		code = closeTooManyRequests
+	case errStreamFinished:
+		code = websocket.CloseNormalClosure
+		errMessage = err.Error()
+	}
 
 	ws.WriteControl(websocket.CloseMessage,
-		websocket.FormatCloseMessage(websocket.CloseProtocolError, errMessage),
+		websocket.FormatCloseMessage(code, errMessage),
 		time.Now().Add(time.Millisecond*500))
 	return
 }
 
+func (t wsHandler) process(r *http.Request, path string, buf []byte) ([]byte, *StreamingTunnel, error) {
+	return t.socket.process(r, t.service, path, buf)
+}
+
+// process checks that the server accepts the request of the client and gives
+// it to the service. It is the entry point of the requests of the websocket
+// and of the gateways.
+func (w *WebSocket) process(r *http.Request, s Service, path string, buf []byte) ([]byte, *StreamingTunnel, error) {
+	if w != nil && w.rejectClients != nil && w.rejectClients() {
+		return nil, nil, ErrDraining
+	}
+	if w != nil && w.limit != nil {
+		release, err := w.limit(r)
+		if err != nil {
+			log.Warnf("refusing request of %s: %v", r.RemoteAddr, err)
+			return nil, nil, err
+		}
+		defer release()
+	}
+	return s.ProcessClientRequest(r, path, buf)
+}
+
+// errStreamFinished ends the connection of a streaming request once the
+// service closed its channel
+var errStreamFinished = xerrors.New("service finished streaming")
+
 type destination struct {
 	si   *network.ServerIdentity
 	path string
@@ -363,6 +486,27 @@ type Client struct {
 	keep bool
 	rx   uint64
 	tx   uint64
+	// credentials sent when opening a connection, see SetToken and
+	// SetPrivate
+	token   string
+	private kyber.Scalar
+	// the nodes used by SendProtobufRoster and SendProtobufHedged
+	roster *Roster
+	policy BalancePolicy
+	health map[network.ServerIdentityID]*NodeHealth
+	// the replies kept for the handlers given to CacheReplies
+	cache *clientCache
+	// whether to ask for compressed connections
+	compression bool
+	// if set, all the connections go to this Unix socket
+	unixSocket string
+	// the deprecated handlers the client used
+	deprecations map[string]Deprecation
+	// how the connections are kept alive, the pings they send, and when
+	// they were last used
+	keepAliveConfig KeepAlive
+	pingers         map[*websocket.Conn]func()
+	lastUsed        map[destination]time.Time
 	sync.Mutex
 }
 
@@ -374,6 +518,8 @@ func NewClient(suite network.Suite, s string) *Client {
 		connections:     make(map[destination]*websocket.Conn),
 		connectionsLock: make(map[destination]*sync.Mutex),
 		suite:           suite,
+		pingers:         make(map[*websocket.Conn]func()),
+		lastUsed:        make(map[destination]time.Time),
 	}
 }
 
@@ -399,6 +545,101 @@ func (c *Client) closeSingleUseConn(dst *network.ServerIdentity, path string) {
 	}
 }
 
+// dial opens a new connection to the path of the service on dst, with the
+// credentials of the client.
+func (c *Client) dial(dst *network.ServerIdentity, path string) (*websocket.Conn, error) {
+	var conn *websocket.Conn
+	var err error
+	d := &websocket.Dialer{}
+	d.TLSClientConfig = c.TLSClientConfig
+	c.Lock()
+	d.EnableCompression = c.compression
+	if c.unixSocket != "" {
+		unixSocket := c.unixSocket
+		d.NetDial = func(string, string) (net.Conn, error) {
+			return net.Dial("unix", unixSocket)
+		}
+	}
+	c.Unlock()
+
+	var serverURL string
+	var header http.Header
+
+	// If the URL is in the dst, then use it.
+	if dst.URL != "" {
+		u, err := url.Parse(dst.URL)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing url: %v", err)
+		}
+		if u.Scheme == "https" {
+			u.Scheme = "wss"
+		} else {
+			u.Scheme = "ws"
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		u.Path += c.service + "/" + path
+		serverURL = u.String()
+		header = http.Header{"Origin": []string{dst.URL}}
+	} else {
+		// Open connection to service.
+		hp, err := getWSHostPort(dst, false)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing port: %v", err)
+		}
+
+		var wsProtocol string
+		var protocol string
+
+		// The old hacky way of deciding if this server has HTTPS or not:
+		// the client somehow magically knows and tells onet by setting
+		// c.TLSClientConfig to a non-nil value.
+		if c.TLSClientConfig != nil {
+			wsProtocol = "wss"
+			protocol = "https"
+		} else {
+			wsProtocol = "ws"
+			protocol = "http"
+		}
+		serverURL = fmt.Sprintf("%s://%s/%s/%s", wsProtocol, hp, c.service, path)
+		header = http.Header{"Origin": []string{protocol + "://" + hp}}
+	}
+
+	c.Lock()
+	err = c.authHeader(header)
+	challenge := c.token == "" && c.private != nil
+	c.Unlock()
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: %v", err)
+	}
+
+	// Re-try to connect in case the websocket is just about to start
+	var resp *http.Response
+	for a := 0; a < network.MaxRetryConnect; a++ {
+		conn, resp, err = d.Dial(serverURL, header)
+		if err == nil {
+			break
+		}
+		time.Sleep(network.WaitRetry)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("dial: %v", err)
+	}
+	if dep := deprecationFromHeader(resp.Header); dep != nil {
+		c.deprecated(path, *dep)
+	}
+	if challenge {
+		// the server verifies the signature on the path it was dialed on
+		u, _ := url.Parse(serverURL)
+		if err := c.answerChallenge(conn, u.Path); err != nil {
+			conn.Close()
+			return nil, xerrors.Errorf("authentication: %v", err)
+		}
+	}
+	return conn, nil
+}
+
 func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*websocket.Conn, *sync.Mutex, error) {
 	var err error
 
@@ -419,72 +660,25 @@ func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*w
 	connLock.Lock()
 	c.Lock()
 	conn, connected := c.connections[dest]
+	ka := c.keepAlive()
+	if connected && ka.IdleTimeout > 0 && time.Since(c.lastUsed[dest]) > ka.IdleTimeout {
+		// a proxy might have dropped it without telling
+		log.Lvlf3("dialing %s/%s again after %s idle", dst, path, time.Since(c.lastUsed[dest]))
+		c.closeConn(dest)
+		connected = false
+	}
 	c.Unlock()
 
 	if !connected {
-		d := &websocket.Dialer{}
-		d.TLSClientConfig = c.TLSClientConfig
-
-		var serverURL string
-		var header http.Header
-
-		// If the URL is in the dst, then use it.
-		if dst.URL != "" {
-			u, err := url.Parse(dst.URL)
-			if err != nil {
-				connLock.Unlock()
-				return nil, nil, xerrors.Errorf("parsing url: %v", err)
-			}
-			if u.Scheme == "https" {
-				u.Scheme = "wss"
-			} else {
-				u.Scheme = "ws"
-			}
-			if !strings.HasSuffix(u.Path, "/") {
-				u.Path += "/"
-			}
-			u.Path += c.service + "/" + path
-			serverURL = u.String()
-			header = http.Header{"Origin": []string{dst.URL}}
-		} else {
-			// Open connection to service.
-			hp, err := getWSHostPort(dst, false)
-			if err != nil {
-				connLock.Unlock()
-				return nil, nil, xerrors.Errorf("parsing port: %v", err)
-			}
-
-			var wsProtocol string
-			var protocol string
-
-			// The old hacky way of deciding if this server has HTTPS or not:
-			// the client somehow magically knows and tells onet by setting
-			// c.TLSClientConfig to a non-nil value.
-			if c.TLSClientConfig != nil {
-				wsProtocol = "wss"
-				protocol = "https"
-			} else {
-				wsProtocol = "ws"
-				protocol = "http"
-			}
-			serverURL = fmt.Sprintf("%s://%s/%s/%s", wsProtocol, hp, c.service, path)
-			header = http.Header{"Origin": []string{protocol + "://" + hp}}
-		}
-
-		// Re-try to connect in case the websocket is just about to start
-		for a := 0; a < network.MaxRetryConnect; a++ {
-			conn, _, err = d.Dial(serverURL, header)
-			if err == nil {
-				break
-			}
-			time.Sleep(network.WaitRetry)
-		}
+		conn, err = c.dial(dst, path)
 		if err != nil {
 			connLock.Unlock()
-			return nil, nil, xerrors.Errorf("dial: %v", err)
+			return nil, nil, err
 		}
 		c.Lock()
 		c.connections[dest] = conn
+		c.pingers[conn] = ka.watch(conn)
+		c.lastUsed[dest] = time.Now()
 		c.Unlock()
 	}
 	return conn, connLock, nil
@@ -495,6 +689,14 @@ func (c *Client) newConnIfNotExist(dst *network.ServerIdentity, path string) (*w
 // idle connection, the message is sent right away. If the current connection is busy,
 // it waits for it to be free.
 func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]byte, error) {
+	c.Lock()
+	cache := c.cache
+	c.Unlock()
+	if reply, ok := cache.lookup(dst, path, buf); ok {
+		log.Lvlf4("Cached reply to %s/%s", c.service, path)
+		return reply, nil
+	}
+
 	conn, connLock, err := c.newConnIfNotExist(dst, path)
 	if err != nil {
 		return nil, xerrors.Errorf("new connection: %v", err)
@@ -502,8 +704,12 @@ func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]b
 	defer connLock.Unlock()
 
 	var rcv []byte
+	c.Lock()
+	ka := c.keepAlive()
+	c.Unlock()
 	defer func() {
 		c.Lock()
+		c.lastUsed[destination{dst, path}] = time.Now()
 		c.closeSingleUseConn(dst, path)
 		c.rx += uint64(len(rcv))
 		c.tx += uint64(len(buf))
@@ -511,11 +717,14 @@ func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]b
 	}()
 
 	log.Lvlf4("Sending %x to %s/%s", buf, c.service, path)
+	if err := ka.extendWrite(conn); err != nil {
+		return nil, xerrors.Errorf("write deadline: %v", err)
+	}
 	if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
 		return nil, xerrors.Errorf("connection write: %v", err)
 	}
 
-	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+	if err := ka.extendRead(conn); err != nil {
 		return nil, xerrors.Errorf("read deadline: %v", err)
 	}
 	_, rcv, err = conn.ReadMessage()
@@ -523,6 +732,7 @@ func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]b
 		return nil, xerrors.Errorf("connection read: %v", err)
 	}
 	log.Lvlf4("Received %x", rcv)
+	cache.store(c, dst, path, buf, rcv)
 	return rcv, nil
 }
 
@@ -533,17 +743,20 @@ func (c *Client) Send(dst *network.ServerIdentity, path string, buf []byte) ([]b
 // client. If there is no error, the ret-structure is filled with the
 // data from the service.
 func (c *Client) SendProtobuf(dst *network.ServerIdentity, msg interface{}, ret interface{}) error {
+	return c.sendProtobuf(dst, strings.Split(reflect.TypeOf(msg).String(), ".")[1], msg, ret)
+}
+
+func (c *Client) sendProtobuf(dst *network.ServerIdentity, path string, msg interface{}, ret interface{}) error {
 	buf, err := protobuf.Encode(msg)
 	if err != nil {
 		return xerrors.Errorf("encoding: %v", err)
 	}
-	path := strings.Split(reflect.TypeOf(msg).String(), ".")[1]
 	reply, err := c.Send(dst, path, buf)
 	if err != nil {
 		return xerrors.Errorf("sending: %v", err)
 	}
 	if ret != nil {
-		err := protobuf.DecodeWithConstructors(reply, ret, network.DefaultConstructors(c.suite))
+		err := network.Decode(reply, ret, c.suite)
 		if err != nil {
 			return xerrors.Errorf("decoding: %v", err)
 		}
@@ -744,29 +957,51 @@ func (c *Client) SendProtobufParallel(nodes []*network.ServerIdentity, msg inter
 // StreamingConn allows clients to read from it without sending additional
 // requests.
 type StreamingConn struct {
-	conn  *websocket.Conn
-	suite network.Suite
+	conn   *websocket.Conn
+	suite  network.Suite
+	client *Client
+	dest   destination
+	// the request of the stream, sent again when it reconnects
+	request   []byte
+	keepAlive KeepAlive
 }
 
 // ReadMessage read more data from the connection, it will block if there are
-// no messages.
+// no messages. It returns io.EOF once the service closed its channel. If the
+// connection breaks, it reconnects as many times as the KeepAlive of the
+// client allows.
 func (c *StreamingConn) ReadMessage(ret interface{}) error {
-	if err := c.conn.SetReadDeadline(time.Now().Add(5 * time.Minute)); err != nil {
-		return xerrors.Errorf("read deadline: %v", err)
-	}
-	// No need to add bytes to counter here because this function is only
-	// called by the client.
-	_, buf, err := c.conn.ReadMessage()
-	if err != nil {
-		return xerrors.Errorf("connection read: %v", err)
+	buf, err := c.read()
+	for attempt := 0; err != nil; attempt++ {
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			c.Close()
+			return io.EOF
+		}
+		if attempt >= c.keepAlive.Reconnect || !brokenStream(err) {
+			return xerrors.Errorf("connection read: %v", err)
+		}
+		log.Lvlf2("stream %s to %s broke, reconnecting: %v", c.dest.path, c.dest.si, err)
+		if err = c.reconnect(); err == nil {
+			buf, err = c.read()
+		}
 	}
-	err = protobuf.DecodeWithConstructors(buf, ret, network.DefaultConstructors(c.suite))
+	err = network.Decode(buf, ret, c.suite)
 	if err != nil {
 		return xerrors.Errorf("decoding: %v", err)
 	}
 	return nil
 }
 
+func (c *StreamingConn) read() ([]byte, error) {
+	if err := c.keepAlive.extendRead(c.conn); err != nil {
+		return nil, xerrors.Errorf("read deadline: %v", err)
+	}
+	// No need to add bytes to counter here because this function is only
+	// called by the client.
+	_, buf, err := c.conn.ReadMessage()
+	return buf, err
+}
+
 // Stream will send a request to start streaming, it returns a connection where
 // the client can continue to read values from it.
 func (c *Client) Stream(dst *network.ServerIdentity, msg interface{}) (StreamingConn, error) {
@@ -781,6 +1016,12 @@ func (c *Client) Stream(dst *network.ServerIdentity, msg interface{}) (Streaming
 		return StreamingConn{}, err
 	}
 	defer connLock.Unlock()
+	c.Lock()
+	ka := c.keepAlive()
+	c.Unlock()
+	if err := ka.extendWrite(conn); err != nil {
+		return StreamingConn{}, err
+	}
 	err = conn.WriteMessage(websocket.BinaryMessage, buf)
 	if err != nil {
 		return StreamingConn{}, err
@@ -788,7 +1029,34 @@ func (c *Client) Stream(dst *network.ServerIdentity, msg interface{}) (Streaming
 	c.Lock()
 	c.tx += uint64(len(buf))
 	c.Unlock()
-	return StreamingConn{conn, c.Suite()}, nil
+	return StreamingConn{
+		conn:      conn,
+		suite:     c.Suite(),
+		client:    c,
+		dest:      destination{dst, path},
+		request:   buf,
+		keepAlive: ka,
+	}, nil
+}
+
+// Close stops the stream: the service is told to stop sending, and the
+// connection is removed from the client.
+func (c *StreamingConn) Close() error {
+	if c.client == nil {
+		return c.conn.Close()
+	}
+	c.client.Lock()
+	connLock := c.client.connectionsLock[c.dest]
+	c.client.Unlock()
+	connLock.Lock()
+	defer connLock.Unlock()
+	c.client.Lock()
+	defer c.client.Unlock()
+	if c.client.connections[c.dest] != c.conn {
+		// already closed
+		return nil
+	}
+	return c.client.closeConn(c.dest)
 }
 
 // SendToAll sends a message to all ServerIdentities of the Roster and returns
@@ -815,6 +1083,7 @@ func (c *Client) SendToAll(dst *Roster, path string, buf []byte) ([][]byte, erro
 func (c *Client) Close() error {
 	c.Lock()
 	defer c.Unlock()
+	c.cache.close()
 	var errstrs []string
 	for dest := range c.connections {
 		connLock := c.connectionsLock[dest]
@@ -839,6 +1108,8 @@ func (c *Client) closeConn(dst destination) error {
 	conn, ok := c.connections[dst]
 	if ok {
 		delete(c.connections, dst)
+		delete(c.lastUsed, dst)
+		c.stopPing(conn)
 		err := conn.WriteMessage(websocket.CloseMessage,
 			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "client closed"))
 		if err != nil {
@@ -9,6 +9,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
@@ -150,6 +151,7 @@ func TestNewWebSocket(t *testing.T) {
 	require.Equal(t, len(c.serviceManager.services), len(c.WebSocket.services))
 	require.NotEmpty(t, c.WebSocket.services[serviceWebSocket])
 	cl := NewClientKeep(tSuite, "WebSocket")
+	defer cl.Close()
 	req := &SimpleResponse{}
 	log.Lvlf1("Sending message Request: %x", uuid.UUID(network.MessageType(req)).Bytes())
 	buf, err := protobuf.Encode(req)
@@ -479,6 +481,7 @@ func TestMultiplePath(t *testing.T) {
 	server := hs[0]
 	defer local.CloseAll()
 	client := NewClientKeep(tSuite, dummyService3Name)
+	defer client.Close()
 	msg, err := protobuf.Encode(&DummyMsg{})
 	require.Nil(t, err)
 	path1, path2 := "path1", "path2"
@@ -510,6 +513,7 @@ func TestMultiplePathTLS(t *testing.T) {
 	server := hs[0]
 	defer local.CloseAll()
 	client := NewClientKeep(tSuite, dummyService3Name)
+	defer client.Close()
 	client.TLSClientConfig = &tls.Config{RootCAs: CAPool}
 	msg, err := protobuf.Encode(&DummyMsg{})
 	require.Nil(t, err)
@@ -528,6 +532,7 @@ func TestWebSocket_Error(t *testing.T) {
 	hs := local.GenServers(2)
 	server := hs[0]
 	defer local.CloseAll()
+	defer client.Close()
 
 	lvl := log.DebugVisible()
 	log.SetDebugVisible(0)
@@ -553,6 +558,7 @@ func TestWebSocketTLS_Error(t *testing.T) {
 	hs := local.GenServers(2)
 	server := hs[0]
 	defer local.CloseAll()
+	defer client.Close()
 
 	lvl := log.DebugVisible()
 	log.SetDebugVisible(0)
@@ -643,6 +649,45 @@ func TestWebSocket_Streaming(t *testing.T) {
 	require.NoError(t, client.Close())
 }
 
+func TestWebSocket_StreamingEOF(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+
+	serName := "streamingService"
+	serID, err := RegisterNewService(serName, newStreamingService)
+	require.NoError(t, err)
+	defer UnregisterService(serName)
+
+	servers, el, _ := local.GenTree(2, false)
+	client := local.NewClientKeep(serName)
+	defer client.Close()
+
+	n := 3
+	r := &SimpleRequest{
+		ServerIdentities: el,
+		Val:              int64(n),
+	}
+	conn, err := client.Stream(servers[0].ServerIdentity, r)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, conn.ReadMessage(&SimpleResponse{}))
+	}
+	require.Equal(t, io.EOF, conn.ReadMessage(&SimpleResponse{}))
+
+	// the client can stream again, on a new connection
+	serviceRoot := local.GetServices(servers, serID)[0].(*StreamingService)
+	serviceRoot.gotStopChan = make(chan bool, 1)
+	conn, err = client.Stream(servers[0].ServerIdentity, r)
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadMessage(&SimpleResponse{}))
+	require.NoError(t, conn.Close())
+	select {
+	case <-serviceRoot.gotStopChan:
+	case <-time.After(time.Second):
+		require.Fail(t, "should have got an early finish signal")
+	}
+}
+
 // TestWebSocket_Streaming_Parallel is essentially the same as
 // TestWebSocket_Streaming, except we do it in parallel.
 func TestWebSocket_Streaming_Parallel(t *testing.T) {
@@ -0,0 +1,219 @@
+package onet
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// WebSocketConfig is the configuration of the listener of the clients, for
+// a conode behind a reverse proxy or serving web pages of other origins.
+type WebSocketConfig struct {
+	// ListenAddress is where the websocket listens, like "127.0.0.1:7771".
+	// By default it listens on all the interfaces, one port above the
+	// address of the server.
+	ListenAddress string
+	// LocalOnly makes the websocket listen on the loopback interface only,
+	// for the clients running on the same host.
+	LocalOnly bool
+	// UnixSocket, if set, is the path of the Unix socket the websocket
+	// listens on instead of a port. The clients need Client.SetUnixSocket
+	// to reach it, and the access is given by the permissions of the file.
+	UnixSocket string
+	// UnixSocketMode are the permissions of the Unix socket, 0600 if zero.
+	UnixSocketMode os.FileMode
+	// AllowedOrigins are the origins of the web pages that may use the
+	// websocket and the gateway, like "https://example.com". By default, or
+	// if it contains "*", all the origins are allowed. The clients that
+	// don't send an origin, which are not browsers, are always allowed.
+	AllowedOrigins []string
+	// TrustedProxies are the IP addresses or the CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header gives the address of the clients,
+	// which is then used for the limits and the logs of the requests.
+	TrustedProxies []string
+	// KeepAlive tells how the connections of the clients are kept alive,
+	// and when they are closed.
+	KeepAlive KeepAlive
+}
+
+// Configure sets the configuration of the websocket. Like the TLSConfig,
+// the listen address can only be set before the server is started.
+func (w *WebSocket) Configure(cfg WebSocketConfig) error {
+	var proxies []*net.IPNet
+	for _, p := range cfg.TrustedProxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return xerrors.New("invalid proxy address: " + p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return xerrors.Errorf("invalid proxy range: %v", err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	if err := cfg.KeepAlive.check(); err != nil {
+		return err
+	}
+	if cfg.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.ListenAddress); err != nil {
+			return xerrors.Errorf("invalid listen address: %v", err)
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if cfg.ListenAddress != "" || cfg.LocalOnly || cfg.UnixSocket != "" {
+		if w.started {
+			return xerrors.New("websocket already started")
+		}
+		if cfg.ListenAddress != "" {
+			w.server.Server.Addr = cfg.ListenAddress
+		}
+		if cfg.LocalOnly {
+			_, port, err := net.SplitHostPort(w.server.Server.Addr)
+			if err != nil {
+				return xerrors.Errorf("listen address: %v", err)
+			}
+			w.server.Server.Addr = net.JoinHostPort("127.0.0.1", port)
+		}
+		w.unixSocket = cfg.UnixSocket
+		w.unixMode = cfg.UnixSocketMode
+		if w.unixMode == 0 {
+			w.unixMode = 0600
+		}
+	}
+	w.origins = nil
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			w.origins = nil
+			break
+		}
+		w.origins = append(w.origins, strings.TrimSuffix(o, "/"))
+	}
+	w.proxies = proxies
+	w.keepAliveConfig = cfg.KeepAlive
+	return nil
+}
+
+// checkOrigin returns true if the page the request comes from may use the
+// websocket.
+func (w *WebSocket) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	w.Lock()
+	defer w.Unlock()
+	if origin == "" || len(w.origins) == 0 {
+		return true
+	}
+	// the onet clients give the address of the conode as origin
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range w.origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxy returns true if the address is one of a trusted proxy.
+func (w *WebSocket) trustedProxy(ip net.IP) bool {
+	for _, p := range w.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns the address of the client, which is the last one
+// in X-Forwarded-For that isn't a trusted proxy if the request comes from
+// one.
+func (w *WebSocket) clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	w.Lock()
+	defer w.Unlock()
+	if ip := net.ParseIP(host); ip == nil || !w.trustedProxy(ip) {
+		return r.RemoteAddr
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		if !w.trustedProxy(ip) {
+			return net.JoinHostPort(ip.String(), "0")
+		}
+	}
+	return r.RemoteAddr
+}
+
+// serveHTTP replaces the address of the requests coming through a trusted
+// proxy, and answers the CORS requests of the allowed origins, before
+// handing the request to the services. The backups are never shared with
+// the other origins.
+func (w *WebSocket) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	r.RemoteAddr = w.clientAddress(r)
+	if origin := r.Header.Get("Origin"); origin != "" && r.URL.Path != "/backup" &&
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		if !w.checkOrigin(r) {
+			http.Error(rw, wrapJSONMsg("origin not allowed"), http.StatusForbidden)
+			return
+		}
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		rw.Header().Add("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	w.mux.ServeHTTP(rw, r)
+}
+
+// serveUnix serves the clients on the Unix socket, replacing the file of a
+// previous run.
+func (w *WebSocket) serveUnix(path string, mode os.FileMode) error {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return xerrors.New("not a socket: " + path)
+		}
+		if err := os.Remove(path); err != nil {
+			return xerrors.Errorf("removing old socket: %v", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return xerrors.Errorf("listening: %v", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return xerrors.Errorf("permissions: %v", err)
+	}
+	return w.server.Serve(ln)
+}
+
+// SetUnixSocket makes the client reach the nodes through the Unix socket at
+// path, for a conode listening on it with WebSocketConfig.UnixSocket.
+func (c *Client) SetUnixSocket(path string) {
+	c.Lock()
+	defer c.Unlock()
+	c.unixSocket = path
+}
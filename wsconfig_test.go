@@ -0,0 +1,131 @@
+package onet
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v4/log"
+)
+
+func TestWebSocket_ClientAddress(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	w := local.GenServers(1)[0].WebSocket
+
+	require.Error(t, w.Configure(WebSocketConfig{TrustedProxies: []string{"nope"}}))
+	require.Error(t, w.Configure(WebSocketConfig{TrustedProxies: []string{"10.0.0.0/99"}}))
+	// the server is already started
+	require.Error(t, w.Configure(WebSocketConfig{ListenAddress: "127.0.0.1:0"}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+	require.Equal(t, "127.0.0.1:1234", w.clientAddress(r))
+
+	require.NoError(t, w.Configure(WebSocketConfig{TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"}}))
+	require.Equal(t, "1.2.3.4:0", w.clientAddress(r))
+	r.Header.Set("X-Forwarded-For", "garbage")
+	require.Equal(t, "127.0.0.1:1234", w.clientAddress(r))
+	r.RemoteAddr = "192.168.0.1:1234"
+	require.Equal(t, "192.168.0.1:1234", w.clientAddress(r))
+}
+
+func TestWebSocket_AllowedOrigins(t *testing.T) {
+	log.AddUserUninterestingGoroutine("created by net/http.(*Transport).dialConn")
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.GenServers(1)[0]
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{
+		AllowedOrigins: []string{"https://good.example"},
+	}))
+
+	port, err := strconv.Atoi(h.ServerIdentity.Address.Port())
+	require.NoError(t, err)
+	hp := h.ServerIdentity.Address.Host() + ":" + strconv.Itoa(port+1)
+	dial := func(origin string) error {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+hp+"/"+testServiceName+"/testMsg",
+			http.Header{"Origin": []string{origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	require.NoError(t, dial("https://good.example"))
+	require.NoError(t, dial("http://"+hp))
+	require.Error(t, dial("https://evil.example"))
+
+	// the onet clients are always allowed
+	cl := local.NewClient(testServiceName)
+	require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &testMsg{I: 1}, nil))
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest("OPTIONS", "http://"+hp+"/v4/"+testServiceName+"/testMsg", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	resp := preflight("https://good.example")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, "https://good.example", resp.Header.Get("Access-Control-Allow-Origin"))
+	require.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "Authorization")
+	resp = preflight("https://evil.example")
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestWebSocket_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onet-unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conode.sock")
+
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.newTCPServer(tSuite)
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{UnixSocket: path}))
+	h.StartInBackground()
+	require.Error(t, h.WebSocket.Configure(WebSocketConfig{UnixSocket: path}))
+
+	var fi os.FileInfo
+	for i := 0; i < 100; i++ {
+		if fi, err = os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	cl := local.NewClient(testServiceName)
+	cl.SetUnixSocket(path)
+	reply := &testMsg{}
+	require.NoError(t, cl.SendProtobuf(h.ServerIdentity, &testMsg{I: 3}, reply))
+	require.Equal(t, int64(3), reply.I)
+	require.NoError(t, cl.Close())
+
+	// nothing listens on the port
+	cl = local.NewClient(testServiceName)
+	require.Error(t, cl.SendProtobuf(h.ServerIdentity, &testMsg{I: 3}, reply))
+}
+
+func TestWebSocket_LocalOnly(t *testing.T) {
+	local := NewTCPTest(tSuite)
+	defer local.CloseAll()
+	h := local.newTCPServer(tSuite)
+	require.NoError(t, h.WebSocket.Configure(WebSocketConfig{
+		ListenAddress: "0.0.0.0:1234",
+		LocalOnly:     true,
+	}))
+	require.Equal(t, "127.0.0.1:1234", h.WebSocket.server.Server.Addr)
+}